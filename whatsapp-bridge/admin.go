@@ -0,0 +1,394 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/mdp/qrterminal"
+)
+
+// How long re-pair waits for the QR code to be scanned, about as long as the bridge keeps pairing
+const adminPairTimeout = 3 * time.Minute
+
+func main() {
+	if len(os.Args) < 2 {
+		printAdminUsage()
+		os.Exit(1)
+	}
+
+	var err error
+	command, args := os.Args[1], os.Args[2:]
+	switch command {
+	case "status":
+		err = runAdminStatus()
+	case "chats":
+		err = runAdminChats(args)
+	case "logout":
+		err = runAdminLogout(args)
+	case "re-pair", "pair":
+		err = runAdminPair(args)
+	case "help", "--help", "-h":
+		printAdminUsage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
+		printAdminUsage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printAdminUsage() {
+	fmt.Println(`WhatsApp session administration
+
+USAGE:
+    admin status
+    admin chats [--query TEXT] [--groups | --direct] [--limit N]
+    admin logout --yes
+    admin re-pair [--yes]
+
+status   shows the session and connection of the running bridge, or the stored session when it isn't running
+chats    lists chats with their JIDs, most recently active first, e.g. to find a group's JID for the config
+logout   unlinks this device from WhatsApp; the bridge then shows a new QR code to pair again
+re-pair  shows the QR code to scan in the terminal, logging out first when a session exists (needs --yes)
+
+logout and re-pair go through the running bridge at BRIDGE_API_URL, with BRIDGE_API_TOKEN (an admin token)
+when the bridge runs with BRIDGE_API_AUTH=required.`)
+}
+
+// getBridgeBaseURL returns the bridge's address without the /api prefix, for /health and /login
+func getBridgeBaseURL() string {
+	return strings.TrimSuffix(getBridgeAPIURL(), "/api")
+}
+
+// callBridgeAdmin sends a request to the bridge and decodes a JSON answer into out (if not nil). Redirects,
+// which the login actions answer with, count as success.
+func callBridgeAdmin(method, path string, out interface{}) (int, error) {
+	req, err := http.NewRequest(method, getBridgeBaseURL()+path, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error creating request: %v", err)
+	}
+	setBridgeAPIAuth(req.Header)
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errBridgeUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, fmt.Errorf("error reading response: %v", err)
+	}
+	if out != nil && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(body, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("error parsing response: %v", err)
+		}
+		return resp.StatusCode, nil
+	}
+	if resp.StatusCode >= 400 {
+		return resp.StatusCode, fmt.Errorf("bridge returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp.StatusCode, nil
+}
+
+// adminLoginStatus is the bridge's /api/login answer
+type adminLoginStatus struct {
+	State    string `json:"state"`
+	JID      string `json:"jid"`
+	PushName string `json:"push_name"`
+	QRCode   string `json:"qr_code"`
+	Error    string `json:"error"`
+}
+
+// getAdminLoginStatus returns the session state of the running bridge
+func getAdminLoginStatus() (adminLoginStatus, error) {
+	var status adminLoginStatus
+	code, err := callBridgeAdmin(http.MethodGet, "/api/login", &status)
+	if err == nil && code >= 400 {
+		err = fmt.Errorf("bridge returned HTTP %d", code)
+	}
+	return status, err
+}
+
+func runAdminStatus() error {
+	var health struct {
+		Status   string `json:"status"`
+		WhatsApp struct {
+			State             string     `json:"state"`
+			Since             time.Time  `json:"since"`
+			ReconnectAttempts int        `json:"reconnect_attempts"`
+			Reconnects        int        `json:"reconnects"`
+			LastError         string     `json:"last_error"`
+			LastMessageAt     *time.Time `json:"last_message_at"`
+		} `json:"whatsapp"`
+		Databases []struct {
+			Name  string `json:"name"`
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		} `json:"databases"`
+		SafeMode bool `json:"safe_mode"`
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if _, err := callBridgeAdmin(http.MethodGet, "/health", &health); err != nil {
+		fmt.Fprintf(writer, "Bridge:\tnot reachable at %s (%v)\n", getBridgeBaseURL(), err)
+		if jid, pushName, err := storedSession(); err != nil {
+			fmt.Fprintf(writer, "Session:\tunknown (%v)\n", err)
+		} else if jid == "" {
+			fmt.Fprintf(writer, "Session:\tnone; start the bridge and pair it\n")
+		} else {
+			fmt.Fprintf(writer, "Session:\t%s (%s), stored\n", jid, orDash(pushName))
+		}
+	} else {
+		fmt.Fprintf(writer, "Bridge:\t%s\n", health.Status)
+		fmt.Fprintf(writer, "Connection:\t%s since %s\n", health.WhatsApp.State, health.WhatsApp.Since.Local().Format(time.DateTime))
+		if status, err := getAdminLoginStatus(); err != nil {
+			fmt.Fprintf(writer, "Session:\tunknown (%v)\n", err)
+		} else if status.JID != "" {
+			fmt.Fprintf(writer, "Session:\t%s (%s)\n", status.JID, orDash(status.PushName))
+		} else {
+			fmt.Fprintf(writer, "Session:\tnone (%s)\n", status.State)
+		}
+		fmt.Fprintf(writer, "Reconnects:\t%d since start, %d attempts now\n", health.WhatsApp.Reconnects, health.WhatsApp.ReconnectAttempts)
+		if health.WhatsApp.LastError != "" {
+			fmt.Fprintf(writer, "Last error:\t%s\n", health.WhatsApp.LastError)
+		}
+		if health.WhatsApp.LastMessageAt != nil {
+			fmt.Fprintf(writer, "Last message:\t%s\n", health.WhatsApp.LastMessageAt.Local().Format(time.DateTime))
+		}
+		for _, db := range health.Databases {
+			state := "ok"
+			if !db.OK {
+				state = "failing: " + db.Error
+			}
+			fmt.Fprintf(writer, "Database %s:\t%s\n", db.Name, state)
+		}
+		fmt.Fprintf(writer, "Safe mode:\t%v\n", health.SafeMode)
+	}
+
+	if chats, messages, last, err := messageStoreStats(); err == nil {
+		lastMessage := "-"
+		if last != "" {
+			lastMessage = last
+		}
+		fmt.Fprintf(writer, "Stored:\t%d chats, %d messages, last at %s\n", chats, messages, lastMessage)
+	}
+	return writer.Flush()
+}
+
+// storedSession returns the account of the session in store/whatsapp.db, "" when there is none
+func storedSession() (string, string, error) {
+	if _, err := os.Stat("store/whatsapp.db"); err != nil {
+		return "", "", nil
+	}
+	db, err := sql.Open("sqlite3", "file:store/whatsapp.db?mode=ro&_busy_timeout=5000")
+	if err != nil {
+		return "", "", err
+	}
+	defer db.Close()
+	var jid, pushName string
+	err = db.QueryRow("SELECT jid, COALESCE(push_name, '') FROM whatsmeow_device LIMIT 1").Scan(&jid, &pushName)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	return jid, pushName, err
+}
+
+// openAdminMessagesDB opens messages.db, without creating it when the bridge never ran here
+func openAdminMessagesDB() (*sql.DB, error) {
+	if _, err := os.Stat("store/messages.db"); err != nil {
+		return nil, fmt.Errorf("no message database in store/; run the command in the bridge's directory")
+	}
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message database: %v", err)
+	}
+	return db, nil
+}
+
+// messageStoreStats counts the chats and messages in messages.db and returns the time of the latest message
+func messageStoreStats() (int, int, string, error) {
+	db, err := openAdminMessagesDB()
+	if err != nil {
+		return 0, 0, "", err
+	}
+	defer db.Close()
+	var chats, messages int
+	var last sql.NullString
+	if err := db.QueryRow("SELECT COUNT(*) FROM chats").Scan(&chats); err != nil {
+		return 0, 0, "", err
+	}
+	if err := db.QueryRow("SELECT COUNT(*), MAX(timestamp) FROM messages").Scan(&messages, &last); err != nil {
+		return 0, 0, "", err
+	}
+	return chats, messages, last.String, nil
+}
+
+func runAdminChats(args []string) error {
+	fs := flag.NewFlagSet("chats", flag.ExitOnError)
+	query := fs.String("query", "", "Only chats whose name or JID contains this")
+	groups := fs.Bool("groups", false, "Only groups")
+	direct := fs.Bool("direct", false, "Only direct chats")
+	limit := fs.Int("limit", 50, "Maximum number of chats (0 for all)")
+	fs.Parse(args)
+
+	db, err := openAdminMessagesDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	condition, queryArgs := "1", []interface{}{}
+	if *query != "" {
+		condition += " AND (LOWER(COALESCE(name, '')) LIKE LOWER(?) OR jid LIKE ?)"
+		queryArgs = append(queryArgs, "%"+*query+"%", "%"+*query+"%")
+	}
+	if *groups {
+		condition += " AND jid LIKE '%@g.us'"
+	}
+	if *direct {
+		condition += " AND jid NOT LIKE '%@g.us'"
+	}
+	if *limit <= 0 {
+		*limit = -1
+	}
+	rows, err := db.Query(`
+		SELECT jid, COALESCE(name, ''), last_message_time FROM chats
+		WHERE `+condition+`
+		ORDER BY last_message_time DESC LIMIT ?
+	`, append(queryArgs, *limit)...)
+	if err != nil {
+		return fmt.Errorf("failed to read chats: %v", err)
+	}
+	defer rows.Close()
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "JID\tTYPE\tNAME\tLAST MESSAGE")
+	count := 0
+	for rows.Next() {
+		var jid, name string
+		var lastMessage sql.NullTime
+		if err := rows.Scan(&jid, &name, &lastMessage); err != nil {
+			return fmt.Errorf("failed to scan chat: %v", err)
+		}
+		last := "-"
+		if lastMessage.Valid {
+			last = lastMessage.Time.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", jid, adminChatType(jid), orDash(name), last)
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read chats: %v", err)
+	}
+	if count == 0 {
+		fmt.Println("No chats found")
+		return nil
+	}
+	return writer.Flush()
+}
+
+// adminChatType names the kind of chat a JID is
+func adminChatType(jid string) string {
+	switch {
+	case strings.HasSuffix(jid, "@g.us"):
+		return "group"
+	case strings.HasSuffix(jid, "@broadcast"):
+		return "broadcast"
+	case strings.HasSuffix(jid, "@newsletter"):
+		return "channel"
+	default:
+		return "direct"
+	}
+}
+
+func runAdminLogout(args []string) error {
+	fs := flag.NewFlagSet("logout", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "Confirm unlinking this device from WhatsApp")
+	fs.Parse(args)
+	if !*yes {
+		return fmt.Errorf("logout unlinks this device from WhatsApp; run again with --yes to confirm")
+	}
+
+	if _, err := callBridgeAdmin(http.MethodPost, "/login/logout", nil); err != nil {
+		return err
+	}
+	fmt.Printf("Logged out. Run \"admin re-pair\" or open %s/login to pair again.\n", getBridgeBaseURL())
+	return nil
+}
+
+func runAdminPair(args []string) error {
+	fs := flag.NewFlagSet("re-pair", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "Log out first when a session exists")
+	fs.Parse(args)
+
+	status, err := getAdminLoginStatus()
+	if err != nil {
+		return err
+	}
+	switch {
+	case status.State == "pairing":
+		// Already waiting for a scan, e.g. after a logout
+	case status.JID != "":
+		if !*yes {
+			return fmt.Errorf("logged in as %s; run again with --yes to log out and pair again", status.JID)
+		}
+		if _, err := callBridgeAdmin(http.MethodPost, "/login/logout", nil); err != nil {
+			return err
+		}
+	default:
+		if _, err := callBridgeAdmin(http.MethodPost, "/login/pair", nil); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Open WhatsApp on your phone, go to Linked devices and scan the code. It changes every few seconds.")
+	shown := ""
+	deadline := time.Now().Add(adminPairTimeout)
+	for time.Now().Before(deadline) {
+		status, err := getAdminLoginStatus()
+		if err != nil {
+			return err
+		}
+		switch status.State {
+		case "connected":
+			fmt.Printf("Paired as %s (%s)\n", status.JID, orDash(status.PushName))
+			return nil
+		case "logged_out":
+			if status.Error != "" {
+				return fmt.Errorf("pairing failed: %s", status.Error)
+			}
+		}
+		if status.QRCode != "" && status.QRCode != shown {
+			qrterminal.GenerateHalfBlock(status.QRCode, qrterminal.L, os.Stdout)
+			shown = status.QRCode
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return fmt.Errorf("the code wasn't scanned within %v", adminPairTimeout)
+}
+
+// orDash returns "-" for an empty value
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// The alerts entry of config.json holds watch rules, e.g. "term sheet", "wire" or my name. A message matching a
+// rule is forwarded right away, with the messages before it, to my self-chat, other chats or webhooks. Alerts
+// also fire in muted chats, which is where they are most useful.
+
+const (
+	// Earlier messages of the chat sent along with an alert, unless the rule sets context
+	defaultAlertContext = 3
+
+	// Upper bound of context, so a rule can't forward a whole chat
+	maxAlertContext = 20
+)
+
+// alertRule is a watch rule with its keywords and pattern compiled and its chats and targets resolved
+type alertRule struct {
+	name          string
+	patterns      []*regexp.Regexp
+	chats         map[string]bool // nil for every chat
+	chatTargets   []string        // JIDs, or "self"
+	webhooks      []string
+	context       int
+	includeFromMe bool
+}
+
+// AlertPayload is the JSON body posted to the webhooks of an alert
+type AlertPayload struct {
+	Type    string        `json:"type"` // "alert"
+	Rules   []string      `json:"rules"`
+	Message StreamEvent   `json:"message"`
+	Context []StreamEvent `json:"context"` // earlier messages of the chat, oldest first
+}
+
+// Alerts forwards the messages matching the watch rules as they arrive
+type Alerts struct {
+	rules        []alertRule
+	messageStore *MessageStore
+	logger       waLog.Logger
+}
+
+// keywordPattern matches a keyword or phrase as whole words in any script, ignoring case
+func keywordPattern(keyword string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(?:^|[^\pL\pN_])` + regexp.QuoteMeta(keyword) + `(?:$|[^\pL\pN_])`)
+}
+
+// newAlertRule compiles a watch rule of the config
+func newAlertRule(config AlertConfig) (alertRule, error) {
+	rule := alertRule{name: config.Name, context: defaultAlertContext, includeFromMe: config.IncludeFromMe}
+	if config.Context != nil {
+		rule.context = min(max(*config.Context, 0), maxAlertContext)
+	}
+	for _, keyword := range config.Keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			rule.patterns = append(rule.patterns, keywordPattern(keyword))
+		}
+	}
+	if config.Pattern != "" {
+		pattern, err := regexp.Compile(config.Pattern)
+		if err != nil {
+			return rule, fmt.Errorf("invalid pattern: %v", err)
+		}
+		rule.patterns = append(rule.patterns, pattern)
+	}
+	if len(rule.patterns) == 0 {
+		return rule, fmt.Errorf("no keywords or pattern")
+	}
+	if rule.name == "" {
+		rule.name = strings.Join(config.Keywords, ", ")
+		if rule.name == "" {
+			rule.name = config.Pattern
+		}
+	}
+
+	if len(config.Chats) > 0 {
+		rule.chats = make(map[string]bool)
+		for _, chat := range config.Chats {
+			jid, err := resolveChatReference(chat)
+			if err != nil {
+				return rule, err
+			}
+			rule.chats[jid] = true
+		}
+	}
+
+	notify := config.Notify
+	if len(notify) == 0 {
+		notify = []string{"self"}
+	}
+	for _, target := range notify {
+		target = strings.TrimSpace(target)
+		switch {
+		case strings.HasPrefix(target, "https://") || strings.HasPrefix(target, "http://"):
+			rule.webhooks = append(rule.webhooks, target)
+		case strings.EqualFold(target, "self"):
+			rule.chatTargets = append(rule.chatTargets, "self")
+		default:
+			jid, err := resolveChatReference(target)
+			if err != nil {
+				return rule, fmt.Errorf("notify %q: %v", target, err)
+			}
+			rule.chatTargets = append(rule.chatTargets, jid)
+		}
+	}
+	return rule, nil
+}
+
+// matches reports whether a message triggers a rule
+func (rule alertRule) matches(event StreamEvent) bool {
+	if event.IsFromMe && !rule.includeFromMe {
+		return false
+	}
+	if rule.chats != nil && !rule.chats[event.ChatJID] {
+		return false
+	}
+	text := strings.TrimSpace(event.Content + " " + event.Filename)
+	for _, pattern := range rule.patterns {
+		if pattern.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// startAlerts starts forwarding the messages that match the watch rules of the config, if any
+func startAlerts(messageStore *MessageStore, logger waLog.Logger) error {
+	config, err := loadBridgeConfig()
+	if err != nil {
+		return err
+	}
+	alerts := &Alerts{messageStore: messageStore, logger: logger}
+	for i, ruleConfig := range config.Alerts {
+		rule, err := newAlertRule(ruleConfig)
+		if err != nil {
+			logger.Warnf("Alert %d (%s) disabled: %v", i+1, ruleConfig.Name, err)
+			continue
+		}
+		alerts.rules = append(alerts.rules, rule)
+	}
+	if len(alerts.rules) == 0 {
+		return nil
+	}
+
+	events := eventHub.Subscribe()
+	go func() {
+		for event := range events {
+			// Automated messages are left out, so alerts can't trigger each other
+			if event.Type != "message" || event.Origin != "" {
+				continue
+			}
+			var matched []alertRule
+			for _, rule := range alerts.rules {
+				if rule.matches(event) {
+					matched = append(matched, rule)
+				}
+			}
+			if len(matched) > 0 {
+				go alerts.forward(event, matched)
+			}
+		}
+	}()
+	logger.Infof("Watching messages for %d alert rule(s)", len(alerts.rules))
+	return nil
+}
+
+// forward sends one alert for a message to the targets of every rule it matched
+func (alerts *Alerts) forward(event StreamEvent, rules []alertRule) {
+	names := make([]string, 0, len(rules))
+	contextSize := 0
+	chatTargets := make(map[string]bool)
+	webhooks := make(map[string]bool)
+	for _, rule := range rules {
+		names = append(names, rule.name)
+		contextSize = max(contextSize, rule.context)
+		for _, target := range rule.chatTargets {
+			chatTargets[target] = true
+		}
+		for _, url := range rule.webhooks {
+			webhooks[url] = true
+		}
+	}
+	earlier := alerts.context(event, contextSize)
+	alerts.logger.Infof("Message %s in %s matched alert %s", event.ID, event.ChatJID, strings.Join(names, ", "))
+
+	if len(chatTargets) > 0 && readOnlyModeEnabled() {
+		alerts.logger.Infof("Read-only mode is on, not sending alert for %s to chats", event.ID)
+	} else if len(chatTargets) > 0 {
+		text := formatAlert(event, earlier, names)
+		for target := range chatTargets {
+			// Goes through the outbox, so alerts arriving while disconnected or in safe mode are sent later
+			_, err := messageOutbox.Send(SendMessageRequest{Recipient: target, Message: text, Origin: messageOriginAlert})
+			if err != nil {
+				alerts.logger.Warnf("Failed to send alert for %s to %s: %v", event.ID, target, err)
+			}
+		}
+	}
+
+	if len(webhooks) > 0 {
+		payload, err := json.Marshal(AlertPayload{Type: "alert", Rules: names, Message: event, Context: earlier})
+		if err != nil {
+			alerts.logger.Warnf("Failed to encode alert payload: %v", err)
+			return
+		}
+		// The same on every retry, like the queued webhook deliveries' IDs
+		deliveryID := time.Now().UnixNano()
+		for url := range webhooks {
+			go alerts.post(&WebhookConfig{URL: url, Secret: os.Getenv("WEBHOOK_SECRET")}, deliveryID, payload)
+		}
+	}
+}
+
+// post delivers an alert to a webhook, retrying with the first delays of the webhook queue
+func (alerts *Alerts) post(webhook *WebhookConfig, deliveryID int64, payload []byte) {
+	delays := webhookRetryDelays[:4]
+	for attempt := 0; ; attempt++ {
+		err := postWebhook(webhook, deliveryID, payload)
+		if err == nil {
+			return
+		}
+		if attempt >= len(delays) {
+			alerts.logger.Warnf("Giving up on alert delivery to %s after %d attempts: %v", webhook.URL, attempt+1, err)
+			return
+		}
+		time.Sleep(delays[attempt])
+	}
+}
+
+// context returns up to size messages of the chat before a message, oldest first
+func (alerts *Alerts) context(event StreamEvent, size int) []StreamEvent {
+	if size == 0 {
+		return nil
+	}
+	messages, err := alerts.messageStore.GetMessages(event.ChatJID, size+1)
+	if err != nil {
+		alerts.logger.Warnf("Failed to load the context of alert %s: %v", event.ID, err)
+		return nil
+	}
+	var earlier []StreamEvent
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if msg.ID == event.ID || msg.Time.After(event.Timestamp) {
+			continue
+		}
+		content := msg.Content
+		if msg.Deleted {
+			content = "[deleted]"
+		}
+		earlier = append(earlier, StreamEvent{
+			Type:       "message",
+			ID:         msg.ID,
+			ChatJID:    event.ChatJID,
+			ChatName:   event.ChatName,
+			Sender:     msg.Sender,
+			SenderName: msg.Sender,
+			Content:    content,
+			MediaType:  msg.MediaType,
+			Filename:   msg.Filename,
+			IsFromMe:   msg.IsFromMe,
+			IsGroup:    event.IsGroup,
+			Timestamp:  msg.Time,
+		})
+	}
+	if len(earlier) > size {
+		earlier = earlier[len(earlier)-size:]
+	}
+	return earlier
+}
+
+// formatAlert renders an alert as a chat message, the matching message last
+func formatAlert(event StreamEvent, earlier []StreamEvent, rules []string) string {
+	chatName := event.ChatName
+	if chatName == "" {
+		chatName = event.ChatJID
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, "🔔 *Alert: %s* — %s\n", strings.Join(rules, ", "), chatName)
+	if len(earlier) > 0 {
+		text.WriteString("\n" + formatCopilotEvents(earlier) + "\n")
+	}
+	text.WriteString("\n➡️ " + formatCopilotEvents([]StreamEvent{event}))
+	if time.Since(event.Timestamp) > time.Hour {
+		fmt.Fprintf(&text, "\n\n_Sent %s_", event.Timestamp.Format("Jan 2 15:04"))
+	}
+	return text.String()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Annotation is a private note or correction attached to a message or to a chat's summary of a given day
+type Annotation struct {
+	ID          int64
+	ChatJID     string
+	MessageID   string // empty for summary annotations
+	SummaryDate string // YYYY-MM-DD, empty for message annotations
+	Note        string
+	CreatedAt   string
+
+	// Filled for message annotations so the prompt shows what is being corrected
+	MessageSender  string
+	MessageContent string
+}
+
+// maxPromptAnnotations limits how many annotations are injected into a prompt
+const maxPromptAnnotations = 50
+
+// ensureAnnotationTables creates the annotations table if it doesn't exist
+func ensureAnnotationTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS annotations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_jid TEXT NOT NULL,
+			message_id TEXT,
+			summary_date TEXT,
+			note TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_annotations_chat ON annotations (chat_jid);
+	`)
+	return err
+}
+
+// getAnnotationsForChat returns the most recent annotations of a chat, oldest first
+func getAnnotationsForChat(db *sql.DB, chatJID string, limit int) ([]Annotation, error) {
+	rows, err := db.Query(`
+		SELECT * FROM (
+			SELECT a.id, a.chat_jid, COALESCE(a.message_id, ''), COALESCE(a.summary_date, ''), a.note, a.created_at,
+				COALESCE(m.sender, ''), COALESCE(m.content, '')
+			FROM annotations a
+			LEFT JOIN messages m ON m.id = a.message_id AND m.chat_jid = a.chat_jid
+			WHERE a.chat_jid = ?
+			ORDER BY a.id DESC
+			LIMIT ?
+		) ORDER BY id ASC
+	`, chatJID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var annotations []Annotation
+	for rows.Next() {
+		var annotation Annotation
+		var createdAt sql.NullString
+		err := rows.Scan(&annotation.ID, &annotation.ChatJID, &annotation.MessageID, &annotation.SummaryDate, &annotation.Note, &createdAt,
+			&annotation.MessageSender, &annotation.MessageContent)
+		if err != nil {
+			return nil, err
+		}
+		annotation.CreatedAt = createdAt.String
+		annotations = append(annotations, annotation)
+	}
+	return annotations, nil
+}
+
+// formatAnnotationsForPrompt renders annotations as ground-truth bullet points
+func formatAnnotationsForPrompt(annotations []Annotation) string {
+	var lines []string
+	for _, annotation := range annotations {
+		switch {
+		case annotation.MessageID != "" && annotation.MessageContent != "":
+			lines = append(lines, fmt.Sprintf("- About the message from %s \"%s\": %s",
+				annotation.MessageSender, truncateAnnotationQuote(annotation.MessageContent), annotation.Note))
+		case annotation.SummaryDate != "":
+			lines = append(lines, fmt.Sprintf("- About the summary of %s: %s", annotation.SummaryDate, annotation.Note))
+		default:
+			lines = append(lines, "- "+annotation.Note)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// truncateAnnotationQuote shortens a quoted message so long messages don't dominate the prompt
+func truncateAnnotationQuote(content string) string {
+	content = strings.ReplaceAll(content, "\n", " ")
+	if len([]rune(content)) > 200 {
+		return string([]rune(content)[:200]) + "..."
+	}
+	return content
+}
+
+// getAnnotationsPromptText loads a chat's annotations formatted for prompts, or "" if there are none
+func getAnnotationsPromptText(db *sql.DB, chatJID string) (string, error) {
+	if err := ensureAnnotationTables(db); err != nil {
+		return "", err
+	}
+	annotations, err := getAnnotationsForChat(db, chatJID, maxPromptAnnotations)
+	if err != nil {
+		return "", err
+	}
+	return formatAnnotationsForPrompt(annotations), nil
+}
+
+// applyAnnotationsToPrompt fills the {{ANNOTATIONS}} placeholder, or appends the corrections to
+// prompts that don't have one so custom templates still benefit from them
+func applyAnnotationsToPrompt(prompt, annotationsText string) string {
+	if strings.Contains(prompt, "{{ANNOTATIONS}}") {
+		return strings.ReplaceAll(prompt, "{{ANNOTATIONS}}", annotationsText)
+	}
+	if annotationsText == "" {
+		return prompt
+	}
+	return prompt + "\n\n**Corrections from the user (treat as ground truth, they override anything in the messages):**\n" + annotationsText
+}
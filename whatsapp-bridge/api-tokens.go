@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Capabilities an API token can be granted
+const (
+	apiCapabilityRead     = "read"     // messages, timeline, pulse and live events of its chats
+	apiCapabilitySend     = "send"     // send messages to its chats
+	apiCapabilityDownload = "download" // download media of its chats
+	apiCapabilityAdmin    = "admin"    // import control, safe mode and group administration
+)
+
+// apiTokenPrefix starts every token, so leaked tokens are easy to recognize
+const apiTokenPrefix = "wab_"
+
+// apiTokenCookie carries a token for browser pages (the login page), which can't send an Authorization header
+const apiTokenCookie = "bridge_api_token"
+
+// APIToken is a bridge API token scoped to some chats and capabilities.
+// Only the SHA-256 hash of the token is stored; the token itself is shown once, when created.
+type APIToken struct {
+	ID           int64
+	Name         string
+	Chats        []string // chat JIDs, or "*" for every chat
+	Capabilities []string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time // zero when the token doesn't expire
+	LastUsedAt   time.Time
+	RevokedAt    time.Time
+}
+
+type apiTokenKey struct{}
+
+// internalAPIRequestKey marks the requests the bridge makes to its own API for MCP tool calls (see mcp-tools.go).
+// Its value is the API token of the MCP client, nil for a trusted one.
+type internalAPIRequestKey struct{}
+
+// ensureAPITokensTable creates the API token table if it doesn't exist
+func ensureAPITokensTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE,
+			token_hash TEXT UNIQUE,
+			chats TEXT DEFAULT '',
+			capabilities TEXT DEFAULT '',
+			created_at TIMESTAMP,
+			expires_at TIMESTAMP,
+			last_used_at TIMESTAMP,
+			revoked_at TIMESTAMP
+		);
+	`)
+	return err
+}
+
+// How API requests without a token are treated (BRIDGE_API_AUTH)
+const (
+	apiAuthRequired = "required" // rejected
+	apiAuthLocal    = "local"    // full access for local tools only (see isLocalToolRequest); the default
+	apiAuthOptional = "optional" // full access from anywhere; tokens only restrict the requests that carry them
+)
+
+// apiAuthMode returns BRIDGE_API_AUTH, or "local" when it is unset or unknown
+func apiAuthMode() string {
+	switch mode := os.Getenv("BRIDGE_API_AUTH"); mode {
+	case apiAuthRequired, apiAuthOptional:
+		return mode
+	}
+	return apiAuthLocal
+}
+
+// clientIP returns the address of the client that made a request
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// sameOriginRequest reports whether a request's Origin, or Referer without one, is the bridge itself; requests
+// with neither, which browsers don't send for forms, pass
+func sameOriginRequest(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+	parsed, err := url.Parse(source)
+	return err == nil && parsed.Host != "" && strings.EqualFold(parsed.Host, r.Host)
+}
+
+// isLocalToolRequest reports whether a request came straight from a program on this machine (or container), such
+// as the bridge's own tools: from a loopback address, not relayed by a proxy and not sent by a browser, which adds
+// an Origin or Referer. A proxy on the same host, or a web page posting to localhost, doesn't pass.
+func isLocalToolRequest(r *http.Request) bool {
+	for _, header := range []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Real-IP", "Origin", "Referer"} {
+		if r.Header.Get(header) != "" {
+			return false
+		}
+	}
+	ip := net.ParseIP(clientIP(r))
+	return ip != nil && ip.IsLoopback()
+}
+
+// tokenlessAPIRequestAllowed reports whether a request without a token may use the API, with full access
+func tokenlessAPIRequestAllowed(r *http.Request) bool {
+	switch apiAuthMode() {
+	case apiAuthOptional:
+		return true
+	case apiAuthRequired:
+		return false
+	}
+	return isLocalToolRequest(r)
+}
+
+// countActiveAPITokens returns how many tokens are neither revoked nor expired
+func countActiveAPITokens(db *sql.DB) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM api_tokens WHERE revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)",
+		time.Now()).Scan(&count)
+	return count, err
+}
+
+// checkAPIAuthSetup warns at startup when the API is open to any process that reaches it, when only local clients
+// can use it without a token, or when it requires tokens but none can pass
+func checkAPIAuthSetup(db *sql.DB) {
+	active, err := countActiveAPITokens(db)
+	if err != nil {
+		fmt.Printf("Warning: failed to check the API tokens: %v\n", err)
+		return
+	}
+	mode := apiAuthMode()
+	switch {
+	case mode == apiAuthOptional:
+		fmt.Println("Warning: BRIDGE_API_AUTH=optional, so requests without a token have full access to the API from anywhere; create tokens with ./tokens and set it to \"required\"")
+	case mode == apiAuthLocal && active == 0:
+		fmt.Println("Warning: there is no active API token, so only clients on this machine (or in this container) can use the API; create one with ./tokens create for the others")
+	case mode == apiAuthRequired && active == 0:
+		fmt.Println("Warning: BRIDGE_API_AUTH=required but there is no active API token, so every API request is rejected; create one with ./tokens create")
+	case mode == apiAuthRequired && os.Getenv("BRIDGE_API_TOKEN") == "":
+		fmt.Println("Warning: BRIDGE_API_AUTH=required but BRIDGE_API_TOKEN isn't set, so the bridge's own tools can't call the API")
+	}
+}
+
+// hashAPIToken returns the stored form of a token
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// validateAPICapabilities checks that every capability is known
+func validateAPICapabilities(capabilities []string) error {
+	for _, capability := range capabilities {
+		switch capability {
+		case apiCapabilityRead, apiCapabilitySend, apiCapabilityDownload, apiCapabilityAdmin:
+		default:
+			return fmt.Errorf("unknown capability %q (expected read, send, download or admin)", capability)
+		}
+	}
+	return nil
+}
+
+// createAPIToken stores a new token and returns it; it can't be retrieved later
+func createAPIToken(db *sql.DB, name string, chats, capabilities []string, expiresAt time.Time) (string, error) {
+	if err := validateAPICapabilities(capabilities); err != nil {
+		return "", err
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	token := apiTokenPrefix + hex.EncodeToString(secret)
+
+	var expires interface{}
+	if !expiresAt.IsZero() {
+		expires = expiresAt
+	}
+	_, err := db.Exec(`
+		INSERT INTO api_tokens (name, token_hash, chats, capabilities, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, name, hashAPIToken(token), strings.Join(chats, ","), strings.Join(capabilities, ","), time.Now(), expires)
+	if err != nil {
+		return "", fmt.Errorf("failed to store token: %v", err)
+	}
+	return token, nil
+}
+
+// revokeAPIToken revokes a token by name
+func revokeAPIToken(db *sql.DB, name string) error {
+	result, err := db.Exec("UPDATE api_tokens SET revoked_at = ? WHERE name = ? AND revoked_at IS NULL", time.Now(), name)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("no active token named %q", name)
+	}
+	return nil
+}
+
+// scanAPIToken reads a token row selected by apiTokenColumns
+func scanAPIToken(scanner interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	var token APIToken
+	var chats, capabilities string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	if err := scanner.Scan(&token.ID, &token.Name, &chats, &capabilities, &token.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	if chats != "" {
+		token.Chats = strings.Split(chats, ",")
+	}
+	if capabilities != "" {
+		token.Capabilities = strings.Split(capabilities, ",")
+	}
+	token.ExpiresAt, token.LastUsedAt, token.RevokedAt = expiresAt.Time, lastUsedAt.Time, revokedAt.Time
+	return &token, nil
+}
+
+const apiTokenColumns = "id, name, chats, capabilities, created_at, expires_at, last_used_at, revoked_at"
+
+// listAPITokens returns every token, active or not
+func listAPITokens(db *sql.DB) ([]*APIToken, error) {
+	rows, err := db.Query("SELECT " + apiTokenColumns + " FROM api_tokens ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// lookupAPIToken returns the active token matching a presented secret
+func lookupAPIToken(db *sql.DB, secret string) (*APIToken, error) {
+	token, err := scanAPIToken(db.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", hashAPIToken(secret)))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("unknown token")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !token.RevokedAt.IsZero() {
+		return nil, fmt.Errorf("token revoked")
+	}
+	if !token.ExpiresAt.IsZero() && time.Now().After(token.ExpiresAt) {
+		return nil, fmt.Errorf("token expired")
+	}
+
+	db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", time.Now(), token.ID)
+	return token, nil
+}
+
+// HasCapability reports whether the token grants a capability; admin grants all of them
+func (token *APIToken) HasCapability(capability string) bool {
+	for _, granted := range token.Capabilities {
+		if granted == capability || granted == apiCapabilityAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsChat reports whether the token covers a chat (JID, or phone number for direct chats)
+func (token *APIToken) AllowsChat(chatJID string) bool {
+	if !strings.Contains(chatJID, "@") {
+		chatJID += "@s.whatsapp.net"
+	}
+	for _, chat := range token.Chats {
+		if chat == "*" || chat == chatJID {
+			return true
+		}
+	}
+	return false
+}
+
+// apiTokenFromRequest returns the token a request was authenticated with, or nil for unauthenticated requests
+func apiTokenFromRequest(r *http.Request) *APIToken {
+	token, _ := r.Context().Value(apiTokenKey{}).(*APIToken)
+	return token
+}
+
+// apiRequestAllowsChat reports whether the request may access a chat
+func apiRequestAllowsChat(r *http.Request, chatJID string) bool {
+	token := apiTokenFromRequest(r)
+	return token == nil || token.AllowsChat(chatJID)
+}
+
+// apiTokenSecret returns the token a request carries, in its Authorization header or the login page's cookie
+func apiTokenSecret(r *http.Request) string {
+	secret := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if cookie, err := r.Cookie(apiTokenCookie); secret == "" && err == nil {
+		secret = cookie.Value
+	}
+	return secret
+}
+
+// requireAPICapability authenticates a request's bearer token and rejects it unless the token grants the capability
+// and the permissions allow the endpoint. Handlers still check the chats the request touches with apiRequestAllowsChat.
+func requireAPICapability(db *sql.DB, capability string, next http.HandlerFunc) http.HandlerFunc {
+	// The permissions in config.json may turn the endpoint off, or limit it to some tokens
+	permitted := func(w http.ResponseWriter, r *http.Request) {
+		if err := checkPermission(r.URL.Path, apiTokenFromRequest(r)); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token, internal := r.Context().Value(internalAPIRequestKey{}).(*APIToken); internal {
+			if token == nil {
+				permitted(w, r)
+				return
+			}
+			if !token.HasCapability(capability) {
+				http.Error(w, fmt.Sprintf("Token %q lacks the %s capability", token.Name, capability), http.StatusForbidden)
+				return
+			}
+			permitted(w, r.WithContext(context.WithValue(r.Context(), apiTokenKey{}, token)))
+			return
+		}
+
+		secret := apiTokenSecret(r)
+		if secret == "" {
+			if !tokenlessAPIRequestAllowed(r) {
+				http.Error(w, "API token required", http.StatusUnauthorized)
+				return
+			}
+			permitted(w, r)
+			return
+		}
+
+		token, err := lookupAPIToken(db, secret)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid API token: %v", err), http.StatusUnauthorized)
+			return
+		}
+		if !token.HasCapability(capability) {
+			http.Error(w, fmt.Sprintf("Token %q lacks the %s capability", token.Name, capability), http.StatusForbidden)
+			return
+		}
+		permitted(w, r.WithContext(context.WithValue(r.Context(), apiTokenKey{}, token)))
+	}
+}
+
+// rejectChat answers a request for a chat outside the token's scope
+func rejectChat(w http.ResponseWriter, r *http.Request, chatJID string) {
+	http.Error(w, fmt.Sprintf("Token %q has no access to %s", apiTokenFromRequest(r).Name, chatJID), http.StatusForbidden)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Messages deleted per statement once archived
+const archiveDeleteBatch = 500
+
+var (
+	archiveOlderThan      = flag.Int("older-than", 0, "Archive messages older than this many days (default ARCHIVE_AFTER_DAYS)")
+	archiveChat           = flag.String("chat", "", "Only archive this chat JID (default every chat)")
+	archiveOut            = flag.String("out", "store/archive", "Directory the Parquet files are written to")
+	archiveDryRun         = flag.Bool("dry-run", false, "Only report what would be archived")
+	archiveKeepLocal      = flag.Bool("keep-local", true, "Keep the local files of archives uploaded to ARCHIVE_S3_BUCKET")
+	archiveAllowPlaintext = flag.Bool("allow-plaintext", false, "Archive an encrypted messages.db (MESSAGES_DB_KEY) to unencrypted files")
+)
+
+// archivePartition is the messages of one chat and month to archive
+type archivePartition struct {
+	chatJID  string
+	month    string
+	messages int
+}
+
+func main() {
+	flag.Parse()
+
+	if err := archiveMessages(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// archiveMessages moves the messages older than --older-than days from messages.db to Parquet files, one per chat
+// and month, recording each in the archive manifest
+func archiveMessages() error {
+	days := *archiveOlderThan
+	if days == 0 {
+		days, _ = strconv.Atoi(os.Getenv("ARCHIVE_AFTER_DAYS"))
+	}
+	if days <= 0 {
+		return fmt.Errorf("set --older-than or ARCHIVE_AFTER_DAYS to the age in days of the messages to archive")
+	}
+	key, err := loadMessagesDBKey()
+	if err != nil {
+		return err
+	}
+	if key != "" && !*archiveAllowPlaintext && !*archiveDryRun {
+		return fmt.Errorf("messages.db is encrypted but archive files aren't; pass --allow-plaintext to archive anyway")
+	}
+
+	db, err := openSharedMessagesDB()
+	if err != nil {
+		return err
+	}
+	if err := migrateSchema(db); err != nil {
+		return err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+	partitions, err := archivePartitions(db, cutoff)
+	if err != nil {
+		return err
+	}
+	if len(partitions) == 0 {
+		fmt.Printf("No messages older than %s to archive\n", cutoff.Format("2006-01-02"))
+		return nil
+	}
+
+	objectStore := objectStoreFromEnv()
+	runID := time.Now().UTC().Format("20060102T150405")
+	total := 0
+	for _, partition := range partitions {
+		if *archiveDryRun {
+			fmt.Printf("Would archive %d messages of %s from %s\n", partition.messages, partition.chatJID, partition.month)
+			total += partition.messages
+			continue
+		}
+		archived, err := archivePartitionMessages(db, partition, cutoff, runID, objectStore)
+		if err != nil {
+			return fmt.Errorf("failed to archive %s %s: %v", partition.chatJID, partition.month, err)
+		}
+		fmt.Printf("Archived %d messages of %s from %s\n", archived, partition.chatJID, partition.month)
+		total += archived
+	}
+
+	if *archiveDryRun {
+		fmt.Printf("Would archive %d messages older than %s in %d chat-month files\n", total, cutoff.Format("2006-01-02"), len(partitions))
+	} else {
+		fmt.Printf("Archived %d messages older than %s in %d chat-month files to %s\n", total, cutoff.Format("2006-01-02"), len(partitions), *archiveOut)
+	}
+	return nil
+}
+
+// archivePartitions counts the messages to archive per chat and month. Months are those of the stored
+// timestamps, in the bridge's local time.
+// Only messages.db is archived: direct chats kept in DIRECT_MESSAGES_DB are left to their retention, so their
+// (possibly encrypted) text is never written to Parquet.
+func archivePartitions(db *sql.DB, cutoff time.Time) ([]archivePartition, error) {
+	query := "SELECT chat_jid, substr(timestamp, 1, 7), COUNT(*) FROM messages WHERE timestamp < ?"
+	args := []interface{}{cutoff}
+	if *archiveChat != "" {
+		query += " AND chat_jid = ?"
+		args = append(args, *archiveChat)
+	}
+	rows, err := db.Query(query+" GROUP BY 1, 2 ORDER BY 1, 2", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count messages to archive: %v", err)
+	}
+	defer rows.Close()
+
+	var partitions []archivePartition
+	for rows.Next() {
+		var partition archivePartition
+		if err := rows.Scan(&partition.chatJID, &partition.month, &partition.messages); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, partition)
+	}
+	return partitions, rows.Err()
+}
+
+// archivePartitionMessages writes a chat's messages of a month to a Parquet file, uploads it when a bucket is
+// configured, records it in the manifest and then deletes the messages it holds from the database
+func archivePartitionMessages(db *sql.DB, partition archivePartition, cutoff time.Time, runID string, objectStore *ObjectStore) (int, error) {
+	rows, err := db.Query(`
+		SELECT id, chat_jid, COALESCE(sender, ''), timestamp, COALESCE(is_from_me, 0), COALESCE(content, ''), COALESCE(transcript, ''),
+			COALESCE(media_type, ''), COALESCE(filename, ''), COALESCE(file_length, 0), COALESCE(message_type, ''), COALESCE(origin, ''),
+			edited_at, deleted_at
+		FROM messages
+		WHERE chat_jid = ? AND substr(timestamp, 1, 7) = ? AND timestamp < ?
+		ORDER BY timestamp, id
+	`, partition.chatJID, partition.month, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	var messages []ArchivedMessage
+	for rows.Next() {
+		var message ArchivedMessage
+		var editedAt, deletedAt sql.NullTime
+		if err := rows.Scan(&message.ID, &message.ChatJID, &message.Sender, &message.Timestamp, &message.IsFromMe,
+			&message.Content, &message.Transcript, &message.MediaType, &message.Filename, &message.FileLength,
+			&message.MessageType, &message.Origin, &editedAt, &deletedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		message.EditedAt, message.DeletedAt = editedAt.Time, deletedAt.Time
+		messages = append(messages, message)
+	}
+	rows.Close()
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	// Hive-style partitions like parquet-export's, with a file per run, so a month can be archived in steps
+	name := filepath.Join("messages", "chat="+partition.chatJID, "month="+partition.month, "part-"+runID+".parquet")
+	path := filepath.Join(*archiveOut, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create archive directory: %v", err)
+	}
+	parquetRows := make([][]interface{}, len(messages))
+	for i, message := range messages {
+		parquetRows[i] = message.parquetRow()
+	}
+	if err := writeParquetFile(path, archiveMessageColumns, parquetRows); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %v", path, err)
+	}
+
+	// The file must read back as written before anything is deleted
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if written, err := readParquetFile(path, archiveMessageColumns); err != nil || len(written) != len(messages) {
+		return 0, fmt.Errorf("archive file %s doesn't read back: %v", path, err)
+	}
+	sum := sha256.Sum256(data)
+
+	objectURL := ""
+	if objectStore != nil {
+		if objectURL, err = objectStore.Put(filepath.ToSlash(name), data); err != nil {
+			return 0, err
+		}
+	}
+
+	// The manifest entry and the deletions commit together, so a failure leaves the messages where they were
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+		INSERT INTO message_archive (chat_jid, month, path, object_url, first_timestamp, last_timestamp, messages, sha256, archived_at)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?)
+	`, partition.chatJID, partition.month, path, objectURL, messages[0].Timestamp, messages[len(messages)-1].Timestamp,
+		len(messages), hex.EncodeToString(sum[:]), time.Now())
+	if err != nil {
+		os.Remove(path)
+		return 0, fmt.Errorf("failed to record %s in the archive manifest: %v", path, err)
+	}
+
+	// Exactly the archived messages are deleted, not ones stored since they were read
+	for start := 0; start < len(messages); start += archiveDeleteBatch {
+		batch := messages[start:min(start+archiveDeleteBatch, len(messages))]
+		args := []interface{}{partition.chatJID}
+		for _, message := range batch {
+			args = append(args, message.ID)
+		}
+		condition := "chat_jid = ? AND id IN (?" + strings.Repeat(", ?", len(batch)-1) + ")"
+		if _, err := deleteMessagesTx(tx, condition, args...); err != nil {
+			os.Remove(path)
+			return 0, fmt.Errorf("failed to delete archived messages: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		os.Remove(path)
+		return 0, fmt.Errorf("failed to delete archived messages: %v", err)
+	}
+
+	if objectURL != "" && !*archiveKeepLocal {
+		os.Remove(path)
+	}
+	return len(messages), nil
+}
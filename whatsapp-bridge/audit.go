@@ -0,0 +1,162 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+var (
+	auditDate     = flag.String("date", "", "Day of the LLM calls in YYYY-MM-DD format (defaults to today)")
+	auditGroupJID = flag.String("group-jid", "", "Only show calls made for this WhatsApp group JID")
+	auditPurpose  = flag.String("purpose", "", "Only show calls with this purpose (daily_summary, topic_segmentation, add_episode, self_chat)")
+	auditList     = flag.Bool("list", false, "Only list the calls, without prompts and responses")
+	auditExport   = flag.String("export", "", "Write the matching records as a JSON bundle to this file (gzipped when it ends in .gz)")
+	auditSends    = flag.Bool("sends", false, "Show the messages sent on my behalf instead of the LLM calls")
+	auditTo       = flag.String("recipient", "", "With --sends, only show sends to this recipient")
+)
+
+func main() {
+	flag.Parse()
+
+	logger := newLogger("Audit", "INFO")
+
+	date := *auditDate
+	if date == "" {
+		date = time.Now().Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		logger.Errorf("Invalid --date: %v", err)
+		os.Exit(1)
+	}
+
+	if *auditSends {
+		if err := showSendAudit(date, *auditTo, logger); err != nil {
+			logger.Errorf("Failed to read the send audit: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	records, err := readLLMAuditRecords(date, *auditGroupJID)
+	if err != nil {
+		logger.Errorf("Failed to read audit records: %v", err)
+		os.Exit(1)
+	}
+	if *auditPurpose != "" {
+		var filtered []LLMAuditRecord
+		for _, record := range records {
+			if record.Purpose == *auditPurpose {
+				filtered = append(filtered, record)
+			}
+		}
+		records = filtered
+	}
+
+	if len(records) == 0 {
+		logger.Infof("No LLM calls recorded for %s (is LLM_AUDIT=true?)", date)
+		return
+	}
+
+	if *auditExport != "" {
+		if err := exportLLMAuditBundle(*auditExport, records); err != nil {
+			logger.Errorf("Failed to export audit bundle: %v", err)
+			os.Exit(1)
+		}
+		logger.Infof("Exported %d LLM calls from %s to %s", len(records), date, *auditExport)
+		return
+	}
+
+	for i, record := range records {
+		status := "ok"
+		if record.Error != "" {
+			status = "error: " + record.Error
+		}
+		fmt.Printf("#%d %s  %s  %s  %s/%s  %dms  prompt %d chars, response %d chars  %s\n",
+			i+1, record.Timestamp.Format("15:04:05"), record.GroupJID, record.Purpose, record.Provider, record.Model,
+			record.DurationMs, len(record.Prompt), len(record.Response), status)
+		if *auditList {
+			continue
+		}
+		fmt.Printf("----- prompt -----\n%s\n----- response -----\n%s\n", record.Prompt, record.Response)
+		if record.RawResponse != "" {
+			fmt.Printf("----- raw response -----\n%s\n", record.RawResponse)
+		}
+		fmt.Println(strings.Repeat("=", 60))
+	}
+}
+
+// showSendAudit lists the sends audited on a day with what triggered them and how they ended, followed by the
+// text of each sent message unless --list is given
+func showSendAudit(date, recipient string, logger waLog.Logger) error {
+	db, err := openSharedMessagesDB()
+	if err != nil {
+		return err
+	}
+	start, _ := time.ParseInLocation("2006-01-02", date, time.Local)
+	records, err := readSendAudit(db, start, start.AddDate(0, 0, 1), recipient)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		logger.Infof("No sends recorded for %s", date)
+		return nil
+	}
+
+	if *auditExport != "" {
+		if err := exportLLMAuditBundle(*auditExport, records); err != nil {
+			return err
+		}
+		logger.Infof("Exported %d sends from %s to %s", len(records), date, *auditExport)
+		return nil
+	}
+
+	for _, record := range records {
+		result := record.Result
+		if record.Error != "" {
+			result += ": " + record.Error
+		}
+		fmt.Printf("#%d %s  %s  %s %s to %s  %s\n", record.ID, record.CreatedAt.In(time.Local).Format("15:04:05"),
+			record.TriggeredBy, record.Origin, record.Kind, record.Recipient, result)
+		if record.PromptHash != "" {
+			fmt.Printf("  prompt sha256 %s\n", record.PromptHash)
+		}
+		if *auditList || record.MessageID == "" {
+			continue
+		}
+		// The text is kept in the messages table only; direct chats stored apart aren't found here
+		var content string
+		if err := db.QueryRow("SELECT content FROM messages WHERE id = ?", record.MessageID).Scan(&content); err == nil && content != "" {
+			fmt.Printf("%s\n", content)
+		}
+		fmt.Println(strings.Repeat("-", 60))
+	}
+	return nil
+}
+
+// exportLLMAuditBundle writes records as one JSON array, gzipped when the path ends in .gz
+func exportLLMAuditBundle(path string, records interface{}) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var out io.Writer = file
+	if strings.HasSuffix(path, ".gz") {
+		gz := gzip.NewWriter(file)
+		defer gz.Close()
+		out = gz
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
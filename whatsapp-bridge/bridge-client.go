@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// errBridgeUnreachable is returned when no bridge answers at BRIDGE_API_URL
+var errBridgeUnreachable = errors.New("bridge not reachable")
+
+// bridgeClientHeader names the program or MCP tool behind a request to the bridge's API, for the send audit
+const bridgeClientHeader = "X-Bridge-Client"
+
+// BridgeSendRequest is the body of the bridge's /api/send endpoint
+type BridgeSendRequest struct {
+	Recipient  string `json:"recipient"`
+	Message    string `json:"message"`
+	MediaPath  string `json:"media_path,omitempty"`
+	Origin     string `json:"origin,omitempty"`      // recorded with the sent message, e.g. "summary"
+	PromptHash string `json:"prompt_hash,omitempty"` // SHA-256 of the LLM prompt that wrote the message, for the send audit
+}
+
+// getBridgeAPIURL returns the base URL of the running WhatsApp bridge REST API
+func getBridgeAPIURL() string {
+	apiURL := os.Getenv("BRIDGE_API_URL")
+	if apiURL == "" {
+		apiURL = "http://localhost:8080/api"
+	}
+	return strings.TrimRight(apiURL, "/")
+}
+
+// bridgeClientName names this program in the requests it makes to the bridge, e.g. "daily-summary"
+func bridgeClientName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// setBridgeAPIAuth adds the bridge API token (BRIDGE_API_TOKEN), needed when the bridge runs with BRIDGE_API_AUTH=required
+func setBridgeAPIAuth(header http.Header) {
+	if token := os.Getenv("BRIDGE_API_TOKEN"); token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// callBridgeAPI sends a JSON request to the bridge REST API and decodes the JSON response into out (if not nil)
+func callBridgeAPI(method, path string, payload interface{}, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("error marshaling request: %v", err)
+		}
+		body = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequest(method, getBridgeAPIURL()+path, body)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(bridgeClientHeader, bridgeClientName())
+	setBridgeAPIAuth(req.Header)
+
+	client := &http.Client{
+		Timeout: 60 * time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errBridgeUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %v", err)
+	}
+
+	if out != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				return fmt.Errorf("bridge returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+			}
+			return fmt.Errorf("error parsing response: %v", err)
+		}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("bridge returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	return nil
+}
+
+// sendViaBridgeAPI sends a text message through the running bridge's /api/send endpoint
+func sendViaBridgeAPI(recipient, message string) error {
+	return postBridgeSend(BridgeSendRequest{Recipient: recipient, Message: message})
+}
+
+// postBridgeSend sends a message, or a local file with a caption, through the running bridge
+func postBridgeSend(request BridgeSendRequest) error {
+	var resp struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+
+	err := callBridgeAPI(http.MethodPost, "/send", request, &resp)
+	if err != nil {
+		if resp.Message != "" {
+			return fmt.Errorf("%s", resp.Message)
+		}
+		return err
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("%s", resp.Message)
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BroadcastList is one of my WhatsApp broadcast lists and the contacts it delivers to
+type BroadcastList struct {
+	JID       string
+	Name      string
+	Tag       string // contact tag carried by every member, so lists can be used in segments
+	Members   []string
+	UpdatedAt time.Time
+}
+
+// broadcastTargetPrefix marks a recipient that should be expanded into a broadcast list's members (e.g. "broadcast:clients")
+const broadcastTargetPrefix = "broadcast:"
+
+var broadcastTagPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// ensureBroadcastTables creates the broadcast list tables (and the contact tag table they sync into) if they don't exist
+func ensureBroadcastTables(db *sql.DB) error {
+	if err := ensureSegmentTables(db); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS broadcast_lists (
+			jid TEXT PRIMARY KEY,
+			name TEXT DEFAULT '',
+			tag TEXT DEFAULT '',
+			updated_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS broadcast_list_members (
+			list_jid TEXT,
+			member_jid TEXT,
+			PRIMARY KEY (list_jid, member_jid)
+		);
+	`)
+	return err
+}
+
+// broadcastListTag returns the contact tag of a list's members, e.g. "broadcast:clients"
+func broadcastListTag(jid, name string) string {
+	slug := strings.Trim(broadcastTagPattern.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if slug == "" {
+		slug = strings.Split(jid, "@")[0]
+	}
+	return broadcastTargetPrefix + slug
+}
+
+// saveBroadcastList records a broadcast list, updating its name when one is given
+func saveBroadcastList(db *sql.DB, jid, name string) error {
+	_, err := db.Exec(`
+		INSERT INTO broadcast_lists (jid, name, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(jid) DO UPDATE SET name = CASE WHEN excluded.name != '' THEN excluded.name ELSE name END
+	`, jid, name, time.Now())
+	if err != nil {
+		return err
+	}
+	return syncBroadcastListTags(db, jid)
+}
+
+// setBroadcastListMembers replaces the members of a list
+func setBroadcastListMembers(db *sql.DB, jid string, members []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("INSERT OR IGNORE INTO broadcast_lists (jid, updated_at) VALUES (?, ?)", jid, time.Now()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM broadcast_list_members WHERE list_jid = ?", jid); err != nil {
+		return err
+	}
+	for _, member := range members {
+		if member = normalizeContactJID(member); member == "" {
+			continue
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO broadcast_list_members (list_jid, member_jid) VALUES (?, ?)", jid, member); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE broadcast_lists SET updated_at = ? WHERE jid = ?", time.Now(), jid); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return syncBroadcastListTags(db, jid)
+}
+
+// updateBroadcastListMembers adds or removes members of a list
+func updateBroadcastListMembers(db *sql.DB, jid string, members []string, add bool) error {
+	for _, member := range members {
+		member = normalizeContactJID(member)
+		var err error
+		if add {
+			_, err = db.Exec("INSERT OR IGNORE INTO broadcast_list_members (list_jid, member_jid) VALUES (?, ?)", jid, member)
+		} else {
+			_, err = db.Exec("DELETE FROM broadcast_list_members WHERE list_jid = ? AND member_jid = ?", jid, member)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec("UPDATE broadcast_lists SET updated_at = ? WHERE jid = ?", time.Now(), jid); err != nil {
+		return err
+	}
+	return syncBroadcastListTags(db, jid)
+}
+
+// syncBroadcastListTags makes the list's contact tag match its current name and members
+func syncBroadcastListTags(db *sql.DB, jid string) error {
+	var name, oldTag string
+	if err := db.QueryRow("SELECT COALESCE(name, ''), COALESCE(tag, '') FROM broadcast_lists WHERE jid = ?", jid).Scan(&name, &oldTag); err != nil {
+		return err
+	}
+	tag := broadcastListTag(jid, name)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, staleTag := range []string{oldTag, tag} {
+		if staleTag == "" {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM contact_tags WHERE tag = ?", staleTag); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`
+		INSERT OR IGNORE INTO contact_tags (jid, tag, created_at)
+		SELECT member_jid, ?, ? FROM broadcast_list_members WHERE list_jid = ?
+	`, tag, time.Now(), jid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE broadcast_lists SET tag = ? WHERE jid = ?", tag, jid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// findBroadcastList returns a list by JID, name (case-insensitive) or tag
+func findBroadcastList(db *sql.DB, list string) (*BroadcastList, error) {
+	list = strings.TrimPrefix(strings.TrimSpace(list), broadcastTargetPrefix)
+	var broadcast BroadcastList
+	var updatedAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT jid, COALESCE(name, ''), COALESCE(tag, ''), updated_at FROM broadcast_lists
+		WHERE jid = ? OR jid = ? OR LOWER(name) = LOWER(?) OR tag = ?
+		ORDER BY jid = ? DESC
+		LIMIT 1
+	`, list, list+"@broadcast", list, broadcastTargetPrefix+list, list).Scan(&broadcast.JID, &broadcast.Name, &broadcast.Tag, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("broadcast list %q not found", list)
+	}
+	if err != nil {
+		return nil, err
+	}
+	broadcast.UpdatedAt = updatedAt.Time
+
+	rows, err := db.Query("SELECT member_jid FROM broadcast_list_members WHERE list_jid = ? ORDER BY member_jid", broadcast.JID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var member string
+		if err := rows.Scan(&member); err != nil {
+			return nil, err
+		}
+		broadcast.Members = append(broadcast.Members, member)
+	}
+	return &broadcast, rows.Err()
+}
+
+// listBroadcastLists returns every known broadcast list with its members
+func listBroadcastLists(db *sql.DB) ([]BroadcastList, error) {
+	rows, err := db.Query("SELECT jid FROM broadcast_lists")
+	if err != nil {
+		return nil, err
+	}
+	var jids []string
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		jids = append(jids, jid)
+	}
+	rows.Close()
+	sort.Strings(jids)
+
+	var lists []BroadcastList
+	for _, jid := range jids {
+		list, err := findBroadcastList(db, jid)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, *list)
+	}
+	return lists, nil
+}
+
+// isBroadcastTarget reports whether a recipient is a broadcast list ("broadcast:<list>" or a list JID)
+func isBroadcastTarget(recipient string) bool {
+	return strings.HasPrefix(recipient, broadcastTargetPrefix) ||
+		(strings.HasSuffix(recipient, "@broadcast") && recipient != "status@broadcast")
+}
+
+// resolveBroadcastList returns the member JIDs of a broadcast list.
+// WhatsApp doesn't let linked devices send to a list, so the members are messaged one by one, as a broadcast is delivered.
+func resolveBroadcastList(db *sql.DB, list string) ([]string, error) {
+	broadcast, err := findBroadcastList(db, list)
+	if err != nil {
+		return nil, err
+	}
+	if len(broadcast.Members) == 0 {
+		return nil, fmt.Errorf("broadcast list %q has no known members (sync them with: segments broadcast-members)", list)
+	}
+	return broadcast.Members, nil
+}
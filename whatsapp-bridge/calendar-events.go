@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// With CALENDAR_EVENTS=true the daily summary also asks the LLM for the meetings and events agreed on in the
+// day's messages. New ones are kept in calendar_events, written as .ics files to CALENDAR_ICS_DIR and, with
+// CALDAV_URL set, pushed to a CalDAV calendar (Google Calendar's included), then listed under the summary.
+
+// Default length of events given a start time but no end
+const defaultCalendarEventMinutes = 60
+
+const calendarEventPrompt = `These are the WhatsApp messages of %s on %s (times in %s). List the meetings, calls and events that were agreed on: a date and time someone proposed and someone else confirmed, or that is stated as already scheduled. Leave out proposals still under discussion, declined or cancelled plans, and events that already happened. Resolve relative dates ("tomorrow", "next Friday") against the date of the message.
+
+For each event give a short title, its date as YYYY-MM-DD, its start time as HH:MM (empty for an all-day event), its length in minutes when known (0 otherwise), its place or video link when given, and one sentence on what it is about. Return no events when none were agreed on.
+
+<messages>
+%s
+</messages>`
+
+// calendarEventSchema describes the LLM's events
+var calendarEventSchema = &JSONSchema{
+	Type: "object",
+	Properties: map[string]*JSONSchema{
+		"events": {
+			Type: "array",
+			Items: &JSONSchema{
+				Type: "object",
+				Properties: map[string]*JSONSchema{
+					"title":            {Type: "string", MinLength: 1},
+					"date":             {Type: "string", Description: "YYYY-MM-DD", MinLength: 10},
+					"start_time":       {Type: "string", Description: "HH:MM, or empty for an all-day event"},
+					"duration_minutes": {Type: "integer"},
+					"location":         {Type: "string"},
+					"description":      {Type: "string"},
+				},
+				Required: []string{"title", "date", "start_time"},
+			},
+		},
+	},
+	Required: []string{"events"},
+}
+
+// CalendarEvent is a meeting or event agreed on in a chat
+type CalendarEvent struct {
+	UID         string
+	ChatJID     string
+	Title       string
+	Start       time.Time
+	End         time.Time // exclusive; the next day's midnight for an all-day event
+	AllDay      bool
+	Location    string
+	Description string
+	ICSPath     string
+}
+
+// calendarEventsEnabled reports whether the daily summary extracts calendar events (CALENDAR_EVENTS=true)
+func calendarEventsEnabled() bool {
+	return os.Getenv("CALENDAR_EVENTS") == "true"
+}
+
+// calendarICSDir returns where the events' .ics files are written (CALENDAR_ICS_DIR, default store/calendar)
+func calendarICSDir() string {
+	if dir := os.Getenv("CALENDAR_ICS_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join("store", "calendar")
+}
+
+// appendCalendarEvents extracts the events agreed on in a chat's messages of the day, saves and exports the new
+// ones, and lists them under the summary
+func appendCalendarEvents(ctx context.Context, summary, chatJID string, messages []DailySummaryMessage, startOfDay time.Time, logger waLog.Logger) string {
+	if !calendarEventsEnabled() || len(messages) == 0 {
+		return summary
+	}
+	events, err := extractCalendarEvents(ctx, chatJID, messages, startOfDay, logger)
+	if err != nil {
+		logger.Warnf("Failed to extract calendar events: %v", err)
+		return summary
+	}
+	if len(events) == 0 {
+		return summary
+	}
+
+	db, err := openSharedMessagesDB()
+	if err != nil {
+		logger.Warnf("Calendar events not available: %v", err)
+		return summary
+	}
+
+	var added []CalendarEvent
+	for _, event := range events {
+		isNew, err := saveCalendarEvent(db, &event)
+		if err != nil {
+			logger.Warnf("Failed to save calendar event %q: %v", event.Title, err)
+			continue
+		}
+		if !isNew {
+			continue
+		}
+		exportCalendarEvent(ctx, db, &event, logger)
+		added = append(added, event)
+	}
+	if len(added) == 0 {
+		return summary
+	}
+	logger.Infof("Listing %d new calendar event(s) under the summary", len(added))
+	return summary + "\n\n" + formatCalendarEventsSection(added)
+}
+
+// extractCalendarEvents asks the LLM for the events agreed on in the messages, in the day's location
+func extractCalendarEvents(ctx context.Context, chatJID string, messages []DailySummaryMessage, startOfDay time.Time, logger waLog.Logger) ([]CalendarEvent, error) {
+	loc := startOfDay.Location()
+	var lines []string
+	for _, msg := range messages {
+		speaker := msg.Sender
+		if msg.IsFromMe {
+			speaker = "Me"
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", msg.Time.In(loc).Format("Mon 2006-01-02 15:04"), speaker, strings.Join(strings.Fields(msg.Content), " ")))
+	}
+	prompt := fmt.Sprintf(calendarEventPrompt, getGroupName(chatJID, logger), startOfDay.Format("Monday, 2006-01-02"), loc.String(), strings.Join(lines, "\n"))
+
+	var answer struct {
+		Events []struct {
+			Title           string `json:"title"`
+			Date            string `json:"date"`
+			StartTime       string `json:"start_time"`
+			DurationMinutes int    `json:"duration_minutes"`
+			Location        string `json:"location"`
+			Description     string `json:"description"`
+		} `json:"events"`
+	}
+	if err := callLLMStructured(withLLMPurpose(ctx, "calendar_events"), prompt, calendarEventSchema, &answer, logger); err != nil {
+		return nil, err
+	}
+
+	var events []CalendarEvent
+	for _, found := range answer.Events {
+		event := CalendarEvent{
+			ChatJID:     chatJID,
+			Title:       strings.TrimSpace(found.Title),
+			Location:    strings.TrimSpace(found.Location),
+			Description: strings.TrimSpace(found.Description),
+		}
+		day, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(found.Date), loc)
+		if err != nil || event.Title == "" {
+			logger.Warnf("Skipping calendar event %q with date %q", found.Title, found.Date)
+			continue
+		}
+		if startTime := strings.TrimSpace(found.StartTime); startTime == "" {
+			event.AllDay = true
+			event.Start = day
+			event.End = day.AddDate(0, 0, 1)
+		} else {
+			clock, err := time.Parse("15:04", startTime)
+			if err != nil {
+				logger.Warnf("Skipping calendar event %q with start time %q", found.Title, found.StartTime)
+				continue
+			}
+			event.Start = time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+			minutes := found.DurationMinutes
+			if minutes <= 0 {
+				minutes = defaultCalendarEventMinutes
+			}
+			event.End = event.Start.Add(time.Duration(minutes) * time.Minute)
+		}
+		// Events of the past are not worth adding to a calendar
+		if event.End.Before(startOfDay) {
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// calendarEventDedupeKey returns what tells an event of a chat apart: its start and, for all-day events, its
+// title, so a meeting mentioned again on a later day under another name is not added twice
+func calendarEventDedupeKey(event CalendarEvent) string {
+	if !event.AllDay {
+		return event.Start.UTC().Format(time.RFC3339)
+	}
+	title := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, event.Title)
+	return event.Start.Format("2006-01-02") + " " + title
+}
+
+// saveCalendarEvent stores an event, giving it a UID; false when the chat already has it
+func saveCalendarEvent(db *sql.DB, event *CalendarEvent) (bool, error) {
+	event.UID = uuid.New().String()
+	result, err := db.Exec(`
+		INSERT INTO calendar_events (uid, chat_jid, dedupe_key, title, starts_at, ends_at, all_day, location, description, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chat_jid, dedupe_key) DO NOTHING
+	`, event.UID, event.ChatJID, calendarEventDedupeKey(*event), event.Title, event.Start, event.End, event.AllDay, event.Location, event.Description, time.Now())
+	if err != nil {
+		return false, err
+	}
+	inserted, _ := result.RowsAffected()
+	return inserted > 0, nil
+}
+
+// exportCalendarEvent writes an event's .ics file and pushes it to the CalDAV calendar, when there is one,
+// recording where it went
+func exportCalendarEvent(ctx context.Context, db *sql.DB, event *CalendarEvent, logger waLog.Logger) {
+	ics := renderICS(*event, time.Now())
+	dir := calendarICSDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Warnf("Failed to create %s: %v", dir, err)
+	} else {
+		path := filepath.Join(dir, event.Start.Format("2006-01-02")+"-"+event.UID+".ics")
+		if err := os.WriteFile(path, []byte(ics), 0644); err != nil {
+			logger.Warnf("Failed to write %s: %v", path, err)
+		} else {
+			event.ICSPath = path
+			db.Exec("UPDATE calendar_events SET ics_path = ? WHERE uid = ?", path, event.UID)
+		}
+	}
+
+	if os.Getenv("CALDAV_URL") == "" {
+		return
+	}
+	if err := pushCalDAVEvent(ctx, event.UID, ics); err != nil {
+		logger.Warnf("Failed to push calendar event %q: %v", event.Title, err)
+		db.Exec("UPDATE calendar_events SET push_error = ? WHERE uid = ?", err.Error(), event.UID)
+		return
+	}
+	db.Exec("UPDATE calendar_events SET pushed_at = ?, push_error = '' WHERE uid = ?", time.Now(), event.UID)
+}
+
+// renderICS renders an event as an iCalendar file with a single VEVENT, times in UTC
+func renderICS(event CalendarEvent, stamp time.Time) string {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//whatsapp-mcp//calendar-events//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"BEGIN:VEVENT",
+		"UID:" + event.UID,
+		"DTSTAMP:" + stamp.UTC().Format("20060102T150405Z"),
+	}
+	if event.AllDay {
+		lines = append(lines,
+			"DTSTART;VALUE=DATE:"+event.Start.Format("20060102"),
+			"DTEND;VALUE=DATE:"+event.End.Format("20060102"))
+	} else {
+		lines = append(lines,
+			"DTSTART:"+event.Start.UTC().Format("20060102T150405Z"),
+			"DTEND:"+event.End.UTC().Format("20060102T150405Z"))
+	}
+	lines = append(lines, "SUMMARY:"+escapeICSText(event.Title))
+	if event.Location != "" {
+		lines = append(lines, "LOCATION:"+escapeICSText(event.Location))
+	}
+	if event.Description != "" {
+		lines = append(lines, "DESCRIPTION:"+escapeICSText(event.Description))
+	}
+	lines = append(lines, "END:VEVENT", "END:VCALENDAR")
+
+	var ics strings.Builder
+	for _, line := range lines {
+		ics.WriteString(foldICSLine(line))
+		ics.WriteString("\r\n")
+	}
+	return ics.String()
+}
+
+// escapeICSText escapes an iCalendar TEXT value
+func escapeICSText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// foldICSLine splits a content line longer than 75 octets into continuation lines, without breaking a UTF-8
+// character
+func foldICSLine(line string) string {
+	var folded strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > 75 {
+			folded.WriteString("\r\n ")
+			width = 1
+		}
+		folded.WriteRune(r)
+		width += size
+	}
+	return folded.String()
+}
+
+// pushCalDAVEvent creates an event on the CalDAV calendar at CALDAV_URL, e.g.
+// https://apidata.googleusercontent.com/caldav/v2/<calendar id>/events for Google Calendar
+func pushCalDAVEvent(ctx context.Context, uid, ics string) error {
+	endpoint := strings.TrimRight(os.Getenv("CALDAV_URL"), "/") + "/" + url.PathEscape(uid) + ".ics"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, strings.NewReader(ics))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/calendar; charset=utf-8")
+	// Never overwrite an event of the calendar
+	req.Header.Set("If-None-Match", "*")
+	if err := authorizeCalDAVRequest(ctx, req); err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("CalDAV server returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// authorizeCalDAVRequest signs a CalDAV request: with an access token from GOOGLE_REFRESH_TOKEN (and
+// GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET), CALDAV_TOKEN as a bearer token, or CALDAV_USERNAME and CALDAV_PASSWORD
+func authorizeCalDAVRequest(ctx context.Context, req *http.Request) error {
+	switch {
+	case os.Getenv("GOOGLE_REFRESH_TOKEN") != "":
+		token, err := googleAccessToken(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case os.Getenv("CALDAV_TOKEN") != "":
+		req.Header.Set("Authorization", "Bearer "+os.Getenv("CALDAV_TOKEN"))
+	case os.Getenv("CALDAV_USERNAME") != "":
+		req.SetBasicAuth(os.Getenv("CALDAV_USERNAME"), os.Getenv("CALDAV_PASSWORD"))
+	}
+	return nil
+}
+
+// googleAccessToken exchanges GOOGLE_REFRESH_TOKEN for an OAuth access token
+func googleAccessToken(ctx context.Context) (string, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {os.Getenv("GOOGLE_REFRESH_TOKEN")},
+		"client_id":     {os.Getenv("GOOGLE_CLIENT_ID")},
+		"client_secret": {os.Getenv("GOOGLE_CLIENT_SECRET")},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://oauth2.googleapis.com/token", bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("error creating token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error requesting Google access token: %v", err)
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("error parsing Google token response: %v", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("Google returned no access token (HTTP %d): %s", resp.StatusCode, token.Error)
+	}
+	return token.AccessToken, nil
+}
+
+// formatCalendarEventsSection renders the new events listed under the summary
+func formatCalendarEventsSection(events []CalendarEvent) string {
+	lines := []string{"📅 *New events*"}
+	for _, event := range events {
+		when := event.Start.Format("Mon Jan 2")
+		if !event.AllDay {
+			when += ", " + event.Start.Format("15:04") + "–" + event.End.Format("15:04")
+		}
+		line := fmt.Sprintf("• %s: %s", when, event.Title)
+		if event.Location != "" {
+			line += " (" + event.Location + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// MissedCalls is how many calls from one caller went unanswered
+type MissedCalls struct {
+	Caller string
+	Count  int
+	Last   time.Time
+}
+
+// ensureCallsTable creates the call log
+func ensureCallsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS calls (
+			call_id TEXT PRIMARY KEY,
+			chat_jid TEXT,
+			caller TEXT,
+			is_from_me BOOLEAN,
+			is_video BOOLEAN,
+			is_group BOOLEAN,
+			started_at TIMESTAMP,
+			accepted_at TIMESTAMP,
+			ended_at TIMESTAMP,
+			duration_seconds INTEGER DEFAULT 0,
+			missed BOOLEAN DEFAULT 0,
+			end_reason TEXT DEFAULT ''
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_calls_started_at ON calls (started_at);
+	`)
+	return err
+}
+
+// missedCallsInSummary reports whether the daily summary sent to the self-chat lists the day's missed calls
+// (DAILY_SUMMARY_MISSED_CALLS, on by default)
+func missedCallsInSummary() bool {
+	return os.Getenv("DAILY_SUMMARY_MISSED_CALLS") != "false"
+}
+
+// callerJID returns who started a call, by phone number when WhatsApp only gave its LID
+func callerJID(client *whatsmeow.Client, creator types.JID) types.JID {
+	creator = creator.ToNonAD()
+	if creator.Server == types.HiddenUserServer {
+		if pn, err := client.Store.LIDs.GetPNForLID(context.Background(), creator); err == nil && !pn.IsEmpty() {
+			return pn.ToNonAD()
+		}
+	}
+	return creator
+}
+
+// recordCallStart records an offered call; a call offered again (e.g. to each of my devices) keeps its first record
+func recordCallStart(client *whatsmeow.Client, db *sql.DB, meta types.BasicCallMeta, video, group bool) error {
+	caller := callerJID(client, meta.CallCreator)
+	chat := caller
+	if !meta.GroupJID.IsEmpty() {
+		chat, group = meta.GroupJID, true
+	}
+	fromMe := client.Store.ID != nil && caller.User == client.Store.ID.User
+	_, err := db.Exec(`
+		INSERT OR IGNORE INTO calls (call_id, chat_jid, caller, is_from_me, is_video, is_group, started_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, meta.CallID, chat.String(), caller.String(), fromMe, video, group, meta.Timestamp)
+	return err
+}
+
+// recordCallAccept records when a call was answered, on any of my devices or by the person called
+func recordCallAccept(db *sql.DB, callID string, acceptedAt time.Time) error {
+	_, err := db.Exec("UPDATE calls SET accepted_at = ? WHERE call_id = ? AND accepted_at IS NULL", acceptedAt, callID)
+	return err
+}
+
+// recordCallEnd records how a call ended and how long it lasted. An incoming call that ended without being
+// answered or declined is missed.
+func recordCallEnd(db *sql.DB, callID string, endedAt time.Time, reason string) error {
+	var acceptedAt sql.NullTime
+	var fromMe bool
+	err := db.QueryRow("SELECT accepted_at, is_from_me FROM calls WHERE call_id = ? AND ended_at IS NULL", callID).Scan(&acceptedAt, &fromMe)
+	if err == sql.ErrNoRows {
+		// Ended already, or started before the bridge was running
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	duration := 0
+	if acceptedAt.Valid {
+		duration = int(endedAt.Sub(acceptedAt.Time).Seconds())
+	}
+	missed := !acceptedAt.Valid && !fromMe && reason != "reject"
+	_, err = db.Exec("UPDATE calls SET ended_at = ?, end_reason = ?, duration_seconds = ?, missed = ? WHERE call_id = ?",
+		endedAt, reason, max(duration, 0), missed, callID)
+	return err
+}
+
+// handleCallEvent records the call offers, answers and ends whatsmeow reports
+func handleCallEvent(client *whatsmeow.Client, db *sql.DB, evt interface{}, logger waLog.Logger) {
+	var err error
+	var callID string
+	switch v := evt.(type) {
+	case *events.CallOffer:
+		// 1:1 call offers list the media they start with
+		callID = v.CallID
+		video := v.Data != nil && v.Data.GetChildByTag("video").Tag == "video"
+		err = recordCallStart(client, db, v.BasicCallMeta, video, false)
+		if err == nil {
+			fmt.Printf("[%s] 📞 %s call from %s\n", v.Timestamp.Format("2006-01-02 15:04:05"), callMedia(video), callerJID(client, v.CallCreator).User)
+		}
+	case *events.CallOfferNotice:
+		callID = v.CallID
+		err = recordCallStart(client, db, v.BasicCallMeta, v.Media == "video", v.Type == "group")
+	case *events.CallAccept:
+		callID = v.CallID
+		err = recordCallAccept(db, v.CallID, v.Timestamp)
+	case *events.CallReject:
+		callID = v.CallID
+		err = recordCallEnd(db, v.CallID, v.Timestamp, "reject")
+	case *events.CallTerminate:
+		callID = v.CallID
+		err = recordCallEnd(db, v.CallID, v.Timestamp, v.Reason)
+	}
+	if err != nil {
+		logger.Warnf("Failed to record call %s: %v", callID, err)
+	}
+}
+
+// callMedia names the kind of a call
+func callMedia(video bool) string {
+	if video {
+		return "video"
+	}
+	return "voice"
+}
+
+// getMissedCalls returns the callers whose calls went unanswered in a period, most missed first
+func getMissedCalls(db *sql.DB, start, end time.Time) ([]MissedCalls, error) {
+	rows, err := db.Query(`
+		SELECT caller, COUNT(*), MAX(started_at)
+		FROM calls
+		WHERE missed AND started_at BETWEEN ? AND ?
+		GROUP BY caller
+		ORDER BY COUNT(*) DESC, MAX(started_at) DESC
+	`, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var missed []MissedCalls
+	for rows.Next() {
+		var calls MissedCalls
+		var last string
+		if err := rows.Scan(&calls.Caller, &calls.Count, &last); err != nil {
+			return nil, err
+		}
+		calls.Last = parseSQLiteTime(last)
+		missed = append(missed, calls)
+	}
+	return missed, rows.Err()
+}
+
+// formatMissedCallsLine renders the missed calls as one line for the daily summary, e.g.
+// "📞 Missed calls: Ana (2), 5511912345678"; "" when there were none. nameOf returns a caller's name, if known.
+func formatMissedCallsLine(missed []MissedCalls, nameOf func(jid string) string) string {
+	if len(missed) == 0 {
+		return ""
+	}
+	var callers []string
+	for _, calls := range missed {
+		name := nameOf(calls.Caller)
+		if name == "" {
+			name = strings.Split(calls.Caller, "@")[0]
+		}
+		if calls.Count > 1 {
+			name = fmt.Sprintf("%s (%d)", name, calls.Count)
+		}
+		callers = append(callers, name)
+	}
+	return "📞 Missed calls: " + strings.Join(callers, ", ")
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// CampaignRecipient represents one row of the campaign CSV
+type CampaignRecipient struct {
+	JID    string
+	Fields map[string]string
+}
+
+var (
+	campaignName     = flag.String("name", "", "Campaign name, used to track deliveries and resume (required)")
+	recipientsCSV    = flag.String("csv", "", "CSV file with a header row and a 'phone' or 'jid' column")
+	recipientSegment = flag.String("segment", "", "Contact segment to message instead of a CSV (see the segments tool)")
+	recipientList    = flag.String("broadcast", "", "Broadcast list (JID, name or tag) whose members to message instead of a CSV")
+	templateFile     = flag.String("template", "", "Message template file; {{column}} placeholders are replaced per recipient (required)")
+	ratePerMinute    = flag.Int("rate", 6, "Maximum messages sent per minute")
+	jitterSeconds    = flag.Int("jitter", 5, "Random extra delay in seconds added between messages")
+	maxMessages      = flag.Int("max", 200, "Maximum messages sent in this run")
+	optOutKeywords   = flag.String("optout-keywords", "stop,sair,parar,unsubscribe", "Comma-separated keywords that opt a contact out when received in their chat")
+	optOutFooter     = flag.String("optout-footer", "", "Optional footer appended to every message (e.g. how to opt out)")
+	campaignDryRun   = flag.Bool("dry-run", false, "Render messages and show recipients without sending")
+	campaignShowOnly = flag.Bool("status", false, "Show delivery status of the campaign and exit")
+)
+
+func main() {
+	flag.Parse()
+
+	logger := newLogger("Campaign", "INFO")
+
+	if exitIfSafeMode(logger) {
+		return
+	}
+
+	if *campaignName == "" {
+		logger.Errorf("--name is required")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// Open SQLite database for messages
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		logger.Errorf("Failed to open message database: %v", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	if err := ensureCampaignTables(db); err != nil {
+		logger.Errorf("Failed to create campaign tables: %v", err)
+		os.Exit(1)
+	}
+
+	if *campaignShowOnly {
+		if err := printCampaignStatus(db, *campaignName); err != nil {
+			logger.Errorf("Failed to show campaign status: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	sources := 0
+	for _, source := range []string{*recipientsCSV, *recipientSegment, *recipientList} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 || *templateFile == "" {
+		logger.Errorf("--template and exactly one of --csv, --segment or --broadcast are required")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	templateBytes, err := os.ReadFile(*templateFile)
+	if err != nil {
+		logger.Errorf("Failed to read template: %v", err)
+		os.Exit(1)
+	}
+	template := strings.TrimSpace(string(templateBytes))
+
+	var recipients []CampaignRecipient
+	if *recipientSegment != "" {
+		recipients, err = loadSegmentRecipients(db, *recipientSegment)
+	} else if *recipientList != "" {
+		recipients, err = loadBroadcastRecipients(db, *recipientList)
+	} else {
+		recipients, err = loadCampaignRecipients(*recipientsCSV)
+	}
+	if err != nil {
+		logger.Errorf("Failed to load recipients: %v", err)
+		os.Exit(1)
+	}
+	logger.Infof("Loaded %d recipients for campaign %s", len(recipients), *campaignName)
+
+	// Record opt-outs received since the last run
+	keywords := parseOptOutKeywords(*optOutKeywords)
+	optedOut, err := refreshCampaignOptOuts(db, keywords)
+	if err != nil {
+		logger.Errorf("Failed to check opt-outs: %v", err)
+		os.Exit(1)
+	}
+
+	// Setup graceful shutdown so an interrupted campaign can be resumed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		logger.Infof("Received shutdown signal, stopping after the current message...")
+		cancel()
+	}()
+
+	interval := time.Minute / time.Duration(max(*ratePerMinute, 1))
+	sentCount, skippedCount, failedCount := 0, 0, 0
+
+	for _, recipient := range recipients {
+		if ctx.Err() != nil {
+			break
+		}
+		if sentCount >= *maxMessages {
+			logger.Infof("Reached the per-run limit of %d messages, run again to continue", *maxMessages)
+			break
+		}
+
+		if optedOut[recipient.JID] {
+			logger.Infof("Skipping %s: opted out", recipient.JID)
+			recordCampaignDelivery(db, *campaignName, recipient.JID, "opted_out", "", "")
+			skippedCount++
+			continue
+		}
+
+		// Resume support: never message the same recipient twice in one campaign
+		var status string
+		err := db.QueryRow("SELECT status FROM campaign_deliveries WHERE campaign = ? AND recipient = ?", *campaignName, recipient.JID).Scan(&status)
+		if err == nil && status == "sent" {
+			skippedCount++
+			continue
+		}
+
+		message := renderCampaignMessage(template, recipient.Fields)
+		if *optOutFooter != "" {
+			message += "\n\n" + *optOutFooter
+		}
+
+		if *campaignDryRun {
+			logger.Infof("DRY RUN: %s <- %s", recipient.JID, message)
+			continue
+		}
+
+		if err := sendViaBridgeAPI(recipient.JID, message); err != nil {
+			logger.Errorf("Failed to send to %s: %v", recipient.JID, err)
+			recordCampaignDelivery(db, *campaignName, recipient.JID, "failed", message, err.Error())
+			failedCount++
+		} else {
+			logger.Infof("Sent to %s", recipient.JID)
+			recordCampaignDelivery(db, *campaignName, recipient.JID, "sent", message, "")
+			sentCount++
+		}
+
+		// Rate limit with random jitter between messages
+		delay := interval
+		if *jitterSeconds > 0 {
+			delay += time.Duration(rand.Intn(*jitterSeconds*1000)) * time.Millisecond
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+
+	logger.Infof("Campaign %s finished this run: %d sent, %d skipped, %d failed", *campaignName, sentCount, skippedCount, failedCount)
+}
+
+// ensureCampaignTables creates the delivery tracking and opt-out tables if they don't exist
+func ensureCampaignTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_deliveries (
+			campaign TEXT,
+			recipient TEXT,
+			status TEXT,
+			message TEXT,
+			error TEXT,
+			updated_at TIMESTAMP,
+			PRIMARY KEY (campaign, recipient)
+		);
+
+		CREATE TABLE IF NOT EXISTS campaign_optouts (
+			jid TEXT PRIMARY KEY,
+			keyword TEXT,
+			opted_out_at TIMESTAMP
+		);
+	`)
+	return err
+}
+
+// loadCampaignRecipients reads the recipients CSV; the header row names the template placeholders
+func loadCampaignRecipients(path string) ([]CampaignRecipient, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+
+	var recipients []CampaignRecipient
+	seen := make(map[string]bool)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CSV: %v", err)
+		}
+
+		fields := make(map[string]string)
+		for i, value := range record {
+			if i < len(header) {
+				fields[header[i]] = strings.TrimSpace(value)
+			}
+		}
+
+		jid := fields["jid"]
+		if jid == "" {
+			phone := strings.NewReplacer("+", "", " ", "", "-", "", "(", "", ")", "").Replace(fields["phone"])
+			if phone == "" {
+				continue
+			}
+			jid = phone + "@s.whatsapp.net"
+		}
+
+		if seen[jid] {
+			continue
+		}
+		seen[jid] = true
+
+		recipients = append(recipients, CampaignRecipient{JID: jid, Fields: fields})
+	}
+
+	return recipients, nil
+}
+
+// loadSegmentRecipients resolves a contact segment into campaign recipients with jid, phone and name placeholders
+func loadSegmentRecipients(db *sql.DB, segment string) ([]CampaignRecipient, error) {
+	if err := ensureSegmentTables(db); err != nil {
+		return nil, err
+	}
+
+	members, err := resolveSegment(db, segment)
+	if err != nil {
+		return nil, err
+	}
+	return contactRecipients(db, members), nil
+}
+
+// loadBroadcastRecipients turns a broadcast list's members into campaign recipients
+func loadBroadcastRecipients(db *sql.DB, list string) ([]CampaignRecipient, error) {
+	if err := ensureBroadcastTables(db); err != nil {
+		return nil, err
+	}
+
+	members, err := resolveBroadcastList(db, list)
+	if err != nil {
+		return nil, err
+	}
+	return contactRecipients(db, members), nil
+}
+
+// contactRecipients builds campaign recipients with jid, phone and name placeholders
+func contactRecipients(db *sql.DB, members []string) []CampaignRecipient {
+	var recipients []CampaignRecipient
+	for _, jid := range members {
+		phone := strings.Split(jid, "@")[0]
+
+		// Use the chat name as {{name}} when we have one, falling back to the phone number
+		var name sql.NullString
+		db.QueryRow("SELECT name FROM chats WHERE jid = ?", jid).Scan(&name)
+		if !name.Valid || name.String == "" {
+			name.String = phone
+		}
+
+		recipients = append(recipients, CampaignRecipient{
+			JID:    jid,
+			Fields: map[string]string{"jid": jid, "phone": phone, "name": name.String},
+		})
+	}
+
+	return recipients
+}
+
+// renderCampaignMessage replaces {{column}} placeholders with the recipient's CSV values
+func renderCampaignMessage(template string, fields map[string]string) string {
+	message := template
+	for key, value := range fields {
+		message = strings.ReplaceAll(message, "{{"+key+"}}", value)
+	}
+	return message
+}
+
+// parseOptOutKeywords splits and normalizes the opt-out keyword list
+func parseOptOutKeywords(list string) []string {
+	var keywords []string
+	for _, keyword := range strings.Split(list, ",") {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return keywords
+}
+
+// refreshCampaignOptOuts records contacts who replied with an opt-out keyword and returns all opted-out JIDs
+func refreshCampaignOptOuts(db *sql.DB, keywords []string) (map[string]bool, error) {
+	for _, keyword := range keywords {
+		_, err := db.Exec(`
+			INSERT OR IGNORE INTO campaign_optouts (jid, keyword, opted_out_at)
+			SELECT chat_jid, ?, MIN(timestamp)
+			FROM messages
+			WHERE is_from_me = 0
+			AND chat_jid LIKE '%@s.whatsapp.net'
+			AND LOWER(TRIM(content)) = ?
+			GROUP BY chat_jid
+		`, keyword, keyword)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record opt-outs for keyword %q: %v", keyword, err)
+		}
+	}
+	if err := refreshDirectStoreOptOuts(db, keywords); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT jid FROM campaign_optouts")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	optedOut := make(map[string]bool)
+	for rows.Next() {
+		var jid string
+		if err := rows.Scan(&jid); err != nil {
+			return nil, err
+		}
+		optedOut[jid] = true
+	}
+
+	return optedOut, nil
+}
+
+// refreshDirectStoreOptOuts records the opt-outs replied in direct chats kept in their own database
+// (DIRECT_MESSAGES_DB). Their text may be encrypted, so the replies are matched after decrypting them.
+func refreshDirectStoreOptOuts(db *sql.DB, keywords []string) error {
+	directDB, directCipher, err := openSharedDirectMessagesDB()
+	if err != nil || directDB == nil {
+		return err
+	}
+
+	rows, err := directDB.Query(`
+		SELECT chat_jid, timestamp, content FROM messages
+		WHERE is_from_me = 0 AND chat_jid LIKE '%@s.whatsapp.net' AND COALESCE(content, '') != ''
+		ORDER BY timestamp
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to read direct messages for opt-outs: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chatJID, content string
+		var timestamp time.Time
+		if err := rows.Scan(&chatJID, &timestamp, &content); err != nil {
+			return fmt.Errorf("failed to read direct message: %v", err)
+		}
+		reply := strings.ToLower(strings.TrimSpace(openDirectMessageText(directCipher, content)))
+		for _, keyword := range keywords {
+			if reply != keyword {
+				continue
+			}
+			// Oldest first, so the first opt-out of a contact is the one kept
+			if _, err := db.Exec(
+				"INSERT OR IGNORE INTO campaign_optouts (jid, keyword, opted_out_at) VALUES (?, ?, ?)",
+				chatJID, keyword, timestamp,
+			); err != nil {
+				return fmt.Errorf("failed to record opt-out of %s: %v", chatJID, err)
+			}
+			break
+		}
+	}
+	return rows.Err()
+}
+
+// recordCampaignDelivery stores the delivery status of a campaign message
+func recordCampaignDelivery(db *sql.DB, campaign, recipient, status, message, errText string) {
+	db.Exec(
+		"INSERT OR REPLACE INTO campaign_deliveries (campaign, recipient, status, message, error, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		campaign, recipient, status, message, errText, time.Now(),
+	)
+}
+
+// printCampaignStatus prints delivery counts and failures for a campaign
+func printCampaignStatus(db *sql.DB, campaign string) error {
+	rows, err := db.Query("SELECT status, COUNT(*) FROM campaign_deliveries WHERE campaign = ? GROUP BY status ORDER BY status", campaign)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	fmt.Printf("Campaign: %s\n", campaign)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return err
+		}
+		fmt.Printf("  %-10s %d\n", status, count)
+	}
+
+	failed, err := db.Query("SELECT recipient, COALESCE(error, '') FROM campaign_deliveries WHERE campaign = ? AND status = 'failed'", campaign)
+	if err != nil {
+		return err
+	}
+	defer failed.Close()
+	for failed.Next() {
+		var recipient, errText string
+		if err := failed.Scan(&recipient, &errText); err != nil {
+			return err
+		}
+		fmt.Printf("  failed: %s (%s)\n", recipient, errText)
+	}
+
+	return nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Built-in pattern for "what did I miss" questions, in English and Portuguese; catch_up_pattern replaces it
+const defaultCatchUpPattern = `(?i)(what did i miss|what('?s| is| was| has been) (going on|happening)|(can|could) (someone|anyone|somebody) (summari[sz]e|recap|catch me up)|catch me up|\btl;?dr\b|o que (eu )?perdi|o que (que )?rolou|qu[eê] (que )?rolou|algu[eé]m (faz|pode fazer|manda) (um )?resumo|resumo (do|da|de) (dia|hoje|conversa|grupo))`
+
+const (
+	// Questions older than this when they reach the bridge (e.g. received while offline) are not answered
+	catchUpMaxQuestionAge = 10 * time.Minute
+
+	// Questions longer than this are not sent to the classifier
+	catchUpClassifierMaxChars = 200
+
+	// Most recent messages of the period given to the LLM
+	catchUpMaxMessages = 400
+)
+
+const catchUpClassifierPrompt = `Is this WhatsApp group message asking for a recap or summary of what was discussed in the group recently? Answer only "yes" or "no".
+
+<message>
+%s
+</message>`
+
+const catchUpPrompt = `Someone in the WhatsApp group "%s" asked what they missed. Write a short recap of the messages below, from the last %d hours, to post in the group.
+
+Cover the main topics, any decisions and the questions still open, as a few bullet points with WhatsApp formatting (*bold*, _italic_). Write in the language of the conversation. Don't include links or URLs, don't mention who asked, and only use what is in the messages.
+
+Return only the recap.
+
+<messages>
+%s
+</messages>`
+
+var (
+	catchUpLinkPattern   = regexp.MustCompile(`(?i)\bhttps?://\S+|\bwww\.\S+`)
+	catchUpSpacesPattern = regexp.MustCompile(`(\S) {2,}`) // runs of spaces inside a line, leaving indentation alone
+)
+
+// CatchUp answers "what did I miss" questions in the groups configured with catch_up
+type CatchUp struct {
+	mu       sync.Mutex
+	patterns map[string]*regexp.Regexp // compiled catch_up_pattern, by pattern
+	inFlight map[string]bool           // groups with a recap being written
+	loc      *time.Location
+
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	logger       waLog.Logger
+}
+
+// ensureCatchUpTable creates the table counting the recaps sent per group and day
+func ensureCatchUpTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS catch_up_replies (
+			chat_jid TEXT,
+			date TEXT,
+			count INTEGER DEFAULT 0,
+			PRIMARY KEY (chat_jid, date)
+		);
+	`)
+	return err
+}
+
+// startCatchUp starts answering "what did I miss" questions when a group has catch_up enabled
+func startCatchUp(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) error {
+	config, err := loadBridgeConfig()
+	if err != nil {
+		return err
+	}
+	enabled := false
+	for _, group := range config.Groups {
+		if group.CatchUp != nil && *group.CatchUp {
+			enabled = true
+		}
+	}
+	if !enabled {
+		return nil
+	}
+	if err := ensureCatchUpTable(messageStore.db); err != nil {
+		return fmt.Errorf("failed to create catch-up table: %v", err)
+	}
+
+	loc := time.Local
+	if timezone := os.Getenv("DAILY_SUMMARY_TIMEZONE"); timezone != "" {
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			logger.Warnf("Failed to load timezone %s, counting recaps per day in local time: %v", timezone, err)
+			loc = time.Local
+		}
+	}
+
+	catchUp := &CatchUp{
+		patterns:     make(map[string]*regexp.Regexp),
+		inFlight:     make(map[string]bool),
+		loc:          loc,
+		client:       client,
+		messageStore: messageStore,
+		logger:       logger,
+	}
+
+	events := eventHub.Subscribe()
+	go func() {
+		for event := range events {
+			if event.Type != "message" || !event.IsGroup || event.IsFromMe || event.Origin != "" || event.Content == "" {
+				continue
+			}
+			group := config.getGroupConfig(event.ChatJID)
+			if group.CatchUp == nil || !*group.CatchUp {
+				continue
+			}
+			if settings, err := getChatSettings(messageStore.db, event.ChatJID); err == nil && settings.Muted {
+				continue
+			}
+			go catchUp.handle(event, group)
+		}
+	}()
+	logger.Infof("Answering \"what did I miss\" questions in the configured groups")
+	return nil
+}
+
+// pattern returns the compiled question pattern of a group
+func (c *CatchUp) pattern(group GroupConfig) (*regexp.Regexp, error) {
+	source := group.CatchUpPattern
+	if source == "" {
+		source = defaultCatchUpPattern
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pattern, ok := c.patterns[source]; ok {
+		return pattern, nil
+	}
+	pattern, err := regexp.Compile(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid catch_up_pattern %q: %v", source, err)
+	}
+	c.patterns[source] = pattern
+	return pattern, nil
+}
+
+// isCatchUpQuestion reports whether a message asks what was missed: a regex match, or the classifier's yes
+// for a short question when catch_up_classifier is on
+func (c *CatchUp) isCatchUpQuestion(event StreamEvent, group GroupConfig) bool {
+	pattern, err := c.pattern(group)
+	if err != nil {
+		c.logger.Warnf("%v", err)
+		return false
+	}
+	if pattern.MatchString(event.Content) {
+		return true
+	}
+	if group.CatchUpClassifier == nil || !*group.CatchUpClassifier {
+		return false
+	}
+	if !strings.Contains(event.Content, "?") || len([]rune(event.Content)) > catchUpClassifierMaxChars || isSafeModeActive() {
+		return false
+	}
+
+	ctx := withLLMPurpose(withLLMAuditScope(context.Background(), event.ChatJID, time.Now().Format("2006-01-02")), "catch_up_classifier")
+	response, err := callLLM(ctx, fmt.Sprintf(catchUpClassifierPrompt, event.Content))
+	if err != nil {
+		c.logger.Warnf("Catch-up classifier failed for %s: %v", event.ID, err)
+		return false
+	}
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(response)), "yes")
+}
+
+// handle answers a group message with a recap when it asks what was missed and the group's daily limit allows it
+func (c *CatchUp) handle(event StreamEvent, group GroupConfig) {
+	if time.Since(event.Timestamp) > catchUpMaxQuestionAge {
+		return
+	}
+	date := time.Now().In(c.loc).Format("2006-01-02")
+	limit := 3
+	if group.CatchUpPerDay != nil {
+		limit = *group.CatchUpPerDay
+	}
+	// Checked before the question too, so a group at its limit costs no classifier calls
+	if sent, err := c.recapsSent(event.ChatJID, date); err != nil || sent >= limit {
+		return
+	}
+	if !c.isCatchUpQuestion(event, group) {
+		return
+	}
+	if isSafeModeActive() {
+		c.logger.Infof("Safe mode is on, not answering catch-up question %s", event.ID)
+		return
+	}
+	if readOnlyModeEnabled() {
+		c.logger.Infof("Read-only mode is on, not answering catch-up question %s", event.ID)
+		return
+	}
+
+	// One recap at a time per group, so a burst of questions gets one answer
+	c.mu.Lock()
+	if c.inFlight[event.ChatJID] {
+		c.mu.Unlock()
+		return
+	}
+	c.inFlight[event.ChatJID] = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.inFlight, event.ChatJID)
+		c.mu.Unlock()
+	}()
+
+	sent, err := c.recapsSent(event.ChatJID, date)
+	if err != nil {
+		c.logger.Warnf("Failed to read catch-up count of %s: %v", event.ChatJID, err)
+		return
+	}
+	if sent >= limit {
+		c.logger.Infof("Catch-up limit of %d recaps reached today in %s, not answering %s", limit, event.ChatJID, event.ID)
+		return
+	}
+
+	hours := 12
+	if group.CatchUpHours != nil && *group.CatchUpHours > 0 {
+		hours = *group.CatchUpHours
+	}
+	stopTyping := func() {}
+	if chat, err := types.ParseJID(event.ChatJID); err == nil {
+		stopTyping = startTyping(c.client, chat, c.logger)
+	}
+	recap, err := c.recap(event, hours)
+	stopTyping()
+	if err != nil {
+		c.logger.Errorf("Failed to write catch-up recap for %s: %v", event.ChatJID, err)
+		return
+	}
+	if recap == "" {
+		c.logger.Infof("Nothing to recap in %s for %s", event.ChatJID, event.ID)
+		return
+	}
+
+	reply := fmt.Sprintf("🕑 *Recap of the last %d hours*\n\n%s", hours, recap)
+	if success, status := sendWhatsAppMessage(c.client, c.messageStore, event.ChatJID, reply, "", messageOriginSummary, event.ID); !success {
+		c.logger.Errorf("Failed to send catch-up recap to %s: %s", event.ChatJID, status)
+		return
+	}
+	if _, err := c.messageStore.db.Exec(`
+		INSERT INTO catch_up_replies (chat_jid, date, count) VALUES (?, ?, 1)
+		ON CONFLICT (chat_jid, date) DO UPDATE SET count = count + 1
+	`, event.ChatJID, date); err != nil {
+		c.logger.Warnf("Failed to record catch-up recap for %s: %v", event.ChatJID, err)
+	}
+	c.logger.Infof("Sent catch-up recap of %d hours to %s (%d/%d today)", hours, event.ChatJID, sent+1, limit)
+}
+
+// recapsSent returns how many recaps were sent to a group on a date
+func (c *CatchUp) recapsSent(chatJID, date string) (int, error) {
+	var sent int
+	err := c.messageStore.db.QueryRow("SELECT count FROM catch_up_replies WHERE chat_jid = ? AND date = ?", chatJID, date).Scan(&sent)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return sent, err
+}
+
+// recap asks the LLM for a link-free recap of the messages of the last hours before a question,
+// or returns "" when the group was quiet
+func (c *CatchUp) recap(event StreamEvent, hours int) (string, error) {
+	rows, err := c.messageStore.db.Query(`
+		SELECT sender, timestamp, is_from_me, COALESCE(content, ''), COALESCE(transcript, ''), COALESCE(media_type, '')
+		FROM messages
+		WHERE chat_jid = ? AND timestamp >= ? AND timestamp < ? AND id != ?
+			AND COALESCE(message_type, '') = '' AND COALESCE(origin, '') = '' AND deleted_at IS NULL
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`, event.ChatJID, event.Timestamp.Add(-time.Duration(hours)*time.Hour), event.Timestamp, event.ID, catchUpMaxMessages)
+	if err != nil {
+		return "", fmt.Errorf("failed to query messages: %v", err)
+	}
+	defer rows.Close()
+
+	names := make(map[string]string)
+	var lines []string
+	for rows.Next() {
+		var sender, content, transcript, mediaType string
+		var timestamp time.Time
+		var isFromMe bool
+		if err := rows.Scan(&sender, &timestamp, &isFromMe, &content, &transcript, &mediaType); err != nil {
+			return "", err
+		}
+		if transcript != "" {
+			content = strings.TrimSpace(content + " " + transcript)
+		}
+		if content == "" && mediaType != "" {
+			content = "[" + mediaType + "]"
+		}
+		name, ok := names[sender]
+		if !ok {
+			name = c.senderName(sender, isFromMe)
+			names[sender] = name
+		}
+		lines = append([]string{fmt.Sprintf("[%s] %s: %s", timestamp.In(c.loc).Format("15:04"), name, content)}, lines...)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+
+	chatName := event.ChatName
+	if chatName == "" {
+		chatName = event.ChatJID
+	}
+	ctx := withLLMPurpose(withLLMAuditScope(context.Background(), event.ChatJID, time.Now().Format("2006-01-02")), "catch_up")
+	response, err := callLLM(ctx, fmt.Sprintf(catchUpPrompt, chatName, hours, strings.Join(lines, "\n")))
+	if err != nil {
+		return "", err
+	}
+	return stripLinks(response), nil
+}
+
+// senderName returns the contact name of a sender, or the phone number when unknown
+func (c *CatchUp) senderName(sender string, isFromMe bool) string {
+	if isFromMe && c.client.Store.PushName != "" {
+		return c.client.Store.PushName
+	}
+	contact, err := c.client.Store.Contacts.GetContact(context.Background(), types.NewJID(sender, types.DefaultUserServer))
+	if err == nil {
+		if contact.FullName != "" {
+			return contact.FullName
+		}
+		if contact.PushName != "" {
+			return contact.PushName
+		}
+	}
+	return sender
+}
+
+// stripLinks removes URLs the LLM may have kept, tidying the spaces they leave behind
+func stripLinks(text string) string {
+	text = catchUpLinkPattern.ReplaceAllString(text, "")
+	text = catchUpSpacesPattern.ReplaceAllString(text, "$1 ")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
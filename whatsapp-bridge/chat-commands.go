@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// In-chat commands make the bridge an assistant inside WhatsApp: "/ask", "/search", "/tasks" and the like, answered
+// in the chat they were sent in. A chat only gets the commands its commands entry in config.json enables, and only
+// from me and the senders that entry allows. The admin chat's operator commands (/safemode, /import, /copilot)
+// are handled before these.
+
+const (
+	// Recent messages of the chat given to the LLM by /ask
+	chatCommandAskMessages = 200
+
+	// Hours of the chat covered by /tasks unless given
+	chatCommandTasksHours = 24
+
+	// Results /search answers with
+	chatCommandSearchResults = 5
+)
+
+const chatCommandAskPrompt = `You are an assistant inside the WhatsApp chat "%s". %s asked the question below. Answer it from the recent messages of the chat.
+
+Be brief, answer in the language of the question and use WhatsApp formatting (*bold*, _italic_). If the messages don't answer it, say so instead of guessing.
+
+<messages>
+%s
+</messages>
+
+Question: %s`
+
+const chatCommandTasksPrompt = `List the open tasks and requests in these messages of the WhatsApp chat "%s", from the last %d hours: what someone asked for or committed to and hasn't been done or answered yet.
+
+Give one line per task with who owns it and any deadline, as a WhatsApp list, in the language of the conversation. Leave out what was resolved. If there are none, say so in one line.
+
+<messages>
+%s
+</messages>`
+
+// ChatCommand is an in-chat command; Run returns the reply
+type ChatCommand struct {
+	Name        string // without the slash
+	Usage       string
+	Description string
+	Run         func(command *ChatCommandRequest) (string, error)
+}
+
+// ChatCommandRequest is a command sent in a chat, with what its handler needs to answer it
+type ChatCommandRequest struct {
+	Event        StreamEvent // the message with the command
+	Args         string      // the text after the command name
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	logger       waLog.Logger
+}
+
+// chatCommands are the in-chat commands; /help is answered by the router itself
+var chatCommands = []ChatCommand{
+	{Name: "ask", Usage: "/ask <question>", Description: "answer a question from the recent messages of this chat", Run: runAskCommand},
+	{Name: "search", Usage: "/search <words>", Description: "find messages of this chat", Run: runSearchCommand},
+	{Name: "tasks", Usage: "/tasks [hours]", Description: "list the open tasks and requests of the last hours (default 24)", Run: runTasksCommand},
+	{Name: "remind", Usage: "/remind me <when> to <what>", Description: "send a reminder in this chat, quoting your message; /remind list, /remind cancel <id>", Run: runRemindCommand},
+	{Name: "dates", Usage: "/dates [days]", Description: "list the contacts' birthdays and other dates of the next days (default 30); /dates add <contact> <date> [label], /dates remove <id>", Run: runDatesCommand},
+}
+
+// parseChatCommand splits a message into a command name, lowercased and without the slash, and its arguments
+func parseChatCommand(content string) (name, args string, ok bool) {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "/") {
+		return "", "", false
+	}
+	name = content[1:]
+	if space := strings.IndexFunc(name, unicode.IsSpace); space >= 0 {
+		name, args = name[:space], name[space:]
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, ":"))
+	return name, strings.TrimSpace(args), name != ""
+}
+
+// findChatCommand returns the command with a name, or nil
+func findChatCommand(name string) *ChatCommand {
+	for i := range chatCommands {
+		if chatCommands[i].Name == name {
+			return &chatCommands[i]
+		}
+	}
+	return nil
+}
+
+// chatCommandConfig returns the commands entry of a chat: its own (by JID, phone number, group name or "self"
+// for my own chat), or "default"
+func chatCommandConfig(config *BridgeConfig, chatJID, ownJID string) (CommandConfig, bool) {
+	for key, entry := range config.Commands {
+		if key == "default" {
+			continue
+		}
+		jid := ownJID
+		if !strings.EqualFold(key, "self") {
+			var err error
+			if jid, err = resolveChatArgument(key); err != nil {
+				continue
+			}
+		}
+		if jid == chatJID {
+			return entry, true
+		}
+	}
+	entry, ok := config.Commands["default"]
+	return entry, ok
+}
+
+// enables reports whether a chat's commands entry enables a command
+func (entry CommandConfig) enables(name string) bool {
+	return slices.Contains(entry.Enabled, "*") || slices.Contains(entry.Enabled, name)
+}
+
+// allows reports whether a sender may use a chat's commands; I always may
+func (entry CommandConfig) allows(sender string, isFromMe bool) bool {
+	if isFromMe {
+		return true
+	}
+	for _, allowed := range entry.Senders {
+		if allowed == "*" {
+			return true
+		}
+		user, _, _ := strings.Cut(allowed, "@")
+		if recipientConfigKey(user) == sender {
+			return true
+		}
+	}
+	return false
+}
+
+// handleChatCommand answers a message that is a command enabled in its chat, in the background. It returns false
+// for any other message, which is then handled as usual.
+func handleChatCommand(client *whatsmeow.Client, messageStore *MessageStore, event StreamEvent, logger waLog.Logger) bool {
+	name, args, ok := parseChatCommand(event.Content)
+	if !ok || (name != "help" && findChatCommand(name) == nil) {
+		return false
+	}
+	config, err := readBridgeConfig()
+	if err != nil {
+		logger.Warnf("In-chat commands unavailable: %v", err)
+		return false
+	}
+	entry, ok := chatCommandConfig(config, event.ChatJID, ownChatJID(client))
+	if !ok || (name != "help" && !entry.enables(name)) || (name == "help" && len(entry.Enabled) == 0) {
+		return false
+	}
+	if !entry.allows(event.Sender, event.IsFromMe) {
+		logger.Infof("Ignoring /%s from %s in %s, who may not use commands there", name, event.Sender, event.ChatJID)
+		return true
+	}
+	if isSafeModeActive() {
+		logger.Infof("Safe mode is on, not answering /%s in %s", name, event.ChatJID)
+		return true
+	}
+	if readOnlyModeEnabled() {
+		logger.Infof("Read-only mode is on, not answering /%s in %s", name, event.ChatJID)
+		return true
+	}
+
+	command := &ChatCommandRequest{Event: event, Args: args, client: client, messageStore: messageStore, logger: logger}
+	go func() {
+		var reply string
+		if name == "help" {
+			reply = chatCommandHelp(entry)
+		} else {
+			stopTyping := func() {}
+			if chat, err := types.ParseJID(event.ChatJID); err == nil {
+				stopTyping = startTyping(client, chat, logger)
+			}
+			var err error
+			reply, err = findChatCommand(name).Run(command)
+			stopTyping()
+			if err != nil {
+				logger.Errorf("Command /%s in %s failed: %v", name, event.ChatJID, err)
+				reply = fmt.Sprintf("❌ /%s failed: %v", name, err)
+			}
+		}
+		logger.Infof("Command /%s from %s in %s: %s", name, event.Sender, event.ChatJID, strings.SplitN(reply, "\n", 2)[0])
+		if success, status := sendWhatsAppMessage(client, messageStore, event.ChatJID, reply, "", messageOriginCommand, event.ID); !success {
+			logger.Errorf("Failed to answer /%s in %s: %s", name, event.ChatJID, status)
+		}
+	}()
+	return true
+}
+
+// chatCommandHelp lists the commands a chat enables
+func chatCommandHelp(entry CommandConfig) string {
+	lines := []string{"🤖 *Commands*"}
+	for _, command := range chatCommands {
+		if entry.enables(command.Name) {
+			lines = append(lines, fmt.Sprintf("%s — %s", command.Usage, command.Description))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// llmContext returns the context of the LLM calls of a command, audited under its chat and purpose
+func (command *ChatCommandRequest) llmContext(purpose string) context.Context {
+	ctx := withLLMAuditScope(context.Background(), command.Event.ChatJID, time.Now().Format("2006-01-02"))
+	return withLLMPurpose(ctx, purpose)
+}
+
+// transcript renders the messages of the command's chat before the command, oldest first, from since on (zero
+// for no limit) and at most limit of them
+func (command *ChatCommandRequest) transcript(since time.Time, limit int) (string, error) {
+	messages, err := command.messageStore.GetMessages(command.Event.ChatJID, limit+1)
+	if err != nil {
+		return "", fmt.Errorf("failed to load messages: %v", err)
+	}
+	var lines []string
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if msg.ID == command.Event.ID || msg.Time.Before(since) || msg.Time.After(command.Event.Timestamp) {
+			continue
+		}
+		sender := msg.Sender
+		if msg.IsFromMe {
+			sender = "Me"
+		}
+		content := msg.Content
+		if msg.Deleted {
+			content = "[deleted]"
+		} else if content == "" && msg.MediaType != "" {
+			content = "[" + msg.MediaType + "]"
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", msg.Time.Format("Jan 2 15:04"), sender, content))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// chatName returns the name of the command's chat, or its JID
+func (command *ChatCommandRequest) chatName() string {
+	if command.Event.ChatName != "" {
+		return command.Event.ChatName
+	}
+	return command.Event.ChatJID
+}
+
+// runAskCommand answers "/ask <question>" from the recent messages of the chat
+func runAskCommand(command *ChatCommandRequest) (string, error) {
+	if command.Args == "" {
+		return "Usage: /ask <question>", nil
+	}
+	transcript, err := command.transcript(time.Time{}, chatCommandAskMessages)
+	if err != nil {
+		return "", err
+	}
+	asker := command.Event.SenderName
+	if command.Event.IsFromMe {
+		asker = "The account owner"
+	}
+	prompt := fmt.Sprintf(chatCommandAskPrompt, command.chatName(), asker, transcript, command.Args)
+	response, err := callLLM(command.llmContext("command_ask"), prompt)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(response), nil
+}
+
+// runSearchCommand answers "/search <words>" with the most relevant messages of the chat
+func runSearchCommand(command *ChatCommandRequest) (string, error) {
+	if command.Args == "" {
+		return "Usage: /search <words>", nil
+	}
+	results, _, err := command.messageStore.SearchMessages(SearchQuery{Query: command.Args, ChatJID: command.Event.ChatJID, Limit: chatCommandSearchResults + 1})
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, result := range results {
+		if result.ID == command.Event.ID || len(lines) == chatCommandSearchResults {
+			continue
+		}
+		sender := result.SenderName
+		if result.IsFromMe {
+			sender = "Me"
+		} else if sender == "" {
+			sender = result.Sender
+		}
+		text := result.Snippet
+		if text == "" {
+			text = result.Content
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", result.Timestamp.Format("Jan 2 15:04"), sender, text))
+	}
+	if len(lines) == 0 {
+		return fmt.Sprintf("🔎 Nothing found for \"%s\"", command.Args), nil
+	}
+	return fmt.Sprintf("🔎 *%s*\n\n%s", command.Args, strings.Join(lines, "\n")), nil
+}
+
+// runTasksCommand answers "/tasks [hours]" with the open tasks of the last hours of the chat
+func runTasksCommand(command *ChatCommandRequest) (string, error) {
+	hours := chatCommandTasksHours
+	if command.Args != "" {
+		parsed, err := strconv.Atoi(command.Args)
+		if err != nil || parsed < 1 || parsed > 24*7 {
+			return "Usage: /tasks [hours, 1 to 168]", nil
+		}
+		hours = parsed
+	}
+	since := command.Event.Timestamp.Add(-time.Duration(hours) * time.Hour)
+	transcript, err := command.transcript(since, catchUpMaxMessages)
+	if err != nil {
+		return "", err
+	}
+	if transcript == "" {
+		return fmt.Sprintf("✅ No messages in the last %d hours", hours), nil
+	}
+	response, err := callLLM(command.llmContext("command_tasks"), fmt.Sprintf(chatCommandTasksPrompt, command.chatName(), hours, transcript))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("📋 *Open tasks, last %d hours*\n\n%s", hours, strings.TrimSpace(response)), nil
+}
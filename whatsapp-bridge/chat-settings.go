@@ -0,0 +1,274 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ChatSettings are a chat's settings from the chat_settings table (see migrations/0009_chat_settings.sql).
+// Settings left unset (nil, "" or 0) fall back to config.json and the environment.
+type ChatSettings struct {
+	ChatJID        string    `json:"chat_jid"`
+	SummaryEnabled *bool     `json:"summary_enabled"`           // nil: only DAILY_SUMMARY_GROUP_JID is summarized
+	PromptOverride string    `json:"prompt_override,omitempty"` // daily summary prompt template, replacing prompts/daily-summary.md
+	Language       string    `json:"language,omitempty"`        // language the chat's summaries are written in
+	RetentionDays  int       `json:"retention_days,omitempty"`  // messages older than this are deleted; 0 keeps them
+	WebhookTargets []string  `json:"webhook_targets,omitempty"` // URLs receiving the chat's messages, besides the config.json webhooks
+	Muted          bool      `json:"muted"`                     // no summaries, digests, webhook deliveries or recaps
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// isEmpty reports whether none of the settings is set
+func (settings ChatSettings) isEmpty() bool {
+	return settings.SummaryEnabled == nil && settings.PromptOverride == "" && settings.Language == "" &&
+		settings.RetentionDays == 0 && len(settings.WebhookTargets) == 0 && !settings.Muted
+}
+
+// summaryEnabled reports whether the daily summary covers the chat; fallback applies when the chat doesn't say
+func (settings ChatSettings) summaryEnabled(fallback bool) bool {
+	if settings.Muted {
+		return false
+	}
+	return boolSetting(settings.SummaryEnabled, fallback)
+}
+
+// queryChatSettings reads the chat_settings rows matching a clause. Databases the table was never added to
+// have no settings.
+func queryChatSettings(db *sql.DB, clause string, args ...interface{}) ([]ChatSettings, error) {
+	rows, err := db.Query(`
+		SELECT chat_jid, summary_enabled, COALESCE(prompt_override, ''), COALESCE(language, ''), COALESCE(retention_days, 0),
+			COALESCE(webhook_targets, ''), muted, updated_at
+		FROM chat_settings `+clause, args...)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []ChatSettings
+	for rows.Next() {
+		var settings ChatSettings
+		var summaryEnabled sql.NullBool
+		var webhookTargets string
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&settings.ChatJID, &summaryEnabled, &settings.PromptOverride, &settings.Language,
+			&settings.RetentionDays, &webhookTargets, &settings.Muted, &updatedAt); err != nil {
+			return nil, err
+		}
+		if summaryEnabled.Valid {
+			settings.SummaryEnabled = &summaryEnabled.Bool
+		}
+		if webhookTargets != "" {
+			settings.WebhookTargets = strings.Split(webhookTargets, ",")
+		}
+		settings.UpdatedAt = updatedAt.Time
+		list = append(list, settings)
+	}
+	return list, rows.Err()
+}
+
+// getChatSettings returns a chat's settings; a chat without any has them all unset
+func getChatSettings(db *sql.DB, chatJID string) (ChatSettings, error) {
+	list, err := queryChatSettings(db, "WHERE chat_jid = ?", chatJID)
+	if err != nil || len(list) == 0 {
+		return ChatSettings{ChatJID: chatJID}, err
+	}
+	return list[0], nil
+}
+
+// listChatSettings returns the settings of every chat that has some
+func listChatSettings(db *sql.DB) ([]ChatSettings, error) {
+	return queryChatSettings(db, "ORDER BY chat_jid")
+}
+
+// loadChatSettings returns a chat's settings from messages.db, for the tools that don't keep it open
+func loadChatSettings(chatJID string) (ChatSettings, error) {
+	db, err := openSharedMessagesDB()
+	if err != nil {
+		return ChatSettings{ChatJID: chatJID}, err
+	}
+	return getChatSettings(db, chatJID)
+}
+
+// validateChatSettings checks settings before they are saved
+func validateChatSettings(settings ChatSettings) error {
+	if settings.ChatJID == "" || !strings.Contains(settings.ChatJID, "@") {
+		return fmt.Errorf("invalid chat JID %q", settings.ChatJID)
+	}
+	if settings.RetentionDays < 0 {
+		return fmt.Errorf("retention_days can't be negative")
+	}
+	for _, target := range settings.WebhookTargets {
+		parsed, err := url.Parse(target)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("webhook target %q is not an http(s) URL", target)
+		}
+		if strings.Contains(target, ",") {
+			return fmt.Errorf("webhook target %q can't contain a comma", target)
+		}
+	}
+	return nil
+}
+
+// saveChatSettings stores a chat's settings; a chat left without any settings is removed from the table
+func saveChatSettings(db *sql.DB, settings ChatSettings) error {
+	if settings.isEmpty() {
+		return deleteChatSettings(db, settings.ChatJID)
+	}
+	_, err := db.Exec(`
+		INSERT INTO chat_settings (chat_jid, summary_enabled, prompt_override, language, retention_days, webhook_targets, muted, updated_at)
+		VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, 0), NULLIF(?, ''), ?, ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET
+			summary_enabled = excluded.summary_enabled, prompt_override = excluded.prompt_override, language = excluded.language,
+			retention_days = excluded.retention_days, webhook_targets = excluded.webhook_targets, muted = excluded.muted,
+			updated_at = excluded.updated_at
+	`, settings.ChatJID, settings.SummaryEnabled, settings.PromptOverride, settings.Language, settings.RetentionDays,
+		strings.Join(settings.WebhookTargets, ","), settings.Muted, time.Now())
+	return err
+}
+
+// deleteChatSettings removes a chat's settings, so it follows config.json and the environment again
+func deleteChatSettings(db *sql.DB, chatJID string) error {
+	_, err := db.Exec("DELETE FROM chat_settings WHERE chat_jid = ?", chatJID)
+	return err
+}
+
+// applyChatSettingsPatch updates the settings named in a JSON object; null resets a setting
+func applyChatSettingsPatch(settings *ChatSettings, patch map[string]json.RawMessage) error {
+	for name, raw := range patch {
+		reset := strings.TrimSpace(string(raw)) == "null"
+		var err error
+		switch name {
+		case "chat_jid":
+		case "summary_enabled":
+			settings.SummaryEnabled = nil
+			if !reset {
+				settings.SummaryEnabled = new(bool)
+				err = json.Unmarshal(raw, settings.SummaryEnabled)
+			}
+		case "prompt_override":
+			settings.PromptOverride = ""
+			if !reset {
+				err = json.Unmarshal(raw, &settings.PromptOverride)
+			}
+		case "language":
+			settings.Language = ""
+			if !reset {
+				err = json.Unmarshal(raw, &settings.Language)
+				settings.Language = strings.TrimSpace(settings.Language)
+			}
+		case "retention_days":
+			settings.RetentionDays = 0
+			if !reset {
+				err = json.Unmarshal(raw, &settings.RetentionDays)
+			}
+		case "webhook_targets":
+			settings.WebhookTargets = nil
+			if !reset {
+				err = json.Unmarshal(raw, &settings.WebhookTargets)
+			}
+		case "muted":
+			settings.Muted = false
+			if !reset {
+				err = json.Unmarshal(raw, &settings.Muted)
+			}
+		default:
+			return fmt.Errorf("unknown chat setting %q", name)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// handleChatSettingsAPI manages chat settings on /api/chat-settings: GET lists them (or one chat's with
+// ?chat_jid=), POST {"chat_jid": ..., <setting>: <value or null>} changes the given settings and DELETE
+// ?chat_jid= clears a chat's
+func handleChatSettingsAPI(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chatJID := r.URL.Query().Get("chat_jid")
+		switch r.Method {
+		case http.MethodGet:
+			if chatJID != "" {
+				settings, err := getChatSettings(db, chatJID)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(settings)
+				return
+			}
+			list, err := listChatSettings(db)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if list == nil {
+				list = []ChatSettings{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"chats": list})
+
+		case http.MethodPost:
+			var patch map[string]json.RawMessage
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			if raw, ok := patch["chat_jid"]; ok {
+				json.Unmarshal(raw, &chatJID)
+			}
+			if chatJID == "" {
+				http.Error(w, "chat_jid is required", http.StatusBadRequest)
+				return
+			}
+			settings, err := getChatSettings(db, chatJID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err := applyChatSettingsPatch(&settings, patch); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := validateChatSettings(settings); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := saveChatSettings(db, settings); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to save chat settings: %v", err), http.StatusInternalServerError)
+				return
+			}
+			if settings, err = getChatSettings(db, chatJID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(settings)
+
+		case http.MethodDelete:
+			if chatJID == "" {
+				http.Error(w, "chat_jid is required", http.StatusBadRequest)
+				return
+			}
+			if err := deleteChatSettings(db, chatJID); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to clear chat settings: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "chat_jid": chatJID})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
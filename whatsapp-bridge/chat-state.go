@@ -0,0 +1,229 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// countUnreadMessage adds a message from someone else to the chat's unread count
+func countUnreadMessage(db *sql.DB, chatJID string, now time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO chat_state (chat_jid, unread_count, updated_at) VALUES (?, 1, ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET unread_count = chat_state.unread_count + 1, updated_at = excluded.updated_at
+	`, chatJID, now)
+	return err
+}
+
+// setChatUnread records how many messages of a chat are unread and whether it's marked as unread
+func setChatUnread(db *sql.DB, chatJID string, unreadCount int, markedUnread bool, now time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO chat_state (chat_jid, unread_count, marked_unread, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET
+			unread_count = excluded.unread_count,
+			marked_unread = excluded.marked_unread,
+			updated_at = excluded.updated_at
+	`, chatJID, unreadCount, markedUnread, now)
+	return err
+}
+
+// markChatRead clears a chat's unread count and unread mark
+func markChatRead(db *sql.DB, chatJID string, now time.Time) error {
+	return setChatUnread(db, chatJID, 0, false, now)
+}
+
+// markChatUnread marks a chat as unread, keeping its unread count
+func markChatUnread(db *sql.DB, chatJID string, now time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO chat_state (chat_jid, marked_unread, updated_at) VALUES (?, 1, ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET marked_unread = 1, updated_at = excluded.updated_at
+	`, chatJID, now)
+	return err
+}
+
+// setParticipantCount records how many members a group has
+func setParticipantCount(db *sql.DB, chatJID string, count int, now time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO chat_state (chat_jid, participant_count, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET participant_count = excluded.participant_count, updated_at = excluded.updated_at
+	`, chatJID, count, now)
+	return err
+}
+
+// addParticipantCount adjusts a group's member count for members joining (positive) or leaving (negative). Groups
+// whose count isn't known yet keep waiting for the next refresh.
+func addParticipantCount(db *sql.DB, chatJID string, delta int, now time.Time) error {
+	_, err := db.Exec(
+		"UPDATE chat_state SET participant_count = MAX(participant_count + ?, 0), updated_at = ? WHERE chat_jid = ? AND participant_count IS NOT NULL",
+		delta, now, chatJID,
+	)
+	return err
+}
+
+// recordChatActivity keeps a chat's unread count in step with a message stored live: a message from someone
+// else adds to it, and one I sent means I read the chat
+func recordChatActivity(messageStore *MessageStore, chatJID string, isFromMe bool, logger waLog.Logger) {
+	var err error
+	if isFromMe {
+		err = markChatRead(messageStore.db, chatJID, time.Now())
+	} else {
+		err = countUnreadMessage(messageStore.db, chatJID, time.Now())
+	}
+	if err != nil {
+		logger.Warnf("Failed to update the unread count of %s: %v", chatJID, err)
+	}
+}
+
+// handleMarkChatAsRead records a chat marked as read or unread on another device
+func handleMarkChatAsRead(messageStore *MessageStore, evt *events.MarkChatAsRead, logger waLog.Logger) {
+	chatJID := evt.JID.ToNonAD().String()
+	var err error
+	if evt.Action.GetRead() {
+		err = markChatRead(messageStore.db, chatJID, evt.Timestamp)
+	} else {
+		err = markChatUnread(messageStore.db, chatJID, evt.Timestamp)
+	}
+	if err != nil {
+		logger.Warnf("Failed to store the read state of %s: %v", chatJID, err)
+	}
+}
+
+// handleReadSelfReceipt records that I read a chat on another device, as my own read receipts tell
+func handleReadSelfReceipt(messageStore *MessageStore, evt *events.Receipt, logger waLog.Logger) {
+	if evt.Type != types.ReceiptTypeRead && evt.Type != types.ReceiptTypeReadSelf && evt.Type != types.ReceiptTypePlayed {
+		return
+	}
+	chatJID := evt.Chat.ToNonAD().String()
+	if err := markChatRead(messageStore.db, chatJID, evt.Timestamp); err != nil {
+		logger.Warnf("Failed to store the read state of %s: %v", chatJID, err)
+	}
+}
+
+// Most messages a whole chat is marked as read with: the newest of those since I last wrote in it
+const markReadMaxMessages = 100
+
+// markMessagesRead sends read receipts for messages of a chat that others sent, and clears the chat's unread
+// count. Without IDs it marks the messages since I last wrote in the chat. Returns how many messages were marked.
+func markMessagesRead(client *whatsmeow.Client, messageStore *MessageStore, chat string, messageIDs []string) (int, error) {
+	if !client.IsConnected() {
+		return 0, fmt.Errorf("not connected to WhatsApp")
+	}
+	chatJID, err := parseRecipientJID(client, chat)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chat %s: %v", chat, err)
+	}
+
+	// Group events stored as system messages aren't WhatsApp messages, so they get no receipts
+	query := "SELECT id, COALESCE(sender, '') FROM messages WHERE chat_jid = ? AND is_from_me = 0 AND COALESCE(message_type, '') = ''"
+	args := []interface{}{chatJID.String()}
+	if len(messageIDs) > 0 {
+		query += " AND id IN (?" + strings.Repeat(", ?", len(messageIDs)-1) + ")"
+		for _, id := range messageIDs {
+			args = append(args, id)
+		}
+	} else {
+		query += ` AND timestamp > COALESCE((SELECT MAX(timestamp) FROM messages WHERE chat_jid = ? AND is_from_me = 1), '')
+			ORDER BY timestamp DESC LIMIT ?`
+		args = append(args, chatJID.String(), markReadMaxMessages)
+	}
+	rows, err := messageStore.dbFor(chatJID.String()).Query(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read messages: %v", err)
+	}
+	// Receipts are sent per sender, which groups need to know
+	bySender := make(map[string][]types.MessageID)
+	found := make(map[string]bool)
+	for rows.Next() {
+		var id, sender string
+		if err := rows.Scan(&id, &sender); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		bySender[sender] = append(bySender[sender], id)
+		found[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, id := range messageIDs {
+		if !found[id] {
+			return 0, fmt.Errorf("message %s from someone else not found in %s", id, chatJID)
+		}
+	}
+
+	now := time.Now()
+	if readOnlyModeEnabled() && len(bySender) > 0 {
+		return 0, errReadOnly
+	}
+	for sender, ids := range bySender {
+		senderJID := types.EmptyJID
+		if chatJID.Server == types.GroupServer {
+			senderJID = storedMessageSenderJID(client, chatJID, sender, false)
+		}
+		if err := client.MarkRead(ids, now, chatJID, senderJID); err != nil {
+			return 0, fmt.Errorf("error sending read receipts: %v", err)
+		}
+	}
+	if len(messageIDs) == 0 {
+		if err := markChatRead(messageStore.db, chatJID.String(), now); err != nil {
+			return 0, err
+		}
+	} else if err := readChatMessages(messageStore.db, chatJID.String(), len(found), now); err != nil {
+		return 0, err
+	}
+	return len(found), nil
+}
+
+// readChatMessages takes messages read one by one off a chat's unread count
+func readChatMessages(db *sql.DB, chatJID string, count int, now time.Time) error {
+	_, err := db.Exec("UPDATE chat_state SET unread_count = MAX(unread_count - ?, 0), updated_at = ? WHERE chat_jid = ?", count, now, chatJID)
+	return err
+}
+
+// MarkReadRequest is the request body for the mark as read API
+type MarkReadRequest struct {
+	ChatJID    string   `json:"chat_jid"`
+	MessageIDs []string `json:"message_ids,omitempty"` // none marks the messages since I last wrote in the chat
+}
+
+// handleMarkReadAPI sends read receipts for messages of a chat, or for the whole chat (POST /api/mark-read)
+func handleMarkReadAPI(client *whatsmeow.Client, messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req MarkReadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChatJID == "" {
+			http.Error(w, "chat_jid is required", http.StatusBadRequest)
+			return
+		}
+		if !apiRequestAllowsChat(r, req.ChatJID) {
+			rejectChat(w, r, req.ChatJID)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		marked, err := markMessagesRead(client, messageStore, req.ChatJID, req.MessageIDs)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": fmt.Sprintf("Marked %d messages of %s as read", marked, req.ChatJID),
+			"marked":  marked,
+		})
+	}
+}
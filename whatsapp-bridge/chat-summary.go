@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Most recent messages of the period given to the LLM by an on-demand summary
+const chatSummaryMaxMessages = 1000
+
+// loadPromptTemplate loads the prompt template and replaces placeholders
+func loadPromptTemplate(messages []DailySummaryMessage, groupJID, date string, logger waLog.Logger) (string, error) {
+	// Try to load custom prompt template
+	promptPath := "prompts/daily-summary.md"
+	promptBytes, err := os.ReadFile(promptPath)
+
+	var promptTemplate string
+	if err != nil {
+		// Use default prompt if file doesn't exist
+		promptTemplate = `You are an executive assistant analyzing conversations in the group for the day. 
+Please provide:
+
+1. **Executive Summary**: Main discussions and decisions
+2. **Pending Actions**: Tasks identified and responsible  
+3. **Metrics**: Companies mentioned, valuations discussed
+4. **Follow-ups Needed**: Suggested next steps
+
+Be direct and concise. Use data and numbers whenever mentioned. Messages followed by [reactions: ...] got that response from the group; many reactions usually mean the message mattered. "(replying to Ana 14:02)" after a sender means the message answers Ana's message from that time.
+
+Messages of the day ({{DATE}}):
+{{MESSAGES}}`
+	} else {
+		promptTemplate = string(promptBytes)
+	}
+
+	// Format messages as text
+	var messageLines []string
+	for _, msg := range messages {
+		direction := "←"
+		if msg.IsFromMe {
+			direction = "→"
+		}
+		speaker := msg.Sender
+		if msg.ReplyTo != "" {
+			speaker += fmt.Sprintf(" (replying to %s)", msg.ReplyTo)
+		}
+		line := formatTranscriptMessage(fmt.Sprintf("[%s] %s %s: ", msg.Timestamp, direction, speaker), msg.Content)
+		if msg.Reactions != "" {
+			line += fmt.Sprintf(" [reactions: %s]", msg.Reactions)
+		}
+		messageLines = append(messageLines, line)
+	}
+	messagesText := strings.Join(messageLines, "\n")
+
+	data := promptMessagesData(messages, date)
+	data["GroupJID"] = groupJID
+	data["MESSAGES"] = messagesText
+
+	// A chat's own prompt and language (chat settings) take precedence; a prompt that doesn't render falls back
+	// to the file
+	settings, err := loadChatSettings(groupJID)
+	if err != nil {
+		logger.Warnf("Failed to read chat settings: %v", err)
+	}
+	prompt := ""
+	if settings.PromptOverride != "" {
+		if prompt, err = renderPrompt("prompt_override of "+groupJID, settings.PromptOverride, data); err != nil {
+			logger.Warnf("Using %s instead: %v", promptPath, err)
+			prompt = ""
+		}
+	}
+	if prompt == "" {
+		if prompt, err = renderPrompt(promptPath, promptTemplate, data); err != nil {
+			return "", err
+		}
+	}
+	if settings.Language != "" {
+		prompt += fmt.Sprintf("\n\nWrite the summary in %s.", settings.Language)
+	}
+
+	// Inject the user's corrections for this group as ground truth
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		return "", fmt.Errorf("failed to open message database: %v", err)
+	}
+	defer db.Close()
+
+	annotationsText, err := getAnnotationsPromptText(db, groupJID)
+	if err != nil {
+		logger.Warnf("Failed to load annotations: %v", err)
+	}
+	prompt = applyAnnotationsToPrompt(prompt, annotationsText)
+
+	return prompt, nil
+}
+
+// ChatSummary is an on-demand summary of a chat over a period
+type ChatSummary struct {
+	ChatJID  string    `json:"chat_jid"`
+	ChatName string    `json:"chat_name"`
+	After    time.Time `json:"after"`
+	Before   time.Time `json:"before"`
+	Messages int       `json:"messages"`          // messages summarized
+	Omitted  int       `json:"omitted,omitempty"` // older messages of the period left out
+	Summary  string    `json:"summary"`           // empty when the chat had no messages in the period
+}
+
+// summarizeChat summarizes a chat's messages between two times with the daily summary prompt (or the chat's
+// prompt_override), its language and annotations, through the configured LLM
+func summarizeChat(ctx context.Context, chatJID string, after, before time.Time, logger waLog.Logger) (*ChatSummary, error) {
+	messages, err := getMessagesFromGroup(chatJID, after, before, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %v", err)
+	}
+	summary := &ChatSummary{ChatJID: chatJID, ChatName: getGroupName(chatJID, logger), After: after, Before: before}
+	if len(messages) == 0 {
+		return summary, nil
+	}
+	if len(messages) > chatSummaryMaxMessages {
+		summary.Omitted = len(messages) - chatSummaryMaxMessages
+		messages = messages[summary.Omitted:]
+	}
+	summary.Messages = len(messages)
+	messages = collapseDuplicatesForPrompt(messages, chatJID, logger)
+
+	// The prompt's date is the period, for summaries of more than a day
+	date := after.Format("2006-01-02")
+	if last := before.Add(-time.Nanosecond).Format("2006-01-02"); last != date {
+		date += " to " + last
+	}
+	prompt, err := loadPromptTemplate(messages, chatJID, date, logger)
+	if err != nil {
+		return nil, err
+	}
+	ctx = withLLMAuditScope(ctx, chatJID, after.Format("2006-01-02"))
+	if summary.Summary, err = callLLM(withLLMPurpose(ctx, "summarize_chat"), prompt); err != nil {
+		return nil, fmt.Errorf("failed to call LLM: %v", err)
+	}
+	return summary, nil
+}
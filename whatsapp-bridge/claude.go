@@ -2,11 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -36,15 +38,87 @@ type ClaudeResponse struct {
 	} `json:"usage"`
 }
 
-// callClaudeServer sends a message to the Claude Code HTTP server with optional tools
+// AnthropicRequest represents the request body of the Anthropic Messages API
+type AnthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	Messages  []AnthropicMessage `json:"messages"`
+	Stream    bool               `json:"stream,omitempty"`
+}
+
+// AnthropicMessage is a single conversation turn sent to the Messages API
+type AnthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// AnthropicUsage is the token usage reported by the Messages API
+type AnthropicUsage struct {
+	InputTokens         int `json:"input_tokens"`
+	CacheCreationTokens int `json:"cache_creation_input_tokens"`
+	CacheReadTokens     int `json:"cache_read_input_tokens"`
+	OutputTokens        int `json:"output_tokens"`
+}
+
+// AnthropicResponse represents the response of the Anthropic Messages API
+type AnthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string         `json:"stop_reason"`
+	Usage      AnthropicUsage `json:"usage"`
+	Error      *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// lastClaudeResponseBody holds the raw body of the most recent LLM response, kept for diagnostics
+var lastClaudeResponseBody string
+
+// ClaudeCodeProvider sends prompts to the Claude Code HTTP server, which can call MCP tools
+type ClaudeCodeProvider struct{}
+
+// Name returns the provider name
+func (provider *ClaudeCodeProvider) Name() string {
+	return llmProviderClaudeCode
+}
+
+// SupportsTools reports that Claude Code can call MCP tools
+func (provider *ClaudeCodeProvider) SupportsTools() bool {
+	return true
+}
+
+// Complete sends the prompt to the Claude Code HTTP server with the given tools
+func (provider *ClaudeCodeProvider) Complete(ctx context.Context, prompt string, tools ...string) (string, error) {
+	return callClaudeCodeServer(ctx, prompt, tools...)
+}
+
+// AnthropicProvider sends prompts directly to the Anthropic Messages API
+type AnthropicProvider struct{}
+
+// Name returns the provider name
+func (provider *AnthropicProvider) Name() string {
+	return llmProviderAnthropic
+}
+
+// SupportsTools reports that MCP tools are not available through the API
+func (provider *AnthropicProvider) SupportsTools() bool {
+	return false
+}
+
+// Complete sends the prompt to the Messages API; tools are ignored
+func (provider *AnthropicProvider) Complete(ctx context.Context, prompt string, tools ...string) (string, error) {
+	return callAnthropicAPI(ctx, prompt)
+}
+
+// callClaudeCodeServer sends a message to the Claude Code HTTP server with optional tools
 // If no tools are specified, uses environment variable or defaults to "mcp__whatsapp"
 // If tools are specified, joins them with commas
-func callClaudeServer(prompt string, tools ...string) (string, error) {
+func callClaudeCodeServer(ctx context.Context, prompt string, tools ...string) (string, error) {
 	// Get configuration from environment
-	claudeServer := os.Getenv("CLAUDE_SERVER_URL")
-	if claudeServer == "" {
-		claudeServer = "http://host.docker.internal:8888/claude"
-	}
+	claudeServer := getLLMProviderURL(llmProviderClaudeCode)
 
 	// Determine allowed tools
 	var allowedTools string
@@ -57,11 +131,15 @@ func callClaudeServer(prompt string, tools ...string) (string, error) {
 	// Enable debug logging for Graphiti tools (when multiple tools are specified)
 	enableDebugLogging := len(tools) > 0 && strings.Contains(allowedTools, "mcp__graphiti")
 
-	// Prepare the request
+	// Prepare the request, resuming the Claude Code session of earlier calls in the same workflow
 	req := ClaudeRequest{
 		Prompt: prompt,
 		Args:   []string{"--allowedTools", allowedTools},
 	}
+	session := llmSessionFromContext(ctx)
+	if sessionID := session.ClaudeSessionID(); sessionID != "" {
+		req.Args = append(req.Args, "--resume", sessionID)
+	}
 
 	if enableDebugLogging {
 		// Log the exact request being sent for debugging
@@ -75,20 +153,19 @@ func callClaudeServer(prompt string, tools ...string) (string, error) {
 		return "", fmt.Errorf("error marshaling request: %v", err)
 	}
 
+	// Bound the call by LLM_TIMEOUT unless the caller's context ends sooner
+	ctx, cancel := withLLMTimeout(ctx, 300*time.Second)
+	defer cancel()
+
 	// Create the HTTP request
-	httpReq, err := http.NewRequest("POST", claudeServer, bytes.NewBuffer(jsonData))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", claudeServer, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return "", fmt.Errorf("error creating request: %v", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
 
-	// Create a client with timeout
-	client := &http.Client{
-		Timeout: 300 * time.Second,
-	}
-
 	// Send the request
-	resp, err := client.Do(httpReq)
+	resp, err := http.DefaultClient.Do(httpReq)
 	if err != nil {
 		return "", fmt.Errorf("error sending request: %v", err)
 	}
@@ -99,6 +176,7 @@ func callClaudeServer(prompt string, tools ...string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("error reading response: %v", err)
 	}
+	lastClaudeResponseBody = string(body)
 
 	// Parse the response
 	var claudeResp ClaudeResponse
@@ -120,6 +198,187 @@ func callClaudeServer(prompt string, tools ...string) (string, error) {
 	if claudeResp.IsError {
 		return "", fmt.Errorf("Claude returned an error: %s", claudeResp.Result)
 	}
+	session.SetClaudeSessionID(claudeResp.SessionId)
+	lastLLMUsage = LLMUsage{
+		InputTokens:         claudeResp.Usage.InputTokens,
+		OutputTokens:        claudeResp.Usage.OutputTokens,
+		CacheCreationTokens: claudeResp.Usage.CacheCreationTokens,
+		CacheReadTokens:     claudeResp.Usage.CacheReadTokens,
+		CostUSD:             claudeResp.TotalCostUsd,
+	}
 
 	return claudeResp.Result, nil
 }
+
+// getAnthropicModel returns the model used by the anthropic provider (ANTHROPIC_MODEL, default claude-sonnet-4-5)
+func getAnthropicModel() string {
+	model := os.Getenv("ANTHROPIC_MODEL")
+	if model == "" {
+		model = "claude-sonnet-4-5"
+	}
+	return model
+}
+
+// callAnthropicAPI sends a prompt to the Anthropic Messages API using ANTHROPIC_API_KEY,
+// after the earlier turns of the session in ctx, if any
+func callAnthropicAPI(ctx context.Context, prompt string) (string, error) {
+	apiKey := os.Getenv("ANTHROPIC_API_KEY")
+	if apiKey == "" {
+		return "", fmt.Errorf("ANTHROPIC_API_KEY is required for the anthropic LLM provider")
+	}
+
+	apiURL := getLLMProviderURL(llmProviderAnthropic)
+
+	model := getAnthropicModel()
+
+	maxTokens, err := strconv.Atoi(os.Getenv("ANTHROPIC_MAX_TOKENS"))
+	if err != nil || maxTokens <= 0 {
+		maxTokens = 4096
+	}
+
+	jsonData, err := json.Marshal(AnthropicRequest{
+		Model:     model,
+		MaxTokens: maxTokens,
+		Messages:  anthropicSessionMessages(llmSessionFromContext(ctx), prompt),
+		Stream:    llmStreamingEnabled(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error marshaling request: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", apiURL+"/v1/messages", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", apiKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+
+	if llmStreamingEnabled() {
+		return streamAnthropicAPI(httpReq, maxTokens)
+	}
+
+	ctx, cancel := withLLMTimeout(ctx, 300*time.Second)
+	defer cancel()
+
+	resp, err := http.DefaultClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response: %v", err)
+	}
+	lastClaudeResponseBody = string(body)
+
+	var apiResp AnthropicResponse
+	if err := json.Unmarshal(body, &apiResp); err != nil {
+		return "", fmt.Errorf("error parsing response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if apiResp.Error != nil {
+		return "", fmt.Errorf("Anthropic API error (%s): %s", apiResp.Error.Type, apiResp.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Anthropic API returned HTTP %d", resp.StatusCode)
+	}
+
+	lastLLMUsage = LLMUsage{
+		InputTokens:         apiResp.Usage.InputTokens,
+		OutputTokens:        apiResp.Usage.OutputTokens,
+		CacheCreationTokens: apiResp.Usage.CacheCreationTokens,
+		CacheReadTokens:     apiResp.Usage.CacheReadTokens,
+	}
+
+	var text strings.Builder
+	for _, block := range apiResp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	if apiResp.StopReason == "max_tokens" {
+		fmt.Printf("Warning: Anthropic response truncated at %d tokens (raise ANTHROPIC_MAX_TOKENS)\n", maxTokens)
+	}
+
+	return text.String(), nil
+}
+
+// anthropicSessionMessages returns the session's earlier turns followed by the new prompt
+func anthropicSessionMessages(session *LLMSession, prompt string) []AnthropicMessage {
+	var messages []AnthropicMessage
+	for _, turn := range session.Turns() {
+		messages = append(messages, AnthropicMessage{Role: turn.Role, Content: turn.Content})
+	}
+	return append(messages, AnthropicMessage{Role: "user", Content: prompt})
+}
+
+// AnthropicStreamEvent is one server-sent event of a streamed Messages API response
+type AnthropicStreamEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	// Input usage comes with message_start, the output tokens with message_delta
+	Message struct {
+		Usage AnthropicUsage `json:"usage"`
+	} `json:"message"`
+	Usage *AnthropicUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// streamAnthropicAPI reads a streamed Messages API response, failing only when the stream goes idle
+func streamAnthropicAPI(httpReq *http.Request, maxTokens int) (string, error) {
+	progress := newLLMStreamProgress("Anthropic")
+	stopReason := ""
+
+	err := doLLMStreamRequest(httpReq, func(data string) (bool, error) {
+		var event AnthropicStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return false, fmt.Errorf("error parsing stream event: %v", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			usage := event.Message.Usage
+			lastLLMUsage = LLMUsage{
+				InputTokens:         usage.InputTokens,
+				OutputTokens:        usage.OutputTokens,
+				CacheCreationTokens: usage.CacheCreationTokens,
+				CacheReadTokens:     usage.CacheReadTokens,
+			}
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" {
+				progress.Add(event.Delta.Text)
+			}
+		case "message_delta":
+			stopReason = event.Delta.StopReason
+			if event.Usage != nil {
+				lastLLMUsage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "message_stop":
+			return true, nil
+		case "error":
+			if event.Error != nil {
+				return false, fmt.Errorf("Anthropic API error (%s): %s", event.Error.Type, event.Error.Message)
+			}
+			return false, fmt.Errorf("Anthropic API stream error")
+		}
+		return false, nil
+	})
+
+	text := progress.Finish()
+	if err != nil {
+		return "", err
+	}
+	if stopReason == "max_tokens" {
+		fmt.Printf("Warning: Anthropic response truncated at %d tokens (raise ANTHROPIC_MAX_TOKENS)\n", maxTokens)
+	}
+	return text, nil
+}
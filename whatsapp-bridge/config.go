@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// BridgeConfig holds settings that don't fit in environment variables, loaded from config/config.json
+type BridgeConfig struct {
+	EntityTypes  []EntityTypeConfig          `json:"entity_types"`
+	Groups       map[string]GroupConfig      `json:"groups"` // keyed by group JID or name, "default" applies to all groups
+	Webhooks     []WebhookConfig             `json:"webhooks,omitempty"`
+	Alerts       []AlertConfig               `json:"alerts,omitempty"`       // watch rules forwarding matching messages as they arrive
+	Commands     map[string]CommandConfig    `json:"commands,omitempty"`     // keyed by chat JID, phone number, group name, "self" or "default"
+	Translations []TranslationConfig         `json:"translations,omitempty"` // chats whose incoming messages are translated into my language
+	Recipients   map[string]RecipientConfig  `json:"recipients,omitempty"`   // keyed by JID, phone number or "self"
+	SendPolicy   SendPolicyConfig            `json:"send_policy,omitempty"`  // chats the MCP tools and summaries may send to
+	Redaction    RedactionConfig             `json:"redaction,omitempty"`    // what PII_REDACTION=true also hides from the LLM
+	Permissions  map[string]PermissionConfig `json:"permissions,omitempty"`  // keyed by MCP tool name or API path (see permissions.go)
+}
+
+// GroupConfig holds per-group settings; unset fields fall back to the "default" entry and then to built-in defaults
+type GroupConfig struct {
+	IncludeSystemMessages *bool    `json:"include_system_messages,omitempty"` // subject, description and settings changes
+	IncludeMemberEvents   *bool    `json:"include_member_events,omitempty"`   // joins, leaves, promotions
+	IncludeOwnAutomated   *bool    `json:"include_own_automated,omitempty"`   // summaries and reports sent by these tools
+	ExcludeSenders        []string `json:"exclude_senders,omitempty"`         // regexes matched against sender phone/JID and name (bots, automations)
+	Importance            *float64 `json:"importance,omitempty"`              // 0-1, controls summary depth; learned from my reply rate when unset
+	StateOfPlay           *bool    `json:"state_of_play,omitempty"`           // carry a compact state of ongoing threads between daily summaries
+	Summarizer            string   `json:"summarizer,omitempty"`              // "llm" (default) or "rules" for a template-only digest without LLM costs
+	CollapseDuplicates    *bool    `json:"collapse_duplicates,omitempty"`     // collapse texts posted several times a day (forwarded chains) before prompting
+	Language              string   `json:"language,omitempty"`                // language the group's summaries are written in, e.g. "Portuguese"
+	MaxSummaryChars       *int     `json:"max_summary_chars,omitempty"`       // longer summaries get a tightening pass before they are sent
+	CatchUp               *bool    `json:"catch_up,omitempty"`                // answer "what did I miss" questions with a recap of the last hours
+	CatchUpHours          *int     `json:"catch_up_hours,omitempty"`          // hours covered by the recap (default 12)
+	CatchUpPerDay         *int     `json:"catch_up_per_day,omitempty"`        // recaps sent per group per day (default 3)
+	CatchUpPattern        string   `json:"catch_up_pattern,omitempty"`        // regex detecting the questions, replacing the built-in English/Portuguese one
+	CatchUpClassifier     *bool    `json:"catch_up_classifier,omitempty"`     // also ask the LLM about short questions the regex misses
+}
+
+// RecipientConfig holds per-recipient settings for what the tools send
+type RecipientConfig struct {
+	Language string `json:"language,omitempty"` // summaries are translated into this language when the group's differs
+}
+
+// SendPolicyConfig lists the chats the MCP tools and the scheduled tools' summaries may or may not send to (see send-policy.go)
+type SendPolicyConfig struct {
+	Allow []string `json:"allow,omitempty"` // JIDs, phone numbers, group names or "self"; when set, only these chats
+	Deny  []string `json:"deny,omitempty"`  // never these chats, even when allowed
+}
+
+// RedactionConfig lists personal data to redact from LLM prompts besides phone numbers, emails and card numbers
+type RedactionConfig struct {
+	Names    []string `json:"names,omitempty"`    // matched as whole words, ignoring case
+	Patterns []string `json:"patterns,omitempty"` // regular expressions
+}
+
+// PermissionConfig says who may use an MCP tool or API endpoint. In config.json it is "enabled", "disabled", or
+// the list of the names of the API tokens that may.
+type PermissionConfig struct {
+	Disabled bool
+	Tokens   []string // nil when every caller may
+}
+
+// UnmarshalJSON reads a permission as "enabled", "disabled" or a list of token names
+func (permission *PermissionConfig) UnmarshalJSON(data []byte) error {
+	var state string
+	if err := json.Unmarshal(data, &state); err == nil {
+		switch state {
+		case "enabled":
+			*permission = PermissionConfig{}
+		case "disabled":
+			*permission = PermissionConfig{Disabled: true}
+		default:
+			return fmt.Errorf(`permission must be "enabled", "disabled" or a list of token names, not %q`, state)
+		}
+		return nil
+	}
+	var tokens []string
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return fmt.Errorf(`permission must be "enabled", "disabled" or a list of token names`)
+	}
+	*permission = PermissionConfig{Tokens: append([]string{}, tokens...)}
+	return nil
+}
+
+// EntityTypeConfig describes a custom entity type the knowledge graph should extract (e.g. Company, Deal)
+type EntityTypeConfig struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Attributes  []string `json:"attributes,omitempty"`
+	Examples    []string `json:"examples,omitempty"`
+}
+
+// WebhookConfig is an outbound webhook receiving stored messages (webhooks in config.json)
+type WebhookConfig struct {
+	URL           string   `json:"url"`
+	Secret        string   `json:"secret,omitempty"`          // HMAC-SHA256 signing key; defaults to WEBHOOK_SECRET
+	Chats         []string `json:"chats,omitempty"`           // chat JIDs, phone numbers or group names; every chat when empty
+	IncludeFromMe bool     `json:"include_from_me,omitempty"` // also deliver messages sent from my account
+}
+
+// AlertConfig is a watch rule: messages matching its keywords or pattern are forwarded as they arrive (see alerts.go)
+type AlertConfig struct {
+	Name          string   `json:"name"`
+	Keywords      []string `json:"keywords,omitempty"`        // words or phrases, matched as whole words ignoring case
+	Pattern       string   `json:"pattern,omitempty"`         // regular expression, matched besides the keywords
+	Chats         []string `json:"chats,omitempty"`           // chat JIDs, phone numbers or group names; every chat when empty
+	Notify        []string `json:"notify,omitempty"`          // "self" (default), other chats, or http(s) webhook URLs
+	Context       *int     `json:"context,omitempty"`         // earlier messages of the chat sent along (default 3)
+	IncludeFromMe bool     `json:"include_from_me,omitempty"` // also match messages sent from my account
+}
+
+// CommandConfig enables in-chat commands such as /ask in a chat (see chat-commands.go)
+type CommandConfig struct {
+	Enabled []string `json:"enabled,omitempty"` // command names without the slash, or "*" for every command
+	Senders []string `json:"senders,omitempty"` // phone numbers or JIDs allowed besides me, or "*" for anyone in the chat
+}
+
+// TranslationConfig is a translation rule: the incoming messages of its chats are translated (see translation.go)
+type TranslationConfig struct {
+	Chats    []string `json:"chats"`              // JIDs, phone numbers or group names
+	Language string   `json:"language,omitempty"` // translated into; defaults to the language of recipients "self", then English
+	Mode     string   `json:"mode,omitempty"`     // "forward" to my self-chat (default), "summary" under the chat's daily summary, or "both"
+}
+
+// getConfigPath returns the path of the JSON config file
+func getConfigPath() string {
+	configPath := os.Getenv("CONFIG_PATH")
+	if configPath == "" {
+		configPath = "config/config.json"
+	}
+	return configPath
+}
+
+// loadBridgeConfig reads the JSON config file, with groups referenced by name keyed by their resolved JID
+func loadBridgeConfig() (*BridgeConfig, error) {
+	config, err := readBridgeConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	for reference, group := range config.Groups {
+		if reference == "default" || isGroupJID(reference) {
+			continue
+		}
+		jid, err := resolveGroupReference(reference)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: group %q in the config: %v\n", reference, err)
+			continue
+		}
+		delete(config.Groups, reference)
+		// An entry keyed by the JID itself takes precedence
+		if _, ok := config.Groups[jid]; !ok {
+			config.Groups[jid] = group
+		}
+	}
+	return config, nil
+}
+
+// readBridgeConfig reads the JSON config file as written; a missing file yields an empty config
+func readBridgeConfig() (*BridgeConfig, error) {
+	config := &BridgeConfig{}
+
+	data, err := os.ReadFile(getConfigPath())
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", getConfigPath(), err)
+	}
+
+	return config, nil
+}
+
+// policyConfig is the parsed config the permissions and send policy are checked against, read once and again
+// only on reloadPolicyConfig (SIGHUP in the bridge)
+var policyConfig struct {
+	sync.Mutex
+	config *BridgeConfig
+	err    error
+}
+
+// loadPolicyConfig returns the config the permissions and send policy use, reading it on first use
+func loadPolicyConfig() (*BridgeConfig, error) {
+	policyConfig.Lock()
+	defer policyConfig.Unlock()
+	if policyConfig.config == nil && policyConfig.err == nil {
+		policyConfig.config, policyConfig.err = readBridgeConfig()
+	}
+	return policyConfig.config, policyConfig.err
+}
+
+// reloadPolicyConfig reads the config for the permissions and send policy again. A config that can't be read
+// keeps the last good one, which stays in force.
+func reloadPolicyConfig() error {
+	config, err := readBridgeConfig()
+	policyConfig.Lock()
+	defer policyConfig.Unlock()
+	if err != nil {
+		if policyConfig.config == nil {
+			policyConfig.err = err
+		}
+		return err
+	}
+	policyConfig.config, policyConfig.err = config, nil
+	return nil
+}
+
+// getGroupConfig returns the settings of a group, merged over the "default" entry
+func (config *BridgeConfig) getGroupConfig(groupJID string) GroupConfig {
+	merged := config.Groups["default"]
+	group, ok := config.Groups[groupJID]
+	if !ok {
+		return merged
+	}
+
+	if group.IncludeSystemMessages != nil {
+		merged.IncludeSystemMessages = group.IncludeSystemMessages
+	}
+	if group.IncludeMemberEvents != nil {
+		merged.IncludeMemberEvents = group.IncludeMemberEvents
+	}
+	if group.IncludeOwnAutomated != nil {
+		merged.IncludeOwnAutomated = group.IncludeOwnAutomated
+	}
+	if group.Importance != nil {
+		merged.Importance = group.Importance
+	}
+	if group.StateOfPlay != nil {
+		merged.StateOfPlay = group.StateOfPlay
+	}
+	if group.Summarizer != "" {
+		merged.Summarizer = group.Summarizer
+	}
+	if group.CollapseDuplicates != nil {
+		merged.CollapseDuplicates = group.CollapseDuplicates
+	}
+	if group.Language != "" {
+		merged.Language = group.Language
+	}
+	if group.MaxSummaryChars != nil {
+		merged.MaxSummaryChars = group.MaxSummaryChars
+	}
+	if group.CatchUp != nil {
+		merged.CatchUp = group.CatchUp
+	}
+	if group.CatchUpHours != nil {
+		merged.CatchUpHours = group.CatchUpHours
+	}
+	if group.CatchUpPerDay != nil {
+		merged.CatchUpPerDay = group.CatchUpPerDay
+	}
+	if group.CatchUpPattern != "" {
+		merged.CatchUpPattern = group.CatchUpPattern
+	}
+	if group.CatchUpClassifier != nil {
+		merged.CatchUpClassifier = group.CatchUpClassifier
+	}
+	merged.ExcludeSenders = append(append([]string{}, merged.ExcludeSenders...), group.ExcludeSenders...)
+	return merged
+}
+
+// getRecipientConfig returns the settings of a recipient given as JID, phone number or "self"
+func (config *BridgeConfig) getRecipientConfig(recipient string) RecipientConfig {
+	key := recipientConfigKey(recipient)
+	for reference, recipientConfig := range config.Recipients {
+		if recipientConfigKey(reference) == key {
+			return recipientConfig
+		}
+	}
+	return RecipientConfig{}
+}
+
+// recipientConfigKey reduces a direct chat JID or a formatted phone number to its digits, so both forms match
+func recipientConfigKey(recipient string) string {
+	recipient = strings.TrimSpace(recipient)
+	if user, ok := strings.CutSuffix(recipient, "@s.whatsapp.net"); ok {
+		return user
+	}
+	if recipient != "" && strings.Trim(recipient, "+0123456789 -()") == "" {
+		return strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, recipient)
+	}
+	return recipient
+}
+
+// boolSetting returns the value of an optional boolean setting, or fallback when unset
+func boolSetting(value *bool, fallback bool) bool {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
+
+// formatEntityTypesForPrompt renders the configured entity types as a markdown list for the {{ENTITY_TYPES}} placeholder
+func formatEntityTypesForPrompt(entityTypes []EntityTypeConfig) string {
+	if len(entityTypes) == 0 {
+		return ""
+	}
+
+	var lines []string
+	for _, entityType := range entityTypes {
+		line := fmt.Sprintf("- **%s**: %s", entityType.Name, entityType.Description)
+		if len(entityType.Attributes) > 0 {
+			line += fmt.Sprintf(" (attributes: %s)", strings.Join(entityType.Attributes, ", "))
+		}
+		if len(entityType.Examples) > 0 {
+			line += fmt.Sprintf(" (e.g. %s)", strings.Join(entityType.Examples, ", "))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// getEntityTypesPromptText loads the config and returns the entity types text for prompts, or "" if none are configured
+func getEntityTypesPromptText() string {
+	config, err := loadBridgeConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return ""
+	}
+	return formatEntityTypesForPrompt(config.EntityTypes)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Reconnect delays double from the first to the longest, with up to 20% jitter
+const (
+	reconnectFirstDelay = 2 * time.Second
+	reconnectMaxDelay   = 5 * time.Minute
+)
+
+// Keepalive pings failing in a row before the connection is considered dead and replaced
+const keepAliveFailuresBeforeReconnect = 3
+
+// Connection trouble worth an error report: reconnect attempts failing in a row, and drops within an hour
+const (
+	errorReportReconnectAttempts = 5
+	errorReportDropsPerHour      = 5
+)
+
+// How long the health check waits for each database
+const healthDBTimeout = 3 * time.Second
+
+// How long the readiness probe waits for the LLM endpoint to answer
+const readinessLLMTimeout = 3 * time.Second
+
+// Connection states reported by /health
+const (
+	connectionConnecting     = "connecting"
+	connectionConnected      = "connected"
+	connectionReconnecting   = "reconnecting"
+	connectionLoggedOut      = "logged_out"
+	connectionStreamReplaced = "stream_replaced" // another client took over this session
+)
+
+// ConnectionSupervisor reconnects the WhatsApp client with exponential backoff and keeps the state /health reports
+type ConnectionSupervisor struct {
+	client *whatsmeow.Client
+	logger waLog.Logger
+
+	mu              sync.Mutex
+	state           string
+	since           time.Time
+	lastError       string
+	attempts        int
+	reconnecting    bool
+	lastMessageAt   time.Time
+	reconnectsTotal int
+	drops           []time.Time // in the last hour, for error reports
+}
+
+// connectionSupervisor is the bridge's supervisor, set once the client exists
+var connectionSupervisor *ConnectionSupervisor
+
+// NewConnectionSupervisor takes over reconnecting from whatsmeow, whose retries back off linearly and
+// give up on some stream errors
+func NewConnectionSupervisor(client *whatsmeow.Client, logger waLog.Logger) *ConnectionSupervisor {
+	client.EnableAutoReconnect = false
+	return &ConnectionSupervisor{client: client, logger: logger, state: connectionConnecting, since: time.Now()}
+}
+
+// reconnectDelay returns how long to wait before a reconnect attempt (0 is the first)
+func reconnectDelay(attempt int) time.Duration {
+	delay := reconnectMaxDelay
+	if attempt < 20 {
+		delay = min(reconnectFirstDelay<<attempt, reconnectMaxDelay)
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+// setState records a connection state change
+func (s *ConnectionSupervisor) setState(state, lastError string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != state {
+		s.state, s.since = state, time.Now()
+	}
+	if lastError != "" {
+		s.lastError = lastError
+	}
+}
+
+// HandleEvent follows the connection events and starts reconnecting when the connection drops
+func (s *ConnectionSupervisor) HandleEvent(evt interface{}) {
+	switch v := evt.(type) {
+	case *events.Connected:
+		s.mu.Lock()
+		s.attempts = 0
+		s.mu.Unlock()
+		s.setState(connectionConnected, "")
+	case *events.Disconnected:
+		s.reconnect("disconnected")
+	case *events.StreamError:
+		s.reconnect("stream error " + v.Code)
+	case *events.ConnectFailure:
+		if v.Reason.IsLoggedOut() {
+			s.setState(connectionLoggedOut, v.Reason.String())
+			go reportError(errorEventConnection, "Logged out of WhatsApp; pair the bridge again",
+				map[string]string{"reason": v.Reason.String()}, s.logger)
+			return
+		}
+		s.reconnect("connect failure: " + v.Reason.String())
+	case *events.KeepAliveTimeout:
+		// A half-open socket keeps timing out without ever disconnecting
+		if v.ErrorCount >= keepAliveFailuresBeforeReconnect {
+			go func() {
+				s.client.Disconnect()
+				s.reconnect("keepalive timeouts")
+			}()
+		}
+	case *events.KeepAliveRestored:
+		s.setState(connectionConnected, "")
+	case *events.StreamReplaced:
+		// Reconnecting would kick the other client off in turn
+		s.setState(connectionStreamReplaced, "stream replaced by another client")
+		go reportError(errorEventConnection, "Another client took over the WhatsApp session; the bridge stopped reconnecting", nil, s.logger)
+	case *events.LoggedOut:
+		s.setState(connectionLoggedOut, v.Reason.String())
+		go reportError(errorEventConnection, "Logged out of WhatsApp; pair the bridge again",
+			map[string]string{"reason": v.Reason.String()}, s.logger)
+	}
+}
+
+// MessageReceived records that a message arrived, for /health
+func (s *ConnectionSupervisor) MessageReceived(at time.Time) {
+	s.mu.Lock()
+	s.lastMessageAt = at
+	s.mu.Unlock()
+}
+
+// reconnect connects again with growing delays until it works, unless a reconnect is already running
+func (s *ConnectionSupervisor) reconnect(reason string) {
+	s.mu.Lock()
+	if s.reconnecting || s.state == connectionLoggedOut || s.state == connectionStreamReplaced {
+		s.mu.Unlock()
+		return
+	}
+	s.reconnecting = true
+	now := time.Now()
+	s.drops = append(slices.DeleteFunc(s.drops, func(at time.Time) bool { return now.Sub(at) > time.Hour }), now)
+	drops := len(s.drops)
+	s.mu.Unlock()
+	s.setState(connectionReconnecting, reason)
+	s.logger.Warnf("WhatsApp connection lost (%s), reconnecting", reason)
+	if drops >= errorReportDropsPerHour {
+		go reportError(errorEventConnection, fmt.Sprintf("WhatsApp connection dropped %d times in the last hour", drops),
+			map[string]string{"reason": reason}, s.logger)
+	}
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			s.reconnecting = false
+			s.mu.Unlock()
+		}()
+		for {
+			s.mu.Lock()
+			attempt := s.attempts
+			s.attempts++
+			state := s.state
+			s.mu.Unlock()
+			if state == connectionLoggedOut || state == connectionStreamReplaced {
+				return
+			}
+
+			delay := reconnectDelay(attempt)
+			s.logger.Infof("Reconnect attempt %d in %v", attempt+1, delay.Round(time.Second))
+			time.Sleep(delay)
+			if s.client.IsConnected() {
+				return
+			}
+			err := s.client.Connect()
+			if err == nil {
+				s.mu.Lock()
+				s.reconnectsTotal++
+				s.mu.Unlock()
+				s.logger.Infof("Reconnected to WhatsApp after %d attempts", attempt+1)
+				return
+			}
+			s.setState(connectionReconnecting, err.Error())
+			s.logger.Warnf("Reconnect attempt %d failed: %v", attempt+1, err)
+			if attempt+1 == errorReportReconnectAttempts {
+				go reportError(errorEventConnection, fmt.Sprintf("Still disconnected from WhatsApp after %d reconnect attempts", attempt+1),
+					map[string]string{"reason": reason, "error": err.Error()}, s.logger)
+			}
+		}
+	}()
+}
+
+// ConnectionHealth is the WhatsApp side of /health
+type ConnectionHealth struct {
+	State             string     `json:"state"`
+	Connected         bool       `json:"connected"`
+	LoggedIn          bool       `json:"logged_in"`
+	Since             time.Time  `json:"since"` // when the connection entered its state
+	ReconnectAttempts int        `json:"reconnect_attempts"`
+	Reconnects        int        `json:"reconnects"` // since the bridge started
+	LastError         string     `json:"last_error,omitempty"`
+	LastMessageAt     *time.Time `json:"last_message_at,omitempty"`
+}
+
+// DatabaseHealth is a database's part of /health
+type DatabaseHealth struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Health is the /health response
+type Health struct {
+	Status    string           `json:"status"` // ok, or down with HTTP 503
+	WhatsApp  ConnectionHealth `json:"whatsapp"`
+	Databases []DatabaseHealth `json:"databases"`
+	SafeMode  bool             `json:"safe_mode"`
+	ReadOnly  bool             `json:"read_only"`
+	CheckedAt time.Time        `json:"checked_at"`
+}
+
+// connectionHealth returns the connection's current state
+func (s *ConnectionSupervisor) connectionHealth() ConnectionHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	health := ConnectionHealth{
+		State:             s.state,
+		Connected:         s.client.IsConnected(),
+		LoggedIn:          s.client.IsLoggedIn(),
+		Since:             s.since,
+		ReconnectAttempts: s.attempts,
+		Reconnects:        s.reconnectsTotal,
+		LastError:         s.lastError,
+	}
+	if !s.lastMessageAt.IsZero() {
+		lastMessageAt := s.lastMessageAt
+		health.LastMessageAt = &lastMessageAt
+	}
+	return health
+}
+
+// checkDatabase runs a trivial query against a database
+func checkDatabase(name string, db *sql.DB) DatabaseHealth {
+	ctx, cancel := context.WithTimeout(context.Background(), healthDBTimeout)
+	defer cancel()
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1 FROM chats LIMIT 1").Scan(&one); err != nil && err != sql.ErrNoRows {
+		return DatabaseHealth{Name: name, Error: err.Error()}
+	}
+	return DatabaseHealth{Name: name, OK: true}
+}
+
+// handleHealth reports the WhatsApp connection and the databases (GET /health). It needs no token, so Docker
+// healthchecks and monitors can call it, and answers 503 unless WhatsApp is connected and every database works.
+func handleHealth(supervisor *ConnectionSupervisor, messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		health := Health{
+			Status:    "ok",
+			WhatsApp:  supervisor.connectionHealth(),
+			Databases: []DatabaseHealth{checkDatabase("messages", messageStore.db)},
+			SafeMode:  isSafeModeActive(),
+			ReadOnly:  readOnlyModeEnabled(),
+			CheckedAt: time.Now(),
+		}
+		if messageStore.direct != nil {
+			health.Databases = append(health.Databases, checkDatabase("direct", messageStore.direct))
+		}
+
+		healthy := health.WhatsApp.Connected && health.WhatsApp.LoggedIn
+		for _, db := range health.Databases {
+			healthy = healthy && db.OK
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !healthy {
+			health.Status = "down"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(health)
+	}
+}
+
+// bridgeStartedAt is when the process started, for /healthz
+var bridgeStartedAt = time.Now()
+
+// ReadinessCheck is one dependency /readyz checked
+type ReadinessCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// checkDatabaseWritable creates a table in a transaction it rolls back, which fails on a read-only or full disk,
+// or while another writer holds the database for longer than the health check waits
+func checkDatabaseWritable(name string, db *sql.DB) ReadinessCheck {
+	ctx, cancel := context.WithTimeout(context.Background(), healthDBTimeout)
+	defer cancel()
+	tx, err := db.BeginTx(ctx, nil)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, "CREATE TABLE readiness_probe (id INTEGER)")
+		tx.Rollback()
+	}
+	if err != nil {
+		return ReadinessCheck{Name: "database " + name, Error: err.Error()}
+	}
+	return ReadinessCheck{Name: "database " + name, OK: true}
+}
+
+// checkLLMReachable checks that the configured LLM provider's server answers HTTP at all: any status will do,
+// since the probe sends no prompt (and no key), so it costs nothing
+func checkLLMReachable() ReadinessCheck {
+	providerName := getLLMProviderName()
+	check := ReadinessCheck{Name: "llm " + providerName}
+	ctx, cancel := context.WithTimeout(context.Background(), readinessLLMTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, getLLMProviderURL(providerName), nil)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	resp.Body.Close()
+	check.OK = true
+	return check
+}
+
+// readinessChecksLLM reports whether /readyz requires the LLM (READINESS_CHECK_LLM, default true); turn it off
+// when the bridge should take traffic while summaries are down
+func readinessChecksLLM() bool {
+	return os.Getenv("READINESS_CHECK_LLM") != "false"
+}
+
+// handleLiveness answers 200 while the process serves HTTP (GET /healthz), for restarting a hung bridge.
+// It checks nothing else: a dropped connection is reconnected by the bridge itself, and restarting doesn't help that.
+func handleLiveness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "uptime_seconds": int(time.Since(bridgeStartedAt).Seconds())})
+}
+
+// handleReadiness answers 200 when the bridge can do its work (GET /readyz): WhatsApp is connected and logged in,
+// the databases take writes and the LLM endpoint answers; otherwise 503 with the failing checks. It needs no token.
+func handleReadiness(supervisor *ConnectionSupervisor, messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		connection := supervisor.connectionHealth()
+		whatsapp := ReadinessCheck{Name: "whatsapp", OK: connection.Connected && connection.LoggedIn}
+		if !whatsapp.OK {
+			whatsapp.Error = connection.State
+			if connection.LastError != "" {
+				whatsapp.Error += ": " + connection.LastError
+			}
+		}
+		checks := []ReadinessCheck{whatsapp, checkDatabaseWritable("messages", messageStore.db)}
+		if messageStore.direct != nil {
+			checks = append(checks, checkDatabaseWritable("direct", messageStore.direct))
+		}
+		if readinessChecksLLM() {
+			checks = append(checks, checkLLMReachable())
+		}
+
+		status, code := "ready", http.StatusOK
+		for _, check := range checks {
+			if !check.OK {
+				status, code = "not_ready", http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "checks": checks, "checked_at": time.Now()})
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A small CRM layer: yearly dates of contacts, such as birthdays and anniversaries, kept in contact_dates. They
+// are imported from a CSV or set with the dates tool or the /dates command, and the dates tool's remind command,
+// run daily, reminds me of them in my self-chat with a greeting drafted to forward.
+
+const defaultContactDateLabel = "birthday"
+
+// ContactDate is a yearly date of a contact
+type ContactDate struct {
+	ID             int64
+	ContactJID     string
+	Name           string // as given; the contact's name in the contacts table comes first
+	Label          string
+	Month          int
+	Day            int
+	Year           int // 0 when unknown
+	Note           string
+	LastRemindedOn string
+}
+
+// contactDateLayouts are the full dates parseContactDate reads; "/" dates are day first
+var contactDateLayouts = []string{"2006-01-02", "02/01/2006", "2/1/2006", "02.01.2006", "January 2, 2006", "2 January 2006"}
+
+// contactDateYearlessLayouts are the dates without a year parseContactDate reads
+var contactDateYearlessLayouts = []string{"01-02", "--01-02", "02/01", "2/1", "02.01", "January 2", "Jan 2", "2 January", "2 Jan"}
+
+// parseContactDate reads a date with or without a year: "1990-03-14", "03-14", "14/03/1990", "14/03", "March 14"
+// or the vCard "--03-14"
+func parseContactDate(value string) (month, day, year int, err error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range contactDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return int(date.Month()), date.Day(), date.Year(), nil
+		}
+	}
+	for _, layout := range contactDateYearlessLayouts {
+		// Parsed in a leap year, so February 29 is accepted
+		if date, err := time.Parse(layout+" 2006", value+" 2000"); err == nil {
+			return int(date.Month()), date.Day(), 0, nil
+		}
+	}
+	return 0, 0, 0, fmt.Errorf("invalid date %q, use YYYY-MM-DD, MM-DD, DD/MM/YYYY or DD/MM", value)
+}
+
+// nextOccurrence returns the first day of a date from a day on, at midnight in the day's location; February 29
+// falls on February 28 in other years
+func (date ContactDate) nextOccurrence(from time.Time) time.Time {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	for year := from.Year(); ; year++ {
+		day := date.Day
+		if date.Month == 2 && day == 29 && time.Date(year, 2, 29, 0, 0, 0, 0, time.UTC).Day() != 29 {
+			day = 28
+		}
+		if occurrence := time.Date(year, time.Month(date.Month), day, 0, 0, 0, 0, from.Location()); !occurrence.Before(from) {
+			return occurrence
+		}
+	}
+}
+
+// format renders a date as "March 14", or "March 14, 1990" with its year
+func (date ContactDate) format() string {
+	day := time.Date(2000, time.Month(date.Month), date.Day, 0, 0, 0, 0, time.UTC)
+	if date.Year > 0 {
+		return fmt.Sprintf("%s, %d", day.Format("January 2"), date.Year)
+	}
+	return day.Format("January 2")
+}
+
+// displayName returns the contact's name from the contacts table, the name given with the date, or the phone number
+func (date ContactDate) displayName(db *sql.DB) string {
+	if name := lookupSenderName(db, date.ContactJID); name != "" {
+		return name
+	}
+	if date.Name != "" {
+		return date.Name
+	}
+	return strings.Split(date.ContactJID, "@")[0]
+}
+
+// resolveContactReference returns the JID of a contact given by JID, phone number or name; a name must match
+// exactly one saved contact
+func resolveContactReference(db *sql.DB, reference string) (string, error) {
+	reference = strings.TrimSpace(reference)
+	if reference == "" {
+		return "", fmt.Errorf("no contact given")
+	}
+	if strings.Contains(reference, "@") || strings.Trim(reference, "+0123456789 -()") == "" {
+		return resolveChatArgument(reference)
+	}
+	rows, err := db.Query(`
+		SELECT jid, COALESCE(name, '') FROM contacts
+		WHERE jid LIKE '%@s.whatsapp.net'
+			AND (name = ? COLLATE NOCASE OR full_name = ? COLLATE NOCASE OR first_name = ? COLLATE NOCASE OR push_name = ? COLLATE NOCASE)
+	`, reference, reference, reference, reference)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up contact: %v", err)
+	}
+	defer rows.Close()
+	var jids, matches []string
+	for rows.Next() {
+		var jid, name string
+		if err := rows.Scan(&jid, &name); err != nil {
+			return "", err
+		}
+		jids = append(jids, jid)
+		matches = append(matches, fmt.Sprintf("%s (%s)", name, strings.Split(jid, "@")[0]))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no contact named %q, give the phone number", reference)
+	case 1:
+		return jids[0], nil
+	default:
+		return "", fmt.Errorf("%q matches %s; give the phone number", reference, strings.Join(matches, ", "))
+	}
+}
+
+// saveContactDate adds a date of a contact, or replaces the contact's date with the same label
+func saveContactDate(db *sql.DB, date ContactDate) (int64, error) {
+	date.Label = strings.ToLower(strings.TrimSpace(date.Label))
+	if date.Label == "" {
+		date.Label = defaultContactDateLabel
+	}
+	_, err := db.Exec(`
+		INSERT INTO contact_dates (contact_jid, name, label, month, day, year, note, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (contact_jid, label) DO UPDATE SET
+			name = CASE WHEN excluded.name != '' THEN excluded.name ELSE contact_dates.name END,
+			month = excluded.month, day = excluded.day, year = excluded.year, note = excluded.note
+	`, date.ContactJID, date.Name, date.Label, date.Month, date.Day, date.Year, date.Note, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to save date: %v", err)
+	}
+	var id int64
+	err = db.QueryRow("SELECT id FROM contact_dates WHERE contact_jid = ? AND label = ?", date.ContactJID, date.Label).Scan(&id)
+	return id, err
+}
+
+// deleteContactDate removes a date; false when there is none with this ID
+func deleteContactDate(db *sql.DB, id int64) (bool, error) {
+	result, err := db.Exec("DELETE FROM contact_dates WHERE id = ?", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete date: %v", err)
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted > 0, nil
+}
+
+// listContactDates returns every date, in calendar order
+func listContactDates(db *sql.DB) ([]ContactDate, error) {
+	rows, err := db.Query(`
+		SELECT id, contact_jid, name, label, month, day, year, note, last_reminded_on FROM contact_dates
+		ORDER BY month, day, contact_jid
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dates: %v", err)
+	}
+	defer rows.Close()
+
+	var dates []ContactDate
+	for rows.Next() {
+		var date ContactDate
+		if err := rows.Scan(&date.ID, &date.ContactJID, &date.Name, &date.Label, &date.Month, &date.Day, &date.Year, &date.Note, &date.LastRemindedOn); err != nil {
+			return nil, err
+		}
+		dates = append(dates, date)
+	}
+	return dates, rows.Err()
+}
+
+// UpcomingContactDate is a date with its next occurrence
+type UpcomingContactDate struct {
+	ContactDate
+	On time.Time
+}
+
+// upcomingContactDates returns the dates falling within days of today (0 for today only), soonest first
+func upcomingContactDates(db *sql.DB, today time.Time, days int) ([]UpcomingContactDate, error) {
+	dates, err := listContactDates(db)
+	if err != nil {
+		return nil, err
+	}
+	last := time.Date(today.Year(), today.Month(), today.Day()+days, 0, 0, 0, 0, today.Location())
+	var upcoming []UpcomingContactDate
+	for _, date := range dates {
+		if on := date.nextOccurrence(today); !on.After(last) {
+			upcoming = append(upcoming, UpcomingContactDate{ContactDate: date, On: on})
+		}
+	}
+	// Dates of next year come after December's
+	sort.SliceStable(upcoming, func(i, j int) bool { return upcoming[i].On.Before(upcoming[j].On) })
+	return upcoming, nil
+}
+
+// importContactDates saves the dates of a CSV with a header row: a "phone", "jid" or "contact" column, and a
+// "date" column with an optional "label", or "birthday" and "anniversary" columns; "name" and "note" are
+// optional. It returns how many dates were saved and the problems of the rows left out.
+func importContactDates(db *sql.DB, input io.Reader) (int, []string, error) {
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	contactColumn := -1
+	for _, name := range []string{"phone", "jid", "contact"} {
+		if i, ok := columns[name]; ok {
+			contactColumn = i
+			break
+		}
+	}
+	if contactColumn < 0 {
+		return 0, nil, fmt.Errorf("the CSV needs a phone, jid or contact column")
+	}
+	dateColumns := make(map[string]int)
+	for _, label := range []string{"date", "birthday", "anniversary"} {
+		if i, ok := columns[label]; ok {
+			dateColumns[label] = i
+		}
+	}
+	if len(dateColumns) == 0 {
+		return 0, nil, fmt.Errorf("the CSV needs a date, birthday or anniversary column")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	saved := 0
+	var problems []string
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return saved, problems, fmt.Errorf("failed to read CSV: %v", err)
+		}
+		if contactColumn >= len(record) || strings.TrimSpace(record[contactColumn]) == "" {
+			problems = append(problems, fmt.Sprintf("line %d: no contact", line))
+			continue
+		}
+		jid, err := resolveContactReference(db, record[contactColumn])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		for column, i := range dateColumns {
+			if i >= len(record) || strings.TrimSpace(record[i]) == "" {
+				continue
+			}
+			month, day, year, err := parseContactDate(record[i])
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("line %d: %v", line, err))
+				continue
+			}
+			label := column
+			if column == "date" {
+				label = field(record, "label")
+			}
+			date := ContactDate{ContactJID: jid, Name: field(record, "name"), Label: label, Month: month, Day: day, Year: year, Note: field(record, "note")}
+			if _, err := saveContactDate(db, date); err != nil {
+				return saved, problems, err
+			}
+			saved++
+		}
+	}
+	return saved, problems, nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ContactSegment is a saved rule set selecting contacts; all non-empty criteria must match
+type ContactSegment struct {
+	Name             string
+	Description      string
+	Tag              string // contact must carry this tag
+	MemberOf         string // contact must have written in this chat JID
+	ActiveWithinDays int    // last interaction no older than N days
+	InactiveForDays  int    // no interaction in the last N days
+}
+
+// segmentTargetPrefix marks a recipient that should be expanded into a segment's members (e.g. "segment:vip")
+const segmentTargetPrefix = "segment:"
+
+// ensureSegmentTables creates the contact tag and segment tables if they don't exist
+func ensureSegmentTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS contact_tags (
+			jid TEXT,
+			tag TEXT,
+			created_at TIMESTAMP,
+			PRIMARY KEY (jid, tag)
+		);
+
+		CREATE TABLE IF NOT EXISTS contact_segments (
+			name TEXT PRIMARY KEY,
+			description TEXT,
+			tag TEXT,
+			member_of TEXT,
+			active_within_days INTEGER DEFAULT 0,
+			inactive_for_days INTEGER DEFAULT 0,
+			updated_at TIMESTAMP
+		);
+	`)
+	return err
+}
+
+// normalizeContactJID converts a phone number or sender user part into a full user JID
+func normalizeContactJID(contact string) string {
+	contact = strings.TrimSpace(contact)
+	if contact == "" || strings.Contains(contact, "@") {
+		return contact
+	}
+	return strings.TrimPrefix(contact, "+") + "@s.whatsapp.net"
+}
+
+// tagContact adds a tag to a contact
+func tagContact(db *sql.DB, jid, tag string) error {
+	_, err := db.Exec(
+		"INSERT OR IGNORE INTO contact_tags (jid, tag, created_at) VALUES (?, ?, ?)",
+		normalizeContactJID(jid), strings.ToLower(strings.TrimSpace(tag)), time.Now(),
+	)
+	return err
+}
+
+// untagContact removes a tag from a contact
+func untagContact(db *sql.DB, jid, tag string) error {
+	_, err := db.Exec(
+		"DELETE FROM contact_tags WHERE jid = ? AND tag = ?",
+		normalizeContactJID(jid), strings.ToLower(strings.TrimSpace(tag)),
+	)
+	return err
+}
+
+// saveSegment creates or replaces a segment definition
+func saveSegment(db *sql.DB, segment ContactSegment) error {
+	if segment.Name == "" {
+		return fmt.Errorf("segment name is required")
+	}
+	_, err := db.Exec(
+		`INSERT OR REPLACE INTO contact_segments (name, description, tag, member_of, active_within_days, inactive_for_days, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		segment.Name, segment.Description, strings.ToLower(segment.Tag), segment.MemberOf,
+		segment.ActiveWithinDays, segment.InactiveForDays, time.Now(),
+	)
+	return err
+}
+
+// deleteSegment removes a segment definition
+func deleteSegment(db *sql.DB, name string) error {
+	_, err := db.Exec("DELETE FROM contact_segments WHERE name = ?", name)
+	return err
+}
+
+// getSegment loads a segment definition by name
+func getSegment(db *sql.DB, name string) (*ContactSegment, error) {
+	var segment ContactSegment
+	err := db.QueryRow(
+		`SELECT name, COALESCE(description, ''), COALESCE(tag, ''), COALESCE(member_of, ''), active_within_days, inactive_for_days
+		FROM contact_segments WHERE name = ?`, name,
+	).Scan(&segment.Name, &segment.Description, &segment.Tag, &segment.MemberOf, &segment.ActiveWithinDays, &segment.InactiveForDays)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("segment %q not found", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &segment, nil
+}
+
+// listSegments returns all segment definitions ordered by name
+func listSegments(db *sql.DB) ([]ContactSegment, error) {
+	rows, err := db.Query(`
+		SELECT name, COALESCE(description, ''), COALESCE(tag, ''), COALESCE(member_of, ''), active_within_days, inactive_for_days
+		FROM contact_segments ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var segments []ContactSegment
+	for rows.Next() {
+		var segment ContactSegment
+		if err := rows.Scan(&segment.Name, &segment.Description, &segment.Tag, &segment.MemberOf, &segment.ActiveWithinDays, &segment.InactiveForDays); err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// resolveSegment returns the JIDs of all contacts matching a segment's criteria
+func resolveSegment(db *sql.DB, name string) ([]string, error) {
+	segment, err := getSegment(db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start from the narrowest candidate set available
+	var query string
+	var args []interface{}
+	switch {
+	case segment.Tag != "":
+		query = "SELECT jid FROM contact_tags WHERE tag = ?"
+		args = []interface{}{segment.Tag}
+	case segment.MemberOf != "":
+		query = "SELECT DISTINCT sender FROM messages WHERE chat_jid = ? AND is_from_me = 0 AND sender != ''"
+		args = []interface{}{segment.MemberOf}
+	default:
+		query = `
+			SELECT jid FROM chats WHERE jid LIKE '%@s.whatsapp.net'
+			UNION
+			SELECT DISTINCT sender FROM messages WHERE chat_jid LIKE '%@g.us' AND is_from_me = 0 AND sender != ''
+		`
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query segment candidates: %v", err)
+	}
+	candidates := make(map[string]bool)
+	for rows.Next() {
+		var contact string
+		if err := rows.Scan(&contact); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates[normalizeContactJID(contact)] = true
+	}
+	rows.Close()
+
+	var members []string
+	for jid := range candidates {
+		matches, err := contactMatchesSegment(db, jid, segment)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			members = append(members, jid)
+		}
+	}
+
+	sort.Strings(members)
+	return members, nil
+}
+
+// contactMatchesSegment checks a single contact against every criterion of a segment
+func contactMatchesSegment(db *sql.DB, jid string, segment *ContactSegment) (bool, error) {
+	phone := strings.Split(jid, "@")[0]
+
+	if segment.Tag != "" {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM contact_tags WHERE jid = ? AND tag = ?", jid, segment.Tag).Scan(&count); err != nil {
+			return false, err
+		}
+		if count == 0 {
+			return false, nil
+		}
+	}
+
+	if segment.MemberOf != "" {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE chat_jid = ? AND sender = ?", segment.MemberOf, phone).Scan(&count); err != nil {
+			return false, err
+		}
+		if count == 0 {
+			return false, nil
+		}
+	}
+
+	if segment.ActiveWithinDays > 0 || segment.InactiveForDays > 0 {
+		lastInteraction, err := getLastInteractionTime(db, jid)
+		if err != nil {
+			return false, err
+		}
+		if segment.ActiveWithinDays > 0 && (lastInteraction.IsZero() || time.Since(lastInteraction) > time.Duration(segment.ActiveWithinDays)*24*time.Hour) {
+			return false, nil
+		}
+		if segment.InactiveForDays > 0 && !lastInteraction.IsZero() && time.Since(lastInteraction) < time.Duration(segment.InactiveForDays)*24*time.Hour {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// getLastInteractionTime returns the time of the most recent message in the contact's direct chat or sent by the contact anywhere
+func getLastInteractionTime(db *sql.DB, jid string) (time.Time, error) {
+	var lastInteraction sql.NullString
+	phone := strings.Split(jid, "@")[0]
+	err := db.QueryRow(`
+		SELECT MAX(timestamp) FROM messages WHERE chat_jid = ? OR sender = ?
+	`, jid, phone).Scan(&lastInteraction)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !lastInteraction.Valid {
+		return time.Time{}, nil
+	}
+	return parseSQLiteTime(lastInteraction.String), nil
+}
+
+// expandRecipients expands "segment:<name>" and "broadcast:<list>" entries (or list JIDs) in a comma-separated
+// recipient list into member JIDs
+func expandRecipients(db *sql.DB, recipients string) ([]string, error) {
+	var expanded []string
+	seen := make(map[string]bool)
+	for _, recipient := range strings.Split(recipients, ",") {
+		recipient = strings.TrimSpace(recipient)
+		if recipient == "" {
+			continue
+		}
+
+		targets := []string{recipient}
+		if strings.HasPrefix(recipient, segmentTargetPrefix) {
+			members, err := resolveSegment(db, strings.TrimPrefix(recipient, segmentTargetPrefix))
+			if err != nil {
+				return nil, err
+			}
+			targets = members
+		} else if isBroadcastTarget(recipient) {
+			members, err := resolveBroadcastList(db, recipient)
+			if err != nil {
+				return nil, err
+			}
+			targets = members
+		}
+
+		for _, target := range targets {
+			if !seen[target] {
+				seen[target] = true
+				expanded = append(expanded, target)
+			}
+		}
+	}
+	return expanded, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ensureContactsTable creates the copy of the whatsmeow contact store the scheduled tools read names from
+func ensureContactsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS contacts (
+			jid TEXT PRIMARY KEY,
+			phone TEXT,
+			name TEXT,
+			full_name TEXT,
+			first_name TEXT,
+			push_name TEXT,
+			business_name TEXT,
+			updated_at TIMESTAMP
+		);
+	`)
+	return err
+}
+
+// contactDisplayName picks the name shown for a contact: the saved name, then the one they chose
+func contactDisplayName(info types.ContactInfo) string {
+	for _, name := range []string{info.FullName, info.FirstName, info.PushName, info.BusinessName} {
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// stripAccents removes diacritics from a name, so "João" matches "joao"
+func stripAccents(name string) string {
+	var stripped strings.Builder
+	for _, r := range norm.NFD.String(name) {
+		if !unicode.Is(unicode.Mn, r) {
+			stripped.WriteRune(r)
+		}
+	}
+	return stripped.String()
+}
+
+// lookupSenderName returns the contact or chat name of a message sender (a phone number, LID or JID), or ""
+// when it has none
+func lookupSenderName(db *sql.DB, sender string) string {
+	user := strings.Split(sender, "@")[0]
+	var name string
+	err := db.QueryRow("SELECT name FROM contacts WHERE jid IN (?, ?, ?) AND COALESCE(name, '') != '' LIMIT 1",
+		sender, user+"@s.whatsapp.net", user+"@lid").Scan(&name)
+	if err == nil {
+		return name
+	}
+	err = db.QueryRow("SELECT name FROM chats WHERE jid IN (?, ?) AND COALESCE(name, '') != '' LIMIT 1",
+		sender, user+"@s.whatsapp.net").Scan(&name)
+	if err == nil {
+		return name
+	}
+	return ""
+}
+
+// execer is what upsertContact needs from a database or transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// upsertContact copies a contact from the whatsmeow store into the contacts table
+func upsertContact(db execer, jid types.JID, info types.ContactInfo, now time.Time) error {
+	phone := ""
+	if jid.Server == types.DefaultUserServer {
+		phone = jid.User
+	}
+	_, err := db.Exec(`
+		INSERT INTO contacts (jid, phone, name, full_name, first_name, push_name, business_name, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET
+			phone = excluded.phone,
+			name = excluded.name,
+			full_name = excluded.full_name,
+			first_name = excluded.first_name,
+			push_name = excluded.push_name,
+			business_name = excluded.business_name,
+			updated_at = excluded.updated_at
+	`, jid.String(), phone, contactDisplayName(info), info.FullName, info.FirstName, info.PushName, info.BusinessName, now)
+	return err
+}
+
+// syncContacts copies the whole whatsmeow contact store into the contacts table
+func syncContacts(client *whatsmeow.Client, db *sql.DB, logger waLog.Logger) {
+	contacts, err := client.Store.Contacts.GetAllContacts(context.Background())
+	if err != nil {
+		logger.Warnf("Failed to read contacts for sync: %v", err)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Warnf("Failed to sync contacts: %v", err)
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	for jid, info := range contacts {
+		if err := upsertContact(tx, jid, info, now); err != nil {
+			logger.Warnf("Failed to sync contact %s: %v", jid, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Warnf("Failed to sync contacts: %v", err)
+		return
+	}
+	logger.Infof("Synced %d contacts", len(contacts))
+}
+
+// refreshContact copies one contact again after whatsmeow updated it (contact, push name or business name events)
+func refreshContact(client *whatsmeow.Client, db *sql.DB, jid types.JID, logger waLog.Logger) {
+	jid = jid.ToNonAD()
+	info, err := client.Store.Contacts.GetContact(context.Background(), jid)
+	if err != nil {
+		logger.Warnf("Failed to read contact %s: %v", jid, err)
+		return
+	}
+	if err := upsertContact(db, jid, info, time.Now()); err != nil {
+		logger.Warnf("Failed to store contact %s: %v", jid, err)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const copilotFile = "store/copilot.json"
+
+const (
+	// Messages of the chat given to a new session as background
+	copilotHistoryMessages = 30
+
+	// Drafts per session before it is restarted from the recent history, so API prompts don't grow without bound
+	copilotMaxDrafts = 25
+)
+
+const copilotPrompt = `You are my co-pilot during a live WhatsApp conversation ({{CHAT}}). I am "Me" in the transcript.
+{{HISTORY}}
+New messages:
+{{MESSAGES}}
+
+Draft the reply I should send next, in the language of the conversation and in my tone. Keep the goals I have shown so far, don't commit me to anything I haven't agreed to, and point out anything I should not miss (a deadline, a change of terms, an unanswered question).
+
+Answer in this format:
+💡 <the suggested reply, ready to copy>
+📝 <one or two lines on why, or what to watch out for>
+
+If no reply is needed yet, answer only: 💡 (no reply needed) — <why>`
+
+// CopilotState is the chat the co-pilot follows; it is persisted so the co-pilot survives restarts
+type CopilotState struct {
+	ChatJID   string    `json:"chat_jid"` // empty when switched off
+	StartedBy string    `json:"started_by"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Copilot streams the messages of one chat to an LLM session that drafts replies into my self-chat
+type Copilot struct {
+	mu       sync.Mutex
+	chatJID  string
+	ctx      context.Context // carries the session
+	drafts   int
+	pending  []StreamEvent
+	timer    *time.Timer
+	drafting sync.Mutex
+
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	logger       waLog.Logger
+}
+
+var copilot *Copilot
+
+// getCopilotDebounce returns how long the chat must be quiet before a draft is made (COPILOT_DEBOUNCE_SECONDS, default 20)
+func getCopilotDebounce() time.Duration {
+	if seconds, err := strconv.Atoi(os.Getenv("COPILOT_DEBOUNCE_SECONDS")); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return 20 * time.Second
+}
+
+// loadCopilotState returns the persisted co-pilot state, or nil when it was never set
+func loadCopilotState() (*CopilotState, error) {
+	data, err := os.ReadFile(copilotFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read co-pilot file: %v", err)
+	}
+	var state CopilotState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse co-pilot file: %v", err)
+	}
+	return &state, nil
+}
+
+// saveCopilotState persists the co-pilot state
+func saveCopilotState(state CopilotState) error {
+	if err := os.MkdirAll("store", 0755); err != nil {
+		return fmt.Errorf("failed to create store directory: %v", err)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(copilotFile, data, 0644)
+}
+
+// startCopilot starts following the persisted chat, or COPILOT_CHAT when none was set with /copilot
+func startCopilot(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) {
+	copilot = &Copilot{client: client, messageStore: messageStore, logger: logger}
+
+	state, err := loadCopilotState()
+	if err != nil {
+		logger.Warnf("%v", err)
+	}
+	chatJID := ""
+	if state != nil {
+		chatJID = state.ChatJID
+	} else if chat := os.Getenv("COPILOT_CHAT"); chat != "" {
+		if chatJID, err = resolveChatReference(chat); err != nil {
+			logger.Warnf("Co-pilot disabled, COPILOT_CHAT: %v", err)
+		}
+	}
+	copilot.follow(chatJID)
+
+	events := eventHub.Subscribe()
+	go func() {
+		for event := range events {
+			copilot.observe(event)
+		}
+	}()
+}
+
+// follow switches the co-pilot to a chat ("" switches it off) with a fresh session
+func (c *Copilot) follow(chatJID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.chatJID = chatJID
+	c.pending = nil
+	c.drafts = 0
+	c.ctx = nil
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	if chatJID != "" {
+		c.logger.Infof("Co-pilot following %s", chatJID)
+	}
+}
+
+// observe queues a message of the followed chat and schedules a draft once the chat is quiet
+func (c *Copilot) observe(event StreamEvent) {
+	if event.Type != "message" || event.Origin != "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.chatJID == "" || event.ChatJID != c.chatJID {
+		return
+	}
+
+	c.pending = append(c.pending, event)
+	// My own messages are context for the next draft, only the other side's messages call for one
+	if event.IsFromMe {
+		return
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.timer = time.AfterFunc(getCopilotDebounce(), c.draft)
+}
+
+// draft sends the pending messages to the session and forwards the suggested reply to my self-chat
+func (c *Copilot) draft() {
+	// One draft at a time, so the session sees the messages in order
+	c.drafting.Lock()
+	defer c.drafting.Unlock()
+
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = nil
+	chatJID := c.chatJID
+	if len(pending) == 0 || chatJID == "" {
+		c.mu.Unlock()
+		return
+	}
+	newSession := c.ctx == nil || c.drafts >= copilotMaxDrafts
+	if newSession {
+		c.ctx = context.WithValue(withLLMAuditScope(context.Background(), chatJID, time.Now().Format("2006-01-02")), llmSessionKey{}, &LLMSession{})
+		c.drafts = 0
+	}
+	ctx := c.ctx
+	c.drafts++
+	c.mu.Unlock()
+
+	if isSafeModeActive() {
+		c.logger.Infof("Safe mode is on, not drafting a co-pilot reply for %s", chatJID)
+		return
+	}
+	if readOnlyModeEnabled() {
+		c.logger.Infof("Read-only mode is on, not drafting a co-pilot reply for %s", chatJID)
+		return
+	}
+
+	history := ""
+	if newSession {
+		history = c.history(chatJID, pending[0].Timestamp)
+	}
+	chatName := pending[0].ChatName
+	if chatName == "" {
+		chatName = chatJID
+	}
+	prompt := strings.NewReplacer(
+		"{{CHAT}}", chatName,
+		"{{HISTORY}}", history,
+		"{{MESSAGES}}", formatCopilotEvents(pending),
+	).Replace(copilotPrompt)
+
+	response, err := callLLM(withLLMPurpose(ctx, "copilot"), prompt)
+	if err != nil {
+		c.logger.Errorf("Co-pilot failed to draft a reply for %s: %v", chatJID, err)
+		return
+	}
+
+	draft := fmt.Sprintf("🧭 *Co-pilot* — %s\n\n%s", chatName, strings.TrimSpace(response))
+	if success, status := sendWhatsAppMessage(c.client, c.messageStore, "self", draft, "", messageOriginClaude, ""); !success {
+		c.logger.Errorf("Failed to send co-pilot draft: %s", status)
+	}
+}
+
+// history renders the messages of the chat before the pending ones, as background for a new session
+func (c *Copilot) history(chatJID string, before time.Time) string {
+	messages, err := c.messageStore.GetMessages(chatJID, copilotHistoryMessages+10)
+	if err != nil {
+		c.logger.Warnf("Failed to load co-pilot history: %v", err)
+		return ""
+	}
+
+	var lines []string
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if !msg.Time.Before(before) {
+			continue
+		}
+		sender := msg.Sender
+		if msg.IsFromMe {
+			sender = "Me"
+		}
+		content := msg.Content
+		if msg.Deleted {
+			content = "[deleted]"
+		} else if content == "" && msg.MediaType != "" {
+			content = "[" + msg.MediaType + "]"
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", msg.Time.Format("Jan 2 15:04"), sender, content))
+	}
+	if len(lines) > copilotHistoryMessages {
+		lines = lines[len(lines)-copilotHistoryMessages:]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "\nEarlier in the conversation:\n" + strings.Join(lines, "\n") + "\n"
+}
+
+// formatCopilotEvents renders new messages as transcript lines
+func formatCopilotEvents(events []StreamEvent) string {
+	var lines []string
+	for _, event := range events {
+		sender := event.SenderName
+		if event.IsFromMe {
+			sender = "Me"
+		}
+		content := event.Content
+		if event.MediaType != "" {
+			content = strings.TrimSpace(fmt.Sprintf("[%s] %s", event.MediaType, content))
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", event.Timestamp.Format("15:04"), sender, content))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isCopilotCommand reports whether a message is a /copilot admin command
+func isCopilotCommand(content string) bool {
+	fields := strings.Fields(content)
+	return len(fields) > 0 && strings.EqualFold(fields[0], "/copilot")
+}
+
+// handleCopilotCommand handles "/copilot <chat>", "/copilot off" and "/copilot status"
+func handleCopilotCommand(command, updatedBy string) string {
+	if copilot == nil {
+		return "❌ The co-pilot is not running"
+	}
+	argument := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(command), strings.Fields(command)[0]))
+
+	switch strings.ToLower(argument) {
+	case "", "status":
+		copilot.mu.Lock()
+		chatJID := copilot.chatJID
+		copilot.mu.Unlock()
+		if chatJID == "" {
+			return "🧭 Co-pilot off. Start it with /copilot <chat name, phone number or JID>."
+		}
+		return fmt.Sprintf("🧭 Co-pilot following %s. Stop it with /copilot off.", chatJID)
+	case "off":
+		if err := saveCopilotState(CopilotState{StartedBy: updatedBy, StartedAt: time.Now()}); err != nil {
+			return fmt.Sprintf("❌ %v", err)
+		}
+		copilot.follow("")
+		return "✅ Co-pilot off."
+	}
+
+	chatJID, err := resolveChatReference(argument)
+	if err != nil {
+		return fmt.Sprintf("❌ %v", err)
+	}
+	if err := saveCopilotState(CopilotState{ChatJID: chatJID, StartedBy: updatedBy, StartedAt: time.Now()}); err != nil {
+		return fmt.Sprintf("❌ %v", err)
+	}
+	copilot.follow(chatJID)
+	return fmt.Sprintf("🧭 Co-pilot following %s. Suggested replies will arrive in this chat.", chatJID)
+}
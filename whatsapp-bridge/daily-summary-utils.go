@@ -4,27 +4,43 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
 	"go.mau.fi/whatsmeow"
-	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/store"
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
 	waLog "go.mau.fi/whatsmeow/util/log"
-	"google.golang.org/protobuf/proto"
 )
 
 // DailySummaryMessage represents a message for the daily summary
 type DailySummaryMessage struct {
 	Timestamp string `json:"timestamp"`
 	Sender    string `json:"sender"`
-	Content   string `json:"content"`
+	Content   string `json:"content"` // WhatsApp formatting rendered per TRANSCRIPT_FORMAT
 	IsFromMe  bool   `json:"is_from_me"`
+
+	// Provenance, not sent to the LLM
+	ID   string    `json:"-"`
+	Time time.Time `json:"-"`
+	Raw  string    `json:"-"` // content with WhatsApp's own formatting markers, for exports
+
+	MediaType string `json:"-"`
+	Filename  string `json:"-"` // name of the media file, under store/<chat> once downloaded
+
+	DuplicateIDs []string `json:"-"` // repeats of this text collapsed into it before prompting
+
+	Reactions string `json:"reactions,omitempty"` // e.g. "👍×3 ❤️×1"
+	ReplyTo   string `json:"reply_to,omitempty"`  // the message it replies to, e.g. "Ana 14:02"
 }
 
 // TopicSegment represents a topic with its associated messages
@@ -33,25 +49,111 @@ type TopicSegment struct {
 	Summary  string `json:"summary"`
 }
 
+// summaryMessageSchema makes sure, once per process, that messages.db has the columns getMessagesFromGroup reads
+var summaryMessageSchema struct {
+	sync.Once
+	err error
+}
+
+// ensureSummaryMessageSchema adds the columns and tables getMessagesFromGroup reads, on its first call
+func ensureSummaryMessageSchema(db *sql.DB) error {
+	summaryMessageSchema.Do(func() {
+		if err := ensureMessageTypeColumn(db); err != nil {
+			summaryMessageSchema.err = fmt.Errorf("failed to add message_type column: %v", err)
+		} else if err := ensureTranscriptColumn(db); err != nil {
+			summaryMessageSchema.err = fmt.Errorf("failed to add transcript column: %v", err)
+		} else if err := ensureReactionsTable(db); err != nil {
+			summaryMessageSchema.err = fmt.Errorf("failed to create reactions table: %v", err)
+		} else if err := ensureEditColumns(db); err != nil {
+			summaryMessageSchema.err = fmt.Errorf("failed to add edit columns: %v", err)
+		}
+	})
+	return summaryMessageSchema.err
+}
+
+// groupDayMessagesQuery selects a chat's messages between two times, for getMessagesFromGroup
+const groupDayMessagesQuery = `
+	SELECT id, sender, content, timestamp, is_from_me, media_type, filename, COALESCE(message_type, ''), COALESCE(origin, ''), COALESCE(transcript, ''), deleted_at IS NOT NULL
+	FROM messages 
+	WHERE chat_jid = ? 
+	AND timestamp >= ? 
+	AND timestamp <= ?
+	AND (content != '' OR media_type != '')
+	ORDER BY timestamp ASC
+`
+
+// Verdicts of the bridge's scam filter (see scam-filter.go)
+const (
+	scamVerdictScam       = "scam"       // quarantined from the summaries
+	scamVerdictSuspicious = "suspicious" // only reported
+)
+
+// getQuarantinedMessages returns the IDs of a chat's messages flagged as scams in a period, and not released.
+// Without the bridge's message_flags table (an older bridge) there are none.
+func getQuarantinedMessages(db *sql.DB, chatJID string, after, before time.Time) (map[string]bool, error) {
+	quarantined := make(map[string]bool)
+	rows, err := db.Query(`
+		SELECT f.message_id FROM message_flags f
+		JOIN messages m ON m.id = f.message_id AND m.chat_jid = f.chat_jid
+		WHERE f.chat_jid = ? AND f.verdict = ? AND f.released_at IS NULL AND m.timestamp >= ? AND m.timestamp <= ?
+	`, chatJID, scamVerdictScam, after, before)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return quarantined, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		quarantined[id] = true
+	}
+	return quarantined, rows.Err()
+}
+
 // getMessagesFromGroup retrieves all messages from a specific group for the given day
 func getMessagesFromGroup(groupJID string, startOfDay, endOfDay time.Time, logger waLog.Logger) ([]DailySummaryMessage, error) {
-	// Open SQLite database for messages
-	db, err := sql.Open("sqlite3", "file:store/messages.db?_foreign_keys=on")
+	db, err := openSharedMessagesDB()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureSummaryMessageSchema(db); err != nil {
+		return nil, err
+	}
+
+	// How the group reacted to each message, a signal of what mattered
+	reactions, err := getReactionCounts(db, groupJID, startOfDay, endOfDay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reactions: %v", err)
+	}
+
+	// Which message each reply quotes, to keep threads together
+	replyTargets, err := getReplyTargets(db, groupJID, startOfDay, endOfDay)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open message database: %v", err)
+		return nil, fmt.Errorf("failed to get replies: %v", err)
+	}
+
+	// Per-group rules for system, bot and automated messages
+	filter, err := newMessageFilter(db, groupJID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build message filter: %v", err)
+	}
+
+	// Messages the bridge's scam filter quarantined
+	quarantined, err := getQuarantinedMessages(db, groupJID, startOfDay, endOfDay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quarantined messages: %v", err)
 	}
-	defer db.Close()
 
 	// Query messages for the specific group and day
-	rows, err := db.Query(`
-		SELECT id, sender, content, timestamp, is_from_me, media_type, filename
-		FROM messages 
-		WHERE chat_jid = ? 
-		AND timestamp >= ? 
-		AND timestamp <= ?
-		AND (content != '' OR media_type != '')
-		ORDER BY timestamp ASC
-	`, groupJID, startOfDay, endOfDay)
+	stmt, err := prepareMessagesStatement(groupDayMessagesQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare messages query: %v", err)
+	}
+	rows, err := stmt.Query(groupJID, startOfDay, endOfDay)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query messages: %v", err)
 	}
@@ -59,48 +161,27 @@ func getMessagesFromGroup(groupJID string, startOfDay, endOfDay time.Time, logge
 
 	var messages []DailySummaryMessage
 	for rows.Next() {
-		var id, sender, content, mediaType, filename string
-		var timestamp time.Time
-		var isFromMe bool
-
-		err := rows.Scan(&id, &sender, &content, &timestamp, &isFromMe, &mediaType, &filename)
+		var row summaryRow
+		err := rows.Scan(&row.ID, &row.Sender, &row.Content, &row.Timestamp, &row.IsFromMe, &row.MediaType, &row.Filename,
+			&row.MessageType, &row.Origin, &row.Transcript, &row.Deleted)
 		if err != nil {
 			logger.Warnf("Failed to scan message row: %v", err)
 			continue
 		}
 
-		// Format content - if it's media, indicate the media type
-		messageContent := content
-		if mediaType != "" && messageContent == "" {
-			switch mediaType {
-			case "image":
-				messageContent = "[Imagem enviada]"
-			case "video":
-				messageContent = "[Vídeo enviado]"
-			case "audio", "ptt":
-				messageContent = "[Áudio enviado]"
-			case "document":
-				if filename != "" {
-					messageContent = fmt.Sprintf("[Documento: %s]", filename)
-				} else {
-					messageContent = "[Documento enviado]"
-				}
-			default:
-				messageContent = fmt.Sprintf("[%s enviado]", mediaType)
-			}
+		if quarantined[row.ID] {
+			logger.Infof("Leaving out message %s, quarantined as a scam", row.ID)
+			continue
 		}
-
-		// Get sender name for display
-		senderName := getSenderName(sender, isFromMe, logger)
-
-		// Replace @mentions with real names in message content
-		processedContent := replaceMentionsWithNames(messageContent, logger)
-
-		message := DailySummaryMessage{
-			Timestamp: timestamp.Format("15:04"),
-			Sender:    senderName,
-			Content:   processedContent,
-			IsFromMe:  isFromMe,
+		message, ok := toSummaryMessage(row, filter, logger)
+		if !ok {
+			continue
+		}
+		if counts := reactions[row.ID]; len(counts) > 0 {
+			message.Reactions = formatReactionCounts(counts)
+		}
+		if target, ok := replyTargets[row.ID]; ok {
+			message.ReplyTo = formatReplyTarget(target, row.Timestamp, logger)
 		}
 
 		messages = append(messages, message)
@@ -110,6 +191,93 @@ func getMessagesFromGroup(groupJID string, startOfDay, endOfDay time.Time, logge
 	return messages, nil
 }
 
+// summaryRow is a stored message, as read for a transcript
+type summaryRow struct {
+	ID          string
+	Sender      string
+	Content     string
+	Timestamp   time.Time
+	IsFromMe    bool
+	MediaType   string
+	Filename    string
+	MessageType string
+	Origin      string
+	Transcript  string
+	Deleted     bool
+}
+
+// toSummaryMessage renders a stored message for the transcript; false when the chat's message filter drops it
+func toSummaryMessage(row summaryRow, filter *MessageFilter, logger waLog.Logger) (DailySummaryMessage, bool) {
+	// Format content - if it's media, indicate the media type; deleted messages don't show what they said
+	messageContent := row.Content
+	if row.Deleted {
+		messageContent = "[Mensagem apagada]"
+	} else if row.MediaType != "" && messageContent == "" {
+		switch row.MediaType {
+		case "image":
+			messageContent = "[Imagem enviada]"
+		case "video":
+			messageContent = "[Vídeo enviado]"
+		case "sticker":
+			messageContent = "[Figurinha enviada]"
+		case "audio", "ptt":
+			messageContent = "[Áudio enviado]"
+			if row.Transcript != "" {
+				messageContent = fmt.Sprintf("[Áudio transcrito] %s", row.Transcript)
+			}
+		case "document":
+			if row.Filename != "" {
+				messageContent = fmt.Sprintf("[Documento: %s]", row.Filename)
+			} else {
+				messageContent = "[Documento enviado]"
+			}
+		default:
+			messageContent = fmt.Sprintf("[%s enviado]", row.MediaType)
+		}
+	}
+
+	// Get sender name for display
+	senderName := getSenderName(row.Sender, row.IsFromMe, logger)
+
+	if !filter.Allows(row.ID, row.Sender, senderName, row.Content, row.MessageType, row.Origin) {
+		return DailySummaryMessage{}, false
+	}
+
+	// Mark group events so they aren't read as something the sender wrote
+	if row.MessageType != "" {
+		messageContent = "[system] " + messageContent
+	}
+
+	// Replace @mentions with real names in message content
+	processedContent := replaceMentionsWithNames(messageContent, logger)
+
+	return DailySummaryMessage{
+		Timestamp: row.Timestamp.Format("15:04"),
+		Sender:    senderName,
+		Content:   renderTranscriptContent(processedContent),
+		IsFromMe:  row.IsFromMe,
+		ID:        row.ID,
+		Time:      row.Timestamp,
+		Raw:       processedContent,
+		MediaType: row.MediaType,
+		Filename:  row.Filename,
+	}, true
+}
+
+// formatReplyTarget names the message a reply quotes by sender and time, with the date when it was sent on
+// another day than the reply
+func formatReplyTarget(target ReplyTarget, replyAt time.Time, logger waLog.Logger) string {
+	name := getSenderName(target.Sender, false, logger)
+	switch {
+	case target.Time.IsZero():
+		return name
+	case target.Time.Format("2006-01-02") != replyAt.Format("2006-01-02"):
+		return fmt.Sprintf("%s %s", name, target.Time.Format("2006-01-02 15:04"))
+	default:
+		return fmt.Sprintf("%s %s", name, target.Time.Format("15:04"))
+	}
+}
+
 // getSenderName retrieves the display name for a sender
 func getSenderName(sender string, isFromMe bool, logger waLog.Logger) string {
 	// Handle empty sender (shouldn't happen but just in case)
@@ -140,25 +308,21 @@ func getSenderName(sender string, isFromMe bool, logger waLog.Logger) string {
 	return sender
 }
 
-// getGroupName retrieves the display name for a group JID
+// getGroupName retrieves the display name for a group JID: its subject as stored by the bridge, or a short form of the JID
 func getGroupName(groupJID string, logger waLog.Logger) string {
-	ctx := context.Background()
-
-	// Open the WhatsApp database
-	container, err := sqlstore.New(ctx, "sqlite3", "file:store/whatsapp.db?_foreign_keys=on", logger)
+	stmt, err := prepareMessagesStatement("SELECT COALESCE(name, '') FROM chats WHERE jid = ?")
 	if err != nil {
-		logger.Errorf("Failed to connect to WhatsApp database: %v", err)
+		logger.Warnf("Failed to read group name: %v", err)
 		return extractGroupIDFromJID(groupJID)
 	}
-
-	// Get all devices (should be just one)
-	devices, err := container.GetAllDevices(ctx)
-	if err != nil || len(devices) == 0 {
-		logger.Errorf("Failed to get devices from WhatsApp database: %v", err)
+	var name string
+	if err := stmt.QueryRow(groupJID).Scan(&name); err != nil && err != sql.ErrNoRows {
+		logger.Warnf("Failed to read group name of %s: %v", groupJID, err)
+	}
+	if name == "" || name == groupJID {
 		return extractGroupIDFromJID(groupJID)
 	}
-
-	return extractGroupIDFromJID(groupJID)
+	return name
 }
 
 // extractGroupIDFromJID extracts a readable group ID from the full JID
@@ -171,26 +335,136 @@ func extractGroupIDFromJID(groupJID string) string {
 	return groupJID
 }
 
-// getUserRealName retrieves the real name of a user from the WhatsApp database
-func getUserRealName(userJID string, logger waLog.Logger) string {
-	ctx := context.Background()
+// How long the contact names read from messages.db are reused at most, and how often the contacts_version
+// counter is checked in between, so names are read again soon after the bridge stores a contact change
+const (
+	contactNamesTTL           = 10 * time.Minute
+	contactNamesCheckInterval = 5 * time.Second
+)
 
-	// Open the WhatsApp database
-	container, err := sqlstore.New(ctx, "sqlite3", "file:store/whatsapp.db?_foreign_keys=on", logger)
+// contactNames caches the contacts table, which the bridge keeps in step with the whatsmeow contact store
+var contactNames struct {
+	sync.Mutex
+	names     map[string]string // display name by JID
+	loadedAt  time.Time
+	version   int64 // contacts_version when the names were read, -1 without the counter
+	checkedAt time.Time
+}
+
+// contactsVersion reads the counter bumped on every contact change; ok is false before the migration adding it
+func contactsVersion(logger waLog.Logger) (version int64, ok bool) {
+	stmt, err := prepareMessagesStatement("SELECT version FROM contacts_version WHERE id = 1")
 	if err != nil {
-		logger.Warnf("Failed to connect to WhatsApp database: %v", err)
-		return ""
+		logger.Debugf("Contacts version not available: %v", err)
+		return -1, false
+	}
+	if err := stmt.QueryRow().Scan(&version); err != nil {
+		return -1, false
 	}
+	return version, true
+}
 
-	// Get all devices (should be just one)
-	devices, err := container.GetAllDevices(ctx)
-	if err != nil || len(devices) == 0 {
-		logger.Warnf("Failed to get devices from WhatsApp database: %v", err)
-		return ""
+// loadContactNames returns the named contacts the bridge synced into messages.db; it is empty before the first sync
+func loadContactNames(logger waLog.Logger) map[string]string {
+	contactNames.Lock()
+	defer contactNames.Unlock()
+	if contactNames.names != nil && time.Since(contactNames.loadedAt) < contactNamesTTL {
+		if time.Since(contactNames.checkedAt) < contactNamesCheckInterval {
+			return contactNames.names
+		}
+		contactNames.checkedAt = time.Now()
+		if version, ok := contactsVersion(logger); !ok || version == contactNames.version {
+			return contactNames.names
+		}
+	}
+
+	// The version is read first, so a change made while the names are read shows up at the next check
+	version, _ := contactsVersion(logger)
+	names := make(map[string]string)
+	if db, err := openSharedMessagesDB(); err == nil {
+		rows, err := db.Query("SELECT jid, name FROM contacts WHERE COALESCE(name, '') != ''")
+		if err != nil {
+			logger.Debugf("Contacts table not available: %v", err)
+		} else {
+			for rows.Next() {
+				var jid, name string
+				if rows.Scan(&jid, &name) == nil {
+					names[jid] = name
+				}
+			}
+			rows.Close()
+		}
 	}
+	now := time.Now()
+	contactNames.names, contactNames.loadedAt, contactNames.version, contactNames.checkedAt = names, now, version, now
+	return names
+}
+
+// getUserRealName retrieves the real name of a user from the contacts synced by the bridge
+func getUserRealName(userJID string, logger waLog.Logger) string {
+	if names := loadContactNames(logger); len(names) > 0 {
+		return names[userJID]
+	}
+	// Before the bridge's first contact sync, read the WhatsApp database itself
+	return getUserRealNameFromDevice(userJID, logger)
+}
 
-	// Use the first device to get contact info
-	device := devices[0]
+// whatsAppDevice is the device read from the WhatsApp database, opened once per process for contact lookups
+var whatsAppDevice struct {
+	sync.Once
+	device *store.Device
+}
+
+// getWhatsAppDevice returns the linked device from the WhatsApp database, or nil when it can't be read
+func getWhatsAppDevice(logger waLog.Logger) *store.Device {
+	whatsAppDevice.Do(func() {
+		ctx := context.Background()
+		container, err := sqlstore.New(ctx, "sqlite3", "file:store/whatsapp.db?_foreign_keys=on&_busy_timeout=5000", logger)
+		if err != nil {
+			logger.Warnf("Failed to connect to WhatsApp database: %v", err)
+			return
+		}
+		// Get all devices (should be just one)
+		devices, err := container.GetAllDevices(ctx)
+		if err != nil || len(devices) == 0 {
+			logger.Warnf("Failed to get devices from WhatsApp database: %v", err)
+			return
+		}
+		whatsAppDevice.device = devices[0]
+	})
+	return whatsAppDevice.device
+}
+
+// deviceContactNames caches the names read from the WhatsApp database, including the senders without one
+var deviceContactNames struct {
+	sync.Mutex
+	names    map[string]string
+	loadedAt time.Time
+}
+
+// getUserRealNameFromDevice retrieves the real name of a user from the WhatsApp database, looking each user up
+// once per contactNamesTTL
+func getUserRealNameFromDevice(userJID string, logger waLog.Logger) string {
+	deviceContactNames.Lock()
+	defer deviceContactNames.Unlock()
+	if deviceContactNames.names == nil || time.Since(deviceContactNames.loadedAt) >= contactNamesTTL {
+		deviceContactNames.names, deviceContactNames.loadedAt = make(map[string]string), time.Now()
+	}
+	if name, ok := deviceContactNames.names[userJID]; ok {
+		return name
+	}
+	name := lookUpDeviceContactName(userJID, logger)
+	deviceContactNames.names[userJID] = name
+	return name
+}
+
+// lookUpDeviceContactName reads the real name of a user from the WhatsApp database
+func lookUpDeviceContactName(userJID string, logger waLog.Logger) string {
+	ctx := context.Background()
+	device := getWhatsAppDevice(logger)
+	if device == nil {
+		return ""
+	}
 
 	// Parse the JID
 	parsedJID, err := types.ParseJID(userJID)
@@ -220,15 +494,12 @@ func getUserRealName(userJID string, logger waLog.Logger) string {
 	return ""
 }
 
+// mentionNumberPattern finds @mentions: @ followed by a phone number
+var mentionNumberPattern = regexp.MustCompile(`@(\+?[0-9]{10,15})`)
+
 // replaceMentionsWithNames replaces @phone_number mentions with real contact names
 func replaceMentionsWithNames(content string, logger waLog.Logger) string {
-	// Regular expression to find @mentions (@ followed by phone numbers)
-	mentionPattern := `@(\+?[0-9]{10,15})`
-
-	// Use regex to find and replace all @mentions
-	re := regexp.MustCompile(mentionPattern)
-
-	result := re.ReplaceAllStringFunc(content, func(match string) string {
+	result := mentionNumberPattern.ReplaceAllStringFunc(content, func(match string) string {
 		// Extract the phone number (remove @ and optional +)
 		phoneNumber := strings.TrimPrefix(match, "@")
 		phoneNumber = strings.TrimPrefix(phoneNumber, "+")
@@ -250,35 +521,44 @@ func replaceMentionsWithNames(content string, logger waLog.Logger) string {
 }
 
 // segmentMessagesByTopic groups messages into topic-based segments using Claude AI
-func segmentMessagesByTopic(messages []DailySummaryMessage, groupName, date string, logger waLog.Logger) (map[string][]DailySummaryMessage, error) {
+func segmentMessagesByTopic(ctx context.Context, messages []DailySummaryMessage, groupName, date string, logger waLog.Logger) (map[string][]DailySummaryMessage, error) {
 	if len(messages) == 0 {
 		return make(map[string][]DailySummaryMessage), nil
 	}
 
 	// Load the topic segmentation prompt
-	prompt, err := loadTopicSegmentationPrompt(messages, date)
+	prompt, err := loadTopicSegmentationPrompt(messages, date, llmSessionFromContext(ctx).Continues())
 	if err != nil {
 		return nil, fmt.Errorf("failed to load topic segmentation prompt: %v", err)
 	}
 
-	// Call Claude API for topic segmentation
-	response, err := callClaudeServer(prompt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get topic segmentation from Claude: %v", err)
-	}
+	ctx = withLLMPurpose(ctx, "topic_segmentation")
+	var segments map[string]TopicSegment
+	if structuredOutputEnabled() {
+		// The answer is validated against the schema, with one corrective retry
+		if err := callLLMStructured(ctx, prompt, topicSegmentationSchema(len(messages)), &segments, logger); err != nil {
+			return nil, fmt.Errorf("failed to get topic segmentation from the LLM: %v", err)
+		}
+		logger.Infof("Received topic segmentation response from the LLM")
+	} else {
+		// Call the LLM for topic segmentation
+		response, err := callLLM(ctx, prompt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get topic segmentation from the LLM: %v", err)
+		}
 
-	logger.Infof("Received topic segmentation response from Claude")
+		logger.Infof("Received topic segmentation response from the LLM")
 
-	// Extract JSON from markdown code blocks if present
-	jsonContent := extractJSONFromMarkdown(response)
+		// Extract JSON from markdown code blocks if present
+		jsonContent := extractJSONFromMarkdown(response)
 
-	// Parse the JSON response (expecting map format from prompt)
-	var segments map[string]TopicSegment
-	err = json.Unmarshal([]byte(jsonContent), &segments)
-	if err != nil {
-		logger.Warnf("Failed to parse topic segmentation JSON: %v", err)
-		logger.Warnf("Response content: %s", jsonContent)
-		return nil, fmt.Errorf("failed to parse topic segmentation JSON: %v", err)
+		// Parse the JSON response (expecting map format from prompt), repairing common syntax slips
+		err = decodeLLMJSON(ctx, jsonContent, &segments)
+		if err != nil {
+			logger.Warnf("Failed to parse topic segmentation JSON: %v", err)
+			logger.Warnf("Response content: %s", jsonContent)
+			return nil, fmt.Errorf("failed to parse topic segmentation JSON: %v", err)
+		}
 	}
 
 	// Convert segments to map of topic -> messages
@@ -299,8 +579,9 @@ func segmentMessagesByTopic(messages []DailySummaryMessage, groupName, date stri
 	return topicSegments, nil
 }
 
-// loadTopicSegmentationPrompt loads and formats the topic segmentation prompt
-func loadTopicSegmentationPrompt(messages []DailySummaryMessage, date string) (string, error) {
+// loadTopicSegmentationPrompt loads and formats the topic segmentation prompt;
+// continuesSession tells the template the model already saw the day in an earlier step
+func loadTopicSegmentationPrompt(messages []DailySummaryMessage, date string, continuesSession bool) (string, error) {
 	// Load the prompt template from file
 	promptTemplate, err := os.ReadFile("prompts/topic-segmentation.md")
 	if err != nil {
@@ -313,142 +594,404 @@ func loadTopicSegmentationPrompt(messages []DailySummaryMessage, date string) (s
 		return "", fmt.Errorf("failed to marshal messages to JSON: %v", err)
 	}
 
-	// Replace placeholders in the template
-	prompt := string(promptTemplate)
-	prompt = strings.ReplaceAll(prompt, "{{MESSAGES}}", string(messagesJSON))
-	prompt = strings.ReplaceAll(prompt, "{{DATE}}", date)
-
-	return prompt, nil
+	data := promptMessagesData(messages, date)
+	data["MESSAGES"] = string(messagesJSON)
+	data["ContinuesSession"] = continuesSession
+	return renderPrompt("prompts/topic-segmentation.md", string(promptTemplate), data)
 }
 
 // loadAddEpisodePrompt loads and formats the add episode prompt for Graphiti
-func loadAddEpisodePrompt(episodeName, topicName, groupName, date, episodeBody, sourceDescription string) (string, error) {
+func loadAddEpisodePrompt(episodeUUID, episodeName, topicName, groupName, groupID, date, episodeBody, sourceDescription string, continuesSession bool) (string, error) {
 	// Load the prompt template from file
 	promptTemplate, err := os.ReadFile("prompts/add-episode.md")
 	if err != nil {
 		return "", fmt.Errorf("failed to read add episode prompt template: %v", err)
 	}
 
-	// Replace placeholders in the template
-	prompt := string(promptTemplate)
-	prompt = strings.ReplaceAll(prompt, "{{EPISODE_NAME}}", episodeName)
-	prompt = strings.ReplaceAll(prompt, "{{TOPIC_NAME}}", topicName)
-	prompt = strings.ReplaceAll(prompt, "{{GROUP_NAME}}", groupName)
-	prompt = strings.ReplaceAll(prompt, "{{DATE}}", date)
-	prompt = strings.ReplaceAll(prompt, "{{EPISODE_BODY}}", episodeBody)
-	prompt = strings.ReplaceAll(prompt, "{{SOURCE_DESCRIPTION}}", sourceDescription)
+	data := addEpisodePromptData(episodeUUID, episodeName, topicName, groupName, groupID, date, episodeBody, sourceDescription)
+	data["ContinuesSession"] = continuesSession
+	return renderPrompt("prompts/add-episode.md", string(promptTemplate), data)
+}
 
-	return prompt, nil
+// addEpisodePromptData returns the fields available in the add episode template
+func addEpisodePromptData(episodeUUID, episodeName, topicName, groupName, groupID, date, episodeBody, sourceDescription string) map[string]interface{} {
+	entityTypes := getEntityTypesPromptText()
+	return map[string]interface{}{
+		"EpisodeUUID":        episodeUUID,
+		"EpisodeName":        episodeName,
+		"TopicName":          topicName,
+		"GroupName":          groupName,
+		"GroupID":            groupID,
+		"Date":               date,
+		"EpisodeBody":        episodeBody,
+		"SourceDescription":  sourceDescription,
+		"EntityTypes":        entityTypes,
+		"EPISODE_UUID":       episodeUUID,
+		"EPISODE_NAME":       episodeName,
+		"TOPIC_NAME":         topicName,
+		"GROUP_NAME":         groupName,
+		"GROUP_ID":           groupID,
+		"DATE":               date,
+		"EPISODE_BODY":       episodeBody,
+		"SOURCE_DESCRIPTION": sourceDescription,
+		"ENTITY_TYPES":       entityTypes,
+	}
 }
 
-// addEpisodesToGraphiti adds topic segments as episodes to the Graphiti knowledge graph
-func addEpisodesToGraphiti(topicSegments map[string][]DailySummaryMessage, groupName, date string, logger waLog.Logger) error {
+// addEpisodesToKnowledgeSink adds topic segments as episodes to the configured knowledge sink
+// (Graphiti by default), using the group's own namespace
+func addEpisodesToKnowledgeSink(ctx context.Context, topicSegments map[string][]DailySummaryMessage, groupJID, groupName, date string, logger waLog.Logger) error {
 	if len(topicSegments) == 0 {
-		logger.Infof("No topic segments to add to Graphiti")
+		logger.Infof("No topic segments to add to the knowledge sink")
 		return nil
 	}
 
+	sink, err := newKnowledgeSink(getKnowledgeSinkName())
+	if err != nil {
+		return err
+	}
+
+	namespace := graphitiGroupIDForChat(groupJID)
+	logger.Infof("Adding episodes to %s namespace %s", sink.Name(), namespace)
+
+	// Track created episodes so they can be removed and re-ingested later
+	db, err := openSharedMessagesDB()
+	if err != nil {
+		return err
+	}
+
+	if err := ensureEpisodeTables(db); err != nil {
+		return fmt.Errorf("failed to create episode table: %v", err)
+	}
+
+	annotationsText, err := getAnnotationsPromptText(db, groupJID)
+	if err != nil {
+		logger.Warnf("Failed to load annotations: %v", err)
+	}
+
 	var successCount int
 	for topicName, messages := range topicSegments {
+		// Stop early on shutdown; the episodes added so far are already recorded
+		if ctx.Err() != nil {
+			return fmt.Errorf("cancelled after %d of %d episodes: %v", successCount, len(topicSegments), ctx.Err())
+		}
+
 		// Format messages as episode body
 		var episodeBody strings.Builder
 		for i, message := range messages {
-			episodeBody.WriteString(fmt.Sprintf("%s: %s", message.Sender, message.Content))
+			episodeBody.WriteString(formatTranscriptMessage(message.Sender+": ", message.Content))
 			if i < len(messages)-1 {
 				episodeBody.WriteString("\n")
 			}
 		}
 
-		// Create episode name
-		episodeName := fmt.Sprintf("%s - %s", date, topicName)
-
-		// Load and format the add episode prompt
-		addEpisodePrompt, err := loadAddEpisodePrompt(
-			episodeName,
-			topicName,
-			groupName,
-			date,
-			episodeBody.String(),
-			"WhatsApp group conversation daily summary",
-		)
-		if err != nil {
-			logger.Errorf("Failed to load add episode prompt for topic '%s': %v", topicName, err)
-			continue
+		// Pre-assign the episode UUID so it can be tracked locally
+		episode := KnowledgeEpisode{
+			UUID:              uuid.New().String(),
+			Name:              fmt.Sprintf("%s - %s", date, topicName),
+			Topic:             topicName,
+			GroupJID:          groupJID,
+			GroupName:         groupName,
+			Namespace:         namespace,
+			Date:              date,
+			Body:              episodeBody.String(),
+			SourceDescription: "WhatsApp group conversation daily summary",
+			Annotations:       annotationsText,
+			Messages:          messages,
 		}
 
-		// Call Claude with Graphiti tools to add the episode
-		_, err = callClaudeServer(addEpisodePrompt, "mcp__graphiti")
-		if err != nil {
-			logger.Errorf("Failed to add episode to Graphiti for topic '%s': %v", topicName, err)
+		if err := sink.AddEpisode(ctx, episode); err != nil {
+			logger.Errorf("Failed to add episode to %s for topic '%s': %v", sink.Name(), topicName, err)
 			continue
 		}
 
-		logger.Infof("Successfully added episode to Graphiti for topic: %s", topicName)
+		logger.Infof("Successfully added episode to %s for topic: %s", sink.Name(), topicName)
+
+		record := newEpisodeRecord(episode.UUID, namespace, groupJID, date, topicName, episode.Name, messages)
+		record.Sink = sink.Name()
+		record.ImportRun = importRunFromContext(ctx)
+		if err := recordEpisode(db, record); err != nil {
+			logger.Warnf("Failed to record episode %s locally: %v", episode.UUID, err)
+		}
 		successCount++
 	}
 
 	if successCount == 0 {
-		return fmt.Errorf("failed to add any episodes to Graphiti")
+		return fmt.Errorf("failed to add any episodes to %s", sink.Name())
 	}
 
 	return nil
 }
 
-// sendToRecipient sends a message to a specific recipient using the WhatsApp client
-func sendToRecipient(message, recipient string, logger waLog.Logger) error {
+// newEpisodeRecord builds the local record of an episode, including the range of messages it was built from
+func newEpisodeRecord(episodeUUID, graphitiGroupID, groupJID, date, topicName, episodeName string, messages []DailySummaryMessage) EpisodeRecord {
+	record := EpisodeRecord{
+		UUID:            episodeUUID,
+		GraphitiGroupID: graphitiGroupID,
+		GroupJID:        groupJID,
+		Date:            date,
+		Topic:           topicName,
+		Name:            episodeName,
+	}
+
+	for _, message := range messages {
+		if message.ID == "" {
+			continue
+		}
+		record.MessageIDs = append(record.MessageIDs, message.ID)
+		record.MessageIDs = append(record.MessageIDs, message.DuplicateIDs...)
+
+		// Topics aren't necessarily contiguous, so find the earliest and latest message
+		if record.FirstMessageID == "" || message.Time.Before(record.FirstMessageAt) {
+			record.FirstMessageID, record.FirstMessageAt = message.ID, message.Time
+		}
+		if record.LastMessageID == "" || message.Time.After(record.LastMessageAt) {
+			record.LastMessageID, record.LastMessageAt = message.ID, message.Time
+		}
+	}
+
+	return record
+}
+
+// Ways the tools send messages (BRIDGE_SEND_MODE)
+const (
+	bridgeSendModeAuto   = "auto"   // through the running bridge, connecting directly only when it isn't reachable
+	bridgeSendModeBridge = "bridge" // only through the running bridge
+	bridgeSendModeDirect = "direct" // always with a connection of their own
+)
+
+// getBridgeSendMode returns how the tools send messages (BRIDGE_SEND_MODE, default auto)
+func getBridgeSendMode() string {
+	switch mode := os.Getenv("BRIDGE_SEND_MODE"); mode {
+	case bridgeSendModeBridge, bridgeSendModeDirect:
+		return mode
+	default:
+		return bridgeSendModeAuto
+	}
+}
+
+// sendThroughBridge sends through the running bridge's session, so the tools don't open a second
+// WhatsApp connection next to it. It reports false when the message should be sent directly instead.
+func sendThroughBridge(request BridgeSendRequest, logger waLog.Logger) (bool, error) {
+	mode := getBridgeSendMode()
+	if mode == bridgeSendModeDirect {
+		return false, nil
+	}
+
+	request.Origin = messageOriginSummary
+	if request.PromptHash == "" {
+		request.PromptHash = lastPromptHash()
+	}
+	err := postBridgeSend(request)
+	if err == nil {
+		logger.Infof("Successfully sent message to %s through the bridge", request.Recipient)
+		return true, nil
+	}
+	if mode == bridgeSendModeAuto && errors.Is(err, errBridgeUnreachable) {
+		logger.Warnf("Bridge not reachable, connecting to WhatsApp directly: %v", err)
+		return false, nil
+	}
+	return true, fmt.Errorf("failed to send through the bridge: %v", err)
+}
+
+// auditDirectSend records a summary that a scheduled tool sent over its own WhatsApp connection, bypassing the bridge
+func auditDirectSend(recipient, text, messageID string, sendErr error, logger waLog.Logger) {
+	db, err := openSharedMessagesDB()
+	if err == nil {
+		err = recordSendResult(db, SendAuditRecord{
+			TriggeredBy: bridgeClientName(),
+			Origin:      messageOriginSummary,
+			Recipient:   recipient,
+			PromptHash:  lastPromptHash(),
+			ContentHash: hashSendText(text),
+		}, messageID, sendErr)
+	}
+	if err != nil {
+		logger.Warnf("Failed to record send in the audit log: %v", err)
+	}
+}
+
+// checkDirectSendPolicy applies the send policy to a summary sent over the tool's own connection (through the
+// bridge, the bridge applies it), logging and auditing a rejection
+func checkDirectSendPolicy(client *whatsmeow.Client, targetJID types.JID, text string, logger waLog.Logger) error {
+	err := checkSendPolicy(targetJID.String(), ownChatJID(client))
+	if err == nil {
+		return nil
+	}
+	logger.Warnf("Rejected summary to %s: %v", targetJID, err)
+	if db, dbErr := openSharedMessagesDB(); dbErr == nil {
+		dbErr = recordSendAudit(db, SendAuditRecord{
+			TriggeredBy: bridgeClientName(),
+			Origin:      messageOriginSummary,
+			Recipient:   targetJID.String(),
+			PromptHash:  lastPromptHash(),
+			ContentHash: hashSendText(text),
+			Result:      sendAuditRejected,
+			Error:       err.Error(),
+		})
+		if dbErr != nil {
+			logger.Warnf("Failed to record send in the audit log: %v", dbErr)
+		}
+	}
+	return err
+}
+
+// waitForDirectSend holds a send made without the bridge to the bridge's limits: it fails when the chat reached its
+// daily cap, and otherwise waits for the pacing (see send-limits.go). It returns the shared messages.db handle.
+func waitForDirectSend(client *whatsmeow.Client, targetJID types.JID) (*sql.DB, error) {
+	db, err := openSharedMessagesDB()
+	if err != nil {
+		return nil, err
+	}
+	// Today's messages are counted in the database the chat is stored in
+	chatDB, err := openMessagesDBFor(targetJID.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDailySendCap(client, chatDB, targetJID); err != nil {
+		return nil, err
+	}
+	outgoingPacer.wait()
+	return db, nil
+}
+
+// connectSendClient connects a WhatsApp client for sending and resolves the recipient ("self" or a JID)
+func connectSendClient(recipient string) (*whatsmeow.Client, types.JID, error) {
 	ctx := context.Background()
 
 	// Try to initialize WhatsApp client for sending
-	container, err := sqlstore.New(ctx, "sqlite3", "file:store/whatsapp.db?_foreign_keys=on", waLog.Stdout("Database", "ERROR", true))
+	container, err := sqlstore.New(ctx, "sqlite3", "file:store/whatsapp.db?_foreign_keys=on", newLogger("Database", "ERROR"))
 	if err != nil {
-		return fmt.Errorf("failed to connect to database: %v", err)
+		return nil, types.JID{}, fmt.Errorf("failed to connect to database: %v", err)
 	}
 
 	deviceStore, err := container.GetFirstDevice(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get device: %v", err)
+		return nil, types.JID{}, fmt.Errorf("failed to get device: %v", err)
 	}
 
-	client := whatsmeow.NewClient(deviceStore, waLog.Stdout("Client", "INFO", true))
-	defer client.Disconnect()
+	client := whatsmeow.NewClient(deviceStore, newLogger("Client", "INFO"))
 
 	// Connect to WhatsApp
 	if err := client.Connect(); err != nil {
-		return fmt.Errorf("failed to connect: %v", err)
+		return nil, types.JID{}, fmt.Errorf("failed to connect: %v", err)
 	}
 
 	// Handle different recipient types
 	var targetJID types.JID
-	var err2 error
-
 	if recipient == "self" {
 		// Send to self (status broadcast)
 		targetJID = types.NewJID(client.Store.ID.User, types.DefaultUserServer)
 	} else {
 		// Parse as regular JID
-		targetJID, err2 = types.ParseJID(recipient)
-		if err2 != nil {
-			return fmt.Errorf("failed to parse recipient JID: %v", err2)
+		targetJID, err = types.ParseJID(recipient)
+		if err != nil {
+			client.Disconnect()
+			return nil, types.JID{}, fmt.Errorf("failed to parse recipient JID: %v", err)
 		}
 	}
+	return client, targetJID, nil
+}
+
+// sendToRecipient sends a message to a specific recipient, through the bridge when it is running
+func sendToRecipient(message, recipient string, logger waLog.Logger) error {
+	if readOnlyModeEnabled() {
+		logger.Infof("Read-only mode is on, not sending to %s", recipient)
+		return nil
+	}
+	if sent, err := sendThroughBridge(BridgeSendRequest{Recipient: recipient, Message: message}, logger); sent {
+		return err
+	}
 
-	// Create and send message
-	msg := &waProto.Message{
-		Conversation: proto.String(message),
+	client, targetJID, err := connectSendClient(recipient)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+	if err := checkDirectSendPolicy(client, targetJID, message, logger); err != nil {
+		return err
 	}
+	db, err := waitForDirectSend(client, targetJID)
+	if err != nil {
+		return err
+	}
+
+	// Create and send message, with its @numbers as mentions
+	text, mentioned := addMentions(applyBridgeMessagePrefix(message), nil)
+	msg := buildTextMessage(text, withMentionedJIDs(nil, mentioned))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	_, err = client.SendMessage(ctx, targetJID, msg)
+	resp, err := client.SendMessage(ctx, targetJID, msg)
+	auditDirectSend(targetJID.String(), message, resp.ID, err, logger)
 	if err != nil {
 		return fmt.Errorf("failed to send message: %v", err)
 	}
 
+	// Remember the message so it isn't summarized again if it was sent into a summarized group
+	if err := recordAutomatedMessage(db, resp.ID, targetJID.String(), messageOriginSummary); err != nil {
+		logger.Warnf("Failed to record automated message: %v", err)
+	}
+
 	logger.Infof("Successfully sent message to %s", recipient)
 	return nil
 }
 
+// sendFileToRecipient sends a file with a caption, like sendToRecipient: images, videos and audio as media,
+// anything else as a document
+func sendFileToRecipient(path, caption, recipient string, logger waLog.Logger) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("failed to read file: %v", err)
+	}
+	if readOnlyModeEnabled() {
+		logger.Infof("Read-only mode is on, not sending %s to %s", filepath.Base(path), recipient)
+		return nil
+	}
+
+	// The bridge runs in the same directory, but give it an absolute path anyway
+	if absolute, err := filepath.Abs(path); err == nil {
+		if sent, err := sendThroughBridge(BridgeSendRequest{Recipient: recipient, Message: caption, MediaPath: absolute}, logger); sent {
+			return err
+		}
+	}
+
+	client, targetJID, err := connectSendClient(recipient)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+	if err := checkDirectSendPolicy(client, targetJID, caption, logger); err != nil {
+		return err
+	}
+	db, err := waitForDirectSend(client, targetJID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	msg, err := buildMediaMessage(ctx, client, path, applyBridgeMessagePrefix(caption), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.SendMessage(ctx, targetJID, msg)
+	auditDirectSend(targetJID.String(), caption, resp.ID, err, logger)
+	if err != nil {
+		return fmt.Errorf("failed to send file: %v", err)
+	}
+
+	// Remember the message so it isn't summarized again if it was sent into a summarized group
+	if err := recordAutomatedMessage(db, resp.ID, targetJID.String(), messageOriginSummary); err != nil {
+		logger.Warnf("Failed to record automated message: %v", err)
+	}
+
+	logger.Infof("Successfully sent %s to %s", filepath.Base(path), recipient)
+	return nil
+}
+
 // extractJSONFromMarkdown extracts JSON content from markdown code blocks
 func extractJSONFromMarkdown(response string) string {
 	// Look for ```json...``` blocks
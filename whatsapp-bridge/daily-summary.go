@@ -1,18 +1,31 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"os"
+	"os/signal"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	waLog "go.mau.fi/whatsmeow/util/log"
 )
 
 func main() {
-	logger := waLog.Stdout("DailySummary", "INFO", true)
+	logger := newLogger("DailySummary", "INFO")
 	logger.Infof("Starting daily summary generation...")
 
+	if exitIfSafeMode(logger) {
+		return
+	}
+
+	// Cancel in-flight LLM and knowledge sink requests when the container stops
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Check if daily summary is enabled
 	enabled := os.Getenv("DAILY_SUMMARY_ENABLED")
 	if enabled != "true" {
@@ -20,8 +33,18 @@ func main() {
 		return
 	}
 
-	// Get configuration from environment
-	groupJID := os.Getenv("DAILY_SUMMARY_GROUP_JID")
+	// Catch template mistakes before spending any LLM calls
+	if err := validatePromptTemplates(); err != nil {
+		logger.Errorf("Invalid prompt template: %v", err)
+		os.Exit(1)
+	}
+
+	// Get configuration from environment; the group may be given by name
+	groupJID, err := resolveGroupReference(os.Getenv("DAILY_SUMMARY_GROUP_JID"))
+	if err != nil {
+		logger.Errorf("Failed to resolve DAILY_SUMMARY_GROUP_JID: %v", err)
+		os.Exit(1)
+	}
 	sendTo := os.Getenv("DAILY_SUMMARY_SEND_TO")
 	timezone := os.Getenv("DAILY_SUMMARY_TIMEZONE")
 
@@ -37,10 +60,125 @@ func main() {
 	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
 	endOfDay := time.Date(now.Year(), now.Month(), now.Day(), 23, 59, 59, 999999999, loc)
 
+	// Track stage results so repeated failures trigger the diagnostics runbook
+	recorder, err := NewPipelineRunRecorder(startOfDay.Format("2006-01-02"), logger)
+	if err != nil {
+		logger.Warnf("Failed to initialize pipeline run recorder: %v", err)
+	} else {
+		defer recorder.Close()
+	}
+	recordStage := func(stage string, stageErr error) {
+		if recorder != nil {
+			recorder.Record(stage, stageErr)
+		}
+		if stageErr != nil {
+			reportError(errorEventSummaryFailed, fmt.Sprintf("Daily summary stage %s failed", stage),
+				map[string]string{"stage": stage, "date": startOfDay.Format("2006-01-02"), "error": stageErr.Error()}, logger)
+		}
+	}
+
+	// Groups on the rules summarizer get a template-only digest, without LLM calls
+	config, err := loadBridgeConfig()
+	if err != nil {
+		logger.Warnf("Failed to load config: %v", err)
+		config = &BridgeConfig{}
+	}
+
+	// Chat settings turn the summary on or off per chat, besides DAILY_SUMMARY_GROUP_JID
+	groups, err := listSummaryGroups(groupJID)
+	if err != nil {
+		logger.Warnf("Failed to read chat settings: %v", err)
+	}
+	for _, rulesGroupJID := range listRulesSummaryGroups(config) {
+		if slices.Contains(groups, rulesGroupJID) || !chatSummaryEnabled(rulesGroupJID, true, logger) {
+			continue
+		}
+		if err := sendRulesSummary(rulesGroupJID, sendTo, startOfDay, endOfDay, logger); err != nil {
+			logger.Warnf("Failed to send rules summary of %s: %v", rulesGroupJID, err)
+		}
+	}
+	if len(groups) == 0 {
+		logger.Infof("No group to summarize: set DAILY_SUMMARY_GROUP_JID or turn the summary on in a chat's settings")
+		return
+	}
+
+	// My pending follow-ups are nobody else's business either: they go with the first summary sent to my
+	// self-chat, or on their own when none is
+	followUps := ""
+	if followUpsInSummary() {
+		followUps = buildFollowUpsSection(ctx, logger)
+	}
+
+	for _, groupJID := range groups {
+		if summarizeGroup(ctx, config, groupJID, sendTo, startOfDay, endOfDay, followUps, recordStage, logger) {
+			followUps = ""
+		}
+	}
+
+	if followUps != "" {
+		if err := sendToSelfChat(followUps, logger); err != nil {
+			logger.Warnf("Failed to send pending follow-ups: %v", err)
+		}
+	}
+}
+
+// listSummaryGroups returns the chats the daily summary covers: the DAILY_SUMMARY_GROUP_JID group unless its chat
+// settings turn the summary off, and the chats whose settings turn it on
+func listSummaryGroups(groupJID string) ([]string, error) {
+	var groups []string
+	if groupJID != "" {
+		groups = append(groups, groupJID)
+	}
+	db, err := openSharedMessagesDB()
+	if err != nil {
+		return groups, err
+	}
+	list, err := listChatSettings(db)
+	if err != nil {
+		return groups, err
+	}
+	for _, settings := range list {
+		if settings.ChatJID == groupJID {
+			if !settings.summaryEnabled(true) {
+				groups = slices.DeleteFunc(groups, func(jid string) bool { return jid == groupJID })
+			}
+		} else if settings.summaryEnabled(false) {
+			groups = append(groups, settings.ChatJID)
+		}
+	}
+	return groups, nil
+}
+
+// chatSummaryEnabled reports whether a chat's settings leave it in the daily summary; fallback applies when
+// they don't say
+func chatSummaryEnabled(chatJID string, fallback bool, logger waLog.Logger) bool {
+	settings, err := loadChatSettings(chatJID)
+	if err != nil {
+		logger.Warnf("Failed to read the chat settings of %s: %v", chatJID, err)
+	}
+	return settings.summaryEnabled(fallback)
+}
+
+// summarizeGroup generates, saves and sends a group's summary of the day, then adds its episodes to the
+// knowledge sink. A summary sent to "self" ends with the followUps section; it reports whether it was sent.
+func summarizeGroup(ctx context.Context, config *BridgeConfig, groupJID, sendTo string, startOfDay, endOfDay time.Time,
+	followUps string, recordStage func(string, error), logger waLog.Logger) (followUpsSent bool) {
 	logger.Infof("Generating summary for group %s from %s to %s", groupJID, startOfDay.Format("2006-01-02 15:04:05"), endOfDay.Format("2006-01-02 15:04:05"))
 
+	if getGroupSummarizer(config, groupJID) == summarizerRules {
+		err := sendRulesSummary(groupJID, sendTo, startOfDay, endOfDay, logger)
+		recordStage("send_summary", err)
+		if err != nil {
+			logger.Errorf("Failed to send rules summary: %v", err)
+			return
+		}
+		logger.Infof("Daily summary of %s completed successfully (rules summarizer)", groupJID)
+		return
+	}
+
 	// Get messages from the database
 	messages, err := getMessagesFromGroup(groupJID, startOfDay, endOfDay, logger)
+	recordStage("fetch_messages", err)
 	if err != nil {
 		logger.Errorf("Failed to get messages: %v", err)
 		return
@@ -52,106 +190,194 @@ func main() {
 	}
 
 	logger.Infof("Found %d messages for today", len(messages))
+	messages = collapseDuplicatesForPrompt(messages, groupJID, logger)
+
+	// Spend less LLM budget on groups that matter less
+	depth := getSummaryDepth(groupJID, logger)
+	logger.Infof("Summary depth: %s (importance %.2f from %s)", depth.Level, depth.Importance, depth.Source)
 
 	// Load prompt template
-	prompt, err := loadPromptTemplate(messages, startOfDay.Format("2006-01-02"))
+	prompt, err := loadPromptTemplate(messages, groupJID, startOfDay.Format("2006-01-02"), logger)
 	if err != nil {
 		logger.Errorf("Failed to load prompt template: %v", err)
 		return
 	}
+	prompt = applySummaryDepthToPrompt(prompt, depth)
+
+	// Groups with ongoing threads get yesterday's state of play instead of re-describing the context
+	date := startOfDay.Format("2006-01-02")
+	ctx = withLLMAuditScope(ctx, groupJID, date)
+	// Summary, segmentation and episode adds share one session when LLM_SESSIONS=true
+	ctx = withLLMSession(ctx)
+	// With PII_REDACTION=true the prompts leave without personal data, which the answers get back
+	ctx, err = withPIIRedaction(ctx, config.Redaction.Names, config.Redaction.Patterns)
+	if err != nil {
+		recordStage("summary_llm", err)
+		logger.Errorf("Failed to set up PII redaction: %v", err)
+		return
+	}
+	useStateOfPlay := stateOfPlayEnabled(groupJID)
+	if useStateOfPlay {
+		previous, err := loadPreviousStateOfPlay(groupJID, date)
+		if err != nil {
+			logger.Warnf("Failed to load previous state of play: %v", err)
+		} else if previous != nil {
+			logger.Infof("Using state of play from %s (%d threads, %d pending actions)", previous.Date, len(previous.StateOfPlay.Threads), len(previous.StateOfPlay.PendingActions))
+		}
+		prompt = applyStateOfPlayToPrompt(prompt, previous)
+	}
 
-	// Call Claude API
-	response, err := callClaudeServer(prompt)
+	// Call the configured LLM
+	response, err := callLLM(withLLMPurpose(ctx, "daily_summary"), prompt)
+	recordStage("summary_llm", err)
 	if err != nil {
-		logger.Errorf("Failed to call Claude server: %v", err)
+		logger.Errorf("Failed to call LLM: %v", err)
 		return
 	}
 
 	logger.Infof("Generated summary (%d characters)", len(response))
 
+	var state *StateOfPlay
+	if useStateOfPlay {
+		response, state, err = extractStateOfPlay(withLLMPurpose(ctx, "daily_summary"), response)
+		if err != nil {
+			logger.Warnf("Keeping the previous state of play: %v", err)
+		}
+	}
+	// Groups with max_summary_chars get consistent-sized digests
+	response = tightenSummary(ctx, response, depth.MaxChars, logger)
+	if err := saveSummary(groupJID, date, response, state); err != nil {
+		logger.Warnf("Failed to save summary: %v", err)
+	}
+
+	// Chats with a summary translation rule get their translated messages listed under the summary
+	response = appendTranslations(response, config, groupJID, startOfDay, endOfDay, logger)
+	// With CALENDAR_EVENTS=true, meetings agreed on today go to the calendar and are listed under the summary
+	response = appendCalendarEvents(ctx, response, groupJID, messages, startOfDay, logger)
+
+	// The self-chat copy also lists the day's missed calls, which are nobody else's business
+	if sendTo == "self" && missedCallsInSummary() {
+		response = appendMissedCalls(response, startOfDay, endOfDay, logger)
+	}
+	withFollowUps := sendTo == "self" && followUps != ""
+	if withFollowUps {
+		response += "\n\n" + followUps
+	}
+
 	// Send the summary
-	err = sendSummary(response, sendTo, groupJID, logger)
+	translator := newRecipientTranslator(ctx, response, getGroupLanguage(groupJID), logger)
+	err = sendSummary(response, sendTo, groupJID, translator, logger)
+	recordStage("send_summary", err)
 	if err != nil {
 		logger.Errorf("Failed to send summary: %v", err)
 		return
 	}
+	followUpsSent = withFollowUps
+
+	// Low-importance groups only get the summary
+	if !depth.Segment {
+		logger.Infof("Skipping topic segmentation and knowledge episodes for %s importance group", depth.Level)
+		logger.Infof("Daily summary of %s completed successfully", groupJID)
+		return
+	}
 
-	// Add episodes to Graphiti knowledge graph
-	logger.Infof("Starting Graphiti episode addition...")
+	// Add episodes to the knowledge graph (or the configured knowledge sink)
+	logger.Infof("Starting knowledge episode addition...")
 
 	// Get group name for better organization
 	groupName := getGroupName(groupJID, logger)
 
 	// Segment messages by topic
-	topicSegments, err := segmentMessagesByTopic(messages, groupName, startOfDay.Format("2006-01-02"), logger)
+	topicSegments, err := segmentMessagesByTopic(ctx, messages, groupName, startOfDay.Format("2006-01-02"), logger)
+	recordStage("topic_segmentation", err)
 	if err != nil {
 		logger.Warnf("Failed to segment messages by topic: %v", err)
 	} else {
-		// Add episodes to Graphiti
-		err = addEpisodesToGraphiti(topicSegments, groupName, startOfDay.Format("2006-01-02"), logger)
+		// Add episodes to the knowledge sink
+		err = addEpisodesToKnowledgeSink(ctx, topicSegments, groupJID, groupName, startOfDay.Format("2006-01-02"), logger)
+		recordStage("graphiti_episodes", err)
 		if err != nil {
-			logger.Warnf("Failed to add episodes to Graphiti: %v", err)
+			logger.Warnf("Failed to add episodes to the knowledge sink: %v", err)
 		} else {
-			logger.Infof("Successfully added conversation episodes to Graphiti knowledge graph")
+			logger.Infof("Successfully added conversation episodes to the knowledge sink")
 		}
 	}
 
-	logger.Infof("Daily summary completed successfully")
+	logger.Infof("Daily summary of %s completed successfully", groupJID)
+	return
 }
 
-// loadPromptTemplate loads the prompt template and replaces placeholders
-func loadPromptTemplate(messages []DailySummaryMessage, date string) (string, error) {
-	// Try to load custom prompt template
-	promptPath := "prompts/daily-summary.md"
-	promptBytes, err := os.ReadFile(promptPath)
+// appendMissedCalls adds a line with the day's missed calls to a summary; it is left as is when there were none
+func appendMissedCalls(summary string, startOfDay, endOfDay time.Time, logger waLog.Logger) string {
+	db, err := openSharedMessagesDB()
+	if err != nil {
+		logger.Warnf("Missed calls not available: %v", err)
+		return summary
+	}
 
-	var promptTemplate string
+	missed, err := getMissedCalls(db, startOfDay, endOfDay)
 	if err != nil {
-		// Use default prompt if file doesn't exist
-		promptTemplate = `You are an executive assistant analyzing conversations in the group for the day. 
-Please provide:
+		// The calls table is created by the bridge
+		logger.Debugf("Missed calls not available: %v", err)
+		return summary
+	}
+	line := formatMissedCallsLine(missed, func(jid string) string { return getUserRealName(jid, logger) })
+	if line == "" {
+		return summary
+	}
+	return summary + "\n\n" + line
+}
 
-1. **Executive Summary**: Main discussions and decisions
-2. **Pending Actions**: Tasks identified and responsible  
-3. **Metrics**: Companies mentioned, valuations discussed
-4. **Follow-ups Needed**: Suggested next steps
+// sendSummary sends the generated summary to the specified recipient.
+// With a translator, each recipient gets it in their configured language.
+func sendSummary(summary, sendTo, groupJID string, translator *RecipientTranslator, logger waLog.Logger) error {
+	textFor := func(recipient string) string {
+		if translator == nil {
+			return summary
+		}
+		return translator.For(recipient)
+	}
 
-Be direct and concise. Use data and numbers whenever mentioned.
+	// If sendTo is "self", send to self-chat
+	if sendTo == "self" {
+		return sendToSelfChat(textFor("self"), logger)
+	}
 
-Messages of the day ({{DATE}}):
-{{MESSAGES}}`
-	} else {
-		promptTemplate = string(promptBytes)
+	// A single JID is sent to directly
+	if !strings.Contains(sendTo, ",") && !strings.HasPrefix(sendTo, segmentTargetPrefix) && !isBroadcastTarget(sendTo) {
+		return sendToRecipient(textFor(sendTo), sendTo, logger)
 	}
 
-	// Format messages as text
-	var messageLines []string
-	for _, msg := range messages {
-		direction := "←"
-		if msg.IsFromMe {
-			direction = "→"
-		}
-		messageLines = append(messageLines, fmt.Sprintf("[%s] %s %s: %s",
-			msg.Timestamp, direction, msg.Sender, msg.Content))
+	// Otherwise expand the comma-separated list, including "segment:<name>" and "broadcast:<list>" entries
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %v", err)
 	}
-	messagesText := strings.Join(messageLines, "\n")
+	defer db.Close()
 
-	// Replace placeholders
-	prompt := strings.ReplaceAll(promptTemplate, "{{MESSAGES}}", messagesText)
-	prompt = strings.ReplaceAll(prompt, "{{DATE}}", date)
+	if err := ensureBroadcastTables(db); err != nil {
+		return fmt.Errorf("failed to create segment tables: %v", err)
+	}
 
-	return prompt, nil
-}
+	recipients, err := expandRecipients(db, sendTo)
+	if err != nil {
+		return fmt.Errorf("failed to resolve recipients: %v", err)
+	}
 
-// sendSummary sends the generated summary to the specified recipient
-func sendSummary(summary, sendTo, groupJID string, logger waLog.Logger) error {
-	// If sendTo is "self", send to self-chat
-	if sendTo == "self" {
-		return sendToSelfChat(summary, logger)
+	var failed []string
+	for _, recipient := range recipients {
+		if err := sendToRecipient(textFor(recipient), recipient, logger); err != nil {
+			logger.Warnf("Failed to send summary to %s: %v", recipient, err)
+			failed = append(failed, recipient)
+		}
 	}
 
-	// Otherwise, send to specified JID
-	return sendToRecipient(summary, sendTo, logger)
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to send summary to %d of %d recipients: %s", len(failed), len(recipients), strings.Join(failed, ", "))
+	}
+
+	logger.Infof("Summary sent to %d recipients", len(recipients))
+	return nil
 }
 
 // sendToSelfChat sends the summary to the user's self-chat
@@ -159,4 +385,4 @@ func sendToSelfChat(summary string, logger waLog.Logger) error {
 	// We need to get the WhatsApp client to send to self
 	// For now, let's use the REST API approach
 	return sendToRecipient(summary, "self", logger)
-}
\ No newline at end of file
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Days of dates listed by "/dates" unless given
+const datesCommandDays = 30
+
+// runDatesCommand handles "/dates [days]", "/dates add <contact> <date> [label] [note]" and "/dates remove <id>"
+// on the contacts' yearly dates (see contact-dates.go)
+func runDatesCommand(command *ChatCommandRequest) (string, error) {
+	fields := strings.Fields(command.Args)
+	if len(fields) > 0 {
+		switch strings.ToLower(fields[0]) {
+		case "add":
+			return addDateCommand(command, fields[1:])
+		case "remove", "delete":
+			if len(fields) != 2 {
+				return "Usage: /dates remove <id>", nil
+			}
+			id, err := strconv.ParseInt(strings.TrimPrefix(fields[1], "#"), 10, 64)
+			if err != nil {
+				return "Usage: /dates remove <id>", nil
+			}
+			deleted, err := deleteContactDate(command.messageStore.db, id)
+			if err != nil {
+				return "", err
+			}
+			if !deleted {
+				return fmt.Sprintf("❓ No date #%d", id), nil
+			}
+			return fmt.Sprintf("✅ Date #%d removed", id), nil
+		case "list":
+			fields = fields[1:]
+		}
+	}
+
+	days := datesCommandDays
+	if len(fields) > 0 {
+		parsed, err := strconv.Atoi(fields[0])
+		if err != nil || parsed < 0 || parsed > 366 {
+			return "Usage: /dates [days, up to 366], /dates add <contact> <date> [label] [note] or /dates remove <id>", nil
+		}
+		days = parsed
+	}
+	db := command.messageStore.db
+	upcoming, err := upcomingContactDates(db, time.Now().In(getReminderLocation()), days)
+	if err != nil {
+		return "", err
+	}
+	if len(upcoming) == 0 {
+		return fmt.Sprintf("📅 No dates in the next %d days", days), nil
+	}
+	lines := []string{fmt.Sprintf("📅 *Next %d days*", days)}
+	for _, date := range upcoming {
+		line := fmt.Sprintf("#%d %s: %s's %s", date.ID, date.On.Format("Mon Jan 2"), date.displayName(db), date.Label)
+		if years := date.On.Year() - date.Year; date.Year > 0 && years > 0 {
+			line += fmt.Sprintf(" (%d)", years)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// addDateCommand handles "/dates add <contact> <date> [label] [note]"; the contact is whatever comes before the date
+func addDateCommand(command *ChatCommandRequest, fields []string) (string, error) {
+	usage := "Usage: /dates add <contact> <date> [label] [note], e.g. /dates add Ana Souza 1990-03-14 or /dates add +5511912345678 06-20 anniversary"
+	for i := 1; i < len(fields); i++ {
+		month, day, year, err := parseContactDate(fields[i])
+		if err != nil {
+			continue
+		}
+		db := command.messageStore.db
+		jid, err := resolveContactReference(db, strings.Join(fields[:i], " "))
+		if err != nil {
+			return "❓ " + err.Error(), nil
+		}
+		date := ContactDate{ContactJID: jid, Month: month, Day: day, Year: year}
+		if i+1 < len(fields) {
+			date.Label = fields[i+1]
+			date.Note = strings.Join(fields[i+2:], " ")
+		}
+		id, err := saveContactDate(db, date)
+		if err != nil {
+			return "", err
+		}
+		if date.Label == "" {
+			date.Label = defaultContactDateLabel
+		}
+		return fmt.Sprintf("📅 Saved #%d: %s's %s on %s", id, date.displayName(db), strings.ToLower(date.Label), date.format()), nil
+	}
+	return usage, nil
+}
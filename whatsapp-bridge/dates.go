@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"crypto/cipher"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Recent messages of a contact's chat given to the LLM as an example of how we write to each other
+const dateGreetingMessages = 15
+
+const dateGreetingPrompt = `Draft a short WhatsApp message I can send to %s for their %s%s. Write it as me, warm and personal but not over the top, in one to three sentences, with at most one emoji.%s
+
+Write it in %s. Return only the message.%s`
+
+func main() {
+	if len(os.Args) < 2 {
+		printDatesUsage()
+		os.Exit(1)
+	}
+
+	// Open SQLite database for messages
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open message database: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	command, args := os.Args[1], os.Args[2:]
+	switch command {
+	case "list":
+		err = runDatesList(db)
+	case "add":
+		err = runDatesAdd(db, args)
+	case "delete":
+		err = runDatesDelete(db, args)
+	case "import":
+		err = runDatesImport(db, args)
+	case "remind":
+		err = runDatesRemind(db, args)
+	case "help", "--help", "-h":
+		printDatesUsage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
+		printDatesUsage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printDatesUsage() {
+	fmt.Println(`Birthdays, anniversaries and other yearly dates of contacts
+
+USAGE:
+    dates list
+    dates add --contact CONTACT --date DATE [--label LABEL] [--name NAME] [--note TEXT]
+    dates delete --id ID
+    dates import --csv FILE
+    dates remind [--days-ahead N] [--dry-run]
+
+CONTACT is a phone number, JID or saved contact name. DATE is YYYY-MM-DD, MM-DD, DD/MM/YYYY or DD/MM;
+with the year, reminders say how old someone turns or how many years an anniversary marks. LABEL
+defaults to "birthday"; a contact has one date per label.
+
+The CSV has a header row with a phone, jid or contact column, and a date column (with an optional label
+column) or birthday and anniversary columns; name and note columns are optional.
+
+remind, run daily by the scheduler, sends each date due today (or within --days-ahead days) to your
+self-chat with a greeting drafted by the LLM, as a separate message to forward.`)
+}
+
+func runDatesList(db *sql.DB) error {
+	dates, err := listContactDates(db)
+	if err != nil {
+		return err
+	}
+	if len(dates) == 0 {
+		fmt.Println("No dates saved")
+		return nil
+	}
+	for _, date := range dates {
+		fmt.Printf("#%-4d %-20s %-12s %s (%s)  %s\n",
+			date.ID, date.format(), date.Label, date.displayName(db), strings.Split(date.ContactJID, "@")[0], date.Note)
+	}
+	return nil
+}
+
+func runDatesAdd(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	contact := fs.String("contact", "", "Phone number, JID or saved contact name (required)")
+	value := fs.String("date", "", "YYYY-MM-DD, MM-DD, DD/MM/YYYY or DD/MM (required)")
+	label := fs.String("label", defaultContactDateLabel, "What the date is: birthday, anniversary or any other word")
+	name := fs.String("name", "", "Name to use when the contact has none")
+	note := fs.String("note", "", "Free-form note, given to the LLM drafting the greeting")
+	fs.Parse(args)
+
+	if *contact == "" || *value == "" {
+		return fmt.Errorf("--contact and --date are required")
+	}
+	jid, err := resolveContactReference(db, *contact)
+	if err != nil {
+		return err
+	}
+	month, day, year, err := parseContactDate(*value)
+	if err != nil {
+		return err
+	}
+	date := ContactDate{ContactJID: jid, Name: *name, Label: *label, Month: month, Day: day, Year: year, Note: *note}
+	id, err := saveContactDate(db, date)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Saved #%d: %s of %s on %s\n", id, strings.ToLower(*label), date.displayName(db), date.format())
+	return nil
+}
+
+func runDatesDelete(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	id := fs.Int64("id", 0, "ID of the date, as listed (required)")
+	fs.Parse(args)
+
+	deleted, err := deleteContactDate(db, *id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf("no date #%d", *id)
+	}
+	fmt.Printf("Deleted #%d\n", *id)
+	return nil
+}
+
+func runDatesImport(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	path := fs.String("csv", "", "CSV file to import (required)")
+	fs.Parse(args)
+
+	if *path == "" {
+		return fmt.Errorf("--csv is required")
+	}
+	file, err := os.Open(*path)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV: %v", err)
+	}
+	defer file.Close()
+
+	saved, problems, err := importContactDates(db, file)
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "Skipped %s\n", problem)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d date(s), skipped %d row(s)\n", saved, len(problems))
+	return nil
+}
+
+func runDatesRemind(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("remind", flag.ExitOnError)
+	defaultDaysAhead, _ := strconv.Atoi(os.Getenv("DATE_REMINDERS_DAYS_AHEAD"))
+	daysAhead := fs.Int("days-ahead", defaultDaysAhead, "Also remind of the dates within this many days (defaults to DATE_REMINDERS_DAYS_AHEAD, then 0)")
+	dryRun := fs.Bool("dry-run", false, "Print the reminders instead of sending them")
+	fs.Parse(args)
+
+	logger := newLogger("DateReminders", "INFO")
+	if exitIfSafeMode(logger) {
+		return nil
+	}
+
+	loc := time.Local
+	if timezone := os.Getenv("DAILY_SUMMARY_TIMEZONE"); timezone != "" {
+		if tz, err := time.LoadLocation(timezone); err == nil {
+			loc = tz
+		}
+	}
+	today := time.Now().In(loc)
+	upcoming, err := upcomingContactDates(db, today, *daysAhead)
+	if err != nil {
+		return err
+	}
+	config, err := loadBridgeConfig()
+	if err != nil {
+		logger.Warnf("Failed to load config: %v", err)
+		config = &BridgeConfig{}
+	}
+
+	sent := 0
+	for _, date := range upcoming {
+		on := date.On.Format("2006-01-02")
+		if date.LastRemindedOn == on && !*dryRun {
+			continue
+		}
+		reminder := formatDateReminder(date, date.displayName(db), today)
+		greeting, err := draftDateGreeting(context.Background(), db, config, date, logger)
+		if err != nil {
+			logger.Warnf("Failed to draft a greeting for %s: %v", date.ContactJID, err)
+		}
+		if *dryRun {
+			fmt.Printf("%s\n\n%s\n\n", reminder, greeting)
+			continue
+		}
+
+		if err := sendToRecipient(reminder, "self", logger); err != nil {
+			return fmt.Errorf("failed to send reminder: %v", err)
+		}
+		// On its own, so it can be forwarded as is
+		if greeting != "" {
+			if err := sendToRecipient(greeting, "self", logger); err != nil {
+				logger.Warnf("Failed to send the greeting for %s: %v", date.ContactJID, err)
+			}
+		}
+		if _, err := db.Exec("UPDATE contact_dates SET last_reminded_on = ? WHERE id = ?", on, date.ID); err != nil {
+			logger.Warnf("Failed to record the reminder of #%d: %v", date.ID, err)
+		}
+		sent++
+	}
+	logger.Infof("Sent %d date reminder(s)", sent)
+	return nil
+}
+
+// formatDateReminder renders the reminder of a date, e.g. "🎂 *Ana*'s birthday is today (turns 35)"
+func formatDateReminder(date UpcomingContactDate, name string, today time.Time) string {
+	icon := "📅"
+	switch date.Label {
+	case "birthday":
+		icon = "🎂"
+	case "anniversary":
+		icon = "💍"
+	}
+	when := "today"
+	midnight := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location())
+	switch days := int(date.On.Sub(midnight).Hours()/24 + 0.5); {
+	case days == 1:
+		when = "tomorrow"
+	case days > 1 && days < 7:
+		when = "on " + date.On.Format("Monday")
+	case days >= 7:
+		when = "on " + date.On.Format("January 2")
+	}
+	reminder := fmt.Sprintf("%s *%s*'s %s is %s", icon, name, date.Label, when)
+	if years := date.On.Year() - date.Year; date.Year > 0 && years > 0 {
+		if date.Label == "birthday" {
+			reminder += fmt.Sprintf(" (turns %d)", years)
+		} else {
+			reminder += fmt.Sprintf(" (%d years)", years)
+		}
+	}
+	if date.Note != "" {
+		reminder += "\n_" + date.Note + "_"
+	}
+	return reminder
+}
+
+// draftDateGreeting asks the LLM for a greeting to send on a date, in the contact's configured language or the
+// language of our recent messages
+func draftDateGreeting(ctx context.Context, db *sql.DB, config *BridgeConfig, date UpcomingContactDate, logger waLog.Logger) (string, error) {
+	name := date.displayName(db)
+	years := ""
+	if date.Year > 0 && date.On.Year() > date.Year {
+		years = fmt.Sprintf(" (%d years)", date.On.Year()-date.Year)
+	}
+	note := ""
+	if date.Note != "" {
+		note = "\n\nAbout them: " + date.Note
+	}
+	language := config.getRecipientConfig(date.ContactJID).Language
+	if language == "" {
+		language = "the language of our recent messages, or English without any"
+	}
+
+	// The contact's chat may be kept in the direct chat database, with its text encrypted
+	messagesDB, directCipher := db, cipher.AEAD(nil)
+	if directDB, dbCipher, err := openSharedDirectMessagesDB(); err != nil {
+		logger.Warnf("Failed to open the direct chat database: %v", err)
+	} else if directDB != nil {
+		messagesDB, directCipher = directDB, dbCipher
+	}
+
+	recent := ""
+	rows, err := messagesDB.Query(`
+		SELECT is_from_me, content FROM messages
+		WHERE chat_jid = ? AND COALESCE(content, '') != '' AND COALESCE(message_type, '') = '' AND deleted_at IS NULL
+		ORDER BY timestamp DESC LIMIT ?
+	`, date.ContactJID, dateGreetingMessages)
+	if err == nil {
+		var lines []string
+		for rows.Next() {
+			var fromMe bool
+			var content string
+			if rows.Scan(&fromMe, &content) == nil {
+				content = openDirectMessageText(directCipher, content)
+				sender := name
+				if fromMe {
+					sender = "Me"
+				}
+				lines = append([]string{sender + ": " + strings.Join(strings.Fields(content), " ")}, lines...)
+			}
+		}
+		rows.Close()
+		if len(lines) > 0 {
+			recent = "\n\nOur recent messages, for the tone:\n<messages>\n" + strings.Join(lines, "\n") + "\n</messages>"
+		}
+	}
+
+	prompt := fmt.Sprintf(dateGreetingPrompt, name, date.Label, years, note, language, recent)
+	ctx = withLLMPurpose(withLLMAuditScope(ctx, date.ContactJID, date.On.Format("2006-01-02")), "date_greeting")
+	greeting, err := callLLM(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(greeting), nil
+}
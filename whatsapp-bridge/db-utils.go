@@ -0,0 +1,511 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// messagesDBDSN opens messages.db in WAL mode, so the tools can read while the bridge writes, waiting up to
+// 5 seconds for a lock instead of failing with "database is locked"
+const messagesDBDSN = "file:store/messages.db?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000"
+
+// Driver that unlocks messages.db with its SQLCipher key before any other statement
+const sqlcipherDriverName = "sqlite3_sqlcipher"
+
+// messagesDBCipher holds the SQLCipher key of messages.db, loaded once per process
+var messagesDBCipher struct {
+	sync.Once
+	key string
+	err error
+}
+
+// loadMessagesDBKey returns the SQLCipher key of messages.db: the contents of MESSAGES_DB_KEYFILE (e.g. a Docker
+// secret) or MESSAGES_DB_KEY; empty when the database isn't encrypted
+func loadMessagesDBKey() (string, error) {
+	if path := os.Getenv("MESSAGES_DB_KEYFILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read MESSAGES_DB_KEYFILE: %v", err)
+		}
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return "", fmt.Errorf("MESSAGES_DB_KEYFILE %s is empty", path)
+		}
+		return key, nil
+	}
+	return os.Getenv("MESSAGES_DB_KEY"), nil
+}
+
+// messagesDBDriver returns the database/sql driver for messages.db and the direct message database: plain
+// "sqlite3", or one that sets the SQLCipher key on every new connection when a key is configured
+func messagesDBDriver() string {
+	messagesDBCipher.Do(func() {
+		messagesDBCipher.key, messagesDBCipher.err = loadMessagesDBKey()
+		if messagesDBCipher.key == "" && messagesDBCipher.err == nil {
+			return
+		}
+		sql.Register(sqlcipherDriverName, &sqlite3.SQLiteDriver{ConnectHook: unlockSQLCipher})
+	})
+	if messagesDBCipher.key == "" && messagesDBCipher.err == nil {
+		return "sqlite3"
+	}
+	return sqlcipherDriverName
+}
+
+// unlockSQLCipher gives a new connection the database key. SQLite without SQLCipher ignores the key pragma, which
+// would silently create an unencrypted database, so the connection is refused unless SQLCipher answers.
+func unlockSQLCipher(conn *sqlite3.SQLiteConn) error {
+	if messagesDBCipher.err != nil {
+		return messagesDBCipher.err
+	}
+	if _, err := conn.Exec("PRAGMA key = '"+strings.ReplaceAll(messagesDBCipher.key, "'", "''")+"'", nil); err != nil {
+		return fmt.Errorf("failed to set the database key: %v", err)
+	}
+
+	rows, err := conn.Query("PRAGMA cipher_version", nil)
+	if err != nil {
+		return fmt.Errorf("failed to check SQLCipher: %v", err)
+	}
+	values := make([]driver.Value, 1)
+	hasCipher := rows.Next(values) == nil
+	rows.Close()
+	if !hasCipher {
+		return fmt.Errorf("MESSAGES_DB_KEY is set but SQLite was built without SQLCipher (build with WITH_SQLCIPHER=true)")
+	}
+
+	// A wrong key only shows when the file is read
+	if _, err := conn.Exec("SELECT count(*) FROM sqlite_master", nil); err != nil {
+		return fmt.Errorf("failed to unlock the database, is the key right? %v", err)
+	}
+	return nil
+}
+
+// sharedMessagesDB is the process's pooled handle on messages.db, with the statements prepared on it
+var sharedMessagesDB struct {
+	sync.Mutex
+	db         *sql.DB
+	statements map[string]*sql.Stmt
+}
+
+// openSharedMessagesDB returns the process's shared handle on messages.db, opening it on first use.
+// Callers must not close it.
+func openSharedMessagesDB() (*sql.DB, error) {
+	sharedMessagesDB.Lock()
+	defer sharedMessagesDB.Unlock()
+	if sharedMessagesDB.db != nil {
+		return sharedMessagesDB.db, nil
+	}
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message database: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open message database: %v", err)
+	}
+	sharedMessagesDB.db, sharedMessagesDB.statements = db, map[string]*sql.Stmt{}
+	return db, nil
+}
+
+// prepareMessagesStatement returns a statement on the shared messages.db handle, prepared on first use
+func prepareMessagesStatement(query string) (*sql.Stmt, error) {
+	db, err := openSharedMessagesDB()
+	if err != nil {
+		return nil, err
+	}
+	sharedMessagesDB.Lock()
+	defer sharedMessagesDB.Unlock()
+	if stmt, ok := sharedMessagesDB.statements[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	sharedMessagesDB.statements[query] = stmt
+	return stmt, nil
+}
+
+// Prefix of text encrypted with DIRECT_MESSAGES_KEY; text without it was stored before the key was set
+const encryptedTextPrefix = "enc:v1:"
+
+// sharedDirectMessagesDB is the process's handle on the direct chat database, like sharedMessagesDB
+var sharedDirectMessagesDB struct {
+	sync.Mutex
+	opened bool
+	db     *sql.DB
+	cipher cipher.AEAD
+}
+
+// getDirectMessagesDBPath returns the database file direct chats are stored in (DIRECT_MESSAGES_DB);
+// empty keeps them in messages.db with the groups
+func getDirectMessagesDBPath() string {
+	return os.Getenv("DIRECT_MESSAGES_DB")
+}
+
+// loadDirectMessagesCipher returns the cipher for direct message text (DIRECT_MESSAGES_KEY), or nil when it isn't set.
+// The key is a passphrase; AES-256-GCM uses its SHA-256.
+func loadDirectMessagesCipher() (cipher.AEAD, error) {
+	passphrase := os.Getenv("DIRECT_MESSAGES_KEY")
+	if passphrase == "" {
+		return nil, nil
+	}
+	key := sha256.Sum256([]byte(passphrase))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// isDirectChat reports whether a chat is a direct chat (anything but a group)
+func isDirectChat(chatJID string) bool {
+	return !strings.HasSuffix(chatJID, "@g.us")
+}
+
+// openSharedDirectMessagesDB returns the process's shared handle on the direct chat database and the cipher of
+// its text, opening them on first use; the handle is nil when direct chats are kept in messages.db.
+// Callers must not close it.
+func openSharedDirectMessagesDB() (*sql.DB, cipher.AEAD, error) {
+	sharedDirectMessagesDB.Lock()
+	defer sharedDirectMessagesDB.Unlock()
+	if sharedDirectMessagesDB.opened {
+		return sharedDirectMessagesDB.db, sharedDirectMessagesDB.cipher, nil
+	}
+	directCipher, err := loadDirectMessagesCipher()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid DIRECT_MESSAGES_KEY: %v", err)
+	}
+	path := getDirectMessagesDBPath()
+	if path == "" {
+		sharedDirectMessagesDB.opened = true
+		return nil, nil, nil
+	}
+	db, err := sql.Open(messagesDBDriver(), "file:"+path+"?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open direct message database: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("failed to open direct message database: %v", err)
+	}
+	sharedDirectMessagesDB.opened, sharedDirectMessagesDB.db, sharedDirectMessagesDB.cipher = true, db, directCipher
+	return db, directCipher, nil
+}
+
+// openMessagesDBFor returns the shared handle on the database a chat's messages are stored in
+func openMessagesDBFor(chatJID string) (*sql.DB, error) {
+	if isDirectChat(chatJID) {
+		db, _, err := openSharedDirectMessagesDB()
+		if err != nil || db != nil {
+			return db, err
+		}
+	}
+	return openSharedMessagesDB()
+}
+
+// openDirectMessageText decrypts message text of the direct chat database; text stored in the clear is returned as is
+func openDirectMessageText(directCipher cipher.AEAD, text string) string {
+	if !strings.HasPrefix(text, encryptedTextPrefix) {
+		return text
+	}
+	if directCipher == nil {
+		return "[encrypted]"
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(text, encryptedTextPrefix))
+	nonceSize := directCipher.NonceSize()
+	if err != nil || len(sealed) < nonceSize {
+		return "[encrypted]"
+	}
+	plain, err := directCipher.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "[encrypted]"
+	}
+	return string(plain)
+}
+
+// parseSQLiteTime parses a timestamp returned as text by SQLite (e.g. from MAX(timestamp), where
+// the column type is lost and the driver no longer converts the value to time.Time)
+func parseSQLiteTime(value string) time.Time {
+	value = strings.TrimSuffix(value, "Z")
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// mediaChatDirs returns the directory a chat's downloaded media is saved in, store/media/<chat>, and the one
+// bridges before it used, store/<chat>, where earlier downloads stay
+func mediaChatDirs(chatJID string) (string, string) {
+	name := strings.ReplaceAll(chatJID, ":", "_")
+	return filepath.Join("store", "media", name), filepath.Join("store", name)
+}
+
+// addColumnIfMissing adds a column to an existing table, doing nothing if the column is already there
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+		return err
+	}
+	return nil
+}
+
+// ensureMessageTypeColumn adds the message_type and origin columns to messages on databases created before they existed.
+// Regular messages have an empty type; group events use the messageType* constants.
+// Messages written by people have an empty origin; messages sent by the bridge or the tools use the messageOrigin* constants.
+func ensureMessageTypeColumn(db *sql.DB) error {
+	if err := addColumnIfMissing(db, "messages", "message_type", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	return addColumnIfMissing(db, "messages", "origin", "TEXT DEFAULT ''")
+}
+
+// ensureTranscriptColumn adds the column holding voice note transcripts to messages
+func ensureTranscriptColumn(db *sql.DB) error {
+	return addColumnIfMissing(db, "messages", "transcript", "TEXT DEFAULT ''")
+}
+
+// ensureEditColumns adds the columns marking edited and deleted messages, and the table keeping the earlier
+// versions of edited messages. Deleted messages keep their content but are shown as deleted.
+func ensureEditColumns(db *sql.DB) error {
+	if err := addColumnIfMissing(db, "messages", "edited_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "messages", "deleted_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "messages", "deleted_by", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS message_edits (
+			message_id TEXT,
+			chat_jid TEXT,
+			content TEXT,
+			replaced_at TIMESTAMP,
+			edited_by TEXT
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_message_edits_message ON message_edits (chat_jid, message_id);
+	`)
+	return err
+}
+
+// ensureReactionsTable creates the table of message reactions; each person has at most one reaction per message
+func ensureReactionsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS reactions (
+			message_id TEXT,
+			chat_jid TEXT,
+			reactor TEXT,
+			emoji TEXT,
+			timestamp TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid, reactor)
+		);
+	`)
+	return err
+}
+
+// getReactionCounts returns the reactions to a chat's messages sent between start and end, counted by message ID and emoji
+func getReactionCounts(db *sql.DB, chatJID string, start, end time.Time) (map[string]map[string]int, error) {
+	rows, err := db.Query(`
+		SELECT r.message_id, r.emoji, COUNT(*)
+		FROM reactions r
+		JOIN messages m ON m.id = r.message_id AND m.chat_jid = r.chat_jid
+		WHERE r.chat_jid = ? AND m.timestamp >= ? AND m.timestamp <= ?
+		GROUP BY r.message_id, r.emoji
+	`, chatJID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]map[string]int)
+	for rows.Next() {
+		var messageID, emoji string
+		var count int
+		if err := rows.Scan(&messageID, &emoji, &count); err != nil {
+			return nil, err
+		}
+		if counts[messageID] == nil {
+			counts[messageID] = make(map[string]int)
+		}
+		counts[messageID][emoji] = count
+	}
+	return counts, rows.Err()
+}
+
+// ReplyTarget is the message a reply quotes
+type ReplyTarget struct {
+	ID     string
+	Sender string    // phone number (or JID) of its sender
+	Time   time.Time // zero when the quoted message isn't stored
+}
+
+// getReplyTargets returns what each reply sent in a chat between two times quotes, by reply ID. Databases the
+// bridge hasn't migrated to reply threads yet have none.
+func getReplyTargets(db *sql.DB, chatJID string, start, end time.Time) (map[string]ReplyTarget, error) {
+	rows, err := db.Query(`
+		SELECT t.message_id, t.reply_to_id, COALESCE(quoted.sender, t.reply_to_sender, ''), quoted.timestamp
+		FROM message_threads t
+		JOIN messages m ON m.id = t.message_id AND m.chat_jid = t.chat_jid
+		LEFT JOIN messages quoted ON quoted.id = t.reply_to_id AND quoted.chat_jid = t.chat_jid
+		WHERE t.chat_jid = ? AND m.timestamp >= ? AND m.timestamp <= ?
+	`, chatJID, start, end)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := make(map[string]ReplyTarget)
+	for rows.Next() {
+		var messageID string
+		var target ReplyTarget
+		var quotedAt sql.NullTime
+		if err := rows.Scan(&messageID, &target.ID, &target.Sender, &quotedAt); err != nil {
+			return nil, err
+		}
+		target.Sender = strings.SplitN(target.Sender, "@", 2)[0]
+		target.Time = quotedAt.Time
+		targets[messageID] = target
+	}
+	return targets, rows.Err()
+}
+
+// deleteMessages deletes the messages matching a condition on the messages table, with their reactions, edits,
+// receipts, polls, reply links, media file records and raw protobufs
+func deleteMessages(db *sql.DB, condition string, args ...interface{}) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	deleted, err := deleteMessagesTx(tx, condition, args...)
+	if err != nil {
+		return 0, err
+	}
+	return deleted, tx.Commit()
+}
+
+// deleteMessagesTx is deleteMessages within a transaction
+func deleteMessagesTx(tx *sql.Tx, condition string, args ...interface{}) (int64, error) {
+	selected := "SELECT id, chat_jid FROM messages WHERE " + condition
+
+	for _, query := range []string{
+		"DELETE FROM reactions WHERE (message_id, chat_jid) IN (" + selected + ")",
+		"DELETE FROM message_edits WHERE (message_id, chat_jid) IN (" + selected + ")",
+		"DELETE FROM message_receipts WHERE (message_id, chat_jid) IN (" + selected + ")",
+		"DELETE FROM poll_votes WHERE (poll_id, chat_jid) IN (" + selected + ")",
+		"DELETE FROM polls WHERE (message_id, chat_jid) IN (" + selected + ")",
+		"DELETE FROM raw_messages WHERE (message_id, chat_jid) IN (" + selected + ")",
+		"DELETE FROM message_threads WHERE (message_id, chat_jid) IN (" + selected + ")",
+		"DELETE FROM media_files WHERE (message_id, chat_jid) IN (" + selected + ")",
+		"DELETE FROM message_translations WHERE (message_id, chat_jid) IN (" + selected + ")",
+		"DELETE FROM message_flags WHERE (message_id, chat_jid) IN (" + selected + ")",
+	} {
+		if _, err := tx.Exec(query, args...); err != nil {
+			return 0, err
+		}
+	}
+	result, err := tx.Exec("DELETE FROM messages WHERE "+condition, args...)
+	if err != nil {
+		return 0, err
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted, nil
+}
+
+// formatReactionCounts renders reaction counts as "👍×3 ❤️×1", most frequent first
+func formatReactionCounts(counts map[string]int) string {
+	emojis := make([]string, 0, len(counts))
+	for emoji := range counts {
+		emojis = append(emojis, emoji)
+	}
+	sort.Slice(emojis, func(i, j int) bool {
+		if counts[emojis[i]] != counts[emojis[j]] {
+			return counts[emojis[i]] > counts[emojis[j]]
+		}
+		return emojis[i] < emojis[j]
+	})
+
+	parts := make([]string, len(emojis))
+	for i, emoji := range emojis {
+		parts[i] = fmt.Sprintf("%s×%d", emoji, counts[emoji])
+	}
+	return strings.Join(parts, " ")
+}
+
+// Origins of messages sent automatically rather than typed by a person
+const (
+	messageOriginBridgeAPI   = "bridge_api"  // sent through the REST API (MCP tools, campaigns, ...)
+	messageOriginClaude      = "claude"      // Claude's replies in the self-chat
+	messageOriginSummary     = "summary"     // daily summaries and reports
+	messageOriginPrefix      = "prefix"      // recognized by BRIDGE_MESSAGE_PREFIX, e.g. sent by another bridge instance
+	messageOriginAlert       = "alert"       // error reports sent to the admin chat and keyword alerts (see error-reporting.go, alerts.go)
+	messageOriginCommand     = "command"     // answers to in-chat commands such as /ask (see chat-commands.go)
+	messageOriginTranslation = "translation" // translations of incoming messages forwarded to the self-chat (see translation.go)
+)
+
+// getBridgeMessagePrefix returns the optional prefix added to every automated message (BRIDGE_MESSAGE_PREFIX, e.g. "🤖 ")
+func getBridgeMessagePrefix() string {
+	return os.Getenv("BRIDGE_MESSAGE_PREFIX")
+}
+
+// applyBridgeMessagePrefix adds the configured prefix to an automated message
+func applyBridgeMessagePrefix(text string) string {
+	prefix := getBridgeMessagePrefix()
+	if prefix == "" || text == "" || strings.HasPrefix(text, prefix) {
+		return text
+	}
+	return prefix + text
+}
+
+// hasBridgeMessagePrefix reports whether a message carries the automated message prefix
+func hasBridgeMessagePrefix(text string) bool {
+	prefix := getBridgeMessagePrefix()
+	return prefix != "" && strings.HasPrefix(text, prefix)
+}
+
+// Message types of the system messages stored for group events
+const (
+	messageTypeGroupSubject     = "group_subject"
+	messageTypeGroupDescription = "group_description"
+	messageTypeGroupSettings    = "group_settings"
+	messageTypeMemberJoin       = "member_join"
+	messageTypeMemberLeave      = "member_leave"
+	messageTypeMemberPromote    = "member_promote"
+	messageTypeMemberDemote     = "member_demote"
+)
+
+// isMemberEventType reports whether a message type is a membership change
+func isMemberEventType(messageType string) bool {
+	return strings.HasPrefix(messageType, "member_")
+}
+
+// getAdminChatJID returns the chat that receives operator notifications
+func getAdminChatJID() string {
+	adminChat := os.Getenv("ADMIN_CHAT_JID")
+	if adminChat == "" {
+		adminChat = "self"
+	}
+	return adminChat
+}
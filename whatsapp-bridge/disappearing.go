@@ -0,0 +1,193 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// How often messages of disappearing chats are purged, when DISAPPEARING_MESSAGES_PURGE is on
+const disappearingPurgeInterval = time.Hour
+
+// ensureDisappearingColumns adds the column with when a message disappears on WhatsApp, and the table with
+// each chat's disappearing-message timer. The timers aren't kept in chats, which StoreChat replaces wholesale.
+func ensureDisappearingColumns(db *sql.DB) error {
+	if err := addColumnIfMissing(db, "messages", "expires_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS chat_ephemeral_timers (
+			chat_jid TEXT PRIMARY KEY,
+			timer INTEGER,
+			updated_at TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_messages_expires_at ON messages (expires_at);
+	`)
+	return err
+}
+
+// disappearingPurgeEnabled reports whether messages are deleted from the local store once they disappear
+// on WhatsApp (DISAPPEARING_MESSAGES_PURGE, off by default)
+func disappearingPurgeEnabled() bool {
+	return os.Getenv("DISAPPEARING_MESSAGES_PURGE") == "true"
+}
+
+// getDisappearingGrace returns how long disappeared messages are kept past their timer (DISAPPEARING_MESSAGES_GRACE,
+// e.g. "24h"), so a daily summary can still include them; 0 by default
+func getDisappearingGrace() time.Duration {
+	grace, err := time.ParseDuration(os.Getenv("DISAPPEARING_MESSAGES_GRACE"))
+	if err != nil || grace < 0 {
+		return 0
+	}
+	return grace
+}
+
+// formatEphemeralTimer describes a disappearing-message timer, e.g. "7 days"
+func formatEphemeralTimer(seconds uint32) string {
+	timer := time.Duration(seconds) * time.Second
+	switch {
+	case timer > 24*time.Hour && timer%(24*time.Hour) == 0:
+		return fmt.Sprintf("%d days", timer/(24*time.Hour))
+	case timer >= time.Hour && timer%time.Hour == 0:
+		return fmt.Sprintf("%d hours", timer/time.Hour)
+	default:
+		return timer.String()
+	}
+}
+
+// messageContextInfos returns the context info of each kind of content a message can carry; all but one are nil
+func messageContextInfos(msg *waProto.Message) []*waProto.ContextInfo {
+	return []*waProto.ContextInfo{
+		msg.GetExtendedTextMessage().GetContextInfo(),
+		msg.GetImageMessage().GetContextInfo(),
+		msg.GetVideoMessage().GetContextInfo(),
+		msg.GetAudioMessage().GetContextInfo(),
+		msg.GetDocumentMessage().GetContextInfo(),
+		msg.GetStickerMessage().GetContextInfo(),
+		msg.GetContactMessage().GetContextInfo(),
+		msg.GetLocationMessage().GetContextInfo(),
+	}
+}
+
+// messageExpiration returns the disappearing timer a message was sent with, in seconds; 0 when it doesn't disappear
+func messageExpiration(msg *waProto.Message) uint32 {
+	for _, contextInfo := range messageContextInfos(msg) {
+		if expiration := contextInfo.GetExpiration(); expiration > 0 {
+			return expiration
+		}
+	}
+	return 0
+}
+
+// withEphemeralExpiration sends a message with a chat's disappearing timer, creating the context info when needed
+func withEphemeralExpiration(contextInfo *waProto.ContextInfo, timer uint32) *waProto.ContextInfo {
+	if contextInfo == nil {
+		contextInfo = &waProto.ContextInfo{}
+	}
+	contextInfo.Expiration = proto.Uint32(timer)
+	return contextInfo
+}
+
+// SetEphemeralTimer records a chat's disappearing-message timer in seconds, 0 when it is off
+func (store *MessageStore) SetEphemeralTimer(chatJID string, timer uint32, updatedAt time.Time) error {
+	_, err := store.db.Exec(`
+		INSERT INTO chat_ephemeral_timers (chat_jid, timer, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (chat_jid) DO UPDATE SET timer = excluded.timer, updated_at = excluded.updated_at
+		WHERE excluded.updated_at >= chat_ephemeral_timers.updated_at
+	`, chatJID, timer, updatedAt)
+	return err
+}
+
+// GetEphemeralTimer returns a chat's disappearing-message timer in seconds; 0 when it is off or unknown
+func (store *MessageStore) GetEphemeralTimer(chatJID string) (uint32, error) {
+	var timer uint32
+	err := store.db.QueryRow("SELECT timer FROM chat_ephemeral_timers WHERE chat_jid = ?", chatJID).Scan(&timer)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return timer, err
+}
+
+// SetMessageExpiry records when a message disappears on WhatsApp
+func (store *MessageStore) SetMessageExpiry(id, chatJID string, expiresAt time.Time) error {
+	_, err := store.dbFor(chatJID).Exec("UPDATE messages SET expires_at = ? WHERE id = ? AND chat_jid = ?", expiresAt, id, chatJID)
+	return err
+}
+
+// recordMessageExpiry records when a stored message disappears: from the timer it was sent with,
+// or the chat's known timer when WhatsApp only flagged it as disappearing
+func recordMessageExpiry(messageStore *MessageStore, msg *events.Message, chatJID string, logger waLog.Logger) {
+	timer := messageExpiration(msg.Message)
+	if timer > 0 {
+		// The sender's client knows the chat's current timer, even when this device missed the change
+		if err := messageStore.SetEphemeralTimer(chatJID, timer, msg.Info.Timestamp); err != nil {
+			logger.Warnf("Failed to store disappearing timer of %s: %v", chatJID, err)
+		}
+	} else if msg.IsEphemeral {
+		var err error
+		if timer, err = messageStore.GetEphemeralTimer(chatJID); err != nil {
+			logger.Warnf("Failed to get disappearing timer of %s: %v", chatJID, err)
+		}
+	}
+	if timer == 0 {
+		return
+	}
+	expiresAt := msg.Info.Timestamp.Add(time.Duration(timer) * time.Second)
+	if err := messageStore.SetMessageExpiry(msg.Info.ID, chatJID, expiresAt); err != nil {
+		logger.Warnf("Failed to store expiry of %s: %v", msg.Info.ID, err)
+	}
+}
+
+// handleEphemeralSetting records a chat's disappearing-message timer being turned on, changed or off
+func handleEphemeralSetting(messageStore *MessageStore, chatJID, sender string, protocol *waProto.ProtocolMessage, receivedAt time.Time, logger waLog.Logger) {
+	timestamp := receivedAt
+	if ts := protocol.GetEphemeralSettingTimestamp(); ts > 0 {
+		timestamp = time.Unix(ts, 0)
+	}
+	timer := protocol.GetEphemeralExpiration()
+	if err := messageStore.SetEphemeralTimer(chatJID, timer, timestamp); err != nil {
+		logger.Warnf("Failed to store disappearing timer of %s: %v", chatJID, err)
+		return
+	}
+	if timer == 0 {
+		fmt.Printf("[%s] %s turned off disappearing messages in %s\n", timestamp.Format("2006-01-02 15:04:05"), sender, chatJID)
+	} else {
+		fmt.Printf("[%s] %s set disappearing messages in %s to %s\n", timestamp.Format("2006-01-02 15:04:05"), sender, chatJID, formatEphemeralTimer(timer))
+	}
+}
+
+// purgeDisappearedMessages deletes the messages that disappeared on WhatsApp more than the grace period ago
+func (store *MessageStore) purgeDisappearedMessages(now time.Time, logger waLog.Logger) {
+	cutoff := now.Add(-getDisappearingGrace())
+	for _, db := range []*sql.DB{store.db, store.direct} {
+		if db == nil {
+			continue
+		}
+		deleted, err := deleteMessages(db, "expires_at IS NOT NULL AND expires_at < ?", cutoff)
+		if err != nil {
+			logger.Warnf("Failed to purge disappeared messages: %v", err)
+		} else if deleted > 0 {
+			logger.Infof("Purged %d messages that disappeared on WhatsApp", deleted)
+		}
+	}
+}
+
+// startDisappearingPurge purges disappeared messages now and every hour, when DISAPPEARING_MESSAGES_PURGE is on
+func startDisappearingPurge(store *MessageStore, logger waLog.Logger) {
+	if !disappearingPurgeEnabled() {
+		return
+	}
+	go func() {
+		for {
+			store.purgeDisappearedMessages(time.Now(), logger)
+			time.Sleep(disappearingPurgeInterval)
+		}
+	}()
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EmbeddingRequest represents the request body of an OpenAI-compatible /embeddings endpoint
+type EmbeddingRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+// EmbeddingResponse represents the response of an OpenAI-compatible /embeddings endpoint
+type EmbeddingResponse struct {
+	Data []struct {
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// getEmbeddingModel returns the embedding model name (EMBEDDING_MODEL, default nomic-embed-text)
+func getEmbeddingModel() string {
+	model := os.Getenv("EMBEDDING_MODEL")
+	if model == "" {
+		model = "nomic-embed-text"
+	}
+	return model
+}
+
+// createEmbedding computes the embedding of a text with an OpenAI-compatible API
+// (EMBEDDING_API_URL, default a local Ollama server; EMBEDDING_API_KEY is optional)
+func createEmbedding(ctx context.Context, text string) ([]float32, error) {
+	apiURL := os.Getenv("EMBEDDING_API_URL")
+	if apiURL == "" {
+		apiURL = "http://host.docker.internal:11434/v1"
+	}
+
+	jsonData, err := json.Marshal(EmbeddingRequest{Model: getEmbeddingModel(), Input: text})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(apiURL, "/")+"/embeddings", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey := os.Getenv("EMBEDDING_API_KEY"); apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	client := &http.Client{
+		Timeout: 60 * time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %v", err)
+	}
+
+	var embeddingResp EmbeddingResponse
+	if err := json.Unmarshal(body, &embeddingResp); err != nil {
+		return nil, fmt.Errorf("error parsing response (HTTP %d): %v", resp.StatusCode, err)
+	}
+	if embeddingResp.Error != nil {
+		return nil, fmt.Errorf("embedding API error: %s", embeddingResp.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK || len(embeddingResp.Data) == 0 {
+		return nil, fmt.Errorf("embedding API returned HTTP %d without data", resp.StatusCode)
+	}
+
+	return embeddingResp.Data[0].Embedding, nil
+}
+
+// encodeEmbedding serializes an embedding as little-endian float32s for storage in a BLOB
+func encodeEmbedding(embedding []float32) []byte {
+	buf := make([]byte, 4*len(embedding))
+	for i, value := range embedding {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(value))
+	}
+	return buf
+}
+
+// decodeEmbedding deserializes an embedding stored with encodeEmbedding
+func decodeEmbedding(data []byte) []float32 {
+	embedding := make([]float32, len(data)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return embedding
+}
+
+// cosineSimilarity returns the cosine similarity of two embeddings (0 if their sizes differ)
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// VectorSearchResult is an episode returned by a similarity search
+type VectorSearchResult struct {
+	UUID      string  `json:"uuid"`
+	Namespace string  `json:"namespace"`
+	GroupJID  string  `json:"group_jid"`
+	Date      string  `json:"date"`
+	Topic     string  `json:"topic"`
+	Name      string  `json:"name"`
+	Body      string  `json:"body"`
+	Score     float64 `json:"score"`
+}
+
+// getVectorDBPath returns the path of the vector store database (VECTOR_DB_PATH, default store/knowledge.db)
+func getVectorDBPath() string {
+	path := os.Getenv("VECTOR_DB_PATH")
+	if path == "" {
+		path = "store/knowledge.db"
+	}
+	return path
+}
+
+// searchVectorIndex returns the episodes of a vector store matching a condition on knowledge_vectors that are
+// most similar to a query, best first
+func searchVectorIndex(ctx context.Context, db *sql.DB, query, condition string, args []interface{}, limit int) ([]VectorSearchResult, error) {
+	queryEmbedding, err := createEmbedding(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create query embedding: %v", err)
+	}
+
+	rows, err := db.Query("SELECT uuid, namespace, group_jid, date, topic, name, body, embedding FROM knowledge_vectors WHERE "+condition, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Brute-force scan: fine for the few thousand episodes a personal archive produces
+	var results []VectorSearchResult
+	for rows.Next() {
+		var result VectorSearchResult
+		var embedding []byte
+		if err := rows.Scan(&result.UUID, &result.Namespace, &result.GroupJID, &result.Date, &result.Topic, &result.Name, &result.Body, &embedding); err != nil {
+			return nil, err
+		}
+		result.Score = cosineSimilarity(queryEmbedding, decodeEmbedding(embedding))
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
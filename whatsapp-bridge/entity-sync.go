@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// EntityProfile represents a contact or group profile that is synced to Graphiti as an entity node
+type EntityProfile struct {
+	Key     string // "contact:<jid>" or "group:<jid>"
+	JID     string
+	Name    string
+	Summary string
+	GroupID string // Graphiti namespace the entity belongs to
+}
+
+// ContactEntity is a WhatsApp contact with the groups they were seen in
+type ContactEntity struct {
+	JID    string
+	Name   string
+	Phone  string
+	Groups []ContactGroup
+}
+
+// ContactGroup is a group a contact is a member of
+type ContactGroup struct {
+	JID  string
+	Name string
+}
+
+// maxSummaryGroups caps how many group names are listed in a contact summary
+const maxSummaryGroups = 20
+
+var (
+	importAliases = flag.String("import-aliases", "", "CSV file with contact aliases (jid,name,role,company) to load before syncing")
+	importGroups  = flag.String("import-groups", "", "CSV file with group profiles (jid,name,purpose) to load before syncing")
+	forceSync     = flag.Bool("force", false, "Re-send every profile even if it has not changed since the last sync")
+	syncDryRun    = flag.Bool("dry-run", false, "Show which profiles would be synced without calling Graphiti")
+	syncContacts  = flag.Bool("contacts", os.Getenv("ENTITY_SYNC_CONTACTS") == "true", "Also sync every named WhatsApp contact with their phone number and group memberships (defaults to ENTITY_SYNC_CONTACTS)")
+)
+
+func main() {
+	flag.Parse()
+
+	logger := newLogger("EntitySync", "INFO")
+	logger.Infof("Starting Graphiti entity sync...")
+
+	if exitIfSafeMode(logger) {
+		return
+	}
+
+	// Open SQLite database for messages
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		logger.Errorf("Failed to open message database: %v", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	if err := ensureEntityProfileTables(db); err != nil {
+		logger.Errorf("Failed to create entity profile tables: %v", err)
+		os.Exit(1)
+	}
+
+	// Load profile CSVs if requested
+	if *importAliases != "" {
+		count, err := importContactAliasesCSV(db, *importAliases)
+		if err != nil {
+			logger.Errorf("Failed to import contact aliases: %v", err)
+			os.Exit(1)
+		}
+		logger.Infof("Imported %d contact aliases from %s", count, *importAliases)
+	}
+	if *importGroups != "" {
+		count, err := importGroupProfilesCSV(db, *importGroups)
+		if err != nil {
+			logger.Errorf("Failed to import group profiles: %v", err)
+			os.Exit(1)
+		}
+		logger.Infof("Imported %d group profiles from %s", count, *importGroups)
+	}
+
+	// Contacts from the WhatsApp store, with the groups they post in
+	var contacts map[string]*ContactEntity
+	if *syncContacts {
+		contacts, err = loadContactDirectory(db, logger)
+		if err != nil {
+			logger.Errorf("Failed to load WhatsApp contacts: %v", err)
+			os.Exit(1)
+		}
+		logger.Infof("Loaded %d WhatsApp contacts", len(contacts))
+	}
+
+	profiles, err := loadEntityProfiles(db, contacts, logger)
+	if err != nil {
+		logger.Errorf("Failed to load entity profiles: %v", err)
+		os.Exit(1)
+	}
+
+	syncedCount, skippedCount, failedCount := 0, 0, 0
+	for _, profile := range profiles {
+		hash := hashEntityProfile(profile)
+
+		// Only send profiles that changed since the last successful sync
+		var entityUUID, lastHash string
+		err := db.QueryRow("SELECT uuid, content_hash FROM graphiti_entity_sync WHERE entity_key = ?", profile.Key).Scan(&entityUUID, &lastHash)
+		if err != nil && err != sql.ErrNoRows {
+			logger.Warnf("Failed to read sync state for %s: %v", profile.Key, err)
+			failedCount++
+			continue
+		}
+		if lastHash == hash && !*forceSync {
+			skippedCount++
+			continue
+		}
+		if entityUUID == "" {
+			entityUUID = uuid.NewString()
+		}
+
+		if *syncDryRun {
+			logger.Infof("DRY RUN: Would sync %s (%s)", profile.Name, profile.Key)
+			syncedCount++
+			continue
+		}
+
+		err = upsertGraphitiEntityNode(GraphitiEntityNodeRequest{
+			UUID:    entityUUID,
+			GroupID: profile.GroupID,
+			Name:    profile.Name,
+			Summary: profile.Summary,
+		})
+		if err != nil {
+			logger.Errorf("Failed to sync entity %s: %v", profile.Key, err)
+			failedCount++
+			continue
+		}
+
+		_, err = db.Exec(
+			"INSERT OR REPLACE INTO graphiti_entity_sync (entity_key, uuid, content_hash, synced_at) VALUES (?, ?, ?, ?)",
+			profile.Key, entityUUID, hash, time.Now(),
+		)
+		if err != nil {
+			logger.Warnf("Failed to record sync state for %s: %v", profile.Key, err)
+		}
+
+		logger.Infof("Synced entity: %s", profile.Name)
+		syncedCount++
+	}
+
+	logger.Infof("Entity sync completed: %d synced, %d unchanged, %d failed", syncedCount, skippedCount, failedCount)
+	if failedCount > 0 {
+		os.Exit(1)
+	}
+}
+
+// ensureEntityProfileTables creates the alias, group profile and sync state tables if they don't exist
+func ensureEntityProfileTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS contact_aliases (
+			jid TEXT PRIMARY KEY,
+			name TEXT,
+			role TEXT,
+			company TEXT,
+			updated_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS group_profiles (
+			jid TEXT PRIMARY KEY,
+			name TEXT,
+			purpose TEXT,
+			updated_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS graphiti_entity_sync (
+			entity_key TEXT PRIMARY KEY,
+			uuid TEXT,
+			content_hash TEXT,
+			synced_at TIMESTAMP
+		);
+	`)
+	return err
+}
+
+// readProfileCSV reads a CSV file with a header row and returns the remaining rows
+func readProfileCSV(path string, columns int) ([][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var rows [][]string
+	header := true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		if header {
+			header = false
+			continue
+		}
+
+		// Pad short rows so optional trailing columns can be omitted
+		for len(record) < columns {
+			record = append(record, "")
+		}
+		rows = append(rows, record)
+	}
+
+	return rows, nil
+}
+
+// importContactAliasesCSV loads jid,name,role,company rows into the contact_aliases table
+func importContactAliasesCSV(db *sql.DB, path string) (int, error) {
+	rows, err := readProfileCSV(path, 4)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, row := range rows {
+		jid := strings.TrimSpace(row[0])
+		if jid == "" {
+			continue
+		}
+		if !strings.Contains(jid, "@") {
+			jid = jid + "@s.whatsapp.net"
+		}
+
+		_, err := db.Exec(
+			"INSERT OR REPLACE INTO contact_aliases (jid, name, role, company, updated_at) VALUES (?, ?, ?, ?, ?)",
+			jid, strings.TrimSpace(row[1]), strings.TrimSpace(row[2]), strings.TrimSpace(row[3]), time.Now(),
+		)
+		if err != nil {
+			return count, fmt.Errorf("failed to store alias for %s: %v", jid, err)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// importGroupProfilesCSV loads jid,name,purpose rows into the group_profiles table
+func importGroupProfilesCSV(db *sql.DB, path string) (int, error) {
+	rows, err := readProfileCSV(path, 3)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, row := range rows {
+		jid := strings.TrimSpace(row[0])
+		if jid == "" {
+			continue
+		}
+
+		_, err := db.Exec(
+			"INSERT OR REPLACE INTO group_profiles (jid, name, purpose, updated_at) VALUES (?, ?, ?, ?)",
+			jid, strings.TrimSpace(row[1]), strings.TrimSpace(row[2]), time.Now(),
+		)
+		if err != nil {
+			return count, fmt.Errorf("failed to store group profile for %s: %v", jid, err)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// loadEntityProfiles builds entity profiles from the alias and group profile tables and, if given, the contact directory
+func loadEntityProfiles(db *sql.DB, contacts map[string]*ContactEntity, logger waLog.Logger) ([]EntityProfile, error) {
+	var profiles []EntityProfile
+	aliased := make(map[string]bool)
+
+	// Contacts with roles/companies
+	rows, err := db.Query("SELECT jid, COALESCE(name, ''), COALESCE(role, ''), COALESCE(company, '') FROM contact_aliases ORDER BY jid")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query contact aliases: %v", err)
+	}
+	for rows.Next() {
+		var jid, name, role, company string
+		if err := rows.Scan(&jid, &name, &role, &company); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan contact alias: %v", err)
+		}
+
+		contact := contacts[jid]
+		aliased[jid] = true
+
+		// Fall back to the WhatsApp contact name when no alias name is set
+		if name == "" {
+			if contact != nil {
+				name = contact.Name
+			} else {
+				name = getSenderName(jid, false, logger)
+			}
+		}
+
+		var groups []ContactGroup
+		if contact != nil {
+			groups = contact.Groups
+		}
+		profiles = append(profiles, contactEntityProfiles(jid, name, role, company, groups)...)
+	}
+	rows.Close()
+
+	// Remaining contacts from the WhatsApp store
+	contactJIDs := make([]string, 0, len(contacts))
+	for jid := range contacts {
+		if !aliased[jid] {
+			contactJIDs = append(contactJIDs, jid)
+		}
+	}
+	sort.Strings(contactJIDs)
+	for _, jid := range contactJIDs {
+		contact := contacts[jid]
+		profiles = append(profiles, contactEntityProfiles(jid, contact.Name, "", "", contact.Groups)...)
+	}
+
+	// Groups with purposes
+	rows, err = db.Query(`
+		SELECT g.jid, COALESCE(NULLIF(g.name, ''), c.name, ''), COALESCE(g.purpose, '')
+		FROM group_profiles g
+		LEFT JOIN chats c ON c.jid = g.jid
+		ORDER BY g.jid
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group profiles: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var jid, name, purpose string
+		if err := rows.Scan(&jid, &name, &purpose); err != nil {
+			return nil, fmt.Errorf("failed to scan group profile: %v", err)
+		}
+		if name == "" {
+			name = extractGroupIDFromJID(jid)
+		}
+
+		summary := fmt.Sprintf("%s is a WhatsApp group.", name)
+		if purpose != "" {
+			summary += fmt.Sprintf(" Purpose: %s.", purpose)
+		}
+
+		profiles = append(profiles, EntityProfile{
+			Key:     "group:" + jid,
+			JID:     jid,
+			Name:    name,
+			Summary: summary,
+			GroupID: graphitiGroupIDForChat(jid),
+		})
+	}
+
+	return profiles, nil
+}
+
+// contactEntityProfiles builds the profile of a contact in the default namespace, plus a copy in the namespace of
+// every group they are a member of so episode mentions in that group's graph resolve to the same person
+func contactEntityProfiles(jid, name, role, company string, groups []ContactGroup) []EntityProfile {
+	phoneNumber := strings.Split(jid, "@")[0]
+	summary := fmt.Sprintf("%s is a WhatsApp contact (phone %s).", name, phoneNumber)
+	if role != "" && company != "" {
+		summary += fmt.Sprintf(" Role: %s at %s.", role, company)
+	} else if role != "" {
+		summary += fmt.Sprintf(" Role: %s.", role)
+	} else if company != "" {
+		summary += fmt.Sprintf(" Company: %s.", company)
+	}
+
+	if len(groups) > 0 {
+		var groupNames []string
+		for i, group := range groups {
+			if i == maxSummaryGroups {
+				groupNames = append(groupNames, fmt.Sprintf("and %d more", len(groups)-maxSummaryGroups))
+				break
+			}
+			groupNames = append(groupNames, group.Name)
+		}
+		summary += fmt.Sprintf(" Member of the WhatsApp groups: %s.", strings.Join(groupNames, ", "))
+	}
+
+	profiles := []EntityProfile{{
+		Key:     "contact:" + jid,
+		JID:     jid,
+		Name:    name,
+		Summary: summary,
+		GroupID: getGraphitiGroupID(),
+	}}
+
+	seen := map[string]bool{getGraphitiGroupID(): true}
+	for _, group := range groups {
+		namespace := graphitiGroupIDForChat(group.JID)
+		if seen[namespace] {
+			continue
+		}
+		seen[namespace] = true
+
+		profiles = append(profiles, EntityProfile{
+			Key:     "contact:" + jid + "|" + group.JID,
+			JID:     jid,
+			Name:    name,
+			Summary: summary,
+			GroupID: namespace,
+		})
+	}
+
+	return profiles
+}
+
+// loadContactDirectory reads the named contacts from the whatsmeow store and adds the groups each was seen posting in
+func loadContactDirectory(db *sql.DB, logger waLog.Logger) (map[string]*ContactEntity, error) {
+	ctx := context.Background()
+
+	container, err := sqlstore.New(ctx, "sqlite3", "file:store/whatsapp.db?_foreign_keys=on", newLogger("Database", "ERROR"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to WhatsApp database: %v", err)
+	}
+
+	device, err := container.GetFirstDevice(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device: %v", err)
+	}
+
+	allContacts, err := device.Contacts.GetAllContacts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contacts: %v", err)
+	}
+
+	contacts := make(map[string]*ContactEntity)
+	for jid, info := range allContacts {
+		if jid.Server != types.DefaultUserServer {
+			continue
+		}
+
+		name := info.FullName
+		if name == "" {
+			name = info.FirstName
+		}
+		if name == "" {
+			name = info.PushName
+		}
+		if name == "" {
+			name = info.BusinessName
+		}
+		if name == "" {
+			continue
+		}
+
+		contacts[jid.String()] = &ContactEntity{JID: jid.String(), Name: name, Phone: jid.User}
+	}
+
+	// Group memberships, as seen from who posts in each group
+	rows, err := db.Query(`
+		SELECT DISTINCT m.sender, m.chat_jid, COALESCE(c.name, '')
+		FROM messages m
+		LEFT JOIN chats c ON c.jid = m.chat_jid
+		WHERE m.chat_jid LIKE '%@g.us' AND m.is_from_me = 0 AND m.sender != ''
+		ORDER BY c.name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group memberships: %v", err)
+	}
+	defer rows.Close()
+
+	memberships := 0
+	for rows.Next() {
+		var sender, groupJID, groupName string
+		if err := rows.Scan(&sender, &groupJID, &groupName); err != nil {
+			return nil, fmt.Errorf("failed to scan group membership: %v", err)
+		}
+		if !strings.Contains(sender, "@") {
+			sender = sender + "@" + types.DefaultUserServer
+		}
+
+		contact := contacts[sender]
+		if contact == nil {
+			continue
+		}
+		if groupName == "" {
+			groupName = extractGroupIDFromJID(groupJID)
+		}
+		contact.Groups = append(contact.Groups, ContactGroup{JID: groupJID, Name: groupName})
+		memberships++
+	}
+
+	logger.Infof("Found %d group memberships", memberships)
+	return contacts, nil
+}
+
+// hashEntityProfile returns a stable hash of the synced fields so unchanged profiles can be skipped
+func hashEntityProfile(profile EntityProfile) string {
+	sum := sha256.Sum256([]byte(profile.Name + "\x00" + profile.Summary + "\x00" + profile.GroupID))
+	return hex.EncodeToString(sum[:])
+}
@@ -11,6 +11,115 @@ if [ -n "$DAILY_SUMMARY_TIMEZONE" ]; then
     echo "Timezone set to: $TZ"
 fi
 
+# Create environment file for cron jobs
+cat > /app/daily-summary.env << EOF
+export DAILY_SUMMARY_ENABLED="$DAILY_SUMMARY_ENABLED"
+export DAILY_SUMMARY_TIME="$DAILY_SUMMARY_TIME"
+export DAILY_SUMMARY_GROUP_JID="$DAILY_SUMMARY_GROUP_JID"
+export DAILY_SUMMARY_SEND_TO="$DAILY_SUMMARY_SEND_TO"
+export DAILY_SUMMARY_TIMEZONE="$DAILY_SUMMARY_TIMEZONE"
+export DAILY_SUMMARY_MISSED_CALLS="$DAILY_SUMMARY_MISSED_CALLS"
+export DAILY_SUMMARY_FOLLOW_UPS="$DAILY_SUMMARY_FOLLOW_UPS"
+export FOLLOW_UP_HOURS="$FOLLOW_UP_HOURS"
+export FOLLOW_UP_DAYS="$FOLLOW_UP_DAYS"
+export CALENDAR_EVENTS="$CALENDAR_EVENTS"
+export CALENDAR_ICS_DIR="$CALENDAR_ICS_DIR"
+export CALDAV_URL="$CALDAV_URL"
+export CALDAV_USERNAME="$CALDAV_USERNAME"
+export CALDAV_PASSWORD="$CALDAV_PASSWORD"
+export CALDAV_TOKEN="$CALDAV_TOKEN"
+export GOOGLE_CLIENT_ID="$GOOGLE_CLIENT_ID"
+export GOOGLE_CLIENT_SECRET="$GOOGLE_CLIENT_SECRET"
+export GOOGLE_REFRESH_TOKEN="$GOOGLE_REFRESH_TOKEN"
+export TRANSCRIPT_FORMAT="$TRANSCRIPT_FORMAT"
+export CLAUDE_SERVER_URL="$CLAUDE_SERVER_URL"
+export CLAUDE_ALLOWED_TOOLS="$CLAUDE_ALLOWED_TOOLS"
+export CLAUDE_BACKEND="$CLAUDE_BACKEND"
+export LLM_PROVIDER="$LLM_PROVIDER"
+export LLM_FALLBACK_PROVIDERS="$LLM_FALLBACK_PROVIDERS"
+export ANTHROPIC_API_KEY="$ANTHROPIC_API_KEY"
+export ANTHROPIC_API_URL="$ANTHROPIC_API_URL"
+export ANTHROPIC_MODEL="$ANTHROPIC_MODEL"
+export ANTHROPIC_MAX_TOKENS="$ANTHROPIC_MAX_TOKENS"
+export OPENAI_API_URL="$OPENAI_API_URL"
+export OPENAI_MODEL="$OPENAI_MODEL"
+export OPENAI_API_KEY="$OPENAI_API_KEY"
+export OPENAI_MAX_TOKENS="$OPENAI_MAX_TOKENS"
+export LLM_STREAM="$LLM_STREAM"
+export LLM_IDLE_TIMEOUT="$LLM_IDLE_TIMEOUT"
+export LLM_TIMEOUT="$LLM_TIMEOUT"
+export LLM_AUDIT="$LLM_AUDIT"
+export LLM_AUDIT_RETENTION_DAYS="$LLM_AUDIT_RETENTION_DAYS"
+export LLM_SESSIONS="$LLM_SESSIONS"
+export LLM_STRUCTURED_OUTPUT="$LLM_STRUCTURED_OUTPUT"
+export PII_REDACTION="$PII_REDACTION"
+export LLM_MAX_IN_FLIGHT="$LLM_MAX_IN_FLIGHT"
+export LLM_MAX_REQUESTS_PER_MINUTE="$LLM_MAX_REQUESTS_PER_MINUTE"
+export GRAPHITI_API_URL="$GRAPHITI_API_URL"
+export GRAPHITI_GROUP_ID="$GRAPHITI_GROUP_ID"
+export GRAPHITI_PER_GROUP_NAMESPACES="$GRAPHITI_PER_GROUP_NAMESPACES"
+export GRAPHITI_GROUP_NAMESPACES="$GRAPHITI_GROUP_NAMESPACES"
+export ENTITY_SYNC_CONTACTS="$ENTITY_SYNC_CONTACTS"
+export ADMIN_CHAT_JID="$ADMIN_CHAT_JID"
+export RUNBOOK_FAILURE_THRESHOLD="$RUNBOOK_FAILURE_THRESHOLD"
+export ERROR_REPORT_WEBHOOK_URL="$ERROR_REPORT_WEBHOOK_URL"
+export SENTRY_DSN="$SENTRY_DSN"
+export ERROR_REPORT_TELEGRAM_BOT_TOKEN="$ERROR_REPORT_TELEGRAM_BOT_TOKEN"
+export ERROR_REPORT_TELEGRAM_CHAT_ID="$ERROR_REPORT_TELEGRAM_CHAT_ID"
+export ERROR_REPORT_WHATSAPP="$ERROR_REPORT_WHATSAPP"
+export ERROR_REPORT_COOLDOWN_MINUTES="$ERROR_REPORT_COOLDOWN_MINUTES"
+export CONFIG_PATH="$CONFIG_PATH"
+export BRIDGE_MESSAGE_PREFIX="$BRIDGE_MESSAGE_PREFIX"
+export KNOWLEDGE_SINK="$KNOWLEDGE_SINK"
+export VECTOR_DB_PATH="$VECTOR_DB_PATH"
+export EMBEDDING_API_URL="$EMBEDDING_API_URL"
+export EMBEDDING_MODEL="$EMBEDDING_MODEL"
+export EMBEDDING_API_KEY="$EMBEDDING_API_KEY"
+export NEO4J_URL="$NEO4J_URL"
+export NEO4J_DATABASE="$NEO4J_DATABASE"
+export NEO4J_USER="$NEO4J_USER"
+export NEO4J_PASSWORD="$NEO4J_PASSWORD"
+export WEEKLY_DIGEST_SEND_TO="$WEEKLY_DIGEST_SEND_TO"
+export WEEKLY_DIGEST_MAX_GROUPS="$WEEKLY_DIGEST_MAX_GROUPS"
+export WEEKLY_DIGEST_RECONNECT="$WEEKLY_DIGEST_RECONNECT"
+export WEEKLY_DIGEST_RECONNECT_WEEKS="$WEEKLY_DIGEST_RECONNECT_WEEKS"
+export WEEKLY_DIGEST_RECONNECT_MAX="$WEEKLY_DIGEST_RECONNECT_MAX"
+export WEEKLY_DIGEST_RECONNECT_SEGMENT="$WEEKLY_DIGEST_RECONNECT_SEGMENT"
+export UNANSWERED_DIGEST_SEND_TO="$UNANSWERED_DIGEST_SEND_TO"
+export UNANSWERED_DIGEST_HOURS="$UNANSWERED_DIGEST_HOURS"
+export UNANSWERED_DIGEST_DAYS="$UNANSWERED_DIGEST_DAYS"
+export DATE_REMINDERS_DAYS_AHEAD="$DATE_REMINDERS_DAYS_AHEAD"
+export QUARTERLY_REVIEW_SEND_TO="$QUARTERLY_REVIEW_SEND_TO"
+export BRIDGE_API_AUTH="$BRIDGE_API_AUTH"
+export BRIDGE_API_TOKEN="$BRIDGE_API_TOKEN"
+export BRIDGE_API_URL="$BRIDGE_API_URL"
+export BRIDGE_SEND_MODE="$BRIDGE_SEND_MODE"
+export READ_ONLY="$READ_ONLY"
+export SEND_RATE_PER_MINUTE="$SEND_RATE_PER_MINUTE"
+export SEND_JITTER_SECONDS="$SEND_JITTER_SECONDS"
+export SEND_DAILY_CAP_PER_CHAT="$SEND_DAILY_CAP_PER_CHAT"
+export MESSAGES_DB_KEY="$MESSAGES_DB_KEY"
+export MESSAGES_DB_KEYFILE="$MESSAGES_DB_KEYFILE"
+export ARCHIVE_AFTER_DAYS="$ARCHIVE_AFTER_DAYS"
+export ARCHIVE_S3_ENDPOINT="$ARCHIVE_S3_ENDPOINT"
+export ARCHIVE_S3_BUCKET="$ARCHIVE_S3_BUCKET"
+export ARCHIVE_S3_REGION="$ARCHIVE_S3_REGION"
+export ARCHIVE_S3_ACCESS_KEY="$ARCHIVE_S3_ACCESS_KEY"
+export ARCHIVE_S3_SECRET_KEY="$ARCHIVE_S3_SECRET_KEY"
+export ARCHIVE_S3_PREFIX="$ARCHIVE_S3_PREFIX"
+export LOG_LEVEL="$LOG_LEVEL"
+export LOG_LEVELS="$LOG_LEVELS"
+export LOG_FILE="$LOG_FILE"
+export LOG_MAX_SIZE_MB="$LOG_MAX_SIZE_MB"
+export LOG_ROTATE_DAILY="$LOG_ROTATE_DAILY"
+export LOG_MAX_FILES="$LOG_MAX_FILES"
+export LOG_ROTATE_TOOL_LOGS="$LOG_ROTATE_TOOL_LOGS"
+export TZ="$TZ"
+EOF
+
+# Cron jobs are collected here and installed once at the end
+: > /tmp/crontab
+
 # Check if daily summary is enabled
 if [ "$DAILY_SUMMARY_ENABLED" = "true" ]; then
     echo "Daily summary is enabled"
@@ -26,36 +135,12 @@ if [ "$DAILY_SUMMARY_ENABLED" = "true" ]; then
     if [ "$HOUR" -ge 0 ] && [ "$HOUR" -le 23 ] && [ "$MINUTE" -ge 0 ] && [ "$MINUTE" -le 59 ]; then
         echo "Daily summary scheduled for: $HOUR:$MINUTE"
         
-        # Create environment file for cron job
-        cat > /app/daily-summary.env << EOF
-export DAILY_SUMMARY_ENABLED="$DAILY_SUMMARY_ENABLED"
-export DAILY_SUMMARY_TIME="$DAILY_SUMMARY_TIME"
-export DAILY_SUMMARY_GROUP_JID="$DAILY_SUMMARY_GROUP_JID"
-export DAILY_SUMMARY_SEND_TO="$DAILY_SUMMARY_SEND_TO"
-export DAILY_SUMMARY_TIMEZONE="$DAILY_SUMMARY_TIMEZONE"
-export CLAUDE_SERVER_URL="$CLAUDE_SERVER_URL"
-export CLAUDE_ALLOWED_TOOLS="$CLAUDE_ALLOWED_TOOLS"
-export TZ="$TZ"
-EOF
-        
         # Create cron job that sources environment and runs as whatsapp user
-        echo "$MINUTE $HOUR * * * cd /app && echo \"[$(date)] Starting daily summary...\" >> /app/store/daily-summary.log && . ./daily-summary.env && su whatsapp -c './daily-summary' >> /app/store/daily-summary.log 2>&1 && echo \"[$(date)] Daily summary completed\" >> /app/store/daily-summary.log" > /tmp/crontab
-        
-        # Install the crontab
-        crontab /tmp/crontab
-        
-        # Show installed crontab for debugging
-        echo "Installed cron job:"
-        crontab -l
+        echo "$MINUTE $HOUR * * * cd /app && echo \"[$(date)] Starting daily summary...\" >> /app/store/daily-summary.log && . ./daily-summary.env && su whatsapp -c './daily-summary' >> /app/store/daily-summary.log 2>&1 && echo \"[$(date)] Daily summary completed\" >> /app/store/daily-summary.log" >> /tmp/crontab
         
         # Ensure log files exist and have proper permissions
         touch /app/store/daily-summary.log /app/store/cron.log
         chown whatsapp:whatsapp /app/store/daily-summary.log
-        
-        # Start cron daemon in background
-        crond -b -l 2 -L /app/store/cron.log
-        
-        echo "Cron daemon started with environment variables"
     else
         echo "Warning: Invalid time format for DAILY_SUMMARY_TIME ($DAILY_TIME). Expected HH:MM format."
         echo "Daily summary will be disabled for this session."
@@ -64,6 +149,106 @@ else
     echo "Daily summary is disabled"
 fi
 
+# Check if Graphiti entity sync is enabled
+if [ "$ENTITY_SYNC_ENABLED" = "true" ]; then
+    # Default: every 6 hours
+    ENTITY_SYNC_SCHEDULE="${ENTITY_SYNC_SCHEDULE:-0 */6 * * *}"
+    echo "Entity sync scheduled: $ENTITY_SYNC_SCHEDULE"
+
+    echo "$ENTITY_SYNC_SCHEDULE cd /app && . ./daily-summary.env && su whatsapp -c './entity-sync' >> /app/store/entity-sync.log 2>&1" >> /tmp/crontab
+
+    touch /app/store/entity-sync.log
+    chown whatsapp:whatsapp /app/store/entity-sync.log
+else
+    echo "Entity sync is disabled"
+fi
+
+# Check if the weekly digest is enabled
+if [ "$WEEKLY_DIGEST_ENABLED" = "true" ]; then
+    # Default: Mondays at 09:00
+    WEEKLY_DIGEST_SCHEDULE="${WEEKLY_DIGEST_SCHEDULE:-0 9 * * 1}"
+    echo "Weekly digest scheduled: $WEEKLY_DIGEST_SCHEDULE"
+
+    echo "$WEEKLY_DIGEST_SCHEDULE cd /app && . ./daily-summary.env && su whatsapp -c './weekly-digest' >> /app/store/weekly-digest.log 2>&1" >> /tmp/crontab
+
+    touch /app/store/weekly-digest.log
+    chown whatsapp:whatsapp /app/store/weekly-digest.log
+else
+    echo "Weekly digest is disabled"
+fi
+
+# Check if the unanswered digest is enabled
+if [ "$UNANSWERED_DIGEST_ENABLED" = "true" ]; then
+    # Default: every day at 09:00
+    UNANSWERED_DIGEST_SCHEDULE="${UNANSWERED_DIGEST_SCHEDULE:-0 9 * * *}"
+    echo "Unanswered digest scheduled: $UNANSWERED_DIGEST_SCHEDULE"
+
+    echo "$UNANSWERED_DIGEST_SCHEDULE cd /app && . ./daily-summary.env && su whatsapp -c './unanswered-digest' >> /app/store/unanswered-digest.log 2>&1" >> /tmp/crontab
+
+    touch /app/store/unanswered-digest.log
+    chown whatsapp:whatsapp /app/store/unanswered-digest.log
+else
+    echo "Unanswered digest is disabled"
+fi
+
+# Check if the date reminders are enabled
+if [ "$DATE_REMINDERS_ENABLED" = "true" ]; then
+    # Default: every day at 08:00
+    DATE_REMINDERS_SCHEDULE="${DATE_REMINDERS_SCHEDULE:-0 8 * * *}"
+    echo "Date reminders scheduled: $DATE_REMINDERS_SCHEDULE"
+
+    echo "$DATE_REMINDERS_SCHEDULE cd /app && . ./daily-summary.env && su whatsapp -c './dates remind' >> /app/store/date-reminders.log 2>&1" >> /tmp/crontab
+
+    touch /app/store/date-reminders.log
+    chown whatsapp:whatsapp /app/store/date-reminders.log
+else
+    echo "Date reminders are disabled"
+fi
+
+# Check if the quarterly review is enabled
+if [ "$QUARTERLY_REVIEW_ENABLED" = "true" ]; then
+    # Default: the first day of each quarter at 08:00, reviewing the quarter that just ended
+    QUARTERLY_REVIEW_SCHEDULE="${QUARTERLY_REVIEW_SCHEDULE:-0 8 1 1,4,7,10 *}"
+    echo "Quarterly review scheduled: $QUARTERLY_REVIEW_SCHEDULE"
+
+    echo "$QUARTERLY_REVIEW_SCHEDULE cd /app && . ./daily-summary.env && su whatsapp -c './quarterly-review' >> /app/store/quarterly-review.log 2>&1" >> /tmp/crontab
+
+    touch /app/store/quarterly-review.log
+    chown whatsapp:whatsapp /app/store/quarterly-review.log
+else
+    echo "Quarterly review is disabled"
+fi
+
+# Check if cold-storage archival is enabled
+if [ "$ARCHIVE_ENABLED" = "true" ]; then
+    # Default: Sundays at 03:00
+    ARCHIVE_SCHEDULE="${ARCHIVE_SCHEDULE:-0 3 * * 0}"
+    echo "Message archival scheduled: $ARCHIVE_SCHEDULE"
+
+    echo "$ARCHIVE_SCHEDULE cd /app && . ./daily-summary.env && su whatsapp -c './archive' >> /app/store/archive.log 2>&1" >> /tmp/crontab
+
+    touch /app/store/archive.log
+    chown whatsapp:whatsapp /app/store/archive.log
+else
+    echo "Message archival is disabled"
+fi
+
+# Install the crontab and start cron if any job was scheduled
+if [ -s /tmp/crontab ]; then
+    crontab /tmp/crontab
+
+    # Show installed crontab for debugging
+    echo "Installed cron jobs:"
+    crontab -l
+
+    touch /app/store/cron.log
+
+    # Start cron daemon in background
+    crond -b -l 2 -L /app/store/cron.log
+
+    echo "Cron daemon started with environment variables"
+fi
+
 # Ensure all log files exist with proper permissions
 touch /app/store/daily-summary.log /app/store/cron.log
 chown whatsapp:whatsapp /app/store/daily-summary.log /app/store/cron.log
@@ -75,7 +260,16 @@ echo "Time: ${DAILY_SUMMARY_TIME:-22:00}"
 echo "Group JID: ${DAILY_SUMMARY_GROUP_JID}"
 echo "Send To: ${DAILY_SUMMARY_SEND_TO:-self}"
 echo "Timezone: ${DAILY_SUMMARY_TIMEZONE:-America/Sao_Paulo}"
-echo "Claude Server: ${CLAUDE_SERVER_URL:-http://host.docker.internal:8888/claude}"
+if [ "$LLM_PROVIDER" = "anthropic" ] || [ -z "$LLM_PROVIDER" -a "$CLAUDE_BACKEND" = "api" ]; then
+    echo "LLM: Anthropic API (${ANTHROPIC_MODEL:-claude-sonnet-4-5})"
+elif [ "$LLM_PROVIDER" = "openai" ]; then
+    echo "LLM: ${OPENAI_MODEL:-llama3.1} at ${OPENAI_API_URL:-http://host.docker.internal:11434/v1}"
+else
+    echo "Claude Server: ${CLAUDE_SERVER_URL:-http://host.docker.internal:8888/claude}"
+fi
+if [ -n "$LLM_FALLBACK_PROVIDERS" ]; then
+    echo "LLM fallbacks: ${LLM_FALLBACK_PROVIDERS}"
+fi
 echo "==================================="
 
 # Check if prompt template exists
@@ -87,4 +281,4 @@ fi
 
 # Start the WhatsApp bridge as whatsapp user (this will run in foreground)
 echo "Starting WhatsApp bridge..."
-exec su whatsapp -c './whatsapp-bridge'
\ No newline at end of file
+exec su whatsapp -c 'exec ./whatsapp-bridge'
\ No newline at end of file
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// EpisodeRecord is a Graphiti episode created by this bridge, kept locally with the messages it was built
+// from so graph facts can be traced back to WhatsApp and episodes can be found and removed later
+type EpisodeRecord struct {
+	UUID            string
+	GraphitiGroupID string
+	GroupJID        string
+	Date            string
+	Topic           string
+	Name            string
+	Sink            string // knowledge sink the episode was stored in
+	ImportRun       string // historical import run that created the episode, if any
+
+	// Provenance: the messages the episode was built from
+	MessageIDs     []string
+	FirstMessageID string
+	LastMessageID  string
+	FirstMessageAt time.Time
+	LastMessageAt  time.Time
+}
+
+// ensureEpisodeTables creates the tables tracking Graphiti episodes and their source messages if they don't exist
+func ensureEpisodeTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS graphiti_episodes (
+			uuid TEXT PRIMARY KEY,
+			graphiti_group_id TEXT,
+			group_jid TEXT,
+			date TEXT,
+			topic TEXT,
+			name TEXT,
+			created_at TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_graphiti_episodes_group_date ON graphiti_episodes (group_jid, date);
+
+		CREATE TABLE IF NOT EXISTS graphiti_episode_messages (
+			episode_uuid TEXT,
+			message_id TEXT,
+			chat_jid TEXT,
+			position INTEGER,
+			PRIMARY KEY (episode_uuid, message_id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_graphiti_episode_messages_message ON graphiti_episode_messages (message_id, chat_jid);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Message range columns added after the initial table
+	for column, definition := range map[string]string{
+		"message_count":    "INTEGER DEFAULT 0",
+		"first_message_id": "TEXT",
+		"last_message_id":  "TEXT",
+		"first_message_at": "TIMESTAMP",
+		"last_message_at":  "TIMESTAMP",
+		"sink":             "TEXT DEFAULT 'graphiti'",
+		"import_run":       "TEXT DEFAULT ''",
+		"rolled_back_at":   "TIMESTAMP",
+	} {
+		if err := addColumnIfMissing(db, "graphiti_episodes", column, definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type importRunKey struct{}
+
+// withImportRun records the historical import run the episodes created with ctx belong to
+func withImportRun(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, importRunKey{}, runID)
+}
+
+// importRunFromContext returns the import run set on ctx, or "" outside of imports
+func importRunFromContext(ctx context.Context) string {
+	runID, _ := ctx.Value(importRunKey{}).(string)
+	return runID
+}
+
+// recordEpisode stores a created Graphiti episode and links it to its source messages
+func recordEpisode(db *sql.DB, episode EpisodeRecord) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT OR REPLACE INTO graphiti_episodes
+		(uuid, graphiti_group_id, group_jid, date, topic, name, created_at,
+		message_count, first_message_id, last_message_id, first_message_at, last_message_at, sink, import_run)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		episode.UUID, episode.GraphitiGroupID, episode.GroupJID, episode.Date, episode.Topic, episode.Name, time.Now(),
+		len(episode.MessageIDs), episode.FirstMessageID, episode.LastMessageID, episode.FirstMessageAt, episode.LastMessageAt, episode.Sink,
+		episode.ImportRun,
+	)
+	if err != nil {
+		return err
+	}
+
+	for position, messageID := range episode.MessageIDs {
+		_, err := tx.Exec(
+			"INSERT OR REPLACE INTO graphiti_episode_messages (episode_uuid, message_id, chat_jid, position) VALUES (?, ?, ?, ?)",
+			episode.UUID, messageID, episode.GroupJID, position,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// listEpisodes returns the recorded episodes of a group between two dates (YYYY-MM-DD, inclusive)
+func listEpisodes(db *sql.DB, groupJID, startDate, endDate string) ([]EpisodeRecord, error) {
+	rows, err := db.Query(`
+		SELECT uuid, graphiti_group_id, group_jid, date, topic, name,
+			COALESCE(first_message_id, ''), COALESCE(last_message_id, ''), COALESCE(sink, 'graphiti')
+		FROM graphiti_episodes
+		WHERE group_jid = ? AND date >= ? AND date <= ?
+		ORDER BY date, created_at
+	`, groupJID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var episodes []EpisodeRecord
+	for rows.Next() {
+		var episode EpisodeRecord
+		err := rows.Scan(&episode.UUID, &episode.GraphitiGroupID, &episode.GroupJID, &episode.Date, &episode.Topic, &episode.Name,
+			&episode.FirstMessageID, &episode.LastMessageID, &episode.Sink)
+		if err != nil {
+			return nil, err
+		}
+		episodes = append(episodes, episode)
+	}
+	return episodes, nil
+}
+
+// deleteEpisodeRecord removes a recorded episode and its message links
+func deleteEpisodeRecord(db *sql.DB, uuid string) error {
+	if _, err := db.Exec("DELETE FROM graphiti_episode_messages WHERE episode_uuid = ?", uuid); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM graphiti_episodes WHERE uuid = ?", uuid)
+	return err
+}
@@ -0,0 +1,751 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	eraseContact = flag.String("contact", "", "Phone number or JID of the contact to erase")
+	eraseChat    = flag.String("chat", "", "JID, phone number or group name of the chat to erase")
+	eraseDryRun  = flag.Bool("dry-run", false, "Only report what would be erased")
+)
+
+// Tables whose rows belong to a chat, by the column holding the chat's JID
+var eraseChatTables = []struct{ table, column string }{
+	{"reactions", "chat_jid"},
+	{"message_edits", "chat_jid"},
+	{"message_receipts", "chat_jid"},
+	{"poll_votes", "chat_jid"},
+	{"polls", "chat_jid"},
+	{"raw_messages", "chat_jid"},
+	{"message_threads", "chat_jid"},
+	{"message_translations", "chat_jid"},
+	{"message_flags", "chat_jid"},
+	{"media_files", "chat_jid"},
+	{"message_stats_daily", "chat_jid"},
+	{"annotations", "chat_jid"},
+	{"automated_messages", "chat_jid"},
+	{"calendar_events", "chat_jid"},
+	{"calls", "chat_jid"},
+	{"catch_up_replies", "chat_jid"},
+	{"reminders", "chat_jid"},
+	{"chat_ephemeral_timers", "chat_jid"},
+	{"chat_settings", "chat_jid"},
+	{"chat_state", "chat_jid"},
+	{"webhook_deliveries", "chat_jid"},
+	{"import_runs", "group_jid"},
+	{"outbox", "recipient"},
+	{"send_audit", "recipient"},
+	{"campaign_deliveries", "recipient"},
+	{"group_pins", "jid"},
+	{"group_profiles", "jid"},
+	{"broadcast_list_members", "list_jid"},
+	{"broadcast_lists", "jid"},
+	{"chats", "jid"},
+}
+
+// Tables whose rows belong to a contact wherever they appear, by the column holding their JID or phone number,
+// and the column holding the chat if there is one
+var eraseContactTables = []struct{ table, column, chatColumn string }{
+	{"reactions", "reactor", "chat_jid"},
+	{"poll_votes", "voter", "chat_jid"},
+	{"message_receipts", "recipient", "chat_jid"},
+	{"message_stats_daily", "sender", "chat_jid"},
+	{"calls", "caller", "chat_jid"},
+	{"contacts", "jid", ""},
+	{"contact_aliases", "jid", ""},
+	{"contact_presence", "jid", ""},
+	{"contact_tags", "jid", ""},
+	{"contact_dates", "contact_jid", ""},
+	{"broadcast_list_members", "member_jid", ""},
+}
+
+// erasure removes everything stored about a chat, or about a contact: their direct chats and what they left in
+// other chats. Dry runs take the same steps and only count, so they report what the erasure would remove.
+type erasure struct {
+	dryRun   bool
+	chats    []string  // chats erased whole
+	people   []string  // the contact's phone number, LID and their JIDs; empty when erasing a chat
+	shared   *sql.DB   // messages.db
+	dbs      []*sql.DB // messages.db, and DIRECT_MESSAGES_DB when direct chats are kept apart
+	whatsapp *sql.DB   // the whatsmeow store, when there is one
+	report   []erasedItem
+	notes    []string
+}
+
+// erasedItem is a line of the erasure report
+type erasedItem struct {
+	what  string
+	count int
+}
+
+func main() {
+	flag.Parse()
+
+	if err := runErase(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runErase erases the chat or contact given on the command line and prints what was (or would be) removed
+func runErase() error {
+	if (*eraseContact == "") == (*eraseChat == "") {
+		return fmt.Errorf("give exactly one of --contact and --chat")
+	}
+
+	shared, err := openSharedMessagesDB()
+	if err != nil {
+		return err
+	}
+	if err := migrateSchema(shared); err != nil {
+		return err
+	}
+	e := &erasure{dryRun: *eraseDryRun, shared: shared, dbs: []*sql.DB{shared}}
+
+	if path := os.Getenv("DIRECT_MESSAGES_DB"); path != "" {
+		direct, err := sql.Open(messagesDBDriver(), "file:"+path+"?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000")
+		if err != nil {
+			return fmt.Errorf("failed to open direct messages database: %v", err)
+		}
+		defer direct.Close()
+		e.dbs = append(e.dbs, direct)
+	}
+
+	// Opening a missing SQLite file would create it
+	if _, err := os.Stat("store/whatsapp.db"); err == nil {
+		if e.whatsapp, err = sql.Open("sqlite3", "file:store/whatsapp.db?_foreign_keys=on&_busy_timeout=5000"); err != nil {
+			return fmt.Errorf("failed to open WhatsApp store: %v", err)
+		}
+		defer e.whatsapp.Close()
+	}
+
+	subject := ""
+	if *eraseChat != "" {
+		chatJID, err := resolveChatArgument(*eraseChat)
+		if err != nil {
+			return err
+		}
+		e.chats = []string{chatJID}
+		subject = "chat " + chatJID
+	} else {
+		if e.people, err = e.contactIdentifiers(*eraseContact); err != nil {
+			return err
+		}
+		for _, id := range e.people {
+			if strings.Contains(id, "@") {
+				e.chats = append(e.chats, id)
+			}
+		}
+		subject = "contact " + e.chats[0]
+	}
+
+	if err := e.run(); err != nil {
+		return err
+	}
+
+	if e.dryRun {
+		fmt.Printf("Would erase %s:\n\n", subject)
+	} else {
+		fmt.Printf("Erased %s:\n\n", subject)
+	}
+	if len(e.report) == 0 {
+		fmt.Println("Nothing stored")
+	} else {
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "WHAT\tCOUNT")
+		for _, item := range e.report {
+			fmt.Fprintf(writer, "%s\t%d\n", item.what, item.count)
+		}
+		writer.Flush()
+	}
+	for _, note := range e.notes {
+		fmt.Printf("\n%s\n", note)
+	}
+	return nil
+}
+
+// contactIdentifiers returns the phone number and LID a contact is stored under, each bare and as a JID.
+// Messages keep the sender's phone number or LID, depending on how WhatsApp addressed them.
+func (e *erasure) contactIdentifiers(contact string) ([]string, error) {
+	contact = strings.TrimSpace(contact)
+	user, server, _ := strings.Cut(contact, "@")
+	phone, lid := "", ""
+	switch {
+	case server == "lid":
+		lid = user
+		if e.whatsapp != nil {
+			e.whatsapp.QueryRow("SELECT pn FROM whatsmeow_lid_map WHERE lid = ?", lid).Scan(&phone)
+		}
+	case server == "" || server == "s.whatsapp.net":
+		phone = recipientConfigKey(user)
+		if e.whatsapp != nil {
+			e.whatsapp.QueryRow("SELECT lid FROM whatsmeow_lid_map WHERE pn = ?", phone).Scan(&lid)
+		}
+	default:
+		return nil, fmt.Errorf("%s is not a contact; use --chat to erase a group", contact)
+	}
+	if phone == "" && lid == "" {
+		return nil, fmt.Errorf("invalid contact %q", contact)
+	}
+
+	var ids []string
+	if phone != "" {
+		ids = append(ids, phone+"@s.whatsapp.net", phone)
+	}
+	if lid != "" {
+		ids = append(ids, lid+"@lid", lid)
+	}
+	return ids, nil
+}
+
+// run takes the erasure's steps. What is only reachable through the messages (episodes, media files) is looked
+// up before the messages go, and knowledge sinks are cleared before the local records of what they hold.
+func (e *erasure) run() error {
+	episodes, err := e.findEpisodes()
+	if err != nil {
+		return fmt.Errorf("failed to find knowledge episodes: %v", err)
+	}
+	mediaPaths, err := e.findMediaPaths()
+	if err != nil {
+		return fmt.Errorf("failed to find media files: %v", err)
+	}
+
+	if err := e.eraseKnowledge(episodes); err != nil {
+		return err
+	}
+	if err := e.eraseArchive(); err != nil {
+		return err
+	}
+	if err := e.eraseRows(); err != nil {
+		return err
+	}
+	return e.eraseFiles(mediaPaths)
+}
+
+// add counts erased items in the report
+func (e *erasure) add(what string, count int) {
+	if count == 0 {
+		return
+	}
+	for i := range e.report {
+		if e.report[i].what == what {
+			e.report[i].count += count
+			return
+		}
+	}
+	e.report = append(e.report, erasedItem{what, count})
+}
+
+// inList returns an "IN (?, ...)" clause with its arguments
+func inList(values []string) (string, []interface{}) {
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return "IN (?" + strings.Repeat(", ?", len(values)-1) + ")", args
+}
+
+// isMissingSchema reports whether an error comes from a table or column a database doesn't have
+func isMissingSchema(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "no such table") || strings.Contains(err.Error(), "no such column"))
+}
+
+// exec applies a statement ("DELETE FROM t" or "UPDATE t SET ...") to a table's rows matching a condition in a
+// database, counting them first. Databases without the table have nothing to erase.
+func (e *erasure) exec(db *sql.DB, what, statement, table, condition string, args []interface{}) error {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE "+condition, args...).Scan(&count)
+	if isMissingSchema(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to count %s: %v", table, err)
+	}
+	if count == 0 {
+		return nil
+	}
+	if !e.dryRun {
+		if _, err := db.Exec(statement+" WHERE "+condition, args...); err != nil {
+			return fmt.Errorf("failed to erase %s: %v", table, err)
+		}
+	}
+	e.add(what, count)
+	return nil
+}
+
+// findEpisodes returns the knowledge episodes built from the erased chats, or from the contact's messages
+func (e *erasure) findEpisodes() ([]EpisodeRecord, error) {
+	chatIn, args := inList(e.chats)
+	condition := "group_jid " + chatIn
+	if len(e.people) > 0 {
+		peopleIn, peopleArgs := inList(e.people)
+		condition += ` OR uuid IN (
+			SELECT l.episode_uuid FROM graphiti_episode_messages l
+			JOIN messages m ON m.id = l.message_id AND m.chat_jid = l.chat_jid
+			WHERE m.sender ` + peopleIn + `)`
+		args = append(args, peopleArgs...)
+	}
+	rows, err := e.shared.Query(`
+		SELECT uuid, COALESCE(graphiti_group_id, ''), COALESCE(group_jid, ''), COALESCE(date, ''), COALESCE(name, ''),
+			COALESCE(sink, 'graphiti')
+		FROM graphiti_episodes
+		WHERE `+condition+`
+		ORDER BY group_jid, date
+	`, args...)
+	if isMissingSchema(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var episodes []EpisodeRecord
+	for rows.Next() {
+		var episode EpisodeRecord
+		if err := rows.Scan(&episode.UUID, &episode.GraphitiGroupID, &episode.GroupJID, &episode.Date, &episode.Name, &episode.Sink); err != nil {
+			return nil, err
+		}
+		episodes = append(episodes, episode)
+	}
+	return episodes, rows.Err()
+}
+
+// findMediaPaths returns the downloaded media of the erased chats, and of the messages the contact sent
+func (e *erasure) findMediaPaths() ([]string, error) {
+	chatIn, args := inList(e.chats)
+	query := "SELECT local_path FROM media_files WHERE local_path != '' AND (chat_jid " + chatIn
+	if len(e.people) > 0 {
+		peopleIn, peopleArgs := inList(e.people)
+		query += " OR (message_id, chat_jid) IN (SELECT id, chat_jid FROM messages WHERE sender " + peopleIn + ")"
+		args = append(args, peopleArgs...)
+	}
+	query += ")"
+
+	var paths []string
+	for _, db := range e.dbs {
+		rows, err := db.Query(query, args...)
+		if isMissingSchema(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var path string
+			if err := rows.Scan(&path); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			paths = append(paths, path)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// eraseKnowledge deletes the episodes from the knowledge sinks they were stored in, drops the Graphiti
+// namespaces dedicated to the erased chats and blanks the entity nodes synced for them
+func (e *erasure) eraseKnowledge(episodes []EpisodeRecord) error {
+	rebuild := make(map[string]bool)
+	graphitiNamespaces := make(map[string]bool)
+	for _, episode := range episodes {
+		if episode.Sink == "graphiti" {
+			graphitiNamespaces[episode.GraphitiGroupID] = true
+		}
+		if !slices.Contains(e.chats, episode.GroupJID) {
+			rebuild[episode.GroupJID+" "+episode.Date] = true
+		}
+		if !e.dryRun {
+			// Episodes are deleted from the sink they were stored in, even if KNOWLEDGE_SINK changed since
+			sink, err := newKnowledgeSink(episode.Sink)
+			if err != nil {
+				return err
+			}
+			if err := sink.DeleteEpisode(episode.UUID); err != nil {
+				return fmt.Errorf("failed to delete episode %s from %s: %v", episode.UUID, sink.Name(), err)
+			}
+			if err := deleteEpisodeRecord(e.shared, episode.UUID); err != nil {
+				return fmt.Errorf("failed to delete episode record %s: %v", episode.UUID, err)
+			}
+		}
+		e.add("knowledge episodes", 1)
+	}
+	if len(rebuild) > 0 {
+		days := make([]string, 0, len(rebuild))
+		for day := range rebuild {
+			days = append(days, day)
+		}
+		slices.Sort(days)
+		e.notes = append(e.notes, "Episodes of other chats held the contact's messages. Rebuild these days with reingest "+
+			"(--group-jid, --start-date and --end-date):\n  "+strings.Join(days, "\n  "))
+	}
+
+	// Entity nodes synced by entity-sync for the erased chats and the contact
+	keys := make([]string, 0, len(e.chats)+len(e.people))
+	for _, chat := range e.chats {
+		keys = append(keys, "group:"+chat)
+	}
+	condition := "entity_key IN (?" + strings.Repeat(", ?", len(keys)-1) + ")"
+	var args []interface{}
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	for _, id := range e.people {
+		if strings.Contains(id, "@") {
+			condition += " OR entity_key = ? OR entity_key LIKE ?"
+			args = append(args, "contact:"+id, "contact:"+id+"|%")
+		}
+	}
+	rows, err := e.shared.Query("SELECT entity_key, uuid FROM graphiti_entity_sync WHERE "+condition, args...)
+	if err != nil && !isMissingSchema(err) {
+		return fmt.Errorf("failed to read entity sync state: %v", err)
+	}
+	type syncedNode struct{ key, uuid, namespace string }
+	var nodes []syncedNode
+	if err == nil {
+		for rows.Next() {
+			var node syncedNode
+			if err := rows.Scan(&node.key, &node.uuid); err != nil {
+				rows.Close()
+				return err
+			}
+			node.namespace = getGraphitiGroupID()
+			if jid, ok := strings.CutPrefix(node.key, "group:"); ok {
+				node.namespace = graphitiGroupIDForChat(jid)
+			} else if _, group, ok := strings.Cut(node.key, "|"); ok {
+				node.namespace = graphitiGroupIDForChat(group)
+			}
+			graphitiNamespaces[node.namespace] = graphitiNamespaces[node.namespace] || strings.HasPrefix(node.key, "group:")
+			nodes = append(nodes, node)
+		}
+		rows.Close()
+	}
+
+	// A chat's own namespace (GRAPHITI_PER_GROUP_NAMESPACES) only holds what was built from that chat
+	dropped := make(map[string]bool)
+	for _, chat := range e.chats {
+		namespace := graphitiGroupIDForChat(chat)
+		if namespace == getGraphitiGroupID() || !graphitiNamespaces[namespace] {
+			continue
+		}
+		if !e.dryRun {
+			err := callGraphitiAPI(http.MethodDelete, "/group/"+namespace, nil, nil)
+			if err != nil && !strings.Contains(err.Error(), "HTTP 404") {
+				return fmt.Errorf("failed to drop Graphiti namespace %s: %v", namespace, err)
+			}
+		}
+		dropped[namespace] = true
+		e.add("Graphiti namespaces", 1)
+	}
+
+	// Graphiti can't delete a single entity node: nodes in other namespaces are overwritten with a blank one
+	for _, node := range nodes {
+		if !e.dryRun {
+			if !dropped[node.namespace] {
+				err := upsertGraphitiEntityNode(GraphitiEntityNodeRequest{UUID: node.uuid, GroupID: node.namespace, Name: "Erased", Summary: ""})
+				if err != nil {
+					return fmt.Errorf("failed to blank Graphiti entity %s: %v", node.key, err)
+				}
+			}
+			if _, err := e.shared.Exec("DELETE FROM graphiti_entity_sync WHERE entity_key = ?", node.key); err != nil {
+				return fmt.Errorf("failed to delete entity sync state: %v", err)
+			}
+		}
+		if !dropped[node.namespace] {
+			e.add("Graphiti entity nodes (blanked)", 1)
+		}
+	}
+	return nil
+}
+
+// eraseArchive deletes the archive files of the erased chats, and rewrites the other chats' files without the
+// contact's messages
+func (e *erasure) eraseArchive() error {
+	files, err := findArchiveFiles(e.shared, nil, time.Time{}, time.Time{})
+	if err != nil {
+		return fmt.Errorf("failed to read the archive manifest: %v", err)
+	}
+	objectStore := objectStoreFromEnv()
+	for _, file := range files {
+		if slices.Contains(e.chats, file.ChatJID) {
+			if err := e.removeArchiveFile(file, objectStore); err != nil {
+				return err
+			}
+			e.add("archived messages", file.Messages)
+			continue
+		}
+		if len(e.people) == 0 {
+			continue
+		}
+
+		messages, err := readArchiveFile(file)
+		if err != nil {
+			return err
+		}
+		var kept []ArchivedMessage
+		for _, message := range messages {
+			if !slices.Contains(e.people, message.Sender) {
+				kept = append(kept, message)
+			}
+		}
+		if len(kept) == len(messages) {
+			continue
+		}
+		e.add("archived messages", len(messages)-len(kept))
+		if len(kept) == 0 {
+			err = e.removeArchiveFile(file, objectStore)
+		} else {
+			err = e.rewriteArchiveFile(file, kept, objectStore)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeArchiveFile deletes an archive file, its uploaded copy and its manifest entry
+func (e *erasure) removeArchiveFile(file ArchiveFile, objectStore *ObjectStore) error {
+	e.add("archive files", 1)
+	if e.dryRun {
+		return nil
+	}
+	if file.ObjectURL != "" {
+		if objectStore == nil {
+			return fmt.Errorf("%s was archived to %s, but ARCHIVE_S3_BUCKET isn't set", file.Path, file.ObjectURL)
+		}
+		if err := objectStore.Delete(file.ObjectURL); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(file.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete %s: %v", file.Path, err)
+	}
+	if _, err := e.shared.Exec("DELETE FROM message_archive WHERE id = ?", file.ID); err != nil {
+		return fmt.Errorf("failed to delete %s from the archive manifest: %v", file.Path, err)
+	}
+	return nil
+}
+
+// rewriteArchiveFile replaces an archive file and its uploaded copy with the messages kept, updating its manifest entry
+func (e *erasure) rewriteArchiveFile(file ArchiveFile, kept []ArchivedMessage, objectStore *ObjectStore) error {
+	e.add("archive files (rewritten)", 1)
+	if e.dryRun {
+		return nil
+	}
+
+	rows := make([][]interface{}, len(kept))
+	for i, message := range kept {
+		rows[i] = message.parquetRow()
+	}
+	tmpPath := file.Path + ".tmp"
+	if err := writeParquetFile(tmpPath, archiveMessageColumns, rows); err != nil {
+		return fmt.Errorf("failed to write %s: %v", tmpPath, err)
+	}
+	data, err := os.ReadFile(tmpPath)
+	if err != nil {
+		return err
+	}
+	if file.ObjectURL != "" {
+		if objectStore == nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("%s was archived to %s, but ARCHIVE_S3_BUCKET isn't set", file.Path, file.ObjectURL)
+		}
+		if err := objectStore.Replace(file.ObjectURL, data); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	_, err = e.shared.Exec(`
+		UPDATE message_archive SET messages = ?, sha256 = ?, first_timestamp = ?, last_timestamp = ? WHERE id = ?
+	`, len(kept), hex.EncodeToString(sum[:]), kept[0].Timestamp, kept[len(kept)-1].Timestamp, file.ID)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to update %s in the archive manifest: %v", file.Path, err)
+	}
+	return os.Rename(tmpPath, file.Path)
+}
+
+// eraseRows deletes the erased chats' rows and the contact's from every database
+func (e *erasure) eraseRows() error {
+	chatIn, chatArgs := inList(e.chats)
+	for _, db := range e.dbs {
+		if err := e.eraseMessages(db, "chat_jid "+chatIn, chatArgs); err != nil {
+			return err
+		}
+		for _, t := range eraseChatTables {
+			if err := e.exec(db, t.table, "DELETE FROM "+t.table, t.table, t.column+" "+chatIn, chatArgs); err != nil {
+				return err
+			}
+		}
+
+		// LLM usage stays in the cost reports, without the chat
+		for _, table := range []string{"llm_usage", "llm_json_parses"} {
+			err := e.exec(db, table+" (chat removed)", "UPDATE "+table+" SET group_jid = ''", table, "group_jid "+chatIn, chatArgs)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(e.people) == 0 {
+			continue
+		}
+		// The rows of the erased chats are already gone, and a dry run mustn't count them twice
+		peopleIn, peopleArgs := inList(e.people)
+		elsewhereArgs := append(append([]interface{}{}, peopleArgs...), chatArgs...)
+		if err := e.eraseMessages(db, "sender "+peopleIn+" AND chat_jid NOT "+chatIn, elsewhereArgs); err != nil {
+			return err
+		}
+		for _, t := range eraseContactTables {
+			condition, args := t.column+" "+peopleIn, peopleArgs
+			if t.chatColumn != "" {
+				condition, args = condition+" AND "+t.chatColumn+" NOT "+chatIn, elsewhereArgs
+			}
+			if err := e.exec(db, t.table, "DELETE FROM "+t.table, t.table, condition, args); err != nil {
+				return err
+			}
+		}
+		err := e.exec(db, "message_threads (reply sender removed)", "UPDATE message_threads SET reply_to_sender = NULL",
+			"message_threads", "reply_to_sender "+peopleIn+" AND chat_jid NOT "+chatIn, elsewhereArgs)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(e.people) > 0 {
+		peopleIn, peopleArgs := inList(e.people)
+		var optedOut int
+		e.shared.QueryRow("SELECT COUNT(*) FROM campaign_optouts WHERE jid "+peopleIn, peopleArgs...).Scan(&optedOut)
+		if optedOut > 0 {
+			e.notes = append(e.notes, "Kept the contact's campaign opt-out, so campaigns keep leaving them out.")
+		}
+		if e.whatsapp != nil {
+			err := e.exec(e.whatsapp, "WhatsApp contacts", "DELETE FROM whatsmeow_contacts", "whatsmeow_contacts", "their_jid "+peopleIn, peopleArgs)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// eraseMessages deletes the messages matching a condition along with their reactions, edits, receipts, polls and media records
+func (e *erasure) eraseMessages(db *sql.DB, condition string, args []interface{}) error {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE "+condition, args...).Scan(&count)
+	if isMissingSchema(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to count messages: %v", err)
+	}
+	if count == 0 {
+		return nil
+	}
+	if !e.dryRun {
+		if _, err := deleteMessages(db, condition, args...); err != nil {
+			return fmt.Errorf("failed to erase messages: %v", err)
+		}
+	}
+	e.add("messages", count)
+	return nil
+}
+
+// eraseChatDirName is the directory name summaries, reviews and LLM audit records use for a chat
+func eraseChatDirName(chatJID string) string {
+	return strings.NewReplacer("@", "_", ":", "_", "/", "_").Replace(chatJID)
+}
+
+// eraseFiles deletes the downloaded media, summaries, reviews, LLM audit records and Parquet exports of the
+// erased chats, and the media the contact sent elsewhere
+func (e *erasure) eraseFiles(mediaPaths []string) error {
+	// Each chat's media directory, and the one earlier bridges saved its media in
+	var chatDirs []string
+	for _, chat := range e.chats {
+		dir, legacyDir := mediaChatDirs(chat)
+		chatDirs = append(chatDirs, dir, legacyDir)
+	}
+	for _, path := range mediaPaths {
+		// Files in the erased chats' directories go with them
+		if slices.ContainsFunc(chatDirs, func(dir string) bool { return strings.HasPrefix(filepath.Clean(path), dir+string(filepath.Separator)) }) {
+			continue
+		}
+		if err := e.removePath("media files", path); err != nil {
+			return err
+		}
+	}
+	for _, chat := range e.chats {
+		dir, legacyDir := mediaChatDirs(chat)
+		paths := map[string][]string{
+			"media files":   {dir, legacyDir},
+			"summary files": {filepath.Join("store", "summaries", eraseChatDirName(chat))},
+			"review files":  {filepath.Join("store", "reviews", eraseChatDirName(chat))},
+			"Parquet export files": {
+				filepath.Join("store", "parquet", "messages", "chat="+chat),
+				filepath.Join("store", "parquet", "daily_activity", "chat="+chat),
+				filepath.Join("store", "parquet", "receipts", "chat="+chat),
+			},
+		}
+		paths["LLM audit records"], _ = filepath.Glob(filepath.Join("store", "llm-audit", "*", eraseChatDirName(chat)))
+		for _, what := range []string{"media files", "summary files", "review files", "LLM audit records", "Parquet export files"} {
+			for _, path := range paths[what] {
+				if err := e.removePath(what, path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if len(e.people) > 0 {
+		if _, err := os.Stat(filepath.Join("store", "parquet")); err == nil {
+			e.notes = append(e.notes, "Parquet exports of other chats still hold the contact's messages; run parquet-export again to rewrite them.")
+		}
+		e.notes = append(e.notes, "Summaries and reviews of other chats may still mention the contact; they are not rewritten.")
+	}
+	return nil
+}
+
+// removePath deletes a file or directory, counting the files it held
+func (e *erasure) removePath(what, path string) error {
+	count := 0
+	err := filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			count++
+		}
+		return err
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if !e.dryRun {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to delete %s: %v", path, err)
+		}
+	}
+	e.add(what, count)
+	return nil
+}
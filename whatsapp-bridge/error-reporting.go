@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Events reported to the error sinks
+const (
+	errorEventSummaryFailed = "summary_failed" // a stage of the daily summary pipeline failed
+	errorEventSendFailed    = "send_failed"    // a queued message was given up on
+	errorEventConnection    = "connection"     // the WhatsApp connection keeps dropping, or the session ended
+)
+
+// How long each error sink gets to take a report
+const errorReportTimeout = 10 * time.Second
+
+// ErrorReport is an error sent to the configured sinks
+type ErrorReport struct {
+	Event   string            `json:"event"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+	Host    string            `json:"host"`
+	Time    time.Time         `json:"time"`
+}
+
+// errorReportsSent remembers when each event and message was last reported, for the cooldown
+var (
+	errorReportsMutex sync.Mutex
+	errorReportsSent  = make(map[string]time.Time)
+)
+
+// getErrorReportCooldown returns how long the same error isn't reported again (ERROR_REPORT_COOLDOWN_MINUTES,
+// default 15)
+func getErrorReportCooldown() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("ERROR_REPORT_COOLDOWN_MINUTES"))
+	if err != nil || minutes < 0 {
+		minutes = 15
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// errorReportingEnabled reports whether any error sink is configured
+func errorReportingEnabled() bool {
+	return os.Getenv("ERROR_REPORT_WEBHOOK_URL") != "" || os.Getenv("SENTRY_DSN") != "" ||
+		os.Getenv("ERROR_REPORT_TELEGRAM_BOT_TOKEN") != "" || os.Getenv("ERROR_REPORT_WHATSAPP") == "true"
+}
+
+// reportError sends an error to every configured sink: a webhook (ERROR_REPORT_WEBHOOK_URL), Sentry (SENTRY_DSN),
+// a Telegram chat (ERROR_REPORT_TELEGRAM_BOT_TOKEN and ERROR_REPORT_TELEGRAM_CHAT_ID) and the admin chat on
+// WhatsApp (ERROR_REPORT_WHATSAPP=true). The same error is reported once per cooldown. It waits for the sinks,
+// so the bridge calls it in a goroutine.
+func reportError(event, message string, details map[string]string, logger waLog.Logger) {
+	if !errorReportingEnabled() {
+		return
+	}
+	key := event + "\x00" + message
+	errorReportsMutex.Lock()
+	if last, ok := errorReportsSent[key]; ok && time.Since(last) < getErrorReportCooldown() {
+		errorReportsMutex.Unlock()
+		return
+	}
+	errorReportsSent[key] = time.Now()
+	errorReportsMutex.Unlock()
+
+	host, _ := os.Hostname()
+	report := ErrorReport{Event: event, Message: message, Details: details, Host: host, Time: time.Now()}
+	if webhookURL := os.Getenv("ERROR_REPORT_WEBHOOK_URL"); webhookURL != "" {
+		if err := sendErrorWebhook(webhookURL, report); err != nil {
+			logger.Warnf("Failed to report error to the webhook: %v", err)
+		}
+	}
+	if dsn := os.Getenv("SENTRY_DSN"); dsn != "" {
+		if err := sendSentryEvent(dsn, report); err != nil {
+			logger.Warnf("Failed to report error to Sentry: %v", err)
+		}
+	}
+	if botToken := os.Getenv("ERROR_REPORT_TELEGRAM_BOT_TOKEN"); botToken != "" {
+		if err := sendTelegramAlert(botToken, os.Getenv("ERROR_REPORT_TELEGRAM_CHAT_ID"), report); err != nil {
+			logger.Warnf("Failed to report error to Telegram: %v", err)
+		}
+	}
+	if os.Getenv("ERROR_REPORT_WHATSAPP") == "true" {
+		// Goes through the bridge's outbox, so alerts about a dropped connection arrive once it is back
+		err := postBridgeSend(BridgeSendRequest{Recipient: getAdminChatJID(), Message: report.text(), Origin: messageOriginAlert})
+		if err != nil {
+			logger.Warnf("Failed to report error to the admin chat: %v", err)
+		}
+	}
+}
+
+// text renders a report as a chat message
+func (report ErrorReport) text() string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "⚠️ %s on %s: %s", report.Event, report.Host, report.Message)
+	keys := make([]string, 0, len(report.Details))
+	for key := range report.Details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&text, "\n%s: %s", key, report.Details[key])
+	}
+	return text.String()
+}
+
+// postErrorReport POSTs a JSON payload to a sink, with optional extra headers
+func postErrorReport(endpoint string, payload interface{}, headers map[string]string) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), errorReportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sendErrorWebhook posts the report as JSON, with a text field Slack and Mattermost incoming webhooks show
+func sendErrorWebhook(webhookURL string, report ErrorReport) error {
+	return postErrorReport(webhookURL, struct {
+		ErrorReport
+		Text string `json:"text"`
+	}{report, report.text()}, nil)
+}
+
+// sendSentryEvent sends the report to Sentry's store endpoint, with the DSN's public key; the event is tagged
+// with its kind, so alerts can be set up per event
+func sendSentryEvent(dsn string, report ErrorReport) error {
+	parsed, err := url.Parse(dsn)
+	if err != nil || parsed.User == nil {
+		return fmt.Errorf("invalid SENTRY_DSN")
+	}
+	path := strings.TrimRight(parsed.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	projectID := path[slash+1:]
+	if projectID == "" {
+		return fmt.Errorf("invalid SENTRY_DSN: no project ID")
+	}
+	endpoint := fmt.Sprintf("%s://%s%s/api/%s/store/", parsed.Scheme, parsed.Host, path[:slash], projectID)
+
+	event := map[string]interface{}{
+		"event_id":    strings.ReplaceAll(uuid.NewString(), "-", ""),
+		"timestamp":   report.Time.UTC().Format(time.RFC3339),
+		"level":       "error",
+		"platform":    "go",
+		"logger":      "whatsapp-bridge",
+		"server_name": report.Host,
+		"message":     map[string]string{"formatted": report.Message},
+		"tags":        map[string]string{"event": report.Event},
+		"extra":       report.Details,
+		"fingerprint": []string{report.Event, report.Message},
+	}
+	auth := "Sentry sentry_version=7, sentry_client=whatsapp-bridge, sentry_key=" + parsed.User.Username()
+	return postErrorReport(endpoint, event, map[string]string{"X-Sentry-Auth": auth})
+}
+
+// sendTelegramAlert sends the report to a Telegram chat through a bot
+func sendTelegramAlert(botToken, chatID string, report ErrorReport) error {
+	if chatID == "" {
+		return fmt.Errorf("ERROR_REPORT_TELEGRAM_CHAT_ID is required")
+	}
+	return postErrorReport("https://api.telegram.org/bot"+botToken+"/sendMessage",
+		map[string]string{"chat_id": chatID, "text": report.text()}, nil)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// StreamEvent is a bridge event pushed to WebSocket subscribers of /api/events
+type StreamEvent struct {
+	Type       string    `json:"type"` // "message"
+	ID         string    `json:"id"`
+	ChatJID    string    `json:"chat_jid"`
+	ChatName   string    `json:"chat_name"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name"`
+	Content    string    `json:"content"`
+	MediaType  string    `json:"media_type,omitempty"`
+	Filename   string    `json:"filename,omitempty"`
+	IsFromMe   bool      `json:"is_from_me"`
+	IsGroup    bool      `json:"is_group"`
+	Origin     string    `json:"origin,omitempty"` // set for automated messages
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// EventHub fans out bridge events to all connected WebSocket subscribers
+type EventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan StreamEvent]bool
+}
+
+// NewEventHub creates an empty event hub
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[chan StreamEvent]bool)}
+}
+
+// Subscribe registers a new subscriber channel
+func (hub *EventHub) Subscribe() chan StreamEvent {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	ch := make(chan StreamEvent, 100)
+	hub.subscribers[ch] = true
+	return ch
+}
+
+// Unsubscribe removes and closes a subscriber channel
+func (hub *EventHub) Unsubscribe(ch chan StreamEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if hub.subscribers[ch] {
+		delete(hub.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Publish sends an event to every subscriber; slow subscribers drop events instead of blocking message handling
+func (hub *EventHub) Publish(event StreamEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	for ch := range hub.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+var eventStreamUpgrader = websocket.Upgrader{
+	// The API has no browser clients; accept any origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// handleEventStream upgrades the request to a WebSocket and streams hub events as JSON until the client disconnects
+func handleEventStream(hub *EventHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := eventStreamUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		events := hub.Subscribe()
+		defer hub.Unsubscribe(events)
+
+		// Scoped tokens only receive the events of their chats
+		token := apiTokenFromRequest(r)
+
+		// Read (and discard) client frames so close messages are noticed
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ping := time.NewTicker(30 * time.Second)
+		defer ping.Stop()
+
+		for {
+			select {
+			case event := <-events:
+				if token != nil && !token.AllowsChat(event.ChatJID) {
+					continue
+				}
+				conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if err := conn.WriteJSON(event); err != nil {
+					return
+				}
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Export formats besides the Markdown and HTML transcripts
+const (
+	exportFormatJSON = "json"
+	exportFormatCSV  = "csv"
+)
+
+// exportExtensions maps each export format to its file extension
+var exportExtensions = map[string]string{
+	whatsAppFormatMarkdown: ".md",
+	whatsAppFormatHTML:     ".html",
+	exportFormatJSON:       ".json",
+	exportFormatCSV:        ".csv",
+}
+
+var (
+	exportChat   = flag.String("chat", "", "Chat JID to export (required)")
+	exportStart  = flag.String("start-date", "", "First day in YYYY-MM-DD format (defaults to the whole chat)")
+	exportEnd    = flag.String("end-date", "", "Last day in YYYY-MM-DD format (defaults to start date, or today for the whole chat)")
+	exportTZ     = flag.String("timezone", "America/Sao_Paulo", "Timezone for the date range")
+	exportFormat = flag.String("format", "markdown", "Output format: markdown, html, json or csv")
+	exportMedia  = flag.Bool("media-links", false, "Link each media message to its downloaded file, when there is one")
+	exportOut    = flag.String("out", "", "Output file (defaults to store/exports/<chat>-<start>-<end>.md|.html|.json|.csv)")
+)
+
+// ExportedMessage is a message in JSON and CSV exports
+type ExportedMessage struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Sender    string    `json:"sender"`
+	IsFromMe  bool      `json:"is_from_me"`
+	Content   string    `json:"content"` // with WhatsApp's own formatting markers
+	MediaType string    `json:"media_type,omitempty"`
+	Filename  string    `json:"filename,omitempty"`
+	MediaPath string    `json:"media_path,omitempty"` // relative to the export file, with --media-links
+	Reactions string    `json:"reactions,omitempty"`
+}
+
+// ChatExport is a JSON export
+type ChatExport struct {
+	ChatJID    string            `json:"chat_jid"`
+	ChatName   string            `json:"chat_name"`
+	StartDate  string            `json:"start_date"`
+	EndDate    string            `json:"end_date"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Messages   []ExportedMessage `json:"messages"`
+}
+
+func main() {
+	flag.Parse()
+
+	if err := exportTranscript(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// exportTranscript writes the chat's messages for the date range as a Markdown or HTML transcript keeping the
+// senders' WhatsApp formatting, or as JSON or CSV records
+func exportTranscript() error {
+	if *exportChat == "" {
+		flag.Usage()
+		return fmt.Errorf("--chat is required")
+	}
+	extension, ok := exportExtensions[*exportFormat]
+	if !ok {
+		return fmt.Errorf("--format must be markdown, html, json or csv")
+	}
+
+	loc, err := time.LoadLocation(*exportTZ)
+	if err != nil {
+		return fmt.Errorf("failed to load timezone %s: %v", *exportTZ, err)
+	}
+	// Without a start date the whole chat is exported, up to today
+	start := time.Unix(0, 0)
+	if *exportStart != "" {
+		if start, err = time.ParseInLocation("2006-01-02", *exportStart, loc); err != nil {
+			return fmt.Errorf("invalid start date: %v", err)
+		}
+		if *exportEnd == "" {
+			*exportEnd = *exportStart
+		}
+	}
+	if *exportEnd == "" {
+		*exportEnd = time.Now().In(loc).Format("2006-01-02")
+	}
+	end, err := time.ParseInLocation("2006-01-02", *exportEnd, loc)
+	if err != nil {
+		return fmt.Errorf("invalid end date: %v", err)
+	}
+	end = end.AddDate(0, 0, 1).Add(-time.Nanosecond)
+
+	logger := newLogger("Export", "WARN")
+	messages, err := getMessagesFromGroup(*exportChat, start, end, logger)
+	if err != nil {
+		return err
+	}
+	// Old messages moved to the archive are exported with the others
+	archived, err := archivedTranscriptMessages(*exportChat, start, end, logger)
+	if err != nil {
+		return err
+	}
+	messages = mergeTranscriptMessages(archived, messages)
+
+	period := *exportStart
+	if period == "" {
+		period = "all"
+	}
+	out := *exportOut
+	if out == "" {
+		name := strings.NewReplacer("@", "_", ":", "_").Replace(*exportChat)
+		out = filepath.Join("store", "exports", fmt.Sprintf("%s-%s-%s%s", name, period, *exportEnd, extension))
+	}
+
+	var mediaPaths map[string]string
+	if *exportMedia {
+		mediaPaths = exportMediaPaths(*exportChat, messages, filepath.Dir(out))
+	}
+
+	chatName := getGroupName(*exportChat, logger)
+	title := fmt.Sprintf("%s — %s to %s", chatName, *exportStart, *exportEnd)
+	if *exportStart == "" {
+		title = fmt.Sprintf("%s — until %s", chatName, *exportEnd)
+	}
+	var document []byte
+	switch *exportFormat {
+	case whatsAppFormatHTML:
+		document = []byte(renderHTMLTranscript(title, messages, mediaPaths, loc))
+	case exportFormatJSON:
+		document, err = json.MarshalIndent(ChatExport{
+			ChatJID:    *exportChat,
+			ChatName:   chatName,
+			StartDate:  *exportStart,
+			EndDate:    *exportEnd,
+			ExportedAt: time.Now(),
+			Messages:   exportedMessages(messages, mediaPaths),
+		}, "", "  ")
+	case exportFormatCSV:
+		document, err = renderCSVExport(exportedMessages(messages, mediaPaths), loc)
+	default:
+		document = []byte(renderMarkdownTranscript(title, messages, mediaPaths, loc))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to render export: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return fmt.Errorf("failed to create export directory: %v", err)
+	}
+	if err := os.WriteFile(out, document, 0644); err != nil {
+		return fmt.Errorf("failed to write export: %v", err)
+	}
+
+	fmt.Printf("Exported %d messages to %s\n", len(messages), out)
+	return nil
+}
+
+// archivedTranscriptMessages reads the chat's archived messages for the date range, rendered like the stored ones
+// (without their reactions and replies, which aren't archived)
+func archivedTranscriptMessages(chatJID string, start, end time.Time, logger waLog.Logger) ([]DailySummaryMessage, error) {
+	db, err := openSharedMessagesDB()
+	if err != nil {
+		return nil, err
+	}
+	archived, err := getArchivedMessages(db, chatJID, start, end)
+	if err != nil || len(archived) == 0 {
+		return nil, err
+	}
+	filter, err := newMessageFilter(db, chatJID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build message filter: %v", err)
+	}
+
+	var messages []DailySummaryMessage
+	for _, message := range archived {
+		if message.Content == "" && message.MediaType == "" {
+			continue
+		}
+		rendered, ok := toSummaryMessage(summaryRow{
+			ID:          message.ID,
+			Sender:      message.Sender,
+			Content:     message.Content,
+			Timestamp:   message.Timestamp,
+			IsFromMe:    message.IsFromMe,
+			MediaType:   message.MediaType,
+			Filename:    message.Filename,
+			MessageType: message.MessageType,
+			Origin:      message.Origin,
+			Transcript:  message.Transcript,
+			Deleted:     !message.DeletedAt.IsZero(),
+		}, filter, logger)
+		if ok {
+			messages = append(messages, rendered)
+		}
+	}
+	return messages, nil
+}
+
+// mergeTranscriptMessages merges archived and stored messages in time order; a message in both is taken from the
+// database
+func mergeTranscriptMessages(archived, stored []DailySummaryMessage) []DailySummaryMessage {
+	if len(archived) == 0 {
+		return stored
+	}
+	inDatabase := make(map[string]bool, len(stored))
+	for _, message := range stored {
+		inDatabase[message.ID] = true
+	}
+	merged := append([]DailySummaryMessage{}, stored...)
+	for _, message := range archived {
+		if !inDatabase[message.ID] {
+			merged = append(merged, message)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Time.Before(merged[j].Time) })
+	return merged
+}
+
+// exportMediaPaths finds the downloaded file of each media message, as a path relative to the export directory.
+// Media that was never downloaded (with the download_media tool) has no file to link to.
+func exportMediaPaths(chatJID string, messages []DailySummaryMessage, exportDir string) map[string]string {
+	chatDir, legacyChatDir := mediaChatDirs(chatJID)
+	paths := make(map[string]string)
+	for _, message := range messages {
+		if message.MediaType == "" || message.Filename == "" {
+			continue
+		}
+		file := filepath.Join(chatDir, message.Filename)
+		if _, err := os.Stat(file); err != nil {
+			file = filepath.Join(legacyChatDir, message.Filename)
+			if _, err := os.Stat(file); err != nil {
+				continue
+			}
+		}
+		if relative, err := filepath.Rel(exportDir, file); err == nil {
+			file = relative
+		}
+		paths[message.ID] = filepath.ToSlash(file)
+	}
+	return paths
+}
+
+// exportedMessages converts messages to export records
+func exportedMessages(messages []DailySummaryMessage, mediaPaths map[string]string) []ExportedMessage {
+	exported := make([]ExportedMessage, 0, len(messages))
+	for _, message := range messages {
+		exported = append(exported, ExportedMessage{
+			ID:        message.ID,
+			Time:      message.Time,
+			Sender:    message.Sender,
+			IsFromMe:  message.IsFromMe,
+			Content:   message.Raw,
+			MediaType: message.MediaType,
+			Filename:  message.Filename,
+			MediaPath: mediaPaths[message.ID],
+			Reactions: message.Reactions,
+		})
+	}
+	return exported
+}
+
+// renderCSVExport renders messages as CSV with a header row, times in the export's timezone
+func renderCSVExport(messages []ExportedMessage, loc *time.Location) ([]byte, error) {
+	var out bytes.Buffer
+	writer := csv.NewWriter(&out)
+	writer.Write([]string{"id", "time", "sender", "is_from_me", "content", "media_type", "filename", "media_path", "reactions"})
+	for _, message := range messages {
+		writer.Write([]string{
+			message.ID,
+			message.Time.In(loc).Format(time.RFC3339),
+			message.Sender,
+			strconv.FormatBool(message.IsFromMe),
+			message.Content,
+			message.MediaType,
+			message.Filename,
+			message.MediaPath,
+			message.Reactions,
+		})
+	}
+	writer.Flush()
+	return out.Bytes(), writer.Error()
+}
+
+// renderMarkdownTranscript renders messages as a Markdown document, one heading per day
+func renderMarkdownTranscript(title string, messages []DailySummaryMessage, mediaPaths map[string]string, loc *time.Location) string {
+	var out strings.Builder
+	out.WriteString("# " + title + "\n")
+
+	day := ""
+	for _, message := range messages {
+		if messageDay := message.Time.In(loc).Format("2006-01-02"); messageDay != day {
+			day = messageDay
+			out.WriteString("\n## " + day + "\n\n")
+		}
+		content := renderWhatsAppText(message.Raw, whatsAppFormatMarkdown, true)
+		if path, ok := mediaPaths[message.ID]; ok {
+			content += fmt.Sprintf(" [📎 %s](<%s>)", message.Filename, path)
+		}
+		// Continuation lines are indented so lists and code stay inside the message's list item
+		out.WriteString(fmt.Sprintf("- **%s** %s: %s\n", message.Time.In(loc).Format("15:04"),
+			renderWhatsAppText(message.Sender, whatsAppFormatMarkdown, true), strings.ReplaceAll(content, "\n", "\n  ")))
+	}
+	return out.String()
+}
+
+// renderHTMLTranscript renders messages as a standalone HTML page, one section per day
+func renderHTMLTranscript(title string, messages []DailySummaryMessage, mediaPaths map[string]string, loc *time.Location) string {
+	var out strings.Builder
+	out.WriteString(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>` + html.EscapeString(title) + `</title>
+<style>
+body { font-family: sans-serif; max-width: 48rem; margin: 2rem auto; line-height: 1.4; }
+.message { margin: 0.5rem 0; }
+.message.me { text-align: right; }
+.time { color: #888; font-size: 0.8rem; }
+pre, code { background: #f2f2f2; border-radius: 3px; }
+blockquote { border-left: 3px solid #ccc; margin: 0.25rem 0; padding-left: 0.5rem; color: #555; }
+</style>
+</head>
+<body>
+<h1>` + html.EscapeString(title) + "</h1>\n")
+
+	day := ""
+	for _, message := range messages {
+		if messageDay := message.Time.In(loc).Format("2006-01-02"); messageDay != day {
+			day = messageDay
+			out.WriteString("<h2>" + day + "</h2>\n")
+		}
+		class := "message"
+		if message.IsFromMe {
+			class += " me"
+		}
+		content := renderWhatsAppText(message.Raw, whatsAppFormatHTML, false)
+		if path, ok := mediaPaths[message.ID]; ok {
+			content += fmt.Sprintf(" <a href=\"%s\">📎 %s</a>", html.EscapeString((&url.URL{Path: path}).String()), html.EscapeString(message.Filename))
+		}
+		out.WriteString(fmt.Sprintf("<div class=\"%s\"><span class=\"time\">%s</span> <strong>%s</strong>: %s</div>\n", class,
+			message.Time.In(loc).Format("15:04"), html.EscapeString(message.Sender), content))
+	}
+	out.WriteString("</body>\n</html>\n")
+	return out.String()
+}
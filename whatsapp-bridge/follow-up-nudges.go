@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// The daily summary sent to my self-chat ends with my pending follow-ups: the asks of mine the bridge reports as
+// unanswered (/api/followups, see follow-ups.go), each with a nudge drafted by the LLM. The nudges of direct chats
+// are wa.me links that open the chat with the nudge typed in, ready to send.
+
+// Follow-ups listed in the summary, and longest preview of each ask
+const (
+	maxSummaryFollowUps   = 10
+	followUpPreviewLength = 80
+)
+
+const followUpNudgePrompt = `I sent these WhatsApp messages asking for something and got no reply. For each, draft a short, friendly nudge I can send to follow up: one or two sentences, in the language of my message, without repeating it word for word and without sounding pushy.
+
+%s`
+
+// followUpNudgeSchema describes the LLM's nudges, one per numbered follow-up
+var followUpNudgeSchema = &JSONSchema{
+	Type: "object",
+	Properties: map[string]*JSONSchema{
+		"nudges": {
+			Type: "array",
+			Items: &JSONSchema{
+				Type: "object",
+				Properties: map[string]*JSONSchema{
+					"number": {Type: "integer", Description: "the number of the follow-up"},
+					"text":   {Type: "string", Description: "the nudge to send", MinLength: 1},
+				},
+				Required: []string{"number", "text"},
+			},
+		},
+	},
+	Required: []string{"nudges"},
+}
+
+// summaryFollowUp is an entry of the bridge's /api/followups response
+type summaryFollowUp struct {
+	ChatJID  string    `json:"chat_jid"`
+	ChatName string    `json:"chat_name"`
+	IsGroup  bool      `json:"is_group"`
+	Waiting  string    `json:"waiting"`
+	Count    int       `json:"count"`
+	Since    time.Time `json:"since"`
+	Content  string    `json:"content"`
+}
+
+// followUpsInSummary reports whether the summary sent to "self" lists my pending follow-ups
+// (DAILY_SUMMARY_FOLLOW_UPS, on unless "false")
+func followUpsInSummary() bool {
+	return os.Getenv("DAILY_SUMMARY_FOLLOW_UPS") != "false"
+}
+
+// followUpSetting returns one of the follow-up tracker's settings, or fallback when it is unset or invalid
+func followUpSetting(name string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil && value >= 0 {
+		return value
+	}
+	return fallback
+}
+
+// buildFollowUpsSection returns the summary's pending follow-ups section, or "" when there are none
+func buildFollowUpsSection(ctx context.Context, logger waLog.Logger) string {
+	var response struct {
+		FollowUps []summaryFollowUp `json:"follow_ups"`
+	}
+	query := url.Values{
+		"hours": {strconv.Itoa(followUpSetting("FOLLOW_UP_HOURS", 24))},
+		"days":  {strconv.Itoa(followUpSetting("FOLLOW_UP_DAYS", 7))},
+	}
+	if err := callBridgeAPI("GET", "/followups?"+query.Encode(), nil, &response); err != nil {
+		logger.Warnf("Pending follow-ups not available: %v", err)
+		return ""
+	}
+	if len(response.FollowUps) == 0 {
+		return ""
+	}
+	followUps := response.FollowUps
+	if len(followUps) > maxSummaryFollowUps {
+		followUps = followUps[:maxSummaryFollowUps]
+	}
+	nudges, err := draftFollowUpNudges(ctx, followUps, logger)
+	if err != nil {
+		logger.Warnf("Listing follow-ups without nudges: %v", err)
+	}
+	logger.Infof("Listing %d pending follow-up(s) in the summary", len(response.FollowUps))
+	return formatFollowUpsSection(response.FollowUps, nudges, time.Now())
+}
+
+// draftFollowUpNudges asks the LLM for a nudge per follow-up, by index
+func draftFollowUpNudges(ctx context.Context, followUps []summaryFollowUp, logger waLog.Logger) (map[int]string, error) {
+	var lines []string
+	for i, followUp := range followUps {
+		to := followUpName(followUp)
+		if followUp.IsGroup && followUp.Waiting != "" {
+			to = followUp.Waiting + " in the group " + to
+		}
+		lines = append(lines, fmt.Sprintf("%d. To %s, %s: %s", i+1, to, followUp.Since.Format("Jan 2"), strings.Join(strings.Fields(followUp.Content), " ")))
+	}
+	var answer struct {
+		Nudges []struct {
+			Number int    `json:"number"`
+			Text   string `json:"text"`
+		} `json:"nudges"`
+	}
+	prompt := fmt.Sprintf(followUpNudgePrompt, strings.Join(lines, "\n"))
+	if err := callLLMStructured(withLLMPurpose(ctx, "follow_up_nudge"), prompt, followUpNudgeSchema, &answer, logger); err != nil {
+		return nil, err
+	}
+	nudges := make(map[int]string)
+	for _, nudge := range answer.Nudges {
+		if nudge.Number >= 1 && nudge.Number <= len(followUps) {
+			nudges[nudge.Number-1] = strings.TrimSpace(nudge.Text)
+		}
+	}
+	return nudges, nil
+}
+
+// followUpName returns the name of a follow-up's chat, or the phone number of a direct chat without one
+func followUpName(followUp summaryFollowUp) string {
+	if followUp.ChatName != "" {
+		return followUp.ChatName
+	}
+	return strings.Split(followUp.ChatJID, "@")[0]
+}
+
+// nudgeLink returns a wa.me link opening a direct chat with a nudge typed in, or "" for groups and chats known
+// only by LID
+func nudgeLink(chatJID, nudge string) string {
+	phone, server, _ := strings.Cut(chatJID, "@")
+	if server != "s.whatsapp.net" {
+		return ""
+	}
+	// wa.me reads "+" literally
+	return "https://wa.me/" + phone + "?text=" + strings.ReplaceAll(url.QueryEscape(nudge), "+", "%20")
+}
+
+// formatFollowUpsSection renders the pending follow-ups, longest waiting first, with their nudges
+func formatFollowUpsSection(followUps []summaryFollowUp, nudges map[int]string, now time.Time) string {
+	lines := []string{fmt.Sprintf("⏳ *Pending follow-ups* (%d)", len(followUps))}
+	for i, followUp := range followUps {
+		if i == maxSummaryFollowUps {
+			lines = append(lines, fmt.Sprintf("_…and %d more_", len(followUps)-i))
+			break
+		}
+		to := "*" + followUpName(followUp) + "*"
+		if followUp.IsGroup && followUp.Waiting != "" {
+			to = "*" + followUp.Waiting + "* in " + to
+		}
+		preview := []rune(strings.Join(strings.Fields(followUp.Content), " "))
+		if len(preview) > followUpPreviewLength {
+			preview = append(preview[:followUpPreviewLength-1], '…')
+		}
+		asks := ""
+		if followUp.Count > 1 {
+			asks = fmt.Sprintf(" (%d asks)", followUp.Count)
+		}
+		days := int(now.Sub(followUp.Since).Hours() / 24)
+		waited := "yesterday"
+		if days > 1 {
+			waited = fmt.Sprintf("%d days ago", days)
+		} else if days < 1 {
+			waited = "today"
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s%s, %s: _%s_", i+1, to, asks, waited, string(preview)))
+
+		if nudge := nudges[i]; nudge != "" {
+			lines = append(lines, "   ✍️ "+nudge)
+			if link := nudgeLink(followUp.ChatJID, nudge); link != "" {
+				lines = append(lines, "   👉 "+link)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// The follow-ups I'm waiting on: my messages ending with a question or asking for something that got no reply. In
+// a direct chat any later message of the other side is a reply; in a group only a message quoting mine, or from
+// the person my message quoted or @mentioned. The daily summary lists them with a drafted nudge each.
+
+const (
+	// Defaults of /api/followups: asks waiting for more than a day, from the last week
+	defaultFollowUpHours = 24
+	defaultFollowUpDays  = 7
+
+	maxFollowUpDays = 90
+)
+
+// followUpAskPattern matches a message asking for something: a question, or a request in English or Portuguese
+var followUpAskPattern = regexp.MustCompile(`(?i)\?[\s\p{So}\p{Sk})\]]*$|\b(?:can|could|would|will) you\b|\b(?:please|pls|plz)\b|\blet me know\b|\bany (?:news|updates?)\b|\bwhen can\b|\bpor favor\b|\bpf\b|\b(?:pode|poderia|consegue|conseguiria)s?\b|\bme (?:avisa|manda|envia|diz|fala|confirma)\b|\balguma novidade\b`)
+
+// FollowUp is a chat where my latest ask got no reply
+type FollowUp struct {
+	ChatJID   string    `json:"chat_jid"`
+	ChatName  string    `json:"chat_name,omitempty"`
+	IsGroup   bool      `json:"is_group"`
+	Waiting   string    `json:"waiting,omitempty"` // who I'm waiting on, in a group: the person quoted or mentioned
+	Count     int       `json:"count"`             // asks without a reply
+	Since     time.Time `json:"since"`             // when the oldest of them was sent
+	MessageID string    `json:"message_id"`
+	Content   string    `json:"content"` // of the latest of them
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// isFollowUpAsk reports whether a message of mine asks for something and so expects a reply
+func isFollowUpAsk(text string) bool {
+	return followUpAskPattern.MatchString(strings.TrimSpace(text))
+}
+
+// queryFollowUpAsks returns my asks in the chats matching chatCondition, sent in a period with no reply since
+func (store *MessageStore) queryFollowUpAsks(db *sql.DB, chatCondition string, after, before time.Time) ([]FollowUp, error) {
+	rows, err := db.Query(`
+		SELECT m.id, m.chat_jid, COALESCE(m.content, ''), COALESCE(t.reply_to_sender, ''), m.timestamp
+		FROM messages m
+		LEFT JOIN message_threads t ON t.message_id = m.id AND t.chat_jid = m.chat_jid
+		WHERE `+chatCondition+` AND m.chat_jid NOT LIKE '%@broadcast' AND m.chat_jid NOT LIKE '%@newsletter'
+			AND m.is_from_me = 1 AND COALESCE(m.origin, '') IN ('', '`+messageOriginBridgeAPI+`')
+			AND COALESCE(m.message_type, '') = '' AND m.deleted_at IS NULL AND COALESCE(m.content, '') != ''
+			AND m.timestamp >= ? AND m.timestamp < ?
+			AND NOT EXISTS (
+				SELECT 1 FROM messages r WHERE r.chat_jid = m.chat_jid AND r.timestamp > m.timestamp AND NOT COALESCE(r.is_from_me, 0)
+					AND (m.chat_jid NOT LIKE '%@g.us'
+						OR EXISTS (SELECT 1 FROM message_threads rt WHERE rt.message_id = r.id AND rt.chat_jid = r.chat_jid AND rt.reply_to_id = m.id)
+						OR t.reply_to_sender LIKE r.sender || '@%'
+						OR m.content LIKE '%@' || r.sender || '%')
+			)
+		ORDER BY m.timestamp
+	`, after, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %v", err)
+	}
+	defer rows.Close()
+
+	var asks []FollowUp
+	for rows.Next() {
+		var ask FollowUp
+		var quotedSender string
+		if err := rows.Scan(&ask.MessageID, &ask.ChatJID, &ask.Content, &quotedSender, &ask.Timestamp); err != nil {
+			return nil, err
+		}
+		ask.Content = store.open(ask.Content)
+		if !isFollowUpAsk(ask.Content) {
+			continue
+		}
+		ask.IsGroup = strings.HasSuffix(ask.ChatJID, "@g.us")
+		if ask.IsGroup {
+			if quotedSender != "" {
+				ask.Waiting = strings.Split(quotedSender, "@")[0]
+			} else if match := mentionPattern.FindStringSubmatch(ask.Content); match != nil {
+				ask.Waiting = match[1]
+			}
+		}
+		asks = append(asks, ask)
+	}
+	return asks, rows.Err()
+}
+
+// PendingFollowUps returns the chats where my asks sent since after, and before the cutoff, got no reply, longest
+// waiting first. users are this account's identifiers (see ownUsers), whose self-chat is left out.
+func (store *MessageStore) PendingFollowUps(users []string, after, cutoff time.Time) ([]FollowUp, error) {
+	if len(users) == 0 {
+		return nil, fmt.Errorf("not logged in")
+	}
+	direct, err := store.queryFollowUpAsks(store.dbFor(users[0]+"@"+types.DefaultUserServer), "m.chat_jid NOT LIKE '%@g.us'", after, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := store.queryFollowUpAsks(store.db, "m.chat_jid LIKE '%@g.us'", after, cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	own := make(map[string]bool)
+	for _, user := range users {
+		own[user] = true
+	}
+	byChat := make(map[string]*FollowUp)
+	var chats []*FollowUp
+	for _, ask := range append(direct, groups...) {
+		if own[strings.Split(ask.ChatJID, "@")[0]] {
+			continue
+		}
+		if settings, _ := getChatSettings(store.db, ask.ChatJID); settings.Muted {
+			continue
+		}
+		chat, ok := byChat[ask.ChatJID]
+		if !ok {
+			chat = &FollowUp{ChatJID: ask.ChatJID, IsGroup: ask.IsGroup, Since: ask.Timestamp}
+			byChat[ask.ChatJID] = chat
+			chats = append(chats, chat)
+		}
+		chat.Count++
+		chat.Waiting, chat.MessageID, chat.Content, chat.Timestamp = ask.Waiting, ask.MessageID, ask.Content, ask.Timestamp
+	}
+
+	followUps := []FollowUp{}
+	for _, chat := range chats {
+		store.db.QueryRow("SELECT COALESCE(name, '') FROM chats WHERE jid = ?", chat.ChatJID).Scan(&chat.ChatName)
+		if chat.Waiting != "" {
+			if name := lookupSenderName(store.db, chat.Waiting); name != "" {
+				chat.Waiting = name
+			}
+		}
+		followUps = append(followUps, *chat)
+	}
+	sort.SliceStable(followUps, func(i, j int) bool { return followUps[i].Since.Before(followUps[j].Since) })
+	return followUps, nil
+}
+
+// handleFollowUpsAPI lists the chats where my asks got no reply for more than hours (default 24), from the last
+// days (default 7)
+func handleFollowUpsAPI(client *whatsmeow.Client, messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		hours, days := defaultFollowUpHours, defaultFollowUpDays
+		if value := r.URL.Query().Get("hours"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 || parsed > 24*maxFollowUpDays {
+				http.Error(w, fmt.Sprintf("hours must be between 0 and %d", 24*maxFollowUpDays), http.StatusBadRequest)
+				return
+			}
+			hours = parsed
+		}
+		if value := r.URL.Query().Get("days"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > maxFollowUpDays {
+				http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxFollowUpDays), http.StatusBadRequest)
+				return
+			}
+			days = parsed
+		}
+		if hours >= 24*days {
+			http.Error(w, "hours must be less than the days covered", http.StatusBadRequest)
+			return
+		}
+
+		users := ownUsers(client)
+		if len(users) == 0 {
+			http.Error(w, "Not logged in", http.StatusServiceUnavailable)
+			return
+		}
+		now := time.Now()
+		followUps, err := messageStore.PendingFollowUps(users, now.AddDate(0, 0, -days), now.Add(-time.Duration(hours)*time.Hour))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		allowed := followUps[:0]
+		for _, followUp := range followUps {
+			if apiRequestAllowsChat(r, followUp.ChatJID) {
+				allowed = append(allowed, followUp)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"hours": hours, "days": days, "follow_ups": allowed})
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// GraphitiEntityNodeRequest represents the request body for the Graphiti /entity-node endpoint
+type GraphitiEntityNodeRequest struct {
+	UUID    string `json:"uuid"`
+	GroupID string `json:"group_id"`
+	Name    string `json:"name"`
+	Summary string `json:"summary"`
+}
+
+// GraphitiMessagesRequest represents the request body for the Graphiti /messages endpoint
+type GraphitiMessagesRequest struct {
+	GroupID  string            `json:"group_id"`
+	Messages []GraphitiMessage `json:"messages"`
+}
+
+// GraphitiMessage is a message ingested by Graphiti as an episode
+type GraphitiMessage struct {
+	UUID              string    `json:"uuid,omitempty"`
+	Name              string    `json:"name"`
+	Content           string    `json:"content"`
+	RoleType          string    `json:"role_type"`
+	Role              string    `json:"role"`
+	Timestamp         time.Time `json:"timestamp"`
+	SourceDescription string    `json:"source_description"`
+}
+
+// GraphitiSearchRequest represents the request body for the Graphiti /search endpoint
+type GraphitiSearchRequest struct {
+	GroupIDs []string `json:"group_ids"`
+	Query    string   `json:"query"`
+	MaxFacts int      `json:"max_facts"`
+}
+
+// GraphitiFact is a fact (edge) returned by a Graphiti search.
+// Timestamps are kept as text: Graphiti may return them without a timezone.
+type GraphitiFact struct {
+	UUID      string `json:"uuid"`
+	Name      string `json:"name"`
+	Fact      string `json:"fact"`
+	ValidAt   string `json:"valid_at"`
+	InvalidAt string `json:"invalid_at"`
+	CreatedAt string `json:"created_at"`
+}
+
+// GraphitiSearchResponse represents the response of the Graphiti /search endpoint
+type GraphitiSearchResponse struct {
+	Facts []GraphitiFact `json:"facts"`
+}
+
+// getGraphitiAPIURL returns the base URL of the Graphiti REST server
+func getGraphitiAPIURL() string {
+	graphitiURL := os.Getenv("GRAPHITI_API_URL")
+	if graphitiURL == "" {
+		graphitiURL = "http://host.docker.internal:8000"
+	}
+	return strings.TrimRight(graphitiURL, "/")
+}
+
+// getGraphitiGroupID returns the Graphiti group_id used when no per-group namespace applies
+func getGraphitiGroupID() string {
+	groupID := os.Getenv("GRAPHITI_GROUP_ID")
+	if groupID == "" {
+		groupID = "default"
+	}
+	return groupID
+}
+
+// graphitiGroupIDForChat returns the Graphiti group_id (namespace) for a WhatsApp chat.
+// Each group gets its own namespace unless GRAPHITI_PER_GROUP_NAMESPACES=false, and individual
+// namespaces can be pinned with GRAPHITI_GROUP_NAMESPACES="jid=namespace,jid2=namespace2".
+func graphitiGroupIDForChat(chatJID string) string {
+	if os.Getenv("GRAPHITI_PER_GROUP_NAMESPACES") == "false" || chatJID == "" {
+		return getGraphitiGroupID()
+	}
+
+	// Explicit mappings take precedence
+	for _, mapping := range strings.Split(os.Getenv("GRAPHITI_GROUP_NAMESPACES"), ",") {
+		jid, namespace, found := strings.Cut(strings.TrimSpace(mapping), "=")
+		if found && strings.TrimSpace(jid) == chatJID && strings.TrimSpace(namespace) != "" {
+			return sanitizeGraphitiGroupID(strings.TrimSpace(namespace))
+		}
+	}
+
+	// Derive the namespace from the JID user part (e.g. 120363012345678901@g.us -> whatsapp_120363012345678901)
+	return sanitizeGraphitiGroupID("whatsapp_" + strings.Split(chatJID, "@")[0])
+}
+
+// sanitizeGraphitiGroupID keeps only the characters Graphiti accepts in a group_id (letters, digits, dashes and underscores)
+func sanitizeGraphitiGroupID(groupID string) string {
+	var sanitized strings.Builder
+	for _, r := range groupID {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			sanitized.WriteRune(r)
+		} else {
+			sanitized.WriteRune('_')
+		}
+	}
+	return sanitized.String()
+}
+
+// callGraphitiAPI sends a JSON request to the Graphiti REST server and decodes the JSON response into out (if not nil)
+func callGraphitiAPI(method, path string, payload interface{}, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("error marshaling request: %v", err)
+		}
+		body = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequest(method, getGraphitiAPIURL()+path, body)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{
+		Timeout: 60 * time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %v", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Graphiti returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	if out != nil && len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("error parsing response: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// upsertGraphitiEntityNode creates or updates an entity node in Graphiti.
+// Graphiti merges nodes on UUID, so re-sending the same UUID updates the existing node.
+func upsertGraphitiEntityNode(node GraphitiEntityNodeRequest) error {
+	return callGraphitiAPI(http.MethodPost, "/entity-node", node, nil)
+}
+
+// deleteGraphitiEpisode removes an episode (and the edges only it supports) from Graphiti.
+// An episode that no longer exists is not an error.
+func deleteGraphitiEpisode(uuid string) error {
+	err := callGraphitiAPI(http.MethodDelete, "/episode/"+uuid, nil, nil)
+	if err != nil && strings.Contains(err.Error(), "HTTP 404") {
+		return nil
+	}
+	return err
+}
+
+// addGraphitiEpisodeDirect sends an episode to the Graphiti REST /messages endpoint without going through Claude.
+// The user's corrections are prepended so Graphiti extracts the corrected facts.
+func addGraphitiEpisodeDirect(episode KnowledgeEpisode) error {
+	content := episode.Body
+	if episode.Annotations != "" {
+		content = "Corrections from the user (these override the conversation):\n" + episode.Annotations + "\n\n" + content
+	}
+
+	timestamp := time.Now()
+	if len(episode.Messages) > 0 && !episode.Messages[0].Time.IsZero() {
+		timestamp = episode.Messages[0].Time
+	}
+
+	return callGraphitiAPI(http.MethodPost, "/messages", GraphitiMessagesRequest{
+		GroupID: episode.Namespace,
+		Messages: []GraphitiMessage{{
+			UUID:              episode.UUID,
+			Name:              episode.Name,
+			Content:           content,
+			RoleType:          "user",
+			Role:              episode.GroupName,
+			Timestamp:         timestamp,
+			SourceDescription: episode.SourceDescription,
+		}},
+	}, nil)
+}
+
+// searchGraphitiFacts returns the facts of a namespace most relevant to a query
+func searchGraphitiFacts(groupID, query string, maxFacts int) ([]GraphitiFact, error) {
+	var response GraphitiSearchResponse
+	err := callGraphitiAPI(http.MethodPost, "/search", GraphitiSearchRequest{
+		GroupIDs: []string{groupID},
+		Query:    query,
+		MaxFacts: maxFacts,
+	}, &response)
+	return response.Facts, err
+}
+
+// parseGraphitiTime parses a Graphiti timestamp, with or without timezone; the zero time when empty or invalid
+func parseGraphitiTime(value string) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999", "2006-01-02T15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
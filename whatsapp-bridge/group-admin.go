@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// GroupParticipantInfo is a member of a group
+type GroupParticipantInfo struct {
+	JID          string `json:"jid"`
+	PhoneNumber  string `json:"phone_number,omitempty"`
+	Name         string `json:"name,omitempty"` // from the contacts, when known
+	IsAdmin      bool   `json:"is_admin"`
+	IsSuperAdmin bool   `json:"is_super_admin"`
+	IsMe         bool   `json:"is_me,omitempty"`
+	Error        int    `json:"error,omitempty"` // WhatsApp's error code when a participant change failed for this member
+}
+
+// GroupDetails is a group's name, description and members, as returned by the group API
+type GroupDetails struct {
+	JID          string                 `json:"jid"`
+	Name         string                 `json:"name"`
+	Description  string                 `json:"description"`
+	CreatedAt    time.Time              `json:"created_at"`
+	Participants []GroupParticipantInfo `json:"participants"`
+}
+
+// newGroupParticipantInfo converts a whatsmeow participant for the group API
+func newGroupParticipantInfo(participant types.GroupParticipant) GroupParticipantInfo {
+	info := GroupParticipantInfo{
+		JID:          participant.JID.String(),
+		IsAdmin:      participant.IsAdmin,
+		IsSuperAdmin: participant.IsSuperAdmin,
+		Error:        participant.Error,
+	}
+	if !participant.PhoneNumber.IsEmpty() {
+		info.PhoneNumber = participant.PhoneNumber.User
+	}
+	return info
+}
+
+// newGroupDetails converts whatsmeow group info for the group API
+func newGroupDetails(info *types.GroupInfo) *GroupDetails {
+	details := &GroupDetails{
+		JID:          info.JID.String(),
+		Name:         info.Name,
+		Description:  info.Topic,
+		CreatedAt:    info.GroupCreated,
+		Participants: []GroupParticipantInfo{},
+	}
+	for _, participant := range info.Participants {
+		details.Participants = append(details.Participants, newGroupParticipantInfo(participant))
+	}
+	return details
+}
+
+// resolveParticipantNames names the members of a group from the contact store, by their JID or phone number,
+// and marks this account
+func resolveParticipantNames(client *whatsmeow.Client, info *types.GroupInfo, details *GroupDetails) {
+	for i, participant := range info.Participants {
+		if me := client.Store.ID; me != nil {
+			details.Participants[i].IsMe = participant.JID.User == me.User || participant.PhoneNumber.User == me.User ||
+				(!client.Store.LID.IsEmpty() && participant.JID.User == client.Store.LID.User)
+		}
+		for _, jid := range []types.JID{participant.JID, participant.PhoneNumber} {
+			if jid.IsEmpty() {
+				continue
+			}
+			contact, err := client.Store.Contacts.GetContact(context.Background(), jid.ToNonAD())
+			if err == nil && contactDisplayName(contact) != "" {
+				details.Participants[i].Name = contactDisplayName(contact)
+				break
+			}
+		}
+	}
+}
+
+// parseGroupJID parses the JID of a group
+func parseGroupJID(groupJID string) (types.JID, error) {
+	jid, err := types.ParseJID(groupJID)
+	if err != nil {
+		return types.JID{}, err
+	}
+	if jid.Server != types.GroupServer {
+		return types.JID{}, fmt.Errorf("%s is not a group JID", groupJID)
+	}
+	return jid, nil
+}
+
+// parseParticipantJIDs parses participants given as JIDs or phone numbers
+func parseParticipantJIDs(client *whatsmeow.Client, participants []string) ([]types.JID, error) {
+	var jids []types.JID
+	for _, participant := range participants {
+		jid, err := parseRecipientJID(client, strings.TrimSpace(participant))
+		if err != nil {
+			return nil, fmt.Errorf("invalid participant %s: %v", participant, err)
+		}
+		jids = append(jids, jid)
+	}
+	if len(jids) == 0 {
+		return nil, fmt.Errorf("participants are required")
+	}
+	return jids, nil
+}
+
+// decodeGroupRequest decodes the body of a group API request, rejecting it unless it is a POST
+// allowed while safe mode is off; it reports whether the handler should go on. Read-only mode is left to the
+// routes' refuseInReadOnlyMode.
+func decodeGroupRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return false
+	}
+	if isSafeModeActive() {
+		http.Error(w, "Safe mode is on, group changes are disabled", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// requireGroup parses a request's group JID and checks the request's token covers it; it reports whether
+// the handler should go on
+func requireGroup(w http.ResponseWriter, r *http.Request, groupJID string) (types.JID, bool) {
+	jid, err := parseGroupJID(groupJID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid group_jid: %v", err), http.StatusBadRequest)
+		return types.JID{}, false
+	}
+	if !apiRequestAllowsChat(r, jid.String()) {
+		rejectChat(w, r, jid.String())
+		return types.JID{}, false
+	}
+	return jid, true
+}
+
+// CreateGroupRequest is the request body for the create group API
+type CreateGroupRequest struct {
+	Name         string   `json:"name"`
+	Participants []string `json:"participants"` // JIDs or phone numbers; this account is added by WhatsApp
+}
+
+// handleCreateGroupAPI creates a group (POST /api/groups/create)
+func handleCreateGroupAPI(client *whatsmeow.Client, messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateGroupRequest
+		if !decodeGroupRequest(w, r, &req) {
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		participants, err := parseParticipantJIDs(client, req.Participants)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		info, err := client.CreateGroup(whatsmeow.ReqCreateGroup{Name: req.Name, Participants: participants})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create group: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := messageStore.StoreChat(info.JID.String(), info.Name, time.Now()); err != nil {
+			fmt.Printf("Failed to store created group: %v\n", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newGroupDetails(info))
+	}
+}
+
+// handleGroupInfoAPI returns a group's name, description and members (GET /api/groups/info?group_jid=...)
+func handleGroupInfoAPI(client *whatsmeow.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		jid, ok := requireGroup(w, r, r.URL.Query().Get("group_jid"))
+		if !ok {
+			return
+		}
+
+		info, err := client.GetGroupInfo(jid)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get group info: %v", err), http.StatusInternalServerError)
+			return
+		}
+		details := newGroupDetails(info)
+		resolveParticipantNames(client, info, details)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(details)
+	}
+}
+
+// GroupParticipantsRequest is the request body for the group participants API
+type GroupParticipantsRequest struct {
+	GroupJID     string   `json:"group_jid"`
+	Action       string   `json:"action"` // add, remove, promote or demote
+	Participants []string `json:"participants"`
+}
+
+// handleGroupParticipantsAPI adds, removes, promotes or demotes members of a group (POST /api/groups/participants).
+// WhatsApp may refuse some of the members (e.g. privacy settings that forbid adding them); they come back with an error code.
+func handleGroupParticipantsAPI(client *whatsmeow.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req GroupParticipantsRequest
+		if !decodeGroupRequest(w, r, &req) {
+			return
+		}
+		jid, ok := requireGroup(w, r, req.GroupJID)
+		if !ok {
+			return
+		}
+		action := whatsmeow.ParticipantChange(req.Action)
+		switch action {
+		case whatsmeow.ParticipantChangeAdd, whatsmeow.ParticipantChangeRemove, whatsmeow.ParticipantChangePromote, whatsmeow.ParticipantChangeDemote:
+		default:
+			http.Error(w, "action must be add, remove, promote or demote", http.StatusBadRequest)
+			return
+		}
+		participants, err := parseParticipantJIDs(client, req.Participants)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		changed, err := client.UpdateGroupParticipants(jid, participants, action)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to %s participants: %v", action, err), http.StatusInternalServerError)
+			return
+		}
+		results := []GroupParticipantInfo{}
+		for _, participant := range changed {
+			results = append(results, newGroupParticipantInfo(participant))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":      true,
+			"message":      fmt.Sprintf("%s done for %d participants of %s", action, len(participants), jid),
+			"participants": results,
+		})
+	}
+}
+
+// GroupSettingsRequest is the request body for the group subject and description APIs
+type GroupSettingsRequest struct {
+	GroupJID    string `json:"group_jid"`
+	Subject     string `json:"subject"`
+	Description string `json:"description"`
+}
+
+// handleGroupSubjectAPI changes a group's subject (POST /api/groups/subject)
+func handleGroupSubjectAPI(client *whatsmeow.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req GroupSettingsRequest
+		if !decodeGroupRequest(w, r, &req) {
+			return
+		}
+		jid, ok := requireGroup(w, r, req.GroupJID)
+		if !ok {
+			return
+		}
+		if strings.TrimSpace(req.Subject) == "" {
+			http.Error(w, "subject is required", http.StatusBadRequest)
+			return
+		}
+
+		// The stored chat name follows through the group info event
+		if err := client.SetGroupName(jid, strings.TrimSpace(req.Subject)); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to change subject: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: fmt.Sprintf("Subject of %s changed", jid)})
+	}
+}
+
+// handleGroupDescriptionAPI changes a group's description; an empty description removes it (POST /api/groups/description)
+func handleGroupDescriptionAPI(client *whatsmeow.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req GroupSettingsRequest
+		if !decodeGroupRequest(w, r, &req) {
+			return
+		}
+		jid, ok := requireGroup(w, r, req.GroupJID)
+		if !ok {
+			return
+		}
+
+		if err := client.SetGroupDescription(jid, req.Description); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to change description: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: fmt.Sprintf("Description of %s changed", jid)})
+	}
+}
+
+// GroupInviteLinkRequest is the request body for the revoke invite link API
+type GroupInviteLinkRequest struct {
+	GroupJID string `json:"group_jid"`
+}
+
+// handleGroupInviteLinkAPI returns a group's invite link (GET /api/groups/invite-link?group_jid=...)
+// or revokes it and returns the new one (POST /api/groups/invite-link with {"group_jid": "..."})
+func handleGroupInviteLinkAPI(client *whatsmeow.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groupJID, revoke := r.URL.Query().Get("group_jid"), false
+		if r.Method != http.MethodGet {
+			var req GroupInviteLinkRequest
+			if !decodeGroupRequest(w, r, &req) {
+				return
+			}
+			groupJID, revoke = req.GroupJID, true
+		}
+		jid, ok := requireGroup(w, r, groupJID)
+		if !ok {
+			return
+		}
+
+		link, err := client.GetGroupInviteLink(jid, revoke)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get invite link: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"group_jid":   jid.String(),
+			"invite_link": link,
+			"revoked":     revoke,
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// GroupPin records the group a name used in the config currently resolves to
+type GroupPin struct {
+	Reference  string // the name as written in the config
+	JID        string
+	Name       string // the group's name when it was pinned
+	ResolvedAt time.Time
+}
+
+// groupCandidate is a group a name can resolve to
+type groupCandidate struct {
+	JID  string
+	Name string
+}
+
+// groupNameMatchThreshold is the lowest score (0-1) at which a group name is considered a match
+const groupNameMatchThreshold = 0.6
+
+// isGroupJID reports whether a group reference is already a JID rather than a name
+func isGroupJID(reference string) bool {
+	return strings.Contains(reference, "@")
+}
+
+// ensureGroupPinsTable creates the table of resolved group names if it doesn't exist
+func ensureGroupPinsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS group_pins (
+			reference TEXT PRIMARY KEY,
+			jid TEXT NOT NULL,
+			name TEXT DEFAULT '',
+			resolved_at TIMESTAMP
+		);
+	`)
+	return err
+}
+
+// normalizeGroupName lowercases a name and reduces it to words, dropping emoji and punctuation
+func normalizeGroupName(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// groupNameScore rates how well a group name matches a reference from 0 to 1:
+// 1 for the same words, high when one contains the other, otherwise by shared words and edit distance
+func groupNameScore(reference, name string) float64 {
+	reference, name = normalizeGroupName(reference), normalizeGroupName(name)
+	if reference == "" || name == "" {
+		return 0
+	}
+	if reference == name {
+		return 1
+	}
+
+	shorter, longer := len(reference), len(name)
+	if shorter > longer {
+		shorter, longer = longer, shorter
+	}
+	if strings.Contains(name, reference) || strings.Contains(reference, name) {
+		return 0.75 + 0.2*float64(shorter)/float64(longer)
+	}
+
+	nameWords := make(map[string]bool)
+	for _, word := range strings.Fields(name) {
+		nameWords[word] = true
+	}
+	referenceWords := strings.Fields(reference)
+	shared := 0
+	for _, word := range referenceWords {
+		if nameWords[word] {
+			shared++
+		}
+	}
+	wordScore := 0.8 * float64(shared) / float64(len(referenceWords))
+
+	editScore := 1 - float64(levenshteinDistance(reference, name))/float64(len([]rune(longerString(reference, name))))
+	if wordScore > editScore {
+		return wordScore
+	}
+	return editScore
+}
+
+// longerString returns the longer of two strings
+func longerString(a, b string) string {
+	if len([]rune(a)) >= len([]rune(b)) {
+		return a
+	}
+	return b
+}
+
+// levenshteinDistance returns the number of single-rune edits turning a into b
+func levenshteinDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current := make([]int, len(rb)+1)
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if previous[j]+1 < current[j] {
+				current[j] = previous[j] + 1
+			}
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+		}
+		previous = current
+	}
+	return previous[len(rb)]
+}
+
+// bestGroupMatch returns the candidate whose name best matches a reference.
+// Candidates come most recently active first, so a recreated group wins over the old one with the same name.
+func bestGroupMatch(reference string, candidates []groupCandidate) (groupCandidate, float64) {
+	var best groupCandidate
+	bestScore := 0.0
+	for _, candidate := range candidates {
+		if score := groupNameScore(reference, candidate.Name); score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best, bestScore
+}
+
+// loadGroupCandidates returns the groups known from stored chats, most recently active first
+func loadGroupCandidates(db *sql.DB) ([]groupCandidate, error) {
+	rows, err := db.Query(`
+		SELECT jid, COALESCE(name, '') FROM chats
+		WHERE jid LIKE '%@g.us'
+		ORDER BY last_message_time DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query groups: %v", err)
+	}
+	defer rows.Close()
+
+	var candidates []groupCandidate
+	for rows.Next() {
+		var candidate groupCandidate
+		if err := rows.Scan(&candidate.JID, &candidate.Name); err != nil {
+			return nil, fmt.Errorf("failed to scan group: %v", err)
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+// getGroupPin returns the pinned group of a reference, or nil if it was never resolved
+func getGroupPin(db *sql.DB, reference string) (*GroupPin, error) {
+	pin := GroupPin{Reference: reference}
+	var resolvedAt sql.NullTime
+	err := db.QueryRow("SELECT jid, COALESCE(name, ''), resolved_at FROM group_pins WHERE reference = ?", reference).
+		Scan(&pin.JID, &pin.Name, &resolvedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pin.ResolvedAt = resolvedAt.Time
+	return &pin, nil
+}
+
+// saveGroupPin pins a reference to a group
+func saveGroupPin(db *sql.DB, reference string, group groupCandidate) error {
+	_, err := db.Exec(`
+		INSERT INTO group_pins (reference, jid, name, resolved_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(reference) DO UPDATE SET jid = excluded.jid, name = excluded.name, resolved_at = excluded.resolved_at
+	`, reference, group.JID, group.Name, time.Now())
+	return err
+}
+
+// resolveChatArgument returns the JID of a chat given on the command line as JID, phone number or group name
+func resolveChatArgument(chat string) (string, error) {
+	chat = strings.TrimSpace(chat)
+	switch {
+	case chat == "":
+		return "", fmt.Errorf("--chat is required")
+	case strings.Contains(chat, "@"):
+		return chat, nil
+	case strings.Trim(chat, "+0123456789 -()") == "":
+		return recipientConfigKey(chat) + "@s.whatsapp.net", nil
+	default:
+		return resolveGroupReference(chat)
+	}
+}
+
+// resolveGroupReference returns the JID of a group referenced by JID or by name.
+// Names use the group pinned by the bridge, or are matched against stored chats and pinned on first use.
+func resolveGroupReference(reference string) (string, error) {
+	reference = strings.TrimSpace(reference)
+	if reference == "" || isGroupJID(reference) {
+		return reference, nil
+	}
+
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		return "", fmt.Errorf("failed to open message database: %v", err)
+	}
+	defer db.Close()
+
+	if err := ensureGroupPinsTable(db); err != nil {
+		return "", fmt.Errorf("failed to create group pins table: %v", err)
+	}
+	pin, err := getGroupPin(db, reference)
+	if err != nil {
+		return "", fmt.Errorf("failed to read group pin: %v", err)
+	}
+	if pin != nil {
+		return pin.JID, nil
+	}
+
+	candidates, err := loadGroupCandidates(db)
+	if err != nil {
+		return "", err
+	}
+	best, score := bestGroupMatch(reference, candidates)
+	if score < groupNameMatchThreshold {
+		return "", fmt.Errorf("no group name matches %q", reference)
+	}
+	if err := saveGroupPin(db, reference, best); err != nil {
+		return "", fmt.Errorf("failed to pin group: %v", err)
+	}
+	return best.JID, nil
+}
+
+// configuredGroupReferences returns the group names (not JIDs) used in the config file and DAILY_SUMMARY_GROUP_JID
+func configuredGroupReferences() ([]string, error) {
+	config, err := readBridgeConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var references []string
+	for reference := range config.Groups {
+		if reference != "default" && !isGroupJID(reference) {
+			references = append(references, reference)
+		}
+	}
+	if reference := strings.TrimSpace(os.Getenv("DAILY_SUMMARY_GROUP_JID")); reference != "" && !isGroupJID(reference) {
+		references = append(references, reference)
+	}
+	return references, nil
+}
+
+// pinGroupReferences resolves every configured group name against the candidates and pins the result.
+// A pinned group keeps its pin while its name still matches at least as well as any other group;
+// when it was renamed away from the reference, the best matching group (e.g. a recreated one) takes over.
+// References nothing matches keep their previous pin.
+func pinGroupReferences(db *sql.DB, candidates []groupCandidate, logger waLog.Logger) error {
+	references, err := configuredGroupReferences()
+	if err != nil {
+		return err
+	}
+	if len(references) == 0 {
+		return nil
+	}
+	if err := ensureGroupPinsTable(db); err != nil {
+		return fmt.Errorf("failed to create group pins table: %v", err)
+	}
+
+	for _, reference := range references {
+		pin, err := getGroupPin(db, reference)
+		if err != nil {
+			return fmt.Errorf("failed to read group pin: %v", err)
+		}
+
+		best, score := bestGroupMatch(reference, candidates)
+		if score < groupNameMatchThreshold {
+			if pin == nil {
+				logger.Warnf("No group name matches %q from the config", reference)
+			} else {
+				logger.Warnf("No group name matches %q anymore, keeping %s (%s)", reference, pin.JID, pin.Name)
+			}
+			continue
+		}
+
+		if pin != nil && pin.JID != best.JID {
+			for _, candidate := range candidates {
+				if candidate.JID == pin.JID && groupNameScore(reference, candidate.Name) >= score {
+					best = candidate
+					break
+				}
+			}
+		}
+
+		if pin == nil || pin.JID != best.JID {
+			logger.Infof("Group %q resolved to %s (%s)", reference, best.JID, best.Name)
+		}
+		if err := saveGroupPin(db, reference, best); err != nil {
+			return fmt.Errorf("failed to pin group: %v", err)
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -15,6 +16,7 @@ import (
 
 // ImportProgress represents the progress tracking for historical import
 type ImportProgress struct {
+	RunID             string            `json:"run_id"` // tags the episodes created, for the report and rollback
 	StartDate         string            `json:"start_date"`
 	EndDate           string            `json:"end_date"`
 	GroupJID          string            `json:"group_jid"`
@@ -36,24 +38,29 @@ type ImportStats struct {
 }
 
 const (
-	progressFile = "store/import-progress.json"
 	defaultDelay = 2 * time.Second
 )
 
 var (
-	groupJID      = flag.String("group-jid", "", "WhatsApp group JID to import (required)")
-	startDate     = flag.String("start-date", "", "Start date in YYYY-MM-DD format")
-	endDate       = flag.String("end-date", "", "End date in YYYY-MM-DD format")
-	daysBack      = flag.Int("days-back", 0, "Number of days back to import from today")
-	delaySeconds  = flag.Int("delay", 2, "Delay in seconds between processing each day")
-	resume        = flag.Bool("resume", false, "Resume interrupted import from progress file")
-	dryRun        = flag.Bool("dry-run", false, "Show what would be imported without actually processing")
-	skipGraphiti  = flag.Bool("skip-graphiti", false, "Skip adding episodes to Graphiti (only process messages)")
-	timezone      = flag.String("timezone", "America/Sao_Paulo", "Timezone for date processing")
-	verbose       = flag.Bool("verbose", false, "Enable verbose logging")
+	groupJID     = flag.String("group-jid", "", "WhatsApp group JID to import (required)")
+	startDate    = flag.String("start-date", "", "Start date in YYYY-MM-DD format")
+	endDate      = flag.String("end-date", "", "End date in YYYY-MM-DD format")
+	daysBack     = flag.Int("days-back", 0, "Number of days back to import from today")
+	delaySeconds = flag.Int("delay", 2, "Delay in seconds between processing each day")
+	resume       = flag.Bool("resume", false, "Resume interrupted import from progress file")
+	dryRun       = flag.Bool("dry-run", false, "Show what would be imported without actually processing")
+	skipGraphiti = flag.Bool("skip-graphiti", false, "Skip adding episodes to Graphiti (only process messages)")
+	timezone     = flag.String("timezone", "America/Sao_Paulo", "Timezone for date processing")
+	verbose      = flag.Bool("verbose", false, "Enable verbose logging")
 )
 
 func main() {
+	// report and rollback work on a finished run and take their own flags
+	if len(os.Args) > 1 && (os.Args[1] == "report" || os.Args[1] == "rollback") {
+		runImportRunCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	flag.Parse()
 
 	// Setup logger with appropriate level
@@ -61,10 +68,16 @@ func main() {
 	if *verbose {
 		logLevel = "DEBUG"
 	}
-	logger := waLog.Stdout("HistoricalImport", logLevel, true)
+	logger := newLogger("HistoricalImport", logLevel)
 
 	logger.Infof("Starting WhatsApp Historical Import to Graphiti")
 
+	// Catch template mistakes before spending any LLM calls
+	if err := validatePromptTemplates(); err != nil {
+		logger.Errorf("Invalid prompt template: %v", err)
+		os.Exit(1)
+	}
+
 	// Validate required parameters
 	if err := validateParameters(); err != nil {
 		logger.Errorf("Parameter validation failed: %v", err)
@@ -84,6 +97,7 @@ func main() {
 	}
 
 	logger.Infof("Import configuration:")
+	logger.Infof("  Run ID: %s", progress.RunID)
 	logger.Infof("  Group JID: %s", progress.GroupJID)
 	logger.Infof("  Date range: %s to %s", progress.StartDate, progress.EndDate)
 	logger.Infof("  Timezone: %s", *timezone)
@@ -128,6 +142,20 @@ func main() {
 		return
 	}
 
+	// Record the run so its episodes can be reported on and rolled back
+	runsDB, err := openImportRunsDB()
+	if err != nil {
+		logger.Errorf("Failed to open import runs: %v", err)
+		os.Exit(1)
+	}
+	defer runsDB.Close()
+	run := ImportRun{ID: progress.RunID, GroupJID: progress.GroupJID, StartDate: progress.StartDate, EndDate: progress.EndDate, StartedAt: progress.StartTime}
+	if err := startImportRun(runsDB, run); err != nil {
+		logger.Errorf("Failed to record import run: %v", err)
+		os.Exit(1)
+	}
+	ctx = withImportRun(ctx, progress.RunID)
+
 	// Get group name for better organization
 	groupName := getGroupName(progress.GroupJID, logger)
 	logger.Infof("Processing group: %s", groupName)
@@ -140,13 +168,19 @@ func main() {
 			logger.Infof("Received shutdown signal, stopping gracefully...")
 			break
 		default:
+			// Respect /import pause from the admin chat or API (also persisted across restarts)
+			if !waitWhileImportPaused(ctx, logger) {
+				logger.Infof("Received shutdown signal while paused, stopping...")
+				continue
+			}
+
 			// Process this date
-			stats, err := processSingleDay(dateStr, progress.GroupJID, groupName, loc, logger)
+			stats, err := processSingleDay(withLLMSession(withLLMAuditScope(ctx, progress.GroupJID, dateStr)), dateStr, progress.GroupJID, groupName, loc, logger)
 			if err != nil {
 				logger.Errorf("Failed to process %s: %v", dateStr, err)
 				progress.FailedDates[dateStr] = err.Error()
 			} else {
-				logger.Infof("Successfully processed %s: %d messages, %d topics, %d episodes", 
+				logger.Infof("Successfully processed %s: %d messages, %d topics, %d episodes",
 					dateStr, stats.MessagesFound, stats.TopicsCreated, stats.EpisodesAdded)
 				progress.ProcessedDates = append(progress.ProcessedDates, dateStr)
 				progress.LastProcessedDate = dateStr
@@ -181,13 +215,80 @@ func main() {
 	logger.Infof("  Total messages imported: %d", progress.TotalMessages)
 	logger.Infof("  Total episodes created: %d", progress.TotalEpisodes)
 	logger.Infof("  Failed dates: %d", len(progress.FailedDates))
-	
+
 	if len(progress.FailedDates) > 0 {
 		logger.Infof("Failed dates can be retried by running the command again with --resume")
 		for failedDate, failedError := range progress.FailedDates {
 			logger.Warnf("  %s: %s", failedDate, failedError)
 		}
 	}
+
+	if err := finishImportRun(runsDB, progress.RunID, time.Now()); err != nil {
+		logger.Warnf("Failed to record the end of the import run: %v", err)
+	}
+	writeRunReport(runsDB, &run, logger)
+	logger.Infof("Undo this import with: historical-import rollback --run %s", progress.RunID)
+}
+
+// writeRunReport logs the report of an import run and saves it under store/import-reports
+func writeRunReport(db *sql.DB, run *ImportRun, logger waLog.Logger) {
+	report, err := buildImportReport(db, run)
+	if err != nil {
+		logger.Warnf("Failed to build import report: %v", err)
+		return
+	}
+	logImportReport(report, logger)
+	path, err := writeImportReport(report)
+	if err != nil {
+		logger.Warnf("Failed to write import report: %v", err)
+		return
+	}
+	logger.Infof("Import report written to %s", path)
+}
+
+// runImportRunCommand runs `report --run <id>` or `rollback --run <id> [--flag-only] [--dry-run]`
+func runImportRunCommand(command string, args []string) {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	runID := fs.String("run", "", "Import run ID, as logged at the start of the import (required)")
+	flagOnly := fs.Bool("flag-only", false, "Mark the run's episodes as rolled back instead of deleting them")
+	rollbackDryRun := fs.Bool("dry-run", false, "List the episodes that would be rolled back")
+	fs.Parse(args)
+
+	logger := newLogger("HistoricalImport", "INFO")
+	if *runID == "" {
+		logger.Errorf("--run is required")
+		os.Exit(1)
+	}
+
+	db, err := openImportRunsDB()
+	if err != nil {
+		logger.Errorf("%v", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	run, err := getImportRun(db, *runID)
+	if err != nil {
+		logger.Errorf("Failed to load import run: %v", err)
+		os.Exit(1)
+	}
+	if run == nil {
+		logger.Errorf("No import run %s", *runID)
+		os.Exit(1)
+	}
+
+	if command == "report" {
+		writeRunReport(db, run, logger)
+		return
+	}
+
+	if err := rollbackImportRun(db, run, *flagOnly, *rollbackDryRun, logger); err != nil {
+		logger.Errorf("Rollback failed: %v", err)
+		os.Exit(1)
+	}
+	if !*rollbackDryRun {
+		logger.Infof("Import run %s rolled back; its days can be imported again with a new run", run.ID)
+	}
 }
 
 func validateParameters() error {
@@ -224,7 +325,7 @@ func validateParameters() error {
 func loadOrCreateProgress() (*ImportProgress, error) {
 	if *resume {
 		// Try to load existing progress
-		data, err := os.ReadFile(progressFile)
+		data, err := os.ReadFile(importProgressFile)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read progress file for resume: %v", err)
 		}
@@ -233,16 +334,22 @@ func loadOrCreateProgress() (*ImportProgress, error) {
 		if err := json.Unmarshal(data, &progress); err != nil {
 			return nil, fmt.Errorf("failed to parse progress file: %v", err)
 		}
+		// Progress files from before runs were tracked continue as a new run
+		if progress.RunID == "" {
+			progress.RunID = newImportRunID(time.Now())
+		}
 
 		return &progress, nil
 	}
 
 	// Create new progress
+	now := time.Now()
 	progress := &ImportProgress{
+		RunID:          newImportRunID(now),
 		GroupJID:       *groupJID,
 		ProcessedDates: make([]string, 0),
 		FailedDates:    make(map[string]string),
-		StartTime:      time.Now(),
+		StartTime:      now,
 	}
 
 	// Calculate date range
@@ -270,7 +377,7 @@ func saveProgress(progress *ImportProgress) error {
 		return fmt.Errorf("failed to marshal progress: %v", err)
 	}
 
-	return os.WriteFile(progressFile, data, 0644)
+	return os.WriteFile(importProgressFile, data, 0644)
 }
 
 func generateDateRange(startStr, endStr string, loc *time.Location) ([]string, error) {
@@ -312,7 +419,7 @@ func filterProcessedDates(allDates, processedDates []string) []string {
 	return remaining
 }
 
-func processSingleDay(dateStr, groupJID, groupName string, loc *time.Location, logger waLog.Logger) (*ImportStats, error) {
+func processSingleDay(ctx context.Context, dateStr, groupJID, groupName string, loc *time.Location, logger waLog.Logger) (*ImportStats, error) {
 	startTime := time.Now()
 
 	// Parse the date and create time range for the day
@@ -324,8 +431,18 @@ func processSingleDay(dateStr, groupJID, groupName string, loc *time.Location, l
 	startOfDay := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
 	endOfDay := time.Date(date.Year(), date.Month(), date.Day(), 23, 59, 59, 999999999, loc)
 
-	logger.Infof("Processing %s (%s to %s)", dateStr, 
-		startOfDay.Format("2006-01-02 15:04:05"), 
+	// With PII_REDACTION=true the prompts leave without personal data, which the answers get back
+	config, err := readBridgeConfig()
+	if err != nil {
+		return nil, err
+	}
+	ctx, err = withPIIRedaction(ctx, config.Redaction.Names, config.Redaction.Patterns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up PII redaction: %v", err)
+	}
+
+	logger.Infof("Processing %s (%s to %s)", dateStr,
+		startOfDay.Format("2006-01-02 15:04:05"),
 		endOfDay.Format("2006-01-02 15:04:05"))
 
 	// Get messages from the database
@@ -346,6 +463,7 @@ func processSingleDay(dateStr, groupJID, groupName string, loc *time.Location, l
 	}
 
 	logger.Infof("Found %d messages for %s", len(messages), dateStr)
+	messages = collapseDuplicatesForPrompt(messages, groupJID, logger)
 
 	// Skip Graphiti processing if requested
 	if *skipGraphiti {
@@ -355,7 +473,7 @@ func processSingleDay(dateStr, groupJID, groupName string, loc *time.Location, l
 	}
 
 	// Segment messages by topic
-	topicSegments, err := segmentMessagesByTopic(messages, groupName, dateStr, logger)
+	topicSegments, err := segmentMessagesByTopic(ctx, messages, groupName, dateStr, logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to segment messages by topic: %v", err)
 	}
@@ -363,10 +481,10 @@ func processSingleDay(dateStr, groupJID, groupName string, loc *time.Location, l
 	stats.TopicsCreated = len(topicSegments)
 	logger.Infof("Segmented into %d topics", stats.TopicsCreated)
 
-	// Add episodes to Graphiti
-	err = addEpisodesToGraphiti(topicSegments, groupName, dateStr, logger)
+	// Add episodes to the knowledge sink (Graphiti by default)
+	err = addEpisodesToKnowledgeSink(ctx, topicSegments, groupJID, groupName, dateStr, logger)
 	if err != nil {
-		return nil, fmt.Errorf("failed to add episodes to Graphiti: %v", err)
+		return nil, fmt.Errorf("failed to add episodes to the knowledge sink: %v", err)
 	}
 
 	stats.EpisodesAdded = len(topicSegments)
@@ -388,4 +506,4 @@ func setupGracefulShutdown(logger waLog.Logger) (context.Context, context.Cancel
 	}()
 
 	return ctx, cancel
-}
\ No newline at end of file
+}
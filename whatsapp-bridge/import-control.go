@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const (
+	importProgressFile = "store/import-progress.json"
+	importControlFile  = "store/import-control.json"
+
+	importPausePollInterval = 5 * time.Second
+)
+
+// ImportControl is the persisted pause state of the historical import, shared between the bridge and the import process
+type ImportControl struct {
+	Paused    bool      `json:"paused"`
+	UpdatedBy string    `json:"updated_by"` // "admin-chat" or "api"
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// loadImportControl reads the pause state; a missing file means the import is not paused
+func loadImportControl() (*ImportControl, error) {
+	data, err := os.ReadFile(importControlFile)
+	if os.IsNotExist(err) {
+		return &ImportControl{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import control file: %v", err)
+	}
+
+	var control ImportControl
+	if err := json.Unmarshal(data, &control); err != nil {
+		return nil, fmt.Errorf("failed to parse import control file: %v", err)
+	}
+	return &control, nil
+}
+
+// setImportPaused persists the pause state so running and future imports pick it up
+func setImportPaused(paused bool, updatedBy string) error {
+	if err := os.MkdirAll("store", 0755); err != nil {
+		return fmt.Errorf("failed to create store directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(ImportControl{Paused: paused, UpdatedBy: updatedBy, UpdatedAt: time.Now()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal import control: %v", err)
+	}
+	return os.WriteFile(importControlFile, data, 0644)
+}
+
+// waitWhileImportPaused blocks while the import is paused; it returns false if ctx was cancelled while waiting
+func waitWhileImportPaused(ctx context.Context, logger waLog.Logger) bool {
+	logged := false
+	for {
+		control, err := loadImportControl()
+		if err != nil {
+			// An unreadable control file shouldn't stall the import forever
+			logger.Warnf("Ignoring import control file: %v", err)
+			return true
+		}
+		if !control.Paused {
+			if logged {
+				logger.Infof("Import resumed")
+			}
+			return true
+		}
+
+		if !logged {
+			logger.Infof("Import paused (by %s at %s), waiting for /import resume...", control.UpdatedBy, control.UpdatedAt.Format("2006-01-02 15:04:05"))
+			logged = true
+		}
+
+		select {
+		case <-time.After(importPausePollInterval):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// importStatusText describes the pause state and the progress of the current import
+func importStatusText() string {
+	control, err := loadImportControl()
+	if err != nil {
+		return fmt.Sprintf("❌ %v", err)
+	}
+
+	state := "running"
+	if control.Paused {
+		state = fmt.Sprintf("paused (by %s at %s)", control.UpdatedBy, control.UpdatedAt.Format("2006-01-02 15:04"))
+	}
+
+	var progress struct {
+		GroupJID          string            `json:"group_jid"`
+		StartDate         string            `json:"start_date"`
+		EndDate           string            `json:"end_date"`
+		LastProcessedDate string            `json:"last_processed_date"`
+		ProcessedDates    []string          `json:"processed_dates"`
+		FailedDates       map[string]string `json:"failed_dates"`
+	}
+	data, err := os.ReadFile(importProgressFile)
+	if err != nil || json.Unmarshal(data, &progress) != nil {
+		return fmt.Sprintf("Import: %s\nNo import progress recorded", state)
+	}
+
+	return fmt.Sprintf("Import: %s\nGroup: %s\nRange: %s to %s\nLast processed: %s\nProcessed days: %d, failed: %d",
+		state, progress.GroupJID, progress.StartDate, progress.EndDate, progress.LastProcessedDate,
+		len(progress.ProcessedDates), len(progress.FailedDates))
+}
+
+// handleImportCommand runs an "/import pause|resume|status" admin command and returns the reply
+func handleImportCommand(command, updatedBy string) string {
+	fields := strings.Fields(command)
+	action := "status"
+	if len(fields) > 1 {
+		action = strings.ToLower(fields[1])
+	}
+
+	switch action {
+	case "pause", "resume":
+		if err := setImportPaused(action == "pause", updatedBy); err != nil {
+			return fmt.Sprintf("❌ %v", err)
+		}
+		if action == "pause" {
+			return "⏸️ Historical import paused. It will stop after the current day and stay paused across restarts until /import resume."
+		}
+		return "▶️ Historical import resumed."
+	case "status":
+		return importStatusText()
+	default:
+		return "Usage: /import pause | resume | status"
+	}
+}
+
+// isImportCommand reports whether a message is an /import admin command
+func isImportCommand(content string) bool {
+	fields := strings.Fields(content)
+	return len(fields) > 0 && strings.EqualFold(fields[0], "/import")
+}
+
+// handleImportControlAPI serves GET (status) and POST {"action": "pause"|"resume"} on /api/import
+func handleImportControlAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Action string `json:"action"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Action != "pause" && req.Action != "resume" {
+			http.Error(w, "Action must be pause or resume", http.StatusBadRequest)
+			return
+		}
+		if err := setImportPaused(req.Action == "pause", "api"); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	control, err := loadImportControl()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"paused":  control.Paused,
+		"message": importStatusText(),
+	})
+}
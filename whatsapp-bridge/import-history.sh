@@ -57,6 +57,8 @@ COMMANDS:
     import-month    Import specific month
     resume          Resume interrupted import
     status          Show import progress
+    report          Show the episodes an import run created per day
+    rollback        Delete the episodes an import run created
     clean           Clean progress files
     dry-run         Preview what would be imported
     help            Show this help
@@ -80,6 +82,11 @@ EXAMPLES:
     # Preview import without processing
     $0 dry-run --group-jid "YOUR_GROUP_ID@g.us" --days 7
 
+    # Report and undo an import run (its ID is logged at the start of the import)
+    $0 report --run 20240131-154502
+    $0 rollback --run 20240131-154502 --dry-run
+    $0 rollback --run 20240131-154502
+
 OPTIONS:
     --group-jid     WhatsApp group JID (required for new imports)
     --days          Number of days back to import
@@ -153,7 +160,7 @@ parse_args() {
 check_binary() {
     if [[ ! -x "$HISTORICAL_IMPORT_BIN" ]]; then
         print_error "Historical import binary not found or not executable: $HISTORICAL_IMPORT_BIN"
-        print_info "Please build it first with: go build -o historical-import historical-import.go daily-summary-utils.go claude.go"
+        print_info "Please build it first with: go build -tags sqlite_fts5 -o historical-import historical-import.go import-control.go import-runs.go daily-summary-utils.go send-limits.go send-audit.go send-policy.go read-only.go media-send.go mentions.go bridge-client.go structured-output.go json-repair.go whatsapp-format.go prompt-template.go config.go group-names.go annotations.go message-filters.go logging.go db-utils.go graphiti.go episodes.go knowledge-sink.go vector-sink.go neo4j-sink.go embeddings.go claude.go llm-provider.go redaction.go llm-audit.go llm-usage.go llm-queue.go"
         exit 1
     fi
 }
@@ -288,6 +295,9 @@ show_status() {
         TOTAL_MESSAGES=$(jq -r '.total_messages' "$PROGRESS_FILE")
         TOTAL_EPISODES=$(jq -r '.total_episodes' "$PROGRESS_FILE")
         
+        RUN_ID=$(jq -r '.run_id // ""' "$PROGRESS_FILE")
+
+        echo "Run ID: $RUN_ID"
         echo "Group JID: $GROUP_JID"
         echo "Date Range: $START_DATE to $END_DATE"
         echo "Last Processed: $LAST_PROCESSED"
@@ -331,6 +341,13 @@ dry_run() {
     fi
 }
 
+# Report or roll back an import run; arguments go to the binary as is
+# (--run <id>, and for rollback --flag-only or --dry-run)
+import_run_command() {
+    check_binary
+    $HISTORICAL_IMPORT_BIN "$@"
+}
+
 # Clean progress files
 clean_progress() {
     if [[ -f "$PROGRESS_FILE" ]]; then
@@ -372,6 +389,9 @@ case ${1:-help} in
         shift
         dry_run "$@"
         ;;
+    report|rollback)
+        import_run_command "$@"
+        ;;
     clean)
         clean_progress
         ;;
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Directory the report of each historical import run is written to
+const importReportsDir = "store/import-reports"
+
+// How a rolled back run was undone
+const (
+	rollbackModeDeleted = "deleted" // episodes removed from the knowledge sink and the local tables
+	rollbackModeFlagged = "flagged" // episodes kept, but marked as rolled back
+)
+
+// ImportRun is one historical import, from its start to its last resume
+type ImportRun struct {
+	ID           string     `json:"id"`
+	GroupJID     string     `json:"group_jid"`
+	StartDate    string     `json:"start_date"`
+	EndDate      string     `json:"end_date"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	RolledBackAt *time.Time `json:"rolled_back_at,omitempty"`
+	RollbackMode string     `json:"rollback_mode,omitempty"`
+}
+
+// ImportReportEpisode is an episode created by an import run
+type ImportReportEpisode struct {
+	UUID         string `json:"uuid"`
+	Name         string `json:"name"`
+	Topic        string `json:"topic"`
+	Sink         string `json:"sink"`
+	MessageCount int    `json:"message_count"`
+}
+
+// ImportReportDay lists the episodes an import run created for one day. OtherEpisodes are the day's episodes
+// created by something else (the daily summary, another import): when there are any, the day was ingested twice.
+type ImportReportDay struct {
+	Date          string                `json:"date"`
+	Episodes      []ImportReportEpisode `json:"episodes"`
+	OtherEpisodes []string              `json:"other_episodes,omitempty"`
+}
+
+// ImportReport lists what an import run created, day by day
+type ImportReport struct {
+	Run             ImportRun         `json:"run"`
+	TotalEpisodes   int               `json:"total_episodes"`
+	DuplicatedDays  int               `json:"duplicated_days"`
+	Days            []ImportReportDay `json:"days"`
+	GeneratedAt     time.Time         `json:"generated_at"`
+	EpisodesFlagged bool              `json:"episodes_flagged,omitempty"` // the run was rolled back by flagging its episodes
+}
+
+// ensureImportRunsTable creates the table of historical import runs
+func ensureImportRunsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS import_runs (
+			id TEXT PRIMARY KEY,
+			group_jid TEXT,
+			start_date TEXT,
+			end_date TEXT,
+			started_at TIMESTAMP,
+			finished_at TIMESTAMP,
+			rolled_back_at TIMESTAMP,
+			rollback_mode TEXT DEFAULT ''
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_graphiti_episodes_import_run ON graphiti_episodes (import_run);
+	`)
+	return err
+}
+
+// openImportRunsDB opens messages.db with the episode and import run tables in place
+func openImportRunsDB() (*sql.DB, error) {
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message database: %v", err)
+	}
+	if err := ensureEpisodeTables(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create episode tables: %v", err)
+	}
+	if err := ensureImportRunsTable(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create import runs table: %v", err)
+	}
+	return db, nil
+}
+
+// newImportRunID returns the ID of a new import run, its start time (e.g. 20240131-154502)
+func newImportRunID(now time.Time) string {
+	return now.Format("20060102-150405")
+}
+
+// startImportRun records an import run; a resumed run keeps its original record
+func startImportRun(db *sql.DB, run ImportRun) error {
+	_, err := db.Exec(
+		"INSERT OR IGNORE INTO import_runs (id, group_jid, start_date, end_date, started_at) VALUES (?, ?, ?, ?, ?)",
+		run.ID, run.GroupJID, run.StartDate, run.EndDate, run.StartedAt,
+	)
+	return err
+}
+
+// finishImportRun records when an import run (or its last resume) ended
+func finishImportRun(db *sql.DB, runID string, finishedAt time.Time) error {
+	_, err := db.Exec("UPDATE import_runs SET finished_at = ? WHERE id = ?", finishedAt, runID)
+	return err
+}
+
+// getImportRun returns an import run; it returns nil when there is no such run
+func getImportRun(db *sql.DB, runID string) (*ImportRun, error) {
+	run := &ImportRun{}
+	var finishedAt, rolledBackAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT id, COALESCE(group_jid, ''), COALESCE(start_date, ''), COALESCE(end_date, ''), started_at,
+			finished_at, rolled_back_at, COALESCE(rollback_mode, '')
+		FROM import_runs WHERE id = ?
+	`, runID).Scan(&run.ID, &run.GroupJID, &run.StartDate, &run.EndDate, &run.StartedAt, &finishedAt, &rolledBackAt, &run.RollbackMode)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	if rolledBackAt.Valid {
+		run.RolledBackAt = &rolledBackAt.Time
+	}
+	return run, nil
+}
+
+// buildImportReport lists the episodes an import run created per day, with the day's episodes from other sources
+func buildImportReport(db *sql.DB, run *ImportRun) (*ImportReport, error) {
+	report := &ImportReport{Run: *run, Days: []ImportReportDay{}, GeneratedAt: time.Now(), EpisodesFlagged: run.RollbackMode == rollbackModeFlagged}
+
+	rows, err := db.Query(`
+		SELECT uuid, date, COALESCE(name, ''), COALESCE(topic, ''), COALESCE(sink, 'graphiti'), COALESCE(message_count, 0)
+		FROM graphiti_episodes
+		WHERE import_run = ?
+		ORDER BY date, created_at
+	`, run.ID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var episode ImportReportEpisode
+		var date string
+		if err := rows.Scan(&episode.UUID, &date, &episode.Name, &episode.Topic, &episode.Sink, &episode.MessageCount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if len(report.Days) == 0 || report.Days[len(report.Days)-1].Date != date {
+			report.Days = append(report.Days, ImportReportDay{Date: date})
+		}
+		day := &report.Days[len(report.Days)-1]
+		day.Episodes = append(day.Episodes, episode)
+		report.TotalEpisodes++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range report.Days {
+		day := &report.Days[i]
+		rows, err := db.Query(`
+			SELECT uuid FROM graphiti_episodes
+			WHERE group_jid = ? AND date = ? AND COALESCE(import_run, '') != ? AND rolled_back_at IS NULL
+			ORDER BY created_at
+		`, run.GroupJID, day.Date, run.ID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var uuid string
+			if err := rows.Scan(&uuid); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			day.OtherEpisodes = append(day.OtherEpisodes, uuid)
+		}
+		rows.Close()
+		if len(day.OtherEpisodes) > 0 {
+			report.DuplicatedDays++
+		}
+	}
+	return report, nil
+}
+
+// writeImportReport saves a report as store/import-reports/<run>.json and returns its path
+func writeImportReport(report *ImportReport) (string, error) {
+	if err := os.MkdirAll(importReportsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create reports directory: %v", err)
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal report: %v", err)
+	}
+	path := filepath.Join(importReportsDir, report.Run.ID+".json")
+	return path, os.WriteFile(path, data, 0644)
+}
+
+// logImportReport prints a report day by day, warning about the days ingested twice
+func logImportReport(report *ImportReport, logger waLog.Logger) {
+	logger.Infof("Import run %s (%s, %s to %s): %d episodes over %d days",
+		report.Run.ID, report.Run.GroupJID, report.Run.StartDate, report.Run.EndDate, report.TotalEpisodes, len(report.Days))
+	for _, day := range report.Days {
+		logger.Infof("  %s: %d episodes", day.Date, len(day.Episodes))
+		for _, episode := range day.Episodes {
+			logger.Infof("    %s  %s (%d messages)", episode.UUID, episode.Topic, episode.MessageCount)
+		}
+		if len(day.OtherEpisodes) > 0 {
+			logger.Warnf("    %d other episodes exist for this day, possibly duplicates: %v", len(day.OtherEpisodes), day.OtherEpisodes)
+		}
+	}
+	if report.DuplicatedDays > 0 {
+		logger.Warnf("%d days already had episodes from another run or the daily summary; "+
+			"remove the extra ones with `rollback --run <id>` or reingest those days", report.DuplicatedDays)
+	}
+}
+
+// rollbackImportRun undoes an import run: its episodes are deleted from their knowledge sink and the local tables,
+// or with flagOnly kept and marked as rolled back. A run whose deletes partly failed can be rolled back again.
+func rollbackImportRun(db *sql.DB, run *ImportRun, flagOnly, dryRun bool, logger waLog.Logger) error {
+	episodes, err := db.Query("SELECT uuid, COALESCE(name, ''), COALESCE(sink, 'graphiti') FROM graphiti_episodes WHERE import_run = ?", run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list the run's episodes: %v", err)
+	}
+	var records []EpisodeRecord
+	for episodes.Next() {
+		var record EpisodeRecord
+		if err := episodes.Scan(&record.UUID, &record.Name, &record.Sink); err != nil {
+			episodes.Close()
+			return err
+		}
+		records = append(records, record)
+	}
+	episodes.Close()
+	logger.Infof("Import run %s has %d recorded episodes", run.ID, len(records))
+
+	if dryRun {
+		for _, record := range records {
+			logger.Infof("DRY RUN: would roll back episode %s (%s) in %s", record.UUID, record.Name, record.Sink)
+		}
+		return nil
+	}
+
+	now := time.Now()
+	if flagOnly {
+		if _, err := db.Exec("UPDATE graphiti_episodes SET rolled_back_at = ? WHERE import_run = ? AND rolled_back_at IS NULL", now, run.ID); err != nil {
+			return fmt.Errorf("failed to flag episodes: %v", err)
+		}
+		_, err := db.Exec("UPDATE import_runs SET rolled_back_at = ?, rollback_mode = ? WHERE id = ?", now, rollbackModeFlagged, run.ID)
+		return err
+	}
+
+	var failed int
+	for _, record := range records {
+		// Episodes are deleted from the sink they were stored in, even if KNOWLEDGE_SINK changed since
+		sink, err := newKnowledgeSink(record.Sink)
+		if err != nil {
+			return err
+		}
+		// Delete from the sink first so a failure leaves the local record for the next attempt
+		if err := sink.DeleteEpisode(record.UUID); err != nil {
+			logger.Errorf("Failed to delete episode %s from %s: %v", record.UUID, sink.Name(), err)
+			failed++
+			continue
+		}
+		if err := deleteEpisodeRecord(db, record.UUID); err != nil {
+			logger.Errorf("Failed to delete episode record %s: %v", record.UUID, err)
+			failed++
+			continue
+		}
+		logger.Infof("Deleted episode %s (%s)", record.UUID, record.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d episodes couldn't be deleted; run the rollback again to retry", failed, len(records))
+	}
+
+	_, err = db.Exec("UPDATE import_runs SET rolled_back_at = ?, rollback_mode = ? WHERE id = ?", now, rollbackModeDeleted, run.ID)
+	return err
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const (
+	// Importance thresholds between the summary depth tiers
+	importanceHighThreshold = 0.5
+	importanceLowThreshold  = 0.15
+
+	// Reply rate is learned over this many days, and only once the group had this many active days
+	importanceLookbackDays   = 30
+	importanceMinActiveDays  = 5
+	defaultSummaryImportance = 1.0
+)
+
+// SummaryDepth controls how much LLM work a group gets in the daily pipeline
+type SummaryDepth struct {
+	Level      string  // "high", "medium" or "low"
+	Importance float64 // score between 0 and 1
+	Source     string  // "config", "reply_rate" or "default"
+	MaxWords   int     // summary length limit, 0 for no limit
+	MaxChars   int     // configured character limit (max_summary_chars), 0 for none
+	Segment    bool    // run topic segmentation and create knowledge episodes
+}
+
+// summaryDepthForImportance maps an importance score to a depth tier
+func summaryDepthForImportance(importance float64) SummaryDepth {
+	switch {
+	case importance >= importanceHighThreshold:
+		return SummaryDepth{Level: "high", Importance: importance, Segment: true}
+	case importance >= importanceLowThreshold:
+		return SummaryDepth{Level: "medium", Importance: importance, MaxWords: 300, Segment: true}
+	default:
+		return SummaryDepth{Level: "low", Importance: importance, MaxWords: 120}
+	}
+}
+
+// getSummaryDepth returns the depth for a group from its configured importance, or from my reply rate when unset,
+// with its configured character limit. Errors fall back to full depth so a broken config never silently drops summaries.
+func getSummaryDepth(groupJID string, logger waLog.Logger) SummaryDepth {
+	config, err := loadBridgeConfig()
+	if err != nil {
+		logger.Warnf("Failed to load config, using full summary depth: %v", err)
+		config = &BridgeConfig{}
+	}
+
+	depth := getSummaryDepthByImportance(config, groupJID, logger)
+	if maxChars := config.getGroupConfig(groupJID).MaxSummaryChars; maxChars != nil && *maxChars > 0 {
+		depth.MaxChars = *maxChars
+	}
+	return depth
+}
+
+// getSummaryDepthByImportance returns the depth tier from the configured importance, or from my reply rate when unset
+func getSummaryDepthByImportance(config *BridgeConfig, groupJID string, logger waLog.Logger) SummaryDepth {
+	if importance := config.getGroupConfig(groupJID).Importance; importance != nil {
+		depth := summaryDepthForImportance(*importance)
+		depth.Source = "config"
+		return depth
+	}
+
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		logger.Warnf("Failed to open message database, using full summary depth: %v", err)
+		return defaultSummaryDepth()
+	}
+	defer db.Close()
+
+	replyRate, activeDays, err := getReplyRate(db, groupJID, time.Now().AddDate(0, 0, -importanceLookbackDays))
+	if err != nil {
+		logger.Warnf("Failed to compute reply rate, using full summary depth: %v", err)
+		return defaultSummaryDepth()
+	}
+	if activeDays < importanceMinActiveDays {
+		return defaultSummaryDepth()
+	}
+
+	depth := summaryDepthForImportance(replyRate)
+	depth.Source = "reply_rate"
+	return depth
+}
+
+// defaultSummaryDepth is used for groups without a configured importance or enough history to learn one
+func defaultSummaryDepth() SummaryDepth {
+	depth := summaryDepthForImportance(defaultSummaryImportance)
+	depth.Source = "default"
+	return depth
+}
+
+// getReplyRate returns the share of days since a time on which others wrote in the chat and I replied,
+// together with the number of days on which others wrote. Automated messages don't count as replies.
+func getReplyRate(db *sql.DB, chatJID string, since time.Time) (float64, int, error) {
+	if err := ensureMessageTypeColumn(db); err != nil {
+		return 0, 0, err
+	}
+
+	var activeDays, repliedDays int
+	err := db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(replied), 0) FROM (
+			SELECT substr(timestamp, 1, 10) AS day,
+				MAX(CASE WHEN is_from_me = 1 AND COALESCE(origin, '') = '' THEN 1 ELSE 0 END) AS replied,
+				MAX(CASE WHEN is_from_me = 0 THEN 1 ELSE 0 END) AS others
+			FROM messages
+			WHERE chat_jid = ? AND timestamp >= ? AND COALESCE(message_type, '') = ''
+			GROUP BY day
+		) WHERE others = 1
+	`, chatJID, since).Scan(&activeDays, &repliedDays)
+	if err != nil {
+		return 0, 0, err
+	}
+	if activeDays == 0 {
+		return 0, 0, nil
+	}
+	return float64(repliedDays) / float64(activeDays), activeDays, nil
+}
+
+// applySummaryDepthToPrompt fills the {{SUMMARY_LENGTH}} placeholder, or appends the length limit to the prompt
+func applySummaryDepthToPrompt(prompt string, depth SummaryDepth) string {
+	instruction := ""
+	if depth.MaxWords > 0 {
+		instruction = fmt.Sprintf("Keep the summary under %d words and cover only the most important points.", depth.MaxWords)
+	}
+	if depth.MaxChars > 0 {
+		instruction = strings.TrimSpace(instruction + fmt.Sprintf(" The summary must not exceed %d characters.", depth.MaxChars))
+	}
+
+	if strings.Contains(prompt, "{{SUMMARY_LENGTH}}") {
+		return strings.ReplaceAll(prompt, "{{SUMMARY_LENGTH}}", instruction)
+	}
+	if instruction == "" {
+		return prompt
+	}
+	return prompt + "\n\n" + instruction
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Fixes the JSON repair can apply to an LLM answer, as recorded in llm_json_parses
+const (
+	jsonFixTrailingComma    = "trailing_comma"    // a comma before a closing brace or bracket
+	jsonFixUnescapedQuote   = "unescaped_quote"   // a quote inside a string that doesn't end it
+	jsonFixControlCharacter = "control_character" // a raw newline or tab inside a string
+	jsonFixTruncated        = "truncated"         // an answer cut off before its closing braces
+)
+
+const (
+	// Larger answers aren't repaired
+	jsonRepairMaxLength = 1 << 20
+
+	// How many earlier cut points are tried to drop a partial value at the end of a truncated answer
+	jsonRepairMaxCuts = 50
+)
+
+// repairLLMJSON returns content unchanged when it is valid JSON, otherwise a repaired version and the fixes applied.
+// When the repair doesn't yield valid JSON, the original decoding error is returned.
+func repairLLMJSON(content string) (string, []string, error) {
+	var value interface{}
+	err := json.Unmarshal([]byte(content), &value)
+	if err == nil {
+		return content, nil, nil
+	}
+
+	repaired, fixes := repairJSON(content)
+	if len(fixes) == 0 || !json.Valid([]byte(repaired)) {
+		return content, nil, err
+	}
+	return repaired, fixes, nil
+}
+
+// jsonRepairCut is a point a truncated answer can be cut back to, with the containers open there
+type jsonRepairCut struct {
+	length int
+	open   string
+}
+
+// repairJSON applies bounded, syntax-level fixes: trailing commas are dropped, quotes inside strings that
+// aren't followed by a delimiter are escaped, raw control characters in strings are escaped, and a truncated
+// answer is closed, dropping the partial value at its end when needed. Values are never invented.
+func repairJSON(content string) (string, []string) {
+	if len(content) > jsonRepairMaxLength {
+		return content, nil
+	}
+
+	var fixes []string
+	note := func(fix string) {
+		for _, applied := range fixes {
+			if applied == fix {
+				return
+			}
+		}
+		fixes = append(fixes, fix)
+	}
+
+	out := make([]byte, 0, len(content)+16)
+	var open []byte // containers open at this point, '{' or '['
+	var cuts []jsonRepairCut
+	inString, escaped := false, false
+	pendingComma := -1 // position in out of a comma with only whitespace after it
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+				out = append(out, c)
+			case c == '\\':
+				escaped = true
+				out = append(out, c)
+			case c == '"':
+				if closesJSONString(content[i+1:]) {
+					inString = false
+					out = append(out, c)
+				} else {
+					note(jsonFixUnescapedQuote)
+					out = append(out, '\\', '"')
+				}
+			case c == '\n':
+				note(jsonFixControlCharacter)
+				out = append(out, '\\', 'n')
+			case c == '\r':
+				note(jsonFixControlCharacter)
+				out = append(out, '\\', 'r')
+			case c == '\t':
+				note(jsonFixControlCharacter)
+				out = append(out, '\\', 't')
+			case c < 0x20:
+				note(jsonFixControlCharacter)
+			default:
+				out = append(out, c)
+			}
+			continue
+		}
+
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			out = append(out, c)
+			continue
+		case '"':
+			inString = true
+		case '{', '[':
+			open = append(open, c)
+			out = append(out, c)
+			cuts = append(cuts, jsonRepairCut{length: len(out), open: string(open)})
+			pendingComma = -1
+			continue
+		case '}', ']':
+			if pendingComma >= 0 {
+				note(jsonFixTrailingComma)
+				out = append(out[:pendingComma], out[pendingComma+1:]...)
+			}
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+		case ',':
+			cuts = append(cuts, jsonRepairCut{length: len(out), open: string(open)})
+			out = append(out, c)
+			pendingComma = len(out) - 1
+			continue
+		}
+		pendingComma = -1
+		out = append(out, c)
+	}
+
+	if !inString && len(open) == 0 {
+		return string(out), fixes
+	}
+
+	// Truncated: close the open string and containers, cutting back to earlier complete values if that isn't enough
+	note(jsonFixTruncated)
+	if inString {
+		if escaped {
+			out = out[:len(out)-1]
+		}
+		out = append(out, '"')
+	}
+	if closed := closeTruncatedJSON(out, string(open)); json.Valid([]byte(closed)) {
+		return closed, fixes
+	}
+	for i := len(cuts) - 1; i >= 0 && i >= len(cuts)-jsonRepairMaxCuts; i-- {
+		if closed := closeTruncatedJSON(out[:cuts[i].length], cuts[i].open); json.Valid([]byte(closed)) {
+			return closed, fixes
+		}
+	}
+	return string(out), fixes
+}
+
+// closesJSONString reports whether a quote followed by rest ends a string: the next non-space
+// character must be a delimiter, or the answer must end there
+func closesJSONString(rest string) bool {
+	rest = strings.TrimLeft(rest, " \t\r\n")
+	return rest == "" || strings.ContainsRune(",:}]", rune(rest[0]))
+}
+
+// closeTruncatedJSON drops a dangling comma or colon and closes the open containers
+func closeTruncatedJSON(out []byte, open string) string {
+	text := strings.TrimRight(string(out), " \t\r\n")
+	text = strings.TrimRight(strings.TrimSuffix(text, ","), " \t\r\n")
+
+	var closed strings.Builder
+	closed.WriteString(text)
+	for i := len(open) - 1; i >= 0; i-- {
+		if open[i] == '{' {
+			closed.WriteByte('}')
+		} else {
+			closed.WriteByte(']')
+		}
+	}
+	return closed.String()
+}
+
+// jsonParseOutcome returns the outcome of a successful parse
+func jsonParseOutcome(fixes []string) string {
+	if len(fixes) > 0 {
+		return jsonParseRepaired
+	}
+	return jsonParseValid
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KnowledgeEpisode is one topic segment of a day's conversation, ready to be stored as memory
+type KnowledgeEpisode struct {
+	UUID              string
+	Name              string
+	Topic             string
+	GroupJID          string
+	GroupName         string
+	Namespace         string // per-group namespace (Graphiti group_id)
+	Date              string
+	Body              string
+	SourceDescription string
+	Annotations       string // user corrections for the group, formatted for prompts
+	Messages          []DailySummaryMessage
+}
+
+// KnowledgeSink stores conversation episodes in a memory backend
+type KnowledgeSink interface {
+	// Name identifies the sink in logs and in the episode records
+	Name() string
+	// AddEpisode stores an episode under episode.UUID; cancelling ctx aborts it
+	AddEpisode(ctx context.Context, episode KnowledgeEpisode) error
+	// DeleteEpisode removes a previously stored episode; deleting a missing episode is not an error
+	DeleteEpisode(uuid string) error
+}
+
+// Knowledge sink names accepted in KNOWLEDGE_SINK
+const (
+	knowledgeSinkGraphiti     = "graphiti"
+	knowledgeSinkSQLiteVector = "sqlite-vector"
+	knowledgeSinkNeo4j        = "neo4j"
+)
+
+// getKnowledgeSinkName returns the configured knowledge sink (KNOWLEDGE_SINK, default graphiti)
+func getKnowledgeSinkName() string {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("KNOWLEDGE_SINK")))
+	if name == "" {
+		name = knowledgeSinkGraphiti
+	}
+	return name
+}
+
+// newKnowledgeSink creates a knowledge sink by name
+func newKnowledgeSink(name string) (KnowledgeSink, error) {
+	switch name {
+	case knowledgeSinkGraphiti:
+		return &GraphitiSink{}, nil
+	case knowledgeSinkSQLiteVector:
+		return NewSQLiteVectorSink()
+	case knowledgeSinkNeo4j:
+		return NewNeo4jSink()
+	default:
+		return nil, fmt.Errorf("unknown knowledge sink %q (expected %s, %s or %s)", name, knowledgeSinkGraphiti, knowledgeSinkSQLiteVector, knowledgeSinkNeo4j)
+	}
+}
+
+// GraphitiSink adds episodes to Graphiti by asking Claude to call the Graphiti MCP add_memory tool,
+// or through the Graphiti REST API when the LLM provider has no tools
+type GraphitiSink struct{}
+
+// Name returns the sink name
+func (sink *GraphitiSink) Name() string {
+	return knowledgeSinkGraphiti
+}
+
+// AddEpisode renders the add-episode prompt and lets Claude add the episode through the Graphiti MCP tools
+func (sink *GraphitiSink) AddEpisode(ctx context.Context, episode KnowledgeEpisode) error {
+	if !llmSupportsTools() {
+		return addGraphitiEpisodeDirect(episode)
+	}
+
+	prompt, err := loadAddEpisodePrompt(
+		episode.UUID,
+		episode.Name,
+		episode.Topic,
+		episode.GroupName,
+		episode.Namespace,
+		episode.Date,
+		episode.Body,
+		episode.SourceDescription,
+		llmSessionFromContext(ctx).Continues(),
+	)
+	if err != nil {
+		return err
+	}
+
+	// The user's corrections are passed along so they end up in the graph instead of the original mistakes
+	prompt = applyAnnotationsToPrompt(prompt, episode.Annotations)
+
+	_, err = callLLM(withLLMPurpose(ctx, "add_episode"), prompt, "mcp__graphiti")
+	return err
+}
+
+// DeleteEpisode removes the episode through the Graphiti REST API
+func (sink *GraphitiSink) DeleteEpisode(uuid string) error {
+	return deleteGraphitiEpisode(uuid)
+}
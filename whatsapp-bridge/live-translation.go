@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// LiveTranslator translates the incoming messages of the chats with a translation rule as they arrive (see
+// translation.go)
+type LiveTranslator struct {
+	rules        []translationRule
+	messageStore *MessageStore
+	logger       waLog.Logger
+}
+
+// startTranslations starts translating the messages of the chats with a translation rule, if any
+func startTranslations(messageStore *MessageStore, logger waLog.Logger) error {
+	config, err := loadBridgeConfig()
+	if err != nil {
+		return err
+	}
+	translator := &LiveTranslator{rules: loadTranslationRules(config, logger), messageStore: messageStore, logger: logger}
+	if len(translator.rules) == 0 {
+		return nil
+	}
+
+	events := eventHub.Subscribe()
+	go func() {
+		for event := range events {
+			// My own and automated messages are left out
+			if event.Type != "message" || event.IsFromMe || event.Origin != "" {
+				continue
+			}
+			if strings.IndexFunc(event.Content, unicode.IsLetter) < 0 {
+				continue
+			}
+			if rule := translationRuleFor(translator.rules, event.ChatJID); rule != nil {
+				go translator.translate(event, *rule)
+			}
+		}
+	}()
+	logger.Infof("Translating the messages of %d translation rule(s) with %s", len(translator.rules), getTranslationProvider())
+	return nil
+}
+
+// translate translates a message, stores the translation and forwards it when the rule says so
+func (translator *LiveTranslator) translate(event StreamEvent, rule translationRule) {
+	ctx := withLLMAuditScope(context.Background(), event.ChatJID, event.Timestamp.Format("2006-01-02"))
+	translation, sourceLanguage, err := translateMessage(ctx, event.Content, rule.language, translator.logger)
+	if err != nil {
+		translator.logger.Warnf("Failed to translate message %s in %s: %v", event.ID, event.ChatJID, err)
+		return
+	}
+	if translation == "" {
+		return
+	}
+
+	stored, err := translator.messageStore.seal(event.ChatJID, translation)
+	if err == nil {
+		err = storeTranslation(translator.messageStore.dbFor(event.ChatJID), event.ID, event.ChatJID, rule.language, sourceLanguage, stored, getTranslationProvider())
+	}
+	if err != nil {
+		translator.logger.Warnf("Failed to store the translation of %s: %v", event.ID, err)
+	}
+
+	if !rule.forward {
+		return
+	}
+	if readOnlyModeEnabled() {
+		translator.logger.Infof("Read-only mode is on, not forwarding the translation of %s", event.ID)
+		return
+	}
+	// Goes through the outbox, so translations arriving while disconnected or in safe mode are sent later
+	if _, err := messageOutbox.Send(SendMessageRequest{Recipient: "self", Message: formatLiveTranslation(event, sourceLanguage, translation), Origin: messageOriginTranslation}); err != nil {
+		translator.logger.Warnf("Failed to forward the translation of %s: %v", event.ID, err)
+	}
+}
+
+// formatLiveTranslation renders a translation forwarded to my self-chat
+func formatLiveTranslation(event StreamEvent, sourceLanguage, translation string) string {
+	sender := event.SenderName
+	if sender == "" {
+		sender = event.Sender
+	}
+	from := "*" + sender + "*"
+	if event.IsGroup {
+		chatName := event.ChatName
+		if chatName == "" {
+			chatName = event.ChatJID
+		}
+		from += " in *" + chatName + "*"
+	}
+	if sourceLanguage != "" {
+		from += " (" + sourceLanguage + ")"
+	}
+	text := fmt.Sprintf("🌐 %s\n\n%s", from, translation)
+	if time.Since(event.Timestamp) > time.Hour {
+		text += fmt.Sprintf("\n\n_Sent %s_", event.Timestamp.Format("Jan 2 15:04"))
+	}
+	return text
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const llmAuditDir = "store/llm-audit"
+
+// LLMAuditRecord is the exact prompt and raw response of one LLM call
+type LLMAuditRecord struct {
+	Timestamp   time.Time `json:"timestamp"`
+	GroupJID    string    `json:"group_jid,omitempty"`
+	Date        string    `json:"date"`
+	Purpose     string    `json:"purpose,omitempty"` // e.g. "daily_summary", "topic_segmentation", "add_episode", "self_chat"
+	Provider    string    `json:"provider"`
+	Model       string    `json:"model,omitempty"`
+	Tools       []string  `json:"tools,omitempty"`
+	Prompt      string    `json:"prompt"`
+	Response    string    `json:"response"`
+	RawResponse string    `json:"raw_response,omitempty"` // provider response body, when it differs from the extracted text
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+}
+
+// LLMAuditScope identifies what an LLM call was made for, carried through the context
+type LLMAuditScope struct {
+	GroupJID string
+	Date     string
+	Purpose  string
+}
+
+type llmAuditScopeKey struct{}
+
+var pruneLLMAuditOnce sync.Once
+
+// withLLMAuditScope records the group and date of the LLM calls made with ctx
+func withLLMAuditScope(ctx context.Context, groupJID, date string) context.Context {
+	scope := llmAuditScopeFromContext(ctx)
+	scope.GroupJID = groupJID
+	scope.Date = date
+	return context.WithValue(ctx, llmAuditScopeKey{}, scope)
+}
+
+// withLLMPurpose records what the LLM calls made with ctx are for
+func withLLMPurpose(ctx context.Context, purpose string) context.Context {
+	scope := llmAuditScopeFromContext(ctx)
+	scope.Purpose = purpose
+	return context.WithValue(ctx, llmAuditScopeKey{}, scope)
+}
+
+// llmAuditScopeFromContext returns the scope set on ctx, if any
+func llmAuditScopeFromContext(ctx context.Context) LLMAuditScope {
+	scope, _ := ctx.Value(llmAuditScopeKey{}).(LLMAuditScope)
+	return scope
+}
+
+// llmAuditEnabled reports whether LLM calls are recorded (LLM_AUDIT=true)
+func llmAuditEnabled() bool {
+	return os.Getenv("LLM_AUDIT") == "true"
+}
+
+// getLLMAuditRetentionDays returns how many days of records are kept (LLM_AUDIT_RETENTION_DAYS, default 30)
+func getLLMAuditRetentionDays() int {
+	if days, err := strconv.Atoi(os.Getenv("LLM_AUDIT_RETENTION_DAYS")); err == nil && days > 0 {
+		return days
+	}
+	return 30
+}
+
+// llmAuditPathSegment turns a group JID into a directory name
+func llmAuditPathSegment(value string) string {
+	if value == "" {
+		return "_none"
+	}
+	return strings.NewReplacer("@", "_", ":", "_", "/", "_").Replace(value)
+}
+
+// recordLLMAudit stores a gzipped record of an LLM call under store/llm-audit/<date>/<group>/ when auditing is enabled.
+// Failures are only reported on stderr: auditing never breaks the call it records.
+func recordLLMAudit(ctx context.Context, providerName, prompt string, tools []string, response string, callErr error, started time.Time) {
+	if !llmAuditEnabled() {
+		return
+	}
+
+	scope := llmAuditScopeFromContext(ctx)
+	record := LLMAuditRecord{
+		Timestamp:  started,
+		GroupJID:   scope.GroupJID,
+		Date:       scope.Date,
+		Purpose:    scope.Purpose,
+		Provider:   providerName,
+		Model:      getLLMModelName(providerName),
+		Tools:      tools,
+		Prompt:     prompt,
+		Response:   response,
+		DurationMs: time.Since(started).Milliseconds(),
+	}
+	if record.Date == "" {
+		record.Date = started.Format("2006-01-02")
+	}
+	if lastClaudeResponseBody != response {
+		record.RawResponse = lastClaudeResponseBody
+	}
+	if callErr != nil {
+		record.Error = callErr.Error()
+	}
+
+	if err := writeLLMAuditRecord(record); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record LLM audit: %v\n", err)
+	}
+
+	pruneLLMAuditOnce.Do(func() {
+		if err := pruneLLMAudit(getLLMAuditRetentionDays()); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to prune LLM audit records: %v\n", err)
+		}
+	})
+}
+
+// writeLLMAuditRecord writes one record as a gzipped JSON file
+func writeLLMAuditRecord(record LLMAuditRecord) error {
+	dir := filepath.Join(llmAuditDir, record.Date, llmAuditPathSegment(record.GroupJID))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create audit directory: %v", err)
+	}
+
+	name := record.Timestamp.Format("150405.000000")
+	if record.Purpose != "" {
+		name += "-" + record.Purpose
+	}
+	file, err := os.Create(filepath.Join(dir, name+".json.gz"))
+	if err != nil {
+		return fmt.Errorf("failed to create audit file: %v", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	encoder := json.NewEncoder(gz)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(record); err != nil {
+		return fmt.Errorf("failed to write audit record: %v", err)
+	}
+	return gz.Close()
+}
+
+// pruneLLMAudit removes the day directories older than the retention period
+func pruneLLMAudit(retentionDays int) error {
+	entries, err := os.ReadDir(llmAuditDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -retentionDays).Format("2006-01-02")
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", entry.Name()); err != nil {
+			continue
+		}
+		if entry.Name() < cutoff {
+			if err := os.RemoveAll(filepath.Join(llmAuditDir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readLLMAuditRecords returns the records of a day, optionally for one group, in call order
+func readLLMAuditRecords(date, groupJID string) ([]LLMAuditRecord, error) {
+	pattern := filepath.Join(llmAuditDir, date, "*", "*.json.gz")
+	if groupJID != "" {
+		pattern = filepath.Join(llmAuditDir, date, llmAuditPathSegment(groupJID), "*.json.gz")
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []LLMAuditRecord
+	for _, path := range paths {
+		record, err := readLLMAuditRecord(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Timestamp.Before(records[j].Timestamp) })
+	return records, nil
+}
+
+// readLLMAuditRecord decodes one gzipped record
+func readLLMAuditRecord(path string) (*LLMAuditRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var record LLMAuditRecord
+	if err := json.NewDecoder(gz).Decode(&record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
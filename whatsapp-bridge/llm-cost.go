@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// LLM spending is watched from the llm_usage table, which every process calling the LLM writes to: /metrics exports
+// the totals to Prometheus, LLM_COST_REPORT=true sends yesterday's costs to the admin chat every morning, and
+// LLM_COST_ALERT_USD warns there as soon as a day's spending passes a limit.
+const (
+	llmCostCheckInterval     = 10 * time.Minute
+	defaultLLMCostReportHour = 8
+)
+
+// Triggers of the cost messages in the send audit, which also tells whether today's was sent
+const (
+	llmCostReportTrigger = "llm-cost-report"
+	llmCostAlertTrigger  = "llm-cost-alert"
+)
+
+// llmUsageTotal is the usage of all calls with the same task, provider and model
+type llmUsageTotal struct {
+	Task, Provider, Model string
+	Calls, Failures       int64
+	Tokens                map[string]int64 // by kind: input, output, cache_creation, cache_read
+	CostUSD               float64
+	CostTodayUSD          float64
+}
+
+// readLLMUsageTotals sums llm_usage per task, provider and model
+func readLLMUsageTotals(db *sql.DB, today time.Time) ([]llmUsageTotal, error) {
+	if err := ensureLLMUsageTable(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`
+		SELECT task_type, provider, model, COUNT(*), SUM(CASE WHEN success THEN 0 ELSE 1 END),
+			SUM(input_tokens), SUM(output_tokens), SUM(cache_creation_tokens), SUM(cache_read_tokens), SUM(cost_usd),
+			SUM(CASE WHEN timestamp >= ? THEN cost_usd ELSE 0 END)
+		FROM llm_usage
+		GROUP BY task_type, provider, model
+		ORDER BY task_type, provider, model
+	`, today)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var totals []llmUsageTotal
+	for rows.Next() {
+		var total llmUsageTotal
+		var input, output, cacheCreation, cacheRead int64
+		if err := rows.Scan(&total.Task, &total.Provider, &total.Model, &total.Calls, &total.Failures,
+			&input, &output, &cacheCreation, &cacheRead, &total.CostUSD, &total.CostTodayUSD); err != nil {
+			return nil, err
+		}
+		total.Tokens = map[string]int64{"input": input, "output": output, "cache_creation": cacheCreation, "cache_read": cacheRead}
+		totals = append(totals, total)
+	}
+	return totals, rows.Err()
+}
+
+// prometheusLabel quotes a Prometheus label value
+func prometheusLabel(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+// handleMetrics exports the LLM calls, tokens and costs as Prometheus metrics (GET /metrics), labelled by task,
+// provider and model. The counters are totals over llm_usage, so they keep growing across restarts.
+func handleMetrics(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		now := time.Now()
+		totals, err := readLLMUsageTotals(db, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read LLM usage: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		var out strings.Builder
+		metric := func(name, kind, help string, value func(total llmUsageTotal, labels string)) {
+			fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+			for _, total := range totals {
+				labels := fmt.Sprintf("task=%s,provider=%s,model=%s", prometheusLabel(total.Task), prometheusLabel(total.Provider), prometheusLabel(total.Model))
+				value(total, labels)
+			}
+		}
+		metric("whatsapp_llm_calls_total", "counter", "LLM calls made by the bridge and the scheduled tools.", func(total llmUsageTotal, labels string) {
+			fmt.Fprintf(&out, "whatsapp_llm_calls_total{%s,status=\"ok\"} %d\n", labels, total.Calls-total.Failures)
+			fmt.Fprintf(&out, "whatsapp_llm_calls_total{%s,status=\"failed\"} %d\n", labels, total.Failures)
+		})
+		metric("whatsapp_llm_tokens_total", "counter", "Tokens used by LLM calls, by kind.", func(total llmUsageTotal, labels string) {
+			kinds := make([]string, 0, len(total.Tokens))
+			for kind := range total.Tokens {
+				kinds = append(kinds, kind)
+			}
+			sort.Strings(kinds)
+			for _, kind := range kinds {
+				fmt.Fprintf(&out, "whatsapp_llm_tokens_total{%s,kind=%s} %d\n", labels, prometheusLabel(kind), total.Tokens[kind])
+			}
+		})
+		metric("whatsapp_llm_cost_usd_total", "counter", "Cost of LLM calls in USD.", func(total llmUsageTotal, labels string) {
+			fmt.Fprintf(&out, "whatsapp_llm_cost_usd_total{%s} %s\n", labels, strconv.FormatFloat(total.CostUSD, 'f', -1, 64))
+		})
+		metric("whatsapp_llm_cost_usd_today", "gauge", "Cost of today's LLM calls in USD.", func(total llmUsageTotal, labels string) {
+			fmt.Fprintf(&out, "whatsapp_llm_cost_usd_today{%s} %s\n", labels, strconv.FormatFloat(total.CostTodayUSD, 'f', -1, 64))
+		})
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		fmt.Fprint(w, out.String())
+	}
+}
+
+// getLLMCostReportHour returns the hour the daily cost report is sent at (LLM_COST_REPORT_HOUR, default 8)
+func getLLMCostReportHour() int {
+	hour, err := strconv.Atoi(os.Getenv("LLM_COST_REPORT_HOUR"))
+	if err != nil || hour < 0 || hour > 23 {
+		return defaultLLMCostReportHour
+	}
+	return hour
+}
+
+// getLLMCostAlertUSD returns the daily spending that triggers an alert (LLM_COST_ALERT_USD), or 0 for none
+func getLLMCostAlertUSD() float64 {
+	limit, err := strconv.ParseFloat(os.Getenv("LLM_COST_ALERT_USD"), 64)
+	if err != nil || limit < 0 {
+		return 0
+	}
+	return limit
+}
+
+// sentToday reports whether a cost message with the trigger was already sent or queued today
+func sentToday(db *sql.DB, trigger string, today time.Time) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM send_audit WHERE triggered_by = ? AND created_at >= ? AND result != ?",
+		trigger, today, sendAuditFailed).Scan(&count)
+	return count > 0, err
+}
+
+// formatLLMCostReport writes the report of a day's LLM costs per task, with the month's total so far
+func formatLLMCostReport(db *sql.DB, day time.Time) (string, error) {
+	date := day.Format("2006-01-02")
+	byTask, err := aggregateLLMUsage(db, "daily", "task", day)
+	if err != nil {
+		return "", err
+	}
+	var report strings.Builder
+	var cost float64
+	var calls, failures int
+	var lines []string
+	for _, aggregate := range byTask {
+		if aggregate.Period != date {
+			continue
+		}
+		cost += aggregate.CostUSD
+		calls += aggregate.Calls
+		failures += aggregate.Failures
+		task := aggregate.Key
+		if task == "" {
+			task = "other"
+		}
+		lines = append(lines, fmt.Sprintf("%s: $%.2f, %d calls, %d tokens in, %d out", task, aggregate.CostUSD,
+			aggregate.Calls, aggregate.InputTokens+aggregate.CacheCreationTokens+aggregate.CacheReadTokens, aggregate.OutputTokens))
+	}
+	fmt.Fprintf(&report, "💸 LLM costs on %s: $%.2f in %d calls", date, cost, calls)
+	if failures > 0 {
+		fmt.Fprintf(&report, " (%d failed)", failures)
+	}
+	for _, line := range lines {
+		report.WriteString("\n" + line)
+	}
+
+	month, err := aggregateLLMUsage(db, "monthly", "", time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, day.Location()))
+	if err != nil {
+		return "", err
+	}
+	for _, aggregate := range month {
+		if aggregate.Period == day.Format("2006-01") {
+			fmt.Fprintf(&report, "\n%s so far: $%.2f", day.Format("January"), aggregate.CostUSD)
+		}
+	}
+	return report.String(), nil
+}
+
+// checkLLMCosts sends the daily cost report once its hour has come, and the alert once today's spending passes
+// the limit; each at most once a day
+func checkLLMCosts(store *MessageStore, logger waLog.Logger) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	send := func(trigger, origin, message string) {
+		_, err := messageOutbox.Send(SendMessageRequest{Recipient: getAdminChatJID(), Message: message, Origin: origin, TriggeredBy: trigger})
+		if err != nil {
+			logger.Warnf("Failed to send the %s: %v", trigger, err)
+		}
+	}
+
+	if os.Getenv("LLM_COST_REPORT") == "true" && now.Hour() >= getLLMCostReportHour() {
+		if sent, err := sentToday(store.db, llmCostReportTrigger, today); err != nil {
+			logger.Warnf("Failed to check for today's LLM cost report: %v", err)
+		} else if !sent {
+			report, err := formatLLMCostReport(store.db, today.AddDate(0, 0, -1))
+			if err != nil {
+				logger.Warnf("Failed to build the LLM cost report: %v", err)
+			} else {
+				send(llmCostReportTrigger, messageOriginSummary, report)
+			}
+		}
+	}
+
+	if limit := getLLMCostAlertUSD(); limit > 0 {
+		usage, err := aggregateLLMUsage(store.db, "daily", "", today)
+		if err != nil {
+			logger.Warnf("Failed to read today's LLM costs: %v", err)
+			return
+		}
+		var cost float64
+		for _, aggregate := range usage {
+			cost += aggregate.CostUSD
+		}
+		if cost < limit {
+			return
+		}
+		if sent, err := sentToday(store.db, llmCostAlertTrigger, today); err != nil {
+			logger.Warnf("Failed to check for today's LLM cost alert: %v", err)
+		} else if !sent {
+			logger.Warnf("LLM costs today are $%.2f, over LLM_COST_ALERT_USD ($%.2f)", cost, limit)
+			report, err := formatLLMCostReport(store.db, today)
+			if err != nil {
+				report = fmt.Sprintf("💸 LLM costs today: $%.2f", cost)
+			}
+			send(llmCostAlertTrigger, messageOriginAlert, fmt.Sprintf("⚠️ LLM spending passed $%.2f today\n%s", limit, report))
+		}
+	}
+}
+
+// startLLMCostWatch checks the LLM costs every few minutes, when the report or the alert is on
+func startLLMCostWatch(store *MessageStore, logger waLog.Logger) {
+	if os.Getenv("LLM_COST_REPORT") != "true" && getLLMCostAlertUSD() == 0 {
+		return
+	}
+	go func() {
+		for {
+			checkLLMCosts(store, logger)
+			time.Sleep(llmCostCheckInterval)
+		}
+	}()
+}
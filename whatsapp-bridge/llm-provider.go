@@ -0,0 +1,634 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LLMProvider is a backend that turns prompts into completions (summaries, segmentations, replies)
+type LLMProvider interface {
+	Name() string
+	// SupportsTools reports whether the provider can call MCP tools (e.g. the Graphiti add_memory tool)
+	SupportsTools() bool
+	// Complete returns the model's answer to the prompt; tools are only used by providers that support them.
+	// Cancelling ctx aborts the request.
+	Complete(ctx context.Context, prompt string, tools ...string) (string, error)
+}
+
+const (
+	llmProviderClaudeCode = "claude-code" // Claude Code HTTP server, with MCP tools
+	llmProviderAnthropic  = "anthropic"   // Anthropic Messages API
+	llmProviderOpenAI     = "openai"      // any OpenAI-compatible chat completions API (Ollama, LM Studio, vLLM, ...)
+)
+
+// OpenAIChatRequest represents the request body of an OpenAI-compatible /chat/completions endpoint
+type OpenAIChatRequest struct {
+	Model     string              `json:"model"`
+	Messages  []OpenAIChatMessage `json:"messages"`
+	MaxTokens int                 `json:"max_tokens,omitempty"`
+	Stream    bool                `json:"stream"`
+	// Asks for a final chunk with the token usage when streaming
+	StreamOptions *OpenAIStreamOptions `json:"stream_options,omitempty"`
+}
+
+// OpenAIStreamOptions are the options of a streamed chat completion
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// OpenAIUsage is the token usage of a chat completion
+type OpenAIUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+// llmUsage converts the usage to the bridge's accounting
+func (usage *OpenAIUsage) llmUsage() LLMUsage {
+	if usage == nil {
+		return LLMUsage{}
+	}
+	result := LLMUsage{InputTokens: usage.PromptTokens, OutputTokens: usage.CompletionTokens}
+	if usage.PromptTokensDetails != nil {
+		result.CacheReadTokens = usage.PromptTokensDetails.CachedTokens
+		result.InputTokens -= usage.PromptTokensDetails.CachedTokens
+	}
+	return result
+}
+
+// OpenAIChatMessage is a single chat message
+type OpenAIChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// OpenAIChatChunk is one streamed chunk of an OpenAI-compatible /chat/completions response
+type OpenAIChatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *OpenAIUsage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// OpenAIChatResponse represents the response of an OpenAI-compatible /chat/completions endpoint
+type OpenAIChatResponse struct {
+	Choices []struct {
+		Message      OpenAIChatMessage `json:"message"`
+		FinishReason string            `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *OpenAIUsage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// getLLMProviderName returns the configured provider (LLM_PROVIDER, default claude-code).
+// CLAUDE_BACKEND=api is still accepted for the Anthropic API.
+func getLLMProviderName() string {
+	name := os.Getenv("LLM_PROVIDER")
+	if name == "" && os.Getenv("CLAUDE_BACKEND") == "api" {
+		name = llmProviderAnthropic
+	}
+	if name == "" {
+		name = llmProviderClaudeCode
+	}
+	return name
+}
+
+// newLLMProvider creates the provider with the given name
+func newLLMProvider(name string) (LLMProvider, error) {
+	switch name {
+	case llmProviderClaudeCode:
+		return &ClaudeCodeProvider{}, nil
+	case llmProviderAnthropic:
+		return &AnthropicProvider{}, nil
+	case llmProviderOpenAI:
+		return &OpenAIProvider{}, nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q (expected %s, %s or %s)", name, llmProviderClaudeCode, llmProviderAnthropic, llmProviderOpenAI)
+	}
+}
+
+// getLLMProvider returns the configured provider
+func getLLMProvider() (LLMProvider, error) {
+	return newLLMProvider(getLLMProviderName())
+}
+
+// getLLMFallbackProviderNames returns the providers tried in order when the configured one fails
+// (LLM_FALLBACK_PROVIDERS, e.g. "anthropic,openai"; empty by default)
+func getLLMFallbackProviderNames() []string {
+	var names []string
+	for _, name := range strings.Split(os.Getenv("LLM_FALLBACK_PROVIDERS"), ",") {
+		if name = strings.TrimSpace(name); name != "" && name != getLLMProviderName() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// llmProviderCooldown is how long a failed provider is skipped while a fallback is available,
+// so a down server doesn't make every call of a run wait for its timeout first
+const llmProviderCooldown = 5 * time.Minute
+
+// llmProviderFailures holds when each provider last failed in this process
+var llmProviderFailures sync.Map
+
+// llmSupportsTools reports whether the configured provider can call MCP tools
+func llmSupportsTools() bool {
+	provider, err := getLLMProvider()
+	return err == nil && provider.SupportsTools()
+}
+
+// lastLLMPrompt holds the prompt of the most recent LLM call, whose hash goes into the send audit
+var lastLLMPrompt string
+
+// callLLM sends a prompt to the configured LLM provider.
+// Tools only apply to providers that support them; the others answer from the prompt alone.
+// Callers bound or cancel the request through ctx (e.g. context.WithTimeout, or a shutdown signal).
+// When it fails, the LLM_FALLBACK_PROVIDERS are tried in order; calls with tools skip fallbacks without tool support.
+func callLLM(ctx context.Context, prompt string, tools ...string) (string, error) {
+	// With PII redaction, only the redacted prompt leaves, and the answer gets the values back (see redaction.go)
+	redactor := piiRedactorFromContext(ctx)
+	if redactor != nil {
+		redacted, err := redactor.Redact(prompt)
+		if err != nil {
+			return "", err
+		}
+		prompt = redacted
+	}
+	lastLLMPrompt = prompt
+	var providers []LLMProvider
+	for i, name := range append([]string{getLLMProviderName()}, getLLMFallbackProviderNames()...) {
+		provider, err := newLLMProvider(name)
+		if err != nil {
+			return "", err
+		}
+		// A fallback without tools would answer without doing what the tools were for
+		if i > 0 && len(tools) > 0 && !provider.SupportsTools() {
+			continue
+		}
+		providers = append(providers, provider)
+	}
+
+	// Wait for a slot in the queue shared by every process calling the LLM
+	release, err := acquireLLMSlot(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	var response string
+	for i, provider := range providers {
+		isLast := i == len(providers)-1
+		if failedAt, ok := llmProviderFailures.Load(provider.Name()); ok && !isLast && time.Since(failedAt.(time.Time)) < llmProviderCooldown {
+			fmt.Printf("Skipping LLM provider %s, it failed %v ago\n", provider.Name(), time.Since(failedAt.(time.Time)).Round(time.Second))
+			continue
+		}
+
+		started := time.Now()
+		lastLLMUsage = LLMUsage{}
+		response, err = provider.Complete(ctx, prompt, tools...)
+		recordLLMAudit(ctx, provider.Name(), prompt, tools, response, err, started)
+		recordLLMUsage(ctx, provider.Name(), lastLLMUsage, err, started)
+		if err == nil {
+			llmProviderFailures.Delete(provider.Name())
+			if i > 0 {
+				fmt.Printf("LLM answer generated by fallback provider %s\n", provider.Name())
+			}
+			llmSessionFromContext(ctx).AddTurns(prompt, response)
+			if redactor != nil {
+				response = redactor.Restore(response)
+			}
+			return response, nil
+		}
+
+		// A cancelled call is not the provider's fault
+		if ctx.Err() != nil {
+			return response, err
+		}
+		llmProviderFailures.Store(provider.Name(), time.Now())
+		if !isLast {
+			fmt.Printf("LLM provider %s failed, trying %s: %v\n", provider.Name(), providers[i+1].Name(), err)
+		}
+	}
+	return response, err
+}
+
+// LLMTurn is one message of a session's conversation
+type LLMTurn struct {
+	Role    string // "user" or "assistant"
+	Content string
+}
+
+// LLMSession chains the calls of a multi-step workflow (e.g. summary, segmentation, episode adds) so the
+// model keeps the context of the earlier steps: Claude Code resumes its session, the API providers get the earlier turns.
+// A nil session is valid and keeps every call independent.
+type LLMSession struct {
+	mu              sync.Mutex
+	claudeSessionID string
+	turns           []LLMTurn
+}
+
+type llmSessionKey struct{}
+
+// llmSessionsEnabled reports whether workflows chain their LLM calls in a session (LLM_SESSIONS=true)
+func llmSessionsEnabled() bool {
+	return os.Getenv("LLM_SESSIONS") == "true"
+}
+
+// withLLMSession starts a session for the LLM calls made with ctx, when sessions are enabled
+func withLLMSession(ctx context.Context) context.Context {
+	if !llmSessionsEnabled() {
+		return ctx
+	}
+	return context.WithValue(ctx, llmSessionKey{}, &LLMSession{})
+}
+
+// llmSessionFromContext returns the session of ctx, or nil
+func llmSessionFromContext(ctx context.Context) *LLMSession {
+	session, _ := ctx.Value(llmSessionKey{}).(*LLMSession)
+	return session
+}
+
+// ClaudeSessionID returns the Claude Code session to resume, if any
+func (session *LLMSession) ClaudeSessionID() string {
+	if session == nil {
+		return ""
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.claudeSessionID
+}
+
+// SetClaudeSessionID remembers the Claude Code session returned by the server
+func (session *LLMSession) SetClaudeSessionID(sessionID string) {
+	if session == nil || sessionID == "" {
+		return
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.claudeSessionID = sessionID
+}
+
+// Turns returns the conversation so far
+func (session *LLMSession) Turns() []LLMTurn {
+	if session == nil {
+		return nil
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return append([]LLMTurn{}, session.turns...)
+}
+
+// AddTurns records a prompt and its answer
+func (session *LLMSession) AddTurns(prompt, response string) {
+	if session == nil {
+		return
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.turns = append(session.turns, LLMTurn{Role: "user", Content: prompt}, LLMTurn{Role: "assistant", Content: response})
+}
+
+// Continues reports whether the session already has earlier turns, so prompts can skip context the model has seen
+func (session *LLMSession) Continues() bool {
+	if session == nil {
+		return false
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return len(session.turns) > 0
+}
+
+// openAISessionMessages returns the session's earlier turns followed by the new prompt
+func openAISessionMessages(session *LLMSession, prompt string) []OpenAIChatMessage {
+	var messages []OpenAIChatMessage
+	for _, turn := range session.Turns() {
+		messages = append(messages, OpenAIChatMessage{Role: turn.Role, Content: turn.Content})
+	}
+	return append(messages, OpenAIChatMessage{Role: "user", Content: prompt})
+}
+
+// withLLMTimeout bounds a non-streamed request by LLM_TIMEOUT seconds (or fallback when unset);
+// LLM_TIMEOUT=0 leaves the request bound only by ctx
+func withLLMTimeout(ctx context.Context, fallback time.Duration) (context.Context, context.CancelFunc) {
+	timeout := fallback
+	if seconds, err := strconv.Atoi(os.Getenv("LLM_TIMEOUT")); err == nil && seconds >= 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// getOpenAIModel returns the model used by the openai provider (OPENAI_MODEL, default llama3.1)
+func getOpenAIModel() string {
+	model := os.Getenv("OPENAI_MODEL")
+	if model == "" {
+		model = "llama3.1"
+	}
+	return model
+}
+
+// getLLMModelName returns the model behind a provider, as far as the bridge knows it
+// (the Claude Code server picks its own model)
+func getLLMModelName(providerName string) string {
+	switch providerName {
+	case llmProviderAnthropic:
+		return getAnthropicModel()
+	case llmProviderOpenAI:
+		return getOpenAIModel()
+	default:
+		return ""
+	}
+}
+
+// getLLMProviderURL returns the URL a provider sends prompts to (CLAUDE_SERVER_URL, ANTHROPIC_API_URL or
+// OPENAI_API_URL), without a trailing slash
+func getLLMProviderURL(providerName string) string {
+	variable, fallback := "CLAUDE_SERVER_URL", "http://host.docker.internal:8888/claude"
+	switch providerName {
+	case llmProviderAnthropic:
+		variable, fallback = "ANTHROPIC_API_URL", "https://api.anthropic.com"
+	case llmProviderOpenAI:
+		variable, fallback = "OPENAI_API_URL", "http://host.docker.internal:11434/v1"
+	}
+	if url := strings.TrimRight(os.Getenv(variable), "/"); url != "" {
+		return url
+	}
+	return fallback
+}
+
+// OpenAIProvider sends prompts to an OpenAI-compatible chat completions API, e.g. a local Ollama
+type OpenAIProvider struct{}
+
+// Name returns the provider name
+func (provider *OpenAIProvider) Name() string {
+	return llmProviderOpenAI
+}
+
+// SupportsTools reports that MCP tools are not available through chat completions
+func (provider *OpenAIProvider) SupportsTools() bool {
+	return false
+}
+
+// Complete sends the prompt as a single user message; tools are ignored
+func (provider *OpenAIProvider) Complete(ctx context.Context, prompt string, tools ...string) (string, error) {
+	apiURL := getLLMProviderURL(llmProviderOpenAI)
+
+	model := getOpenAIModel()
+
+	maxTokens, _ := strconv.Atoi(os.Getenv("OPENAI_MAX_TOKENS"))
+
+	chatReq := OpenAIChatRequest{
+		Model:     model,
+		Messages:  openAISessionMessages(llmSessionFromContext(ctx), prompt),
+		MaxTokens: maxTokens,
+		Stream:    llmStreamingEnabled(),
+	}
+	if chatReq.Stream {
+		chatReq.StreamOptions = &OpenAIStreamOptions{IncludeUsage: true}
+	}
+	jsonData, err := json.Marshal(chatReq)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling request: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", apiURL+"/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if apiKey := os.Getenv("OPENAI_API_KEY"); apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	if llmStreamingEnabled() {
+		return streamOpenAIChat(httpReq)
+	}
+
+	// Local models on modest hardware can take a while for a full day of messages
+	ctx, cancel := withLLMTimeout(ctx, 600*time.Second)
+	defer cancel()
+
+	resp, err := http.DefaultClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading response: %v", err)
+	}
+	lastClaudeResponseBody = string(body)
+
+	var chatResp OpenAIChatResponse
+	if err := json.Unmarshal(body, &chatResp); err != nil {
+		return "", fmt.Errorf("error parsing response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if chatResp.Error != nil {
+		return "", fmt.Errorf("LLM API error: %s", chatResp.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("LLM API returned HTTP %d", resp.StatusCode)
+	}
+	if len(chatResp.Choices) == 0 {
+		return "", fmt.Errorf("LLM API returned no choices")
+	}
+
+	lastLLMUsage = chatResp.Usage.llmUsage()
+
+	if chatResp.Choices[0].FinishReason == "length" {
+		fmt.Printf("Warning: LLM response truncated (raise OPENAI_MAX_TOKENS)\n")
+	}
+
+	return chatResp.Choices[0].Message.Content, nil
+}
+
+// streamOpenAIChat reads a streamed chat completions response, failing only when the stream goes idle
+func streamOpenAIChat(httpReq *http.Request) (string, error) {
+	progress := newLLMStreamProgress("LLM")
+	finishReason := ""
+
+	err := doLLMStreamRequest(httpReq, func(data string) (bool, error) {
+		if data == "[DONE]" {
+			return true, nil
+		}
+
+		var chunk OpenAIChatChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return false, fmt.Errorf("error parsing stream chunk: %v", err)
+		}
+		if chunk.Error != nil {
+			return false, fmt.Errorf("LLM API error: %s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			lastLLMUsage = chunk.Usage.llmUsage()
+		}
+		for _, choice := range chunk.Choices {
+			progress.Add(choice.Delta.Content)
+			if choice.FinishReason != nil {
+				finishReason = *choice.FinishReason
+			}
+		}
+		return false, nil
+	})
+
+	text := progress.Finish()
+	if err != nil {
+		return "", err
+	}
+	if finishReason == "length" {
+		fmt.Printf("Warning: LLM response truncated (raise OPENAI_MAX_TOKENS)\n")
+	}
+	return text, nil
+}
+
+// llmStreamingEnabled reports whether API providers stream their responses (LLM_STREAM, default true)
+func llmStreamingEnabled() bool {
+	return os.Getenv("LLM_STREAM") != "false"
+}
+
+// getLLMIdleTimeout returns how long a streamed response may go without data (LLM_IDLE_TIMEOUT seconds, default 90)
+func getLLMIdleTimeout() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("LLM_IDLE_TIMEOUT"))
+	if err != nil || seconds <= 0 {
+		seconds = 90
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// doLLMStreamRequest sends a request whose response is a server-sent event stream and passes the data of every
+// event to onData until it reports completion. Instead of a total timeout, the request is cancelled when no
+// data arrives for the idle timeout, so long generations aren't cut off while they are still progressing.
+// The request's own context still applies, so callers can cancel the stream.
+func doLLMStreamRequest(httpReq *http.Request, onData func(data string) (done bool, err error)) error {
+	idleTimeout := getLLMIdleTimeout()
+	parent := httpReq.Context()
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var idleExpired atomic.Bool
+	idle := time.AfterFunc(idleTimeout, func() {
+		idleExpired.Store(true)
+		cancel()
+	})
+	defer idle.Stop()
+
+	httpReq.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		if parent.Err() != nil {
+			return fmt.Errorf("request cancelled: %v", parent.Err())
+		}
+		if idleExpired.Load() {
+			return fmt.Errorf("no response within %v", idleTimeout)
+		}
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		lastClaudeResponseBody = string(body)
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		idle.Reset(idleTimeout)
+
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue // event names, comments and keep-alives
+		}
+
+		done, err := onData(strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+
+	if parent.Err() != nil {
+		return fmt.Errorf("request cancelled: %v", parent.Err())
+	}
+	if idleExpired.Load() {
+		return fmt.Errorf("stream idle for more than %v", idleTimeout)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading stream: %v", err)
+	}
+	// Some servers just close the stream when done
+	return nil
+}
+
+// llmStreamProgress accumulates streamed text and logs progress periodically
+type llmStreamProgress struct {
+	label     string
+	text      strings.Builder
+	started   time.Time
+	lastLogAt time.Time
+}
+
+// llmStreamLogInterval is how often streaming progress is logged
+const llmStreamLogInterval = 10 * time.Second
+
+// newLLMStreamProgress starts tracking a streamed response
+func newLLMStreamProgress(label string) *llmStreamProgress {
+	now := time.Now()
+	return &llmStreamProgress{label: label, started: now, lastLogAt: now}
+}
+
+// Add appends a chunk and logs the latest complete line every llmStreamLogInterval
+func (progress *llmStreamProgress) Add(chunk string) {
+	progress.text.WriteString(chunk)
+
+	if time.Since(progress.lastLogAt) < llmStreamLogInterval {
+		return
+	}
+	progress.lastLogAt = time.Now()
+
+	text := progress.text.String()
+	lastLine := ""
+	if end := strings.LastIndex(text, "\n"); end >= 0 {
+		lines := strings.Split(strings.TrimSpace(text[:end]), "\n")
+		lastLine = strings.TrimSpace(lines[len(lines)-1])
+	}
+	if len(lastLine) > 120 {
+		lastLine = lastLine[:120] + "..."
+	}
+	fmt.Printf("%s streaming: %d characters after %v | %s\n", progress.label, len(text), time.Since(progress.started).Round(time.Second), lastLine)
+}
+
+// Finish returns the full text and keeps it for diagnostics
+func (progress *llmStreamProgress) Finish() string {
+	text := progress.text.String()
+	lastClaudeResponseBody = text
+	return text
+}
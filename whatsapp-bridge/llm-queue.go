@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// LLM calls from every process (bridge, scheduler, imports) share one queue in messages.db,
+// so concurrent jobs can't exceed the in-flight and per-minute limits together
+
+const (
+	llmQueueLease        = 2 * time.Minute        // a ticket whose process stopped refreshing it is dropped after this
+	llmQueueHeartbeat    = 30 * time.Second       // how often a live ticket is refreshed
+	llmQueuePollInterval = 500 * time.Millisecond // how often a waiting call checks for a free slot
+)
+
+// LLMQueueTicket is a call waiting for, or holding, a slot in the queue
+type LLMQueueTicket struct {
+	ID         string
+	PID        int
+	Task       string
+	GroupJID   string
+	Running    bool
+	EnqueuedAt time.Time
+	StartedAt  time.Time
+}
+
+var llmQueueSequence atomic.Int64
+
+// getLLMQueueLimits returns the maximum number of calls in flight (LLM_MAX_IN_FLIGHT, default 2)
+// and started per minute (LLM_MAX_REQUESTS_PER_MINUTE, default unlimited); 0 disables a limit
+func getLLMQueueLimits() (maxInFlight, perMinute int) {
+	maxInFlight = 2
+	if value, err := strconv.Atoi(os.Getenv("LLM_MAX_IN_FLIGHT")); err == nil && value >= 0 {
+		maxInFlight = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("LLM_MAX_REQUESTS_PER_MINUTE")); err == nil && value >= 0 {
+		perMinute = value
+	}
+	return maxInFlight, perMinute
+}
+
+// ensureLLMQueueTables creates the queue tables if they don't exist
+func ensureLLMQueueTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS llm_queue (
+			id TEXT PRIMARY KEY,
+			pid INTEGER,
+			task_type TEXT DEFAULT '',
+			group_jid TEXT DEFAULT '',
+			running BOOLEAN DEFAULT 0,
+			enqueued_at INTEGER,
+			started_at INTEGER DEFAULT 0,
+			heartbeat_at INTEGER
+		);
+
+		CREATE TABLE IF NOT EXISTS llm_request_starts (
+			started_at INTEGER
+		);
+	`)
+	return err
+}
+
+// acquireLLMSlot waits in the queue until the call may start and returns the function releasing its slot.
+// Only a cancelled context is an error: when the queue itself fails, the call goes ahead unthrottled.
+func acquireLLMSlot(ctx context.Context) (func(), error) {
+	maxInFlight, perMinute := getLLMQueueLimits()
+	if maxInFlight == 0 && perMinute == 0 {
+		return func() {}, nil
+	}
+
+	// Immediate transactions serialize the slot checks of concurrent processes
+	db, err := sql.Open(messagesDBDriver(), "file:store/messages.db?_foreign_keys=on&_txlock=immediate&_busy_timeout=10000")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "LLM queue unavailable: %v\n", err)
+		return func() {}, nil
+	}
+	if err := ensureLLMQueueTables(db); err != nil {
+		fmt.Fprintf(os.Stderr, "LLM queue unavailable: %v\n", err)
+		db.Close()
+		return func() {}, nil
+	}
+
+	id := fmt.Sprintf("%d-%d-%d", os.Getpid(), time.Now().UnixNano(), llmQueueSequence.Add(1))
+	scope := llmAuditScopeFromContext(ctx)
+	now := time.Now().UnixNano()
+	if _, err := db.Exec(`
+		INSERT INTO llm_queue (id, pid, task_type, group_jid, enqueued_at, heartbeat_at) VALUES (?, ?, ?, ?, ?, ?)
+	`, id, os.Getpid(), scope.Purpose, scope.GroupJID, now, now); err != nil {
+		fmt.Fprintf(os.Stderr, "LLM queue unavailable: %v\n", err)
+		db.Close()
+		return func() {}, nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(llmQueueHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				db.Exec("UPDATE llm_queue SET heartbeat_at = ? WHERE id = ?", time.Now().UnixNano(), id)
+			}
+		}
+	}()
+	release := func() {
+		close(done)
+		if _, err := db.Exec("DELETE FROM llm_queue WHERE id = ?", id); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to release LLM queue slot: %v\n", err)
+		}
+		db.Close()
+	}
+
+	waitingSince := time.Now()
+	for {
+		started, wait, err := tryStartLLMCall(db, id, maxInFlight, perMinute)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "LLM queue unavailable, calling without it: %v\n", err)
+			return release, nil
+		}
+		if started {
+			if waited := time.Since(waitingSince); waited > time.Second {
+				fmt.Fprintf(os.Stderr, "LLM call waited %s in the queue\n", waited.Round(time.Second))
+			}
+			return release, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// tryStartLLMCall starts the ticket's call if a slot is free and the calls queued before it have been served;
+// otherwise it returns how long to wait before checking again
+func tryStartLLMCall(db *sql.DB, id string, maxInFlight, perMinute int) (bool, time.Duration, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec("DELETE FROM llm_queue WHERE heartbeat_at < ?", now.Add(-llmQueueLease).UnixNano()); err != nil {
+		return false, 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM llm_request_starts WHERE started_at <= ?", now.Add(-time.Minute).UnixNano()); err != nil {
+		return false, 0, err
+	}
+
+	var running, ahead int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM llm_queue WHERE running = 1").Scan(&running); err != nil {
+		return false, 0, err
+	}
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM llm_queue, (SELECT enqueued_at AS mine, id AS my_id FROM llm_queue WHERE id = ?)
+		WHERE running = 0 AND (enqueued_at < mine OR (enqueued_at = mine AND id < my_id))
+	`, id).Scan(&ahead)
+	if err != nil {
+		return false, 0, err
+	}
+
+	// Free slots go to the oldest waiting calls first
+	if maxInFlight > 0 && ahead >= maxInFlight-running {
+		return false, llmQueuePollInterval, nil
+	}
+	if perMinute > 0 {
+		var started int
+		var oldest sql.NullInt64
+		if err := tx.QueryRow("SELECT COUNT(*), MIN(started_at) FROM llm_request_starts").Scan(&started, &oldest); err != nil {
+			return false, 0, err
+		}
+		if ahead >= perMinute-started {
+			wait := llmQueuePollInterval
+			if oldest.Valid {
+				if untilFree := time.Until(time.Unix(0, oldest.Int64).Add(time.Minute)); untilFree > wait {
+					wait = untilFree
+				}
+			}
+			return false, wait, nil
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE llm_queue SET running = 1, started_at = ? WHERE id = ?", now.UnixNano(), id); err != nil {
+		return false, 0, err
+	}
+	if _, err := tx.Exec("INSERT INTO llm_request_starts (started_at) VALUES (?)", now.UnixNano()); err != nil {
+		return false, 0, err
+	}
+	return true, 0, tx.Commit()
+}
+
+// listLLMQueue returns the calls currently running or waiting, in queue order
+func listLLMQueue(db *sql.DB) ([]LLMQueueTicket, error) {
+	if err := ensureLLMQueueTables(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`
+		SELECT id, pid, COALESCE(task_type, ''), COALESCE(group_jid, ''), running, enqueued_at, started_at
+		FROM llm_queue
+		WHERE heartbeat_at >= ?
+		ORDER BY running DESC, enqueued_at, id
+	`, time.Now().Add(-llmQueueLease).UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tickets []LLMQueueTicket
+	for rows.Next() {
+		var ticket LLMQueueTicket
+		var enqueuedAt, startedAt int64
+		if err := rows.Scan(&ticket.ID, &ticket.PID, &ticket.Task, &ticket.GroupJID, &ticket.Running, &enqueuedAt, &startedAt); err != nil {
+			return nil, err
+		}
+		ticket.EnqueuedAt = time.Unix(0, enqueuedAt)
+		if ticket.Running {
+			ticket.StartedAt = time.Unix(0, startedAt)
+		}
+		tickets = append(tickets, ticket)
+	}
+	return tickets, rows.Err()
+}
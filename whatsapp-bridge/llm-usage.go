@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// LLMUsage is the token accounting of one LLM call, as reported by the provider
+type LLMUsage struct {
+	InputTokens         int
+	OutputTokens        int
+	CacheCreationTokens int
+	CacheReadTokens     int
+	CostUSD             float64 // reported by Claude Code; estimated from llmPrices for the Anthropic API
+}
+
+// lastLLMUsage holds the usage of the most recent LLM response, set by the providers like lastClaudeResponseBody
+var lastLLMUsage LLMUsage
+
+// llmPrice is the USD price per million tokens of a model
+type llmPrice struct {
+	Input, Output, CacheWrite, CacheRead float64
+}
+
+// llmPrices are the Anthropic API list prices, matched by model name prefix
+var llmPrices = []struct {
+	prefix string
+	price  llmPrice
+}{
+	{"claude-opus-4", llmPrice{Input: 15, Output: 75, CacheWrite: 18.75, CacheRead: 1.5}},
+	{"claude-sonnet-4", llmPrice{Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3}},
+	{"claude-3-7-sonnet", llmPrice{Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3}},
+	{"claude-3-5-sonnet", llmPrice{Input: 3, Output: 15, CacheWrite: 3.75, CacheRead: 0.3}},
+	{"claude-haiku-4", llmPrice{Input: 1, Output: 5, CacheWrite: 1.25, CacheRead: 0.1}},
+	{"claude-3-5-haiku", llmPrice{Input: 0.8, Output: 4, CacheWrite: 1, CacheRead: 0.08}},
+}
+
+// estimateLLMCost returns the cost of a call from the model's list price, or 0 for unknown (e.g. local) models
+func estimateLLMCost(model string, usage LLMUsage) float64 {
+	for _, entry := range llmPrices {
+		if strings.HasPrefix(model, entry.prefix) {
+			return (float64(usage.InputTokens)*entry.price.Input +
+				float64(usage.OutputTokens)*entry.price.Output +
+				float64(usage.CacheCreationTokens)*entry.price.CacheWrite +
+				float64(usage.CacheReadTokens)*entry.price.CacheRead) / 1e6
+		}
+	}
+	return 0
+}
+
+// ensureLLMUsageTable creates the table of per-call LLM usage if it doesn't exist
+func ensureLLMUsageTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS llm_usage (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp TIMESTAMP NOT NULL,
+			provider TEXT NOT NULL,
+			model TEXT DEFAULT '',
+			task_type TEXT DEFAULT '',
+			group_jid TEXT DEFAULT '',
+			input_tokens INTEGER DEFAULT 0,
+			output_tokens INTEGER DEFAULT 0,
+			cache_creation_tokens INTEGER DEFAULT 0,
+			cache_read_tokens INTEGER DEFAULT 0,
+			duration_ms INTEGER DEFAULT 0,
+			cost_usd REAL DEFAULT 0,
+			success BOOLEAN DEFAULT 1
+		);
+		CREATE INDEX IF NOT EXISTS idx_llm_usage_timestamp ON llm_usage(timestamp);
+	`)
+	return err
+}
+
+// recordLLMUsage stores the tokens, duration and cost of an LLM call in the llm_usage table.
+// Failures are only reported on stderr: usage tracking never breaks the call it records.
+func recordLLMUsage(ctx context.Context, providerName string, usage LLMUsage, callErr error, started time.Time) {
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record LLM usage: %v\n", err)
+		return
+	}
+	defer db.Close()
+
+	if err := ensureLLMUsageTable(db); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record LLM usage: %v\n", err)
+		return
+	}
+
+	model := getLLMModelName(providerName)
+	if usage.CostUSD == 0 {
+		usage.CostUSD = estimateLLMCost(model, usage)
+	}
+
+	scope := llmAuditScopeFromContext(ctx)
+	_, err = db.Exec(`
+		INSERT INTO llm_usage (timestamp, provider, model, task_type, group_jid, input_tokens, output_tokens,
+			cache_creation_tokens, cache_read_tokens, duration_ms, cost_usd, success)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, started, providerName, model, scope.Purpose, scope.GroupJID, usage.InputTokens, usage.OutputTokens,
+		usage.CacheCreationTokens, usage.CacheReadTokens, time.Since(started).Milliseconds(), usage.CostUSD, callErr == nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record LLM usage: %v\n", err)
+	}
+}
+
+// LLMUsageAggregate is the usage of the calls in one period (and optionally one task type, group or provider)
+type LLMUsageAggregate struct {
+	Period              string
+	Key                 string
+	Calls               int
+	Failures            int
+	InputTokens         int64
+	OutputTokens        int64
+	CacheCreationTokens int64
+	CacheReadTokens     int64
+	AvgDurationMs       float64
+	CostUSD             float64
+}
+
+// llmUsageGroupColumns are the columns a usage report can be broken down by
+var llmUsageGroupColumns = map[string]string{
+	"":         "''",
+	"task":     "task_type",
+	"group":    "group_jid",
+	"provider": "provider || CASE WHEN model != '' THEN '/' || model ELSE '' END",
+}
+
+// aggregateLLMUsage sums the usage since a time per day ("daily") or month ("monthly"),
+// optionally broken down by task, group or provider
+func aggregateLLMUsage(db *sql.DB, period, by string, since time.Time) ([]LLMUsageAggregate, error) {
+	periodColumn := "substr(timestamp, 1, 10)"
+	if period == "monthly" {
+		periodColumn = "substr(timestamp, 1, 7)"
+	}
+	keyColumn, ok := llmUsageGroupColumns[by]
+	if !ok {
+		return nil, fmt.Errorf("unknown breakdown %q (expected task, group or provider)", by)
+	}
+
+	if err := ensureLLMUsageTable(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT %s AS period, %s AS key, COUNT(*), SUM(CASE WHEN success THEN 0 ELSE 1 END),
+			SUM(input_tokens), SUM(output_tokens), SUM(cache_creation_tokens), SUM(cache_read_tokens),
+			AVG(duration_ms), SUM(cost_usd)
+		FROM llm_usage
+		WHERE timestamp >= ?
+		GROUP BY period, key
+		ORDER BY period, key
+	`, periodColumn, keyColumn), since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aggregates []LLMUsageAggregate
+	for rows.Next() {
+		var aggregate LLMUsageAggregate
+		if err := rows.Scan(&aggregate.Period, &aggregate.Key, &aggregate.Calls, &aggregate.Failures,
+			&aggregate.InputTokens, &aggregate.OutputTokens, &aggregate.CacheCreationTokens, &aggregate.CacheReadTokens,
+			&aggregate.AvgDurationMs, &aggregate.CostUSD); err != nil {
+			return nil, err
+		}
+		aggregates = append(aggregates, aggregate)
+	}
+	return aggregates, rows.Err()
+}
+
+// Outcomes of parsing JSON written by the LLM, as recorded in llm_json_parses
+const (
+	jsonParseValid          = "valid"           // valid as returned
+	jsonParseRepaired       = "repaired"        // valid after repairJSON
+	jsonParseFailed         = "failed"          // not valid JSON even after repair
+	jsonParseSchemaMismatch = "schema_mismatch" // valid JSON that doesn't match the expected schema
+)
+
+// ensureLLMJSONParsesTable creates the table of LLM JSON parse outcomes if it doesn't exist
+func ensureLLMJSONParsesTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS llm_json_parses (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp TIMESTAMP NOT NULL,
+			task_type TEXT DEFAULT '',
+			group_jid TEXT DEFAULT '',
+			outcome TEXT NOT NULL,
+			fixes TEXT DEFAULT ''
+		);
+		CREATE INDEX IF NOT EXISTS idx_llm_json_parses_timestamp ON llm_json_parses(timestamp);
+	`)
+	return err
+}
+
+// recordLLMJSONParse stores the outcome of parsing an LLM answer as JSON, to monitor how often answers need repair.
+// Like recordLLMUsage, failures are only reported on stderr.
+func recordLLMJSONParse(ctx context.Context, outcome string, fixes []string) {
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record LLM JSON parse: %v\n", err)
+		return
+	}
+	defer db.Close()
+
+	if err := ensureLLMJSONParsesTable(db); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record LLM JSON parse: %v\n", err)
+		return
+	}
+
+	scope := llmAuditScopeFromContext(ctx)
+	_, err = db.Exec(`
+		INSERT INTO llm_json_parses (timestamp, task_type, group_jid, outcome, fixes)
+		VALUES (?, ?, ?, ?, ?)
+	`, time.Now(), scope.Purpose, scope.GroupJID, outcome, strings.Join(fixes, ","))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record LLM JSON parse: %v\n", err)
+	}
+}
+
+// LLMJSONParseAggregate counts the JSON parse outcomes of one task type
+type LLMJSONParseAggregate struct {
+	Task           string
+	Parses         int
+	Valid          int
+	Repaired       int
+	Failed         int
+	SchemaMismatch int
+	Fixes          map[string]int
+}
+
+// aggregateLLMJSONParses counts the parse outcomes and applied fixes per task type since a time
+func aggregateLLMJSONParses(db *sql.DB, since time.Time) ([]*LLMJSONParseAggregate, error) {
+	if err := ensureLLMJSONParsesTable(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT task_type, outcome, COALESCE(fixes, '') FROM llm_json_parses
+		WHERE timestamp >= ?
+		ORDER BY task_type
+	`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aggregates []*LLMJSONParseAggregate
+	byTask := make(map[string]*LLMJSONParseAggregate)
+	for rows.Next() {
+		var task, outcome, fixes string
+		if err := rows.Scan(&task, &outcome, &fixes); err != nil {
+			return nil, err
+		}
+		aggregate, ok := byTask[task]
+		if !ok {
+			aggregate = &LLMJSONParseAggregate{Task: task, Fixes: make(map[string]int)}
+			byTask[task] = aggregate
+			aggregates = append(aggregates, aggregate)
+		}
+		aggregate.Parses++
+		switch outcome {
+		case jsonParseValid:
+			aggregate.Valid++
+		case jsonParseRepaired:
+			aggregate.Repaired++
+		case jsonParseFailed:
+			aggregate.Failed++
+		case jsonParseSchemaMismatch:
+			aggregate.SchemaMismatch++
+		}
+		for _, fix := range strings.Split(fixes, ",") {
+			if fix != "" {
+				aggregate.Fixes[fix]++
+			}
+		}
+	}
+	return aggregates, rows.Err()
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Log levels are set per component: LOG_LEVEL applies to all of them, and LOG_LEVELS overrides it for some,
+// e.g. "Database=ERROR,Client=INFO,Summary=DEBUG". A component's level also applies to its sub-loggers
+// (Client/Socket), unless LOG_LEVELS names them too.
+
+// Log levels, from the most verbose
+var logLevelRanks = map[string]int{"DEBUG": 0, "INFO": 1, "WARN": 2, "ERROR": 3}
+
+// ANSI colors of the log levels, as whatsmeow's stdout logger uses them
+var logLevelColors = map[string]string{"INFO": "\033[36m", "WARN": "\033[33m", "ERROR": "\033[31m"}
+
+// ansiEscapes matches the color codes, which are left out of log files
+var ansiEscapes = regexp.MustCompile("\033\\[[0-9;]*m")
+
+// componentLogger writes log lines to stdout, like waLog.Stdout, at the level configured for its component
+type componentLogger struct {
+	module string
+	min    int
+	color  bool
+}
+
+// parseLogLevels reads LOG_LEVELS into levels by component
+func parseLogLevels() map[string]string {
+	levels := make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv("LOG_LEVELS"), ",") {
+		component, level, ok := strings.Cut(entry, "=")
+		level = strings.ToUpper(strings.TrimSpace(level))
+		if _, known := logLevelRanks[level]; ok && known {
+			levels[strings.TrimSpace(component)] = level
+		}
+	}
+	return levels
+}
+
+// newLogger returns the logger of a component, at its level from LOG_LEVELS or LOG_LEVEL, else defaultLevel
+func newLogger(module, defaultLevel string) waLog.Logger {
+	level, ok := parseLogLevels()[module]
+	if !ok {
+		level = strings.ToUpper(os.Getenv("LOG_LEVEL"))
+	}
+	rank, ok := logLevelRanks[level]
+	if !ok {
+		rank = logLevelRanks[strings.ToUpper(defaultLevel)]
+	}
+	return &componentLogger{module: module, min: rank, color: true}
+}
+
+func (l *componentLogger) outputf(level, msg string, args ...interface{}) {
+	if logLevelRanks[level] < l.min {
+		return
+	}
+	var colorStart, colorReset string
+	if l.color {
+		colorStart, colorReset = logLevelColors[level], "\033[0m"
+	}
+	fmt.Printf("%s%s [%s %s] %s%s\n", time.Now().Format("15:04:05.000"), colorStart, l.module, level, fmt.Sprintf(msg, args...), colorReset)
+}
+
+func (l *componentLogger) Errorf(msg string, args ...interface{}) { l.outputf("ERROR", msg, args...) }
+func (l *componentLogger) Warnf(msg string, args ...interface{})  { l.outputf("WARN", msg, args...) }
+func (l *componentLogger) Infof(msg string, args ...interface{})  { l.outputf("INFO", msg, args...) }
+func (l *componentLogger) Debugf(msg string, args ...interface{}) { l.outputf("DEBUG", msg, args...) }
+
+// Sub returns the logger of a sub-component, at its own level if LOG_LEVELS has one, else at this one's
+func (l *componentLogger) Sub(module string) waLog.Logger {
+	sub := &componentLogger{module: l.module + "/" + module, min: l.min, color: l.color}
+	if level, ok := parseLogLevels()[sub.module]; ok {
+		sub.min = logLevelRanks[level]
+	}
+	return sub
+}
+
+// rotatingFile is a log file that is renamed with a timestamp and started afresh once it reaches LOG_MAX_SIZE_MB,
+// or at midnight with LOG_ROTATE_DAILY=true. Only the newest LOG_MAX_FILES rotated files are kept.
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64 // 0 for no size limit
+	daily    bool
+	maxFiles int
+	file     *os.File
+	size     int64
+	day      string
+}
+
+// getLogEnvInt reads a non-negative number from the environment, or returns fallback
+func getLogEnvInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+// openRotatingFile opens a log file for appending, with the rotation limits from the environment
+func openRotatingFile(path string) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	rotating := &rotatingFile{
+		path:     path,
+		maxSize:  int64(getLogEnvInt("LOG_MAX_SIZE_MB", 10)) * 1024 * 1024,
+		daily:    os.Getenv("LOG_ROTATE_DAILY") == "true",
+		maxFiles: getLogEnvInt("LOG_MAX_FILES", 5),
+	}
+	return rotating, rotating.open()
+}
+
+// open opens the current log file, picking up its size and day when it already exists
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.file, r.size, r.day = file, 0, time.Now().Format("2006-01-02")
+	if info, err := file.Stat(); err == nil {
+		r.size = info.Size()
+		if info.Size() > 0 {
+			r.day = info.ModTime().Format("2006-01-02")
+		}
+	}
+	return nil
+}
+
+// Write appends to the log file, rotating it first when the write would exceed the size limit or the day changed
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size > 0 && ((r.maxSize > 0 && r.size+int64(len(p)) > r.maxSize) || (r.daily && r.day != time.Now().Format("2006-01-02"))) {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rotate %s: %v\n", r.path, err)
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the log file with the time it was rotated, deletes the oldest rotated files and opens a new one
+func (r *rotatingFile) rotate() error {
+	r.file.Close()
+	rotated := r.path + "." + time.Now().Format("20060102-150405.000")
+	if err := os.Rename(r.path, rotated); err != nil {
+		return r.open()
+	}
+	pruneRotatedLogs(r.path, r.maxFiles)
+	return r.open()
+}
+
+// pruneRotatedLogs deletes the rotated files of a log beyond the newest keep
+func pruneRotatedLogs(path string, keep int) {
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil || len(rotated) <= keep {
+		return
+	}
+	sort.Strings(rotated) // the timestamps sort oldest first
+	for _, old := range rotated[:len(rotated)-keep] {
+		os.Remove(old)
+	}
+}
+
+// Close closes the log file
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// setupLogFile copies everything the program prints to stdout into LOG_FILE as well, without colors, rotating it.
+// It returns a function that writes out what is still buffered and closes the file, or nil when LOG_FILE isn't set.
+func setupLogFile() (func(), error) {
+	path := os.Getenv("LOG_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	logFile, err := openRotatingFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open LOG_FILE: %v", err)
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		logFile.Close()
+		return nil, fmt.Errorf("failed to open LOG_FILE: %v", err)
+	}
+
+	console := os.Stdout
+	os.Stdout = writer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lines := bufio.NewReader(reader)
+		for {
+			line, err := lines.ReadString('\n')
+			if line != "" {
+				io.WriteString(console, line)
+				io.WriteString(logFile, ansiEscapes.ReplaceAllString(line, ""))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		os.Stdout = console
+		writer.Close()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+		}
+		logFile.Close()
+	}, nil
+}
+
+// rotateToolLogs rotates the logs the scheduled tools append to in store/ (daily-summary.log, cron.log, ...) once
+// they pass LOG_MAX_SIZE_MB: each is copied to a timestamped file and truncated, since cron keeps appending to it.
+func rotateToolLogs(logger waLog.Logger) {
+	maxSize := int64(getLogEnvInt("LOG_MAX_SIZE_MB", 10)) * 1024 * 1024
+	paths, _ := filepath.Glob(filepath.Join("store", "*.log"))
+	for _, path := range paths {
+		if filepath.Clean(path) == filepath.Clean(os.Getenv("LOG_FILE")) {
+			continue // rotated as it is written
+		}
+		info, err := os.Stat(path)
+		if err != nil || maxSize == 0 || info.Size() <= maxSize {
+			continue
+		}
+		if err := copyTruncateLog(path); err != nil {
+			logger.Warnf("Failed to rotate %s: %v", path, err)
+			continue
+		}
+		pruneRotatedLogs(path, getLogEnvInt("LOG_MAX_FILES", 5))
+		logger.Infof("Rotated %s (%d MB)", path, info.Size()/1024/1024)
+	}
+}
+
+// copyTruncateLog copies a log to a timestamped file and empties it
+func copyTruncateLog(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.Create(path + "." + time.Now().Format("20060102-150405.000"))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		target.Close()
+		return err
+	}
+	if err := target.Close(); err != nil {
+		return err
+	}
+	return os.Truncate(path, 0)
+}
+
+// startToolLogRotation checks the scheduled tools' logs now and every hour, when LOG_ROTATE_TOOL_LOGS=true
+func startToolLogRotation(logger waLog.Logger) {
+	if os.Getenv("LOG_ROTATE_TOOL_LOGS") != "true" {
+		return
+	}
+	go func() {
+		for {
+			rotateToolLogs(logger)
+			time.Sleep(time.Hour)
+		}
+	}()
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mdp/qrterminal"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"rsc.io/qr"
+)
+
+// States of the WhatsApp session shown on the login page
+const (
+	loginStatePairing      = "pairing"      // waiting for a QR code to be scanned
+	loginStateConnected    = "connected"    // logged in and connected
+	loginStateDisconnected = "disconnected" // logged in, reconnecting
+	loginStateLoggedOut    = "logged_out"   // no session; pair again to continue
+)
+
+// LoginStatus is the state of the WhatsApp session, as served on /api/login
+type LoginStatus struct {
+	State     string    `json:"state"`
+	JID       string    `json:"jid,omitempty"`
+	PushName  string    `json:"push_name,omitempty"`
+	HasQR     bool      `json:"has_qr"`
+	QRCode    string    `json:"qr_code,omitempty"` // the code to scan, for terminals (see the admin command)
+	Error     string    `json:"error,omitempty"`   // why the last pairing ended without success
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// loginTracker follows the session state and the current QR code
+type loginTracker struct {
+	mu        sync.Mutex
+	state     string
+	qrCode    string
+	err       string
+	updatedAt time.Time
+	pairing   bool
+}
+
+var login = &loginTracker{state: loginStateDisconnected, updatedAt: time.Now()}
+
+// set records a new state; the QR code is only kept while pairing
+func (tracker *loginTracker) set(state, qrCode, err string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	tracker.state, tracker.qrCode, tracker.err, tracker.updatedAt = state, qrCode, err, time.Now()
+}
+
+// status returns the session state of a client
+func (tracker *loginTracker) status(client *whatsmeow.Client) LoginStatus {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	status := LoginStatus{State: tracker.state, HasQR: tracker.qrCode != "", QRCode: tracker.qrCode, Error: tracker.err, UpdatedAt: tracker.updatedAt}
+	if client.Store.ID != nil {
+		status.JID = client.Store.ID.ToNonAD().String()
+		status.PushName = client.Store.PushName
+	}
+	return status
+}
+
+// startPairing connects a client without a session and shows its QR codes in the terminal and on the login page.
+// The returned channel receives true once a code was scanned, or false when pairing ended without success.
+func startPairing(client *whatsmeow.Client, logger waLog.Logger) (<-chan bool, error) {
+	login.mu.Lock()
+	if login.pairing {
+		login.mu.Unlock()
+		return nil, fmt.Errorf("pairing already in progress")
+	}
+	login.pairing = true
+	login.mu.Unlock()
+
+	stopPairing := func() {
+		login.mu.Lock()
+		login.pairing = false
+		login.mu.Unlock()
+	}
+
+	qrChan, err := client.GetQRChannel(context.Background())
+	if err != nil {
+		stopPairing()
+		return nil, fmt.Errorf("failed to start pairing: %v", err)
+	}
+	if err := client.Connect(); err != nil {
+		stopPairing()
+		return nil, fmt.Errorf("failed to connect: %v", err)
+	}
+	login.set(loginStatePairing, "", "")
+
+	paired := make(chan bool, 1)
+	go func() {
+		defer stopPairing()
+		for evt := range qrChan {
+			switch {
+			case evt.Event == whatsmeow.QRChannelEventCode:
+				fmt.Println("\nScan this QR code with your WhatsApp app (or open /login on the bridge API):")
+				qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, os.Stdout)
+				login.set(loginStatePairing, evt.Code, "")
+			case evt == whatsmeow.QRChannelSuccess:
+				login.set(loginStateConnected, "", "")
+				paired <- true
+				return
+			default:
+				reason := evt.Event
+				if evt.Error != nil {
+					reason = evt.Error.Error()
+				}
+				logger.Warnf("Pairing ended without success: %s", reason)
+				login.set(loginStateLoggedOut, "", reason)
+				paired <- false
+				return
+			}
+		}
+		paired <- false
+	}()
+	return paired, nil
+}
+
+// handleLoginEvent keeps the login state in step with the client's connection events
+func handleLoginEvent(evt interface{}) {
+	switch evt.(type) {
+	case *events.Connected:
+		login.set(loginStateConnected, "", "")
+	case *events.Disconnected:
+		// Pairing ends with a disconnect too, which isn't a lost session
+		login.mu.Lock()
+		if login.state == loginStateConnected {
+			login.state, login.updatedAt = loginStateDisconnected, time.Now()
+		}
+		login.mu.Unlock()
+	case *events.LoggedOut:
+		login.set(loginStateLoggedOut, "", "logged out from the phone")
+	}
+}
+
+// registerLoginHandlers serves the login page, its QR code image and its actions
+func registerLoginHandlers(client *whatsmeow.Client, db *sql.DB, logger waLog.Logger) {
+	page := requireAPICapability(db, apiCapabilityAdmin, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprint(w, renderLoginPage(login.status(client)))
+	})
+	http.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		// Browsers can't send bearer tokens: /login?token=<token> stores it in a cookie once
+		if token := r.URL.Query().Get("token"); token != "" {
+			http.SetCookie(w, &http.Cookie{Name: apiTokenCookie, Value: token, Path: "/", HttpOnly: true, Secure: requestIsHTTPS(r), SameSite: http.SameSiteStrictMode})
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		page(w, r)
+	})
+
+	http.HandleFunc("/login/qr.png", requireAPICapability(db, apiCapabilityAdmin, func(w http.ResponseWriter, r *http.Request) {
+		login.mu.Lock()
+		code := login.qrCode
+		login.mu.Unlock()
+		if code == "" {
+			http.Error(w, "No QR code to scan", http.StatusNotFound)
+			return
+		}
+		image, err := qr.Encode(code, qr.L)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to render QR code: %v", err), http.StatusInternalServerError)
+			return
+		}
+		image.Scale = 8
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(image.PNG())
+	}))
+
+	http.HandleFunc("/login/pair", requireLoginSession(db, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if client.Store.ID != nil {
+			http.Error(w, "Already logged in; log out first", http.StatusConflict)
+			return
+		}
+		logger.Infof("Pairing from the login page, requested from %s", clientIP(r))
+		client.Disconnect()
+		if _, err := startPairing(client, logger); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}))
+
+	http.HandleFunc("/login/logout", requireLoginSession(db, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if client.Store.ID != nil {
+			logger.Warnf("Logging out from the login page, requested from %s", clientIP(r))
+			if err := client.Logout(r.Context()); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to log out: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		login.set(loginStateLoggedOut, "", "")
+		if _, err := startPairing(client, logger); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}))
+
+	http.HandleFunc("/api/login", requireAPICapability(db, apiCapabilityAdmin, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(login.status(client))
+	}))
+}
+
+// requireLoginSession guards the login page's actions, which unlink or re-pair the device, against forms posted
+// from other sites: a browser's request must come from the bridge's own pages and carry an admin token, from the
+// cookie /login?token= sets, even when BRIDGE_API_AUTH isn't "required". Only requests from this machine that no
+// browser sent, such as the admin command's, may go without one.
+func requireLoginSession(db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
+	guarded := requireAPICapability(db, apiCapabilityAdmin, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !sameOriginRequest(r) {
+			http.Error(w, "Cross-site request refused", http.StatusForbidden)
+			return
+		}
+		if apiTokenSecret(r) == "" && !isLocalToolRequest(r) {
+			http.Error(w, "An admin token is required: open /login?token=<token> first", http.StatusUnauthorized)
+			return
+		}
+		guarded(w, r)
+	}
+}
+
+// renderLoginPage renders the session status, with the QR code while pairing; it refreshes itself every 5 seconds
+func renderLoginPage(status LoginStatus) string {
+	var body string
+	switch status.State {
+	case loginStatePairing:
+		body = `<p>Open WhatsApp on your phone, go to <b>Linked devices</b> and scan this code. It changes every few seconds.</p>`
+		if status.HasQR {
+			body += fmt.Sprintf(`<img src="/login/qr.png?t=%d" alt="QR code" width="264" height="264">`, status.UpdatedAt.UnixNano())
+		} else {
+			body += `<p>Waiting for a QR code…</p>`
+		}
+	case loginStateConnected, loginStateDisconnected:
+		label := "✅ Connected"
+		if status.State == loginStateDisconnected {
+			label = "⏳ Reconnecting"
+		}
+		body = fmt.Sprintf(`<p class="state">%s as <b>%s</b> (%s)</p>
+<form method="post" action="/login/logout" onsubmit="return confirm('Unlink this device from WhatsApp and pair again?')">
+<button>Log out and pair again</button></form>`, label, html.EscapeString(status.PushName), html.EscapeString(status.JID))
+	default:
+		body = `<p class="state">Not logged in.</p>`
+		if status.Error != "" {
+			body += fmt.Sprintf(`<p>Last pairing: %s</p>`, html.EscapeString(status.Error))
+		}
+		body += `<form method="post" action="/login/pair"><button>Show a QR code</button></form>`
+	}
+
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta http-equiv="refresh" content="5">
+<title>WhatsApp bridge</title>
+<style>body{font-family:sans-serif;max-width:28em;margin:3em auto;color:#222}.state{font-size:1.2em}img{image-rendering:pixelated;border:1px solid #ddd}button{font-size:1em;padding:.5em 1em}small{color:#888}</style>
+</head><body>
+<h1>WhatsApp bridge</h1>
+%s
+<p><small>Updated %s</small></p>
+</body></html>`, body, status.UpdatedAt.Format("2006-01-02 15:04:05"))
+}
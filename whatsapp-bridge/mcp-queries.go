@@ -0,0 +1,1221 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mcpMessage is a message as the MCP tools return it
+type mcpMessage struct {
+	ID         string    `json:"id"`
+	ChatJID    string    `json:"chat_jid"`
+	ChatName   string    `json:"chat_name,omitempty"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name,omitempty"` // set by tools that resolve names (see fillSenderNames)
+	Content    string    `json:"content"`
+	MediaType  string    `json:"media_type,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	IsFromMe   bool      `json:"is_from_me"`
+	Edited     bool      `json:"edited,omitempty"`
+	Deleted    bool      `json:"deleted,omitempty"`
+}
+
+// mcpChat is a chat as the MCP tools return it
+type mcpChat struct {
+	JID              string     `json:"jid"`
+	Name             string     `json:"name"`
+	Type             string     `json:"type"` // "direct", "group", "broadcast" or "channel"
+	IsGroup          bool       `json:"is_group"`
+	ParticipantCount *int       `json:"participant_count,omitempty"` // groups only, once the bridge refreshed them
+	Unread           bool       `json:"unread"`                      // unread messages, or marked as unread
+	UnreadCount      int        `json:"unread_count"`
+	LastMessageTime  *time.Time `json:"last_message_time"`
+	LastMessage      *string    `json:"last_message,omitempty"` // a preview: the start of the text, or the media type
+	LastSender       *string    `json:"last_sender,omitempty"`
+	LastIsFromMe     *bool      `json:"last_is_from_me,omitempty"`
+}
+
+// Characters of the last message shown in a chat's preview
+const mcpPreviewLength = 200
+
+// Characters of an episode shown in a semantic search result
+const mcpSnippetLength = 500
+
+// Most excerpts semantic_search returns
+const mcpMaxSemanticResults = 50
+
+// mcpChatType tells direct chats, groups, broadcast lists and channels apart by their JID
+func mcpChatType(jid string) string {
+	switch {
+	case strings.HasSuffix(jid, "@g.us"):
+		return "group"
+	case strings.HasSuffix(jid, "@broadcast"):
+		return "broadcast"
+	case strings.HasSuffix(jid, "@newsletter"):
+		return "channel"
+	default:
+		return "direct"
+	}
+}
+
+// The columns of mcpMessage, from messages m joined with their chat c
+const mcpMessageColumns = `m.id, m.chat_jid, COALESCE(c.name, ''), COALESCE(m.sender, ''), COALESCE(m.content, ''),
+	COALESCE(m.media_type, ''), m.timestamp, COALESCE(m.is_from_me, 0), m.edited_at IS NOT NULL, m.deleted_at IS NOT NULL
+	FROM messages m LEFT JOIN chats c ON c.jid = m.chat_jid`
+
+// databases returns the message stores: messages.db and, when configured, the direct chat database
+func (store *MessageStore) databases() []*sql.DB {
+	if store.direct == nil {
+		return []*sql.DB{store.db}
+	}
+	return []*sql.DB{store.db, store.direct}
+}
+
+// queryMCPMessages reads messages selected by a query's clauses (after mcpMessageColumns) from one database
+func (store *MessageStore) queryMCPMessages(db *sql.DB, clauses string, args ...interface{}) ([]mcpMessage, error) {
+	rows, err := db.Query("SELECT "+mcpMessageColumns+" "+clauses, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []mcpMessage
+	for rows.Next() {
+		var message mcpMessage
+		if err := rows.Scan(&message.ID, &message.ChatJID, &message.ChatName, &message.Sender, &message.Content,
+			&message.MediaType, &message.Timestamp, &message.IsFromMe, &message.Edited, &message.Deleted); err != nil {
+			return nil, err
+		}
+		message.Content = store.open(message.Content)
+		if message.Deleted {
+			message.Content = ""
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+// chatScopeClause restricts a query's column to the client's chats: "" when it may access every chat
+func (call *mcpCall) chatScopeClause(column string) (string, []interface{}) {
+	chats := call.chatScope()
+	if chats == nil {
+		return "", nil
+	}
+	if len(chats) == 0 {
+		return " AND 0", nil
+	}
+	args := make([]interface{}, len(chats))
+	for i, chat := range chats {
+		args[i] = chat
+	}
+	return " AND " + column + " IN (?" + strings.Repeat(", ?", len(chats)-1) + ")", args
+}
+
+// findMessages returns the messages matching a condition in both stores, newest first, a page at a time
+func (call *mcpCall) findMessages(condition string, args []interface{}, limit, offset int) ([]mcpMessage, error) {
+	scope, scopeArgs := call.chatScopeClause("m.chat_jid")
+	args = append(append([]interface{}{}, args...), scopeArgs...)
+	args = append(args, limit+offset)
+
+	var messages []mcpMessage
+	for _, db := range call.server.store.databases() {
+		found, err := call.server.store.queryMCPMessages(db, "WHERE "+condition+scope+" ORDER BY m.timestamp DESC LIMIT ?", args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read messages: %v", err)
+		}
+		messages = append(messages, found...)
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Timestamp.After(messages[j].Timestamp) })
+	if offset >= len(messages) {
+		return nil, nil
+	}
+	return messages[offset:min(offset+limit, len(messages))], nil
+}
+
+// formatMessages writes messages one per line as "[time] Chat: name From: sender: content", with the media
+// type, edits and reactions
+func (call *mcpCall) formatMessages(messages []mcpMessage) string {
+	if len(messages) == 0 {
+		return "No messages to display."
+	}
+	names := make(map[string]string)
+	var text strings.Builder
+	for _, message := range messages {
+		text.WriteString("[" + message.Timestamp.Format("2006-01-02 15:04:05") + "] ")
+		if message.ChatName != "" {
+			text.WriteString("Chat: " + message.ChatName + " ")
+		}
+
+		sender := "Me"
+		if !message.IsFromMe {
+			if _, ok := names[message.Sender]; !ok {
+				names[message.Sender] = call.server.senderName(message.Sender)
+			}
+			sender = names[message.Sender]
+		}
+		text.WriteString("From: " + sender + ": ")
+		if message.MediaType != "" {
+			fmt.Fprintf(&text, "[%s - Message ID: %s - Chat JID: %s] ", message.MediaType, message.ID, message.ChatJID)
+		}
+		switch {
+		case message.Deleted:
+			text.WriteString("[message deleted]")
+		case message.Edited:
+			text.WriteString(message.Content + " (edited)")
+		default:
+			text.WriteString(message.Content)
+		}
+		if reactions := call.server.reactionSummary(message.ChatJID, message.ID); reactions != "" {
+			text.WriteString(" [reactions: " + reactions + "]")
+		}
+		text.WriteString("\n")
+	}
+	return text.String()
+}
+
+// senderName returns the contact or chat name of a message sender, or the sender itself when unknown
+func (server *MCPServer) senderName(sender string) string {
+	if name := lookupSenderName(server.store.db, sender); name != "" {
+		return name
+	}
+	return sender
+}
+
+// reactionSummary returns the reactions to a message as e.g. "👍×3 ❤️×1", most frequent first
+func (server *MCPServer) reactionSummary(chatJID, messageID string) string {
+	rows, err := server.store.dbFor(chatJID).Query(`
+		SELECT emoji, COUNT(*) FROM reactions WHERE chat_jid = ? AND message_id = ?
+		GROUP BY emoji ORDER BY COUNT(*) DESC, emoji
+	`, chatJID, messageID)
+	if err != nil {
+		return ""
+	}
+	defer rows.Close()
+
+	var counts []string
+	for rows.Next() {
+		var emoji string
+		var count int
+		if rows.Scan(&emoji, &count) == nil {
+			counts = append(counts, fmt.Sprintf("%s×%d", emoji, count))
+		}
+	}
+	return strings.Join(counts, " ")
+}
+
+// listMessages returns the messages matching the list_messages filters as text, each with its context
+func (call *mcpCall) listMessages(args mcpArgs) (string, error) {
+	condition := "1"
+	var params []interface{}
+	for _, bound := range []struct{ name, op string }{{"after", ">"}, {"before", "<"}} {
+		value := args.String(bound.name)
+		if value == "" {
+			continue
+		}
+		t, err := parseMCPTime(value)
+		if err != nil {
+			return "", fmt.Errorf("invalid date format for %q: %s. Please use ISO-8601 format", bound.name, value)
+		}
+		condition += " AND m.timestamp " + bound.op + " ?"
+		params = append(params, t)
+	}
+	if sender := args.String("sender_phone_number"); sender != "" {
+		condition += " AND m.sender = ?"
+		params = append(params, strings.Split(sender, "@")[0])
+	}
+	if chatJID := args.String("chat_jid"); chatJID != "" {
+		condition += " AND m.chat_jid = ?"
+		params = append(params, chatJID)
+	}
+	if query := args.String("query"); query != "" {
+		condition += " AND LOWER(m.content) LIKE LOWER(?)"
+		params = append(params, "%"+query+"%")
+	}
+
+	limit := args.Int("limit", 20)
+	messages, err := call.findMessages(condition, params, limit, args.Int("page", 0)*limit)
+	if err != nil {
+		return "", err
+	}
+	if !args.Bool("include_context", true) {
+		return call.formatMessages(messages), nil
+	}
+
+	var withContext []mcpMessage
+	for _, message := range messages {
+		before, after, err := call.server.surroundingMessages(message, args.Int("context_before", 1), args.Int("context_after", 1))
+		if err != nil {
+			return "", err
+		}
+		withContext = append(withContext, before...)
+		withContext = append(withContext, message)
+		withContext = append(withContext, after...)
+	}
+	return call.formatMessages(withContext), nil
+}
+
+// parseMCPTime parses an ISO-8601 time or date given to a tool
+func parseMCPTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// surroundingMessages returns up to before messages sent just before a message in its chat, and up to after
+// sent just after, in time order. Messages sent in the same second are ordered by ID.
+func (server *MCPServer) surroundingMessages(message mcpMessage, before, after int) ([]mcpMessage, []mcpMessage, error) {
+	db := server.store.dbFor(message.ChatJID)
+	earlier, err := server.store.queryMCPMessages(db, `
+		WHERE m.chat_jid = ? AND (m.timestamp < ? OR (m.timestamp = ? AND m.id < ?))
+		ORDER BY m.timestamp DESC, m.id DESC LIMIT ?
+	`, message.ChatJID, message.Timestamp, message.Timestamp, message.ID, before)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read messages: %v", err)
+	}
+	for i, j := 0, len(earlier)-1; i < j; i, j = i+1, j-1 {
+		earlier[i], earlier[j] = earlier[j], earlier[i]
+	}
+	later, err := server.store.queryMCPMessages(db, `
+		WHERE m.chat_jid = ? AND (m.timestamp > ? OR (m.timestamp = ? AND m.id > ?))
+		ORDER BY m.timestamp ASC, m.id ASC LIMIT ?
+	`, message.ChatJID, message.Timestamp, message.Timestamp, message.ID, after)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read messages: %v", err)
+	}
+	return earlier, later, nil
+}
+
+// Most messages get_message_context returns on either side of the message
+const mcpMaxContextMessages = 50
+
+// getMessageContext returns a message with the messages sent before and after it in its chat, with their
+// senders' names. Message IDs are only unique within a chat, so chatJID picks the chat when given.
+func (call *mcpCall) getMessageContext(messageID, chatJID string, before, after int) (interface{}, error) {
+	if before < 0 || after < 0 || before > mcpMaxContextMessages || after > mcpMaxContextMessages {
+		return nil, fmt.Errorf("before and after must be between 0 and %d", mcpMaxContextMessages)
+	}
+	condition, args := "m.id = ?", []interface{}{messageID}
+	if chatJID != "" {
+		if err := call.checkChat(chatJID); err != nil {
+			return nil, err
+		}
+		condition, args = "m.id = ? AND m.chat_jid = ?", []interface{}{messageID, chatJID}
+	}
+	messages, err := call.findMessages(condition, args, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("message with ID %s not found", messageID)
+	}
+	earlier, later, err := call.server.surroundingMessages(messages[0], before, after)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string)
+	call.server.fillSenderNames(messages, names)
+	call.server.fillSenderNames(earlier, names)
+	call.server.fillSenderNames(later, names)
+	return map[string]interface{}{"message": messages[0], "before": nonNil(earlier), "after": nonNil(later)}, nil
+}
+
+// getThread returns the reply thread a message belongs to, finding the message's chat when chatJID is empty
+func (call *mcpCall) getThread(messageID, chatJID string) (interface{}, error) {
+	if chatJID == "" {
+		messages, err := call.findMessages("m.id = ?", []interface{}{messageID}, 1, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) == 0 {
+			return nil, fmt.Errorf("message with ID %s not found", messageID)
+		}
+		chatJID = messages[0].ChatJID
+	}
+	return call.get("/api/thread", map[string]string{"chat_jid": chatJID, "message_id": messageID})
+}
+
+// fillSenderNames sets the messages' sender names, "Me" for mine, looking each sender up once in names
+func (server *MCPServer) fillSenderNames(messages []mcpMessage, names map[string]string) {
+	for i := range messages {
+		if messages[i].IsFromMe {
+			messages[i].SenderName = "Me"
+			continue
+		}
+		if _, ok := names[messages[i].Sender]; !ok {
+			names[messages[i].Sender] = server.senderName(messages[i].Sender)
+		}
+		messages[i].SenderName = names[messages[i].Sender]
+	}
+}
+
+// nonNil returns an empty list for nil, so it encodes as [] rather than null
+func nonNil(messages []mcpMessage) []mcpMessage {
+	if messages == nil {
+		return []mcpMessage{}
+	}
+	return messages
+}
+
+// getLastInteraction returns the most recent message from or to a contact, as text
+func (call *mcpCall) getLastInteraction(jid string) (string, error) {
+	messages, err := call.findMessages("(m.sender IN (?, ?) OR m.chat_jid = ?)",
+		[]interface{}{jid, strings.Split(jid, "@")[0], jid}, 1, 0)
+	if err != nil {
+		return "", err
+	}
+	if len(messages) == 0 {
+		return "No messages found for " + jid, nil
+	}
+	return call.formatMessages(messages), nil
+}
+
+// queryChats reads the chats selected by a condition on chats (and their chat_state s), in the client's scope
+func (call *mcpCall) queryChats(condition, order string, args []interface{}, limit, offset int, includeLastMessage bool) ([]mcpChat, error) {
+	scope, scopeArgs := call.chatScopeClause("jid")
+	args = append(append(append([]interface{}{}, args...), scopeArgs...), limit, offset)
+	rows, err := call.server.store.db.Query(`
+		SELECT jid, COALESCE(name, ''), last_message_time, COALESCE(s.unread_count, 0), COALESCE(s.marked_unread, 0), s.participant_count
+		FROM chats LEFT JOIN chat_state s ON s.chat_jid = chats.jid
+		WHERE `+condition+scope+" ORDER BY "+order+" LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chats: %v", err)
+	}
+	var chats []mcpChat
+	for rows.Next() {
+		var chat mcpChat
+		var lastMessageTime sql.NullTime
+		var markedUnread bool
+		var participantCount sql.NullInt64
+		if err := rows.Scan(&chat.JID, &chat.Name, &lastMessageTime, &chat.UnreadCount, &markedUnread, &participantCount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		chat.Type = mcpChatType(chat.JID)
+		chat.IsGroup = chat.Type == "group"
+		chat.Unread = chat.UnreadCount > 0 || markedUnread
+		if participantCount.Valid && chat.IsGroup {
+			count := int(participantCount.Int64)
+			chat.ParticipantCount = &count
+		}
+		if lastMessageTime.Valid {
+			chat.LastMessageTime = &lastMessageTime.Time
+		}
+		chats = append(chats, chat)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if includeLastMessage {
+		for i := range chats {
+			call.server.fillLastMessage(&chats[i])
+		}
+	}
+	if chats == nil {
+		chats = []mcpChat{}
+	}
+	return chats, nil
+}
+
+// fillLastMessage adds a preview of a chat's last message, from the store its messages are in
+func (server *MCPServer) fillLastMessage(chat *mcpChat) {
+	var content, mediaType, sender string
+	var isFromMe bool
+	err := server.store.dbFor(chat.JID).QueryRow(`
+		SELECT COALESCE(content, ''), COALESCE(media_type, ''), COALESCE(sender, ''), COALESCE(is_from_me, 0) FROM messages
+		WHERE chat_jid = ? AND deleted_at IS NULL ORDER BY timestamp DESC LIMIT 1
+	`, chat.JID).Scan(&content, &mediaType, &sender, &isFromMe)
+	if err != nil {
+		return
+	}
+	content = strings.ReplaceAll(server.store.open(content), "\n", " ")
+	if len([]rune(content)) > mcpPreviewLength {
+		content = string([]rune(content)[:mcpPreviewLength]) + "..."
+	}
+	if mediaType != "" {
+		content = strings.TrimSpace("[" + mediaType + "] " + content)
+	}
+	chat.LastMessage, chat.LastSender, chat.LastIsFromMe = &content, &sender, &isFromMe
+}
+
+// listChats returns the chats whose name or JID matches a query, most recently active or by name
+func (call *mcpCall) listChats(query string, limit, page int, includeLastMessage bool, sortBy string, unreadOnly bool) ([]mcpChat, error) {
+	condition := "1"
+	var args []interface{}
+	if query != "" {
+		condition = "(LOWER(name) LIKE LOWER(?) OR jid LIKE ?)"
+		args = append(args, "%"+query+"%", "%"+query+"%")
+	}
+	if unreadOnly {
+		condition += " AND (s.unread_count > 0 OR s.marked_unread)"
+	}
+	order := "last_message_time DESC"
+	if sortBy == "name" {
+		order = "name"
+	}
+	return call.queryChats(condition, order, args, limit, page*limit, includeLastMessage)
+}
+
+// Most unread messages get_unread_messages returns per chat, and most chats it covers
+const (
+	mcpMaxUnreadPerChat = 100
+	mcpMaxUnreadChats   = 100
+)
+
+// mcpUnreadChat is a chat with unread messages as get_unread_messages returns it
+type mcpUnreadChat struct {
+	ChatJID      string       `json:"chat_jid"`
+	ChatName     string       `json:"chat_name"`
+	Type         string       `json:"type"`
+	UnreadCount  int          `json:"unread_count"`
+	MarkedUnread bool         `json:"marked_unread,omitempty"` // marked as unread by hand, without unread messages
+	Messages     []mcpMessage `json:"messages"`                // the latest unread messages, oldest first
+	Omitted      int          `json:"omitted,omitempty"`       // older unread messages left out
+}
+
+// getUnreadMessages returns the unread messages of every chat, grouped by chat, most recently active chat first.
+// Muted chats are left out unless includeMuted.
+func (call *mcpCall) getUnreadMessages(limitPerChat int, includeMuted bool) (interface{}, error) {
+	if limitPerChat < 1 || limitPerChat > mcpMaxUnreadPerChat {
+		return nil, fmt.Errorf("limit_per_chat must be between 1 and %d", mcpMaxUnreadPerChat)
+	}
+	condition := "(s.unread_count > 0 OR s.marked_unread)"
+	if !includeMuted {
+		condition += " AND jid NOT IN (SELECT chat_jid FROM chat_settings WHERE muted)"
+	}
+	chats, err := call.queryChats(condition, "last_message_time DESC", nil, mcpMaxUnreadChats, 0, false)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string)
+	unread := []mcpUnreadChat{}
+	total := 0
+	for _, chat := range chats {
+		// The unread messages are the latest ones from others
+		messages, err := call.server.store.queryMCPMessages(call.server.store.dbFor(chat.JID), `
+			WHERE m.chat_jid = ? AND NOT COALESCE(m.is_from_me, 0) AND m.deleted_at IS NULL
+			ORDER BY m.timestamp DESC LIMIT ?
+		`, chat.JID, min(chat.UnreadCount, limitPerChat))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read messages of %s: %v", chat.JID, err)
+		}
+		sort.SliceStable(messages, func(i, j int) bool { return messages[i].Timestamp.Before(messages[j].Timestamp) })
+		call.server.fillSenderNames(messages, names)
+		unread = append(unread, mcpUnreadChat{
+			ChatJID:      chat.JID,
+			ChatName:     chat.Name,
+			Type:         chat.Type,
+			UnreadCount:  chat.UnreadCount,
+			MarkedUnread: chat.UnreadCount == 0,
+			Messages:     nonNil(messages),
+			Omitted:      max(chat.UnreadCount-len(messages), 0),
+		})
+		total += chat.UnreadCount
+	}
+	return map[string]interface{}{"total_unread": total, "chats": unread}, nil
+}
+
+// getChat returns a chat by JID
+func (call *mcpCall) getChat(chatJID string, includeLastMessage bool) (interface{}, error) {
+	chats, err := call.queryChats("jid = ?", "jid", []interface{}{chatJID}, 1, 0, includeLastMessage)
+	if err != nil {
+		return nil, err
+	}
+	if len(chats) == 0 {
+		return nil, fmt.Errorf("chat %s not found", chatJID)
+	}
+	return chats[0], nil
+}
+
+// getDirectChatByContact returns the direct chat with a phone number
+func (call *mcpCall) getDirectChatByContact(phone string) (interface{}, error) {
+	chats, err := call.queryChats("jid LIKE ? AND jid NOT LIKE '%@g.us'", "last_message_time DESC",
+		[]interface{}{"%" + phone + "%"}, 1, 0, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(chats) == 0 {
+		return nil, fmt.Errorf("no direct chat found for %s", phone)
+	}
+	return chats[0], nil
+}
+
+// getContactChats returns the chats a contact wrote in, and the direct chat with them, most recently active first
+func (call *mcpCall) getContactChats(jid string, limit, page int) ([]mcpChat, error) {
+	chatJIDs := []interface{}{jid}
+	for _, db := range call.server.store.databases() {
+		rows, err := db.Query("SELECT DISTINCT chat_jid FROM messages WHERE sender IN (?, ?)", jid, strings.Split(jid, "@")[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read messages: %v", err)
+		}
+		for rows.Next() {
+			var chatJID string
+			if rows.Scan(&chatJID) == nil {
+				chatJIDs = append(chatJIDs, chatJID)
+			}
+		}
+		rows.Close()
+	}
+	condition := "jid IN (?" + strings.Repeat(", ?", len(chatJIDs)-1) + ")"
+	return call.queryChats(condition, "last_message_time DESC", chatJIDs, limit, page*limit, true)
+}
+
+// mcpContact is a contact found by search_contacts
+type mcpContact struct {
+	PhoneNumber string `json:"phone_number"`
+	Name        string `json:"name"`
+	JID         string `json:"jid"`
+}
+
+// searchContacts returns the direct chats whose name or phone number matches a query
+func (call *mcpCall) searchContacts(query string) ([]mcpContact, error) {
+	pattern := "%" + query + "%"
+	chats, err := call.queryChats("(LOWER(name) LIKE LOWER(?) OR LOWER(jid) LIKE LOWER(?)) AND jid NOT LIKE '%@g.us'",
+		"name, jid", []interface{}{pattern, pattern}, 50, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	contacts := []mcpContact{}
+	for _, chat := range chats {
+		contacts = append(contacts, mcpContact{PhoneNumber: strings.Split(chat.JID, "@")[0], Name: chat.Name, JID: chat.JID})
+	}
+	return contacts, nil
+}
+
+// Lowest name score (0-1) at which resolve_contact returns a contact, and most candidates it returns
+const (
+	mcpContactMatchThreshold = 0.55
+	mcpMaxContactCandidates  = 20
+)
+
+// mcpRecentContactPeriod is how far back resolve_contact counts messages to prefer the people you talk to
+const mcpRecentContactPeriod = 30 * 24 * time.Hour
+
+// mcpContactCandidate is a contact resolve_contact found for a reference
+type mcpContactCandidate struct {
+	JID             string     `json:"jid"`
+	PhoneNumber     string     `json:"phone_number"`
+	Name            string     `json:"name"`
+	Score           float64    `json:"score"`
+	MatchedOn       string     `json:"matched_on"`        // the name, phone number or business name that matched
+	Context         string     `json:"context,omitempty"` // the business or group that matched the "from ..." part
+	RecentMessages  int        `json:"recent_messages"`
+	LastInteraction *time.Time `json:"last_interaction,omitempty"`
+
+	names    []string
+	business string
+}
+
+// splitContactReference splits "joão from acme" into the name and the company or group the person is from
+func splitContactReference(reference string) (string, string) {
+	words := strings.Fields(reference)
+	for i := 1; i < len(words)-1; i++ {
+		switch strings.ToLower(words[i]) {
+		case "from", "at", "@":
+			return strings.Join(words[:i], " "), strings.Join(words[i+1:], " ")
+		}
+	}
+	return reference, ""
+}
+
+// contactNameScore rates how well a contact name matches a reference from 0 to 1, ignoring case and accents
+func contactNameScore(reference, name string) float64 {
+	return groupNameScore(stripAccents(reference), stripAccents(name))
+}
+
+// loadContactCandidates returns the people known from the contacts table and direct chats, by JID
+func (call *mcpCall) loadContactCandidates() (map[string]*mcpContactCandidate, error) {
+	db := call.server.store.db
+	candidates := make(map[string]*mcpContactCandidate)
+	candidate := func(jid string) *mcpContactCandidate {
+		if candidates[jid] == nil {
+			candidates[jid] = &mcpContactCandidate{JID: jid, PhoneNumber: strings.Split(jid, "@")[0]}
+		}
+		return candidates[jid]
+	}
+
+	rows, err := db.Query(`
+		SELECT jid, COALESCE(name, ''), COALESCE(full_name, ''), COALESCE(first_name, ''), COALESCE(push_name, ''),
+			COALESCE(business_name, '')
+		FROM contacts WHERE jid NOT LIKE '%@g.us'
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contacts: %v", err)
+	}
+	for rows.Next() {
+		var jid, name, fullName, firstName, pushName, business string
+		if err := rows.Scan(&jid, &name, &fullName, &firstName, &pushName, &business); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan contact: %v", err)
+		}
+		contact := candidate(jid)
+		contact.Name, contact.business = name, business
+		contact.names = append(contact.names, name, fullName, firstName, pushName)
+	}
+	rows.Close()
+
+	chats, err := call.queryChats("jid NOT LIKE '%@g.us'", "last_message_time DESC", nil, -1, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, chat := range chats {
+		contact := candidate(chat.JID)
+		if contact.Name == "" {
+			contact.Name = chat.Name
+		}
+		contact.names = append(contact.names, chat.Name)
+		if chat.LastMessageTime != nil {
+			contact.LastInteraction = chat.LastMessageTime
+		}
+	}
+
+	rows, err = db.Query(`
+		SELECT chat_jid, COUNT(*) FROM messages
+		WHERE timestamp > ? AND chat_jid NOT LIKE '%@g.us'
+		GROUP BY chat_jid
+	`, time.Now().Add(-mcpRecentContactPeriod))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count recent messages: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var jid string
+		var count int
+		if rows.Scan(&jid, &count) == nil && candidates[jid] != nil {
+			candidates[jid].RecentMessages = count
+		}
+	}
+
+	// Tokens limited to some chats only see the people they have a direct chat with
+	if scope := call.chatScope(); scope != nil {
+		for jid := range candidates {
+			if !slices.Contains(scope, jid) {
+				delete(candidates, jid)
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// contextGroupSenders returns the senders in the groups whose name matches context, by phone number or LID
+// user, with the name of the group they were seen in
+func (call *mcpCall) contextGroupSenders(context string) (map[string]string, error) {
+	groups, err := loadGroupCandidates(call.server.store.db)
+	if err != nil {
+		return nil, err
+	}
+	senders := make(map[string]string)
+	for _, group := range groups {
+		if contactNameScore(context, group.Name) < groupNameMatchThreshold {
+			continue
+		}
+		for _, db := range call.server.store.databases() {
+			rows, err := db.Query("SELECT DISTINCT sender FROM messages WHERE chat_jid = ? AND COALESCE(sender, '') != ''", group.JID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read messages: %v", err)
+			}
+			for rows.Next() {
+				var sender string
+				if rows.Scan(&sender) == nil && senders[strings.Split(sender, "@")[0]] == "" {
+					senders[strings.Split(sender, "@")[0]] = group.Name
+				}
+			}
+			rows.Close()
+		}
+	}
+	return senders, nil
+}
+
+// resolveContact finds the people a partial name such as "joão from acme" may refer to, best match first.
+// Names match fuzzily and regardless of accents; a "from ..." part must match the contact's business name, a
+// word of their name or a group they wrote in, and people you talked to lately rank higher among close matches.
+func (call *mcpCall) resolveContact(reference string, limit int) (interface{}, error) {
+	if strings.TrimSpace(reference) == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if limit < 1 || limit > mcpMaxContactCandidates {
+		return nil, fmt.Errorf("limit must be between 1 and %d", mcpMaxContactCandidates)
+	}
+	name, context := splitContactReference(strings.TrimSpace(reference))
+
+	candidates, err := call.loadContactCandidates()
+	if err != nil {
+		return nil, err
+	}
+	var groupSenders map[string]string
+	if context != "" {
+		if groupSenders, err = call.contextGroupSenders(context); err != nil {
+			return nil, err
+		}
+	}
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, name)
+	if len(digits) < 4 || len(digits) < len(strings.Join(strings.Fields(name), ""))-2 {
+		digits = ""
+	}
+
+	matches := []mcpContactCandidate{}
+	for _, candidate := range candidates {
+		score, matchedOn := 0.0, ""
+		if digits != "" && strings.Contains(candidate.PhoneNumber, digits) {
+			score, matchedOn = 0.8+0.2*float64(len(digits))/float64(len(candidate.PhoneNumber)), candidate.PhoneNumber
+		}
+		for _, candidateName := range candidate.names {
+			if nameScore := contactNameScore(name, candidateName); nameScore > score {
+				score, matchedOn = nameScore, candidateName
+			}
+		}
+		if context != "" {
+			switch {
+			case candidate.business != "" && contactNameScore(context, candidate.business) >= groupNameMatchThreshold:
+				candidate.Context = candidate.business
+			case groupSenders[candidate.PhoneNumber] != "":
+				candidate.Context = groupSenders[candidate.PhoneNumber]
+			case contactNameScore(name+" "+context, matchedOn) >= score:
+				// The context is part of the saved name, as in "João Acme"
+				score, candidate.Context = contactNameScore(name+" "+context, matchedOn), matchedOn
+			default:
+				score *= 0.7
+			}
+		}
+		if score < mcpContactMatchThreshold {
+			continue
+		}
+		// Among close matches, prefer the people you talk to
+		score += 0.05 * float64(min(candidate.RecentMessages, 20)) / 20
+		candidate.Score = float64(int(min(score, 1)*100+0.5)) / 100
+		candidate.MatchedOn = matchedOn
+		if candidate.Name == "" {
+			candidate.Name = matchedOn
+		}
+		matches = append(matches, *candidate)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].RecentMessages > matches[j].RecentMessages
+	})
+	ambiguous := len(matches) > 1 && matches[0].Score-matches[1].Score < 0.1
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return map[string]interface{}{
+		"name":       name,
+		"context":    context,
+		"candidates": matches,
+		"ambiguous":  ambiguous,
+	}, nil
+}
+
+// tagContact adds or removes a tag on a contact
+func (call *mcpCall) tagContact(jid, tag string, remove bool) (interface{}, error) {
+	jid, tag = normalizeContactJID(jid), strings.ToLower(tag)
+	if jid == "" || tag == "" {
+		return nil, fmt.Errorf("jid and tag are required")
+	}
+	db := call.server.store.db
+	if remove {
+		if err := untagContact(db, jid, tag); err != nil {
+			return nil, fmt.Errorf("failed to remove tag: %v", err)
+		}
+		return map[string]interface{}{"success": true, "message": fmt.Sprintf("Removed tag %s from %s", tag, jid)}, nil
+	}
+	if err := tagContact(db, jid, tag); err != nil {
+		return nil, fmt.Errorf("failed to tag contact: %v", err)
+	}
+	return map[string]interface{}{"success": true, "message": fmt.Sprintf("Tagged %s with %s", jid, tag)}, nil
+}
+
+// createSegment saves a segment and counts its members
+func (call *mcpCall) createSegment(segment ContactSegment) (interface{}, error) {
+	if segment.Name == "" {
+		return nil, fmt.Errorf("segment name is required")
+	}
+	db := call.server.store.db
+	if err := saveSegment(db, segment); err != nil {
+		return nil, fmt.Errorf("failed to save segment: %v", err)
+	}
+	members, err := resolveSegment(db, segment.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve segment: %v", err)
+	}
+	return map[string]interface{}{
+		"success":      true,
+		"message":      fmt.Sprintf("Segment %s saved", segment.Name),
+		"member_count": len(members),
+	}, nil
+}
+
+// listSegments returns the segment definitions
+func (call *mcpCall) listSegments() ([]map[string]interface{}, error) {
+	segments, err := listSegments(call.server.store.db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list segments: %v", err)
+	}
+	list := []map[string]interface{}{}
+	for _, segment := range segments {
+		list = append(list, map[string]interface{}{
+			"name":               segment.Name,
+			"description":        segment.Description,
+			"tag":                segment.Tag,
+			"member_of":          segment.MemberOf,
+			"active_within_days": segment.ActiveWithinDays,
+			"inactive_for_days":  segment.InactiveForDays,
+		})
+	}
+	return list, nil
+}
+
+// addAnnotation attaches a note to a chat, one of its messages or its summary of a day
+func (call *mcpCall) addAnnotation(chatJID, note, messageID, summaryDate string) (interface{}, error) {
+	if chatJID == "" || note == "" {
+		return nil, fmt.Errorf("chat_jid and note are required")
+	}
+	if err := call.checkChat(chatJID); err != nil {
+		return nil, err
+	}
+	if messageID != "" {
+		var count int
+		call.server.store.dbFor(chatJID).QueryRow("SELECT COUNT(*) FROM messages WHERE id = ? AND chat_jid = ?", messageID, chatJID).Scan(&count)
+		if count == 0 {
+			return nil, fmt.Errorf("message %s not found in chat %s", messageID, chatJID)
+		}
+	}
+	result, err := call.server.store.db.Exec("INSERT INTO annotations (chat_jid, message_id, summary_date, note) VALUES (?, NULLIF(?, ''), NULLIF(?, ''), ?)",
+		chatJID, messageID, summaryDate, note)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save annotation: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	return map[string]interface{}{"success": true, "message": fmt.Sprintf("Annotation %d saved", id), "id": id}, nil
+}
+
+// listAnnotations returns the annotations, newest first, of one chat or of every chat in the client's scope
+func (call *mcpCall) listAnnotations(chatJID string, limit int) ([]map[string]interface{}, error) {
+	condition := "1"
+	var args []interface{}
+	if chatJID != "" {
+		condition = "chat_jid = ?"
+		args = append(args, chatJID)
+	}
+	scope, scopeArgs := call.chatScopeClause("chat_jid")
+	args = append(append(args, scopeArgs...), limit)
+	rows, err := call.server.store.db.Query(`
+		SELECT id, chat_jid, COALESCE(message_id, ''), COALESCE(summary_date, ''), note, created_at
+		FROM annotations WHERE `+condition+scope+` ORDER BY id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list annotations: %v", err)
+	}
+	var annotations []Annotation
+	for rows.Next() {
+		var annotation Annotation
+		var createdAt sql.NullString
+		if err := rows.Scan(&annotation.ID, &annotation.ChatJID, &annotation.MessageID, &annotation.SummaryDate, &annotation.Note, &createdAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		annotation.CreatedAt = createdAt.String
+		annotations = append(annotations, annotation)
+	}
+	rows.Close()
+
+	list := []map[string]interface{}{}
+	for _, annotation := range annotations {
+		// The messages of direct chats may be in the other store
+		var content sql.NullString
+		if annotation.MessageID != "" {
+			call.server.store.dbFor(annotation.ChatJID).QueryRow("SELECT content FROM messages WHERE id = ? AND chat_jid = ?",
+				annotation.MessageID, annotation.ChatJID).Scan(&content)
+		}
+		entry := map[string]interface{}{
+			"id":              annotation.ID,
+			"chat_jid":        annotation.ChatJID,
+			"message_id":      nilIfEmpty(annotation.MessageID),
+			"summary_date":    nilIfEmpty(annotation.SummaryDate),
+			"note":            annotation.Note,
+			"created_at":      annotation.CreatedAt,
+			"message_content": nil,
+		}
+		if content.Valid {
+			entry["message_content"] = call.server.store.open(content.String)
+		}
+		list = append(list, entry)
+	}
+	return list, nil
+}
+
+// nilIfEmpty returns nil for "", so it encodes as null
+func nilIfEmpty(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// deleteAnnotation deletes an annotation of a chat in the client's scope
+func (call *mcpCall) deleteAnnotation(id int64) (interface{}, error) {
+	var chatJID string
+	err := call.server.store.db.QueryRow("SELECT chat_jid FROM annotations WHERE id = ?", id).Scan(&chatJID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("annotation %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := call.checkChat(chatJID); err != nil {
+		return nil, err
+	}
+	if _, err := call.server.store.db.Exec("DELETE FROM annotations WHERE id = ?", id); err != nil {
+		return nil, fmt.Errorf("failed to delete annotation: %v", err)
+	}
+	return map[string]interface{}{"success": true, "message": fmt.Sprintf("Annotation %d deleted", id)}, nil
+}
+
+// listEpisodes returns the knowledge graph episodes recorded for a group between two dates
+func (call *mcpCall) listEpisodes(groupJID, startDate, endDate string) ([]map[string]interface{}, error) {
+	if err := call.checkChat(groupJID); err != nil {
+		return nil, err
+	}
+	if startDate == "" {
+		startDate = "0000-00-00"
+	}
+	if endDate == "" {
+		endDate = "9999-99-99"
+	}
+	episodes, err := listEpisodes(call.server.store.db, groupJID, startDate, endDate)
+	if err != nil && !strings.Contains(err.Error(), "no such table") {
+		return nil, fmt.Errorf("failed to list episodes: %v", err)
+	}
+	list := []map[string]interface{}{}
+	for _, episode := range episodes {
+		list = append(list, map[string]interface{}{
+			"uuid":              episode.UUID,
+			"graphiti_group_id": episode.GraphitiGroupID,
+			"date":              episode.Date,
+			"topic":             episode.Topic,
+			"name":              episode.Name,
+			"sink":              episode.Sink,
+			"first_message_id":  episode.FirstMessageID,
+			"last_message_id":   episode.LastMessageID,
+		})
+	}
+	return list, nil
+}
+
+// getEpisodeMessages returns the messages a knowledge graph episode was built from, as text
+func (call *mcpCall) getEpisodeMessages(uuid string) (string, error) {
+	var groupJID string
+	err := call.server.store.db.QueryRow("SELECT group_jid FROM graphiti_episodes WHERE uuid = ?", uuid).Scan(&groupJID)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("episode %s not found", uuid)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read episode: %v", err)
+	}
+	if err := call.checkChat(groupJID); err != nil {
+		return "", err
+	}
+	messages, err := call.server.store.queryMCPMessages(call.server.store.dbFor(groupJID), `
+		JOIN graphiti_episode_messages em ON em.message_id = m.id AND em.chat_jid = m.chat_jid
+		WHERE em.episode_uuid = ?
+		ORDER BY m.timestamp
+	`, uuid)
+	if err != nil {
+		return "", fmt.Errorf("failed to read episode messages: %v", err)
+	}
+	return call.formatMessages(messages), nil
+}
+
+// mcpSemanticResult is a conversation excerpt found by semantic_search
+type mcpSemanticResult struct {
+	EpisodeUUID string   `json:"episode_uuid"`
+	ChatJID     string   `json:"chat_jid"`
+	ChatName    string   `json:"chat_name,omitempty"`
+	Date        string   `json:"date"`
+	Topic       string   `json:"topic"`
+	Score       float64  `json:"score"` // cosine similarity to the query, higher is closer
+	Snippet     string   `json:"snippet"`
+	MessageIDs  []string `json:"message_ids"` // the messages the excerpt was built from, in order
+}
+
+// semanticSearch ranks the conversation episodes of the vector store (KNOWLEDGE_SINK=sqlite-vector) by their
+// similarity in meaning to a query, optionally in one chat
+func (call *mcpCall) semanticSearch(query, chatJID string, limit int) ([]mcpSemanticResult, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+	if limit < 1 || limit > mcpMaxSemanticResults {
+		return nil, fmt.Errorf("limit must be between 1 and %d", mcpMaxSemanticResults)
+	}
+	if _, err := os.Stat(getVectorDBPath()); err != nil {
+		return nil, fmt.Errorf("no embeddings index at %s: semantic search needs the daily summary to run with KNOWLEDGE_SINK=sqlite-vector", getVectorDBPath())
+	}
+	condition, args := "1", []interface{}{}
+	if chatJID != "" {
+		if err := call.checkChat(chatJID); err != nil {
+			return nil, err
+		}
+		condition, args = "group_jid = ?", []interface{}{chatJID}
+	}
+	scope, scopeArgs := call.chatScopeClause("group_jid")
+	args = append(args, scopeArgs...)
+
+	index, err := sql.Open("sqlite3", "file:"+getVectorDBPath()+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the embeddings index: %v", err)
+	}
+	defer index.Close()
+	found, err := searchVectorIndex(call.ctx, index, query, condition+scope, args, limit)
+	if err != nil {
+		return nil, fmt.Errorf("semantic search failed: %v", err)
+	}
+
+	results := []mcpSemanticResult{}
+	for _, episode := range found {
+		result := mcpSemanticResult{
+			EpisodeUUID: episode.UUID,
+			ChatJID:     episode.GroupJID,
+			Date:        episode.Date,
+			Topic:       episode.Topic,
+			Score:       episode.Score,
+			Snippet:     episode.Body,
+			MessageIDs:  []string{},
+		}
+		if len([]rune(result.Snippet)) > mcpSnippetLength {
+			result.Snippet = string([]rune(result.Snippet)[:mcpSnippetLength]) + "..."
+		}
+		call.server.store.db.QueryRow("SELECT COALESCE(name, '') FROM chats WHERE jid = ?", episode.GroupJID).Scan(&result.ChatName)
+		rows, err := call.server.store.db.Query(
+			"SELECT message_id FROM graphiti_episode_messages WHERE episode_uuid = ? ORDER BY position", episode.UUID)
+		if err == nil {
+			for rows.Next() {
+				var id string
+				if rows.Scan(&id) == nil {
+					result.MessageIDs = append(result.MessageIDs, id)
+				}
+			}
+			rows.Close()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Period summarize_chat covers when none is given
+const mcpDefaultSummaryPeriod = 24 * time.Hour
+
+// summarizeChat summarizes a chat between two times (by default the last 24 hours) with the daily summary's
+// prompt and LLM, without saving or sending the summary. Like every LLM job, it is refused in safe mode.
+func (call *mcpCall) summarizeChat(chatJID, after, before string) (*ChatSummary, error) {
+	if err := call.checkChat(chatJID); err != nil {
+		return nil, err
+	}
+	end := time.Now()
+	if before != "" {
+		t, err := parseMCPTime(before)
+		if err != nil {
+			return nil, err
+		}
+		end = t
+	}
+	start := end.Add(-mcpDefaultSummaryPeriod)
+	if after != "" {
+		t, err := parseMCPTime(after)
+		if err != nil {
+			return nil, err
+		}
+		start = t
+	}
+	if !start.Before(end) {
+		return nil, fmt.Errorf("after must be before before")
+	}
+	if isSafeModeActive() {
+		return nil, fmt.Errorf("safe mode is on, LLM jobs are disabled")
+	}
+	return summarizeChat(call.ctx, chatJID, start, end, newLogger("Summary", "INFO"))
+}
+
+// mcpGroupParticipants is a group's member list as get_group_participants returns it
+type mcpGroupParticipants struct {
+	GroupJID         string                 `json:"group_jid"`
+	Name             string                 `json:"name"`
+	ParticipantCount int                    `json:"participant_count"`
+	Admins           []string               `json:"admins"`       // names (or phone numbers) of the admins
+	Participants     []GroupParticipantInfo `json:"participants"` // admins first, then by name
+}
+
+// getGroupParticipants returns the members of a group given by JID or name, with their names, phone numbers
+// and admin flags
+func (call *mcpCall) getGroupParticipants(group string) (interface{}, error) {
+	groupJID, err := resolveGroupReference(group)
+	if err != nil {
+		return nil, err
+	}
+	if err := call.checkChat(groupJID); err != nil {
+		return nil, err
+	}
+	result, err := call.get("/api/groups/info", map[string]string{"group_jid": groupJID})
+	if err != nil {
+		return nil, err
+	}
+	var details GroupDetails
+	data, _ := json.Marshal(result)
+	if err := json.Unmarshal(data, &details); err != nil {
+		return nil, fmt.Errorf("error parsing group info: %v", err)
+	}
+
+	// Members missing from the contact store may still be named in the stored chats
+	label := func(participant GroupParticipantInfo) string {
+		if participant.Name != "" {
+			return participant.Name
+		}
+		if participant.PhoneNumber != "" {
+			return participant.PhoneNumber
+		}
+		return strings.Split(participant.JID, "@")[0]
+	}
+	for i, participant := range details.Participants {
+		if participant.Name == "" && participant.PhoneNumber != "" {
+			details.Participants[i].Name = lookupSenderName(call.server.store.db, participant.PhoneNumber)
+		}
+	}
+	sort.SliceStable(details.Participants, func(i, j int) bool {
+		a, b := details.Participants[i], details.Participants[j]
+		if a.IsAdmin != b.IsAdmin {
+			return a.IsAdmin
+		}
+		return strings.ToLower(label(a)) < strings.ToLower(label(b))
+	})
+
+	members := mcpGroupParticipants{
+		GroupJID:         details.JID,
+		Name:             details.Name,
+		ParticipantCount: len(details.Participants),
+		Admins:           []string{},
+		Participants:     details.Participants,
+	}
+	for _, participant := range details.Participants {
+		if participant.IsAdmin {
+			members.Admins = append(members.Admins, label(participant))
+		}
+	}
+	// Keep list_chats' member count current
+	setParticipantCount(call.server.store.db, details.JID, len(details.Participants), time.Now())
+	return members, nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// MCP protocol versions the server speaks, newest first. A client asking for another gets the newest.
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// mcpServerVersion is reported to clients in serverInfo
+const mcpServerVersion = "0.2.0"
+
+// JSON-RPC error codes
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+)
+
+// MCPServer serves the WhatsApp tools (see mcp-tools.go) to MCP clients such as Claude Desktop, over stdio
+// or streamable HTTP on /mcp
+type MCPServer struct {
+	store *MessageStore
+	tools []mcpTool
+}
+
+// jsonRPCMessage is a JSON-RPC 2.0 request, notification (no id) or response
+type jsonRPCMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+}
+
+type jsonRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// isNotification reports whether a message expects no response
+func (message jsonRPCMessage) isNotification() bool {
+	return len(message.ID) == 0 || string(message.ID) == "null"
+}
+
+// NewMCPServer creates the MCP server over the message store
+func NewMCPServer(store *MessageStore) (*MCPServer, error) {
+	// Tools write annotations, tags and segments, whose tables are otherwise created on first use
+	if err := ensureAnnotationTables(store.db); err != nil {
+		return nil, fmt.Errorf("failed to create annotation tables: %v", err)
+	}
+	if err := ensureSegmentTables(store.db); err != nil {
+		return nil, fmt.Errorf("failed to create segment tables: %v", err)
+	}
+	server := &MCPServer{store: store}
+	server.tools = server.registerTools()
+	return server, nil
+}
+
+// handle answers one JSON-RPC message, or a batch of them; it returns nil when there is nothing to answer.
+// token is the API token of the client, nil for a trusted one.
+func (server *MCPServer) handle(ctx context.Context, token *APIToken, data []byte) []byte {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(data, &batch); err != nil || len(batch) == 0 {
+			return mustMarshal(jsonRPCErrorResponse(nil, jsonRPCParseError, "Invalid JSON-RPC batch"))
+		}
+		var responses []jsonRPCMessage
+		for _, raw := range batch {
+			if response := server.handleMessage(ctx, token, raw); response != nil {
+				responses = append(responses, *response)
+			}
+		}
+		if len(responses) == 0 {
+			return nil
+		}
+		return mustMarshal(responses)
+	}
+	if response := server.handleMessage(ctx, token, data); response != nil {
+		return mustMarshal(response)
+	}
+	return nil
+}
+
+// handleMessage answers a single JSON-RPC message; notifications and responses get nil
+func (server *MCPServer) handleMessage(ctx context.Context, token *APIToken, data []byte) *jsonRPCMessage {
+	var request jsonRPCMessage
+	if err := json.Unmarshal(data, &request); err != nil {
+		return jsonRPCErrorResponse(nil, jsonRPCParseError, fmt.Sprintf("Parse error: %v", err))
+	}
+	if request.JSONRPC != "2.0" || (request.Method == "" && request.isNotification()) {
+		return jsonRPCErrorResponse(request.ID, jsonRPCInvalidRequest, "Invalid JSON-RPC request")
+	}
+	if request.Method == "" {
+		// A response to a request of ours; the server sends none
+		return nil
+	}
+
+	result, rpcErr := server.dispatch(ctx, token, request.Method, request.Params)
+	if request.isNotification() {
+		return nil
+	}
+	if rpcErr != nil {
+		return jsonRPCErrorResponse(request.ID, rpcErr.Code, rpcErr.Message)
+	}
+	return &jsonRPCMessage{JSONRPC: "2.0", ID: request.ID, Result: result}
+}
+
+// dispatch runs an MCP method
+func (server *MCPServer) dispatch(ctx context.Context, token *APIToken, method string, params json.RawMessage) (interface{}, *jsonRPCError) {
+	switch method {
+	case "initialize":
+		var init struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(params, &init)
+		version := mcpProtocolVersions[0]
+		if slices.Contains(mcpProtocolVersions, init.ProtocolVersion) {
+			version = init.ProtocolVersion
+		}
+		return map[string]interface{}{
+			"protocolVersion": version,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{"listChanged": false}},
+			"serverInfo":      map[string]interface{}{"name": "whatsapp", "version": mcpServerVersion},
+		}, nil
+
+	case "notifications/initialized", "notifications/cancelled":
+		return nil, nil
+
+	case "ping":
+		return map[string]interface{}{}, nil
+
+	case "tools/list":
+		tools := []map[string]interface{}{}
+		for _, tool := range server.tools {
+			if (token == nil || token.HasCapability(tool.Capability)) && checkPermission(tool.Name, token) == nil {
+				tools = append(tools, tool.definition())
+			}
+		}
+		return map[string]interface{}{"tools": tools}, nil
+
+	case "tools/call":
+		var call struct {
+			Name      string                     `json:"name"`
+			Arguments map[string]json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(params, &call); err != nil {
+			return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: fmt.Sprintf("Invalid params: %v", err)}
+		}
+		tool := server.tool(call.Name)
+		if tool == nil {
+			return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: fmt.Sprintf("Unknown tool: %s", call.Name)}
+		}
+		args := mcpArgs(call.Arguments)
+		if err := args.check(tool.Params); err != nil {
+			return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: err.Error()}
+		}
+		if token != nil && !token.HasCapability(tool.Capability) {
+			return mcpToolResult(nil, fmt.Errorf("token %q lacks the %s capability", token.Name, tool.Capability)), nil
+		}
+		if err := checkPermission(tool.Name, token); err != nil {
+			return mcpToolResult(nil, err), nil
+		}
+		result, err := tool.Call(&mcpCall{server: server, ctx: ctx, token: token, tool: call.Name}, args)
+		return mcpToolResult(result, err), nil
+
+	default:
+		return nil, &jsonRPCError{Code: jsonRPCMethodNotFound, Message: fmt.Sprintf("Method not found: %s", method)}
+	}
+}
+
+// tool returns the tool with a name, or nil
+func (server *MCPServer) tool(name string) *mcpTool {
+	for i := range server.tools {
+		if server.tools[i].Name == name {
+			return &server.tools[i]
+		}
+	}
+	return nil
+}
+
+// mcpToolResult turns what a tool returned into a tools/call result: text as is, anything else as JSON.
+// Tool errors are results too, flagged with isError, so the model sees them.
+func mcpToolResult(result interface{}, err error) map[string]interface{} {
+	var text string
+	if err != nil {
+		text = err.Error()
+	} else if s, ok := result.(string); ok {
+		text = s
+	} else {
+		data, marshalErr := json.MarshalIndent(result, "", "  ")
+		if marshalErr != nil {
+			text, err = fmt.Sprintf("Failed to encode the result: %v", marshalErr), marshalErr
+		} else {
+			text = string(data)
+		}
+	}
+	return map[string]interface{}{
+		"content": []map[string]interface{}{{"type": "text", "text": text}},
+		"isError": err != nil,
+	}
+}
+
+func jsonRPCErrorResponse(id json.RawMessage, code int, message string) *jsonRPCMessage {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return &jsonRPCMessage{JSONRPC: "2.0", ID: id, Error: &jsonRPCError{Code: code, Message: message}}
+}
+
+func mustMarshal(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(jsonRPCErrorResponse(nil, jsonRPCInvalidRequest, err.Error()))
+	}
+	return data
+}
+
+// ServeStdio serves MCP over newline-delimited JSON-RPC on in and out, as a client that started the bridge
+// as its subprocess expects. The client is trusted: its calls don't need an API token. Returns when in ends.
+func (server *MCPServer) ServeStdio(in io.Reader, out io.Writer) error {
+	var writeMutex sync.Mutex
+	var calls sync.WaitGroup
+	reader := bufio.NewReaderSize(in, 1<<20)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			// Tool calls can take a while (e.g. sending media), so they don't hold up pings
+			calls.Add(1)
+			go func(line []byte) {
+				defer calls.Done()
+				response := server.handle(context.Background(), nil, line)
+				if response == nil {
+					return
+				}
+				writeMutex.Lock()
+				defer writeMutex.Unlock()
+				out.Write(append(response, '\n'))
+			}(line)
+		}
+		if err == io.EOF {
+			calls.Wait()
+			return nil
+		}
+		if err != nil {
+			calls.Wait()
+			return err
+		}
+	}
+}
+
+// handleMCPHTTP serves MCP's streamable HTTP transport on /mcp: each POST carries a JSON-RPC message (or batch)
+// answered with JSON. The server keeps no sessions and sends no requests of its own, so GET and DELETE aren't
+// supported. Calls run with the request's API token.
+func (server *MCPServer) handleMCPHTTP(w http.ResponseWriter, r *http.Request) {
+	if !mcpOriginAllowed(r.Header.Get("Origin")) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4<<20))
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+	response := server.handle(r.Context(), apiTokenFromRequest(r), body)
+	if response == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// mcpOriginAllowed guards /mcp against DNS rebinding: browsers may only call it from localhost or the origins
+// in MCP_ALLOWED_ORIGINS (comma-separated). Requests without an Origin don't come from a browser.
+func mcpOriginAllowed(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range strings.Split(os.Getenv("MCP_ALLOWED_ORIGINS"), ",") {
+		if strings.TrimSpace(allowed) == origin {
+			return true
+		}
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := parsed.Hostname()
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
@@ -0,0 +1,1030 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long ffmpeg may take to convert audio into a voice message
+const audioConvertTimeout = 2 * time.Minute
+
+// The recipient argument, as every sending tool describes it
+const mcpRecipientDescription = `The recipient - either a phone number with country code but no + or other symbols, or a JID (e.g., "123456789@s.whatsapp.net" or a group JID like "123456789@g.us")`
+
+// mcpTool is a tool offered to MCP clients. Clients with an API token only see and call the tools their
+// token has the capability for.
+type mcpTool struct {
+	Name        string
+	Description string
+	Capability  string
+	Params      []mcpParam
+	Call        func(call *mcpCall, args mcpArgs) (interface{}, error)
+}
+
+// mcpParam is an argument of a tool
+type mcpParam struct {
+	Name        string
+	Type        string // "string", "integer", "boolean" or "array" (of strings)
+	Description string
+	Required    bool
+}
+
+// definition returns the tool as tools/list describes it, with the JSON Schema of its arguments
+func (tool mcpTool) definition() map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+	for _, param := range tool.Params {
+		property := map[string]interface{}{"type": param.Type, "description": param.Description}
+		if param.Type == "array" {
+			property["items"] = map[string]interface{}{"type": "string"}
+		}
+		properties[param.Name] = property
+		if param.Required {
+			required = append(required, param.Name)
+		}
+	}
+	return map[string]interface{}{
+		"name":        tool.Name,
+		"description": tool.Description,
+		"inputSchema": map[string]interface{}{"type": "object", "properties": properties, "required": required},
+	}
+}
+
+// mcpArgs are the arguments of a tool call
+type mcpArgs map[string]json.RawMessage
+
+// check validates the arguments against the tool's parameters: required ones must be given, and all of the
+// declared type
+func (args mcpArgs) check(params []mcpParam) error {
+	for _, param := range params {
+		raw, ok := args[param.Name]
+		if !ok || string(raw) == "null" {
+			if param.Required {
+				return fmt.Errorf("missing required argument %q", param.Name)
+			}
+			continue
+		}
+		var err error
+		switch param.Type {
+		case "string":
+			var value string
+			err = json.Unmarshal(raw, &value)
+		case "integer":
+			var value int
+			err = json.Unmarshal(raw, &value)
+		case "boolean":
+			var value bool
+			err = json.Unmarshal(raw, &value)
+		case "array":
+			var value []string
+			err = json.Unmarshal(raw, &value)
+		}
+		if err != nil {
+			return fmt.Errorf("argument %q must be of type %s", param.Name, param.Type)
+		}
+	}
+	return nil
+}
+
+// String returns a string argument, "" when not given
+func (args mcpArgs) String(name string) string {
+	var value string
+	json.Unmarshal(args[name], &value)
+	return strings.TrimSpace(value)
+}
+
+// Int returns an integer argument, or fallback when not given
+func (args mcpArgs) Int(name string, fallback int) int {
+	value := fallback
+	if raw, ok := args[name]; ok && string(raw) != "null" {
+		json.Unmarshal(raw, &value)
+	}
+	return value
+}
+
+// Bool returns a boolean argument, or fallback when not given
+func (args mcpArgs) Bool(name string, fallback bool) bool {
+	value := fallback
+	if raw, ok := args[name]; ok && string(raw) != "null" {
+		json.Unmarshal(raw, &value)
+	}
+	return value
+}
+
+// Strings returns a string array argument
+func (args mcpArgs) Strings(name string) []string {
+	var value []string
+	json.Unmarshal(args[name], &value)
+	return value
+}
+
+// mcpCall is a tool call being run, with the API token of the client that made it (nil for a trusted one)
+type mcpCall struct {
+	server *MCPServer
+	ctx    context.Context
+	token  *APIToken
+	tool   string // name of the tool being called
+}
+
+// allowsChat reports whether the client may access a chat
+func (call *mcpCall) allowsChat(chatJID string) bool {
+	return call.token == nil || call.token.AllowsChat(chatJID)
+}
+
+// checkChat returns an error when the client may not access a chat
+func (call *mcpCall) checkChat(chatJID string) error {
+	if !call.allowsChat(chatJID) {
+		return fmt.Errorf("token %q has no access to %s", call.token.Name, chatJID)
+	}
+	return nil
+}
+
+// chatScope returns the chats the client's token is limited to, or nil when it may access every chat
+func (call *mcpCall) chatScope() []string {
+	if call.token == nil || call.token.AllowsChat("*") {
+		return nil
+	}
+	return call.token.Chats
+}
+
+// api makes a request to the bridge's REST API in-process, as the client that called the tool, and returns its
+// decoded JSON response. Error responses become errors with the message the API gave.
+func (call *mcpCall) api(method, path string, query url.Values, payload interface{}) (interface{}, error) {
+	var body bytes.Buffer
+	if payload != nil {
+		if err := json.NewEncoder(&body).Encode(payload); err != nil {
+			return nil, err
+		}
+	}
+	target := path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	ctx := context.WithValue(call.ctx, internalAPIRequestKey{}, call.token)
+	request, err := http.NewRequestWithContext(ctx, method, target, &body)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	request.Header.Set(bridgeClientHeader, "mcp:"+call.tool)
+	recorder := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(recorder, request)
+
+	var result interface{}
+	jsonErr := json.Unmarshal(recorder.Body.Bytes(), &result)
+	if recorder.Code >= 300 {
+		if response, ok := result.(map[string]interface{}); ok && jsonErr == nil {
+			if message, ok := response["message"].(string); ok && message != "" {
+				return nil, fmt.Errorf("%s", message)
+			}
+		}
+		return nil, fmt.Errorf("HTTP %d - %s", recorder.Code, strings.TrimSpace(recorder.Body.String()))
+	}
+	if jsonErr != nil {
+		return nil, fmt.Errorf("error parsing response: %s", recorder.Body.String())
+	}
+	return result, nil
+}
+
+// get calls a GET endpoint of the bridge's API with the given non-empty query parameters
+func (call *mcpCall) get(path string, params map[string]string) (interface{}, error) {
+	query := url.Values{}
+	for name, value := range params {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	return call.api(http.MethodGet, path, query, nil)
+}
+
+// post calls a POST endpoint of the bridge's API with a JSON payload
+func (call *mcpCall) post(path string, payload interface{}) (interface{}, error) {
+	return call.api(http.MethodPost, path, nil, payload)
+}
+
+// registerTools returns the tools of the server
+func (server *MCPServer) registerTools() []mcpTool {
+	return []mcpTool{
+		// Reading the message history
+		{
+			Name:        "search_contacts",
+			Description: "Search WhatsApp contacts by name or phone number.",
+			Capability:  apiCapabilityRead,
+			Params: []mcpParam{
+				{"query", "string", "Search term to match against contact names or phone numbers", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.searchContacts(args.String("query"))
+			},
+		},
+		{
+			Name: "resolve_contact",
+			Description: "Find the contacts a partial name refers to, such as \"joão\" or \"joão from acme\", before sending " +
+				"to them. Names match fuzzily, ignoring case and accents; a \"from ...\" part is matched against the " +
+				"contact's business name and the groups they wrote in, and people you talk to often rank first. Returns " +
+				"scored candidates and whether the best match is ambiguous, in which case ask the user which one they mean.",
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"name", "string", "Partial name, optionally with \"from <company or group>\", or part of a phone number", true},
+				{"limit", "integer", "Maximum number of candidates to return (default 5, at most 20)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.resolveContact(args.String("name"), args.Int("limit", 5))
+			},
+		},
+		{
+			Name:        "list_messages",
+			Description: "Get WhatsApp messages matching specified criteria with optional context.",
+			Capability:  apiCapabilityRead,
+			Params: []mcpParam{
+				{"after", "string", "Optional ISO-8601 formatted string to only return messages after this date", false},
+				{"before", "string", "Optional ISO-8601 formatted string to only return messages before this date", false},
+				{"sender_phone_number", "string", "Optional phone number to filter messages by sender", false},
+				{"chat_jid", "string", "Optional chat JID to filter messages by chat", false},
+				{"query", "string", "Optional search term to filter messages by content", false},
+				{"limit", "integer", "Maximum number of messages to return (default 20)", false},
+				{"page", "integer", "Page number for pagination (default 0)", false},
+				{"include_context", "boolean", "Whether to include messages before and after matches (default true)", false},
+				{"context_before", "integer", "Number of messages to include before each match (default 1)", false},
+				{"context_after", "integer", "Number of messages to include after each match (default 1)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.listMessages(args)
+			},
+		},
+		{
+			Name: "search_messages",
+			Description: "Search the whole message history for words, much faster than list_messages with a query. " +
+				"Every word must appear in the message (as a word or the start of one); accents and case are ignored. " +
+				"Filter by chat, sender, date range and media type; with a media type the words can be left out, e.g. to find every photo someone sent last week. " +
+				"Returns the matching messages, newest first, each with the sender's contact name, its media type and a snippet of the match.",
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"query", "string", "The words to search for; optional when media_type is given", false},
+				{"chat_jid", "string", "Optional chat JID to search in", false},
+				{"sender", "string", "Optional phone number of the sender", false},
+				{"media_type", "string", `Optional media type: "image", "video", "audio", "document", "sticker", or "any" for every message with media`, false},
+				{"after", "string", "Optional date (YYYY-MM-DD) or ISO-8601 time to only return messages from then on", false},
+				{"before", "string", "Optional date (YYYY-MM-DD) or ISO-8601 time to only return messages before it", false},
+				{"limit", "integer", "Maximum number of messages to return (default 20, at most 100)", false},
+				{"offset", "integer", "Number of results to skip, for the next page (see next_offset in the response)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.get("/api/search", map[string]string{
+					"q": args.String("query"), "chat_jid": args.String("chat_jid"), "sender": args.String("sender"),
+					"media_type": args.String("media_type"), "after": args.String("after"), "before": args.String("before"),
+					"limit": strconv.Itoa(args.Int("limit", searchDefaultLimit)), "offset": strconv.Itoa(args.Int("offset", 0)),
+				})
+			},
+		},
+		{
+			Name: "semantic_search",
+			Description: "Search conversations by meaning rather than words, e.g. \"when did we discuss raising prices?\" when the messages may not use those words. " +
+				"Use search_messages instead for exact words, names or numbers. " +
+				"Searches the topic excerpts of group conversations that the daily summary embedded (needs KNOWLEDGE_SINK=sqlite-vector). " +
+				"Returns the closest excerpts first, each with its chat, date, topic, a snippet and the IDs of its messages (see get_message_context).",
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"query", "string", "What to look for, in natural language", true},
+				{"chat_jid", "string", "Optional group JID to search in", false},
+				{"limit", "integer", "Maximum number of excerpts to return (default 10, at most 50)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.semanticSearch(args.String("query"), args.String("chat_jid"), args.Int("limit", 10))
+			},
+		},
+		{
+			Name: "summarize_chat",
+			Description: "Summarize a chat over a period on demand, like the daily summary does for the day: main discussions and decisions, " +
+				"pending actions and follow-ups, using the chat's own summary prompt and language when set. " +
+				"Defaults to the last 24 hours. Calls the configured LLM, so it can take a minute. " +
+				"Returns the summary with the number of messages it covers; periods with more than 1000 messages only summarize the latest ones.",
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"chat_jid", "string", "The JID of the chat to summarize", true},
+				{"after", "string", "Optional ISO-8601 time or date (YYYY-MM-DD) the period starts at (default 24 hours before before)", false},
+				{"before", "string", "Optional ISO-8601 time or date (YYYY-MM-DD) the period ends at (default now)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.summarizeChat(args.String("chat_jid"), args.String("after"), args.String("before"))
+			},
+		},
+		{
+			Name: "get_message_stats",
+			Description: "Get message activity statistics, e.g. who was most active in a group this month, without reading the messages. " +
+				"Returns rows of message, media and response counts and the average reply time in seconds, plus totals.",
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"by", "string", `Group the counts by "sender" (most active first), "chat" (most active first) or "day" (in order); default "sender"`, false},
+				{"chat_jid", "string", "Optional chat JID to count in", false},
+				{"sender", "string", "Optional phone number of the sender to count", false},
+				{"after", "string", "Optional first day to count (YYYY-MM-DD)", false},
+				{"before", "string", "Optional day after the last to count (YYYY-MM-DD)", false},
+				{"limit", "integer", "Maximum number of rows to return (default 20)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				by := args.String("by")
+				if by == "" {
+					by = "sender"
+				}
+				return call.get("/api/stats", map[string]string{
+					"by": by, "chat_jid": args.String("chat_jid"), "sender": args.String("sender"),
+					"after": args.String("after"), "before": args.String("before"), "limit": strconv.Itoa(args.Int("limit", 20)),
+				})
+			},
+		},
+		{
+			Name: "list_chats",
+			Description: "Get WhatsApp chats matching specified criteria, like the chat list on the phone. " +
+				`Each chat has its type ("direct", "group", "broadcast" or "channel"), the member count of groups, ` +
+				"whether it has unread messages (and how many), and a preview of the last message.",
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"query", "string", "Optional search term to filter chats by name or JID", false},
+				{"limit", "integer", "Maximum number of chats to return (default 20)", false},
+				{"page", "integer", "Page number for pagination (default 0)", false},
+				{"include_last_message", "boolean", "Whether to include the last message in each chat (default true)", false},
+				{"sort_by", "string", `Field to sort results by, either "last_active" or "name" (default "last_active")`, false},
+				{"unread_only", "boolean", "Only return chats with unread messages or marked as unread (default false)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.listChats(args.String("query"), args.Int("limit", 20), args.Int("page", 0),
+					args.Bool("include_last_message", true), args.String("sort_by"), args.Bool("unread_only", false))
+			},
+		},
+		{
+			Name: "get_unread_messages",
+			Description: "Get every unread message, grouped by chat with the most recently active chat first, e.g. to triage WhatsApp. " +
+				"Each chat has its unread count and its latest unread messages, oldest first, with the senders' names; " +
+				"chats marked as unread without unread messages come with none. Muted chats are left out unless include_muted. " +
+				"Use mark_as_read once they're handled.",
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"limit_per_chat", "integer", "Maximum number of messages to return per chat, the latest ones (default 20, at most 100)", false},
+				{"include_muted", "boolean", "Whether to include muted chats (default false)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.getUnreadMessages(args.Int("limit_per_chat", 20), args.Bool("include_muted", false))
+			},
+		},
+		{
+			Name:        "get_chat",
+			Description: "Get WhatsApp chat metadata by JID.",
+			Capability:  apiCapabilityRead,
+			Params: []mcpParam{
+				{"chat_jid", "string", "The JID of the chat to retrieve", true},
+				{"include_last_message", "boolean", "Whether to include the last message (default true)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.getChat(args.String("chat_jid"), args.Bool("include_last_message", true))
+			},
+		},
+		{
+			Name:        "get_direct_chat_by_contact",
+			Description: "Get WhatsApp chat metadata by sender phone number.",
+			Capability:  apiCapabilityRead,
+			Params: []mcpParam{
+				{"sender_phone_number", "string", "The phone number to search for", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.getDirectChatByContact(args.String("sender_phone_number"))
+			},
+		},
+		{
+			Name:        "get_contact_chats",
+			Description: "Get all WhatsApp chats involving the contact.",
+			Capability:  apiCapabilityRead,
+			Params: []mcpParam{
+				{"jid", "string", "The contact's JID to search for", true},
+				{"limit", "integer", "Maximum number of chats to return (default 20)", false},
+				{"page", "integer", "Page number for pagination (default 0)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.getContactChats(args.String("jid"), args.Int("limit", 20), args.Int("page", 0))
+			},
+		},
+		{
+			Name:        "get_last_interaction",
+			Description: "Get most recent WhatsApp message involving the contact.",
+			Capability:  apiCapabilityRead,
+			Params: []mcpParam{
+				{"jid", "string", "The JID of the contact to search for", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.getLastInteraction(args.String("jid"))
+			},
+		},
+		{
+			Name: "get_message_context",
+			Description: "Get the conversation around a specific WhatsApp message, e.g. one found with search_messages: " +
+				"the message and the messages sent just before and after it in its chat, in time order, each with its sender's name and timestamp.",
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"message_id", "string", "The ID of the message to get context for", true},
+				{"chat_jid", "string", "Optional JID of the message's chat; message IDs are only unique within a chat", false},
+				{"before", "integer", "Number of messages to include before the target message (default 5, at most 50)", false},
+				{"after", "integer", "Number of messages to include after the target message (default 5, at most 50)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.getMessageContext(args.String("message_id"), args.String("chat_jid"), args.Int("before", 5), args.Int("after", 5))
+			},
+		},
+		{
+			Name: "get_thread",
+			Description: "Get the whole reply thread a WhatsApp message belongs to, to follow a discussion held in replies: " +
+				"root is the first message, and messages has it and every reply to it or to those replies, in time order, " +
+				"with sender names and reactions. Each reply has reply_to, the ID of the message it quotes.",
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"message_id", "string", "The ID of any message in the thread", true},
+				{"chat_jid", "string", "The JID of the chat the message is in (optional, looked up when omitted)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.getThread(args.String("message_id"), args.String("chat_jid"))
+			},
+		},
+
+		// Sending
+		{
+			Name: "send_message",
+			Description: "Send a WhatsApp message to a person or group. For group chats use the JID. " +
+				`To @mention (and notify) people, write "@<phone number>" in the message or list them in mentions. ` +
+				"Returns the message_id once sent, or the queue_id when the bridge queued it to send once possible.",
+			Capability: apiCapabilitySend,
+			Params: []mcpParam{
+				{"recipient", "string", mcpRecipientDescription, true},
+				{"message", "string", "The message text to send", true},
+				{"mentions", "array", "Optional phone numbers or JIDs to @mention; those not written in the message are appended to it", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.post("/api/send", SendMessageRequest{
+					Recipient: args.String("recipient"), Message: args.String("message"), Mentions: args.Strings("mentions"),
+				})
+			},
+		},
+		{
+			Name: "send_file",
+			Description: "Send a file such as a picture, chart, raw audio, video or document (e.g. a PDF) via WhatsApp to the specified recipient. " +
+				"Images and videos are sent as media with a preview, audio as playable audio and anything else as a document. For group messages use the JID.",
+			Capability: apiCapabilitySend,
+			Params: []mcpParam{
+				{"recipient", "string", mcpRecipientDescription, true},
+				{"media_path", "string", "The absolute path to the media file to send (image, video, audio, document)", true},
+				{"caption", "string", "Optional text shown under the image, video or document (audio can't have one)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				mediaPath := args.String("media_path")
+				if _, err := os.Stat(mediaPath); err != nil {
+					return nil, fmt.Errorf("media file not found: %s", mediaPath)
+				}
+				return call.post("/api/send", SendMessageRequest{
+					Recipient: args.String("recipient"), Message: args.String("caption"), MediaPath: mediaPath,
+				})
+			},
+		},
+		{
+			Name: "send_audio_message",
+			Description: "Send any audio file as a WhatsApp audio message to the specified recipient. For group messages use the JID. " +
+				"Files other than .ogg are converted to Opus with ffmpeg; if the bridge has no ffmpeg, use send_file instead.",
+			Capability: apiCapabilitySend,
+			Params: []mcpParam{
+				{"recipient", "string", mcpRecipientDescription, true},
+				{"media_path", "string", "The absolute path to the audio file to send (will be converted to Opus .ogg if it's not a .ogg file)", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.sendAudioMessage(args.String("recipient"), args.String("media_path"))
+			},
+		},
+		{
+			Name: "send_sticker",
+			Description: "Send an image as a WhatsApp sticker to the specified recipient. For group messages use the JID. " +
+				"A 512x512 WebP is sent as it is; PNG and JPEG images become static stickers and GIFs animated ones, which needs ffmpeg on the bridge.",
+			Capability: apiCapabilitySend,
+			Params: []mcpParam{
+				{"recipient", "string", mcpRecipientDescription, true},
+				{"media_path", "string", "The absolute path to the WebP, PNG, JPEG or GIF file", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.post("/api/stickers/send", map[string]string{"recipient": args.String("recipient"), "media_path": args.String("media_path")})
+			},
+		},
+		{
+			Name: "send_poll",
+			Description: "Send a WhatsApp poll to a person or group, e.g. to let a group decide quickly. For group chats use the JID. " +
+				"Returns the poll ID, to read the votes with get_poll_results.",
+			Capability: apiCapabilitySend,
+			Params: []mcpParam{
+				{"recipient", "string", mcpRecipientDescription, true},
+				{"question", "string", "The poll question", true},
+				{"options", "array", "Between 2 and 12 distinct options", true},
+				{"multiple_answers", "boolean", "Whether people may pick several options (default false: one each)", false},
+				{"selectable_count", "integer", "Instead of multiple_answers, exactly how many options each person may pick (0 for any number)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				selectable := 1
+				if args.Bool("multiple_answers", false) {
+					selectable = 0
+				}
+				return call.post("/api/polls/send", map[string]interface{}{
+					"recipient": args.String("recipient"), "question": args.String("question"),
+					"options": args.Strings("options"), "selectable_count": args.Int("selectable_count", selectable),
+				})
+			},
+		},
+		{
+			Name: "send_reaction",
+			Description: "React to a WhatsApp message with an emoji, replacing my earlier reaction to it, or remove my reaction. " +
+				"The message must be stored by the bridge, e.g. found with list_messages or search_messages.",
+			Capability: apiCapabilitySend,
+			Params: []mcpParam{
+				{"chat_jid", "string", "The JID of the message's chat", true},
+				{"message_id", "string", "The ID of the message to react to", true},
+				{"emoji", "string", `The emoji to react with, e.g. "👍"; an empty string removes my reaction`, true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.post("/api/reactions/send", map[string]interface{}{
+					"chat_jid": args.String("chat_jid"), "message_id": args.String("message_id"), "emoji": args.String("emoji"),
+				})
+			},
+		},
+		{
+			Name: "mark_as_read",
+			Description: "Mark WhatsApp messages as read, sending read receipts (blue ticks) to their senders: specific messages, " +
+				"or the whole chat, i.e. the messages since I last wrote in it. Clears the chat's unread count in list_chats.",
+			Capability: apiCapabilitySend,
+			Params: []mcpParam{
+				{"chat_jid", "string", "The JID of the chat", true},
+				{"message_ids", "array", "Optional IDs of messages from others to mark as read; leave out to mark the whole chat", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.post("/api/mark-read", map[string]interface{}{
+					"chat_jid": args.String("chat_jid"), "message_ids": args.Strings("message_ids"),
+				})
+			},
+		},
+		{
+			Name: "get_outbox",
+			Description: "List the messages the bridge couldn't send right away (e.g. while disconnected) and retries, " +
+				"with the recently sent and failed ones. A message queued by send_message shows up here with its queue_id.",
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"status", "string", `Only "pending", "scheduled", "sent" or "failed" messages (default: all)`, false},
+				{"limit", "integer", "Maximum number of messages to return (default 50)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.get("/api/outbox", map[string]string{"status": args.String("status"), "limit": strconv.Itoa(args.Int("limit", 50))})
+			},
+		},
+		{
+			Name:        "retry_outbox_message",
+			Description: "Queue a failed message again; it is sent after the messages already waiting for the same recipient.",
+			Capability:  apiCapabilitySend,
+			Params: []mcpParam{
+				{"queue_id", "integer", "The message's id in the outbox (see get_outbox)", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.post("/api/outbox/retry", map[string]int{"id": args.Int("queue_id", 0)})
+			},
+		},
+		{
+			Name: "schedule_message",
+			Description: "Schedule a text message to be sent later, e.g. a reminder or a birthday message. " +
+				"The bridge keeps it across restarts and sends it within a few seconds of send_at, or as soon as it's connected again. " +
+				"Returns its queue_id, to cancel it with cancel_scheduled.",
+			Capability: apiCapabilitySend,
+			Params: []mcpParam{
+				{"recipient", "string", mcpRecipientDescription, true},
+				{"message", "string", "The message text to send", true},
+				{"send_at", "string", "When to send it: an ISO-8601 time with its offset (e.g. 2025-03-01T09:00:00-03:00), or without one in the bridge's timezone; within a year", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.post("/api/schedule", map[string]string{
+					"recipient": args.String("recipient"), "message": args.String("message"), "send_at": args.String("send_at"),
+				})
+			},
+		},
+		{
+			Name:        "list_scheduled",
+			Description: "List the messages scheduled with schedule_message that weren't sent yet, each with its id (the queue_id), recipient, text and next_attempt_at (when it will be sent).",
+			Capability:  apiCapabilityRead,
+			Params: []mcpParam{
+				{"limit", "integer", "Maximum number of messages to return (default 50)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.get("/api/outbox", map[string]string{"status": outboxScheduled, "limit": strconv.Itoa(args.Int("limit", 50))})
+			},
+		},
+		{
+			Name:        "cancel_scheduled",
+			Description: "Cancel a scheduled message before it is sent.",
+			Capability:  apiCapabilitySend,
+			Params: []mcpParam{
+				{"queue_id", "integer", "The scheduled message's id (see list_scheduled)", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.post("/api/schedule/cancel", map[string]int{"id": args.Int("queue_id", 0)})
+			},
+		},
+		{
+			Name: "get_message_status",
+			Description: "Check whether a message sent earlier was delivered and read: its status (sent, delivered or read), " +
+				"when it was first delivered and read, and per recipient for groups. send_message returns the message ID.",
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"chat_jid", "string", "The JID of the chat the message was sent to", true},
+				{"message_id", "string", "The ID of the sent message", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.get("/api/message-status", map[string]string{"chat_jid": args.String("chat_jid"), "message_id": args.String("message_id")})
+			},
+		},
+		{
+			Name: "get_poll_results",
+			Description: "Get the results of a WhatsApp poll: the votes and voters (with their names) of each option, and the leading option. " +
+				`Polls appear in messages as "📊 Poll: ..."; their message ID is the poll ID (see also list_polls).`,
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"chat_jid", "string", "The JID of the chat the poll was sent in", true},
+				{"poll_id", "string", "The poll's message ID", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.get("/api/polls/tally", map[string]string{"chat_jid": args.String("chat_jid"), "poll_id": args.String("poll_id")})
+			},
+		},
+		{
+			Name:        "list_polls",
+			Description: "List the latest polls of a chat, newest first, each with its poll ID, question and current results.",
+			Capability:  apiCapabilityRead,
+			Params: []mcpParam{
+				{"chat_jid", "string", "The JID of the chat", true},
+				{"limit", "integer", "Maximum number of polls to return (default 20)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.get("/api/polls", map[string]string{"chat_jid": args.String("chat_jid"), "limit": strconv.Itoa(args.Int("limit", 20))})
+			},
+		},
+		{
+			Name: "set_presence",
+			Description: "Show this WhatsApp account as online or offline. While online the phone doesn't get " +
+				"notifications, and contacts' last seen updates are received.",
+			Capability: apiCapabilitySend,
+			Params: []mcpParam{
+				{"presence", "string", `"available" (online) or "unavailable" (offline)`, true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.post("/api/presence/set", map[string]string{"presence": args.String("presence")})
+			},
+		},
+		{
+			Name: "send_typing",
+			Description: `Show "typing..." (or "recording audio...") in a chat, e.g. before writing a long reply. ` +
+				`WhatsApp clears it after about 25 seconds; send "paused" to clear it sooner.`,
+			Capability: apiCapabilitySend,
+			Params: []mcpParam{
+				{"chat_jid", "string", "The JID of the chat", true},
+				{"state", "string", `"composing", "recording" or "paused" (default "composing")`, false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				state := args.String("state")
+				if state == "" {
+					state = "composing"
+				}
+				return call.post("/api/typing", map[string]string{"chat_jid": args.String("chat_jid"), "state": state})
+			},
+		},
+		{
+			Name: "get_last_seen",
+			Description: "Get a contact's last known online state and last seen time. Only recorded for contacts " +
+				"the bridge is subscribed to (PRESENCE_SUBSCRIBE) and while the account is available.",
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"jid", "string", `The contact's JID (e.g. "5511999999999@s.whatsapp.net")`, true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.get("/api/presence", map[string]string{"jid": args.String("jid")})
+			},
+		},
+
+		// Media
+		{
+			Name: "download_media",
+			Description: "Download the media (image, video, audio, document or sticker) of a WhatsApp message, unless it was downloaded before, " +
+				"and get the local file path, mime type and size, so the file can be read or analyzed.",
+			Capability: apiCapabilityDownload,
+			Params: []mcpParam{
+				{"message_id", "string", "The ID of the message containing the media", true},
+				{"chat_jid", "string", "The JID of the chat containing the message", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.post("/api/download", DownloadMediaRequest{MessageID: args.String("message_id"), ChatJID: args.String("chat_jid")})
+			},
+		},
+		{
+			Name: "get_media_usage",
+			Description: "Report the media files of each chat or media type and the disk space they take: a row per chat or type, " +
+				"largest on disk first, with files, downloaded, failed, bytes (every file) and disk_bytes (downloaded files, each content counted once), and the totals.",
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"by", "string", `"chat" or "type" (image, video, audio, sticker, document); default "chat"`, false},
+				{"chat_jid", "string", "Optional chat to report on alone", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				by := args.String("by")
+				if by == "" {
+					by = "chat"
+				}
+				return call.get("/api/media/usage", map[string]string{"by": by, "chat_jid": args.String("chat_jid")})
+			},
+		},
+		{
+			Name: "retry_media_downloads",
+			Description: "Download failed media again, oldest first. Files that expired on WhatsApp's servers are asked " +
+				"for from the phone and download once it has uploaded them again. Returns how many files were retried and downloaded, and the errors of the others.",
+			Capability: apiCapabilityDownload,
+			Params: []mcpParam{
+				{"chat_jid", "string", "Optional chat whose failed downloads are retried", false},
+				{"limit", "integer", "Maximum number of files to retry (default 20)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				payload := map[string]interface{}{"limit": args.Int("limit", 20)}
+				if chatJID := args.String("chat_jid"); chatJID != "" {
+					payload["chat_jid"] = chatJID
+				}
+				return call.post("/api/media/retry", payload)
+			},
+		},
+
+		// Contact tags and segments
+		{
+			Name:        "tag_contact",
+			Description: "Add or remove a tag on a contact. Tags are used to build contact segments.",
+			Capability:  apiCapabilityAdmin,
+			Params: []mcpParam{
+				{"jid", "string", "The contact's JID or phone number", true},
+				{"tag", "string", `The tag to add or remove (e.g., "vip", "investor")`, true},
+				{"remove", "boolean", "Remove the tag instead of adding it (default false)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.tagContact(args.String("jid"), args.String("tag"), args.Bool("remove", false))
+			},
+		},
+		{
+			Name: "create_segment",
+			Description: "Create or update a contact segment. All non-empty criteria must match. " +
+				`Segments can be used as recipients with "segment:<name>" in campaigns and daily summaries. Returns the member count.`,
+			Capability: apiCapabilityAdmin,
+			Params: []mcpParam{
+				{"name", "string", "Segment name", true},
+				{"description", "string", "Optional description", false},
+				{"tag", "string", "Only contacts with this tag", false},
+				{"member_of", "string", "Only contacts who wrote in this chat JID (e.g., a group)", false},
+				{"active_within_days", "integer", "Only contacts with an interaction in the last N days (0 to ignore)", false},
+				{"inactive_for_days", "integer", "Only contacts without an interaction in the last N days (0 to ignore)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.createSegment(ContactSegment{
+					Name: args.String("name"), Description: args.String("description"), Tag: args.String("tag"),
+					MemberOf: args.String("member_of"), ActiveWithinDays: args.Int("active_within_days", 0),
+					InactiveForDays: args.Int("inactive_for_days", 0),
+				})
+			},
+		},
+		{
+			Name:        "list_segments",
+			Description: "List all contact segments and their criteria.",
+			Capability:  apiCapabilityAdmin,
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.listSegments()
+			},
+		},
+		{
+			Name:        "get_segment_members",
+			Description: "Get the JIDs of all contacts currently in a segment.",
+			Capability:  apiCapabilityAdmin,
+			Params: []mcpParam{
+				{"name", "string", "Segment name", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				members, err := resolveSegment(call.server.store.db, args.String("name"))
+				if members == nil {
+					members = []string{}
+				}
+				return members, err
+			},
+		},
+
+		// Annotations and the knowledge graph
+		{
+			Name: "add_annotation",
+			Description: "Attach a private note or correction to a message or to a chat's daily summary. " +
+				"Annotations are stored locally and injected as ground truth into future summaries and knowledge graph episodes " +
+				`(e.g., "the amount discussed was 2.5M, not 25M").`,
+			Capability: apiCapabilityAdmin,
+			Params: []mcpParam{
+				{"chat_jid", "string", "The JID of the chat the note is about", true},
+				{"note", "string", "The note or correction", true},
+				{"message_id", "string", "Optional ID of the message being corrected", false},
+				{"summary_date", "string", "Optional date (YYYY-MM-DD) of the summary being corrected", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.addAnnotation(args.String("chat_jid"), args.String("note"), args.String("message_id"), args.String("summary_date"))
+			},
+		},
+		{
+			Name:        "list_annotations",
+			Description: "List notes and corrections, newest first.",
+			Capability:  apiCapabilityRead,
+			Params: []mcpParam{
+				{"chat_jid", "string", "Optional chat JID to filter by", false},
+				{"limit", "integer", "Maximum number of annotations to return (default 50)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.listAnnotations(args.String("chat_jid"), args.Int("limit", 50))
+			},
+		},
+		{
+			Name:        "delete_annotation",
+			Description: "Delete a note or correction.",
+			Capability:  apiCapabilityAdmin,
+			Params: []mcpParam{
+				{"annotation_id", "integer", "The ID of the annotation (see list_annotations)", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.deleteAnnotation(int64(args.Int("annotation_id", 0)))
+			},
+		},
+		{
+			Name:        "list_episodes",
+			Description: "List the knowledge graph (Graphiti) episodes created from a group, with the range of messages each was built from.",
+			Capability:  apiCapabilityRead,
+			Params: []mcpParam{
+				{"group_jid", "string", "The group JID", true},
+				{"start_date", "string", "Optional first date (YYYY-MM-DD)", false},
+				{"end_date", "string", "Optional last date (YYYY-MM-DD)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.listEpisodes(args.String("group_jid"), args.String("start_date"), args.String("end_date"))
+			},
+		},
+		{
+			Name: "get_episode_messages",
+			Description: "Get the original WhatsApp messages a knowledge graph (Graphiti) episode was built from, " +
+				"to trace a graph fact back to its source. Graphiti facts reference the UUIDs of their episodes.",
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"episode_uuid", "string", "The Graphiti episode UUID", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.getEpisodeMessages(args.String("episode_uuid"))
+			},
+		},
+
+		// Groups
+		{
+			Name:        "create_group",
+			Description: "Create a WhatsApp group. This account is added as its admin.",
+			Capability:  apiCapabilityAdmin,
+			Params: []mcpParam{
+				{"name", "string", "The group's subject (up to 25 characters)", true},
+				{"participants", "array", "Members to add, as phone numbers with country code or JIDs", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.post("/api/groups/create", map[string]interface{}{"name": args.String("name"), "participants": args.Strings("participants")})
+			},
+		},
+		{
+			Name:        "get_group_info",
+			Description: "Get a group's subject, description and members, with who is an admin.",
+			Capability:  apiCapabilityRead,
+			Params: []mcpParam{
+				{"group_jid", "string", `The JID of the group (e.g. "123456789@g.us")`, true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.get("/api/groups/info", map[string]string{"group_jid": args.String("group_jid")})
+			},
+		},
+		{
+			Name: "get_group_participants",
+			Description: "List the members of a group, admins first, with their names, phone numbers and admin flags, " +
+				`e.g. to answer "who is in the LPs group and who are the admins?". The group can be given by name.`,
+			Capability: apiCapabilityRead,
+			Params: []mcpParam{
+				{"group", "string", `The group's JID (e.g. "123456789@g.us") or name`, true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.getGroupParticipants(args.String("group"))
+			},
+		},
+		{
+			Name: "update_group_participants",
+			Description: "Add, remove, promote (make admin) or demote members of a group. Members WhatsApp refused " +
+				"come back with a non-zero error code (403 usually means they must be invited with the link).",
+			Capability: apiCapabilityAdmin,
+			Params: []mcpParam{
+				{"group_jid", "string", "The JID of the group", true},
+				{"action", "string", `"add", "remove", "promote" or "demote"`, true},
+				{"participants", "array", "Phone numbers with country code or JIDs", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.post("/api/groups/participants", map[string]interface{}{
+					"group_jid": args.String("group_jid"), "action": args.String("action"), "participants": args.Strings("participants"),
+				})
+			},
+		},
+		{
+			Name:        "set_group_subject",
+			Description: "Change a group's subject (its name).",
+			Capability:  apiCapabilityAdmin,
+			Params: []mcpParam{
+				{"group_jid", "string", "The JID of the group", true},
+				{"subject", "string", "The new subject", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.post("/api/groups/subject", map[string]string{"group_jid": args.String("group_jid"), "subject": args.String("subject")})
+			},
+		},
+		{
+			Name:        "set_group_description",
+			Description: "Change a group's description; an empty description removes it.",
+			Capability:  apiCapabilityAdmin,
+			Params: []mcpParam{
+				{"group_jid", "string", "The JID of the group", true},
+				{"description", "string", "The new description", true},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				return call.post("/api/groups/description", map[string]string{"group_jid": args.String("group_jid"), "description": args.String("description")})
+			},
+		},
+		{
+			Name:        "get_group_invite_link",
+			Description: "Get a group's invite link. With revoke, the current link stops working and a new one is returned.",
+			Capability:  apiCapabilityAdmin,
+			Params: []mcpParam{
+				{"group_jid", "string", "The JID of the group", true},
+				{"revoke", "boolean", "Revoke the current link and create a new one (default false)", false},
+			},
+			Call: func(call *mcpCall, args mcpArgs) (interface{}, error) {
+				if args.Bool("revoke", false) {
+					return call.post("/api/groups/invite-link", map[string]string{"group_jid": args.String("group_jid")})
+				}
+				return call.get("/api/groups/invite-link", map[string]string{"group_jid": args.String("group_jid")})
+			},
+		},
+	}
+}
+
+// sendAudioMessage sends an audio file as a voice message, converting anything but .ogg to Opus first
+func (call *mcpCall) sendAudioMessage(recipient, mediaPath string) (interface{}, error) {
+	if _, err := os.Stat(mediaPath); err != nil {
+		return nil, fmt.Errorf("media file not found: %s", mediaPath)
+	}
+	converted := ""
+	if strings.ToLower(filepath.Ext(mediaPath)) != ".ogg" {
+		var err error
+		if converted, err = convertToOpusOgg(mediaPath); err != nil {
+			return nil, fmt.Errorf("error converting file to opus ogg, you likely need to install ffmpeg: %v", err)
+		}
+		mediaPath = converted
+	}
+	result, err := call.post("/api/send", SendMessageRequest{Recipient: recipient, MediaPath: mediaPath})
+	// A queued message is sent from the converted file later
+	if response, ok := result.(map[string]interface{}); converted != "" && (err != nil || !ok || response["queued"] != true) {
+		os.Remove(converted)
+	}
+	return result, err
+}
+
+// convertToOpusOgg converts an audio file into a temporary Ogg Opus file, the format of WhatsApp voice messages
+func convertToOpusOgg(path string) (string, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return "", err
+	}
+	output, err := os.CreateTemp("", "voice-*.ogg")
+	if err != nil {
+		return "", err
+	}
+	output.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), audioConvertTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-y", "-i", path, "-c:a", "libopus", "-b:a", "32k", "-ar", "24000",
+		"-application", "voip", "-vbr", "on", "-compression_level", "10", "-frame_duration", "60", output.Name()).CombinedOutput()
+	if err != nil {
+		os.Remove(output.Name())
+		return "", fmt.Errorf("ffmpeg failed: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return output.Name(), nil
+}
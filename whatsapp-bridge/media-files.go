@@ -0,0 +1,529 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/proto/waMmsRetry"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Download states of a media file (see migrations/0007_media_files.sql)
+const (
+	mediaStatePending    = "pending"
+	mediaStateDownloaded = "downloaded"
+	mediaStateFailed     = "failed"
+	mediaStateExpired    = "expired" // gone from WhatsApp's servers; the phone was asked to upload it again
+)
+
+// Downloads of a file tried before /api/media/retry gives up on it
+const mediaMaxAttempts = 5
+
+// Failed downloads retried per /api/media/retry request, by default and at most
+const (
+	mediaRetryDefaultLimit = 20
+	mediaRetryMaxLimit     = 200
+)
+
+// mediaFileDetails returns a media message's mime type and direct path, which extractMediaInfo leaves out
+func mediaFileDetails(msg *waProto.Message) (mimeType, directPath string) {
+	var media interface {
+		GetMimetype() string
+		GetDirectPath() string
+	}
+	switch {
+	case msg.GetImageMessage() != nil:
+		media = msg.GetImageMessage()
+	case msg.GetVideoMessage() != nil:
+		media = msg.GetVideoMessage()
+	case msg.GetAudioMessage() != nil:
+		media = msg.GetAudioMessage()
+	case msg.GetStickerMessage() != nil:
+		media = msg.GetStickerMessage()
+	case msg.GetDocumentMessage() != nil:
+		media = msg.GetDocumentMessage()
+	default:
+		return "", ""
+	}
+	return media.GetMimetype(), media.GetDirectPath()
+}
+
+// StoreMediaFile records a media message's file metadata. A message delivered again updates it but keeps its
+// download state.
+func (store *MessageStore) StoreMediaFile(messageID, chatJID string, msg *waProto.Message) error {
+	mediaType, _, url, _, fileSHA256, fileEncSHA256, fileLength := extractMediaInfo(msg)
+	if mediaType == "" || messageID == "" {
+		return nil
+	}
+	mimeType, directPath := mediaFileDetails(msg)
+	if directPath == "" && url != "" {
+		directPath = extractDirectPathFromURL(url)
+	}
+	_, err := store.dbFor(chatJID).Exec(`
+		INSERT INTO media_files (message_id, chat_jid, media_type, mime_type, file_length, file_sha256, file_enc_sha256, direct_path)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid) DO UPDATE SET
+			media_type = excluded.media_type, mime_type = excluded.mime_type, file_length = excluded.file_length,
+			file_sha256 = excluded.file_sha256, file_enc_sha256 = excluded.file_enc_sha256, direct_path = excluded.direct_path
+	`, messageID, chatJID, mediaType, mimeType, fileLength, hexOrNil(fileSHA256), hexOrNil(fileEncSHA256), directPath)
+	return err
+}
+
+// hexOrNil hex-encodes a hash, or returns NULL for a missing one
+func hexOrNil(hash []byte) interface{} {
+	if len(hash) == 0 {
+		return nil
+	}
+	return hex.EncodeToString(hash)
+}
+
+// recordMediaFile records a received media message's file metadata, logging failures
+func recordMediaFile(messageStore *MessageStore, messageID, chatJID string, msg *waProto.Message, logger waLog.Logger) {
+	if err := messageStore.StoreMediaFile(messageID, chatJID, msg); err != nil {
+		logger.Warnf("Failed to record media file of %s: %v", messageID, err)
+	}
+}
+
+// mediaDirectPath returns the direct path recorded for a media file, "" when there is none
+func (store *MessageStore) mediaDirectPath(messageID, chatJID string) string {
+	var directPath sql.NullString
+	store.dbFor(chatJID).QueryRow("SELECT direct_path FROM media_files WHERE message_id = ? AND chat_jid = ?",
+		messageID, chatJID).Scan(&directPath)
+	return directPath.String
+}
+
+// mediaMimeType returns the mime type of a downloaded media file: as the message gave it, or else guessed from the
+// file's extension or content
+func (store *MessageStore) mediaMimeType(messageID, chatJID, path string) string {
+	var mimeType sql.NullString
+	store.dbFor(chatJID).QueryRow("SELECT mime_type FROM media_files WHERE message_id = ? AND chat_jid = ?",
+		messageID, chatJID).Scan(&mimeType)
+	if mimeType.String != "" {
+		return mimeType.String
+	}
+	if guessed := mime.TypeByExtension(filepath.Ext(path)); guessed != "" {
+		return guessed
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer file.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	return http.DetectContentType(head[:n])
+}
+
+// SetMediaDownloaded records where a media file was saved
+func (store *MessageStore) SetMediaDownloaded(messageID, chatJID, localPath string) error {
+	_, err := store.dbFor(chatJID).Exec(`
+		UPDATE media_files SET download_state = ?, local_path = ?, last_error = NULL, downloaded_at = ?
+		WHERE message_id = ? AND chat_jid = ?
+	`, mediaStateDownloaded, localPath, time.Now(), messageID, chatJID)
+	return err
+}
+
+// SetMediaDownloadFailed records a failed download attempt, in the failed or expired state
+func (store *MessageStore) SetMediaDownloadFailed(messageID, chatJID, state string, downloadErr error) error {
+	_, err := store.dbFor(chatJID).Exec(`
+		UPDATE media_files SET download_state = ?, attempts = attempts + 1, last_error = ?
+		WHERE message_id = ? AND chat_jid = ?
+	`, state, downloadErr.Error(), messageID, chatJID)
+	return err
+}
+
+// findDownloadedCopy returns the local path of an already downloaded file with the same content, in either
+// store; "" when there is none left on disk
+func (store *MessageStore) findDownloadedCopy(fileSHA256 []byte) string {
+	if len(fileSHA256) == 0 {
+		return ""
+	}
+	for _, db := range []*sql.DB{store.db, store.direct} {
+		if db == nil {
+			continue
+		}
+		rows, err := db.Query("SELECT local_path FROM media_files WHERE file_sha256 = ? AND download_state = ? AND local_path != ''",
+			hex.EncodeToString(fileSHA256), mediaStateDownloaded)
+		if err != nil {
+			continue
+		}
+		var paths []string
+		for rows.Next() {
+			var path string
+			if rows.Scan(&path) == nil {
+				paths = append(paths, path)
+			}
+		}
+		rows.Close()
+		for _, path := range paths {
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// linkMediaFile puts an existing file at a new path: as a hard link, so the content is stored once, or as a
+// copy where links aren't possible
+func linkMediaFile(source, target string) error {
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(target)
+		return err
+	}
+	return out.Close()
+}
+
+// mediaExpired reports whether a download failed because the file is no longer on WhatsApp's servers
+func mediaExpired(err error) bool {
+	return errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith404) || errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith410)
+}
+
+// requestMediaReupload asks my phone to upload a media file again; the answer arrives as an events.MediaRetry
+func requestMediaReupload(client *whatsmeow.Client, messageStore *MessageStore, messageID, chatJID string, mediaKey []byte) error {
+	chat, err := types.ParseJID(chatJID)
+	if err != nil {
+		return err
+	}
+	var sender string
+	var isFromMe bool
+	err = messageStore.dbFor(chatJID).QueryRow("SELECT sender, is_from_me FROM messages WHERE id = ? AND chat_jid = ?",
+		messageID, chatJID).Scan(&sender, &isFromMe)
+	if err != nil {
+		return err
+	}
+
+	info := &types.MessageInfo{
+		ID:            messageID,
+		MessageSource: types.MessageSource{Chat: chat, IsFromMe: isFromMe, IsGroup: chat.Server == types.GroupServer},
+	}
+	if info.IsGroup {
+		// The raw message keeps the sender's full JID, which may be a LID
+		var rawSender string
+		messageStore.dbFor(chatJID).QueryRow("SELECT sender FROM raw_messages WHERE message_id = ? AND chat_jid = ?",
+			messageID, chatJID).Scan(&rawSender)
+		if info.Sender, err = types.ParseJID(rawSender); err != nil || rawSender == "" {
+			info.Sender = types.NewJID(sender, types.DefaultUserServer)
+		}
+	}
+	return client.SendMediaRetryReceipt(info, mediaKey)
+}
+
+// handleMediaRetry takes my phone's answer to a re-upload request: the file's new direct path, which it is
+// downloaded from again
+func handleMediaRetry(client *whatsmeow.Client, messageStore *MessageStore, evt *events.MediaRetry, logger waLog.Logger) {
+	messageID, chatJID := evt.MessageID, evt.ChatID.String()
+	_, _, _, mediaKey, _, _, _, err := messageStore.GetMediaInfo(messageID, chatJID)
+	if err != nil || len(mediaKey) == 0 {
+		return
+	}
+
+	notification, err := whatsmeow.DecryptMediaRetryNotification(evt, mediaKey)
+	if err == nil && notification.GetResult() != waMmsRetry.MediaRetryNotification_SUCCESS {
+		err = fmt.Errorf("re-upload failed: %s", notification.GetResult())
+	}
+	if err == nil && notification.GetDirectPath() == "" {
+		err = fmt.Errorf("re-upload returned no direct path")
+	}
+	if err != nil {
+		logger.Warnf("Media of %s can't be downloaded again: %v", messageID, err)
+		messageStore.SetMediaDownloadFailed(messageID, chatJID, mediaStateFailed, err)
+		return
+	}
+
+	_, err = messageStore.dbFor(chatJID).Exec("UPDATE media_files SET direct_path = ?, download_state = ? WHERE message_id = ? AND chat_jid = ?",
+		notification.GetDirectPath(), mediaStatePending, messageID, chatJID)
+	if err != nil {
+		logger.Warnf("Failed to record the new direct path of %s: %v", messageID, err)
+		return
+	}
+	if _, _, _, _, err := downloadMedia(client, messageStore, messageID, chatJID); err != nil {
+		logger.Warnf("Failed to download re-uploaded media of %s: %v", messageID, err)
+	}
+}
+
+// MediaRetryRequest is the body of /api/media/retry
+type MediaRetryRequest struct {
+	ChatJID string `json:"chat_jid,omitempty"` // only this chat's failed downloads
+	Limit   int    `json:"limit,omitempty"`
+}
+
+// MediaRetryResponse is the /api/media/retry response
+type MediaRetryResponse struct {
+	Retried    int      `json:"retried"`
+	Downloaded int      `json:"downloaded"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+// failedMediaDownloads lists failed downloads that haven't used up their attempts, oldest first
+func (store *MessageStore) failedMediaDownloads(chatJID string, chats []string, limit int) ([][2]string, error) {
+	var failed [][2]string
+	for _, db := range []*sql.DB{store.db, store.direct} {
+		if db == nil || (chatJID != "" && db != store.dbFor(chatJID)) {
+			continue
+		}
+		conditions := []string{"f.download_state IN (?, ?)", "f.attempts < ?"}
+		args := []interface{}{mediaStateFailed, mediaStateExpired, mediaMaxAttempts}
+		if chatJID != "" {
+			conditions = append(conditions, "f.chat_jid = ?")
+			args = append(args, chatJID)
+		}
+		if chats != nil {
+			if len(chats) == 0 {
+				return nil, nil
+			}
+			conditions = append(conditions, "f.chat_jid IN (?"+strings.Repeat(", ?", len(chats)-1)+")")
+			for _, chat := range chats {
+				args = append(args, chat)
+			}
+		}
+		args = append(args, limit)
+		rows, err := db.Query(`
+			SELECT f.message_id, f.chat_jid FROM media_files f
+			JOIN messages m ON m.id = f.message_id AND m.chat_jid = f.chat_jid
+			WHERE `+strings.Join(conditions, " AND ")+`
+			ORDER BY m.timestamp
+			LIMIT ?
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var file [2]string
+			if err := rows.Scan(&file[0], &file[1]); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			failed = append(failed, file)
+		}
+		rows.Close()
+	}
+	if len(failed) > limit {
+		failed = failed[:limit]
+	}
+	return failed, nil
+}
+
+// handleMediaRetryAPI downloads failed media files again
+// (POST /api/media/retry {"chat_jid": "...", "limit": 20}). Files still gone from WhatsApp's servers are asked
+// for from my phone, and download when it has uploaded them again.
+func handleMediaRetryAPI(client *whatsmeow.Client, messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req MediaRetryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Limit == 0 {
+			req.Limit = mediaRetryDefaultLimit
+		}
+		if req.Limit < 1 || req.Limit > mediaRetryMaxLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", mediaRetryMaxLimit), http.StatusBadRequest)
+			return
+		}
+		if req.ChatJID != "" && !apiRequestAllowsChat(r, req.ChatJID) {
+			rejectChat(w, r, req.ChatJID)
+			return
+		}
+		var chats []string
+		if token := apiTokenFromRequest(r); token != nil && !slices.Contains(token.Chats, "*") {
+			chats = token.Chats
+		}
+
+		failed, err := messageStore.failedMediaDownloads(req.ChatJID, chats, req.Limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response := MediaRetryResponse{Retried: len(failed)}
+		for _, file := range failed {
+			if _, _, _, _, err := downloadMedia(client, messageStore, file[0], file[1]); err != nil {
+				response.Errors = append(response.Errors, fmt.Sprintf("%s in %s: %v", file[0], file[1], err))
+			} else {
+				response.Downloaded++
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// How media usage can be grouped
+const (
+	mediaUsageByChat = "chat"
+	mediaUsageByType = "type"
+)
+
+// MediaUsageRow is the media of one chat or media type
+type MediaUsageRow struct {
+	Key        string `json:"key"` // chat JID or media type
+	Name       string `json:"name,omitempty"`
+	Files      int    `json:"files"`
+	Downloaded int    `json:"downloaded"`
+	Failed     int    `json:"failed"`     // failed, or waiting for my phone to upload them again
+	Bytes      int64  `json:"bytes"`      // size of every media file, downloaded or not
+	DiskBytes  int64  `json:"disk_bytes"` // space the downloaded files take, counting files with the same content once
+}
+
+// MediaUsageResponse is the /api/media/usage response
+type MediaUsageResponse struct {
+	By     string          `json:"by"`
+	Rows   []MediaUsageRow `json:"rows"`
+	Totals MediaUsageRow   `json:"totals"`
+}
+
+// MediaUsage adds up the media files of both stores per chat or media type, the largest on disk first
+func (store *MessageStore) MediaUsage(by, chatJID string, chats []string) (MediaUsageResponse, error) {
+	response := MediaUsageResponse{By: by, Rows: []MediaUsageRow{}, Totals: MediaUsageRow{Key: "total"}}
+	usage := make(map[string]*MediaUsageRow)
+	// Content already counted, per row and overall
+	counted := make(map[string]bool)
+
+	for _, db := range []*sql.DB{store.db, store.direct} {
+		if db == nil || (chatJID != "" && db != store.dbFor(chatJID)) {
+			continue
+		}
+		conditions := []string{"1 = 1"}
+		var args []interface{}
+		if chatJID != "" {
+			conditions = append(conditions, "f.chat_jid = ?")
+			args = append(args, chatJID)
+		}
+		if chats != nil {
+			if len(chats) == 0 {
+				return response, nil
+			}
+			conditions = append(conditions, "f.chat_jid IN (?"+strings.Repeat(", ?", len(chats)-1)+")")
+			for _, chat := range chats {
+				args = append(args, chat)
+			}
+		}
+		rows, err := db.Query(`
+			SELECT f.message_id, f.chat_jid, COALESCE(c.name, ''), f.media_type, f.download_state,
+				COALESCE(f.file_length, 0), COALESCE(f.file_sha256, '')
+			FROM media_files f
+			LEFT JOIN chats c ON c.jid = f.chat_jid
+			WHERE `+strings.Join(conditions, " AND "), args...)
+		if err != nil {
+			return response, fmt.Errorf("failed to read media usage: %v", err)
+		}
+		for rows.Next() {
+			var messageID, chat, name, mediaType, state, sha256 string
+			var length int64
+			if err := rows.Scan(&messageID, &chat, &name, &mediaType, &state, &length, &sha256); err != nil {
+				rows.Close()
+				return response, fmt.Errorf("failed to read media usage: %v", err)
+			}
+			key := mediaType
+			if by == mediaUsageByChat {
+				key = chat
+			} else {
+				name = ""
+			}
+			row, ok := usage[key]
+			if !ok {
+				row = &MediaUsageRow{Key: key, Name: name}
+				usage[key] = row
+			}
+			content := sha256
+			if content == "" {
+				content = messageID + "/" + chat
+			}
+
+			for _, total := range []*MediaUsageRow{row, &response.Totals} {
+				total.Files++
+				total.Bytes += length
+				switch state {
+				case mediaStateDownloaded:
+					total.Downloaded++
+					if seen := total.Key + "\x00" + content; !counted[seen] {
+						counted[seen] = true
+						total.DiskBytes += length
+					}
+				case mediaStateFailed, mediaStateExpired:
+					total.Failed++
+				}
+			}
+		}
+		rows.Close()
+	}
+
+	for _, row := range usage {
+		response.Rows = append(response.Rows, *row)
+	}
+	sort.Slice(response.Rows, func(i, j int) bool {
+		if response.Rows[i].DiskBytes != response.Rows[j].DiskBytes {
+			return response.Rows[i].DiskBytes > response.Rows[j].DiskBytes
+		}
+		return response.Rows[i].Key < response.Rows[j].Key
+	})
+	return response, nil
+}
+
+// handleMediaUsageAPI reports how much media each chat or media type has, and how much of it is on disk
+// (GET /api/media/usage?by=chat|type&chat_jid=...). Tokens limited to some chats only count those chats.
+func handleMediaUsageAPI(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		by, chatJID := r.URL.Query().Get("by"), r.URL.Query().Get("chat_jid")
+		if by == "" {
+			by = mediaUsageByChat
+		}
+		if by != mediaUsageByChat && by != mediaUsageByType {
+			http.Error(w, "by must be chat or type", http.StatusBadRequest)
+			return
+		}
+		if chatJID != "" && !apiRequestAllowsChat(r, chatJID) {
+			rejectChat(w, r, chatJID)
+			return
+		}
+		var chats []string
+		if token := apiTokenFromRequest(r); token != nil && !slices.Contains(token.Chats, "*") {
+			chats = token.Chats
+		}
+
+		response, err := messageStore.MediaUsage(by, chatJID, chats)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
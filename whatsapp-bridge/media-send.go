@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+	"math/rand"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Longest side of the JPEG thumbnails sent with images and videos
+const mediaThumbnailSize = 100
+
+// How long ffmpeg may take to grab the first frame of a video
+const videoThumbnailTimeout = 30 * time.Second
+
+// Mime types of the files WhatsApp shows as media, and of common documents; others are looked up by extension,
+// then sniffed from the content
+var mediaMimeTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".mp4":  "video/mp4",
+	".3gp":  "video/3gpp",
+	".mov":  "video/quicktime",
+	".avi":  "video/avi",
+	".ogg":  "audio/ogg; codecs=opus",
+	".opus": "audio/ogg; codecs=opus",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".amr":  "audio/amr",
+	".pdf":  "application/pdf",
+	".md":   "text/markdown",
+	".txt":  "text/plain",
+	".csv":  "text/csv",
+	".json": "application/json",
+	".zip":  "application/zip",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+}
+
+// detectMedia returns how a file is sent (image, video, audio or document) and its mime type
+func detectMedia(path string, data []byte) (whatsmeow.MediaType, string) {
+	ext := strings.ToLower(filepath.Ext(path))
+	mimeType := mediaMimeTypes[ext]
+	if mimeType == "" {
+		mimeType = mime.TypeByExtension(ext)
+	}
+	if mimeType == "" {
+		// "application/octet-stream" when the content isn't recognized either
+		mimeType = http.DetectContentType(data)
+	}
+
+	switch strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0]) {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return whatsmeow.MediaImage, mimeType
+	case "video/mp4", "video/3gpp", "video/quicktime", "video/avi":
+		return whatsmeow.MediaVideo, mimeType
+	case "audio/ogg", "application/ogg":
+		return whatsmeow.MediaAudio, "audio/ogg; codecs=opus"
+	case "audio/mpeg", "audio/mp4", "audio/aac", "audio/amr":
+		return whatsmeow.MediaAudio, mimeType
+	}
+	return whatsmeow.MediaDocument, mimeType
+}
+
+// imageThumbnail returns a small JPEG of an image with the image's size; the thumbnail is nil when the
+// image can't be decoded (e.g. WebP)
+func imageThumbnail(data []byte) (thumbnail []byte, width, height uint32) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0
+	}
+	bounds := img.Bounds()
+	width, height = uint32(bounds.Dx()), uint32(bounds.Dy())
+	if width == 0 || height == 0 {
+		return nil, 0, 0
+	}
+
+	scale := math.Min(1, float64(mediaThumbnailSize)/float64(max(width, height)))
+	thumbWidth := max(1, int(float64(width)*scale))
+	thumbHeight := max(1, int(float64(height)*scale))
+	thumb := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	for y := 0; y < thumbHeight; y++ {
+		for x := 0; x < thumbWidth; x++ {
+			thumb.Set(x, y, img.At(bounds.Min.X+x*bounds.Dx()/thumbWidth, bounds.Min.Y+y*bounds.Dy()/thumbHeight))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 70}); err != nil {
+		return nil, width, height
+	}
+	return buf.Bytes(), width, height
+}
+
+// videoThumbnail returns a small JPEG of a video's first frame with the video's size, when ffmpeg is installed
+func videoThumbnail(path string) (thumbnail []byte, width, height uint32) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, 0, 0
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), videoThumbnailTimeout)
+	defer cancel()
+	frame, err := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-i", path, "-frames:v", "1", "-f", "image2pipe", "-vcodec", "mjpeg", "-").Output()
+	if err != nil {
+		return nil, 0, 0
+	}
+	return imageThumbnail(frame)
+}
+
+// buildMediaMessage uploads a file and returns the message sending it with a caption: images, videos and
+// audio as such (Ogg Opus as a voice note), anything else as a document. Images and videos get a thumbnail.
+// Audio can't have a caption. contextInfo quotes a reply and lists mentions, and may be nil.
+func buildMediaMessage(ctx context.Context, client *whatsmeow.Client, path, caption string, contextInfo *waProto.ContextInfo) (*waProto.Message, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read media file: %v", err)
+	}
+	mediaType, mimeType := detectMedia(path, data)
+
+	// Check voice notes before uploading them
+	var seconds uint32
+	var waveform []byte
+	voiceNote := strings.HasPrefix(mimeType, "audio/ogg")
+	if voiceNote {
+		if seconds, waveform, err = analyzeOggOpus(data); err != nil {
+			return nil, fmt.Errorf("failed to analyze Ogg Opus file: %v", err)
+		}
+	}
+
+	upload, err := client.Upload(ctx, data, mediaType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload media: %v", err)
+	}
+
+	msg := &waProto.Message{}
+	switch mediaType {
+	case whatsmeow.MediaImage:
+		thumbnail, width, height := imageThumbnail(data)
+		msg.ImageMessage = &waProto.ImageMessage{
+			ContextInfo:   contextInfo,
+			Caption:       proto.String(caption),
+			Mimetype:      proto.String(mimeType),
+			URL:           &upload.URL,
+			DirectPath:    &upload.DirectPath,
+			MediaKey:      upload.MediaKey,
+			FileEncSHA256: upload.FileEncSHA256,
+			FileSHA256:    upload.FileSHA256,
+			FileLength:    &upload.FileLength,
+			JPEGThumbnail: thumbnail,
+		}
+		if width > 0 {
+			msg.ImageMessage.Width, msg.ImageMessage.Height = proto.Uint32(width), proto.Uint32(height)
+		}
+	case whatsmeow.MediaVideo:
+		thumbnail, width, height := videoThumbnail(path)
+		msg.VideoMessage = &waProto.VideoMessage{
+			ContextInfo:   contextInfo,
+			Caption:       proto.String(caption),
+			Mimetype:      proto.String(mimeType),
+			URL:           &upload.URL,
+			DirectPath:    &upload.DirectPath,
+			MediaKey:      upload.MediaKey,
+			FileEncSHA256: upload.FileEncSHA256,
+			FileSHA256:    upload.FileSHA256,
+			FileLength:    &upload.FileLength,
+			JPEGThumbnail: thumbnail,
+		}
+		if width > 0 {
+			msg.VideoMessage.Width, msg.VideoMessage.Height = proto.Uint32(width), proto.Uint32(height)
+		}
+	case whatsmeow.MediaAudio:
+		msg.AudioMessage = &waProto.AudioMessage{
+			ContextInfo:   contextInfo,
+			Mimetype:      proto.String(mimeType),
+			URL:           &upload.URL,
+			DirectPath:    &upload.DirectPath,
+			MediaKey:      upload.MediaKey,
+			FileEncSHA256: upload.FileEncSHA256,
+			FileSHA256:    upload.FileSHA256,
+			FileLength:    &upload.FileLength,
+			PTT:           proto.Bool(voiceNote),
+		}
+		if voiceNote {
+			msg.AudioMessage.Seconds, msg.AudioMessage.Waveform = proto.Uint32(seconds), waveform
+		}
+	default:
+		filename := filepath.Base(path)
+		msg.DocumentMessage = &waProto.DocumentMessage{
+			ContextInfo:   contextInfo,
+			Title:         proto.String(filename),
+			FileName:      proto.String(filename),
+			Caption:       proto.String(caption),
+			Mimetype:      proto.String(mimeType),
+			URL:           &upload.URL,
+			DirectPath:    &upload.DirectPath,
+			MediaKey:      upload.MediaKey,
+			FileEncSHA256: upload.FileEncSHA256,
+			FileSHA256:    upload.FileSHA256,
+			FileLength:    &upload.FileLength,
+		}
+	}
+	return msg, nil
+}
+
+// analyzeOggOpus tries to extract duration and generate a simple waveform from an Ogg Opus file
+func analyzeOggOpus(data []byte) (duration uint32, waveform []byte, err error) {
+	// Try to detect if this is a valid Ogg file by checking for the "OggS" signature
+	// at the beginning of the file
+	if len(data) < 4 || string(data[0:4]) != "OggS" {
+		return 0, nil, fmt.Errorf("not a valid Ogg file (missing OggS signature)")
+	}
+
+	// Parse Ogg pages to find the last page with a valid granule position
+	var lastGranule uint64
+	var sampleRate uint32 = 48000 // Default Opus sample rate
+	var preSkip uint16 = 0
+	var foundOpusHead bool
+
+	// Scan through the file looking for Ogg pages
+	for i := 0; i < len(data); {
+		// Check if we have enough data to read Ogg page header
+		if i+27 >= len(data) {
+			break
+		}
+
+		// Verify Ogg page signature
+		if string(data[i:i+4]) != "OggS" {
+			// Skip until next potential page
+			i++
+			continue
+		}
+
+		// Extract header fields
+		granulePos := binary.LittleEndian.Uint64(data[i+6 : i+14])
+		pageSeqNum := binary.LittleEndian.Uint32(data[i+18 : i+22])
+		numSegments := int(data[i+26])
+
+		// Extract segment table
+		if i+27+numSegments >= len(data) {
+			break
+		}
+		segmentTable := data[i+27 : i+27+numSegments]
+
+		// Calculate page size
+		pageSize := 27 + numSegments
+		for _, segLen := range segmentTable {
+			pageSize += int(segLen)
+		}
+
+		// Check if we're looking at an OpusHead packet (should be in first few pages)
+		if !foundOpusHead && pageSeqNum <= 1 {
+			// Look for "OpusHead" marker in this page
+			pageData := data[i : i+pageSize]
+			headPos := bytes.Index(pageData, []byte("OpusHead"))
+			if headPos >= 0 && headPos+12 < len(pageData) {
+				// Found OpusHead, extract sample rate and pre-skip
+				// OpusHead format: Magic(8) + Version(1) + Channels(1) + PreSkip(2) + SampleRate(4) + ...
+				headPos += 8 // Skip "OpusHead" marker
+				// PreSkip is 2 bytes at offset 10
+				if headPos+12 <= len(pageData) {
+					preSkip = binary.LittleEndian.Uint16(pageData[headPos+10 : headPos+12])
+					sampleRate = binary.LittleEndian.Uint32(pageData[headPos+12 : headPos+16])
+					foundOpusHead = true
+					fmt.Printf("Found OpusHead: sampleRate=%d, preSkip=%d\n", sampleRate, preSkip)
+				}
+			}
+		}
+
+		// Keep track of last valid granule position
+		if granulePos != 0 {
+			lastGranule = granulePos
+		}
+
+		// Move to next page
+		i += pageSize
+	}
+
+	if !foundOpusHead {
+		fmt.Println("Warning: OpusHead not found, using default values")
+	}
+
+	// Calculate duration based on granule position
+	if lastGranule > 0 {
+		// Formula for duration: (lastGranule - preSkip) / sampleRate
+		durationSeconds := float64(lastGranule-uint64(preSkip)) / float64(sampleRate)
+		duration = uint32(math.Ceil(durationSeconds))
+		fmt.Printf("Calculated Opus duration from granule: %f seconds (lastGranule=%d)\n",
+			durationSeconds, lastGranule)
+	} else {
+		// Fallback to rough estimation if granule position not found
+		fmt.Println("Warning: No valid granule position found, using estimation")
+		durationEstimate := float64(len(data)) / 2000.0 // Very rough approximation
+		duration = uint32(durationEstimate)
+	}
+
+	// Make sure we have a reasonable duration (at least 1 second, at most 300 seconds)
+	if duration < 1 {
+		duration = 1
+	} else if duration > 300 {
+		duration = 300
+	}
+
+	// Generate waveform
+	waveform = placeholderWaveform(duration)
+
+	fmt.Printf("Ogg Opus analysis: size=%d bytes, calculated duration=%d sec, waveform=%d bytes\n",
+		len(data), duration, len(waveform))
+
+	return duration, waveform, nil
+}
+
+// placeholderWaveform generates a synthetic waveform for WhatsApp voice messages
+// that appears natural with some variability based on the duration
+func placeholderWaveform(duration uint32) []byte {
+	// WhatsApp expects a 64-byte waveform for voice messages
+	const waveformLength = 64
+	waveform := make([]byte, waveformLength)
+
+	// Seed the random number generator for consistent results with the same duration
+	rand.Seed(int64(duration))
+
+	// Create a more natural looking waveform with some patterns and variability
+	// rather than completely random values
+
+	// Base amplitude and frequency - longer messages get faster frequency
+	baseAmplitude := 35.0
+	frequencyFactor := float64(min(int(duration), 120)) / 30.0
+
+	for i := range waveform {
+		// Position in the waveform (normalized 0-1)
+		pos := float64(i) / float64(waveformLength)
+
+		// Create a wave pattern with some randomness
+		// Use multiple sine waves of different frequencies for more natural look
+		val := baseAmplitude * math.Sin(pos*math.Pi*frequencyFactor*8)
+		val += (baseAmplitude / 2) * math.Sin(pos*math.Pi*frequencyFactor*16)
+
+		// Add some randomness to make it look more natural
+		val += (rand.Float64() - 0.5) * 15
+
+		// Add some fade-in and fade-out effects
+		fadeInOut := math.Sin(pos * math.Pi)
+		val = val * (0.7 + 0.3*fadeInOut)
+
+		// Center around 50 (typical voice baseline)
+		val = val + 50
+
+		// Ensure values stay within WhatsApp's expected range (0-100)
+		if val < 0 {
+			val = 0
+		} else if val > 100 {
+			val = 100
+		}
+
+		waveform[i] = byte(val)
+	}
+
+	return waveform
+}
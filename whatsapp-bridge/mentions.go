@@ -0,0 +1,64 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// mentionPattern matches an @mention written as a phone number with country code, e.g. "@5511912345678"
+var mentionPattern = regexp.MustCompile(`@(\d{8,15})\b`)
+
+// addMentions makes the @phone numbers in a text and the given contacts real mentions, which notify those people
+// even in muted groups. Contacts not written in the text are appended as "@<number>", since WhatsApp only
+// highlights mentions that appear in it. It returns the text and the mentioned JIDs.
+func addMentions(text string, contacts []types.JID) (string, []string) {
+	var mentioned []string
+	seen := map[string]bool{}
+	mention := func(jid types.JID) {
+		if !seen[jid.String()] {
+			seen[jid.String()] = true
+			mentioned = append(mentioned, jid.String())
+		}
+	}
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		mention(types.NewJID(match[1], types.DefaultUserServer))
+	}
+	for _, jid := range contacts {
+		jid = jid.ToNonAD()
+		if !strings.Contains(text, "@"+jid.User) {
+			text = strings.TrimRight(text, " ") + " @" + jid.User
+		}
+		mention(jid)
+	}
+	return text, mentioned
+}
+
+// withMentionedJIDs adds mentions to a message's context info (which may also quote a reply), creating it when needed
+func withMentionedJIDs(contextInfo *waProto.ContextInfo, mentioned []string) *waProto.ContextInfo {
+	if len(mentioned) == 0 {
+		return contextInfo
+	}
+	if contextInfo == nil {
+		contextInfo = &waProto.ContextInfo{}
+	}
+	contextInfo.MentionedJID = mentioned
+	return contextInfo
+}
+
+// buildTextMessage builds a text message; mentions and quoted replies need an extended text message
+func buildTextMessage(text string, contextInfo *waProto.ContextInfo) *waProto.Message {
+	if contextInfo == nil {
+		return &waProto.Message{Conversation: proto.String(text)}
+	}
+	return &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text:        proto.String(text),
+			ContextInfo: contextInfo,
+		},
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveMessageColumns are the columns of an archived message, as the messages table has them
+var archiveMessageColumns = []ParquetColumn{
+	{Name: "id", Type: ParquetString},
+	{Name: "chat_jid", Type: ParquetString},
+	{Name: "sender", Type: ParquetString},
+	{Name: "timestamp", Type: ParquetTimestamp},
+	{Name: "is_from_me", Type: ParquetBool},
+	{Name: "content", Type: ParquetString},
+	{Name: "transcript", Type: ParquetString},
+	{Name: "media_type", Type: ParquetString},
+	{Name: "filename", Type: ParquetString},
+	{Name: "file_length", Type: ParquetInt64},
+	{Name: "message_type", Type: ParquetString},
+	{Name: "origin", Type: ParquetString},
+	{Name: "edited_at", Type: ParquetTimestamp},
+	{Name: "deleted_at", Type: ParquetTimestamp},
+}
+
+// ArchivedMessage is a message moved to the archive
+type ArchivedMessage struct {
+	ID          string
+	ChatJID     string
+	Sender      string
+	Timestamp   time.Time
+	IsFromMe    bool
+	Content     string
+	Transcript  string
+	MediaType   string
+	Filename    string
+	FileLength  int64
+	MessageType string
+	Origin      string
+	EditedAt    time.Time // zero when it wasn't edited
+	DeletedAt   time.Time // zero when it wasn't deleted
+}
+
+// parquetRow returns the message as a row of archiveMessageColumns
+func (message ArchivedMessage) parquetRow() []interface{} {
+	optionalTime := func(t time.Time) interface{} {
+		if t.IsZero() {
+			return nil
+		}
+		return t
+	}
+	optionalString := func(s string) interface{} {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+	var fileLength interface{}
+	if message.FileLength != 0 {
+		fileLength = message.FileLength
+	}
+	return []interface{}{
+		message.ID, message.ChatJID, optionalString(message.Sender), message.Timestamp, message.IsFromMe,
+		optionalString(message.Content), optionalString(message.Transcript), optionalString(message.MediaType),
+		optionalString(message.Filename), fileLength, optionalString(message.MessageType), optionalString(message.Origin),
+		optionalTime(message.EditedAt), optionalTime(message.DeletedAt),
+	}
+}
+
+// archivedMessageFromRow reads a row of archiveMessageColumns; times come back in local time, like the database's
+func archivedMessageFromRow(row []interface{}) ArchivedMessage {
+	text := func(i int) string {
+		s, _ := row[i].(string)
+		return s
+	}
+	moment := func(i int) time.Time {
+		if t, ok := row[i].(time.Time); ok {
+			return t.Local()
+		}
+		return time.Time{}
+	}
+	isFromMe, _ := row[4].(bool)
+	fileLength, _ := row[9].(int64)
+	return ArchivedMessage{
+		ID: text(0), ChatJID: text(1), Sender: text(2), Timestamp: moment(3), IsFromMe: isFromMe,
+		Content: text(5), Transcript: text(6), MediaType: text(7), Filename: text(8), FileLength: fileLength,
+		MessageType: text(10), Origin: text(11), EditedAt: moment(12), DeletedAt: moment(13),
+	}
+}
+
+// ArchiveFile is an entry of the archive manifest (see migrations/0008_message_archive.sql)
+type ArchiveFile struct {
+	ID             int64
+	ChatJID        string
+	Month          string
+	Path           string
+	ObjectURL      string
+	FirstTimestamp time.Time
+	LastTimestamp  time.Time
+	Messages       int
+	SHA256         string
+}
+
+// findArchiveFiles lists the archive files of some chats (nil for every chat) with messages between two times
+// (zero for no bound), newest first. Databases the archive table was never added to have no archive.
+func findArchiveFiles(db *sql.DB, chats []string, after, before time.Time) ([]ArchiveFile, error) {
+	if chats != nil && len(chats) == 0 {
+		return nil, nil
+	}
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+	if chats != nil {
+		conditions = append(conditions, "chat_jid IN (?"+strings.Repeat(", ?", len(chats)-1)+")")
+		for _, chat := range chats {
+			args = append(args, chat)
+		}
+	}
+	if !after.IsZero() {
+		conditions = append(conditions, "last_timestamp >= ?")
+		args = append(args, after)
+	}
+	if !before.IsZero() {
+		conditions = append(conditions, "first_timestamp <= ?")
+		args = append(args, before)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, chat_jid, month, path, COALESCE(object_url, ''), first_timestamp, last_timestamp, messages, sha256
+		FROM message_archive
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY last_timestamp DESC
+	`, args...)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []ArchiveFile
+	for rows.Next() {
+		var file ArchiveFile
+		if err := rows.Scan(&file.ID, &file.ChatJID, &file.Month, &file.Path, &file.ObjectURL,
+			&file.FirstTimestamp, &file.LastTimestamp, &file.Messages, &file.SHA256); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// readArchiveFile reads the messages of an archive file. A file no longer kept locally is downloaded again from
+// the bucket it was uploaded to (ARCHIVE_S3_*) and kept; either way it must match its manifest checksum.
+func readArchiveFile(file ArchiveFile) ([]ArchivedMessage, error) {
+	data, err := os.ReadFile(file.Path)
+	if os.IsNotExist(err) && file.ObjectURL != "" {
+		objectStore := objectStoreFromEnv()
+		if objectStore == nil {
+			return nil, fmt.Errorf("%s was archived to %s, but ARCHIVE_S3_BUCKET isn't set", file.Path, file.ObjectURL)
+		}
+		if data, err = objectStore.Get(file.ObjectURL); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(file.Path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create archive directory: %v", err)
+		}
+		if err := os.WriteFile(file.Path, data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to keep %s: %v", file.Path, err)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive file: %v", err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != file.SHA256 {
+		return nil, fmt.Errorf("archive file %s doesn't match its checksum", file.Path)
+	}
+
+	rows, err := readParquetFile(file.Path, archiveMessageColumns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive file %s: %v", file.Path, err)
+	}
+	messages := make([]ArchivedMessage, 0, len(rows))
+	for _, row := range rows {
+		messages = append(messages, archivedMessageFromRow(row))
+	}
+	return messages, nil
+}
+
+// getArchivedMessages returns a chat's archived messages between two times (zero for no bound), in time order
+func getArchivedMessages(db *sql.DB, chatJID string, after, before time.Time) ([]ArchivedMessage, error) {
+	files, err := findArchiveFiles(db, []string{chatJID}, after, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the archive manifest: %v", err)
+	}
+	var messages []ArchivedMessage
+	for _, file := range files {
+		archived, err := readArchiveFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, message := range archived {
+			if (after.IsZero() || !message.Timestamp.Before(after)) && (before.IsZero() || !message.Timestamp.After(before)) {
+				messages = append(messages, message)
+			}
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Timestamp.Before(messages[j].Timestamp) })
+	return messages, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// MessageVersion is an earlier version of an edited message
+type MessageVersion struct {
+	Content    string    `json:"content"`
+	ReplacedAt time.Time `json:"replaced_at"` // when the next version replaced it
+	EditedBy   string    `json:"edited_by"`
+}
+
+// StoreMessageEdit replaces a message's content with its edited version, keeping the previous one in message_edits.
+// It returns false when the message isn't stored.
+func (store *MessageStore) StoreMessageEdit(id, chatJID, content, editedBy string, editedAt time.Time) (bool, error) {
+	tx, err := store.dbFor(chatJID).Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRow("SELECT COALESCE(content, '') FROM messages WHERE id = ? AND chat_jid = ?", id, chatJID).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// The same edit can be delivered more than once
+	if store.open(previous) == content {
+		return true, nil
+	}
+	if content, err = store.seal(chatJID, content); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO message_edits (message_id, chat_jid, content, replaced_at, edited_by) VALUES (?, ?, ?, ?, ?)",
+		id, chatJID, previous, editedAt, editedBy,
+	); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec("UPDATE messages SET content = ?, edited_at = ? WHERE id = ? AND chat_jid = ?", content, editedAt, id, chatJID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// MarkMessageDeleted marks a message as deleted for everyone; its content is kept, but no longer shown.
+// It returns false when the message isn't stored.
+func (store *MessageStore) MarkMessageDeleted(id, chatJID, deletedBy string, deletedAt time.Time) (bool, error) {
+	result, err := store.dbFor(chatJID).Exec(
+		"UPDATE messages SET deleted_at = ?, deleted_by = ? WHERE id = ? AND chat_jid = ? AND deleted_at IS NULL",
+		deletedAt, deletedBy, id, chatJID,
+	)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	return updated > 0, err
+}
+
+// GetMessageVersions returns the earlier versions of an edited message, the original first
+func (store *MessageStore) GetMessageVersions(id, chatJID string) ([]MessageVersion, error) {
+	rows, err := store.dbFor(chatJID).Query(
+		"SELECT COALESCE(content, ''), replaced_at, COALESCE(edited_by, '') FROM message_edits WHERE message_id = ? AND chat_jid = ? ORDER BY replaced_at",
+		id, chatJID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []MessageVersion{}
+	for rows.Next() {
+		var version MessageVersion
+		if err := rows.Scan(&version.Content, &version.ReplacedAt, &version.EditedBy); err != nil {
+			return nil, err
+		}
+		version.Content = store.open(version.Content)
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// handleProtocolMessage records the edits and deletions (revokes) that protocol messages carry; other types are ignored
+func handleProtocolMessage(messageStore *MessageStore, chatJID, sender string, protocol *waProto.ProtocolMessage, receivedAt time.Time, logger waLog.Logger) {
+	// Disappearing-message timer changes don't point at a message
+	if protocol.GetType() == waProto.ProtocolMessage_EPHEMERAL_SETTING {
+		handleEphemeralSetting(messageStore, chatJID, sender, protocol, receivedAt, logger)
+		return
+	}
+
+	messageID := protocol.GetKey().GetID()
+	if messageID == "" {
+		return
+	}
+	timestamp := receivedAt
+	if ms := protocol.GetTimestampMS(); ms > 0 {
+		timestamp = time.UnixMilli(ms)
+	}
+
+	switch protocol.GetType() {
+	case waProto.ProtocolMessage_MESSAGE_EDIT:
+		content := extractTextContent(protocol.GetEditedMessage())
+		if content == "" {
+			return
+		}
+		found, err := messageStore.StoreMessageEdit(messageID, chatJID, content, sender, timestamp)
+		if err != nil {
+			logger.Warnf("Failed to store edit of %s: %v", messageID, err)
+		} else if !found {
+			logger.Debugf("Edited message %s in %s isn't stored, ignoring the edit", messageID, chatJID)
+		} else {
+			fmt.Printf("[%s] %s edited %s: %s\n", timestamp.Format("2006-01-02 15:04:05"), sender, messageID, content)
+		}
+
+	case waProto.ProtocolMessage_REVOKE:
+		found, err := messageStore.MarkMessageDeleted(messageID, chatJID, sender, timestamp)
+		if err != nil {
+			logger.Warnf("Failed to mark %s as deleted: %v", messageID, err)
+		} else if found {
+			fmt.Printf("[%s] %s deleted %s\n", timestamp.Format("2006-01-02 15:04:05"), sender, messageID)
+		}
+	}
+}
+
+// handleMessageHistoryAPI returns a message's edit and deletion state with its earlier versions
+// (GET /api/message-history?chat_jid=...&message_id=...)
+func handleMessageHistoryAPI(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		messageID := r.URL.Query().Get("message_id")
+		if chatJID == "" || messageID == "" {
+			http.Error(w, "chat_jid and message_id are required", http.StatusBadRequest)
+			return
+		}
+		if !apiRequestAllowsChat(r, chatJID) {
+			rejectChat(w, r, chatJID)
+			return
+		}
+
+		var content, deletedBy string
+		var editedAt, deletedAt sql.NullTime
+		err := messageStore.dbFor(chatJID).QueryRow(
+			"SELECT COALESCE(content, ''), edited_at, deleted_at, COALESCE(deleted_by, '') FROM messages WHERE id = ? AND chat_jid = ?",
+			messageID, chatJID,
+		).Scan(&content, &editedAt, &deletedAt, &deletedBy)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get message: %v", err), http.StatusInternalServerError)
+			return
+		}
+		versions, err := messageStore.GetMessageVersions(messageID, chatJID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get edit history: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		response := map[string]interface{}{
+			"message_id": messageID,
+			"chat_jid":   chatJID,
+			"content":    messageStore.open(content),
+			"versions":   versions,
+		}
+		if editedAt.Valid {
+			response["edited_at"] = editedAt.Time
+		}
+		if deletedAt.Valid {
+			response["deleted_at"] = deletedAt.Time
+			response["deleted_by"] = deletedBy
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const (
+	// Shorter messages ("ok", "thanks!") are never collapsed as duplicates
+	duplicateMinLength = 40
+
+	// Word-trigram similarity (0-1) from which two messages count as the same text
+	duplicateSimilarity = 0.85
+
+	// Repeats listed by sender in the annotation of a collapsed message
+	duplicateMaxListed = 5
+)
+
+// MessageFilter decides which stored messages of a group are passed on to summaries and the knowledge graph
+type MessageFilter struct {
+	includeSystem    bool
+	includeMembers   bool
+	includeAutomated bool
+	excludeSenders   []*regexp.Regexp
+	automatedIDs     map[string]bool
+}
+
+// ensureAutomatedMessagesTable creates the table of messages sent by the summary tools if it doesn't exist
+func ensureAutomatedMessagesTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS automated_messages (
+			id TEXT,
+			chat_jid TEXT,
+			source TEXT,
+			sent_at TIMESTAMP,
+			PRIMARY KEY (id, chat_jid)
+		);
+	`)
+	return err
+}
+
+// recordAutomatedMessage remembers a message sent by the tools so it isn't summarized again later
+func recordAutomatedMessage(db *sql.DB, id, chatJID, source string) error {
+	if err := ensureAutomatedMessagesTable(db); err != nil {
+		return err
+	}
+	_, err := db.Exec(
+		"INSERT OR REPLACE INTO automated_messages (id, chat_jid, source, sent_at) VALUES (?, ?, ?, ?)",
+		id, chatJID, source, time.Now(),
+	)
+	return err
+}
+
+// newMessageFilter builds the filter for a group from its config
+func newMessageFilter(db *sql.DB, groupJID string) (*MessageFilter, error) {
+	config, err := loadBridgeConfig()
+	if err != nil {
+		return nil, err
+	}
+	groupConfig := config.getGroupConfig(groupJID)
+
+	filter := &MessageFilter{
+		includeSystem:    boolSetting(groupConfig.IncludeSystemMessages, false),
+		includeMembers:   boolSetting(groupConfig.IncludeMemberEvents, false),
+		includeAutomated: boolSetting(groupConfig.IncludeOwnAutomated, false),
+		automatedIDs:     make(map[string]bool),
+	}
+
+	for _, pattern := range groupConfig.ExcludeSenders {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude_senders pattern %q: %v", pattern, err)
+		}
+		filter.excludeSenders = append(filter.excludeSenders, re)
+	}
+
+	if !filter.includeAutomated {
+		if err := ensureAutomatedMessagesTable(db); err != nil {
+			return nil, err
+		}
+		rows, err := db.Query("SELECT id FROM automated_messages WHERE chat_jid = ?", groupJID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load automated messages: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return nil, err
+			}
+			filter.automatedIDs[id] = true
+		}
+	}
+
+	return filter, nil
+}
+
+// Allows reports whether a message should be kept
+func (filter *MessageFilter) Allows(id, sender, senderName, content, messageType, origin string) bool {
+	switch {
+	case messageType == "":
+		// Regular message
+	case isMemberEventType(messageType):
+		if !filter.includeMembers {
+			return false
+		}
+	default:
+		if !filter.includeSystem {
+			return false
+		}
+	}
+
+	// Messages sent by the bridge or the tools, so the assistant never summarizes or memorizes its own output
+	if !filter.includeAutomated && (origin != "" || filter.automatedIDs[id] || hasBridgeMessagePrefix(content)) {
+		return false
+	}
+
+	for _, re := range filter.excludeSenders {
+		if re.MatchString(sender) || re.MatchString(senderName) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// collapseDuplicatesEnabled reports whether a group's repeated texts are collapsed before prompting (collapse_duplicates in config.json, default on)
+func collapseDuplicatesEnabled(groupJID string) bool {
+	config, err := loadBridgeConfig()
+	if err != nil {
+		return true
+	}
+	return boolSetting(config.getGroupConfig(groupJID).CollapseDuplicates, true)
+}
+
+// collapseDuplicatesForPrompt collapses a day's repeated texts when the group allows it
+func collapseDuplicatesForPrompt(messages []DailySummaryMessage, groupJID string, logger waLog.Logger) []DailySummaryMessage {
+	if !collapseDuplicatesEnabled(groupJID) {
+		return messages
+	}
+	collapsed, repeats := collapseDuplicateMessages(messages)
+	if repeats > 0 {
+		logger.Infof("Collapsed %d repeated messages (forwarded or re-pasted texts)", repeats)
+	}
+	return collapsed
+}
+
+// collapseDuplicateMessages keeps the first instance of texts posted several times (forwarded chains, re-pastes),
+// annotated with who repeated them and when, and returns how many repeats were dropped.
+// The repeats' IDs stay on the kept message, so episodes still trace back to every copy.
+func collapseDuplicateMessages(messages []DailySummaryMessage) ([]DailySummaryMessage, int) {
+	type original struct {
+		index    int
+		text     string
+		shingles map[string]bool
+		repeats  []DailySummaryMessage
+	}
+
+	var originals []*original
+	var kept []DailySummaryMessage
+	dropped := 0
+	for _, message := range messages {
+		text := duplicateText(message.Content)
+		if len(text) < duplicateMinLength {
+			kept = append(kept, message)
+			continue
+		}
+
+		shingles := wordShingles(text)
+		var match *original
+		for _, candidate := range originals {
+			if candidate.text == text || (similarLength(candidate.text, text) && shingleSimilarity(candidate.shingles, shingles) >= duplicateSimilarity) {
+				match = candidate
+				break
+			}
+		}
+		if match != nil {
+			match.repeats = append(match.repeats, message)
+			dropped++
+			continue
+		}
+
+		originals = append(originals, &original{index: len(kept), text: text, shingles: shingles})
+		kept = append(kept, message)
+	}
+
+	for _, candidate := range originals {
+		if len(candidate.repeats) == 0 {
+			continue
+		}
+		var listed []string
+		for i, repeat := range candidate.repeats {
+			if i == duplicateMaxListed {
+				listed = append(listed, fmt.Sprintf("and %d more", len(candidate.repeats)-i))
+				break
+			}
+			listed = append(listed, repeat.Sender+" "+repeat.Timestamp)
+		}
+		for _, repeat := range candidate.repeats {
+			if repeat.ID != "" {
+				kept[candidate.index].DuplicateIDs = append(kept[candidate.index].DuplicateIDs, repeat.ID)
+			}
+		}
+		kept[candidate.index].Content += fmt.Sprintf(" [posted %d more times: %s]", len(candidate.repeats), strings.Join(listed, ", "))
+	}
+	return kept, dropped
+}
+
+// duplicateText reduces a message to its lowercase words, so forwards that differ only in
+// formatting, punctuation or emoji compare equal
+func duplicateText(content string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// wordShingles returns the set of word trigrams of a text
+func wordShingles(text string) map[string]bool {
+	words := strings.Fields(text)
+	shingles := make(map[string]bool)
+	if len(words) < 3 {
+		shingles[text] = true
+		return shingles
+	}
+	for i := 0; i+3 <= len(words); i++ {
+		shingles[strings.Join(words[i:i+3], " ")] = true
+	}
+	return shingles
+}
+
+// similarLength reports whether two texts are close enough in length to possibly be the same text
+func similarLength(a, b string) bool {
+	shorter, longer := len(a), len(b)
+	if shorter > longer {
+		shorter, longer = longer, shorter
+	}
+	return float64(shorter) >= duplicateSimilarity*float64(longer)
+}
+
+// shingleSimilarity returns the Jaccard similarity of two shingle sets
+func shingleSimilarity(a, b map[string]bool) float64 {
+	shared := 0
+	for shingle := range a {
+		if b[shingle] {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// How often old messages are pruned when a retention is set
+const retentionInterval = 24 * time.Hour
+
+// getRetentionDays returns the days of messages a store keeps from an environment variable; 0 keeps them forever
+func getRetentionDays(name string) int {
+	if days, err := strconv.Atoi(os.Getenv(name)); err == nil && days > 0 {
+		return days
+	}
+	return 0
+}
+
+// openDirectStore opens the separate direct chat database, if configured, with the same schema as messages.db
+func (store *MessageStore) openDirectStore() error {
+	directCipher, err := loadDirectMessagesCipher()
+	if err != nil {
+		return fmt.Errorf("invalid DIRECT_MESSAGES_KEY: %v", err)
+	}
+	path := getDirectMessagesDBPath()
+	if path == "" {
+		if directCipher != nil {
+			return fmt.Errorf("DIRECT_MESSAGES_KEY requires DIRECT_MESSAGES_DB")
+		}
+		return nil
+	}
+
+	db, err := sql.Open(messagesDBDriver(), "file:"+path+"?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return fmt.Errorf("failed to open direct message database: %v", err)
+	}
+	if err := initMessageSchema(db); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to set up direct message database: %v", err)
+	}
+	store.direct, store.directCipher = db, directCipher
+	return nil
+}
+
+// dbFor returns the database a chat's messages are stored in
+func (store *MessageStore) dbFor(chatJID string) *sql.DB {
+	if store.direct != nil && isDirectChat(chatJID) {
+		return store.direct
+	}
+	return store.db
+}
+
+// seal encrypts message text of a chat for storage when its store is encrypted
+func (store *MessageStore) seal(chatJID, text string) (string, error) {
+	if text == "" || store.directCipher == nil || !isDirectChat(chatJID) {
+		return text, nil
+	}
+	nonce := make([]byte, store.directCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := store.directCipher.Seal(nonce, nonce, []byte(text), nil)
+	return encryptedTextPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts stored message text; text stored in the clear is returned as is
+func (store *MessageStore) open(text string) string {
+	return openDirectMessageText(store.directCipher, text)
+}
+
+// pruneMessages deletes the messages older than a cutoff, with their reactions, edits, receipts, polls, reply
+// links, media file records and raw protobufs.
+// where restricts the messages further (e.g. to groups when both kinds share a database), with its args.
+func pruneMessages(db *sql.DB, cutoff time.Time, where string, args ...interface{}) (int64, error) {
+	condition := "timestamp < ?"
+	if where != "" {
+		condition += " AND " + where
+	}
+	args = append([]interface{}{cutoff}, args...)
+	deleted, err := deleteMessages(db, condition, args...)
+	if err != nil {
+		return deleted, err
+	}
+	// Raw messages of kinds that aren't stored as messages (reactions, votes, edits...) expire the same way
+	_, err = db.Exec("DELETE FROM raw_messages WHERE "+condition, args...)
+	return deleted, err
+}
+
+// pruneExpiredMessages applies GROUP_MESSAGES_RETENTION_DAYS and DIRECT_MESSAGES_RETENTION_DAYS to their stores,
+// and the retention_days chat setting to the chats that have one instead
+func (store *MessageStore) pruneExpiredMessages(now time.Time, logger waLog.Logger) {
+	chatRetention := make(map[string]int)
+	if list, err := listChatSettings(store.db); err != nil {
+		logger.Warnf("Failed to read chat retention settings: %v", err)
+	} else {
+		for _, settings := range list {
+			if settings.RetentionDays > 0 {
+				chatRetention[settings.ChatJID] = settings.RetentionDays
+			}
+		}
+	}
+
+	prune := func(label string, db *sql.DB, days int, where string, args ...interface{}) {
+		if days == 0 {
+			return
+		}
+		deleted, err := pruneMessages(db, now.AddDate(0, 0, -days), where, args...)
+		if err != nil {
+			logger.Warnf("Failed to prune %s: %v", label, err)
+		} else if deleted > 0 {
+			logger.Infof("Pruned %d %s older than %d days", deleted, label, days)
+		}
+	}
+	// withoutChatRetention leaves the chats with their own retention out of a store's
+	withoutChatRetention := func(where string) (string, []interface{}) {
+		if len(chatRetention) == 0 {
+			return where, nil
+		}
+		var args []interface{}
+		for chatJID := range chatRetention {
+			args = append(args, chatJID)
+		}
+		excluded := "chat_jid NOT IN (?" + strings.Repeat(", ?", len(args)-1) + ")"
+		if where == "" {
+			return excluded, args
+		}
+		return where + " AND " + excluded, args
+	}
+
+	where, args := withoutChatRetention("chat_jid LIKE '%@g.us'")
+	prune("group messages", store.db, getRetentionDays("GROUP_MESSAGES_RETENTION_DAYS"), where, args...)
+	if store.direct != nil {
+		where, args = withoutChatRetention("")
+		prune("direct messages", store.direct, getRetentionDays("DIRECT_MESSAGES_RETENTION_DAYS"), where, args...)
+	} else {
+		where, args = withoutChatRetention("chat_jid NOT LIKE '%@g.us'")
+		prune("direct messages", store.db, getRetentionDays("DIRECT_MESSAGES_RETENTION_DAYS"), where, args...)
+	}
+	for chatJID, days := range chatRetention {
+		prune("messages of "+chatJID, store.dbFor(chatJID), days, "chat_jid = ?", chatJID)
+	}
+}
+
+// startRetention prunes expired messages now and once a day. It always runs, since chats can be given a
+// retention while the bridge is up.
+func startRetention(store *MessageStore, logger waLog.Logger) {
+	go func() {
+		for {
+			store.pruneExpiredMessages(time.Now(), logger)
+			time.Sleep(retentionInterval)
+		}
+	}()
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema migrations, named <version>_<name>.sql (e.g. 0002_reaction_index.sql). A
+// migration runs once per database, in one transaction; never change one that has been released, add the next.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// ensureSchemaVersionTable creates the table recording the applied migrations
+func ensureSchemaVersionTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			name TEXT,
+			applied_at TIMESTAMP
+		);
+	`)
+	return err
+}
+
+// loadMigrations reads the embedded migrations, ordered by version
+func loadMigrations() ([]Migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+	for _, name := range names {
+		base := strings.TrimSuffix(path.Base(name), ".sql")
+		number, label, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s doesn't start with a version number", name)
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, name)
+		}
+		seen[version] = name
+
+		content, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: label, SQL: string(content)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// schemaVersion returns the latest migration applied to a database (0 for none)
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// applyMigration runs one migration and records it. Recording it first takes the write lock, so when another
+// process is migrating the same database this waits for it and then skips the migration it already applied.
+func applyMigration(db *sql.DB, migration Migration) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT OR IGNORE INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)",
+		migration.Version, migration.Name, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if recorded, err := result.RowsAffected(); err != nil || recorded == 0 {
+		return false, err
+	}
+	if _, err := tx.Exec(migration.SQL); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// migrateSchema applies the migrations a database hasn't had yet, in order. It refuses a database migrated by
+// a newer bridge, whose schema this one may not handle.
+func migrateSchema(db *sql.DB) error {
+	if err := ensureSchemaVersionTable(db); err != nil {
+		return fmt.Errorf("failed to create schema_version table: %v", err)
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %v", err)
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if latest := migrations[len(migrations)-1].Version; current > latest {
+		return fmt.Errorf("database schema version %d is newer than this bridge supports (%d); update the bridge", current, latest)
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= current {
+			continue
+		}
+		applied, err := applyMigration(db, migration)
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s failed: %v", migration.Version, migration.Name, err)
+		}
+		if applied {
+			fmt.Printf("Applied schema migration %04d_%s\n", migration.Version, migration.Name)
+		}
+	}
+	return nil
+}
+
+// indexedQuery is a frequent query and the index it should use
+type indexedQuery struct {
+	Name  string
+	Query string
+	Index string
+}
+
+// indexedQueries are checked on startup, so a missing index shows up in the log rather than as slow summaries
+var indexedQueries = []indexedQuery{
+	{
+		Name:  "chat messages by time",
+		Query: "SELECT id FROM messages WHERE chat_jid = ? AND timestamp >= ? AND timestamp <= ? ORDER BY timestamp",
+		Index: "idx_messages_chat_timestamp",
+	},
+	{
+		Name:  "sender messages by time",
+		Query: "SELECT MAX(timestamp) FROM messages WHERE sender = ? AND timestamp >= ?",
+		Index: "idx_messages_sender_timestamp",
+	},
+}
+
+// queryPlan returns SQLite's plan for a query, one step per line
+func queryPlan(db *sql.DB, query string, args ...interface{}) (string, error) {
+	rows, err := db.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var steps []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			return "", err
+		}
+		steps = append(steps, detail)
+	}
+	return strings.Join(steps, "\n"), rows.Err()
+}
+
+// checkQueryPlans warns about the frequent queries SQLite would run without their index
+func checkQueryPlans(db *sql.DB) {
+	for _, check := range indexedQueries {
+		args := make([]interface{}, strings.Count(check.Query, "?"))
+		for i := range args {
+			args[i] = ""
+		}
+		plan, err := queryPlan(db, check.Query, args...)
+		if err != nil {
+			fmt.Printf("Warning: failed to check the query plan of %s: %v\n", check.Name, err)
+			continue
+		}
+		if !strings.Contains(plan, check.Index) {
+			fmt.Printf("Warning: %s doesn't use %s and may be slow on large chats (plan: %s)\n",
+				check.Name, check.Index, strings.ReplaceAll(plan, "\n", "; "))
+		}
+	}
+}
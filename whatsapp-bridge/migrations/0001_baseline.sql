@@ -0,0 +1,2 @@
+-- Version 1 is the schema initMessageSchema builds: the tables and columns added before versioned migrations.
+-- Later changes to the message databases go in the next numbered file.
@@ -0,0 +1,4 @@
+-- A chat's or a sender's messages over a period (daily summaries, timelines, last interactions) without scanning
+-- the whole table
+CREATE INDEX IF NOT EXISTS idx_messages_chat_timestamp ON messages (chat_jid, timestamp);
+CREATE INDEX IF NOT EXISTS idx_messages_sender_timestamp ON messages (sender, timestamp);
@@ -0,0 +1,18 @@
+-- Bumped on every change to the contacts table, so the tools caching contact names know when to read them again
+CREATE TABLE IF NOT EXISTS contacts_version (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	version INTEGER NOT NULL
+);
+INSERT OR IGNORE INTO contacts_version (id, version) VALUES (1, 0);
+
+CREATE TRIGGER IF NOT EXISTS contacts_version_insert AFTER INSERT ON contacts BEGIN
+	UPDATE contacts_version SET version = version + 1 WHERE id = 1;
+END;
+
+CREATE TRIGGER IF NOT EXISTS contacts_version_update AFTER UPDATE ON contacts BEGIN
+	UPDATE contacts_version SET version = version + 1 WHERE id = 1;
+END;
+
+CREATE TRIGGER IF NOT EXISTS contacts_version_delete AFTER DELETE ON contacts BEGIN
+	UPDATE contacts_version SET version = version + 1 WHERE id = 1;
+END;
@@ -0,0 +1,91 @@
+-- Daily rollups of the messages per chat and sender, kept up to date by triggers, so activity statistics don't
+-- scan the messages. System messages (group events) aren't counted. Rollups stay when old messages are pruned.
+
+-- Seconds since the previous message in the chat, when it came from someone else within 12 hours: a reply time
+ALTER TABLE messages ADD COLUMN response_seconds INTEGER;
+
+CREATE TABLE IF NOT EXISTS message_stats_daily (
+	chat_jid TEXT NOT NULL,
+	sender TEXT NOT NULL,
+	day TEXT NOT NULL, -- YYYY-MM-DD in the bridge's timezone
+	messages INTEGER NOT NULL DEFAULT 0,
+	media INTEGER NOT NULL DEFAULT 0,
+	responses INTEGER NOT NULL DEFAULT 0,
+	response_seconds INTEGER NOT NULL DEFAULT 0, -- sum, divided by responses for the average
+	PRIMARY KEY (chat_jid, sender, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_stats_daily_day ON message_stats_daily (day);
+
+UPDATE messages SET response_seconds = gaps.seconds
+FROM (
+	SELECT message_rowid, CAST(round((julianday(timestamp) - julianday(previous_time)) * 86400) AS INTEGER) AS seconds
+	FROM (
+		SELECT rowid AS message_rowid, sender, timestamp,
+			LAG(sender) OVER chat AS previous_sender, LAG(timestamp) OVER chat AS previous_time
+		FROM messages
+		WHERE COALESCE(message_type, '') = ''
+		WINDOW chat AS (PARTITION BY chat_jid ORDER BY timestamp)
+	)
+	WHERE previous_sender != sender AND julianday(timestamp) - julianday(previous_time) <= 0.5
+) AS gaps
+WHERE messages.rowid = gaps.message_rowid;
+
+INSERT INTO message_stats_daily (chat_jid, sender, day, messages, media, responses, response_seconds)
+SELECT chat_jid, COALESCE(sender, ''), substr(timestamp, 1, 10), COUNT(*), SUM(COALESCE(media_type, '') != ''),
+	COUNT(response_seconds), COALESCE(SUM(response_seconds), 0)
+FROM messages
+WHERE COALESCE(message_type, '') = ''
+GROUP BY chat_jid, COALESCE(sender, ''), substr(timestamp, 1, 10);
+
+CREATE TRIGGER IF NOT EXISTS message_stats_insert AFTER INSERT ON messages
+WHEN COALESCE(new.message_type, '') = ''
+BEGIN
+	UPDATE messages SET response_seconds = (
+		SELECT CAST(round((julianday(new.timestamp) - julianday(previous.timestamp)) * 86400) AS INTEGER)
+		FROM (
+			SELECT sender, timestamp FROM messages
+			WHERE chat_jid = new.chat_jid AND timestamp < new.timestamp AND COALESCE(message_type, '') = ''
+			ORDER BY timestamp DESC
+			LIMIT 1
+		) AS previous
+		WHERE previous.sender != new.sender AND julianday(new.timestamp) - julianday(previous.timestamp) <= 0.5
+	)
+	WHERE rowid = new.rowid;
+
+	INSERT INTO message_stats_daily (chat_jid, sender, day, messages, media, responses, response_seconds)
+	SELECT new.chat_jid, COALESCE(new.sender, ''), substr(new.timestamp, 1, 10), 1, COALESCE(new.media_type, '') != '',
+		response_seconds IS NOT NULL, COALESCE(response_seconds, 0)
+	FROM messages
+	WHERE rowid = new.rowid
+	ON CONFLICT (chat_jid, sender, day) DO UPDATE SET
+		messages = messages + excluded.messages,
+		media = media + excluded.media,
+		responses = responses + excluded.responses,
+		response_seconds = response_seconds + excluded.response_seconds;
+END;
+
+-- Messages stored again keep their counts; only a changed sender, time, media or type moves them
+CREATE TRIGGER IF NOT EXISTS message_stats_update AFTER UPDATE OF sender, timestamp, media_type, message_type ON messages
+WHEN old.sender IS NOT new.sender OR old.timestamp IS NOT new.timestamp
+	OR COALESCE(old.media_type, '') != COALESCE(new.media_type, '')
+	OR COALESCE(old.message_type, '') != COALESCE(new.message_type, '')
+BEGIN
+	UPDATE message_stats_daily SET
+		messages = messages - 1,
+		media = media - (COALESCE(old.media_type, '') != ''),
+		responses = responses - (old.response_seconds IS NOT NULL),
+		response_seconds = response_seconds - COALESCE(old.response_seconds, 0)
+	WHERE COALESCE(old.message_type, '') = ''
+		AND chat_jid = old.chat_jid AND sender = COALESCE(old.sender, '') AND day = substr(old.timestamp, 1, 10);
+
+	INSERT INTO message_stats_daily (chat_jid, sender, day, messages, media, responses, response_seconds)
+	SELECT new.chat_jid, COALESCE(new.sender, ''), substr(new.timestamp, 1, 10), 1, COALESCE(new.media_type, '') != '',
+		new.response_seconds IS NOT NULL, COALESCE(new.response_seconds, 0)
+	WHERE COALESCE(new.message_type, '') = ''
+	ON CONFLICT (chat_jid, sender, day) DO UPDATE SET
+		messages = messages + excluded.messages,
+		media = media + excluded.media,
+		responses = responses + excluded.responses,
+		response_seconds = response_seconds + excluded.response_seconds;
+END;
@@ -0,0 +1,14 @@
+-- Every message as WhatsApp sent it, including the kinds that aren't parsed into messages, so new parsers can
+-- be run over the history. proto_type is the protobuf message name to decode proto with.
+CREATE TABLE IF NOT EXISTS raw_messages (
+	message_id TEXT NOT NULL,
+	chat_jid TEXT NOT NULL,
+	sender TEXT,
+	timestamp TIMESTAMP,
+	proto_type TEXT NOT NULL,
+	proto BLOB NOT NULL, -- sealed like the message text in direct chats with DIRECT_MESSAGES_KEY
+	stored_at TIMESTAMP,
+	PRIMARY KEY (message_id, chat_jid)
+);
+
+CREATE INDEX IF NOT EXISTS idx_raw_messages_chat_timestamp ON raw_messages (chat_jid, timestamp);
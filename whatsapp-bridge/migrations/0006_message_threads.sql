@@ -0,0 +1,10 @@
+-- Which message each reply quotes, to follow reply chains in both directions
+CREATE TABLE IF NOT EXISTS message_threads (
+	message_id TEXT NOT NULL,
+	chat_jid TEXT NOT NULL,
+	reply_to_id TEXT NOT NULL,
+	reply_to_sender TEXT, -- JID of the quoted message's sender, as the reply gave it
+	PRIMARY KEY (message_id, chat_jid)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_threads_reply_to ON message_threads (chat_jid, reply_to_id);
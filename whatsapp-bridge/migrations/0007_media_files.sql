@@ -0,0 +1,27 @@
+-- What is known about each media message's file, and whether it was downloaded. file_sha256 (hex) finds the
+-- same file forwarded again, so it is downloaded once; failed downloads are retried from here.
+CREATE TABLE IF NOT EXISTS media_files (
+	message_id TEXT NOT NULL,
+	chat_jid TEXT NOT NULL,
+	media_type TEXT NOT NULL,
+	mime_type TEXT,
+	file_length INTEGER,
+	file_sha256 TEXT,
+	file_enc_sha256 TEXT,
+	direct_path TEXT,
+	download_state TEXT NOT NULL DEFAULT 'pending', -- pending, downloaded, failed or expired (re-upload asked for)
+	local_path TEXT,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	downloaded_at TIMESTAMP,
+	PRIMARY KEY (message_id, chat_jid)
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_files_sha256 ON media_files (file_sha256);
+CREATE INDEX IF NOT EXISTS idx_media_files_state ON media_files (download_state);
+
+-- Media stored before this table existed; mime types and direct paths weren't kept
+INSERT OR IGNORE INTO media_files (message_id, chat_jid, media_type, file_length, file_sha256, file_enc_sha256)
+SELECT id, chat_jid, media_type, file_length, NULLIF(lower(hex(file_sha256)), ''), NULLIF(lower(hex(file_enc_sha256)), '')
+FROM messages
+WHERE COALESCE(media_type, '') != '';
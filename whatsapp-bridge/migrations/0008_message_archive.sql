@@ -0,0 +1,16 @@
+-- Manifest of the Parquet files old messages were archived to (see archive.go), so search and exports can read
+-- them after they left the messages table
+CREATE TABLE IF NOT EXISTS message_archive (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_jid TEXT NOT NULL,
+	month TEXT NOT NULL, -- YYYY-MM
+	path TEXT NOT NULL, -- local file, relative to the bridge directory
+	object_url TEXT, -- s3:// URL it was uploaded to, if it was
+	first_timestamp TIMESTAMP NOT NULL,
+	last_timestamp TIMESTAMP NOT NULL,
+	messages INTEGER NOT NULL,
+	sha256 TEXT NOT NULL, -- of the file, checked when it is read back
+	archived_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_archive_chat ON message_archive (chat_jid, last_timestamp);
@@ -0,0 +1,12 @@
+-- Per-chat settings managed with the settings command and /api/chat-settings (see chat-settings.go). A NULL or
+-- empty column leaves the setting to config.json and the environment.
+CREATE TABLE IF NOT EXISTS chat_settings (
+	chat_jid TEXT PRIMARY KEY,
+	summary_enabled BOOLEAN, -- include the chat in the daily summary, or leave it out
+	prompt_override TEXT, -- replaces prompts/daily-summary.md for the chat
+	language TEXT, -- language the chat's summaries are written in
+	retention_days INTEGER, -- the bridge deletes the chat's messages older than this
+	webhook_targets TEXT, -- comma-separated URLs that also receive the chat's messages
+	muted BOOLEAN NOT NULL DEFAULT 0, -- no summaries, digests, webhooks or recaps for the chat
+	updated_at TIMESTAMP
+);
@@ -0,0 +1,9 @@
+-- What the chat list shows beyond the chats table, which StoreChat replaces wholesale (see chat-state.go).
+-- Chats without a row have nothing unread.
+CREATE TABLE IF NOT EXISTS chat_state (
+	chat_jid TEXT PRIMARY KEY,
+	unread_count INTEGER NOT NULL DEFAULT 0, -- messages from others since the chat was last read
+	marked_unread BOOLEAN NOT NULL DEFAULT 0, -- marked as unread on the phone
+	participant_count INTEGER, -- members of a group, as of the last refresh of the joined groups
+	updated_at TIMESTAMP
+);
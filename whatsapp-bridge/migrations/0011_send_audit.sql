@@ -0,0 +1,20 @@
+-- Every message the bridge and the scheduled tools sent on their own, and what made them send it (see
+-- send-audit.go). The text stays in the messages table, possibly encrypted; only its hash is kept here.
+CREATE TABLE IF NOT EXISTS send_audit (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at TIMESTAMP NOT NULL,
+	triggered_by TEXT NOT NULL DEFAULT '', -- e.g. mcp:send_message, daily-summary, self-chat, api (token ci)
+	origin TEXT NOT NULL DEFAULT '', -- as in messages.origin
+	kind TEXT NOT NULL DEFAULT 'message', -- message, poll, reaction or sticker
+	recipient TEXT NOT NULL,
+	outbox_id INTEGER, -- for messages sent through the outbox, whose outcome comes later
+	message_id TEXT NOT NULL DEFAULT '',
+	prompt_hash TEXT NOT NULL DEFAULT '', -- SHA-256 of the LLM prompt that wrote the message
+	content_hash TEXT NOT NULL DEFAULT '', -- SHA-256 of the text as requested
+	result TEXT NOT NULL, -- queued, sent, failed or cancelled
+	error TEXT NOT NULL DEFAULT '',
+	sent_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_send_audit_created ON send_audit (created_at);
+CREATE INDEX IF NOT EXISTS idx_send_audit_outbox ON send_audit (outbox_id);
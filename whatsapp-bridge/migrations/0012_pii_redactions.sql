@@ -0,0 +1,8 @@
+-- Values redacted from LLM prompts and the placeholders that replaced them (see redaction.go). A value keeps its
+-- placeholder across runs, so knowledge graph episodes written with placeholders stay consistent.
+CREATE TABLE IF NOT EXISTS pii_redactions (
+	placeholder TEXT PRIMARY KEY, -- e.g. [PHONE_3]
+	kind TEXT NOT NULL, -- PHONE, EMAIL, CARD, NAME or PII (a configured pattern)
+	value TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMP NOT NULL
+);
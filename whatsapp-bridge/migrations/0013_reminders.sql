@@ -0,0 +1,15 @@
+-- Reminders asked for with /remind (see reminders.go). They are sent as scheduled outbox messages quoting the
+-- /remind message; the outbox entry tells whether they are still due.
+CREATE TABLE IF NOT EXISTS reminders (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	chat_jid TEXT NOT NULL,
+	message_id TEXT NOT NULL DEFAULT '', -- the /remind message
+	created_by TEXT NOT NULL DEFAULT '', -- its sender
+	text TEXT NOT NULL,
+	remind_at TIMESTAMP NOT NULL,
+	outbox_id INTEGER, -- the scheduled message
+	created_at TIMESTAMP NOT NULL,
+	cancelled_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_reminders_chat ON reminders (chat_jid, remind_at);
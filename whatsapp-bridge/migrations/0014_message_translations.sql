@@ -0,0 +1,12 @@
+-- Translations of incoming messages of the chats with a translation rule (see translation.go), forwarded to my
+-- self-chat or listed under the chat's daily summary
+CREATE TABLE IF NOT EXISTS message_translations (
+	message_id TEXT NOT NULL,
+	chat_jid TEXT NOT NULL,
+	language TEXT NOT NULL, -- translated into
+	source_language TEXT NOT NULL DEFAULT '',
+	translation TEXT NOT NULL,
+	provider TEXT NOT NULL DEFAULT '', -- "llm" or "deepl"
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (message_id, chat_jid, language)
+);
@@ -0,0 +1,17 @@
+-- Incoming messages from unknown numbers flagged as likely scams or phishing (see scam-filter.go). Scams are
+-- quarantined from the summaries; every flag is listed in the weekly digest. Only the verdict is kept here, the
+-- message itself stays where it is stored.
+CREATE TABLE IF NOT EXISTS message_flags (
+	message_id TEXT NOT NULL,
+	chat_jid TEXT NOT NULL,
+	sender TEXT NOT NULL,
+	verdict TEXT NOT NULL, -- "scam" or "suspicious"
+	score INTEGER NOT NULL DEFAULT 0, -- of the heuristics, 0-100
+	reasons TEXT NOT NULL DEFAULT '', -- comma-separated
+	classifier TEXT NOT NULL DEFAULT '', -- "rules" or "llm"
+	flagged_at TIMESTAMP NOT NULL,
+	released_at TIMESTAMP, -- marked as legitimate, back in the summaries
+	PRIMARY KEY (message_id, chat_jid)
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_flags_flagged_at ON message_flags (flagged_at);
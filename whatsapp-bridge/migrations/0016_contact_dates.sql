@@ -0,0 +1,17 @@
+-- Birthdays, anniversaries and other yearly dates of contacts (see contact-dates.go), imported from a CSV or set
+-- with the dates tool or the /dates command. The dates tool's remind command sends a greeting to forward.
+CREATE TABLE IF NOT EXISTS contact_dates (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	contact_jid TEXT NOT NULL,
+	name TEXT NOT NULL DEFAULT '', -- as given, when the contact has no name in the contacts table
+	label TEXT NOT NULL DEFAULT 'birthday', -- "birthday", "anniversary" or any other word
+	month INTEGER NOT NULL,
+	day INTEGER NOT NULL,
+	year INTEGER NOT NULL DEFAULT 0, -- 0 when unknown
+	note TEXT NOT NULL DEFAULT '',
+	last_reminded_on TEXT NOT NULL DEFAULT '', -- "YYYY-MM-DD" of the last occurrence reminded of
+	created_at TIMESTAMP NOT NULL,
+	UNIQUE (contact_jid, label)
+);
+
+CREATE INDEX IF NOT EXISTS idx_contact_dates_day ON contact_dates (month, day);
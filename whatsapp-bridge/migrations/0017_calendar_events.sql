@@ -0,0 +1,20 @@
+-- Meetings and events agreed on in the summarized chats (see calendar-events.go), extracted with the daily summary
+-- and written as .ics files or pushed to a CalDAV calendar
+CREATE TABLE IF NOT EXISTS calendar_events (
+	uid TEXT PRIMARY KEY,
+	chat_jid TEXT NOT NULL,
+	dedupe_key TEXT NOT NULL, -- the start, and the title of all-day events, so a meeting mentioned again isn't added twice
+	title TEXT NOT NULL,
+	starts_at TIMESTAMP NOT NULL,
+	ends_at TIMESTAMP NOT NULL,
+	all_day BOOLEAN NOT NULL DEFAULT 0,
+	location TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	ics_path TEXT NOT NULL DEFAULT '',
+	pushed_at TIMESTAMP, -- to the CalDAV calendar
+	push_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	UNIQUE (chat_jid, dedupe_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_calendar_events_starts_at ON calendar_events (starts_at);
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Neo4jSink writes episodes as plain nodes and relationships to Neo4j through its HTTP transaction API
+type Neo4jSink struct {
+	url      string
+	database string
+	user     string
+	password string
+}
+
+// Neo4jStatement is a Cypher statement with parameters
+type Neo4jStatement struct {
+	Statement  string                 `json:"statement"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// Neo4jResponse represents the response of the Neo4j transaction commit endpoint
+type Neo4jResponse struct {
+	Errors []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// NewNeo4jSink creates a Neo4j sink from NEO4J_URL, NEO4J_DATABASE, NEO4J_USER and NEO4J_PASSWORD
+func NewNeo4jSink() (*Neo4jSink, error) {
+	sink := &Neo4jSink{
+		url:      strings.TrimRight(os.Getenv("NEO4J_URL"), "/"),
+		database: os.Getenv("NEO4J_DATABASE"),
+		user:     os.Getenv("NEO4J_USER"),
+		password: os.Getenv("NEO4J_PASSWORD"),
+	}
+	if sink.url == "" {
+		sink.url = "http://host.docker.internal:7474"
+	}
+	if sink.database == "" {
+		sink.database = "neo4j"
+	}
+	if sink.user == "" {
+		sink.user = "neo4j"
+	}
+	if sink.password == "" {
+		return nil, fmt.Errorf("NEO4J_PASSWORD is required for the neo4j knowledge sink")
+	}
+	return sink, nil
+}
+
+// Name returns the sink name
+func (sink *Neo4jSink) Name() string {
+	return knowledgeSinkNeo4j
+}
+
+// AddEpisode creates the episode node linked to its group, topic and participants
+func (sink *Neo4jSink) AddEpisode(ctx context.Context, episode KnowledgeEpisode) error {
+	// Unique participant names
+	seen := make(map[string]bool)
+	var participants []string
+	for _, message := range episode.Messages {
+		if !seen[message.Sender] {
+			seen[message.Sender] = true
+			participants = append(participants, message.Sender)
+		}
+	}
+
+	return sink.run(ctx, []Neo4jStatement{{
+		Statement: `
+			MERGE (g:WhatsAppGroup {jid: $group_jid})
+			SET g.name = $group_name, g.group_id = $group_id
+			MERGE (e:Episode {uuid: $uuid})
+			SET e.name = $name, e.topic = $topic, e.date = $date, e.body = $body,
+				e.source_description = $source_description, e.group_id = $group_id, e.created_at = datetime()
+			MERGE (e)-[:IN_GROUP]->(g)
+			MERGE (t:Topic {name: $topic, group_id: $group_id})
+			MERGE (e)-[:ABOUT]->(t)
+			WITH e
+			UNWIND $participants AS participant
+			MERGE (p:Person {name: participant})
+			MERGE (p)-[:PARTICIPATED_IN]->(e)
+		`,
+		Parameters: map[string]interface{}{
+			"uuid":               episode.UUID,
+			"name":               episode.Name,
+			"topic":              episode.Topic,
+			"date":               episode.Date,
+			"body":               episode.Body,
+			"source_description": episode.SourceDescription,
+			"group_jid":          episode.GroupJID,
+			"group_name":         episode.GroupName,
+			"group_id":           episode.Namespace,
+			"participants":       participants,
+		},
+	}})
+}
+
+// DeleteEpisode removes the episode node and its relationships
+func (sink *Neo4jSink) DeleteEpisode(uuid string) error {
+	return sink.run(context.Background(), []Neo4jStatement{{
+		Statement:  "MATCH (e:Episode {uuid: $uuid}) DETACH DELETE e",
+		Parameters: map[string]interface{}{"uuid": uuid},
+	}})
+}
+
+// run executes statements in a single auto-committed transaction
+func (sink *Neo4jSink) run(ctx context.Context, statements []Neo4jStatement) error {
+	jsonData, err := json.Marshal(map[string]interface{}{"statements": statements})
+	if err != nil {
+		return fmt.Errorf("error marshaling request: %v", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/db/%s/tx/commit", sink.url, sink.database)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(sink.user, sink.password)
+
+	client := &http.Client{
+		Timeout: 60 * time.Second,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Neo4j returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var neo4jResp Neo4jResponse
+	if err := json.Unmarshal(body, &neo4jResp); err != nil {
+		return fmt.Errorf("error parsing response: %v", err)
+	}
+	if len(neo4jResp.Errors) > 0 {
+		return fmt.Errorf("Neo4j error %s: %s", neo4jResp.Errors[0].Code, neo4jResp.Errors[0].Message)
+	}
+
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ObjectStore is an S3-compatible bucket (AWS S3, MinIO, Backblaze B2, Cloudflare R2...) the archive is uploaded
+// to. Requests are signed with AWS Signature Version 4 and use path-style URLs, which every such service accepts.
+type ObjectStore struct {
+	Endpoint  string // e.g. https://s3.eu-west-1.amazonaws.com or http://minio:9000
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Prefix    string // prepended to every key, e.g. "whatsapp/"
+	client    *http.Client
+}
+
+// objectStoreFromEnv returns the bucket configured with ARCHIVE_S3_*, or nil when none is
+func objectStoreFromEnv() *ObjectStore {
+	bucket := os.Getenv("ARCHIVE_S3_BUCKET")
+	if bucket == "" {
+		return nil
+	}
+	store := &ObjectStore{
+		Endpoint:  strings.TrimSuffix(os.Getenv("ARCHIVE_S3_ENDPOINT"), "/"),
+		Bucket:    bucket,
+		Region:    os.Getenv("ARCHIVE_S3_REGION"),
+		AccessKey: os.Getenv("ARCHIVE_S3_ACCESS_KEY"),
+		SecretKey: os.Getenv("ARCHIVE_S3_SECRET_KEY"),
+		Prefix:    os.Getenv("ARCHIVE_S3_PREFIX"),
+		client:    &http.Client{Timeout: 10 * time.Minute},
+	}
+	if store.Region == "" {
+		store.Region = "us-east-1"
+	}
+	if store.Endpoint == "" {
+		store.Endpoint = "https://s3." + store.Region + ".amazonaws.com"
+	}
+	return store
+}
+
+// URL returns the s3:// URL of a key, as recorded in the archive manifest
+func (store *ObjectStore) URL(key string) string {
+	return "s3://" + store.Bucket + "/" + store.Prefix + key
+}
+
+// Put uploads an object and returns its s3:// URL
+func (store *ObjectStore) Put(key string, data []byte) (string, error) {
+	resp, err := store.do(http.MethodPut, store.Prefix+key, data)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("upload of %s failed: HTTP %d: %s", key, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return store.URL(key), nil
+}
+
+// Get downloads the object at an s3:// URL of this bucket
+func (store *ObjectStore) Get(url string) ([]byte, error) {
+	key, err := store.objectKey(url)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := store.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("download of %s failed: HTTP %d: %s", url, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Replace overwrites the object at an s3:// URL of this bucket
+func (store *ObjectStore) Replace(url string, data []byte) error {
+	key, err := store.objectKey(url)
+	if err != nil {
+		return err
+	}
+	resp, err := store.do(http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("upload of %s failed: HTTP %d: %s", url, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Delete removes the object at an s3:// URL of this bucket; a missing object is not an error
+func (store *ObjectStore) Delete(url string) error {
+	key, err := store.objectKey(url)
+	if err != nil {
+		return err
+	}
+	resp, err := store.do(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("deletion of %s failed: HTTP %d: %s", url, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// objectKey returns the key of an s3:// URL of this bucket
+func (store *ObjectStore) objectKey(url string) (string, error) {
+	key, ok := strings.CutPrefix(url, "s3://"+store.Bucket+"/")
+	if !ok {
+		return "", fmt.Errorf("%s is not in bucket %s", url, store.Bucket)
+	}
+	return key, nil
+}
+
+// do sends a signed request for a key of the bucket
+func (store *ObjectStore) do(method, key string, body []byte) (*http.Response, error) {
+	path := "/" + s3Escape(store.Bucket) + "/" + s3Escape(key)
+	req, err := http.NewRequest(method, store.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	store.sign(req, path, body, time.Now().UTC())
+	return store.client.Do(req)
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to a request without a query string
+func (store *ObjectStore) sign(req *http.Request, path string, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	req.Header.Set("x-amz-date", amzDate)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		"",
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + store.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+store.SecretKey), day)
+	for _, part := range []string{store.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		store.AccessKey, scope, signedHeaders, signature))
+}
+
+// s3Escape percent-encodes a key as Signature Version 4 expects: everything but unreserved characters and "/"
+func s3Escape(key string) string {
+	var out strings.Builder
+	for _, b := range []byte(key) {
+		if b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || strings.IndexByte("-_.~/", b) >= 0 {
+			out.WriteByte(b)
+		} else {
+			fmt.Fprintf(&out, "%%%02X", b)
+		}
+	}
+	return out.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
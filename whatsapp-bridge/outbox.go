@@ -0,0 +1,635 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Messages sent through /api/send go through a durable outbox in messages.db, so a send that fails while the
+// connection is down is retried instead of lost. Each recipient's messages are delivered in the order they were queued.
+const (
+	outboxPollInterval     = 5 * time.Second
+	outboxFirstRetryDelay  = 5 * time.Second
+	outboxMaxRetryDelay    = 10 * time.Minute
+	outboxDefaultAttempts  = 10
+	outboxRetention        = 7 * 24 * time.Hour // sent and failed entries are kept this long for /api/outbox
+	outboxDefaultListLimit = 50
+	outboxMaxScheduleAhead = 366 * 24 * time.Hour // how far ahead a message can be scheduled
+)
+
+// Outbox entry states
+const (
+	outboxPending   = "pending"
+	outboxSent      = "sent"
+	outboxFailed    = "failed"
+	outboxScheduled = "scheduled" // waits for its next_attempt_at, then becomes pending
+)
+
+// OutboxEntry is a message queued for sending
+type OutboxEntry struct {
+	ID            int64      `json:"id"`
+	Recipient     string     `json:"recipient"`
+	Message       string     `json:"message,omitempty"` // cleared once sent
+	MediaPath     string     `json:"media_path,omitempty"`
+	Origin        string     `json:"origin,omitempty"`
+	ReplyTo       string     `json:"reply_to,omitempty"`
+	Mentions      []string   `json:"mentions,omitempty"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	MessageID     string     `json:"message_id,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"` // for scheduled messages, when they're sent
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
+
+// Outbox delivers queued messages when the connection is up, retrying failed sends with growing delays
+type Outbox struct {
+	client *whatsmeow.Client
+	store  *MessageStore
+	logger waLog.Logger
+
+	mu   sync.Mutex // held while sending, so a recipient's messages can't overtake each other
+	wake chan struct{}
+}
+
+// messageOutbox is the bridge's outbox, set once the client exists
+var messageOutbox *Outbox
+
+// ensureOutboxTable creates the outbox table
+func ensureOutboxTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			recipient TEXT NOT NULL,
+			message TEXT DEFAULT '',
+			media_path TEXT DEFAULT '',
+			origin TEXT DEFAULT '',
+			reply_to TEXT DEFAULT '',
+			mentions TEXT DEFAULT '',
+			status TEXT DEFAULT 'pending',
+			attempts INTEGER DEFAULT 0,
+			last_error TEXT DEFAULT '',
+			message_id TEXT DEFAULT '',
+			created_at TIMESTAMP,
+			next_attempt_at TIMESTAMP,
+			sent_at TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_outbox_status ON outbox (status, id);
+	`)
+	return err
+}
+
+// getOutboxMaxAttempts returns how many times a queued message is tried before it is marked failed
+// (OUTBOX_MAX_ATTEMPTS, default 10); attempts while disconnected don't count
+func getOutboxMaxAttempts() int {
+	attempts, err := strconv.Atoi(os.Getenv("OUTBOX_MAX_ATTEMPTS"))
+	if err != nil || attempts < 1 {
+		return outboxDefaultAttempts
+	}
+	return attempts
+}
+
+// outboxRetryDelay returns how long to wait before trying a message again after its nth failed attempt
+func outboxRetryDelay(attempts int) time.Duration {
+	if attempts > 20 {
+		return outboxMaxRetryDelay
+	}
+	return min(outboxFirstRetryDelay<<max(attempts-1, 0), outboxMaxRetryDelay)
+}
+
+// NewOutbox creates the outbox; Start delivers what an earlier run left queued
+func NewOutbox(client *whatsmeow.Client, store *MessageStore, logger waLog.Logger) *Outbox {
+	return &Outbox{client: client, store: store, logger: logger, wake: make(chan struct{}, 1)}
+}
+
+// Start delivers queued messages in the background, whenever woken and every few seconds
+func (o *Outbox) Start() {
+	go func() {
+		lastPrune := time.Time{}
+		for {
+			o.deliverDue()
+			if time.Since(lastPrune) > time.Hour {
+				o.prune(time.Now())
+				lastPrune = time.Now()
+			}
+			select {
+			case <-o.wake:
+			case <-time.After(outboxPollInterval):
+			}
+		}
+	}()
+}
+
+// Wake makes the outbox look for messages to deliver now, e.g. after reconnecting
+func (o *Outbox) Wake() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Send queues a message and sends it right away when connected and no earlier message to the same recipient
+// is waiting. It returns the entry as it stands afterwards: sent, failed, or still pending for a retry.
+func (o *Outbox) Send(req SendMessageRequest) (OutboxEntry, error) {
+	if readOnlyModeEnabled() {
+		return OutboxEntry{}, errReadOnly
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	result, err := o.store.db.Exec(`
+		INSERT INTO outbox (recipient, message, media_path, origin, reply_to, mentions, status, created_at, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, req.Recipient, req.Message, req.MediaPath, req.Origin, req.ReplyTo, strings.Join(req.Mentions, ","), outboxPending, now, now)
+	if err != nil {
+		return OutboxEntry{}, fmt.Errorf("failed to queue message: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return OutboxEntry{}, fmt.Errorf("failed to queue message: %v", err)
+	}
+	o.auditQueued(id, req)
+
+	var waiting int
+	if err := o.store.db.QueryRow("SELECT COUNT(*) FROM outbox WHERE status = ? AND recipient = ? AND id < ?", outboxPending, req.Recipient, id).Scan(&waiting); err != nil {
+		return OutboxEntry{}, fmt.Errorf("failed to check the outbox: %v", err)
+	}
+
+	entry, err := o.get(id)
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+	if waiting > 0 {
+		entry.LastError = fmt.Sprintf("behind %d earlier message(s) to %s", waiting, req.Recipient)
+		o.Wake()
+		return entry, nil
+	}
+	o.deliver(&entry)
+	return entry, nil
+}
+
+// Schedule queues a message to be sent at a later time. Until then it doesn't hold up the recipient's other
+// messages, and it can be cancelled.
+func (o *Outbox) Schedule(req SendMessageRequest, sendAt time.Time) (OutboxEntry, error) {
+	if readOnlyModeEnabled() {
+		return OutboxEntry{}, errReadOnly
+	}
+	// SQLite compares the times as text, so they're all stored in local time
+	sendAt = sendAt.In(time.Local)
+	result, err := o.store.db.Exec(`
+		INSERT INTO outbox (recipient, message, media_path, origin, reply_to, mentions, status, created_at, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, req.Recipient, req.Message, req.MediaPath, req.Origin, req.ReplyTo, strings.Join(req.Mentions, ","), outboxScheduled, time.Now(), sendAt)
+	if err != nil {
+		return OutboxEntry{}, fmt.Errorf("failed to schedule message: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return OutboxEntry{}, fmt.Errorf("failed to schedule message: %v", err)
+	}
+	o.auditQueued(id, req)
+	return o.get(id)
+}
+
+// auditQueued records a message queued in the outbox in the send audit; deliver records how it ends
+func (o *Outbox) auditQueued(id int64, req SendMessageRequest) {
+	origin := req.Origin
+	if origin == "" {
+		origin = messageOriginBridgeAPI
+	}
+	err := recordSendAudit(o.store.db, SendAuditRecord{
+		TriggeredBy: req.TriggeredBy,
+		Origin:      origin,
+		Recipient:   req.Recipient,
+		OutboxID:    id,
+		PromptHash:  req.PromptHash,
+		ContentHash: hashSendText(req.Message),
+		Result:      sendAuditQueued,
+	})
+	if err != nil {
+		o.logger.Warnf("Failed to record message %d in the audit log: %v", id, err)
+	}
+}
+
+// Cancel deletes a scheduled message that wasn't sent yet
+func (o *Outbox) Cancel(id int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	entry, err := o.get(id)
+	if err != nil {
+		return err
+	}
+	if entry.Status != outboxScheduled {
+		return fmt.Errorf("message %d is %s, only scheduled messages can be cancelled", id, entry.Status)
+	}
+	if _, err := o.store.db.Exec("DELETE FROM outbox WHERE id = ? AND status = ?", id, outboxScheduled); err != nil {
+		return fmt.Errorf("failed to cancel message %d: %v", id, err)
+	}
+	if err := updateOutboxSendAudit(o.store.db, id, sendAuditCancelled, "", ""); err != nil {
+		o.logger.Warnf("Failed to update the audit log for message %d: %v", id, err)
+	}
+	return nil
+}
+
+// deliverDue sends the queued messages whose retry time has come, oldest first. A recipient whose message
+// can't be sent yet gets nothing else this round, so its later messages don't overtake it.
+func (o *Outbox) deliverDue() {
+	// Scheduled messages whose time has come join the queue
+	if _, err := o.store.db.Exec("UPDATE outbox SET status = ? WHERE status = ? AND next_attempt_at <= ?", outboxPending, outboxScheduled, time.Now()); err != nil {
+		o.logger.Warnf("Failed to queue the scheduled messages: %v", err)
+	}
+	if isSafeModeActive() || readOnlyModeEnabled() || !o.client.IsConnected() {
+		return
+	}
+	entries, err := o.list(outboxPending, 0)
+	if err != nil {
+		o.logger.Warnf("Failed to read the outbox: %v", err)
+		return
+	}
+
+	blocked := map[string]bool{}
+	for i := len(entries) - 1; i >= 0; i-- { // list returns the newest first
+		entry := entries[i]
+		if blocked[entry.Recipient] {
+			continue
+		}
+		if entry.NextAttemptAt != nil && entry.NextAttemptAt.After(time.Now()) {
+			blocked[entry.Recipient] = true
+			continue
+		}
+
+		o.mu.Lock()
+		// Send may have delivered it meanwhile
+		current, err := o.get(entry.ID)
+		if err == nil && current.Status == outboxPending {
+			o.deliver(&current)
+		}
+		o.mu.Unlock()
+		if err != nil || current.Status == outboxPending {
+			blocked[entry.Recipient] = true
+		}
+	}
+}
+
+// deliver tries to send a pending entry and records the outcome in it and in the table; the caller holds o.mu
+func (o *Outbox) deliver(entry *OutboxEntry) {
+	if !o.client.IsConnected() {
+		// Not an attempt: the entry waits for the connection
+		entry.LastError = "Not connected to WhatsApp"
+		return
+	}
+
+	messageID, status, retryable := trySendWhatsAppMessage(o.client, o.store, entry.Recipient, entry.Message, entry.MediaPath, entry.Origin, entry.ReplyTo, entry.Mentions)
+	entry.Attempts++
+	now := time.Now()
+	var err error
+	switch {
+	case messageID != "":
+		entry.Status, entry.MessageID, entry.LastError, entry.SentAt, entry.NextAttemptAt = outboxSent, messageID, "", &now, nil
+		// The text is in the messages table now, possibly encrypted; don't keep a plain copy here
+		entry.Message = ""
+		_, err = o.store.db.Exec("UPDATE outbox SET status = ?, attempts = ?, message_id = ?, last_error = '', message = '', sent_at = ?, next_attempt_at = NULL WHERE id = ?",
+			outboxSent, entry.Attempts, messageID, now, entry.ID)
+		if entry.Attempts > 1 {
+			o.logger.Infof("Sent queued message %d to %s after %d attempts", entry.ID, entry.Recipient, entry.Attempts)
+		}
+		if auditErr := updateOutboxSendAudit(o.store.db, entry.ID, sendAuditSent, messageID, ""); auditErr != nil {
+			o.logger.Warnf("Failed to update the audit log for message %d: %v", entry.ID, auditErr)
+		}
+	case retryable && entry.Attempts < getOutboxMaxAttempts():
+		next := now.Add(outboxRetryDelay(entry.Attempts))
+		entry.LastError, entry.NextAttemptAt = status, &next
+		_, err = o.store.db.Exec("UPDATE outbox SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE id = ?", entry.Attempts, status, next, entry.ID)
+		o.logger.Warnf("Sending queued message %d to %s failed (attempt %d), retrying at %s: %s", entry.ID, entry.Recipient, entry.Attempts, next.Format("15:04:05"), status)
+	default:
+		entry.Status, entry.LastError, entry.NextAttemptAt = outboxFailed, status, nil
+		_, err = o.store.db.Exec("UPDATE outbox SET status = ?, attempts = ?, last_error = ?, next_attempt_at = NULL WHERE id = ?", outboxFailed, entry.Attempts, status, entry.ID)
+		o.logger.Warnf("Giving up on queued message %d to %s after %d attempts: %s", entry.ID, entry.Recipient, entry.Attempts, status)
+		if auditErr := updateOutboxSendAudit(o.store.db, entry.ID, sendAuditFailed, "", status); auditErr != nil {
+			o.logger.Warnf("Failed to update the audit log for message %d: %v", entry.ID, auditErr)
+		}
+		if entry.Origin != messageOriginAlert {
+			// An alert that can't be sent isn't reported in turn
+			go reportError(errorEventSendFailed, fmt.Sprintf("Failed to send a message to %s", entry.Recipient),
+				map[string]string{"outbox_id": strconv.FormatInt(entry.ID, 10), "attempts": strconv.Itoa(entry.Attempts), "error": status}, o.logger)
+		}
+	}
+	if err != nil {
+		o.logger.Warnf("Failed to update outbox entry %d: %v", entry.ID, err)
+	}
+}
+
+// Retry queues a failed message again, behind the messages already waiting for its recipient
+func (o *Outbox) Retry(id int64) (OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	entry, err := o.get(id)
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+	if entry.Status != outboxFailed {
+		return OutboxEntry{}, fmt.Errorf("message %d is %s, only failed messages can be retried", id, entry.Status)
+	}
+
+	// A new ID puts it at the end of the recipient's queue
+	result, err := o.store.db.Exec(`
+		INSERT INTO outbox (recipient, message, media_path, origin, reply_to, mentions, status, created_at, next_attempt_at)
+		SELECT recipient, message, media_path, origin, reply_to, mentions, ?, created_at, ? FROM outbox WHERE id = ?
+	`, outboxPending, time.Now(), id)
+	if err != nil {
+		return OutboxEntry{}, fmt.Errorf("failed to queue message again: %v", err)
+	}
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return OutboxEntry{}, fmt.Errorf("failed to queue message again: %v", err)
+	}
+	if _, err := o.store.db.Exec("DELETE FROM outbox WHERE id = ?", id); err != nil {
+		return OutboxEntry{}, fmt.Errorf("failed to queue message again: %v", err)
+	}
+	// The audited send follows the message to its new ID
+	if _, err := o.store.db.Exec("UPDATE send_audit SET outbox_id = ?, result = ?, error = '' WHERE outbox_id = ?", newID, sendAuditQueued, id); err != nil {
+		o.logger.Warnf("Failed to update the audit log: %v", err)
+	}
+	o.Wake()
+	return o.get(newID)
+}
+
+// prune deletes the sent and failed entries older than the retention period
+func (o *Outbox) prune(now time.Time) {
+	if _, err := o.store.db.Exec("DELETE FROM outbox WHERE status IN (?, ?) AND created_at < ?", outboxSent, outboxFailed, now.Add(-outboxRetention)); err != nil {
+		o.logger.Warnf("Failed to prune the outbox: %v", err)
+	}
+}
+
+// outboxColumns are the columns scanned by scanOutboxEntry
+const outboxColumns = "id, recipient, message, media_path, origin, reply_to, mentions, status, attempts, last_error, message_id, created_at, next_attempt_at, sent_at"
+
+// scanOutboxEntry reads an outbox row
+func scanOutboxEntry(row interface{ Scan(...any) error }) (OutboxEntry, error) {
+	var entry OutboxEntry
+	var mentions string
+	var nextAttemptAt, sentAt sql.NullTime
+	err := row.Scan(&entry.ID, &entry.Recipient, &entry.Message, &entry.MediaPath, &entry.Origin, &entry.ReplyTo, &mentions,
+		&entry.Status, &entry.Attempts, &entry.LastError, &entry.MessageID, &entry.CreatedAt, &nextAttemptAt, &sentAt)
+	if err != nil {
+		return OutboxEntry{}, err
+	}
+	if mentions != "" {
+		entry.Mentions = strings.Split(mentions, ",")
+	}
+	if nextAttemptAt.Valid {
+		entry.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if sentAt.Valid {
+		entry.SentAt = &sentAt.Time
+	}
+	return entry, nil
+}
+
+// get returns an outbox entry
+func (o *Outbox) get(id int64) (OutboxEntry, error) {
+	entry, err := scanOutboxEntry(o.store.db.QueryRow("SELECT "+outboxColumns+" FROM outbox WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return OutboxEntry{}, fmt.Errorf("message %d is not in the outbox", id)
+	}
+	if err != nil {
+		return OutboxEntry{}, fmt.Errorf("failed to read outbox entry %d: %v", id, err)
+	}
+	return entry, nil
+}
+
+// list returns the outbox entries with a status ("" for all), newest first; limit 0 returns them all
+func (o *Outbox) list(status string, limit int) ([]OutboxEntry, error) {
+	query := "SELECT " + outboxColumns + " FROM outbox WHERE (? = '' OR status = ?) ORDER BY id DESC"
+	args := []interface{}{status, status}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := o.store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		entry, err := scanOutboxEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// OutboxStatus is the /api/outbox response
+type OutboxStatus struct {
+	Connected bool           `json:"connected"`
+	SafeMode  bool           `json:"safe_mode"` // queued messages wait while safe mode is on
+	Counts    map[string]int `json:"counts"`    // entries per status
+	Entries   []OutboxEntry  `json:"entries"`
+}
+
+// handleOutboxAPI lists the queued, scheduled, sent and failed messages (GET /api/outbox?status=pending&limit=50).
+// Tokens limited to some chats only see the messages to those chats.
+func handleOutboxAPI(outbox *Outbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		status := r.URL.Query().Get("status")
+		if status != "" && status != outboxPending && status != outboxSent && status != outboxFailed && status != outboxScheduled {
+			http.Error(w, "Status must be pending, scheduled, sent or failed", http.StatusBadRequest)
+			return
+		}
+		limit := outboxDefaultListLimit
+		if value := r.URL.Query().Get("limit"); value != "" {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+				http.Error(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// The outbox only holds recent entries, so the token's chats are filtered here
+		entries, err := outbox.list("", 0)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read the outbox: %v", err), http.StatusInternalServerError)
+			return
+		}
+		response := OutboxStatus{
+			Connected: outbox.client.IsConnected(),
+			SafeMode:  isSafeModeActive(),
+			Counts:    map[string]int{outboxPending: 0, outboxScheduled: 0, outboxSent: 0, outboxFailed: 0},
+			Entries:   []OutboxEntry{},
+		}
+		for _, entry := range entries {
+			if !apiRequestAllowsChat(r, entry.Recipient) {
+				continue
+			}
+			response.Counts[entry.Status]++
+			if (status == "" || entry.Status == status) && len(response.Entries) < limit {
+				response.Entries = append(response.Entries, entry)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// OutboxRetryRequest is the body of /api/outbox/retry and /api/schedule/cancel
+type OutboxRetryRequest struct {
+	ID int64 `json:"id"`
+}
+
+// handleOutboxRetryAPI queues a failed message again (POST /api/outbox/retry)
+func handleOutboxRetryAPI(outbox *Outbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req OutboxRetryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		entry, err := outbox.get(req.ID)
+		if err == nil && !apiRequestAllowsChat(r, entry.Recipient) {
+			rejectChat(w, r, entry.Recipient)
+			return
+		}
+		if err == nil {
+			entry, err = outbox.Retry(req.ID)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success: true,
+			Message: fmt.Sprintf("Message queued again as %d", entry.ID),
+			QueueID: entry.ID,
+		})
+	}
+}
+
+// ScheduleMessageRequest is the body of /api/schedule: a message as for /api/send, and when to send it
+type ScheduleMessageRequest struct {
+	SendMessageRequest
+	SendAt string `json:"send_at"` // RFC 3339 time, or YYYY-MM-DDTHH:MM:SS in the bridge's timezone
+}
+
+// handleScheduleAPI queues a message to be sent later (POST /api/schedule). Scheduled messages are listed with
+// /api/outbox?status=scheduled and cancelled with /api/schedule/cancel; in safe mode they wait until it's cleared.
+func handleScheduleAPI(outbox *Outbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ScheduleMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Recipient == "" {
+			http.Error(w, "Recipient is required", http.StatusBadRequest)
+			return
+		}
+		if req.Message == "" && req.MediaPath == "" {
+			http.Error(w, "Message or media path is required", http.StatusBadRequest)
+			return
+		}
+		if !strings.Contains(req.Recipient, "@") && req.Recipient != "self" {
+			req.Recipient = normalizePhoneRecipient(req.Recipient)
+		}
+		if !apiRequestAllowsChat(r, req.Recipient) {
+			rejectChat(w, r, req.Recipient)
+			return
+		}
+		if req.MediaPath != "" && apiTokenFromRequest(r) != nil && !apiTokenFromRequest(r).HasCapability(apiCapabilityAdmin) {
+			http.Error(w, "Sending media requires the admin capability", http.StatusForbidden)
+			return
+		}
+		if rejectedBySendPolicy(w, r, outbox.client, outbox.store, sendKindMessage, "", req.Recipient, req.Message) {
+			return
+		}
+		sendAt, err := parseSearchTime(req.SendAt)
+		if err != nil {
+			http.Error(w, "send_at must be an RFC 3339 time or YYYY-MM-DDTHH:MM:SS", http.StatusBadRequest)
+			return
+		}
+		if !sendAt.After(time.Now()) || sendAt.After(time.Now().Add(outboxMaxScheduleAhead)) {
+			http.Error(w, "send_at must be in the future, within a year", http.StatusBadRequest)
+			return
+		}
+		req.Origin = ""
+		req.TriggeredBy = sendTrigger(r)
+
+		entry, err := outbox.Schedule(req.SendMessageRequest, sendAt)
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success: true,
+			Message: fmt.Sprintf("Message to %s scheduled for %s", req.Recipient, sendAt.In(time.Local).Format("2006-01-02 15:04:05 MST")),
+			Queued:  true,
+			QueueID: entry.ID,
+		})
+	}
+}
+
+// handleScheduleCancelAPI cancels a scheduled message (POST /api/schedule/cancel {"id": 12})
+func handleScheduleCancelAPI(outbox *Outbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req OutboxRetryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		entry, err := outbox.get(req.ID)
+		if err == nil && !apiRequestAllowsChat(r, entry.Recipient) {
+			rejectChat(w, r, entry.Recipient)
+			return
+		}
+		if err == nil {
+			err = outbox.Cancel(req.ID)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: fmt.Sprintf("Scheduled message %d cancelled", req.ID)})
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	parquetOut   = flag.String("out", "store/parquet", "Output directory")
+	parquetChat  = flag.String("chat", "", "Only export this chat JID (default every chat)")
+	parquetSince = flag.String("since", "", "Only export months from this one on, in YYYY-MM format (default every month)")
+	parquetTZ    = flag.String("timezone", "", "Timezone for month partitions and daily aggregates (default DAILY_SUMMARY_TIMEZONE, or UTC)")
+)
+
+// Columns of the exported datasets
+var (
+	parquetChatColumns = []ParquetColumn{
+		{Name: "jid", Type: ParquetString},
+		{Name: "name", Type: ParquetString},
+		{Name: "is_group", Type: ParquetBool},
+		{Name: "last_message_time", Type: ParquetTimestamp},
+	}
+	parquetMessageColumns = []ParquetColumn{
+		{Name: "id", Type: ParquetString},
+		{Name: "chat_jid", Type: ParquetString},
+		{Name: "chat_name", Type: ParquetString},
+		{Name: "sender", Type: ParquetString},
+		{Name: "timestamp", Type: ParquetTimestamp},
+		{Name: "is_from_me", Type: ParquetBool},
+		{Name: "content", Type: ParquetString},
+		{Name: "transcript", Type: ParquetString},
+		{Name: "media_type", Type: ParquetString},
+		{Name: "filename", Type: ParquetString},
+		{Name: "file_length", Type: ParquetInt64},
+		{Name: "message_type", Type: ParquetString},
+		{Name: "origin", Type: ParquetString},
+		{Name: "edited_at", Type: ParquetTimestamp},
+		{Name: "deleted_at", Type: ParquetTimestamp},
+	}
+	parquetActivityColumns = []ParquetColumn{
+		{Name: "date", Type: ParquetDate},
+		{Name: "chat_jid", Type: ParquetString},
+		{Name: "sender", Type: ParquetString},
+		{Name: "is_from_me", Type: ParquetBool},
+		{Name: "messages", Type: ParquetInt64},
+		{Name: "media_messages", Type: ParquetInt64},
+		{Name: "characters", Type: ParquetInt64},
+		{Name: "first_message", Type: ParquetTimestamp},
+		{Name: "last_message", Type: ParquetTimestamp},
+	}
+	parquetReceiptColumns = []ParquetColumn{
+		{Name: "message_id", Type: ParquetString},
+		{Name: "chat_jid", Type: ParquetString},
+		{Name: "recipient", Type: ParquetString},
+		{Name: "message_timestamp", Type: ParquetTimestamp},
+		{Name: "delivered_at", Type: ParquetTimestamp},
+		{Name: "read_at", Type: ParquetTimestamp},
+	}
+)
+
+func main() {
+	flag.Parse()
+
+	if err := exportParquet(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// parquetPartition holds the rows of one chat and month while they are read
+type parquetPartition struct {
+	chatJID  string
+	month    string
+	messages [][]interface{}
+	activity map[string]*parquetActivity // by date and sender
+}
+
+// parquetActivity is one row of the daily activity aggregate
+type parquetActivity struct {
+	date         time.Time
+	sender       string
+	isFromMe     bool
+	messages     int64
+	media        int64
+	characters   int64
+	firstMessage time.Time
+	lastMessage  time.Time
+}
+
+// exportParquet writes the chats, messages, daily activity and receipts to Parquet files partitioned by chat and month,
+// reading the database without writing to it
+func exportParquet() error {
+	timezone := *parquetTZ
+	if timezone == "" {
+		timezone = os.Getenv("DAILY_SUMMARY_TIMEZONE")
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return fmt.Errorf("failed to load timezone %s: %v", timezone, err)
+	}
+	var since time.Time
+	if *parquetSince != "" {
+		if since, err = time.ParseInLocation("2006-01", *parquetSince, loc); err != nil {
+			return fmt.Errorf("invalid --since month: %v", err)
+		}
+	}
+
+	// Direct chats kept in DIRECT_MESSAGES_DB are not exported, so their text is never written out unencrypted
+	db, err := sql.Open(messagesDBDriver(), "file:store/messages.db?mode=ro&_foreign_keys=on")
+	if err != nil {
+		return fmt.Errorf("failed to open message database: %v", err)
+	}
+	defer db.Close()
+
+	// Read everything in one transaction, so the export is consistent while the bridge keeps writing
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start reading: %v", err)
+	}
+	defer tx.Rollback()
+
+	chatNames, err := exportParquetChats(tx)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		SELECT id, chat_jid, COALESCE(sender, ''), timestamp, COALESCE(is_from_me, 0), COALESCE(content, ''), COALESCE(transcript, ''),
+			COALESCE(media_type, ''), COALESCE(filename, ''), COALESCE(file_length, 0), COALESCE(message_type, ''), COALESCE(origin, ''), edited_at, deleted_at
+		FROM messages`
+	var args []interface{}
+	if *parquetChat != "" {
+		query += " WHERE chat_jid = ?"
+		args = append(args, *parquetChat)
+	}
+	rows, err := tx.Query(query+" ORDER BY chat_jid, timestamp, id", args...)
+	if err != nil {
+		return fmt.Errorf("failed to query messages: %v", err)
+	}
+	defer rows.Close()
+
+	var partition *parquetPartition
+	partitions, messages := 0, 0
+	flush := func() error {
+		if partition == nil || len(partition.messages) == 0 {
+			return nil
+		}
+		if err := writeParquetPartition(partition); err != nil {
+			return err
+		}
+		partitions++
+		messages += len(partition.messages)
+		return nil
+	}
+
+	for rows.Next() {
+		var id, chatJID, sender, content, transcript, mediaType, filename, messageType, origin string
+		var timestamp time.Time
+		var isFromMe bool
+		var fileLength int64
+		var editedAt, deletedAt sql.NullTime
+		if err := rows.Scan(&id, &chatJID, &sender, &timestamp, &isFromMe, &content, &transcript,
+			&mediaType, &filename, &fileLength, &messageType, &origin, &editedAt, &deletedAt); err != nil {
+			return fmt.Errorf("failed to read message: %v", err)
+		}
+		// Deleted messages are exported without what they said
+		if deletedAt.Valid {
+			content, transcript = "", ""
+		}
+		local := timestamp.In(loc)
+		if !since.IsZero() && local.Before(since) {
+			continue
+		}
+
+		month := local.Format("2006-01")
+		if partition == nil || partition.chatJID != chatJID || partition.month != month {
+			if err := flush(); err != nil {
+				return err
+			}
+			partition = &parquetPartition{chatJID: chatJID, month: month, activity: make(map[string]*parquetActivity)}
+		}
+
+		partition.messages = append(partition.messages, []interface{}{
+			id, chatJID, optionalParquetString(chatNames[chatJID]), optionalParquetString(sender), timestamp, isFromMe,
+			optionalParquetString(content), optionalParquetString(transcript), optionalParquetString(mediaType),
+			optionalParquetString(filename), optionalParquetInt64(fileLength), optionalParquetString(messageType), optionalParquetString(origin),
+			optionalParquetTime(editedAt), optionalParquetTime(deletedAt),
+		})
+
+		date := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+		key := date.Format("2006-01-02") + "|" + sender
+		activity, ok := partition.activity[key]
+		if !ok {
+			activity = &parquetActivity{date: date, sender: sender, isFromMe: isFromMe, firstMessage: timestamp}
+			partition.activity[key] = activity
+		}
+		activity.messages++
+		activity.characters += int64(len([]rune(content)))
+		if mediaType != "" {
+			activity.media++
+		}
+		activity.lastMessage = timestamp
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read messages: %v", err)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	receipts, err := exportParquetReceipts(tx, loc, since)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %d messages and %d receipts in %d chat-month partitions to %s\n", messages, receipts, partitions, *parquetOut)
+	return nil
+}
+
+// exportParquetReceipts writes the delivery and read receipts of sent messages, partitioned by the chat and month
+// of the message they are for, and returns how many it wrote
+func exportParquetReceipts(tx *sql.Tx, loc *time.Location, since time.Time) (int, error) {
+	query := `
+		SELECT r.message_id, r.chat_jid, r.recipient, m.timestamp, r.delivered_at, r.read_at
+		FROM message_receipts r
+		JOIN messages m ON m.id = r.message_id AND m.chat_jid = r.chat_jid`
+	var args []interface{}
+	if *parquetChat != "" {
+		query += " WHERE r.chat_jid = ?"
+		args = append(args, *parquetChat)
+	}
+	rows, err := tx.Query(query+" ORDER BY r.chat_jid, m.timestamp, r.message_id, r.recipient", args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query receipts: %v", err)
+	}
+	defer rows.Close()
+
+	var chatJID, month string
+	var partition [][]interface{}
+	receipts := 0
+	flush := func() error {
+		if len(partition) == 0 {
+			return nil
+		}
+		dir := parquetPartitionDir("receipts", chatJID, month)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", dir, err)
+		}
+		if err := writeParquetFile(filepath.Join(dir, "part-0.parquet"), parquetReceiptColumns, partition); err != nil {
+			return fmt.Errorf("failed to write receipts of %s %s: %v", chatJID, month, err)
+		}
+		receipts += len(partition)
+		partition = nil
+		return nil
+	}
+
+	for rows.Next() {
+		var messageID, chat, recipient string
+		var timestamp time.Time
+		var deliveredAt, readAt sql.NullTime
+		if err := rows.Scan(&messageID, &chat, &recipient, &timestamp, &deliveredAt, &readAt); err != nil {
+			return 0, fmt.Errorf("failed to read receipt: %v", err)
+		}
+		local := timestamp.In(loc)
+		if !since.IsZero() && local.Before(since) {
+			continue
+		}
+
+		if chat != chatJID || local.Format("2006-01") != month {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+			chatJID, month = chat, local.Format("2006-01")
+		}
+		partition = append(partition, []interface{}{
+			messageID, chat, recipient, timestamp, optionalParquetTime(deliveredAt), optionalParquetTime(readAt),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read receipts: %v", err)
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	return receipts, nil
+}
+
+// exportParquetChats writes every chat to chats.parquet and returns the chat names by JID
+func exportParquetChats(tx *sql.Tx) (map[string]string, error) {
+	rows, err := tx.Query("SELECT jid, COALESCE(name, ''), last_message_time FROM chats ORDER BY jid")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chats: %v", err)
+	}
+	defer rows.Close()
+
+	names := make(map[string]string)
+	var chats [][]interface{}
+	for rows.Next() {
+		var jid, name string
+		var lastMessageTime sql.NullTime
+		if err := rows.Scan(&jid, &name, &lastMessageTime); err != nil {
+			return nil, fmt.Errorf("failed to read chat: %v", err)
+		}
+		names[jid] = name
+		var last interface{}
+		if lastMessageTime.Valid {
+			last = lastMessageTime.Time
+		}
+		chats = append(chats, []interface{}{jid, optionalParquetString(name), strings.HasSuffix(jid, "@g.us"), last})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read chats: %v", err)
+	}
+
+	if err := os.MkdirAll(*parquetOut, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %v", err)
+	}
+	if err := writeParquetFile(filepath.Join(*parquetOut, "chats.parquet"), parquetChatColumns, chats); err != nil {
+		return nil, fmt.Errorf("failed to write chats: %v", err)
+	}
+	return names, nil
+}
+
+// parquetPartitionDir returns the Hive-style directory of a dataset partition, e.g. messages/chat=<jid>/month=2024-01
+func parquetPartitionDir(dataset, chatJID, month string) string {
+	return filepath.Join(*parquetOut, dataset, "chat="+chatJID, "month="+month)
+}
+
+// writeParquetPartition writes the messages and daily activity of one chat and month
+func writeParquetPartition(partition *parquetPartition) error {
+	dir := parquetPartitionDir("messages", partition.chatJID, partition.month)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	if err := writeParquetFile(filepath.Join(dir, "part-0.parquet"), parquetMessageColumns, partition.messages); err != nil {
+		return fmt.Errorf("failed to write messages of %s %s: %v", partition.chatJID, partition.month, err)
+	}
+
+	activities := make([]*parquetActivity, 0, len(partition.activity))
+	for _, activity := range partition.activity {
+		activities = append(activities, activity)
+	}
+	sort.Slice(activities, func(i, j int) bool {
+		if !activities[i].date.Equal(activities[j].date) {
+			return activities[i].date.Before(activities[j].date)
+		}
+		return activities[i].sender < activities[j].sender
+	})
+	var rows [][]interface{}
+	for _, activity := range activities {
+		rows = append(rows, []interface{}{
+			activity.date, partition.chatJID, optionalParquetString(activity.sender), activity.isFromMe,
+			activity.messages, activity.media, activity.characters, activity.firstMessage, activity.lastMessage,
+		})
+	}
+
+	dir = parquetPartitionDir("daily_activity", partition.chatJID, partition.month)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	if err := writeParquetFile(filepath.Join(dir, "part-0.parquet"), parquetActivityColumns, rows); err != nil {
+		return fmt.Errorf("failed to write daily activity of %s %s: %v", partition.chatJID, partition.month, err)
+	}
+	return nil
+}
+
+// optionalParquetString stores empty strings as null
+func optionalParquetString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// optionalParquetTime stores a missing time as null
+func optionalParquetTime(value sql.NullTime) interface{} {
+	if !value.Valid {
+		return nil
+	}
+	return value.Time
+}
+
+// optionalParquetInt64 stores zero as null
+func optionalParquetInt64(value int64) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,728 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// A minimal Parquet writer: one row group, one uncompressed PLAIN data page per column and every column optional,
+// which is all the exports need and keeps the bridge free of a Parquet dependency. Readers such as DuckDB,
+// pandas/pyarrow and Spark read these files like any other. readParquetFile reads them back, for the archive.
+
+// ParquetType is the type of a Parquet column as exported
+type ParquetType int
+
+const (
+	ParquetString    ParquetType = iota // BYTE_ARRAY annotated UTF8; values are strings
+	ParquetInt64                        // INT64; values are int64
+	ParquetBool                         // BOOLEAN; values are bool
+	ParquetDouble                       // DOUBLE; values are float64
+	ParquetTimestamp                    // INT64 annotated TIMESTAMP_MILLIS (UTC); values are time.Time
+	ParquetDate                         // INT32 annotated DATE; values are time.Time, only the date is kept
+)
+
+// ParquetColumn describes a column; a nil value in a row is stored as null
+type ParquetColumn struct {
+	Name string
+	Type ParquetType
+}
+
+// Parquet physical types, repetition types, converted types and encodings from the format's Thrift definition
+const (
+	parquetPhysicalBoolean   = 0
+	parquetPhysicalInt32     = 1
+	parquetPhysicalInt64     = 2
+	parquetPhysicalDouble    = 5
+	parquetPhysicalByteArray = 6
+
+	parquetRepetitionOptional = 1
+
+	parquetConvertedUTF8            = 0
+	parquetConvertedDate            = 6
+	parquetConvertedTimestampMillis = 9
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetPageData = 0
+)
+
+// physicalType returns the Parquet physical type and converted type (-1 for none) of a column type
+func (columnType ParquetType) physicalType() (int32, int32) {
+	switch columnType {
+	case ParquetString:
+		return parquetPhysicalByteArray, parquetConvertedUTF8
+	case ParquetBool:
+		return parquetPhysicalBoolean, -1
+	case ParquetDouble:
+		return parquetPhysicalDouble, -1
+	case ParquetTimestamp:
+		return parquetPhysicalInt64, parquetConvertedTimestampMillis
+	case ParquetDate:
+		return parquetPhysicalInt32, parquetConvertedDate
+	default:
+		return parquetPhysicalInt64, -1
+	}
+}
+
+// writeParquetFile writes rows to a Parquet file; the file is replaced atomically
+func writeParquetFile(path string, columns []ParquetColumn, rows [][]interface{}) error {
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+
+	type chunk struct {
+		offset int64
+		size   int64
+	}
+	chunks := make([]chunk, len(columns))
+	for i, column := range columns {
+		page, err := encodeParquetPage(column, i, rows)
+		if err != nil {
+			return err
+		}
+
+		header := newThriftCompactWriter()
+		header.i32(1, parquetPageData)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.structBegin(5) // DataPageHeader
+		header.i32(1, int32(len(rows)))
+		header.i32(2, parquetEncodingPlain)
+		header.i32(3, parquetEncodingRLE)
+		header.i32(4, parquetEncodingRLE)
+		header.structEnd()
+		headerBytes := header.finish()
+
+		chunks[i] = chunk{offset: int64(file.Len()), size: int64(len(headerBytes) + len(page))}
+		file.Write(headerBytes)
+		file.Write(page)
+	}
+
+	var totalSize int64
+	for _, chunk := range chunks {
+		totalSize += chunk.size
+	}
+
+	meta := newThriftCompactWriter()
+	meta.i32(1, 1) // version
+	meta.listBegin(2, thriftCompactStruct, len(columns)+1)
+	meta.listStructBegin() // root
+	meta.binary(4, []byte("schema"))
+	meta.i32(5, int32(len(columns)))
+	meta.structEnd()
+	for _, column := range columns {
+		physical, converted := column.Type.physicalType()
+		meta.listStructBegin()
+		meta.i32(1, physical)
+		meta.i32(3, parquetRepetitionOptional)
+		meta.binary(4, []byte(column.Name))
+		if converted >= 0 {
+			meta.i32(6, converted)
+		}
+		meta.structEnd()
+	}
+	meta.i64(3, int64(len(rows)))
+	meta.listBegin(4, thriftCompactStruct, 1)
+	meta.listStructBegin() // RowGroup
+	meta.listBegin(1, thriftCompactStruct, len(columns))
+	for i, column := range columns {
+		physical, _ := column.Type.physicalType()
+		meta.listStructBegin() // ColumnChunk
+		meta.i64(2, chunks[i].offset)
+		meta.structBegin(3) // ColumnMetaData
+		meta.i32(1, physical)
+		meta.listBegin(2, thriftCompactI32, 2)
+		meta.listI32(parquetEncodingPlain)
+		meta.listI32(parquetEncodingRLE)
+		meta.listBegin(3, thriftCompactBinary, 1)
+		meta.listBinary([]byte(column.Name))
+		meta.i32(4, 0) // uncompressed
+		meta.i64(5, int64(len(rows)))
+		meta.i64(6, chunks[i].size)
+		meta.i64(7, chunks[i].size)
+		meta.i64(9, chunks[i].offset)
+		meta.structEnd()
+		meta.structEnd()
+	}
+	meta.i64(2, totalSize)
+	meta.i64(3, int64(len(rows)))
+	meta.structEnd()
+	meta.binary(6, []byte("whatsapp-bridge parquet-export"))
+	metaBytes := meta.finish()
+
+	file.Write(metaBytes)
+	binary.Write(&file, binary.LittleEndian, uint32(len(metaBytes)))
+	file.WriteString("PAR1")
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, file.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// encodeParquetPage encodes a column's definition levels (RLE, bit width 1) and non-null values (PLAIN)
+func encodeParquetPage(column ParquetColumn, index int, rows [][]interface{}) ([]byte, error) {
+	var levels, values bytes.Buffer
+	var bits []bool
+
+	runValue, runLength := -1, 0
+	flushRun := func() {
+		if runLength > 0 {
+			levels.Write(binary.AppendUvarint(nil, uint64(runLength)<<1))
+			levels.WriteByte(byte(runValue))
+		}
+	}
+
+	for _, row := range rows {
+		value := row[index]
+		defined := 0
+		if value != nil {
+			defined = 1
+		}
+		if defined != runValue {
+			flushRun()
+			runValue, runLength = defined, 0
+		}
+		runLength++
+		if value == nil {
+			continue
+		}
+
+		var ok bool
+		switch column.Type {
+		case ParquetString:
+			var s string
+			if s, ok = value.(string); ok {
+				binary.Write(&values, binary.LittleEndian, uint32(len(s)))
+				values.WriteString(s)
+			}
+		case ParquetInt64:
+			var n int64
+			if n, ok = value.(int64); ok {
+				binary.Write(&values, binary.LittleEndian, n)
+			}
+		case ParquetBool:
+			var b bool
+			if b, ok = value.(bool); ok {
+				bits = append(bits, b)
+			}
+		case ParquetDouble:
+			var f float64
+			if f, ok = value.(float64); ok {
+				binary.Write(&values, binary.LittleEndian, math.Float64bits(f))
+			}
+		case ParquetTimestamp:
+			var t time.Time
+			if t, ok = value.(time.Time); ok {
+				binary.Write(&values, binary.LittleEndian, t.UnixMilli())
+			}
+		case ParquetDate:
+			var t time.Time
+			if t, ok = value.(time.Time); ok {
+				day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+				binary.Write(&values, binary.LittleEndian, int32(day.Unix()/86400))
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("column %s: unexpected value %T", column.Name, value)
+		}
+	}
+	flushRun()
+
+	if column.Type == ParquetBool {
+		packed := make([]byte, (len(bits)+7)/8)
+		for i, b := range bits {
+			if b {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		values.Write(packed)
+	}
+
+	var page bytes.Buffer
+	binary.Write(&page, binary.LittleEndian, uint32(levels.Len()))
+	page.Write(levels.Bytes())
+	page.Write(values.Bytes())
+	return page.Bytes(), nil
+}
+
+// Thrift compact protocol type ids
+const (
+	thriftCompactTrue   = 1
+	thriftCompactFalse  = 2
+	thriftCompactByte   = 3
+	thriftCompactI16    = 4
+	thriftCompactI32    = 5
+	thriftCompactI64    = 6
+	thriftCompactBinary = 8
+	thriftCompactDouble = 7
+	thriftCompactList   = 9
+	thriftCompactSet    = 10
+	thriftCompactMap    = 11
+	thriftCompactStruct = 12
+)
+
+// thriftCompactWriter encodes the Parquet page headers and footer with the Thrift compact protocol
+type thriftCompactWriter struct {
+	buf       bytes.Buffer
+	lastField []int16 // id of the last field written in each open struct
+}
+
+func newThriftCompactWriter() *thriftCompactWriter {
+	return &thriftCompactWriter{lastField: []int16{0}}
+}
+
+func (w *thriftCompactWriter) varint(v uint64) {
+	w.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (w *thriftCompactWriter) zigzag(v int64) {
+	w.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *thriftCompactWriter) fieldHeader(id int16, fieldType byte) {
+	last := &w.lastField[len(w.lastField)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		w.buf.WriteByte(fieldType)
+		w.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (w *thriftCompactWriter) i32(id int16, v int32) {
+	w.fieldHeader(id, thriftCompactI32)
+	w.zigzag(int64(v))
+}
+
+func (w *thriftCompactWriter) i64(id int16, v int64) {
+	w.fieldHeader(id, thriftCompactI64)
+	w.zigzag(v)
+}
+
+func (w *thriftCompactWriter) binary(id int16, v []byte) {
+	w.fieldHeader(id, thriftCompactBinary)
+	w.listBinary(v)
+}
+
+func (w *thriftCompactWriter) structBegin(id int16) {
+	w.fieldHeader(id, thriftCompactStruct)
+	w.lastField = append(w.lastField, 0)
+}
+
+func (w *thriftCompactWriter) structEnd() {
+	w.buf.WriteByte(0)
+	w.lastField = w.lastField[:len(w.lastField)-1]
+}
+
+func (w *thriftCompactWriter) listBegin(id int16, elementType byte, size int) {
+	w.fieldHeader(id, thriftCompactList)
+	if size < 15 {
+		w.buf.WriteByte(byte(size)<<4 | elementType)
+	} else {
+		w.buf.WriteByte(0xF0 | elementType)
+		w.varint(uint64(size))
+	}
+}
+
+// listStructBegin starts a struct element of a list; end it with structEnd
+func (w *thriftCompactWriter) listStructBegin() {
+	w.lastField = append(w.lastField, 0)
+}
+
+func (w *thriftCompactWriter) listI32(v int32) {
+	w.zigzag(int64(v))
+}
+
+func (w *thriftCompactWriter) listBinary(v []byte) {
+	w.varint(uint64(len(v)))
+	w.buf.Write(v)
+}
+
+// finish ends the top-level struct and returns the encoding
+func (w *thriftCompactWriter) finish() []byte {
+	w.buf.WriteByte(0)
+	return w.buf.Bytes()
+}
+
+// parquetChunk is where a column's values are in a row group
+type parquetChunk struct {
+	name       string
+	codec      int64
+	pageOffset int64
+}
+
+// readParquetFile reads the named columns of a file written by writeParquetFile: flat, optional columns with
+// one uncompressed PLAIN page per row group. Values come back as writeParquetFile takes them, with nil for nulls
+// and for columns the file doesn't have.
+func readParquetFile(path string, columns []ParquetColumn) ([][]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[:4]) != "PAR1" || string(data[len(data)-4:]) != "PAR1" {
+		return nil, fmt.Errorf("not a Parquet file")
+	}
+	metaLength := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if metaLength > len(data)-12 {
+		return nil, fmt.Errorf("invalid Parquet footer")
+	}
+
+	// The footer: the schema's column names, and each row group's column chunks
+	var names []string
+	var rowGroups [][]parquetChunk
+	meta := &thriftCompactReader{data: data[len(data)-8-metaLength : len(data)-8]}
+	meta.readStruct(func(id int16, fieldType byte) bool {
+		switch {
+		case id == 2 && fieldType == thriftCompactList:
+			first := true
+			meta.readList(func(byte) {
+				var name string
+				meta.readStruct(func(id int16, fieldType byte) bool {
+					if id == 4 && fieldType == thriftCompactBinary {
+						name = string(meta.readBinary())
+						return true
+					}
+					return false
+				})
+				if !first {
+					names = append(names, name)
+				}
+				first = false
+			})
+			return true
+		case id == 4 && fieldType == thriftCompactList:
+			meta.readList(func(byte) {
+				var chunks []parquetChunk
+				meta.readStruct(func(id int16, fieldType byte) bool {
+					if id != 1 || fieldType != thriftCompactList {
+						return false
+					}
+					meta.readList(func(byte) {
+						var chunk parquetChunk
+						meta.readStruct(func(id int16, fieldType byte) bool {
+							if id != 3 || fieldType != thriftCompactStruct {
+								return false
+							}
+							meta.readStruct(func(id int16, fieldType byte) bool {
+								switch {
+								case id == 3 && fieldType == thriftCompactList:
+									var path []string
+									meta.readList(func(byte) { path = append(path, string(meta.readBinary())) })
+									chunk.name = strings.Join(path, ".")
+								case id == 4 && fieldType == thriftCompactI32:
+									chunk.codec = meta.readVarint()
+								case id == 9 && fieldType == thriftCompactI64:
+									chunk.pageOffset = meta.readVarint()
+								default:
+									return false
+								}
+								return true
+							})
+							return true
+						})
+						chunks = append(chunks, chunk)
+					})
+					return true
+				})
+				rowGroups = append(rowGroups, chunks)
+			})
+			return true
+		}
+		return false
+	})
+	if meta.err != nil {
+		return nil, fmt.Errorf("invalid Parquet footer: %v", meta.err)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("Parquet file has no columns")
+	}
+
+	var rows [][]interface{}
+	for _, chunks := range rowGroups {
+		var groupRows [][]interface{}
+		for i, column := range columns {
+			var chunk *parquetChunk
+			for j := range chunks {
+				if chunks[j].name == column.Name {
+					chunk = &chunks[j]
+				}
+			}
+			if chunk == nil {
+				continue
+			}
+			if chunk.codec != 0 {
+				return nil, fmt.Errorf("column %s is compressed", column.Name)
+			}
+			values, err := readParquetPage(data, chunk.pageOffset, column)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %v", column.Name, err)
+			}
+			if groupRows == nil {
+				groupRows = make([][]interface{}, len(values))
+				for r := range groupRows {
+					groupRows[r] = make([]interface{}, len(columns))
+				}
+			}
+			if len(values) != len(groupRows) {
+				return nil, fmt.Errorf("column %s has %d values for %d rows", column.Name, len(values), len(groupRows))
+			}
+			for r, value := range values {
+				groupRows[r][i] = value
+			}
+		}
+		rows = append(rows, groupRows...)
+	}
+	return rows, nil
+}
+
+// readParquetPage decodes the data page at an offset: the definition levels, then the non-null values
+func readParquetPage(data []byte, offset int64, column ParquetColumn) ([]interface{}, error) {
+	if offset < 4 || offset >= int64(len(data)) {
+		return nil, fmt.Errorf("invalid page offset")
+	}
+	header := &thriftCompactReader{data: data[offset:]}
+	var pageType, pageSize, numValues, encoding int64 = -1, -1, -1, -1
+	header.readStruct(func(id int16, fieldType byte) bool {
+		switch {
+		case id == 1 && fieldType == thriftCompactI32:
+			pageType = header.readVarint()
+		case id == 3 && fieldType == thriftCompactI32:
+			pageSize = header.readVarint()
+		case id == 5 && fieldType == thriftCompactStruct:
+			header.readStruct(func(id int16, fieldType byte) bool {
+				switch {
+				case id == 1 && fieldType == thriftCompactI32:
+					numValues = header.readVarint()
+				case id == 2 && fieldType == thriftCompactI32:
+					encoding = header.readVarint()
+				default:
+					return false
+				}
+				return true
+			})
+		default:
+			return false
+		}
+		return true
+	})
+	if header.err != nil {
+		return nil, header.err
+	}
+	if pageType != parquetPageData || encoding != parquetEncodingPlain || numValues < 0 || pageSize < 4 {
+		return nil, fmt.Errorf("unsupported page")
+	}
+	start := offset + int64(header.pos)
+	if start+pageSize > int64(len(data)) {
+		return nil, fmt.Errorf("truncated page")
+	}
+	page := data[start : start+pageSize]
+
+	// Definition levels: RLE/bit-packed hybrid with bit width 1
+	levelsLength := int(binary.LittleEndian.Uint32(page))
+	if 4+levelsLength > len(page) {
+		return nil, fmt.Errorf("truncated definition levels")
+	}
+	levels := page[4 : 4+levelsLength]
+	defined := make([]bool, 0, numValues)
+	for pos := 0; pos < len(levels) && int64(len(defined)) < numValues; {
+		runHeader, n := binary.Uvarint(levels[pos:])
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid definition levels")
+		}
+		pos += n
+		if runHeader&1 == 0 {
+			if pos >= len(levels) {
+				return nil, fmt.Errorf("invalid definition levels")
+			}
+			for i := uint64(0); i < runHeader>>1; i++ {
+				defined = append(defined, levels[pos] == 1)
+			}
+			pos++
+		} else {
+			for i := uint64(0); i < runHeader>>1 && pos < len(levels); i++ {
+				for bit := 0; bit < 8; bit++ {
+					defined = append(defined, levels[pos]&(1<<bit) != 0)
+				}
+				pos++
+			}
+		}
+	}
+	if int64(len(defined)) < numValues {
+		return nil, fmt.Errorf("missing definition levels")
+	}
+	defined = defined[:numValues]
+
+	values := bytes.NewReader(page[4+levelsLength:])
+	var bits []byte
+	if column.Type == ParquetBool {
+		bits = page[4+levelsLength:]
+	}
+	result := make([]interface{}, numValues)
+	present := 0
+	for i, isDefined := range defined {
+		if !isDefined {
+			continue
+		}
+		var err error
+		switch column.Type {
+		case ParquetString:
+			var length uint32
+			if err = binary.Read(values, binary.LittleEndian, &length); err == nil {
+				text := make([]byte, length)
+				if _, err = io.ReadFull(values, text); err == nil {
+					result[i] = string(text)
+				}
+			}
+		case ParquetInt64:
+			var n int64
+			err = binary.Read(values, binary.LittleEndian, &n)
+			result[i] = n
+		case ParquetBool:
+			if present/8 >= len(bits) {
+				err = io.ErrUnexpectedEOF
+			} else {
+				result[i] = bits[present/8]&(1<<(present%8)) != 0
+			}
+		case ParquetDouble:
+			var f uint64
+			err = binary.Read(values, binary.LittleEndian, &f)
+			result[i] = math.Float64frombits(f)
+		case ParquetTimestamp:
+			var millis int64
+			err = binary.Read(values, binary.LittleEndian, &millis)
+			result[i] = time.UnixMilli(millis).UTC()
+		case ParquetDate:
+			var days int32
+			err = binary.Read(values, binary.LittleEndian, &days)
+			result[i] = time.Unix(int64(days)*86400, 0).UTC()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("truncated values")
+		}
+		present++
+	}
+	return result, nil
+}
+
+// thriftCompactReader decodes the Thrift compact protocol of Parquet footers and page headers, skipping the
+// fields it isn't asked for
+type thriftCompactReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *thriftCompactReader) readByte() byte {
+	if r.err != nil || r.pos >= len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftCompactReader) readUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+// readVarint reads a zigzag-encoded i16, i32 or i64
+func (r *thriftCompactReader) readVarint() int64 {
+	v := r.readUvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftCompactReader) readBinary() []byte {
+	length := r.readUvarint()
+	if r.err != nil || length > uint64(len(r.data)-r.pos) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	v := r.data[r.pos : r.pos+int(length)]
+	r.pos += int(length)
+	return v
+}
+
+// readStruct reads a struct's fields, calling field for each; fields it returns false for are skipped
+func (r *thriftCompactReader) readStruct(field func(id int16, fieldType byte) bool) {
+	var last int16
+	for r.err == nil {
+		header := r.readByte()
+		if header == 0 {
+			return
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.readVarint())
+		}
+		last = id
+		fieldType := header & 0x0F
+		if fieldType == thriftCompactTrue || fieldType == thriftCompactFalse {
+			continue
+		}
+		if !field(id, fieldType) {
+			r.skip(fieldType)
+		}
+	}
+}
+
+// readList reads a list's header, then calls element for each element
+func (r *thriftCompactReader) readList(element func(elementType byte)) {
+	header := r.readByte()
+	size := uint64(header >> 4)
+	if size == 15 {
+		size = r.readUvarint()
+	}
+	for i := uint64(0); i < size && r.err == nil; i++ {
+		element(header & 0x0F)
+	}
+}
+
+// skip reads past a value of a type
+func (r *thriftCompactReader) skip(valueType byte) {
+	switch valueType {
+	case thriftCompactTrue, thriftCompactFalse, thriftCompactByte:
+		r.readByte()
+	case thriftCompactI16, thriftCompactI32, thriftCompactI64:
+		r.readUvarint()
+	case thriftCompactDouble:
+		for i := 0; i < 8; i++ {
+			r.readByte()
+		}
+	case thriftCompactBinary:
+		r.readBinary()
+	case thriftCompactList, thriftCompactSet:
+		r.readList(func(elementType byte) { r.skip(elementType) })
+	case thriftCompactMap:
+		size := r.readUvarint()
+		if size > 0 {
+			types := r.readByte()
+			for i := uint64(0); i < size && r.err == nil; i++ {
+				r.skip(types >> 4)
+				r.skip(types & 0x0F)
+			}
+		}
+	case thriftCompactStruct:
+		r.readStruct(func(int16, byte) bool { return false })
+	default:
+		r.err = fmt.Errorf("unknown Thrift type %d", valueType)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// The permissions entry of config.json turns MCP tools and API endpoints off, or limits them to some API tokens,
+// on top of the capabilities and chats of the tokens. Keys are tool names (send_message) or API paths
+// (/api/send); a key ending with * covers everything it starts with (/api/groups/*), and the longest match wins.
+// Requests without a token, like the stdio MCP client, can't use what is limited to tokens.
+
+// lookupPermission returns the permission of a tool or path, and whether one is configured
+func lookupPermission(permissions map[string]PermissionConfig, name string) (PermissionConfig, bool) {
+	if permission, ok := permissions[name]; ok {
+		return permission, true
+	}
+	var best PermissionConfig
+	bestLength := -1
+	for key, permission := range permissions {
+		prefix, wildcard := strings.CutSuffix(key, "*")
+		if wildcard && strings.HasPrefix(name, prefix) && len(prefix) > bestLength {
+			best, bestLength = permission, len(prefix)
+		}
+	}
+	return best, bestLength >= 0
+}
+
+// checkPermission returns an error when the permissions keep a caller from using a tool or path; token is the
+// caller's API token, nil for requests without one. The config is parsed once (see loadPolicyConfig); if it
+// couldn't be read at first, everything is refused.
+func checkPermission(name string, token *APIToken) error {
+	config, err := loadPolicyConfig()
+	if err != nil {
+		return fmt.Errorf("permissions unavailable: %v", err)
+	}
+	permission, ok := lookupPermission(config.Permissions, name)
+	switch {
+	case !ok:
+		return nil
+	case permission.Disabled:
+		return fmt.Errorf("%s is disabled", name)
+	case permission.Tokens == nil:
+		return nil
+	case token == nil:
+		return fmt.Errorf("%s requires one of the API tokens it is limited to", name)
+	case !slices.Contains(permission.Tokens, token.Name):
+		return fmt.Errorf("token %q may not use %s", token.Name, name)
+	}
+	return nil
+}
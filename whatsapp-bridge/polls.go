@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// WhatsApp accepts between 2 and 12 options per poll
+const (
+	pollMinOptions = 2
+	pollMaxOptions = 12
+)
+
+// PollOptionTally is the votes one option of a poll received
+type PollOptionTally struct {
+	Option     string   `json:"option"`
+	Votes      int      `json:"votes"`
+	Voters     []string `json:"voters"`
+	VoterNames []string `json:"voter_names"` // contact names of the voters, in the same order; the number when unknown
+}
+
+// PollTally is a poll with its votes counted per option
+type PollTally struct {
+	PollID          string            `json:"poll_id"`
+	ChatJID         string            `json:"chat_jid"`
+	Question        string            `json:"question"`
+	SelectableCount int               `json:"selectable_count"` // 0 means any number of options
+	CreatedBy       string            `json:"created_by"`
+	CreatedAt       time.Time         `json:"created_at"`
+	Voters          int               `json:"voters"`
+	Options         []PollOptionTally `json:"options"`
+	Leading         []string          `json:"leading"` // the options with the most votes, several when tied; none without votes
+}
+
+// ensurePollTables creates the tables for polls and their votes.
+// Votes keep the hashes of the selected options, as WhatsApp sends them, and are matched to the poll's options when tallied.
+func ensurePollTables(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS polls (
+			message_id TEXT,
+			chat_jid TEXT,
+			question TEXT,
+			options TEXT,
+			selectable_count INTEGER,
+			created_by TEXT,
+			created_at TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid)
+		);
+
+		CREATE TABLE IF NOT EXISTS poll_votes (
+			poll_id TEXT,
+			chat_jid TEXT,
+			voter TEXT,
+			option_hashes TEXT,
+			timestamp TIMESTAMP,
+			PRIMARY KEY (poll_id, chat_jid, voter)
+		);
+	`)
+	return err
+}
+
+// getPollCreation returns the poll a message creates, whichever version of poll message it is
+func getPollCreation(msg *waProto.Message) *waProto.PollCreationMessage {
+	if poll := msg.GetPollCreationMessage(); poll != nil {
+		return poll
+	}
+	if poll := msg.GetPollCreationMessageV2(); poll != nil {
+		return poll
+	}
+	return msg.GetPollCreationMessageV3()
+}
+
+// pollOptionNames returns the option names of a poll, in order
+func pollOptionNames(poll *waProto.PollCreationMessage) []string {
+	var names []string
+	for _, option := range poll.GetOptions() {
+		names = append(names, option.GetOptionName())
+	}
+	return names
+}
+
+// formatPollContent renders a poll as the text stored for its message
+func formatPollContent(poll *waProto.PollCreationMessage) string {
+	return fmt.Sprintf("📊 Poll: %s (%s)", poll.GetName(), strings.Join(pollOptionNames(poll), " / "))
+}
+
+// StorePoll records a poll's question and options
+func (store *MessageStore) StorePoll(messageID, chatJID, question string, options []string, selectableCount int, createdBy string, createdAt time.Time) error {
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	// Questions and options are message text, encrypted like it
+	if question, err = store.seal(chatJID, question); err != nil {
+		return err
+	}
+	sealedOptions, err := store.seal(chatJID, string(encoded))
+	if err != nil {
+		return err
+	}
+	_, err = store.dbFor(chatJID).Exec(`
+		INSERT OR IGNORE INTO polls (message_id, chat_jid, question, options, selectable_count, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, messageID, chatJID, question, sealedOptions, selectableCount, createdBy, createdAt)
+	return err
+}
+
+// StorePollVote records a person's vote on a poll, replacing their previous one; an empty vote removes it
+func (store *MessageStore) StorePollVote(pollID, chatJID, voter string, optionHashes [][]byte, timestamp time.Time) error {
+	if len(optionHashes) == 0 {
+		_, err := store.dbFor(chatJID).Exec("DELETE FROM poll_votes WHERE poll_id = ? AND chat_jid = ? AND voter = ?", pollID, chatJID, voter)
+		return err
+	}
+	hashes := make([]string, len(optionHashes))
+	for i, hash := range optionHashes {
+		hashes[i] = hex.EncodeToString(hash)
+	}
+	encoded, err := json.Marshal(hashes)
+	if err != nil {
+		return err
+	}
+	// Votes can be delivered out of order, so older votes never win
+	_, err = store.dbFor(chatJID).Exec(`
+		INSERT INTO poll_votes (poll_id, chat_jid, voter, option_hashes, timestamp) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (poll_id, chat_jid, voter) DO UPDATE SET option_hashes = excluded.option_hashes, timestamp = excluded.timestamp
+		WHERE excluded.timestamp >= poll_votes.timestamp
+	`, pollID, chatJID, voter, string(encoded), timestamp)
+	return err
+}
+
+// GetPollTally counts the votes of a poll per option; it returns nil when the poll isn't stored
+func (store *MessageStore) GetPollTally(pollID, chatJID string) (*PollTally, error) {
+	tally := &PollTally{PollID: pollID, ChatJID: chatJID}
+	var options string
+	err := store.dbFor(chatJID).QueryRow(
+		"SELECT COALESCE(question, ''), COALESCE(options, '[]'), COALESCE(selectable_count, 0), COALESCE(created_by, ''), created_at FROM polls WHERE message_id = ? AND chat_jid = ?",
+		pollID, chatJID,
+	).Scan(&tally.Question, &options, &tally.SelectableCount, &tally.CreatedBy, &tally.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tally.Question = store.open(tally.Question)
+	var names []string
+	if err := json.Unmarshal([]byte(store.open(options)), &names); err != nil {
+		return nil, fmt.Errorf("invalid options of poll %s: %v", pollID, err)
+	}
+
+	// Votes name options by the SHA-256 of their text
+	byHash := make(map[string]int)
+	tally.Options = make([]PollOptionTally, len(names))
+	for i, name := range names {
+		hash := sha256.Sum256([]byte(name))
+		byHash[hex.EncodeToString(hash[:])] = i
+		tally.Options[i] = PollOptionTally{Option: name, Voters: []string{}, VoterNames: []string{}}
+	}
+
+	rows, err := store.dbFor(chatJID).Query("SELECT voter, option_hashes FROM poll_votes WHERE poll_id = ? AND chat_jid = ? ORDER BY timestamp", pollID, chatJID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	voterNames := make(map[string]string)
+	for rows.Next() {
+		var voter, encoded string
+		if err := rows.Scan(&voter, &encoded); err != nil {
+			return nil, err
+		}
+		var hashes []string
+		if err := json.Unmarshal([]byte(encoded), &hashes); err != nil {
+			continue
+		}
+		tally.Voters++
+		if _, ok := voterNames[voter]; !ok {
+			voterNames[voter] = lookupSenderName(store.db, voter)
+			if voterNames[voter] == "" {
+				voterNames[voter] = voter
+			}
+		}
+		for _, hash := range hashes {
+			if i, ok := byHash[hash]; ok {
+				tally.Options[i].Votes++
+				tally.Options[i].Voters = append(tally.Options[i].Voters, voter)
+				tally.Options[i].VoterNames = append(tally.Options[i].VoterNames, voterNames[voter])
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tally.Leading = []string{}
+	most := 0
+	for _, option := range tally.Options {
+		most = max(most, option.Votes)
+	}
+	for _, option := range tally.Options {
+		if most > 0 && option.Votes == most {
+			tally.Leading = append(tally.Leading, option.Option)
+		}
+	}
+	return tally, nil
+}
+
+// ListPolls returns the polls of a chat with their tallies, newest first
+func (store *MessageStore) ListPolls(chatJID string, limit int) ([]*PollTally, error) {
+	rows, err := store.dbFor(chatJID).Query("SELECT message_id FROM polls WHERE chat_jid = ? ORDER BY created_at DESC LIMIT ?", chatJID, limit)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	polls := []*PollTally{}
+	for _, id := range ids {
+		tally, err := store.GetPollTally(id, chatJID)
+		if err != nil {
+			return nil, err
+		}
+		if tally != nil {
+			polls = append(polls, tally)
+		}
+	}
+	return polls, nil
+}
+
+// recordPoll stores the options of a received poll, so its votes can be tallied
+func recordPoll(messageStore *MessageStore, messageID, chatJID, sender string, poll *waProto.PollCreationMessage, timestamp time.Time, logger waLog.Logger) {
+	err := messageStore.StorePoll(messageID, chatJID, poll.GetName(), pollOptionNames(poll), int(poll.GetSelectableOptionsCount()), sender, timestamp)
+	if err != nil {
+		logger.Warnf("Failed to store poll %s: %v", messageID, err)
+	}
+}
+
+// handlePollVote decrypts a poll vote and records it against its poll.
+// Decrypting needs the poll's secret, which whatsmeow keeps for the polls this device has seen.
+func handlePollVote(client *whatsmeow.Client, messageStore *MessageStore, chatJID, voter string, msg *events.Message, logger waLog.Logger) {
+	update := msg.Message.GetPollUpdateMessage()
+	pollID := update.GetPollCreationMessageKey().GetID()
+	if pollID == "" {
+		return
+	}
+
+	vote, err := client.DecryptPollVote(context.Background(), msg)
+	if err != nil {
+		logger.Warnf("Failed to decrypt vote on poll %s: %v", pollID, err)
+		return
+	}
+	timestamp := msg.Info.Timestamp
+	if ms := update.GetSenderTimestampMS(); ms > 0 {
+		timestamp = time.UnixMilli(ms)
+	}
+
+	if err := messageStore.StorePollVote(pollID, chatJID, voter, vote.GetSelectedOptions(), timestamp); err != nil {
+		logger.Warnf("Failed to store vote on poll %s: %v", pollID, err)
+		return
+	}
+	fmt.Printf("[%s] %s voted on poll %s (%d options)\n", timestamp.Format("2006-01-02 15:04:05"), voter, pollID, len(vote.GetSelectedOptions()))
+}
+
+// sendWhatsAppPoll sends a poll and records it; selectableCount is how many options each person may pick (0 for any number)
+func sendWhatsAppPoll(client *whatsmeow.Client, messageStore *MessageStore, recipient, question string, options []string, selectableCount int) (string, error) {
+	if readOnlyModeEnabled() {
+		return "", errReadOnly
+	}
+	if !client.IsConnected() {
+		return "", fmt.Errorf("not connected to WhatsApp")
+	}
+	recipientJID, err := parseRecipientJID(client, recipient)
+	if err != nil {
+		return "", fmt.Errorf("invalid recipient %s: %v", recipient, err)
+	}
+
+	question = strings.TrimSpace(question)
+	if question == "" {
+		return "", fmt.Errorf("question is required")
+	}
+	if len(options) < pollMinOptions || len(options) > pollMaxOptions {
+		return "", fmt.Errorf("a poll needs between %d and %d options", pollMinOptions, pollMaxOptions)
+	}
+	seen := make(map[string]bool)
+	for i, option := range options {
+		options[i] = strings.TrimSpace(option)
+		if options[i] == "" || seen[options[i]] {
+			return "", fmt.Errorf("options must be non-empty and distinct")
+		}
+		seen[options[i]] = true
+	}
+	if selectableCount < 0 || selectableCount > len(options) {
+		return "", fmt.Errorf("selectable_count must be between 0 and %d", len(options))
+	}
+
+	// Mark automated messages with the configured prefix, if any
+	question = applyBridgeMessagePrefix(question)
+	msg := client.BuildPollCreation(question, options, selectableCount)
+	sendResp, err := sendPaced(client, messageStore.dbFor(recipientJID.String()), recipientJID, msg)
+	if err != nil {
+		return "", fmt.Errorf("error sending poll: %v", err)
+	}
+
+	if err := messageStore.StoreSentMessage(client, sendResp.ID, recipientJID, msg, messageOriginBridgeAPI); err != nil {
+		fmt.Printf("Failed to store sent poll: %v\n", err)
+	}
+	createdBy := ""
+	if client.Store.ID != nil {
+		createdBy = client.Store.ID.User
+	}
+	if err := messageStore.StorePoll(sendResp.ID, recipientJID.String(), question, options, selectableCount, createdBy, sendResp.Timestamp); err != nil {
+		fmt.Printf("Failed to store sent poll: %v\n", err)
+	}
+	return sendResp.ID, nil
+}
+
+// SendPollRequest is the request body for the send poll API
+type SendPollRequest struct {
+	Recipient       string   `json:"recipient"`
+	Question        string   `json:"question"`
+	Options         []string `json:"options"`
+	SelectableCount int      `json:"selectable_count"` // 0 means any number of options
+}
+
+// handleSendPollAPI sends a poll (POST /api/polls/send)
+func handleSendPollAPI(client *whatsmeow.Client, messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SendPollRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Recipient == "" || req.Question == "" {
+			http.Error(w, "recipient and question are required", http.StatusBadRequest)
+			return
+		}
+		if !strings.Contains(req.Recipient, "@") && req.Recipient != "self" {
+			req.Recipient = normalizePhoneRecipient(req.Recipient)
+		}
+		if !apiRequestAllowsChat(r, req.Recipient) {
+			rejectChat(w, r, req.Recipient)
+			return
+		}
+		if rejectedBySendPolicy(w, r, client, messageStore, sendKindPoll, "", req.Recipient, req.Question) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if isSafeModeActive() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(SendMessageResponse{
+				Success: false,
+				Message: "Safe mode is on, outbound messages are disabled",
+			})
+			return
+		}
+
+		pollID, err := sendWhatsAppPoll(client, messageStore, req.Recipient, req.Question, req.Options, req.SelectableCount)
+		auditAPISend(messageStore, r, sendKindPoll, req.Recipient, req.Question, pollID, err)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"message": fmt.Sprintf("Poll sent to %s", req.Recipient),
+			"poll_id": pollID,
+		})
+	}
+}
+
+// handlePollsAPI lists a chat's polls with their tallies (GET /api/polls?chat_jid=...&limit=...)
+func handlePollsAPI(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		if chatJID == "" {
+			http.Error(w, "chat_jid is required", http.StatusBadRequest)
+			return
+		}
+		if !apiRequestAllowsChat(r, chatJID) {
+			rejectChat(w, r, chatJID)
+			return
+		}
+		limit := 20
+		if value, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && value > 0 {
+			limit = value
+		}
+
+		polls, err := messageStore.ListPolls(chatJID, limit)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list polls: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(polls)
+	}
+}
+
+// handlePollTallyAPI counts the votes of a poll (GET /api/polls/tally?chat_jid=...&poll_id=...)
+func handlePollTallyAPI(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		pollID := r.URL.Query().Get("poll_id")
+		if chatJID == "" || pollID == "" {
+			http.Error(w, "chat_jid and poll_id are required", http.StatusBadRequest)
+			return
+		}
+		if !apiRequestAllowsChat(r, chatJID) {
+			rejectChat(w, r, chatJID)
+			return
+		}
+
+		tally, err := messageStore.GetPollTally(pollID, chatJID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to tally poll: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if tally == nil {
+			http.Error(w, "Poll not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tally)
+	}
+}
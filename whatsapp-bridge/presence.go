@@ -0,0 +1,292 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// A typing indicator expires after about 25 seconds unless it is sent again
+const typingRefreshInterval = 10 * time.Second
+
+// ContactPresence is the last known presence of a contact
+type ContactPresence struct {
+	JID       string     `json:"jid"`
+	Online    bool       `json:"online"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"` // empty when the contact hides it
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// ensurePresenceTable creates the table with the last known presence of each contact
+func ensurePresenceTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS contact_presence (
+			jid TEXT PRIMARY KEY,
+			online BOOLEAN,
+			last_seen TIMESTAMP,
+			updated_at TIMESTAMP
+		);
+	`)
+	return err
+}
+
+// getPresenceSubscriptions returns the contacts whose presence is requested on connect (PRESENCE_SUBSCRIBE)
+func getPresenceSubscriptions() []string {
+	var jids []string
+	for _, jid := range strings.Split(os.Getenv("PRESENCE_SUBSCRIBE"), ",") {
+		if jid = strings.TrimSpace(jid); jid != "" {
+			jids = append(jids, jid)
+		}
+	}
+	return jids
+}
+
+// typingIndicatorsEnabled reports whether "typing..." is shown while a bot reply is written (TYPING_INDICATORS, on by default)
+func typingIndicatorsEnabled() bool {
+	return os.Getenv("TYPING_INDICATORS") != "false"
+}
+
+// StorePresence records a contact coming online or going offline; a hidden last seen keeps the previous one
+func (store *MessageStore) StorePresence(jid string, online bool, lastSeen, updatedAt time.Time) error {
+	var seen interface{}
+	if !lastSeen.IsZero() {
+		seen = lastSeen
+	} else if online {
+		seen = updatedAt
+	}
+	_, err := store.db.Exec(`
+		INSERT INTO contact_presence (jid, online, last_seen, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (jid) DO UPDATE SET
+			online = excluded.online,
+			last_seen = COALESCE(excluded.last_seen, contact_presence.last_seen),
+			updated_at = excluded.updated_at
+	`, jid, online, seen, updatedAt)
+	return err
+}
+
+// GetPresence returns the last known presence of a contact; it returns nil when none was received
+func (store *MessageStore) GetPresence(jid string) (*ContactPresence, error) {
+	presence := &ContactPresence{JID: jid}
+	var lastSeen sql.NullTime
+	err := store.db.QueryRow(
+		"SELECT online, last_seen, updated_at FROM contact_presence WHERE jid = ?", jid,
+	).Scan(&presence.Online, &lastSeen, &presence.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if lastSeen.Valid {
+		presence.LastSeen = &lastSeen.Time
+	}
+	return presence, nil
+}
+
+// handlePresence records the online and last-seen updates of the contacts subscribed to
+func handlePresence(messageStore *MessageStore, evt *events.Presence, logger waLog.Logger) {
+	jid := evt.From.ToNonAD().String()
+	if err := messageStore.StorePresence(jid, !evt.Unavailable, evt.LastSeen, time.Now()); err != nil {
+		logger.Warnf("Failed to store presence of %s: %v", jid, err)
+	}
+}
+
+// subscribePresence asks WhatsApp for a contact's presence updates; they only arrive while this account is available
+func subscribePresence(client *whatsmeow.Client, recipient string) error {
+	jid, err := parseRecipientJID(client, recipient)
+	if err != nil {
+		return err
+	}
+	if jid.Server == types.GroupServer {
+		return fmt.Errorf("presence is only available for contacts, not groups")
+	}
+	return client.SubscribePresence(jid)
+}
+
+// subscribeConfiguredPresences subscribes to the contacts in PRESENCE_SUBSCRIBE, after each connect
+func subscribeConfiguredPresences(client *whatsmeow.Client, logger waLog.Logger) {
+	for _, jid := range getPresenceSubscriptions() {
+		if err := subscribePresence(client, jid); err != nil {
+			logger.Warnf("Failed to subscribe to presence of %s: %v", jid, err)
+		}
+	}
+}
+
+// startTyping shows "typing..." in a chat until the returned function is called, e.g. while an LLM writes a reply
+func startTyping(client *whatsmeow.Client, chat types.JID, logger waLog.Logger) (stop func()) {
+	if !typingIndicatorsEnabled() || isSafeModeActive() || readOnlyModeEnabled() {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(typingRefreshInterval)
+		defer ticker.Stop()
+		for {
+			if err := client.SendChatPresence(chat, types.ChatPresenceComposing, types.ChatPresenceMediaText); err != nil {
+				logger.Debugf("Failed to send typing indicator to %s: %v", chat, err)
+			}
+			select {
+			case <-done:
+				client.SendChatPresence(chat, types.ChatPresencePaused, types.ChatPresenceMediaText)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// PresenceRequest is the request body for the presence API
+type PresenceRequest struct {
+	Presence string `json:"presence"` // available or unavailable
+}
+
+// handlePresenceAPI returns a contact's last known presence (GET /api/presence?jid=...)
+func handlePresenceAPI(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		jid := r.URL.Query().Get("jid")
+		if jid == "" {
+			http.Error(w, "jid is required", http.StatusBadRequest)
+			return
+		}
+		if !apiRequestAllowsChat(r, jid) {
+			rejectChat(w, r, jid)
+			return
+		}
+		presence, err := messageStore.GetPresence(jid)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get presence: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if presence == nil {
+			http.Error(w, "No presence received for this contact; subscribe to it first", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(presence)
+	}
+}
+
+// handleSetPresenceAPI sets this account's presence (POST /api/presence/set with {"presence": "available"})
+func handleSetPresenceAPI(client *whatsmeow.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req PresenceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		state := types.Presence(req.Presence)
+		if state != types.PresenceAvailable && state != types.PresenceUnavailable {
+			http.Error(w, "presence must be available or unavailable", http.StatusBadRequest)
+			return
+		}
+		if err := client.SendPresence(state); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to set presence: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: fmt.Sprintf("Presence set to %s", state)})
+	}
+}
+
+// PresenceSubscribeRequest is the request body for the presence subscribe API
+type PresenceSubscribeRequest struct {
+	JID string `json:"jid"`
+}
+
+// handlePresenceSubscribeAPI starts recording a contact's online and last-seen updates (POST /api/presence/subscribe)
+func handlePresenceSubscribeAPI(client *whatsmeow.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req PresenceSubscribeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.JID == "" {
+			http.Error(w, "jid is required", http.StatusBadRequest)
+			return
+		}
+		if !apiRequestAllowsChat(r, req.JID) {
+			rejectChat(w, r, req.JID)
+			return
+		}
+		if err := subscribePresence(client, req.JID); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to subscribe to presence: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: fmt.Sprintf("Subscribed to presence of %s", req.JID)})
+	}
+}
+
+// TypingRequest is the request body for the typing API
+type TypingRequest struct {
+	ChatJID string `json:"chat_jid"`
+	State   string `json:"state"` // composing (the default), recording or paused
+}
+
+// handleTypingAPI shows or clears the typing indicator in a chat (POST /api/typing).
+// WhatsApp clears it by itself after about 25 seconds, so send it again for longer replies.
+func handleTypingAPI(client *whatsmeow.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req TypingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChatJID == "" {
+			http.Error(w, "chat_jid is required", http.StatusBadRequest)
+			return
+		}
+		if !apiRequestAllowsChat(r, req.ChatJID) {
+			rejectChat(w, r, req.ChatJID)
+			return
+		}
+
+		state, media := types.ChatPresenceComposing, types.ChatPresenceMediaText
+		switch req.State {
+		case "", "composing":
+			req.State = "composing"
+		case "recording":
+			media = types.ChatPresenceMediaAudio
+		case "paused":
+			state = types.ChatPresencePaused
+		default:
+			http.Error(w, "state must be composing, recording or paused", http.StatusBadRequest)
+			return
+		}
+
+		chat, err := parseRecipientJID(client, req.ChatJID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid chat_jid: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := client.SendChatPresence(chat, state, media); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to send typing indicator: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: fmt.Sprintf("Typing indicator %s sent to %s", req.State, req.ChatJID)})
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Placeholders filled after the template is rendered (they depend on per-call state and are appended when missing)
+var postRenderPlaceholders = map[string]bool{
+	"ANNOTATIONS":    true,
+	"SUMMARY_LENGTH": true,
+	"STATE_OF_PLAY":  true,
+}
+
+// legacyPlaceholderPattern matches the original {{NAME}} placeholders
+var legacyPlaceholderPattern = regexp.MustCompile(`\{\{\s*([A-Z][A-Z0-9_]*)\s*\}\}`)
+
+// promptTemplateFuncs are the helper functions available in prompt templates
+var promptTemplateFuncs = template.FuncMap{
+	// truncate shortens text to n characters, adding "…" when cut
+	"truncate": func(n int, text string) string {
+		runes := []rune(text)
+		if len(runes) <= n {
+			return text
+		}
+		return string(runes[:n]) + "…"
+	},
+	// formatDate formats a time or a YYYY-MM-DD / RFC 3339 string with a Go layout
+	"formatDate": func(layout string, value interface{}) (string, error) {
+		switch v := value.(type) {
+		case time.Time:
+			return v.Format(layout), nil
+		case string:
+			for _, inputLayout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+				if t, err := time.Parse(inputLayout, v); err == nil {
+					return t.Format(layout), nil
+				}
+			}
+			return "", fmt.Errorf("formatDate: can't parse %q", v)
+		default:
+			return "", fmt.Errorf("formatDate: unsupported value %T", value)
+		}
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+	"join": func(sep string, items []string) string {
+		return strings.Join(items, sep)
+	},
+	"add": func(a, b int) int {
+		return a + b
+	},
+	"json": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
+
+// translateLegacyPlaceholders turns {{NAME}} into {{.NAME}} when NAME is provided, and keeps the
+// post-render placeholders as literal text for the steps that fill them later
+func translateLegacyPlaceholders(source string, data map[string]interface{}) string {
+	return legacyPlaceholderPattern.ReplaceAllStringFunc(source, func(match string) string {
+		name := legacyPlaceholderPattern.FindStringSubmatch(match)[1]
+		if _, ok := data[name]; ok {
+			return "{{." + name + "}}"
+		}
+		if postRenderPlaceholders[name] {
+			return `{{"{{` + name + `}}"}}`
+		}
+		return match
+	})
+}
+
+// parsePromptTemplate parses a prompt file with text/template
+func parsePromptTemplate(name, source string, data map[string]interface{}) (*template.Template, error) {
+	return template.New(name).
+		Funcs(promptTemplateFuncs).
+		Option("missingkey=error").
+		Parse(translateLegacyPlaceholders(source, data))
+}
+
+// renderPrompt renders a prompt template with the given data.
+// Templates can use {{.Field}}, conditionals, loops and the helper functions; the original {{NAME}} placeholders keep working.
+func renderPrompt(name, source string, data map[string]interface{}) (string, error) {
+	tmpl, err := parsePromptTemplate(name, source, data)
+	if err != nil {
+		return "", fmt.Errorf("invalid prompt template %s: %v", name, err)
+	}
+
+	var prompt strings.Builder
+	if err := tmpl.Execute(&prompt, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt template %s: %v", name, err)
+	}
+	return prompt.String(), nil
+}
+
+// promptMessagesData returns the message fields shared by the summary and segmentation templates
+func promptMessagesData(messages []DailySummaryMessage, date string) map[string]interface{} {
+	return map[string]interface{}{
+		"Date":             date,
+		"Messages":         messages,
+		"MessageCount":     len(messages),
+		"EntityTypes":      getEntityTypesPromptText(),
+		"ContinuesSession": false,
+		"DATE":             date,
+		"ENTITY_TYPES":     getEntityTypesPromptText(),
+	}
+}
+
+// samplePromptData returns data for validating each prompt template before it is used
+func samplePromptData(path string) map[string]interface{} {
+	date := time.Now().Format("2006-01-02")
+	messages := []DailySummaryMessage{{Timestamp: date + " 09:00:00", Sender: "Sample", Content: "Sample message"}}
+
+	switch path {
+	case "prompts/add-episode.md":
+		data := addEpisodePromptData("sample-uuid", date+" - sample", "sample", "Sample group", "sample_group", date, "Sample: Sample message", "sample")
+		data["ContinuesSession"] = false
+		return data
+	case "prompts/topic-segmentation.md":
+		data := promptMessagesData(messages, date)
+		data["MESSAGES"] = "[]"
+		return data
+	default:
+		data := promptMessagesData(messages, date)
+		data["GroupJID"] = "sample@g.us"
+		data["MESSAGES"] = ""
+		return data
+	}
+}
+
+// validatePromptTemplates parses and test-renders the prompt files that exist, so a broken template
+// fails at startup instead of in the middle of a run
+func validatePromptTemplates() error {
+	var problems []string
+	for _, path := range []string{"prompts/daily-summary.md", "prompts/topic-segmentation.md", "prompts/add-episode.md"} {
+		source, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+			continue
+		}
+		if _, err := renderPrompt(path, string(source), samplePromptData(path)); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// A reply only counts towards response latency when it comes within this window
+	pulseMaxResponseGap = 6 * time.Hour
+	// Response latency at or above this scores zero
+	pulseSlowResponse = 12 * time.Hour
+)
+
+// PulseMetrics are the raw numbers behind a group's pulse for one week
+type PulseMetrics struct {
+	Messages              int     `json:"messages"`
+	Participants          int     `json:"participants"`
+	ParticipationBreadth  float64 `json:"participation_breadth"` // 0-1, how evenly messages are spread over participants
+	MedianResponseMinutes float64 `json:"median_response_minutes"`
+	Sentiment             float64 `json:"sentiment"` // -1 (negative) to 1 (positive)
+	Score                 int     `json:"score"`     // 0-100
+}
+
+// GroupPulse is a group's weekly health score with the previous week for comparison
+type GroupPulse struct {
+	GroupJID      string       `json:"group_jid"`
+	Name          string       `json:"name"`
+	WeekStart     time.Time    `json:"week_start"`
+	WeekEnd       time.Time    `json:"week_end"`
+	ActivityTrend float64      `json:"activity_trend"` // relative change in messages vs the previous week
+	Current       PulseMetrics `json:"current"`
+	Previous      PulseMetrics `json:"previous"`
+}
+
+// Small sentiment lexicon (Portuguese and English) for a cheap mood signal without an LLM
+var (
+	pulsePositiveWords = toWordSet("bom boa ótimo ótima otimo otima excelente parabéns parabens obrigado obrigada valeu legal show top perfeito perfeita feliz massa incrível incrivel sucesso adorei amei concordo beleza good great excellent thanks thank awesome congrats congratulations perfect happy love nice agree success")
+	pulseNegativeWords = toWordSet("ruim péssimo pessimo problema problemas erro errado atraso atrasado difícil dificil triste preocupado preocupada infelizmente chato cancelado cancelada reclamação reclamacao urgente falha bad terrible problem issue error wrong late delay sad worried unfortunately cancelled canceled complaint urgent failure broken")
+)
+
+// toWordSet splits a space-separated word list into a set
+func toWordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+// computeGroupPulse computes the pulse of a group for the week ending at weekEnd, compared with the week before
+func computeGroupPulse(db *sql.DB, groupJID string, weekEnd time.Time) (*GroupPulse, error) {
+	weekStart := weekEnd.AddDate(0, 0, -7)
+	current, err := computePulseMetrics(db, groupJID, weekStart, weekEnd)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := computePulseMetrics(db, groupJID, weekStart.AddDate(0, 0, -7), weekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	pulse := &GroupPulse{
+		GroupJID:  groupJID,
+		WeekStart: weekStart,
+		WeekEnd:   weekEnd,
+		Current:   *current,
+		Previous:  *previous,
+	}
+	db.QueryRow("SELECT COALESCE(name, '') FROM chats WHERE jid = ?", groupJID).Scan(&pulse.Name)
+	if pulse.Name == "" {
+		pulse.Name = groupJID
+	}
+
+	if previous.Messages > 0 {
+		pulse.ActivityTrend = float64(current.Messages-previous.Messages) / float64(previous.Messages)
+	} else if current.Messages > 0 {
+		pulse.ActivityTrend = 1
+	}
+
+	pulse.Current.Score = pulseScore(*current, pulse.ActivityTrend)
+	// The previous week is scored without a trend of its own, only for the direction of the score
+	pulse.Previous.Score = pulseScore(*previous, 0)
+	return pulse, nil
+}
+
+// computePulseMetrics reads a group's messages in [start, end) and computes the pulse metrics
+func computePulseMetrics(db *sql.DB, groupJID string, start, end time.Time) (*PulseMetrics, error) {
+	if err := ensureMessageTypeColumn(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT timestamp, sender, COALESCE(content, '') FROM messages
+		WHERE chat_jid = ? AND timestamp >= ? AND timestamp < ?
+			AND COALESCE(message_type, '') = '' AND COALESCE(origin, '') = ''
+		ORDER BY timestamp
+	`, groupJID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := &PulseMetrics{}
+	perSender := make(map[string]int)
+	var responseGaps []float64
+	var lastSender string
+	var lastTime time.Time
+	var positive, negative int
+
+	for rows.Next() {
+		var timestamp time.Time
+		var sender, content string
+		if err := rows.Scan(&timestamp, &sender, &content); err != nil {
+			return nil, err
+		}
+
+		metrics.Messages++
+		perSender[sender]++
+
+		// Time until someone else answered
+		if lastSender != "" && sender != lastSender {
+			if gap := timestamp.Sub(lastTime); gap <= pulseMaxResponseGap {
+				responseGaps = append(responseGaps, gap.Minutes())
+			}
+		}
+		lastSender = sender
+		lastTime = timestamp
+
+		for _, word := range strings.FieldsFunc(strings.ToLower(content), func(r rune) bool { return !unicode.IsLetter(r) }) {
+			if pulsePositiveWords[word] {
+				positive++
+			} else if pulseNegativeWords[word] {
+				negative++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	metrics.Participants = len(perSender)
+	metrics.ParticipationBreadth = participationBreadth(perSender, metrics.Messages)
+	if len(responseGaps) > 0 {
+		sort.Float64s(responseGaps)
+		metrics.MedianResponseMinutes = math.Round(responseGaps[len(responseGaps)/2]*10) / 10
+	}
+	if positive+negative > 0 {
+		metrics.Sentiment = math.Round(float64(positive-negative)/float64(positive+negative)*100) / 100
+	}
+	return metrics, nil
+}
+
+// participationBreadth is the normalized entropy of messages per sender: 1 when everyone writes equally, 0 for a monologue
+func participationBreadth(perSender map[string]int, total int) float64 {
+	if len(perSender) < 2 || total == 0 {
+		return 0
+	}
+	entropy := 0.0
+	for _, count := range perSender {
+		p := float64(count) / float64(total)
+		entropy -= p * math.Log(p)
+	}
+	return math.Round(entropy/math.Log(float64(len(perSender)))*100) / 100
+}
+
+// pulseScore combines the metrics into a 0-100 health score
+func pulseScore(metrics PulseMetrics, activityTrend float64) int {
+	if metrics.Messages == 0 {
+		return 0
+	}
+
+	// Growing activity scores above 0.5, shrinking below (capped at ±100%)
+	activity := 0.5 + math.Max(-1, math.Min(1, activityTrend))/2
+
+	latency := 0.5 // no replies to measure
+	if metrics.MedianResponseMinutes > 0 {
+		latency = 1 - math.Min(1, metrics.MedianResponseMinutes/pulseSlowResponse.Minutes())
+	}
+
+	sentiment := (metrics.Sentiment + 1) / 2
+
+	score := 0.3*activity + 0.25*latency + 0.25*metrics.ParticipationBreadth + 0.2*sentiment
+	return int(math.Round(score * 100))
+}
+
+// pulseArrow shows the direction of a change
+func pulseArrow(current, previous float64) string {
+	switch {
+	case current > previous:
+		return "↑"
+	case current < previous:
+		return "↓"
+	default:
+		return "→"
+	}
+}
+
+// formatPulseLine renders the one-line pulse of a group for the weekly digest
+func formatPulseLine(pulse *GroupPulse) string {
+	current, previous := pulse.Current, pulse.Previous
+
+	mood := "😐"
+	if current.Sentiment > 0.2 {
+		mood = "🙂"
+	} else if current.Sentiment < -0.2 {
+		mood = "🙁"
+	}
+
+	response := "no replies"
+	if current.MedianResponseMinutes > 0 {
+		response = fmt.Sprintf("replies in %s %s", formatPulseMinutes(current.MedianResponseMinutes),
+			// Faster replies are better, so the arrow follows the speed
+			pulseArrow(previous.MedianResponseMinutes, current.MedianResponseMinutes))
+	}
+
+	return fmt.Sprintf("%s %d %s · %d msgs %s (%+.0f%%) · %s · %d people %s · mood %s %s",
+		pulse.Name,
+		current.Score, pulseArrow(float64(current.Score), float64(previous.Score)),
+		current.Messages, pulseArrow(float64(current.Messages), float64(previous.Messages)), pulse.ActivityTrend*100,
+		response,
+		current.Participants, pulseArrow(float64(current.Participants), float64(previous.Participants)),
+		mood, pulseArrow(current.Sentiment, previous.Sentiment))
+}
+
+// formatPulseMinutes renders a duration in minutes as "45m" or "3.5h"
+func formatPulseMinutes(minutes float64) string {
+	if minutes < 60 {
+		return fmt.Sprintf("%.0fm", minutes)
+	}
+	return fmt.Sprintf("%.1fh", minutes/60)
+}
+
+// listActiveGroups returns the groups with messages since a time, most active first
+func listActiveGroups(db *sql.DB, since time.Time) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT chat_jid FROM messages
+		WHERE chat_jid LIKE '%@g.us' AND timestamp >= ?
+		GROUP BY chat_jid
+		ORDER BY COUNT(*) DESC
+	`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []string
+	for rows.Next() {
+		var groupJID string
+		if err := rows.Scan(&groupJID); err != nil {
+			return nil, err
+		}
+		groups = append(groups, groupJID)
+	}
+	return groups, rows.Err()
+}
+
+// getPulseWeekEnd returns the end of the last full week: the most recent midnight in the location
+func getPulseWeekEnd(loc *time.Location) time.Time {
+	now := time.Now().In(loc)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+}
+
+// handlePulseAPI serves GET /api/analytics/pulse?chat_jid=...&week_end=YYYY-MM-DD&timezone=...
+// (every group active in the last two weeks when chat_jid is omitted)
+func handlePulseAPI(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		timezone := query.Get("timezone")
+		if timezone == "" {
+			timezone = os.Getenv("DAILY_SUMMARY_TIMEZONE")
+		}
+		if timezone == "" {
+			timezone = "UTC"
+		}
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid timezone: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		weekEnd := getPulseWeekEnd(loc)
+		if value := query.Get("week_end"); value != "" {
+			if weekEnd, err = time.ParseInLocation("2006-01-02", value, loc); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid week_end: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		groups := []string{query.Get("chat_jid")}
+		if groups[0] == "" {
+			if groups, err = listActiveGroups(db, weekEnd.AddDate(0, 0, -14)); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to list groups: %v", err), http.StatusInternalServerError)
+				return
+			}
+		} else if !apiRequestAllowsChat(r, groups[0]) {
+			rejectChat(w, r, groups[0])
+			return
+		}
+
+		// Scoped tokens only see their own groups
+		var allowed []string
+		for _, groupJID := range groups {
+			if apiRequestAllowsChat(r, groupJID) {
+				allowed = append(allowed, groupJID)
+			}
+		}
+		groups = allowed
+
+		pulses := []*GroupPulse{}
+		for _, groupJID := range groups {
+			pulse, err := computeGroupPulse(db, groupJID, weekEnd)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to compute pulse of %s: %v", groupJID, err), http.StatusInternalServerError)
+				return
+			}
+			pulses = append(pulses, pulse)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pulses)
+	}
+}
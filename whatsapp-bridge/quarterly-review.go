@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+var (
+	reviewGroupJID = flag.String("group-jid", os.Getenv("DAILY_SUMMARY_GROUP_JID"), "Group JID or name (defaults to DAILY_SUMMARY_GROUP_JID)")
+	reviewQuarter  = flag.String("quarter", "", "Quarter to review, e.g. 2026-Q3 (defaults to the last completed quarter)")
+	reviewSendTo   = flag.String("send-to", os.Getenv("QUARTERLY_REVIEW_SEND_TO"), "Recipient JID or \"self\" (defaults to QUARTERLY_REVIEW_SEND_TO, then self)")
+	reviewDryRun   = flag.Bool("dry-run", false, "Print the review instead of saving and sending it")
+)
+
+const (
+	reviewsDir = "store/reviews"
+
+	// Daily summaries beyond this many characters are left out of the prompt, oldest first
+	reviewMaxSummaryChars = 80000
+
+	// Facts requested from Graphiti for each review query
+	reviewMaxFactsPerQuery = 25
+)
+
+// reviewGraphitiQueries are the searches run against the knowledge graph for a quarter's review
+var reviewGraphitiQueries = []string{
+	"decisions made and their outcomes",
+	"metrics, numbers, valuations and targets",
+	"open questions, pending actions and unresolved issues",
+}
+
+var quarterPattern = regexp.MustCompile(`^(\d{4})-Q([1-4])$`)
+
+const defaultQuarterlyReviewPrompt = `You are an executive assistant writing the end-of-quarter review of a WhatsApp group ({{.GroupName}}) for {{.Quarter}} ({{.StartDate}} to {{.EndDate}}).
+
+Using the material below, write a Markdown document with:
+
+1. **Quarter in Review**: the main themes and how they evolved
+2. **Key Decisions**: what was decided, when, and what came of it
+3. **Metrics**: numbers, valuations and targets mentioned, and how they changed
+4. **Open Items**: actions and questions still unresolved at the end of the quarter
+5. **Looking Ahead**: what to watch or follow up on next quarter
+
+Be direct and concise. Cite dates whenever you can. Don't invent facts that aren't in the material.
+
+{{if .Facts}}## Knowledge graph facts
+{{.Facts}}
+
+{{end}}{{if .Decisions}}## Decisions recorded in daily summaries
+{{.Decisions}}
+
+{{end}}{{if .OpenItems}}## Open items at the end of the quarter
+{{.OpenItems}}
+
+{{end}}{{if .Topics}}## Topics discussed
+{{.Topics}}
+
+{{end}}## Daily summaries
+{{.Summaries}}`
+
+func main() {
+	flag.Parse()
+
+	logger := newLogger("QuarterlyReview", "INFO")
+	logger.Infof("Starting quarterly review...")
+
+	if exitIfSafeMode(logger) {
+		return
+	}
+
+	timezone := os.Getenv("DAILY_SUMMARY_TIMEZONE")
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		logger.Errorf("Failed to load timezone %s: %v", timezone, err)
+		loc = time.UTC
+	}
+
+	if *reviewGroupJID == "" {
+		logger.Errorf("No group given (use --group-jid or set DAILY_SUMMARY_GROUP_JID)")
+		os.Exit(1)
+	}
+	groupJID, err := resolveGroupReference(*reviewGroupJID)
+	if err != nil {
+		logger.Errorf("Failed to resolve group %s: %v", *reviewGroupJID, err)
+		os.Exit(1)
+	}
+
+	quarter := *reviewQuarter
+	if quarter == "" {
+		quarter = previousQuarter(time.Now().In(loc))
+	}
+	start, end, err := quarterRange(quarter, loc)
+	if err != nil {
+		logger.Errorf("%v", err)
+		os.Exit(1)
+	}
+	startDate, endDate := start.Format("2006-01-02"), end.AddDate(0, 0, -1).Format("2006-01-02")
+	groupName := getGroupName(groupJID, logger)
+	logger.Infof("Reviewing %s (%s) for %s", groupName, groupJID, quarter)
+
+	summaries, sidecars := loadQuarterSummaries(groupJID, start, end, logger)
+	if len(summaries) == 0 {
+		logger.Infof("No daily summaries for %s in %s, nothing to review", groupJID, quarter)
+		return
+	}
+
+	data := map[string]interface{}{
+		"GroupName": groupName,
+		"GroupJID":  groupJID,
+		"Quarter":   quarter,
+		"StartDate": startDate,
+		"EndDate":   endDate,
+		"Summaries": strings.Join(summaries, "\n\n"),
+		"Decisions": formatQuarterDecisions(sidecars),
+		"OpenItems": formatQuarterOpenItems(sidecars),
+		"Topics":    loadQuarterTopics(groupJID, startDate, endDate, logger),
+		"Facts":     loadQuarterFacts(groupJID, start, end, logger),
+	}
+
+	promptPath := "prompts/quarterly-review.md"
+	promptTemplate := defaultQuarterlyReviewPrompt
+	if promptBytes, err := os.ReadFile(promptPath); err == nil {
+		promptTemplate = string(promptBytes)
+	}
+	prompt, err := renderPrompt(promptPath, promptTemplate, data)
+	if err != nil {
+		logger.Errorf("Failed to render prompt: %v", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Infof("Generating review from %d daily summaries...", len(summaries))
+	review, err := callLLM(withLLMPurpose(withLLMAuditScope(ctx, groupJID, quarter), "quarterly_review"), prompt)
+	if err != nil {
+		logger.Errorf("Failed to generate quarterly review: %v", err)
+		os.Exit(1)
+	}
+
+	if *reviewDryRun {
+		fmt.Println(review)
+		return
+	}
+
+	path := reviewPath(groupJID, quarter)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logger.Errorf("Failed to create reviews directory: %v", err)
+		os.Exit(1)
+	}
+	document := fmt.Sprintf("# %s — %s review\n\n_%s to %s_\n\n%s\n", groupName, quarter, startDate, endDate, strings.TrimSpace(review))
+	if err := os.WriteFile(path, []byte(document), 0644); err != nil {
+		logger.Errorf("Failed to save quarterly review: %v", err)
+		os.Exit(1)
+	}
+	logger.Infof("Quarterly review saved to %s", path)
+
+	sendTo := *reviewSendTo
+	if sendTo == "" {
+		sendTo = "self"
+	}
+	caption := fmt.Sprintf("📘 *%s review* — %s", quarter, groupName)
+	if err := sendFileToRecipient(path, caption, sendTo, logger); err != nil {
+		logger.Errorf("Failed to send quarterly review: %v", err)
+		os.Exit(1)
+	}
+	logger.Infof("Quarterly review sent")
+}
+
+// previousQuarter returns the last completed quarter before a time, e.g. 2026-Q2 during July 2026
+func previousQuarter(now time.Time) string {
+	year, quarter := now.Year(), (int(now.Month())-1)/3
+	if quarter == 0 {
+		year, quarter = year-1, 4
+	}
+	return fmt.Sprintf("%d-Q%d", year, quarter)
+}
+
+// quarterRange returns the start of a quarter and the start of the next one
+func quarterRange(quarter string, loc *time.Location) (time.Time, time.Time, error) {
+	match := quarterPattern.FindStringSubmatch(quarter)
+	if match == nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid quarter %q (expected e.g. 2026-Q3)", quarter)
+	}
+	year, _ := strconv.Atoi(match[1])
+	number, _ := strconv.Atoi(match[2])
+	start := time.Date(year, time.Month(3*(number-1)+1), 1, 0, 0, 0, 0, loc)
+	return start, start.AddDate(0, 3, 0), nil
+}
+
+// reviewPath returns where a group's review of a quarter is saved
+func reviewPath(groupJID, quarter string) string {
+	dir := strings.NewReplacer("@", "_", ":", "_", "/", "_").Replace(groupJID)
+	return filepath.Join(reviewsDir, dir, quarter+".md")
+}
+
+// loadQuarterSummaries returns the daily summaries of the quarter, each headed by its date, and their sidecars.
+// When they don't all fit in reviewMaxSummaryChars the most recent ones are kept.
+func loadQuarterSummaries(groupJID string, start, end time.Time, logger waLog.Logger) ([]string, []*SummarySidecar) {
+	var summaries []string
+	var sidecars []*SummarySidecar
+	total := 0
+	for day := end.AddDate(0, 0, -1); !day.Before(start); day = day.AddDate(0, 0, -1) {
+		date := day.Format("2006-01-02")
+		if data, err := os.ReadFile(summaryPath(groupJID, date, ".json")); err == nil {
+			var sidecar SummarySidecar
+			if err := json.Unmarshal(data, &sidecar); err != nil {
+				logger.Warnf("Ignoring invalid state of play for %s: %v", date, err)
+			} else {
+				sidecars = append([]*SummarySidecar{&sidecar}, sidecars...)
+			}
+		}
+
+		summary, err := os.ReadFile(summaryPath(groupJID, date, ".md"))
+		if err != nil {
+			continue
+		}
+		entry := fmt.Sprintf("### %s\n%s", date, strings.TrimSpace(string(summary)))
+		if total+len(entry) > reviewMaxSummaryChars {
+			logger.Infof("Leaving out daily summaries from %s and earlier to fit the prompt", date)
+			break
+		}
+		total += len(entry)
+		summaries = append([]string{entry}, summaries...)
+	}
+	return summaries, sidecars
+}
+
+// formatQuarterDecisions lists the decisions recorded in the quarter's states of play, without repeats
+func formatQuarterDecisions(sidecars []*SummarySidecar) string {
+	seen := make(map[string]bool)
+	var lines []string
+	for _, sidecar := range sidecars {
+		for _, decision := range sidecar.StateOfPlay.Decisions {
+			if key := strings.ToLower(strings.TrimSpace(decision)); key != "" && !seen[key] {
+				seen[key] = true
+				lines = append(lines, fmt.Sprintf("- %s: %s", sidecar.Date, decision))
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatQuarterOpenItems lists the threads and pending actions of the quarter's last state of play
+func formatQuarterOpenItems(sidecars []*SummarySidecar) string {
+	if len(sidecars) == 0 {
+		return ""
+	}
+	state := sidecars[len(sidecars)-1].StateOfPlay
+
+	var lines []string
+	for _, action := range state.PendingActions {
+		line := "- Action: " + action.Action
+		if action.Owner != "" {
+			line += " (owner: " + action.Owner + ")"
+		}
+		if action.Due != "" {
+			line += " (due: " + action.Due + ")"
+		}
+		lines = append(lines, line)
+	}
+	for _, thread := range state.Threads {
+		line := fmt.Sprintf("- Thread: %s — %s", thread.Topic, thread.Status)
+		if thread.Since != "" {
+			line += " (since " + thread.Since + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// loadQuarterTopics lists the topics of the episodes recorded during the quarter with the days they came up
+func loadQuarterTopics(groupJID, startDate, endDate string, logger waLog.Logger) string {
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		logger.Warnf("Failed to open message database: %v", err)
+		return ""
+	}
+	defer db.Close()
+
+	if err := ensureEpisodeTables(db); err != nil {
+		logger.Warnf("Failed to create episode tables: %v", err)
+		return ""
+	}
+	episodes, err := listEpisodes(db, groupJID, startDate, endDate)
+	if err != nil {
+		logger.Warnf("Failed to list episodes: %v", err)
+		return ""
+	}
+
+	var topics []string
+	days := make(map[string][]string)
+	for _, episode := range episodes {
+		if _, ok := days[episode.Topic]; !ok {
+			topics = append(topics, episode.Topic)
+		}
+		days[episode.Topic] = append(days[episode.Topic], episode.Date)
+	}
+
+	var lines []string
+	for _, topic := range topics {
+		lines = append(lines, fmt.Sprintf("- %s (%s)", topic, strings.Join(days[topic], ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// loadQuarterFacts searches the group's Graphiti namespace for the facts a review needs,
+// keeping those valid during the quarter. Only used when Graphiti is the knowledge sink.
+func loadQuarterFacts(groupJID string, start, end time.Time, logger waLog.Logger) string {
+	if getKnowledgeSinkName() != knowledgeSinkGraphiti {
+		return ""
+	}
+
+	graphitiGroupID := graphitiGroupIDForChat(groupJID)
+	seen := make(map[string]bool)
+	var lines []string
+	for _, query := range reviewGraphitiQueries {
+		facts, err := searchGraphitiFacts(graphitiGroupID, query, reviewMaxFactsPerQuery)
+		if err != nil {
+			logger.Warnf("Failed to search Graphiti for %q: %v", query, err)
+			continue
+		}
+		for _, fact := range facts {
+			if seen[fact.UUID] || !graphitiFactInRange(fact, start, end) {
+				continue
+			}
+			seen[fact.UUID] = true
+
+			line := "- " + fact.Fact
+			if validAt := parseGraphitiTime(fact.ValidAt); !validAt.IsZero() {
+				line += " (since " + validAt.Format("2006-01-02")
+				if invalidAt := parseGraphitiTime(fact.InvalidAt); !invalidAt.IsZero() {
+					line += ", until " + invalidAt.Format("2006-01-02")
+				}
+				line += ")"
+			}
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// graphitiFactInRange reports whether a fact held at some point between start and end.
+// Facts without a valid_at fall back to when they were created; undated facts are kept.
+func graphitiFactInRange(fact GraphitiFact, start, end time.Time) bool {
+	validAt := parseGraphitiTime(fact.ValidAt)
+	if validAt.IsZero() {
+		validAt = parseGraphitiTime(fact.CreatedAt)
+	}
+	if !validAt.IsZero() && !validAt.Before(end) {
+		return false
+	}
+	invalidAt := parseGraphitiTime(fact.InvalidAt)
+	return invalidAt.IsZero() || invalidAt.After(start)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// rawMessagesEnabled reports whether the original protobuf of each message is kept (STORE_RAW_MESSAGES, on by default)
+func rawMessagesEnabled() bool {
+	return os.Getenv("STORE_RAW_MESSAGES") != "false"
+}
+
+// StoreRawMessage keeps a message's protobuf; a message delivered again replaces it
+func (store *MessageStore) StoreRawMessage(id, chatJID, sender string, timestamp time.Time, message proto.Message) error {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return err
+	}
+	sealed, err := store.seal(chatJID, string(data))
+	if err != nil {
+		return err
+	}
+	_, err = store.dbFor(chatJID).Exec(`
+		INSERT INTO raw_messages (message_id, chat_jid, sender, timestamp, proto_type, proto, stored_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid) DO UPDATE SET
+			sender = excluded.sender, timestamp = excluded.timestamp, proto_type = excluded.proto_type,
+			proto = excluded.proto, stored_at = excluded.stored_at
+	`, id, chatJID, sender, timestamp, string(proto.MessageName(message)), []byte(sealed), time.Now())
+	return err
+}
+
+// storeRawMessage keeps a live message as it arrived, before ephemeral and view-once wrappers were removed
+func storeRawMessage(messageStore *MessageStore, msg *events.Message, chatJID string, logger waLog.Logger) {
+	if !rawMessagesEnabled() {
+		return
+	}
+	message := msg.RawMessage
+	if message == nil {
+		message = msg.Message
+	}
+	if err := messageStore.StoreRawMessage(msg.Info.ID, chatJID, msg.Info.Sender.ToNonAD().String(), msg.Info.Timestamp, message); err != nil {
+		logger.Warnf("Failed to store raw message %s: %v", msg.Info.ID, err)
+	}
+}
+
+// storeRawHistoryMessage keeps a message from a history sync, with the reactions and poll votes WhatsApp sent along
+func storeRawHistoryMessage(client *whatsmeow.Client, messageStore *MessageStore, chat types.JID, info *waProto.WebMessageInfo, logger waLog.Logger) {
+	if !rawMessagesEnabled() || info.GetKey().GetID() == "" || info.GetMessageTimestamp() == 0 {
+		return
+	}
+	sender := chat.ToNonAD().String()
+	if participant := info.GetKey().GetParticipant(); participant != "" {
+		sender = participant
+	} else if info.GetKey().GetFromMe() && client.Store.ID != nil {
+		sender = client.Store.ID.ToNonAD().String()
+	}
+	timestamp := time.Unix(int64(info.GetMessageTimestamp()), 0)
+	if err := messageStore.StoreRawMessage(info.GetKey().GetID(), chat.String(), sender, timestamp, info); err != nil {
+		logger.Warnf("Failed to store raw history message %s: %v", info.GetKey().GetID(), err)
+	}
+}
+
+// RawMessage is a stored message protobuf, decoded to JSON
+type RawMessage struct {
+	ID        string          `json:"id"`
+	ChatJID   string          `json:"chat_jid"`
+	Sender    string          `json:"sender"`
+	Timestamp time.Time       `json:"timestamp"`
+	ProtoType string          `json:"proto_type"`
+	Message   json.RawMessage `json:"message"`
+}
+
+// GetRawMessage reads a message's stored protobuf and decodes it; sql.ErrNoRows when it wasn't kept
+func (store *MessageStore) GetRawMessage(id, chatJID string) (proto.Message, RawMessage, error) {
+	raw := RawMessage{ID: id, ChatJID: chatJID}
+	var sender sql.NullString
+	var data []byte
+	err := store.dbFor(chatJID).QueryRow(
+		"SELECT sender, timestamp, proto_type, proto FROM raw_messages WHERE message_id = ? AND chat_jid = ?", id, chatJID,
+	).Scan(&sender, &raw.Timestamp, &raw.ProtoType, &data)
+	if err != nil {
+		return nil, raw, err
+	}
+	raw.Sender = sender.String
+
+	messageType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(raw.ProtoType))
+	if err != nil {
+		return nil, raw, fmt.Errorf("unknown protobuf type %s: %v", raw.ProtoType, err)
+	}
+	if strings.HasPrefix(string(data), encryptedTextPrefix) && store.directCipher == nil {
+		return nil, raw, fmt.Errorf("raw message is encrypted with DIRECT_MESSAGES_KEY")
+	}
+	message := messageType.New().Interface()
+	if err := proto.Unmarshal([]byte(store.open(string(data))), message); err != nil {
+		return nil, raw, fmt.Errorf("failed to decode raw message: %v", err)
+	}
+	raw.Message, err = protojson.Marshal(message)
+	return message, raw, err
+}
+
+// handleRawMessageAPI returns a message's original protobuf as JSON (GET /api/messages/raw?id=...&chat_jid=...)
+func handleRawMessageAPI(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, chatJID := r.URL.Query().Get("id"), r.URL.Query().Get("chat_jid")
+		if id == "" || chatJID == "" {
+			http.Error(w, "id and chat_jid are required", http.StatusBadRequest)
+			return
+		}
+		if !apiRequestAllowsChat(r, chatJID) {
+			rejectChat(w, r, chatJID)
+			return
+		}
+
+		_, raw, err := messageStore.GetRawMessage(id, chatJID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Raw message not stored", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(raw)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Reaction is one person's reaction to a message
+type Reaction struct {
+	MessageID string    `json:"message_id"`
+	ChatJID   string    `json:"chat_jid"`
+	Reactor   string    `json:"reactor"`
+	Emoji     string    `json:"emoji"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// StoreReaction records a person's reaction to a message, replacing their previous one; an empty emoji removes it
+func (store *MessageStore) StoreReaction(messageID, chatJID, reactor, emoji string, timestamp time.Time) error {
+	if emoji == "" {
+		_, err := store.dbFor(chatJID).Exec("DELETE FROM reactions WHERE message_id = ? AND chat_jid = ? AND reactor = ?", messageID, chatJID, reactor)
+		return err
+	}
+	// History sync can deliver a reaction after a newer one arrived live, so older reactions never win
+	_, err := store.dbFor(chatJID).Exec(`
+		INSERT INTO reactions (message_id, chat_jid, reactor, emoji, timestamp) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid, reactor) DO UPDATE SET emoji = excluded.emoji, timestamp = excluded.timestamp
+		WHERE excluded.timestamp >= reactions.timestamp
+	`, messageID, chatJID, reactor, emoji, timestamp)
+	return err
+}
+
+// GetReactions returns the reactions to a message, oldest first
+func (store *MessageStore) GetReactions(messageID, chatJID string) ([]Reaction, error) {
+	rows, err := store.dbFor(chatJID).Query(
+		"SELECT message_id, chat_jid, reactor, emoji, timestamp FROM reactions WHERE message_id = ? AND chat_jid = ? ORDER BY timestamp",
+		messageID, chatJID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reactions := []Reaction{}
+	for rows.Next() {
+		var reaction Reaction
+		if err := rows.Scan(&reaction.MessageID, &reaction.ChatJID, &reaction.Reactor, &reaction.Emoji, &reaction.Timestamp); err != nil {
+			return nil, err
+		}
+		reactions = append(reactions, reaction)
+	}
+	return reactions, rows.Err()
+}
+
+// handleReactionMessage records a live reaction against the message it reacts to
+func handleReactionMessage(messageStore *MessageStore, chatJID, reactor string, reaction *waProto.ReactionMessage, receivedAt time.Time, logger waLog.Logger) {
+	messageID := reaction.GetKey().GetID()
+	if messageID == "" {
+		return
+	}
+	timestamp := receivedAt
+	if ms := reaction.GetSenderTimestampMS(); ms > 0 {
+		timestamp = time.UnixMilli(ms)
+	}
+
+	if err := messageStore.StoreReaction(messageID, chatJID, reactor, reaction.GetText(), timestamp); err != nil {
+		logger.Warnf("Failed to store reaction to %s: %v", messageID, err)
+		return
+	}
+	if reaction.GetText() == "" {
+		fmt.Printf("[%s] %s removed their reaction to %s\n", timestamp.Format("2006-01-02 15:04:05"), reactor, messageID)
+	} else {
+		fmt.Printf("[%s] %s reacted %s to %s\n", timestamp.Format("2006-01-02 15:04:05"), reactor, reaction.GetText(), messageID)
+	}
+}
+
+// storeHistoryReactions records the reactions history sync carries with a message
+func storeHistoryReactions(client *whatsmeow.Client, messageStore *MessageStore, messageID string, chat types.JID, reactions []*waProto.Reaction, logger waLog.Logger) {
+	for _, reaction := range reactions {
+		if reaction.GetText() == "" {
+			continue
+		}
+
+		// The key is the reaction's own: its participant reacted in a group, otherwise it's the other side of the chat
+		key := reaction.GetKey()
+		reactor := chat.User
+		if key.GetFromMe() && client.Store.ID != nil {
+			reactor = client.Store.ID.User
+		} else if participant, err := types.ParseJID(key.GetParticipant()); err == nil && participant.User != "" {
+			reactor = participant.User
+		}
+
+		timestamp := time.UnixMilli(reaction.GetSenderTimestampMS())
+		if err := messageStore.StoreReaction(messageID, chat.String(), reactor, reaction.GetText(), timestamp); err != nil {
+			logger.Warnf("Failed to store history reaction to %s: %v", messageID, err)
+		}
+	}
+}
+
+// handleReactionsAPI lists the reactions to a message (GET /api/reactions?chat_jid=...&message_id=...)
+func handleReactionsAPI(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		messageID := r.URL.Query().Get("message_id")
+		if chatJID == "" || messageID == "" {
+			http.Error(w, "chat_jid and message_id are required", http.StatusBadRequest)
+			return
+		}
+		if !apiRequestAllowsChat(r, chatJID) {
+			rejectChat(w, r, chatJID)
+			return
+		}
+
+		reactions, err := messageStore.GetReactions(messageID, chatJID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get reactions: %v", err), http.StatusInternalServerError)
+			return
+		}
+		counts := make(map[string]int)
+		for _, reaction := range reactions {
+			counts[reaction.Emoji]++
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message_id": messageID,
+			"chat_jid":   chatJID,
+			"counts":     counts,
+			"reactions":  reactions,
+		})
+	}
+}
+
+// validReactionEmoji reports whether a reaction is an emoji (possibly a sequence, like a flag or a family) rather
+// than text, which WhatsApp would send but phones can't show
+func validReactionEmoji(emoji string) bool {
+	if len([]rune(emoji)) > 16 {
+		return false
+	}
+	for _, r := range emoji {
+		if unicode.IsLetter(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// sendWhatsAppReaction reacts to a stored message of a chat with an emoji, or removes my reaction when emoji is ""
+func sendWhatsAppReaction(client *whatsmeow.Client, messageStore *MessageStore, chat, messageID, emoji string) error {
+	if readOnlyModeEnabled() {
+		return errReadOnly
+	}
+	if !client.IsConnected() {
+		return fmt.Errorf("not connected to WhatsApp")
+	}
+	chatJID, err := parseRecipientJID(client, chat)
+	if err != nil {
+		return fmt.Errorf("invalid chat %s: %v", chat, err)
+	}
+	emoji = strings.TrimSpace(emoji)
+	if emoji != "" && !validReactionEmoji(emoji) {
+		return fmt.Errorf("%q is not an emoji", emoji)
+	}
+
+	// The reaction points at the message's sender, so the message must be stored
+	var sender string
+	var isFromMe bool
+	err = messageStore.dbFor(chatJID.String()).QueryRow("SELECT COALESCE(sender, ''), is_from_me FROM messages WHERE id = ? AND chat_jid = ?",
+		messageID, chatJID.String()).Scan(&sender, &isFromMe)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("message %s not found in %s", messageID, chatJID)
+	}
+	if err != nil {
+		return err
+	}
+
+	msg := client.BuildReaction(chatJID, storedMessageSenderJID(client, chatJID, sender, isFromMe), messageID, emoji)
+	sendResp, err := sendPaced(client, messageStore.dbFor(chatJID.String()), chatJID, msg)
+	if err != nil {
+		return fmt.Errorf("error sending reaction: %v", err)
+	}
+
+	// My own reactions don't come back as events
+	if client.Store.ID != nil {
+		if err := messageStore.StoreReaction(messageID, chatJID.String(), client.Store.ID.User, emoji, sendResp.Timestamp); err != nil {
+			fmt.Printf("Failed to store sent reaction: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// SendReactionRequest is the request body for the send reaction API
+type SendReactionRequest struct {
+	ChatJID   string `json:"chat_jid"`
+	MessageID string `json:"message_id"`
+	Emoji     string `json:"emoji"` // "" removes my reaction
+}
+
+// handleSendReactionAPI reacts to a message, or removes my reaction (POST /api/reactions/send)
+func handleSendReactionAPI(client *whatsmeow.Client, messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SendReactionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.ChatJID == "" || req.MessageID == "" {
+			http.Error(w, "chat_jid and message_id are required", http.StatusBadRequest)
+			return
+		}
+		if !apiRequestAllowsChat(r, req.ChatJID) {
+			rejectChat(w, r, req.ChatJID)
+			return
+		}
+		if rejectedBySendPolicy(w, r, client, messageStore, sendKindReaction, "", req.ChatJID, req.Emoji) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if isSafeModeActive() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(SendMessageResponse{
+				Success: false,
+				Message: "Safe mode is on, outbound messages are disabled",
+			})
+			return
+		}
+
+		err := sendWhatsAppReaction(client, messageStore, req.ChatJID, req.MessageID, req.Emoji)
+		auditAPISend(messageStore, r, sendKindReaction, req.ChatJID, req.Emoji, "", err)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: err.Error()})
+			return
+		}
+		message := fmt.Sprintf("Reacted %s to %s", strings.TrimSpace(req.Emoji), req.MessageID)
+		if strings.TrimSpace(req.Emoji) == "" {
+			message = fmt.Sprintf("Removed the reaction to %s", req.MessageID)
+		}
+		json.NewEncoder(w).Encode(SendMessageResponse{Success: true, Message: message})
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+)
+
+// With READ_ONLY=true the bridge only watches, e.g. for compliance monitoring: it keeps storing, searching,
+// summarizing to files and feeding the knowledge graph, but changes nothing on WhatsApp. Messages, reactions,
+// polls, stickers, read receipts, presence and group changes are refused, and queued messages stay queued.
+
+// errReadOnly is returned by the sends refused in read-only mode
+var errReadOnly = errors.New("read-only mode is on, sending is disabled")
+
+// readOnlyModeEnabled reports whether the bridge and the tools may not change anything on WhatsApp (READ_ONLY=true)
+func readOnlyModeEnabled() bool {
+	return os.Getenv("READ_ONLY") == "true"
+}
+
+// refuseInReadOnlyMode wraps an API handler that changes something on WhatsApp, refusing its requests other than
+// GET with 403 in read-only mode
+func refuseInReadOnlyMode(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if readOnlyModeEnabled() && r.Method != http.MethodGet {
+			http.Error(w, "Read-only mode is on, sending is disabled", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Delivery states of a message I sent
+const (
+	deliveryStatusSent      = "sent"      // accepted by the server
+	deliveryStatusDelivered = "delivered" // reached a recipient's phone
+	deliveryStatusRead      = "read"      // seen (or played) by a recipient
+)
+
+// MessageReceipt is the delivery state of a sent message for one recipient; groups have one per participant
+type MessageReceipt struct {
+	Recipient   string     `json:"recipient"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `json:"read_at,omitempty"`
+}
+
+// MessageDeliveryStatus is the delivery state of a sent message
+type MessageDeliveryStatus struct {
+	MessageID   string           `json:"message_id"`
+	ChatJID     string           `json:"chat_jid"`
+	Status      string           `json:"status"`
+	SentAt      time.Time        `json:"sent_at"`
+	DeliveredAt *time.Time       `json:"delivered_at,omitempty"` // first delivery
+	ReadAt      *time.Time       `json:"read_at,omitempty"`      // first read
+	Recipients  []MessageReceipt `json:"recipients"`
+}
+
+// ensureReceiptColumns adds the columns with the first delivery and read of sent messages,
+// and the table with the receipts of each recipient
+func ensureReceiptColumns(db *sql.DB) error {
+	if err := addColumnIfMissing(db, "messages", "delivered_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "messages", "read_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS message_receipts (
+			message_id TEXT,
+			chat_jid TEXT,
+			recipient TEXT,
+			delivered_at TIMESTAMP,
+			read_at TIMESTAMP,
+			PRIMARY KEY (message_id, chat_jid, recipient)
+		);
+	`)
+	return err
+}
+
+// StoreReceipt records that messages I sent were delivered to or read by a recipient; only the first time counts
+func (store *MessageStore) StoreReceipt(chatJID string, messageIDs []string, recipient string, read bool, timestamp time.Time) error {
+	db := store.dbFor(chatJID)
+	var readAt interface{}
+	if read {
+		readAt = timestamp
+	}
+	for _, id := range messageIDs {
+		// A read receipt implies delivery, which may not have been reported on its own
+		if _, err := db.Exec(
+			"UPDATE messages SET delivered_at = COALESCE(delivered_at, ?), read_at = COALESCE(read_at, ?) WHERE id = ? AND chat_jid = ? AND is_from_me = 1",
+			timestamp, readAt, id, chatJID,
+		); err != nil {
+			return err
+		}
+		if _, err := db.Exec(`
+			INSERT INTO message_receipts (message_id, chat_jid, recipient, delivered_at, read_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (message_id, chat_jid, recipient) DO UPDATE SET
+				delivered_at = COALESCE(message_receipts.delivered_at, excluded.delivered_at),
+				read_at = COALESCE(message_receipts.read_at, excluded.read_at)
+		`, id, chatJID, recipient, timestamp, readAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDeliveryStatus returns the delivery state of a message I sent; it returns nil when the message isn't stored
+// or wasn't sent by me
+func (store *MessageStore) GetDeliveryStatus(messageID, chatJID string) (*MessageDeliveryStatus, error) {
+	db := store.dbFor(chatJID)
+	status := &MessageDeliveryStatus{MessageID: messageID, ChatJID: chatJID, Recipients: []MessageReceipt{}}
+	var deliveredAt, readAt sql.NullTime
+	err := db.QueryRow(
+		"SELECT timestamp, delivered_at, read_at FROM messages WHERE id = ? AND chat_jid = ? AND is_from_me = 1",
+		messageID, chatJID,
+	).Scan(&status.SentAt, &deliveredAt, &readAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status.Status = deliveryStatusSent
+	if deliveredAt.Valid {
+		status.Status, status.DeliveredAt = deliveryStatusDelivered, &deliveredAt.Time
+	}
+	if readAt.Valid {
+		status.Status, status.ReadAt = deliveryStatusRead, &readAt.Time
+	}
+
+	rows, err := db.Query(
+		"SELECT recipient, delivered_at, read_at FROM message_receipts WHERE message_id = ? AND chat_jid = ? ORDER BY recipient",
+		messageID, chatJID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var receipt MessageReceipt
+		var delivered, read sql.NullTime
+		if err := rows.Scan(&receipt.Recipient, &delivered, &read); err != nil {
+			return nil, err
+		}
+		if delivered.Valid {
+			receipt.DeliveredAt = &delivered.Time
+		}
+		if read.Valid {
+			receipt.ReadAt = &read.Time
+		}
+		status.Recipients = append(status.Recipients, receipt)
+	}
+	return status, rows.Err()
+}
+
+// handleReceipt records the delivery and read receipts of messages I sent; my own devices' receipts are ignored
+func handleReceipt(messageStore *MessageStore, evt *events.Receipt, logger waLog.Logger) {
+	if evt.IsFromMe {
+		// My own receipts, from reading a chat on another device
+		handleReadSelfReceipt(messageStore, evt, logger)
+		return
+	}
+	var read bool
+	switch evt.Type {
+	case types.ReceiptTypeDelivered:
+	case types.ReceiptTypeRead, types.ReceiptTypePlayed:
+		read = true
+	default:
+		return
+	}
+
+	chatJID := evt.Chat.ToNonAD().String()
+	if err := messageStore.StoreReceipt(chatJID, evt.MessageIDs, evt.Sender.User, read, evt.Timestamp); err != nil {
+		logger.Warnf("Failed to store receipt for %v in %s: %v", evt.MessageIDs, chatJID, err)
+	}
+}
+
+// handleMessageStatusAPI returns the delivery state of a message I sent
+// (GET /api/message-status?chat_jid=...&message_id=...)
+func handleMessageStatusAPI(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		chatJID := r.URL.Query().Get("chat_jid")
+		messageID := r.URL.Query().Get("message_id")
+		if chatJID == "" || messageID == "" {
+			http.Error(w, "chat_jid and message_id are required", http.StatusBadRequest)
+			return
+		}
+		if !apiRequestAllowsChat(r, chatJID) {
+			rejectChat(w, r, chatJID)
+			return
+		}
+
+		status, err := messageStore.GetDeliveryStatus(messageID, chatJID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get delivery status: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if status == nil {
+			http.Error(w, "Sent message not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
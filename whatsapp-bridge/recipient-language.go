@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const translationPrompt = `Translate the following WhatsApp message into %s.
+
+Keep the WhatsApp formatting (*bold*, _italic_, ~strikethrough~, lists), emoji, line breaks, names, links, numbers, dates and amounts exactly as they are. Translate everything else, including headings. If the message is already in %s, return it unchanged.
+
+Return only the translated message, without any introduction or notes.
+
+<message>
+%s
+</message>`
+
+// RecipientTranslator translates an outbound text into the preferred language of each recipient,
+// once per language, so a digest sent to several people costs one LLM call per language
+type RecipientTranslator struct {
+	ctx            context.Context
+	config         *BridgeConfig
+	text           string
+	sourceLanguage string // "" when unknown; every recipient with a language then gets a translation pass
+	translations   map[string]string
+	logger         waLog.Logger
+}
+
+// newRecipientTranslator prepares the translations of text, written in sourceLanguage
+func newRecipientTranslator(ctx context.Context, text, sourceLanguage string, logger waLog.Logger) *RecipientTranslator {
+	config, err := loadBridgeConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		config = &BridgeConfig{}
+	}
+	return &RecipientTranslator{
+		ctx:            ctx,
+		config:         config,
+		text:           text,
+		sourceLanguage: sourceLanguage,
+		translations:   make(map[string]string),
+		logger:         logger,
+	}
+}
+
+// getGroupLanguage returns the language of a group's summaries from its chat settings or config.json, or "" when unset
+func getGroupLanguage(groupJID string) string {
+	if settings, err := loadChatSettings(groupJID); err == nil && settings.Language != "" {
+		return settings.Language
+	}
+	config, err := loadBridgeConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return ""
+	}
+	return config.getGroupConfig(groupJID).Language
+}
+
+// For returns the text in the recipient's language. Recipients without a language, or with the
+// source language, get the original; so do all of them when the translation fails.
+func (translator *RecipientTranslator) For(recipient string) string {
+	language := strings.TrimSpace(translator.config.getRecipientConfig(recipient).Language)
+	if language == "" || strings.EqualFold(language, strings.TrimSpace(translator.sourceLanguage)) {
+		return translator.text
+	}
+
+	key := strings.ToLower(language)
+	if translated, ok := translator.translations[key]; ok {
+		return translated
+	}
+
+	translated, err := translateText(translator.ctx, translator.text, language)
+	if err != nil {
+		translator.logger.Warnf("Failed to translate into %s for %s, sending the original: %v", language, recipient, err)
+		translated = translator.text
+	} else {
+		translator.logger.Infof("Translated into %s for %s", language, recipient)
+	}
+	translator.translations[key] = translated
+	return translated
+}
+
+// translateText asks the LLM for a translation of text into language
+func translateText(ctx context.Context, text, language string) (string, error) {
+	response, err := callLLM(withLLMPurpose(ctx, "translation"), fmt.Sprintf(translationPrompt, language, language, text))
+	if err != nil {
+		return "", err
+	}
+	response = strings.TrimSpace(response)
+	response = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(response, "<message>"), "</message>"))
+	if response == "" {
+		return "", fmt.Errorf("empty translation")
+	}
+	return response, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const (
+	// Messages I must have written in a direct chat over the last year for the contact to count as important
+	reconnectMinMessages = 20
+
+	// Messages of the last conversation given to the LLM for the opener
+	reconnectContextMessages = 20
+)
+
+const reconnectPrompt = `I haven't talked to %s on WhatsApp for %d weeks. This is the end of our last conversation (I am "Me"):
+
+%s
+
+Write a short, natural opener I could send to restart the conversation. Refer to something specific from our last conversation (a plan, a question left open, news they shared) rather than a generic "long time no see". Write it in the language of the conversation and in my tone, at most two sentences.
+
+Return only the message, without quotes or notes.`
+
+// ReconnectCandidate is an important contact I haven't spoken to recently
+type ReconnectCandidate struct {
+	JID         string
+	Name        string
+	LastMessage time.Time
+	MyMessages  int // messages I wrote in the direct chat over the last year
+}
+
+// reconnectEnabled reports whether the digest includes reconnect suggestions (WEEKLY_DIGEST_RECONNECT)
+func reconnectEnabled() bool {
+	return os.Getenv("WEEKLY_DIGEST_RECONNECT") == "true"
+}
+
+// getReconnectWeeks returns after how many quiet weeks a contact is suggested (WEEKLY_DIGEST_RECONNECT_WEEKS, default 6)
+func getReconnectWeeks() int {
+	if weeks, err := strconv.Atoi(os.Getenv("WEEKLY_DIGEST_RECONNECT_WEEKS")); err == nil && weeks > 0 {
+		return weeks
+	}
+	return 6
+}
+
+// getReconnectMax returns how many contacts are suggested per digest (WEEKLY_DIGEST_RECONNECT_MAX, default 3)
+func getReconnectMax() int {
+	if max, err := strconv.Atoi(os.Getenv("WEEKLY_DIGEST_RECONNECT_MAX")); err == nil && max > 0 {
+		return max
+	}
+	return 3
+}
+
+// findDormantContacts returns the important contacts whose direct chat has been quiet for the given weeks, most
+// important first. Important contacts are the members of WEEKLY_DIGEST_RECONNECT_SEGMENT when set, otherwise the
+// direct chats where I wrote at least reconnectMinMessages messages over the last year.
+func findDormantContacts(db *sql.DB, weeks int, now time.Time) ([]ReconnectCandidate, error) {
+	var important map[string]bool
+	if segment := os.Getenv("WEEKLY_DIGEST_RECONNECT_SEGMENT"); segment != "" {
+		if err := ensureBroadcastTables(db); err != nil {
+			return nil, fmt.Errorf("failed to create segment tables: %v", err)
+		}
+		members, err := resolveSegment(db, segment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve segment %s: %v", segment, err)
+		}
+		important = make(map[string]bool)
+		for _, member := range members {
+			important[member] = true
+		}
+	}
+
+	// Only direct chats where both sides wrote, which also leaves out my self-chat
+	rows, err := db.Query(`
+		SELECT chat_jid,
+			SUM(CASE WHEN is_from_me = 1 AND COALESCE(origin, '') = '' THEN 1 ELSE 0 END) AS mine,
+			SUM(CASE WHEN is_from_me = 0 THEN 1 ELSE 0 END) AS theirs,
+			MAX(timestamp)
+		FROM messages
+		WHERE chat_jid LIKE '%@s.whatsapp.net' AND COALESCE(message_type, '') = '' AND timestamp >= ?
+		GROUP BY chat_jid
+		HAVING theirs > 0
+	`, now.AddDate(-1, 0, 0))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query direct chats: %v", err)
+	}
+	defer rows.Close()
+
+	cutoff := now.AddDate(0, 0, -7*weeks)
+	var candidates []ReconnectCandidate
+	for rows.Next() {
+		var candidate ReconnectCandidate
+		var theirs int
+		var lastMessage string
+		if err := rows.Scan(&candidate.JID, &candidate.MyMessages, &theirs, &lastMessage); err != nil {
+			return nil, err
+		}
+		if important != nil {
+			if !important[candidate.JID] {
+				continue
+			}
+		} else if candidate.MyMessages < reconnectMinMessages {
+			continue
+		}
+		candidate.LastMessage = parseSQLiteTime(lastMessage)
+		if candidate.LastMessage.IsZero() || candidate.LastMessage.After(cutoff) {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].MyMessages != candidates[j].MyMessages {
+			return candidates[i].MyMessages > candidates[j].MyMessages
+		}
+		return candidates[i].LastMessage.After(candidates[j].LastMessage)
+	})
+	return candidates, nil
+}
+
+// getLastConversation renders the last messages of a direct chat as a transcript, oldest first
+func getLastConversation(db *sql.DB, chatJID, name string) (string, error) {
+	rows, err := db.Query(`
+		SELECT timestamp, is_from_me, COALESCE(content, ''), COALESCE(media_type, '')
+		FROM messages
+		WHERE chat_jid = ? AND COALESCE(message_type, '') = '' AND deleted_at IS NULL
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`, chatJID, reconnectContextMessages)
+	if err != nil {
+		return "", fmt.Errorf("failed to query the last conversation: %v", err)
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var timestamp time.Time
+		var isFromMe bool
+		var content, mediaType string
+		if err := rows.Scan(&timestamp, &isFromMe, &content, &mediaType); err != nil {
+			return "", err
+		}
+		sender := name
+		if isFromMe {
+			sender = "Me"
+		}
+		if content == "" && mediaType != "" {
+			content = "[" + mediaType + "]"
+		}
+		lines = append([]string{fmt.Sprintf("[%s] %s: %s", timestamp.Format("2006-01-02 15:04"), sender, content)}, lines...)
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
+
+// draftReconnectOpener asks the LLM for an opener referencing the last conversation with a contact
+func draftReconnectOpener(ctx context.Context, db *sql.DB, candidate ReconnectCandidate, weeks int) (string, error) {
+	conversation, err := getLastConversation(db, candidate.JID, candidate.Name)
+	if err != nil {
+		return "", err
+	}
+	if conversation == "" {
+		return "", fmt.Errorf("no messages with %s", candidate.JID)
+	}
+
+	ctx = withLLMPurpose(withLLMAuditScope(ctx, candidate.JID, time.Now().Format("2006-01-02")), "reconnect")
+	response, err := callLLM(ctx, fmt.Sprintf(reconnectPrompt, candidate.Name, weeks, conversation))
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(strings.TrimSpace(response), `"“”`), nil
+}
+
+// buildReconnectSection returns the "Reconnect" section of the digest, or "" when no important contact is dormant
+func buildReconnectSection(ctx context.Context, db *sql.DB, now time.Time, logger waLog.Logger) string {
+	weeks := getReconnectWeeks()
+	candidates, err := findDormantContacts(db, weeks, now)
+	if err != nil {
+		logger.Warnf("Failed to find dormant contacts: %v", err)
+		return ""
+	}
+	if len(candidates) == 0 {
+		logger.Infof("No important contact quiet for %d weeks", weeks)
+		return ""
+	}
+	if max := getReconnectMax(); len(candidates) > max {
+		candidates = candidates[:max]
+	}
+
+	var lines []string
+	for _, candidate := range candidates {
+		candidate.Name = getSenderName(strings.Split(candidate.JID, "@")[0], false, logger)
+		quiet := int(now.Sub(candidate.LastMessage).Hours() / (24 * 7))
+		line := fmt.Sprintf("• %s — %d weeks since %s", candidate.Name, quiet, candidate.LastMessage.Format("2006-01-02"))
+
+		// Without an opener the contact is still worth the reminder
+		opener, err := draftReconnectOpener(ctx, db, candidate, quiet)
+		if err != nil {
+			logger.Warnf("Failed to draft an opener for %s: %v", candidate.JID, err)
+		} else if opener != "" {
+			line += "\n  💬 " + opener
+		}
+		lines = append(lines, line)
+	}
+
+	return fmt.Sprintf("*Reconnect* (important contacts quiet for %d+ weeks)\n%s", weeks, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// With PII_REDACTION=true, the prompts of the daily summary and the historical import leave for the LLM with phone
+// numbers, emails, card numbers and the configured names and patterns (redaction in config.json) replaced by
+// placeholders such as [PHONE_3]. The placeholders in the answers are restored locally, so summaries go out with
+// the real values. The mapping is kept in pii_redactions, so a value keeps its placeholder across runs.
+
+// Kinds of redacted values, which name their placeholders
+const (
+	redactionCard    = "CARD"
+	redactionEmail   = "EMAIL"
+	redactionPhone   = "PHONE"
+	redactionName    = "NAME"
+	redactionPattern = "PII"
+)
+
+// redactionRule replaces the matches of a pattern with placeholders of a kind
+type redactionRule struct {
+	kind      string
+	pattern   *regexp.Regexp
+	valid     func(match string) bool // nil accepts every match
+	wholeWord bool                    // only matches not inside a word, in any script (\b only knows ASCII)
+}
+
+// builtinRedactionRules run in order: card numbers before phone numbers, which they would otherwise match
+var builtinRedactionRules = []redactionRule{
+	{kind: redactionCard, pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), valid: luhnValid},
+	{kind: redactionEmail, pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{kind: redactionPhone, pattern: regexp.MustCompile(`\+\d{1,3}[\s.-]?(?:\(\d{1,4}\)[\s.-]?)?\d{2,5}(?:[\s.-]?\d{2,5}){1,3}`)},
+	{kind: redactionPhone, pattern: regexp.MustCompile(`\(\d{2,3}\)\s?\d{4,5}[\s-]?\d{4}\b`)},
+	{kind: redactionPhone, pattern: regexp.MustCompile(`\b9\d{4}-\d{4}\b|\b\d{10,13}\b`)},
+}
+
+// redactionPlaceholders matches the placeholders in an LLM answer
+var redactionPlaceholders = regexp.MustCompile(`\[(?:CARD|EMAIL|PHONE|NAME|PII)_\d+\]`)
+
+// PIIRedactor replaces personal data in prompts with placeholders and restores them in the answers
+type PIIRedactor struct {
+	db    *sql.DB
+	rules []redactionRule
+
+	mu           sync.Mutex
+	placeholders map[string]string // by value
+	values       map[string]string // by placeholder
+}
+
+type piiRedactorKey struct{}
+
+// piiRedactionEnabled reports whether prompts are redacted (PII_REDACTION=true)
+func piiRedactionEnabled() bool {
+	return os.Getenv("PII_REDACTION") == "true"
+}
+
+// luhnValid reports whether a number passes the Luhn check of card numbers, ignoring spaces and dashes
+func luhnValid(number string) bool {
+	var sum, digits int
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if digits%2 == 1 {
+			if digit *= 2; digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
+
+// isWordRune reports whether a rune belongs to a word
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// accepts reports whether the match of a rule at text[start:end] is to be redacted
+func (rule redactionRule) accepts(text string, start, end int) bool {
+	if rule.valid != nil && !rule.valid(text[start:end]) {
+		return false
+	}
+	if rule.wholeWord {
+		if before, _ := utf8.DecodeLastRuneInString(text[:start]); start > 0 && isWordRune(before) {
+			return false
+		}
+		if after, _ := utf8.DecodeRuneInString(text[end:]); end < len(text) && isWordRune(after) {
+			return false
+		}
+	}
+	return !redactionPlaceholders.MatchString(text[start:end])
+}
+
+// newPIIRedactor builds a redactor with the built-in rules, then the configured names and patterns
+func newPIIRedactor(db *sql.DB, names, patterns []string) (*PIIRedactor, error) {
+	rules := append([]redactionRule{}, builtinRedactionRules...)
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			rules = append(rules, redactionRule{kind: redactionName, pattern: regexp.MustCompile(`(?i)` + regexp.QuoteMeta(name)), wholeWord: true})
+		}
+	}
+	for _, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %v", pattern, err)
+		}
+		rules = append(rules, redactionRule{kind: redactionPattern, pattern: compiled})
+	}
+	return &PIIRedactor{db: db, rules: rules, placeholders: map[string]string{}, values: map[string]string{}}, nil
+}
+
+// withPIIRedaction makes the LLM calls made with ctx redact their prompts, when PII_REDACTION=true
+func withPIIRedaction(ctx context.Context, names, patterns []string) (context.Context, error) {
+	if !piiRedactionEnabled() {
+		return ctx, nil
+	}
+	db, err := openSharedMessagesDB()
+	if err != nil {
+		return ctx, err
+	}
+	redactor, err := newPIIRedactor(db, names, patterns)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, piiRedactorKey{}, redactor), nil
+}
+
+// piiRedactorFromContext returns the redactor of ctx, or nil
+func piiRedactorFromContext(ctx context.Context) *PIIRedactor {
+	redactor, _ := ctx.Value(piiRedactorKey{}).(*PIIRedactor)
+	return redactor
+}
+
+// placeholder returns the placeholder of a value, creating it on first sight
+func (redactor *PIIRedactor) placeholder(kind, value string) (string, error) {
+	if placeholder, ok := redactor.placeholders[value]; ok {
+		return placeholder, nil
+	}
+
+	var placeholder string
+	err := redactor.db.QueryRow("SELECT placeholder FROM pii_redactions WHERE value = ?", value).Scan(&placeholder)
+	// Another process may take the value or the number meanwhile, so the row that won is read back
+	for attempt := 0; attempt < 3 && err == sql.ErrNoRows; attempt++ {
+		var count int
+		if err := redactor.db.QueryRow("SELECT COUNT(*) FROM pii_redactions WHERE kind = ?", kind).Scan(&count); err != nil {
+			return "", err
+		}
+		placeholder = fmt.Sprintf("[%s_%d]", kind, count+1)
+		if _, err := redactor.db.Exec("INSERT OR IGNORE INTO pii_redactions (placeholder, kind, value, created_at) VALUES (?, ?, ?, ?)",
+			placeholder, kind, value, time.Now()); err != nil {
+			return "", err
+		}
+		err = redactor.db.QueryRow("SELECT placeholder FROM pii_redactions WHERE value = ?", value).Scan(&placeholder)
+	}
+	if err != nil {
+		return "", err
+	}
+	redactor.placeholders[value], redactor.values[placeholder] = placeholder, value
+	return placeholder, nil
+}
+
+// Redact replaces the personal data in a prompt with placeholders. It fails rather than let a value through.
+func (redactor *PIIRedactor) Redact(prompt string) (string, error) {
+	redactor.mu.Lock()
+	defer redactor.mu.Unlock()
+
+	for _, rule := range redactor.rules {
+		var redacted strings.Builder
+		last := 0
+		for _, match := range rule.pattern.FindAllStringIndex(prompt, -1) {
+			if !rule.accepts(prompt, match[0], match[1]) {
+				continue
+			}
+			placeholder, err := redactor.placeholder(rule.kind, prompt[match[0]:match[1]])
+			if err != nil {
+				return "", fmt.Errorf("failed to redact prompt: %v", err)
+			}
+			redacted.WriteString(prompt[last:match[0]])
+			redacted.WriteString(placeholder)
+			last = match[1]
+		}
+		redacted.WriteString(prompt[last:])
+		prompt = redacted.String()
+	}
+	return prompt, nil
+}
+
+// Restore puts the redacted values back in an answer; unknown placeholders are left as they are
+func (redactor *PIIRedactor) Restore(answer string) string {
+	redactor.mu.Lock()
+	defer redactor.mu.Unlock()
+
+	return redactionPlaceholders.ReplaceAllStringFunc(answer, func(placeholder string) string {
+		if value, ok := redactor.values[placeholder]; ok {
+			return value
+		}
+		var value string
+		if err := redactor.db.QueryRow("SELECT value FROM pii_redactions WHERE placeholder = ?", placeholder).Scan(&value); err != nil {
+			return placeholder
+		}
+		redactor.placeholders[value], redactor.values[placeholder] = placeholder, value
+		return value
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+var (
+	reingestGroupJID   = flag.String("group-jid", os.Getenv("DAILY_SUMMARY_GROUP_JID"), "WhatsApp group JID or name to re-ingest (defaults to DAILY_SUMMARY_GROUP_JID)")
+	reingestStartDate  = flag.String("start-date", "", "Start date in YYYY-MM-DD format (required)")
+	reingestEndDate    = flag.String("end-date", "", "End date in YYYY-MM-DD format (defaults to start date)")
+	reingestTimezone   = flag.String("timezone", "America/Sao_Paulo", "Timezone for date processing")
+	reingestDelay      = flag.Int("delay", 2, "Delay in seconds between processing each day")
+	reingestDeleteOnly = flag.Bool("delete-only", false, "Only delete the existing episodes, don't re-run segmentation")
+	reingestDryRun     = flag.Bool("dry-run", false, "Show which episodes would be deleted without changing anything")
+)
+
+func main() {
+	flag.Parse()
+
+	logger := newLogger("Reingest", "INFO")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *reingestGroupJID == "" || *reingestStartDate == "" {
+		logger.Errorf("--group-jid and --start-date are required")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *reingestEndDate == "" {
+		*reingestEndDate = *reingestStartDate
+	}
+	groupJID, err := resolveGroupReference(*reingestGroupJID)
+	if err != nil {
+		logger.Errorf("Failed to resolve group: %v", err)
+		os.Exit(1)
+	}
+	*reingestGroupJID = groupJID
+
+	// Catch template mistakes before spending any LLM calls
+	if err := validatePromptTemplates(); err != nil {
+		logger.Errorf("Invalid prompt template: %v", err)
+		os.Exit(1)
+	}
+
+	loc, err := time.LoadLocation(*reingestTimezone)
+	if err != nil {
+		logger.Errorf("Failed to load timezone %s: %v", *reingestTimezone, err)
+		loc = time.UTC
+	}
+
+	start, err := time.Parse("2006-01-02", *reingestStartDate)
+	if err != nil {
+		logger.Errorf("Invalid start date: %v", err)
+		os.Exit(1)
+	}
+	end, err := time.Parse("2006-01-02", *reingestEndDate)
+	if err != nil {
+		logger.Errorf("Invalid end date: %v", err)
+		os.Exit(1)
+	}
+	if start.After(end) {
+		logger.Errorf("Start date cannot be after end date")
+		os.Exit(1)
+	}
+
+	// Open SQLite database for messages
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		logger.Errorf("Failed to open message database: %v", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	if err := ensureEpisodeTables(db); err != nil {
+		logger.Errorf("Failed to create episode table: %v", err)
+		os.Exit(1)
+	}
+
+	groupName := getGroupName(*reingestGroupJID, logger)
+	logger.Infof("Re-ingesting %s (%s) from %s to %s", groupName, *reingestGroupJID, *reingestStartDate, *reingestEndDate)
+
+	var failedDates []string
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		if ctx.Err() != nil {
+			logger.Warnf("Interrupted before %s", date)
+			failedDates = append(failedDates, date)
+			break
+		}
+
+		if err := reingestDay(withLLMSession(withLLMAuditScope(ctx, *reingestGroupJID, date)), db, date, groupName, loc, logger); err != nil {
+			logger.Errorf("Failed to re-ingest %s: %v", date, err)
+			failedDates = append(failedDates, date)
+		}
+
+		if !*reingestDryRun && !*reingestDeleteOnly && day.Before(end) {
+			time.Sleep(time.Duration(*reingestDelay) * time.Second)
+		}
+	}
+
+	if len(failedDates) > 0 {
+		logger.Errorf("Re-ingest finished with %d failed dates: %v", len(failedDates), failedDates)
+		os.Exit(1)
+	}
+
+	logger.Infof("Re-ingest completed")
+}
+
+// reingestDay deletes the tracked episodes of one day and re-runs segmentation and episode creation
+func reingestDay(ctx context.Context, db *sql.DB, date, groupName string, loc *time.Location, logger waLog.Logger) error {
+	episodes, err := listEpisodes(db, *reingestGroupJID, date, date)
+	if err != nil {
+		return fmt.Errorf("failed to list episodes: %v", err)
+	}
+
+	logger.Infof("%s: %d tracked episodes", date, len(episodes))
+
+	for _, episode := range episodes {
+		if *reingestDryRun {
+			logger.Infof("DRY RUN: would delete episode %s (%s)", episode.UUID, episode.Name)
+			continue
+		}
+
+		// Episodes are deleted from the sink they were stored in, even if KNOWLEDGE_SINK changed since
+		sink, err := newKnowledgeSink(episode.Sink)
+		if err != nil {
+			return err
+		}
+
+		// Delete from the sink first so a failure leaves the local record for the next attempt
+		if err := sink.DeleteEpisode(episode.UUID); err != nil {
+			return fmt.Errorf("failed to delete episode %s from %s: %v", episode.UUID, sink.Name(), err)
+		}
+		if err := deleteEpisodeRecord(db, episode.UUID); err != nil {
+			return fmt.Errorf("failed to delete episode record %s: %v", episode.UUID, err)
+		}
+		logger.Infof("Deleted episode %s (%s)", episode.UUID, episode.Name)
+	}
+
+	if *reingestDryRun || *reingestDeleteOnly {
+		return nil
+	}
+
+	day, _ := time.Parse("2006-01-02", date)
+	startOfDay := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+	endOfDay := time.Date(day.Year(), day.Month(), day.Day(), 23, 59, 59, 999999999, loc)
+
+	messages, err := getMessagesFromGroup(*reingestGroupJID, startOfDay, endOfDay, logger)
+	if err != nil {
+		return fmt.Errorf("failed to get messages: %v", err)
+	}
+	if len(messages) == 0 {
+		logger.Infof("%s: no messages, nothing to re-ingest", date)
+		return nil
+	}
+
+	messages = collapseDuplicatesForPrompt(messages, *reingestGroupJID, logger)
+
+	topicSegments, err := segmentMessagesByTopic(ctx, messages, groupName, date, logger)
+	if err != nil {
+		return fmt.Errorf("failed to segment messages by topic: %v", err)
+	}
+
+	if err := addEpisodesToKnowledgeSink(ctx, topicSegments, *reingestGroupJID, groupName, date, logger); err != nil {
+		return fmt.Errorf("failed to add episodes to the knowledge sink: %v", err)
+	}
+
+	logger.Infof("%s: re-ingested %d messages into %d topics", date, len(messages), len(topicSegments))
+	return nil
+}
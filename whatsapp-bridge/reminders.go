@@ -0,0 +1,380 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// "/remind me tomorrow 10am to follow up with Pedro" schedules a reminder in the chat it was sent in: a scheduled
+// outbox message quoting the /remind message, so it is sent even if the bridge was down at that time. The times
+// the parser below understands are read without the LLM; anything else is handed to it.
+
+const (
+	// Time of day of reminders given a day but no time
+	reminderDefaultHour = 9
+
+	// Time of day of reminders for "tonight" without a time
+	reminderTonightHour = 20
+)
+
+const reminderPrompt = `Read this reminder request. It is now %s (%s, timezone %s).
+
+<request>
+%s
+</request>
+
+Return when to remind, as local time "YYYY-MM-DD HH:MM", and what to remind about, phrased as the reminder itself (e.g. "follow up with Pedro"), in the language of the request. When no time of day is given, use 09:00. If the request has no time or no subject, set "when" to "".`
+
+// reminderAnswerSchema describes the LLM's reading of a request
+var reminderAnswerSchema = &JSONSchema{
+	Type: "object",
+	Properties: map[string]*JSONSchema{
+		"when": {Type: "string", Description: `local time "YYYY-MM-DD HH:MM", or "" when the request doesn't say`},
+		"what": {Type: "string", Description: "what to remind about"},
+	},
+	Required: []string{"when", "what"},
+}
+
+var (
+	reminderClockPattern    = regexp.MustCompile(`^(\d{1,2})(?:[:.h](\d{2}))?(am|pm)?$`)
+	reminderDurationPattern = regexp.MustCompile(`^(\d+)([a-z]*)$`)
+	reminderDatePattern     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+// reminderUnits are the units of "in 20 minutes", by the words that name them
+var reminderUnits = map[string]time.Duration{
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+var reminderWeekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// getReminderLocation returns the timezone reminder times are read in (DAILY_SUMMARY_TIMEZONE, or local time)
+func getReminderLocation() *time.Location {
+	if timezone := os.Getenv("DAILY_SUMMARY_TIMEZONE"); timezone != "" {
+		if loc, err := time.LoadLocation(timezone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// parseReminderClock reads a time of day at the start of words ("10am", "10:30", "3 pm", "noon"), returning the
+// words it used; a bare hour only counts after "at"
+func parseReminderClock(words []string, afterAt bool) (hour, minute, used int) {
+	if len(words) == 0 {
+		return 0, 0, 0
+	}
+	word := words[0]
+	switch word {
+	case "noon", "midday":
+		return 12, 0, 1
+	case "midnight":
+		return 0, 0, 1
+	}
+	match := reminderClockPattern.FindStringSubmatch(word)
+	if match == nil {
+		return 0, 0, 0
+	}
+	used = 1
+	meridiem := match[3]
+	if meridiem == "" && len(words) > 1 && (words[1] == "am" || words[1] == "pm") {
+		meridiem, used = words[1], 2
+	}
+	if meridiem == "" && match[2] == "" && !strings.ContainsAny(word, ":.h") && !afterAt {
+		return 0, 0, 0
+	}
+	hour, _ = strconv.Atoi(match[1])
+	if match[2] != "" {
+		minute, _ = strconv.Atoi(match[2])
+	}
+	switch {
+	case minute > 59, meridiem == "" && hour > 23, meridiem != "" && (hour < 1 || hour > 12):
+		return 0, 0, 0
+	case meridiem == "pm" && hour < 12:
+		hour += 12
+	case meridiem == "am" && hour == 12:
+		hour = 0
+	}
+	return hour, minute, used
+}
+
+// parseReminderDuration reads the duration after "in" ("20 minutes", "2h", "an hour"), returning the words it used
+func parseReminderDuration(words []string) (time.Duration, int) {
+	if len(words) == 0 {
+		return 0, 0
+	}
+	amount, unit, used := 0, "", 1
+	if words[0] == "a" || words[0] == "an" {
+		amount = 1
+	} else if match := reminderDurationPattern.FindStringSubmatch(words[0]); match != nil {
+		amount, _ = strconv.Atoi(match[1])
+		unit = match[2]
+	} else {
+		return 0, 0
+	}
+	if unit == "" {
+		if len(words) < 2 {
+			return 0, 0
+		}
+		unit, used = words[1], 2
+	}
+	size, ok := reminderUnits[unit]
+	if !ok || amount < 1 {
+		return 0, 0
+	}
+	return time.Duration(amount) * size, used
+}
+
+// parseReminder reads a reminder request, "[me] <when> [to|that|about] <what>", where when is "in 20 minutes",
+// or a day (today, tonight, tomorrow, a weekday, next week, YYYY-MM-DD) and/or a time of day ("at 10am", "15:30").
+// ok is false when the request isn't in this form.
+func parseReminder(request string, now time.Time) (at time.Time, text string, ok bool) {
+	words := strings.Fields(request)
+	lower := make([]string, len(words))
+	for i, word := range words {
+		lower[i] = strings.Trim(strings.ToLower(word), ",;")
+	}
+	i := 0
+	if i < len(lower) && lower[i] == "me" {
+		i++
+	}
+
+	var day time.Time
+	hour, minute, clockSet, tonight := reminderDefaultHour, 0, false, false
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for progress := true; progress && i < len(lower); {
+		progress = false
+		word := lower[i]
+		if word == "on" && i+1 < len(lower) && day.IsZero() && at.IsZero() {
+			// "on monday", "on 2026-03-01"
+			if _, isWeekday := reminderWeekdays[lower[i+1]]; isWeekday || reminderDatePattern.MatchString(lower[i+1]) {
+				i++
+				word = lower[i]
+			}
+		}
+		switch weekday, isWeekday := reminderWeekdays[word]; {
+		case word == "in" && at.IsZero() && day.IsZero() && !clockSet:
+			if duration, used := parseReminderDuration(lower[i+1:]); used > 0 {
+				at = now.Add(duration)
+				i += 1 + used
+				progress = true
+			}
+		case !day.IsZero() || !at.IsZero():
+		case word == "today":
+			day, i, progress = today, i+1, true
+		case word == "tonight":
+			day, i, progress, tonight = today, i+1, true, true
+		case word == "tomorrow":
+			day, i, progress = today.AddDate(0, 0, 1), i+1, true
+		case word == "next" && i+1 < len(lower) && lower[i+1] == "week":
+			day, i, progress = today.AddDate(0, 0, 7), i+2, true
+		case isWeekday:
+			days := (int(weekday) - int(now.Weekday()) + 7) % 7
+			if days == 0 {
+				days = 7
+			}
+			day, i, progress = today.AddDate(0, 0, days), i+1, true
+		case reminderDatePattern.MatchString(word):
+			if date, err := time.ParseInLocation("2006-01-02", word, now.Location()); err == nil {
+				day, i, progress = date, i+1, true
+			}
+		}
+		if progress || clockSet || !at.IsZero() || i >= len(lower) {
+			continue
+		}
+		afterAt := lower[i] == "at"
+		start := i
+		if afterAt {
+			start++
+		}
+		if h, m, used := parseReminderClock(lower[start:], afterAt); used > 0 {
+			hour, minute, clockSet = h, m, true
+			i = start + used
+			progress = true
+		}
+	}
+
+	switch {
+	case !at.IsZero():
+	case !day.IsZero():
+		if tonight && !clockSet {
+			hour = reminderTonightHour
+		} else if tonight && hour < 12 {
+			// "tonight at 9"
+			hour += 12
+		}
+		at = time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location())
+	case clockSet:
+		at = time.Date(today.Year(), today.Month(), today.Day(), hour, minute, 0, 0, now.Location())
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+	default:
+		return time.Time{}, "", false
+	}
+	if i < len(lower) && (lower[i] == "to" || lower[i] == "that" || lower[i] == "about") {
+		i++
+	}
+	text = strings.TrimSpace(strings.Join(words[i:], " "))
+	return at, text, text != ""
+}
+
+// readReminderRequest returns when and what a request asks to be reminded of, asking the LLM when the parser
+// can't read it
+func readReminderRequest(command *ChatCommandRequest, now time.Time) (time.Time, string, error) {
+	if at, text, ok := parseReminder(command.Args, now); ok {
+		return at, text, nil
+	}
+	var answer struct {
+		When string `json:"when"`
+		What string `json:"what"`
+	}
+	prompt := fmt.Sprintf(reminderPrompt, now.Format("2006-01-02 15:04"), now.Weekday(), now.Location(), command.Args)
+	if err := callLLMStructured(command.llmContext("command_remind"), prompt, reminderAnswerSchema, &answer, command.logger); err != nil {
+		return time.Time{}, "", err
+	}
+	if answer.When == "" || strings.TrimSpace(answer.What) == "" {
+		return time.Time{}, "", nil
+	}
+	at, err := time.ParseInLocation("2006-01-02 15:04", answer.When, now.Location())
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("unexpected time %q from the LLM", answer.When)
+	}
+	return at, strings.TrimSpace(answer.What), nil
+}
+
+// runRemindCommand handles "/remind me <when> to <what>", "/remind list" and "/remind cancel <id>"
+func runRemindCommand(command *ChatCommandRequest) (string, error) {
+	fields := strings.Fields(strings.ToLower(command.Args))
+	switch {
+	case len(fields) == 0:
+		return "Usage: /remind me tomorrow 10am to follow up with Pedro, /remind list or /remind cancel <id>", nil
+	case len(fields) == 1 && fields[0] == "list":
+		return listReminders(command)
+	case len(fields) == 2 && fields[0] == "cancel":
+		id, err := strconv.ParseInt(strings.TrimPrefix(fields[1], "#"), 10, 64)
+		if err != nil {
+			return "Usage: /remind cancel <id>", nil
+		}
+		return cancelReminder(command, id)
+	}
+
+	loc := getReminderLocation()
+	now := time.Now().In(loc)
+	at, text, err := readReminderRequest(command, now)
+	if err != nil {
+		return "", err
+	}
+	if text == "" {
+		return "❓ I couldn't tell when or what to remind you. Try: /remind me tomorrow 10am to follow up with Pedro", nil
+	}
+	if !at.After(now) {
+		return fmt.Sprintf("❓ %s has already passed", at.Format("Mon Jan 2 2006 15:04")), nil
+	}
+	if at.After(now.Add(outboxMaxScheduleAhead)) {
+		return fmt.Sprintf("❓ %s is more than a year ahead", at.Format("Mon Jan 2 2006 15:04")), nil
+	}
+
+	db := command.messageStore.db
+	result, err := db.Exec(`
+		INSERT INTO reminders (chat_jid, message_id, created_by, text, remind_at, created_at) VALUES (?, ?, ?, ?, ?, ?)
+	`, command.Event.ChatJID, command.Event.ID, command.Event.Sender, text, at.In(time.Local), time.Now())
+	if err != nil {
+		return "", fmt.Errorf("failed to save reminder: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", fmt.Errorf("failed to save reminder: %v", err)
+	}
+	entry, err := messageOutbox.Schedule(SendMessageRequest{
+		Recipient:   command.Event.ChatJID,
+		Message:     "⏰ *Reminder:* " + text,
+		Origin:      messageOriginCommand,
+		ReplyTo:     command.Event.ID,
+		TriggeredBy: "command:remind",
+	}, at)
+	if err != nil {
+		db.Exec("DELETE FROM reminders WHERE id = ?", id)
+		return "", err
+	}
+	if _, err := db.Exec("UPDATE reminders SET outbox_id = ? WHERE id = ?", entry.ID, id); err != nil {
+		return "", fmt.Errorf("failed to save reminder: %v", err)
+	}
+	return fmt.Sprintf("⏰ I'll remind you %s: %s\nCancel with /remind cancel %d", formatReminderTime(at, now), text, id), nil
+}
+
+// formatReminderTime renders when a reminder is due, relative to now for the next days
+func formatReminderTime(at, now time.Time) string {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch days := int(at.Sub(today).Hours() / 24); {
+	case days == 0:
+		return "today at " + at.Format("15:04")
+	case days == 1:
+		return "tomorrow at " + at.Format("15:04")
+	case days < 7:
+		return "on " + at.Format("Monday") + " at " + at.Format("15:04")
+	default:
+		return "on " + at.Format("Mon Jan 2 2006") + " at " + at.Format("15:04")
+	}
+}
+
+// listReminders answers /remind list with the chat's reminders still to be sent
+func listReminders(command *ChatCommandRequest) (string, error) {
+	rows, err := command.messageStore.db.Query(`
+		SELECT r.id, r.text, r.remind_at FROM reminders r JOIN outbox o ON o.id = r.outbox_id
+		WHERE r.chat_jid = ? AND r.cancelled_at IS NULL AND o.status = ?
+		ORDER BY r.remind_at
+	`, command.Event.ChatJID, outboxScheduled)
+	if err != nil {
+		return "", fmt.Errorf("failed to list reminders: %v", err)
+	}
+	defer rows.Close()
+
+	now := time.Now().In(getReminderLocation())
+	var lines []string
+	for rows.Next() {
+		var id int64
+		var text string
+		var at time.Time
+		if err := rows.Scan(&id, &text, &at); err != nil {
+			return "", err
+		}
+		lines = append(lines, fmt.Sprintf("#%d %s: %s", id, formatReminderTime(at.In(now.Location()), now), text))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(lines) == 0 {
+		return "⏰ No reminders pending in this chat", nil
+	}
+	return "⏰ *Reminders*\n" + strings.Join(lines, "\n"), nil
+}
+
+// cancelReminder answers /remind cancel <id> for a reminder of the chat that wasn't sent yet
+func cancelReminder(command *ChatCommandRequest, id int64) (string, error) {
+	var outboxID int64
+	err := command.messageStore.db.QueryRow("SELECT COALESCE(outbox_id, 0) FROM reminders WHERE id = ? AND chat_jid = ? AND cancelled_at IS NULL",
+		id, command.Event.ChatJID).Scan(&outboxID)
+	if err != nil {
+		return fmt.Sprintf("❓ No reminder #%d in this chat", id), nil
+	}
+	if err := messageOutbox.Cancel(outboxID); err != nil {
+		return fmt.Sprintf("❌ Reminder #%d can't be cancelled: %v", id, err), nil
+	}
+	if _, err := command.messageStore.db.Exec("UPDATE reminders SET cancelled_at = ? WHERE id = ?", time.Now(), id); err != nil {
+		return "", fmt.Errorf("failed to cancel reminder: %v", err)
+	}
+	return fmt.Sprintf("✅ Reminder #%d cancelled", id), nil
+}
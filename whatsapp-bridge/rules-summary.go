@@ -0,0 +1,295 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const (
+	summarizerLLM   = "llm"
+	summarizerRules = "rules"
+
+	// Days of the group's history used as the TF-IDF corpus
+	rulesSummaryCorpusDays = 30
+	rulesSummaryKeywords   = 10
+	rulesSummaryLinks      = 5
+	rulesSummarySenders    = 3
+)
+
+var rulesSummaryLinkPattern = regexp.MustCompile(`https?://[^\s<>"')\]]+`)
+
+// Common Portuguese and English words that never make good keywords
+var rulesSummaryStopwords = rulesSummaryWordSet(`a o e é de da do das dos em no na nos nas um uma uns umas para pra pro por com sem que se não nao mas
+	mais muito muita já ja tem ter foi ser são sao está esta estão estao isso isto esse essa este aquele aquela ele ela eles elas eu você voce
+	vocês voces nós nos me te lhe meu minha seu sua nosso nossa ao aos à às como quando onde quem qual porque pois também tambem só so sim
+	aqui ali lá la então entao ainda agora hoje amanhã amanha ontem vai vou vamos pode posso acho bem bom boa tá ta né ne aí ai ok kkk kkkk
+	kkkkk haha hahaha rs the and for that this with you are was have has not but from they will what can all just about your there their
+	would could should been were our out get got its it's also into than then them some any more one two http https www com`)
+
+// rulesSummaryWordSet splits a whitespace-separated word list into a set
+func rulesSummaryWordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+// getGroupSummarizer returns how a group is summarized: "llm" (default) or "rules"
+func getGroupSummarizer(config *BridgeConfig, groupJID string) string {
+	if summarizer := strings.ToLower(config.getGroupConfig(groupJID).Summarizer); summarizer == summarizerRules {
+		return summarizerRules
+	}
+	return summarizerLLM
+}
+
+// listRulesSummaryGroups returns the groups configured explicitly with the rules summarizer
+func listRulesSummaryGroups(config *BridgeConfig) []string {
+	var groups []string
+	for groupJID := range config.Groups {
+		if groupJID != "default" && getGroupSummarizer(config, groupJID) == summarizerRules {
+			groups = append(groups, groupJID)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// RulesSummaryStats are the numbers behind a rules-based summary
+type RulesSummaryStats struct {
+	Messages     int
+	Participants int
+	TopSenders   []rulesSummaryCount
+	BusiestHour  int
+	Keywords     []string
+	Links        []rulesSummaryCount
+}
+
+type rulesSummaryCount struct {
+	Value string
+	Count int
+}
+
+// buildRulesSummary produces a digest of the day without an LLM: counts, top links and TF-IDF keywords
+func buildRulesSummary(messages []DailySummaryMessage, groupJID, groupName, date string, logger waLog.Logger) string {
+	stats := computeRulesSummaryStats(messages)
+
+	// Keywords are weighted against the group's own recent history, so recurring chatter doesn't dominate
+	corpus, err := loadRulesSummaryCorpus(groupJID, date)
+	if err != nil {
+		logger.Warnf("Failed to load keyword history, using the day alone: %v", err)
+	}
+	stats.Keywords = topTFIDFKeywords(messages, corpus, rulesSummaryKeywords)
+
+	var summary strings.Builder
+	summary.WriteString(fmt.Sprintf("📋 *%s* — %s (automatic digest)\n\n", groupName, date))
+	summary.WriteString(fmt.Sprintf("💬 %d messages from %d people\n", stats.Messages, stats.Participants))
+
+	var senders []string
+	for _, sender := range stats.TopSenders {
+		senders = append(senders, fmt.Sprintf("%s (%d)", sender.Value, sender.Count))
+	}
+	if len(senders) > 0 {
+		summary.WriteString(fmt.Sprintf("👥 Most active: %s\n", strings.Join(senders, ", ")))
+	}
+	if stats.Messages > 0 {
+		summary.WriteString(fmt.Sprintf("⏰ Busiest hour: %02d:00\n", stats.BusiestHour))
+	}
+	if len(stats.Keywords) > 0 {
+		summary.WriteString(fmt.Sprintf("🔑 Keywords: %s\n", strings.Join(stats.Keywords, ", ")))
+	}
+	if len(stats.Links) > 0 {
+		summary.WriteString("🔗 Links:\n")
+		for _, link := range stats.Links {
+			if link.Count > 1 {
+				summary.WriteString(fmt.Sprintf("  • %s (%dx)\n", link.Value, link.Count))
+			} else {
+				summary.WriteString(fmt.Sprintf("  • %s\n", link.Value))
+			}
+		}
+	}
+	return strings.TrimSpace(summary.String())
+}
+
+// computeRulesSummaryStats counts messages, senders, hours and links
+func computeRulesSummaryStats(messages []DailySummaryMessage) RulesSummaryStats {
+	stats := RulesSummaryStats{Messages: len(messages)}
+	perSender := make(map[string]int)
+	perLink := make(map[string]int)
+	var perHour [24]int
+
+	for _, message := range messages {
+		perSender[message.Sender]++
+		if !message.Time.IsZero() {
+			perHour[message.Time.Hour()]++
+		}
+		for _, link := range rulesSummaryLinkPattern.FindAllString(message.Content, -1) {
+			perLink[strings.TrimRight(link, ".,;:!?")]++
+		}
+	}
+
+	stats.Participants = len(perSender)
+	stats.TopSenders = topRulesSummaryCounts(perSender, rulesSummarySenders)
+	stats.Links = topRulesSummaryCounts(perLink, rulesSummaryLinks)
+	for hour := range perHour {
+		if perHour[hour] > perHour[stats.BusiestHour] {
+			stats.BusiestHour = hour
+		}
+	}
+	return stats
+}
+
+// topRulesSummaryCounts returns the n most frequent values, ties in alphabetical order
+func topRulesSummaryCounts(counts map[string]int, n int) []rulesSummaryCount {
+	var sorted []rulesSummaryCount
+	for value, count := range counts {
+		sorted = append(sorted, rulesSummaryCount{Value: value, Count: count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Value < sorted[j].Value
+	})
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// rulesSummaryTerms splits text into lowercase keyword candidates, without links, stopwords and short words
+func rulesSummaryTerms(text string) []string {
+	text = rulesSummaryLinkPattern.ReplaceAllString(strings.ToLower(text), " ")
+	var terms []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if len([]rune(word)) < 3 || rulesSummaryStopwords[word] || isAllDigits(word) {
+			continue
+		}
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// isAllDigits reports whether a word is a number
+func isAllDigits(word string) bool {
+	for _, r := range word {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadRulesSummaryCorpus returns the set of terms of each earlier day of the group, as TF-IDF documents
+func loadRulesSummaryCorpus(groupJID, date string) ([]map[string]bool, error) {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT substr(timestamp, 1, 10), COALESCE(content, '') FROM messages
+		WHERE chat_jid = ? AND timestamp >= ? AND timestamp < ? AND content != ''
+	`, groupJID, day.AddDate(0, 0, -rulesSummaryCorpusDays).Format("2006-01-02"), date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	documents := make(map[string]map[string]bool)
+	for rows.Next() {
+		var messageDay, content string
+		if err := rows.Scan(&messageDay, &content); err != nil {
+			return nil, err
+		}
+		if documents[messageDay] == nil {
+			documents[messageDay] = make(map[string]bool)
+		}
+		for _, term := range rulesSummaryTerms(content) {
+			documents[messageDay][term] = true
+		}
+	}
+
+	var corpus []map[string]bool
+	for _, document := range documents {
+		corpus = append(corpus, document)
+	}
+	return corpus, rows.Err()
+}
+
+// topTFIDFKeywords ranks the day's terms by frequency today times rarity on earlier days
+func topTFIDFKeywords(messages []DailySummaryMessage, corpus []map[string]bool, n int) []string {
+	termFrequency := make(map[string]int)
+	for _, message := range messages {
+		for _, term := range rulesSummaryTerms(message.Content) {
+			termFrequency[term]++
+		}
+	}
+
+	type scoredTerm struct {
+		term  string
+		score float64
+	}
+	var scored []scoredTerm
+	documents := float64(len(corpus) + 1) // today counts as a document
+	for term, frequency := range termFrequency {
+		documentFrequency := 1.0
+		for _, document := range corpus {
+			if document[term] {
+				documentFrequency++
+			}
+		}
+		idf := math.Log((documents+1)/(documentFrequency+1)) + 1
+		scored = append(scored, scoredTerm{term: term, score: float64(frequency) * idf})
+	}
+
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].score != scored[j].score {
+			return scored[i].score > scored[j].score
+		}
+		return scored[i].term < scored[j].term
+	})
+
+	var keywords []string
+	for _, term := range scored {
+		if len(keywords) == n {
+			break
+		}
+		keywords = append(keywords, term.term)
+	}
+	return keywords
+}
+
+// sendRulesSummary builds the rules-based digest of a group's day, saves it and sends it
+func sendRulesSummary(groupJID, sendTo string, startOfDay, endOfDay time.Time, logger waLog.Logger) error {
+	messages, err := getMessagesFromGroup(groupJID, startOfDay, endOfDay, logger)
+	if err != nil {
+		return fmt.Errorf("failed to get messages: %v", err)
+	}
+	if len(messages) == 0 {
+		logger.Infof("No messages found for today in group %s", groupJID)
+		return nil
+	}
+
+	date := startOfDay.Format("2006-01-02")
+	summary := buildRulesSummary(messages, groupJID, getGroupName(groupJID, logger), date, logger)
+	if err := saveSummary(groupJID, date, summary, nil); err != nil {
+		logger.Warnf("Failed to save summary: %v", err)
+	}
+	// Sent as is: translating would add the LLM cost these digests avoid
+	return sendSummary(summary, sendTo, groupJID, nil, logger)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const (
+	diagnosticsDir           = "store/diagnostics"
+	defaultFailureThreshold  = 3
+	diagnosticsLogTailLines  = 200
+	diagnosticsResponseLimit = 2000
+)
+
+// diagnosticsLogFiles lists the log files whose tails are bundled into diagnostics reports
+var diagnosticsLogFiles = []string{
+	"store/daily-summary.log",
+	"store/cron.log",
+	"store/entity-sync.log",
+}
+
+// PipelineRunRecorder tracks the outcome of each pipeline stage per day and triggers the runbook on repeated failures
+type PipelineRunRecorder struct {
+	db      *sql.DB
+	runDate string
+	logger  waLog.Logger
+}
+
+// NewPipelineRunRecorder opens the message database and prepares the pipeline_runs table
+func NewPipelineRunRecorder(runDate string, logger waLog.Logger) (*PipelineRunRecorder, error) {
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message database: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS pipeline_runs (
+			stage TEXT,
+			run_date TEXT,
+			success BOOLEAN,
+			error TEXT,
+			last_response TEXT,
+			reported BOOLEAN DEFAULT 0,
+			recorded_at TIMESTAMP,
+			PRIMARY KEY (stage, run_date)
+		);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create pipeline_runs table: %v", err)
+	}
+
+	return &PipelineRunRecorder{db: db, runDate: runDate, logger: logger}, nil
+}
+
+// Close the database connection
+func (r *PipelineRunRecorder) Close() error {
+	return r.db.Close()
+}
+
+// Record stores the result of a stage for the current run date.
+// When a stage has failed on enough consecutive days, a diagnostics report is written and the admin chat is notified.
+func (r *PipelineRunRecorder) Record(stage string, stageErr error) {
+	success := stageErr == nil
+	errText := ""
+	lastResponse := ""
+	if !success {
+		errText = stageErr.Error()
+		lastResponse = truncateForDiagnostics(lastClaudeResponseBody, diagnosticsResponseLimit)
+	}
+
+	_, err := r.db.Exec(
+		`INSERT OR REPLACE INTO pipeline_runs (stage, run_date, success, error, last_response, reported, recorded_at)
+		VALUES (?, ?, ?, ?, ?, 0, ?)`,
+		stage, r.runDate, success, errText, lastResponse, time.Now(),
+	)
+	if err != nil {
+		r.logger.Warnf("Failed to record pipeline result for stage %s: %v", stage, err)
+		return
+	}
+
+	if success {
+		return
+	}
+
+	streak, err := r.consecutiveFailures(stage)
+	if err != nil {
+		r.logger.Warnf("Failed to count consecutive failures for stage %s: %v", stage, err)
+		return
+	}
+
+	threshold := getFailureThreshold()
+	if streak < threshold {
+		return
+	}
+
+	r.logger.Warnf("Stage %s has failed %d days in a row, running diagnostics runbook", stage, streak)
+
+	reportPath, err := r.writeDiagnosticsReport(stage, streak)
+	if err != nil {
+		r.logger.Errorf("Failed to write diagnostics report for stage %s: %v", stage, err)
+		return
+	}
+
+	r.logger.Infof("Diagnostics report written to %s", reportPath)
+
+	// Notify the admin chat once per streak
+	notification := fmt.Sprintf("⚠️ Pipeline stage *%s* has failed %d days in a row.\nLast error: %s\nDiagnostics report: %s",
+		stage, streak, truncateForDiagnostics(errText, 300), reportPath)
+	if err := sendToRecipient(notification, getAdminChatJID(), r.logger); err != nil {
+		r.logger.Errorf("Failed to notify admin chat about stage %s: %v", stage, err)
+		return
+	}
+
+	r.db.Exec("UPDATE pipeline_runs SET reported = 1 WHERE stage = ? AND run_date = ?", stage, r.runDate)
+}
+
+// consecutiveFailures counts how many of the most recent recorded days failed for a stage
+func (r *PipelineRunRecorder) consecutiveFailures(stage string) (int, error) {
+	rows, err := r.db.Query("SELECT success FROM pipeline_runs WHERE stage = ? ORDER BY run_date DESC LIMIT 60", stage)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	streak := 0
+	for rows.Next() {
+		var success bool
+		if err := rows.Scan(&success); err != nil {
+			return 0, err
+		}
+		if success {
+			break
+		}
+		streak++
+	}
+
+	return streak, nil
+}
+
+// writeDiagnosticsReport bundles recent failures, logs and a config snapshot into a Markdown report
+func (r *PipelineRunRecorder) writeDiagnosticsReport(stage string, streak int) (string, error) {
+	if err := os.MkdirAll(diagnosticsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create diagnostics directory: %v", err)
+	}
+
+	var report strings.Builder
+	report.WriteString(fmt.Sprintf("# Diagnostics: stage %s\n\n", stage))
+	report.WriteString(fmt.Sprintf("- Generated at: %s\n", time.Now().Format(time.RFC3339)))
+	report.WriteString(fmt.Sprintf("- Consecutive failed days: %d\n\n", streak))
+
+	// Recent failures with truncated responses
+	report.WriteString("## Recent failures\n\n")
+	rows, err := r.db.Query(
+		"SELECT run_date, COALESCE(error, ''), COALESCE(last_response, '') FROM pipeline_runs WHERE stage = ? ORDER BY run_date DESC LIMIT ?",
+		stage, streak,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to query recent failures: %v", err)
+	}
+	for rows.Next() {
+		var runDate, errText, lastResponse string
+		if err := rows.Scan(&runDate, &errText, &lastResponse); err != nil {
+			rows.Close()
+			return "", fmt.Errorf("failed to scan failure row: %v", err)
+		}
+		report.WriteString(fmt.Sprintf("### %s\n\nError:\n```\n%s\n```\n\n", runDate, errText))
+		if lastResponse != "" {
+			report.WriteString(fmt.Sprintf("Last LLM response (truncated):\n```\n%s\n```\n\n", lastResponse))
+		}
+	}
+	rows.Close()
+
+	// Config snapshot with secrets masked
+	report.WriteString("## Configuration snapshot\n\n```\n")
+	report.WriteString(configSnapshot())
+	report.WriteString("```\n\n")
+
+	// Log tails
+	report.WriteString("## Recent logs\n\n")
+	for _, logFile := range diagnosticsLogFiles {
+		tail, err := tailFile(logFile, diagnosticsLogTailLines)
+		if err != nil {
+			continue
+		}
+		report.WriteString(fmt.Sprintf("### %s\n\n```\n%s\n```\n\n", logFile, tail))
+	}
+
+	reportPath := filepath.Join(diagnosticsDir, fmt.Sprintf("report-%s-%s.md", stage, r.runDate))
+	if err := os.WriteFile(reportPath, []byte(report.String()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write report: %v", err)
+	}
+
+	absPath, err := filepath.Abs(reportPath)
+	if err != nil {
+		return reportPath, nil
+	}
+	return absPath, nil
+}
+
+// configSnapshot returns the relevant environment configuration with secret values masked
+func configSnapshot() string {
+	var lines []string
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		if !isBridgeConfigKey(key) {
+			continue
+		}
+		if isSecretConfigKey(key) && value != "" {
+			value = "****"
+		}
+		lines = append(lines, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// isBridgeConfigKey reports whether an environment variable belongs to the bridge configuration
+func isBridgeConfigKey(key string) bool {
+	for _, prefix := range []string{"DAILY_SUMMARY_", "CLAUDE_", "GRAPHITI_", "ENTITY_SYNC_", "RUNBOOK_", "ADMIN_", "KNOWLEDGE_", "EMBEDDING_", "VECTOR_", "NEO4J_", "CONFIG_", "BRIDGE_", "ANTHROPIC_", "LLM_", "OPENAI_", "WEEKLY_DIGEST_", "TZ"} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSecretConfigKey reports whether an environment variable likely holds a secret
+func isSecretConfigKey(key string) bool {
+	upper := strings.ToUpper(key)
+	for _, marker := range []string{"KEY", "TOKEN", "SECRET", "PASSWORD"} {
+		if strings.Contains(upper, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// tailFile returns the last n lines of a file
+func tailFile(path string, n int) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// truncateForDiagnostics shortens text to at most limit bytes
+func truncateForDiagnostics(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	return text[:limit] + "... [truncated]"
+}
+
+// getFailureThreshold returns how many consecutive failed days trigger the runbook
+func getFailureThreshold() int {
+	threshold, err := strconv.Atoi(os.Getenv("RUNBOOK_FAILURE_THRESHOLD"))
+	if err != nil || threshold < 1 {
+		return defaultFailureThreshold
+	}
+	return threshold
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const (
+	startupCounterFile = "store/startup-counter.json"
+	safeModeFile       = "store/safe-mode.json"
+)
+
+// StartupCounter tracks bridge starts that didn't reach a stable run, to detect crash loops
+type StartupCounter struct {
+	Starts    int       `json:"starts"`
+	LastStart time.Time `json:"last_start"`
+}
+
+// SafeModeState is persisted while safe mode is on; it stays on across restarts until an operator clears it
+type SafeModeState struct {
+	Reason    string    `json:"reason"`
+	EnabledBy string    `json:"enabled_by"` // "crash-loop", "env", "admin-chat" or "api"
+	EnabledAt time.Time `json:"enabled_at"`
+}
+
+// getSafeModeThreshold returns how many unstable starts in a row trigger safe mode (SAFE_MODE_CRASH_THRESHOLD, default 3; 0 disables detection)
+func getSafeModeThreshold() int {
+	if threshold, err := strconv.Atoi(os.Getenv("SAFE_MODE_CRASH_THRESHOLD")); err == nil && threshold >= 0 {
+		return threshold
+	}
+	return 3
+}
+
+// getSafeModeStableAfter returns how long the bridge must run before a start counts as stable (SAFE_MODE_STABLE_SECONDS, default 300)
+func getSafeModeStableAfter() time.Duration {
+	if seconds, err := strconv.Atoi(os.Getenv("SAFE_MODE_STABLE_SECONDS")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return 300 * time.Second
+}
+
+// recordStartup counts this start and turns safe mode on when the bridge keeps restarting before becoming stable.
+// It returns the safe mode state, or nil when the bridge starts normally.
+func recordStartup(logger waLog.Logger) *SafeModeState {
+	if os.Getenv("SAFE_MODE") == "true" {
+		if state, _ := loadSafeMode(); state == nil {
+			if err := setSafeMode("SAFE_MODE=true", "env"); err != nil {
+				logger.Warnf("Failed to persist safe mode: %v", err)
+			}
+		}
+	}
+
+	counter := StartupCounter{}
+	if data, err := os.ReadFile(startupCounterFile); err == nil {
+		json.Unmarshal(data, &counter)
+	}
+	counter.Starts++
+	counter.LastStart = time.Now()
+	if err := saveStartupCounter(counter); err != nil {
+		logger.Warnf("Failed to update startup counter: %v", err)
+	}
+
+	if threshold := getSafeModeThreshold(); threshold > 0 && counter.Starts >= threshold {
+		if state, _ := loadSafeMode(); state == nil {
+			reason := fmt.Sprintf("%d starts in a row without running for %v", counter.Starts, getSafeModeStableAfter())
+			logger.Warnf("Crash loop detected: %s", reason)
+			if err := setSafeMode(reason, "crash-loop"); err != nil {
+				logger.Errorf("Failed to enable safe mode: %v", err)
+			}
+		}
+	}
+
+	state, err := loadSafeMode()
+	if err != nil {
+		logger.Warnf("Failed to read safe mode state: %v", err)
+	}
+	return state
+}
+
+// markStartupStable resets the startup counter once the bridge has been running long enough
+func markStartupStable(logger waLog.Logger) {
+	time.AfterFunc(getSafeModeStableAfter(), func() {
+		if err := saveStartupCounter(StartupCounter{}); err != nil {
+			logger.Warnf("Failed to reset startup counter: %v", err)
+		}
+	})
+}
+
+// saveStartupCounter persists the startup counter
+func saveStartupCounter(counter StartupCounter) error {
+	if err := os.MkdirAll("store", 0755); err != nil {
+		return fmt.Errorf("failed to create store directory: %v", err)
+	}
+	data, err := json.MarshalIndent(counter, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(startupCounterFile, data, 0644)
+}
+
+// loadSafeMode returns the safe mode state, or nil when safe mode is off
+func loadSafeMode() (*SafeModeState, error) {
+	data, err := os.ReadFile(safeModeFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read safe mode file: %v", err)
+	}
+
+	var state SafeModeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		// A damaged flag file still means someone wanted safe mode on
+		return &SafeModeState{Reason: "unreadable safe mode file"}, nil
+	}
+	return &state, nil
+}
+
+// isSafeModeActive reports whether LLM jobs and outbound automation are disabled
+func isSafeModeActive() bool {
+	state, err := loadSafeMode()
+	return state != nil || err != nil
+}
+
+// setSafeMode turns safe mode on
+func setSafeMode(reason, enabledBy string) error {
+	if err := os.MkdirAll("store", 0755); err != nil {
+		return fmt.Errorf("failed to create store directory: %v", err)
+	}
+	data, err := json.MarshalIndent(SafeModeState{Reason: reason, EnabledBy: enabledBy, EnabledAt: time.Now()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal safe mode state: %v", err)
+	}
+	return os.WriteFile(safeModeFile, data, 0644)
+}
+
+// clearSafeMode turns safe mode off and resets the crash loop detection
+func clearSafeMode() error {
+	if err := os.Remove(safeModeFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove safe mode file: %v", err)
+	}
+	return saveStartupCounter(StartupCounter{})
+}
+
+// exitIfSafeMode is called by scheduled jobs; it returns true (after logging why) when the job must not run
+func exitIfSafeMode(logger waLog.Logger) bool {
+	state, err := loadSafeMode()
+	if err != nil {
+		logger.Warnf("Safe mode state unreadable, not running: %v", err)
+		return true
+	}
+	if state == nil {
+		return false
+	}
+	logger.Warnf("Safe mode is on since %s (%s), not running. Clear it with /safemode off in the admin chat or POST /api/safe-mode.",
+		state.EnabledAt.Format("2006-01-02 15:04:05"), state.Reason)
+	return true
+}
+
+// safeModeStatusText describes the safe mode state for the admin chat and the API
+func safeModeStatusText() string {
+	state, err := loadSafeMode()
+	if err != nil {
+		return fmt.Sprintf("❌ %v", err)
+	}
+	if state == nil {
+		return "Safe mode: off"
+	}
+	return fmt.Sprintf("Safe mode: on (by %s at %s)\nReason: %s\nLLM jobs and outbound automation are disabled; messages are still archived.",
+		state.EnabledBy, state.EnabledAt.Format("2006-01-02 15:04"), state.Reason)
+}
+
+// handleSafeModeCommand runs a "/safemode on|off|status" admin command and returns the reply
+func handleSafeModeCommand(command, updatedBy string) string {
+	fields := strings.Fields(command)
+	action := "status"
+	if len(fields) > 1 {
+		action = strings.ToLower(fields[1])
+	}
+
+	switch action {
+	case "on":
+		if err := setSafeMode("enabled manually", updatedBy); err != nil {
+			return fmt.Sprintf("❌ %v", err)
+		}
+		return "🛟 Safe mode on. LLM jobs and outbound automation are disabled until /safemode off."
+	case "off":
+		if err := clearSafeMode(); err != nil {
+			return fmt.Sprintf("❌ %v", err)
+		}
+		return "✅ Safe mode off. LLM jobs and outbound automation are enabled again."
+	case "status":
+		return safeModeStatusText()
+	default:
+		return "Usage: /safemode on | off | status"
+	}
+}
+
+// isSafeModeCommand reports whether a message is a /safemode admin command
+func isSafeModeCommand(content string) bool {
+	fields := strings.Fields(content)
+	return len(fields) > 0 && strings.EqualFold(fields[0], "/safemode")
+}
+
+// handleSafeModeAPI serves GET (status) and POST {"enabled": true|false} on /api/safe-mode
+func handleSafeModeAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Enabled *bool  `json:"enabled"`
+			Reason  string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+			http.Error(w, "Invalid request format, expected {\"enabled\": true|false}", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		if *req.Enabled {
+			reason := req.Reason
+			if reason == "" {
+				reason = "enabled manually"
+			}
+			err = setSafeMode(reason, "api")
+		} else {
+			err = clearSafeMode()
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state, err := loadSafeMode()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":   true,
+		"safe_mode": state != nil,
+		"state":     state,
+		"message":   safeModeStatusText(),
+	})
+}
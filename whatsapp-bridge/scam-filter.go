@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// With SCAM_FILTER=true the bridge checks the messages of unknown numbers (not saved as contacts, and never
+// written to) for scam and phishing signs: shortened or odd links, prizes, urgency, requests for codes or money,
+// "this is my new number". Likely scams are quarantined from the summaries and suspicious messages are only
+// reported, both in the weekly digest. With SCAM_FILTER_LLM=true the LLM decides on every message with a sign.
+
+const (
+	// Heuristic scores from which a message is a scam, or suspicious
+	scamScoreThreshold       = 60
+	suspiciousScoreThreshold = 30
+
+	// Days of flagged messages listed by /api/suspicious unless given
+	defaultSuspiciousDays = 7
+
+	// Messages checked at once; the others wait in the filter's queue
+	scamFilterWorkers = 4
+)
+
+const scamClassifierPrompt = `Classify this WhatsApp message from an unknown number. Is it a scam or phishing attempt (fake prizes, impersonation, requests for codes, passwords or money, fake jobs or investments, malicious links), suspicious, or legitimate?
+
+Signs found by the filter: %s
+
+<message>
+%s
+</message>`
+
+// scamClassifierSchema describes the LLM's verdict on a message
+var scamClassifierSchema = &JSONSchema{
+	Type: "object",
+	Properties: map[string]*JSONSchema{
+		"verdict": {Type: "string", Description: `"scam", "suspicious" or "legitimate"`},
+		"reason":  {Type: "string", Description: "the main reason, in a few words"},
+	},
+	Required: []string{"verdict", "reason"},
+}
+
+// scamSignal is a sign of a scam, with how much it adds to a message's score
+type scamSignal struct {
+	reason  string
+	weight  int
+	pattern *regexp.Regexp
+}
+
+// scamSignals are matched against the text of messages, in English and Portuguese
+var scamSignals = []scamSignal{
+	{"link", 10, regexp.MustCompile(`(?i)https?://|www\.`)},
+	{"shortened link", 25, regexp.MustCompile(`(?i)\b(?:bit\.ly|tinyurl\.com|t\.co|cutt\.ly|is\.gd|goo\.gl|ow\.ly|rebrand\.ly|shorturl\.at|encurtador\.com\.br)/`)},
+	{"link to an IP address", 30, regexp.MustCompile(`(?i)https?://\d{1,3}(?:\.\d{1,3}){3}`)},
+	{"unusual link domain", 20, regexp.MustCompile(`(?i)\b[a-z0-9-]+\.(?:xyz|top|click|icu|buzz|live|online|site|shop|rest|monster|quest|cfd|sbs)(?:/|\s|$)`)},
+	{"prize", 25, regexp.MustCompile(`(?i)\b(?:you(?:'ve| have)? won|winner|prize|giveaway|gift card|claim your|voc[eê] ganhou|ganhador|pr[eê]mio|sorteio|resgate seu)`)},
+	{"urgency", 15, regexp.MustCompile(`(?i)\b(?:urgent|immediately|within 24 hours|act now|last chance|account (?:will be )?(?:suspended|blocked|locked)|urgente|imediatamente|[uú]ltima chance|conta (?:ser[aá] )?(?:bloqueada|suspensa))`)},
+	{"asks for a code or password", 35, regexp.MustCompile(`(?i)\b(?:verification code|security code|one-time (?:code|password)|otp|password|pin code|c[oó]digo (?:de verifica[cç][aã]o|de seguran[cç]a|que (?:chegou|voc[eê] recebeu))|senha)\b`)},
+	{"payment request", 20, regexp.MustCompile(`(?i)\b(?:pix|wire transfer|bank transfer|bitcoin|btc|usdt|crypto|transfer[eê]ncia|dep[oó]sito|boleto)\b`)},
+	{"investment promise", 25, regexp.MustCompile(`(?i)\b(?:guaranteed (?:profit|returns?)|double your money|passive income|forex|trading signals?|lucro garantido|renda extra|rendimento garantido)\b`)},
+	{"new number claim", 35, regexp.MustCompile(`(?i)\b(?:(?:this is|here'?s) my new number|changed my number|new phone number|troquei (?:de|o) n[uú]mero|meu n[uú]mero novo|esse [eé] meu novo n[uú]mero)`)},
+	{"job offer", 20, regexp.MustCompile(`(?i)\b(?:part[- ]time job|work from home|earn \$?\d+ (?:per|a) day|like (?:youtube )?videos|trabalho (?:remoto|em casa)|ganhe r\$ ?\d+)`)},
+}
+
+// ScamFilter flags the messages of unknown numbers that look like scams as they arrive. The message handler
+// queues every stored message for it, so none is missed in a burst, and a few workers check them in turn.
+type ScamFilter struct {
+	client       *whatsmeow.Client
+	messageStore *MessageStore
+	useLLM       bool
+	logger       waLog.Logger
+
+	mu      sync.Mutex
+	ready   *sync.Cond
+	pending []StreamEvent
+}
+
+// scamFilter is the running filter, nil unless SCAM_FILTER=true
+var scamFilter *ScamFilter
+
+// MessageFlag is a message flagged by the scam filter, as listed by /api/suspicious
+type MessageFlag struct {
+	MessageID  string    `json:"message_id"`
+	ChatJID    string    `json:"chat_jid"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name,omitempty"`
+	Content    string    `json:"content"`
+	Verdict    string    `json:"verdict"` // "scam" (quarantined from summaries) or "suspicious"
+	Score      int       `json:"score"`
+	Reasons    []string  `json:"reasons"`
+	Classifier string    `json:"classifier"` // "rules" or "llm"
+	FlaggedAt  time.Time `json:"flagged_at"`
+}
+
+// scamFilterEnabled reports whether the messages of unknown numbers are checked for scams (SCAM_FILTER=true)
+func scamFilterEnabled() bool {
+	return os.Getenv("SCAM_FILTER") == "true"
+}
+
+// scoreScamMessage adds up the scam signs of a message, at most 100, with the reasons
+func scoreScamMessage(text string, firstMessage bool) (int, []string) {
+	score := 0
+	var reasons []string
+	for _, signal := range scamSignals {
+		if signal.pattern.MatchString(text) {
+			score += signal.weight
+			reasons = append(reasons, signal.reason)
+		}
+	}
+	// A first message only counts along with another sign
+	if firstMessage && score > 0 {
+		score += 15
+		reasons = append(reasons, "first message")
+	}
+	return min(score, 100), reasons
+}
+
+// scamVerdict returns the verdict of a heuristic score, or "" for a legitimate message
+func scamVerdict(score int) string {
+	switch {
+	case score >= scamScoreThreshold:
+		return scamVerdictScam
+	case score >= suspiciousScoreThreshold:
+		return scamVerdictSuspicious
+	default:
+		return ""
+	}
+}
+
+// startScamFilter starts checking the messages of unknown numbers for scams, with SCAM_FILTER=true
+func startScamFilter(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) {
+	if !scamFilterEnabled() {
+		return
+	}
+	filter := &ScamFilter{client: client, messageStore: messageStore, useLLM: os.Getenv("SCAM_FILTER_LLM") == "true", logger: logger}
+	filter.ready = sync.NewCond(&filter.mu)
+	for i := 0; i < scamFilterWorkers; i++ {
+		go filter.work()
+	}
+	scamFilter = filter
+	logger.Infof("Checking messages of unknown numbers for scams (LLM: %t)", filter.useLLM)
+}
+
+// enqueue queues a stored message to be checked, unless I sent it. The queue has no limit, so a burst waits for
+// the workers instead of being dropped or holding up message handling.
+func (filter *ScamFilter) enqueue(event StreamEvent) {
+	if filter == nil || event.IsFromMe || event.Origin != "" || scamFilterText(event) == "" {
+		return
+	}
+	filter.mu.Lock()
+	filter.pending = append(filter.pending, event)
+	filter.mu.Unlock()
+	filter.ready.Signal()
+}
+
+// work checks the queued messages, oldest first, for as long as the bridge runs
+func (filter *ScamFilter) work() {
+	for {
+		filter.mu.Lock()
+		for len(filter.pending) == 0 {
+			filter.ready.Wait()
+		}
+		event := filter.pending[0]
+		filter.pending[0] = StreamEvent{}
+		filter.pending = filter.pending[1:]
+		filter.mu.Unlock()
+
+		filter.check(event, scamFilterText(event))
+	}
+}
+
+// scamFilterText returns what the filter looks at in a message: its text and the name of its file
+func scamFilterText(event StreamEvent) string {
+	return strings.TrimSpace(event.Content + " " + event.Filename)
+}
+
+// check flags a message when its sender is unknown and it looks like a scam
+func (filter *ScamFilter) check(event StreamEvent, text string) {
+	if filter.isKnownSender(event.Sender) {
+		return
+	}
+	score, reasons := scoreScamMessage(text, filter.isFirstMessage(event))
+	if score == 0 {
+		return
+	}
+	verdict, classifier := scamVerdict(score), "rules"
+	if filter.useLLM {
+		llmVerdict, reason, err := filter.classify(event, text, reasons)
+		if err != nil {
+			filter.logger.Warnf("Scam classifier failed for %s, keeping the heuristic verdict: %v", event.ID, err)
+		} else {
+			verdict, classifier = llmVerdict, "llm"
+			if reason != "" {
+				reasons = append(reasons, reason)
+			}
+		}
+	}
+	if verdict == "" {
+		return
+	}
+
+	_, err := filter.messageStore.db.Exec(`
+		INSERT INTO message_flags (message_id, chat_jid, sender, verdict, score, reasons, classifier, flagged_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid) DO NOTHING
+	`, event.ID, event.ChatJID, event.Sender, verdict, score, strings.Join(reasons, ", "), classifier, time.Now())
+	if err != nil {
+		filter.logger.Warnf("Failed to flag message %s: %v", event.ID, err)
+		return
+	}
+	filter.logger.Infof("Flagged message %s from %s in %s as %s (score %d: %s)", event.ID, event.Sender, event.ChatJID, verdict, score, strings.Join(reasons, ", "))
+}
+
+// isKnownSender reports whether a sender is saved as a contact, or someone I have written to directly
+func (filter *ScamFilter) isKnownSender(sender string) bool {
+	user := strings.Split(sender, "@")[0]
+	for _, server := range []string{types.DefaultUserServer, types.HiddenUserServer} {
+		jid := types.NewJID(user, server)
+		contact, err := filter.client.Store.Contacts.GetContact(context.Background(), jid)
+		if err == nil && (contact.FullName != "" || contact.FirstName != "") {
+			return true
+		}
+		var wrote bool
+		err = filter.messageStore.dbFor(jid.String()).QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM messages WHERE chat_jid = ? AND is_from_me = 1 AND COALESCE(origin, '') = '')", jid.String(),
+		).Scan(&wrote)
+		if err == nil && wrote {
+			return true
+		}
+	}
+	return false
+}
+
+// isFirstMessage reports whether a message is the first its sender wrote in the chat
+func (filter *ScamFilter) isFirstMessage(event StreamEvent) bool {
+	var earlier bool
+	err := filter.messageStore.dbFor(event.ChatJID).QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM messages WHERE chat_jid = ? AND sender = ? AND id != ? AND timestamp < ?)",
+		event.ChatJID, event.Sender, event.ID, event.Timestamp,
+	).Scan(&earlier)
+	return err == nil && !earlier
+}
+
+// classify asks the LLM for the verdict on a message; "" for a legitimate one
+func (filter *ScamFilter) classify(event StreamEvent, text string, reasons []string) (verdict, reason string, err error) {
+	var answer struct {
+		Verdict string `json:"verdict"`
+		Reason  string `json:"reason"`
+	}
+	ctx := withLLMPurpose(withLLMAuditScope(context.Background(), event.ChatJID, event.Timestamp.Format("2006-01-02")), "scam_filter")
+	prompt := fmt.Sprintf(scamClassifierPrompt, strings.Join(reasons, ", "), text)
+	if err := callLLMStructured(ctx, prompt, scamClassifierSchema, &answer, filter.logger); err != nil {
+		return "", "", err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer.Verdict)) {
+	case scamVerdictScam:
+		return scamVerdictScam, strings.TrimSpace(answer.Reason), nil
+	case scamVerdictSuspicious:
+		return scamVerdictSuspicious, strings.TrimSpace(answer.Reason), nil
+	case "legitimate":
+		return "", "", nil
+	default:
+		return "", "", fmt.Errorf("unexpected verdict %q", answer.Verdict)
+	}
+}
+
+// ListMessageFlags returns the messages flagged since a time and not released, most recent first
+func (store *MessageStore) ListMessageFlags(since time.Time) ([]MessageFlag, error) {
+	rows, err := store.db.Query(`
+		SELECT message_id, chat_jid, sender, verdict, score, reasons, classifier, flagged_at FROM message_flags
+		WHERE flagged_at >= ? AND released_at IS NULL
+		ORDER BY flagged_at DESC
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flagged messages: %v", err)
+	}
+	defer rows.Close()
+
+	flags := []MessageFlag{}
+	for rows.Next() {
+		var flag MessageFlag
+		var reasons string
+		if err := rows.Scan(&flag.MessageID, &flag.ChatJID, &flag.Sender, &flag.Verdict, &flag.Score, &reasons, &flag.Classifier, &flag.FlaggedAt); err != nil {
+			return nil, err
+		}
+		flag.Reasons = strings.Split(reasons, ", ")
+		flags = append(flags, flag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range flags {
+		var content string
+		err := store.dbFor(flags[i].ChatJID).QueryRow("SELECT COALESCE(content, '') FROM messages WHERE id = ? AND chat_jid = ?",
+			flags[i].MessageID, flags[i].ChatJID).Scan(&content)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		flags[i].Content = store.open(content)
+		flags[i].SenderName = lookupSenderName(store.db, flags[i].Sender)
+	}
+	return flags, nil
+}
+
+// handleSuspiciousAPI lists the messages flagged by the scam filter in the last days (default 7)
+func handleSuspiciousAPI(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		days := defaultSuspiciousDays
+		if value := r.URL.Query().Get("days"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > 365 {
+				http.Error(w, "days must be between 1 and 365", http.StatusBadRequest)
+				return
+			}
+			days = parsed
+		}
+		flags, err := messageStore.ListMessageFlags(time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		allowed := flags[:0]
+		for _, flag := range flags {
+			if apiRequestAllowsChat(r, flag.ChatJID) {
+				allowed = append(allowed, flag)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"days": days, "messages": allowed})
+	}
+}
+
+// handleReleaseSuspiciousAPI marks a flagged message as legitimate, so the summaries include it again; the body
+// is {"id": ..., "chat_jid": ...}
+func handleReleaseSuspiciousAPI(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			ID      string `json:"id"`
+			ChatJID string `json:"chat_jid"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" || req.ChatJID == "" {
+			http.Error(w, "id and chat_jid are required", http.StatusBadRequest)
+			return
+		}
+		if !apiRequestAllowsChat(r, req.ChatJID) {
+			rejectChat(w, r, req.ChatJID)
+			return
+		}
+		result, err := messageStore.db.Exec("UPDATE message_flags SET released_at = ? WHERE message_id = ? AND chat_jid = ? AND released_at IS NULL",
+			time.Now(), req.ID, req.ChatJID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if released, _ := result.RowsAffected(); released == 0 {
+			http.Error(w, "No flagged message with this id in this chat", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Search results per page, by default and at most
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 100
+)
+
+// searchMediaTypes are the media types a search can be limited to; "any" matches every message with media
+var searchMediaTypes = []string{"image", "video", "audio", "document", "sticker", "any"}
+
+// ensureMessageSearchIndex creates the FTS5 index over the message text and the triggers that keep it in sync,
+// filling it from the stored messages the first time. Binaries built without the sqlite_fts5 tag can't create
+// it; search then falls back to scanning the messages.
+func ensureMessageSearchIndex(db *sql.DB) error {
+	if hasMessageSearchIndex(db) {
+		return dropSearchReplaceTrigger(db)
+	}
+	_, err := db.Exec(`
+		CREATE VIRTUAL TABLE messages_fts USING fts5(
+			content,
+			content = 'messages',
+			content_rowid = 'rowid',
+			tokenize = 'unicode61 remove_diacritics 2'
+		);
+
+		CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts (rowid, content) VALUES (new.rowid, new.content);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.rowid, old.content);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, content) VALUES ('delete', old.rowid, old.content);
+			INSERT INTO messages_fts (rowid, content) VALUES (new.rowid, new.content);
+		END;
+
+		INSERT INTO messages_fts (messages_fts) VALUES ('rebuild');
+	`)
+	if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+		fmt.Println("Full-text search disabled: SQLite was built without FTS5 (build with -tags sqlite_fts5)")
+		return nil
+	}
+	return err
+}
+
+// dropSearchReplaceTrigger removes the trigger earlier versions used for INSERT OR REPLACE, and rebuilds the
+// index it damaged: it also fired for messages stored again, whose entry the update trigger then deleted twice.
+// Messages are stored again with upserts, which fire the update trigger alone.
+func dropSearchReplaceTrigger(db *sql.DB) error {
+	var name string
+	err := db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'trigger' AND name = 'messages_fts_replace'").Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		DROP TRIGGER messages_fts_replace;
+		INSERT INTO messages_fts (messages_fts) VALUES ('rebuild');
+	`)
+	return err
+}
+
+// hasMessageSearchIndex reports whether a database has the full-text index
+func hasMessageSearchIndex(db *sql.DB) bool {
+	var name string
+	return db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = 'messages_fts'").Scan(&name) == nil
+}
+
+// SearchQuery filters a message search
+type SearchQuery struct {
+	Query     string // words to search for; may be left empty when MediaType is set
+	ChatJID   string
+	Chats     []string // only these chats, for tokens limited to some chats; nil searches every chat
+	Sender    string   // phone number (or LID) of the sender, as stored in messages.sender
+	MediaType string   // one of searchMediaTypes, or "" for messages with or without media
+	After     time.Time
+	Before    time.Time
+	Limit     int
+	Offset    int
+}
+
+// SearchResult is a message matching a search
+type SearchResult struct {
+	ID         string    `json:"id"`
+	ChatJID    string    `json:"chat_jid"`
+	ChatName   string    `json:"chat_name,omitempty"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name,omitempty"` // contact name of the sender, for messages not sent by this account
+	Content    string    `json:"content"`
+	MediaType  string    `json:"media_type,omitempty"`
+	Snippet    string    `json:"snippet,omitempty"` // the matching part, with the matched words in [brackets]
+	Timestamp  time.Time `json:"timestamp"`
+	IsFromMe   bool      `json:"is_from_me"`
+	Archived   bool      `json:"archived,omitempty"` // found in the archive of old messages (see archive.go)
+}
+
+// searchTerms splits a search into words, dropping the characters FTS5 would read as query syntax
+func searchTerms(query string) []string {
+	var terms []string
+	for _, term := range strings.Fields(query) {
+		term = strings.Trim(strings.ReplaceAll(term, `"`, ""), "*^():")
+		if term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// ftsMatchQuery turns search words into an FTS5 query matching messages with all of them, each as a prefix
+func ftsMatchQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// searchMessagesIn searches one database, newest first, returning up to limit results
+func searchMessagesIn(db *sql.DB, query SearchQuery, terms []string, limit int) ([]SearchResult, error) {
+	var conditions []string
+	var args []interface{}
+	selectSnippet := "''"
+	from := "messages m"
+	if len(terms) > 0 && hasMessageSearchIndex(db) {
+		selectSnippet = "snippet(messages_fts, 0, '[', ']', '…', 12)"
+		from = "messages_fts JOIN messages m ON m.rowid = messages_fts.rowid"
+		conditions = append(conditions, "messages_fts MATCH ?")
+		args = append(args, ftsMatchQuery(terms))
+	} else {
+		for _, term := range terms {
+			conditions = append(conditions, "LOWER(m.content) LIKE LOWER(?)")
+			args = append(args, "%"+term+"%")
+		}
+	}
+
+	// Deleted messages keep their text in the table, but not for search
+	conditions = append(conditions, "m.deleted_at IS NULL")
+	if query.ChatJID != "" {
+		conditions = append(conditions, "m.chat_jid = ?")
+		args = append(args, query.ChatJID)
+	}
+	if query.Chats != nil {
+		if len(query.Chats) == 0 {
+			return nil, nil
+		}
+		conditions = append(conditions, "m.chat_jid IN (?"+strings.Repeat(", ?", len(query.Chats)-1)+")")
+		for _, chat := range query.Chats {
+			args = append(args, chat)
+		}
+	}
+	if query.Sender != "" {
+		conditions = append(conditions, "m.sender = ?")
+		args = append(args, query.Sender)
+	}
+	switch query.MediaType {
+	case "":
+	case "any":
+		conditions = append(conditions, "COALESCE(m.media_type, '') != ''")
+	default:
+		conditions = append(conditions, "m.media_type = ?")
+		args = append(args, query.MediaType)
+	}
+	if !query.After.IsZero() {
+		conditions = append(conditions, "m.timestamp >= ?")
+		args = append(args, query.After)
+	}
+	if !query.Before.IsZero() {
+		conditions = append(conditions, "m.timestamp < ?")
+		args = append(args, query.Before)
+	}
+	args = append(args, limit)
+
+	rows, err := db.Query(`
+		SELECT m.id, m.chat_jid, COALESCE(c.name, ''), m.sender, m.content, COALESCE(m.media_type, ''), `+selectSnippet+`,
+			m.timestamp, m.is_from_me
+		FROM `+from+`
+		LEFT JOIN chats c ON c.jid = m.chat_jid
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY m.timestamp DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		if err := rows.Scan(&result.ID, &result.ChatJID, &result.ChatName, &result.Sender, &result.Content, &result.MediaType, &result.Snippet, &result.Timestamp, &result.IsFromMe); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// searchArchive searches the archived messages, newest first, returning up to limit results. Archive files are
+// read whole, so words match anywhere in the text, without the index's prefix and accent handling.
+func (store *MessageStore) searchArchive(query SearchQuery, terms []string, limit int) ([]SearchResult, error) {
+	chats := query.Chats
+	if query.ChatJID != "" {
+		chats = []string{query.ChatJID}
+	}
+	files, err := findArchiveFiles(store.db, chats, query.After, query.Before)
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	words := make([]string, len(terms))
+	for i, term := range terms {
+		words[i] = strings.ToLower(term)
+	}
+
+	chatNames := make(map[string]string)
+	var results []SearchResult
+	for _, file := range files {
+		messages, err := readArchiveFile(file)
+		if err != nil {
+			return nil, err
+		}
+	messages:
+		for _, message := range messages {
+			if !message.DeletedAt.IsZero() || (query.Sender != "" && message.Sender != query.Sender) ||
+				!searchMediaTypeMatches(query.MediaType, message.MediaType) ||
+				(!query.After.IsZero() && message.Timestamp.Before(query.After)) ||
+				(!query.Before.IsZero() && !message.Timestamp.Before(query.Before)) {
+				continue
+			}
+			content := strings.ToLower(message.Content)
+			for _, word := range words {
+				if !strings.Contains(content, word) {
+					continue messages
+				}
+			}
+
+			name, ok := chatNames[message.ChatJID]
+			if !ok {
+				store.db.QueryRow("SELECT COALESCE(name, '') FROM chats WHERE jid = ?", message.ChatJID).Scan(&name)
+				chatNames[message.ChatJID] = name
+			}
+			results = append(results, SearchResult{
+				ID:        message.ID,
+				ChatJID:   message.ChatJID,
+				ChatName:  name,
+				Sender:    message.Sender,
+				Content:   message.Content,
+				MediaType: message.MediaType,
+				Timestamp: message.Timestamp,
+				IsFromMe:  message.IsFromMe,
+				Archived:  true,
+			})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Timestamp.After(results[j].Timestamp) })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// searchMediaTypeMatches reports whether a message's media type passes a search's media type filter
+func searchMediaTypeMatches(filter, mediaType string) bool {
+	switch filter {
+	case "":
+		return true
+	case "any":
+		return mediaType != ""
+	default:
+		return mediaType == filter
+	}
+}
+
+// SearchMessages searches the message text of both stores, and of the archive when they don't fill the page,
+// newest first. It also reports whether there are more results after this page. Direct messages encrypted with
+// DIRECT_MESSAGES_KEY can't be searched for words, only with the other filters.
+func (store *MessageStore) SearchMessages(query SearchQuery) ([]SearchResult, bool, error) {
+	terms := searchTerms(query.Query)
+	if len(terms) == 0 && query.MediaType == "" {
+		return nil, false, fmt.Errorf("nothing to search for")
+	}
+
+	// Each store's first offset+limit+1 matches hold the page and tell whether another one follows
+	want := query.Offset + query.Limit + 1
+	var results []SearchResult
+	for _, db := range []*sql.DB{store.db, store.direct} {
+		if db == nil || (query.ChatJID != "" && db != store.dbFor(query.ChatJID)) {
+			continue
+		}
+		found, err := searchMessagesIn(db, query, terms, want)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to search messages: %v", err)
+		}
+		results = append(results, found...)
+	}
+	// Messages moved to the archive fill what the databases can't
+	if len(results) < want {
+		archived, err := store.searchArchive(query, terms, want)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to search the archive: %v", err)
+		}
+		results = append(results, archived...)
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Timestamp.After(results[j].Timestamp) })
+
+	if query.Offset >= len(results) {
+		return []SearchResult{}, false, nil
+	}
+	results = results[query.Offset:]
+	more := len(results) > query.Limit
+	if more {
+		results = results[:query.Limit]
+	}
+	names := make(map[string]string)
+	for i := range results {
+		results[i].Content = store.open(results[i].Content)
+		if results[i].IsFromMe || results[i].Sender == "" {
+			continue
+		}
+		if _, ok := names[results[i].Sender]; !ok {
+			names[results[i].Sender] = lookupSenderName(store.db, results[i].Sender)
+		}
+		results[i].SenderName = names[results[i].Sender]
+	}
+	return results, more, nil
+}
+
+// parseSearchTime parses a search date: RFC 3339, or YYYY-MM-DD or YYYY-MM-DDTHH:MM:SS in local time
+func parseSearchTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04:05", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// SearchResponse is the /api/search response
+type SearchResponse struct {
+	Results    []SearchResult `json:"results"`
+	NextOffset int            `json:"next_offset,omitempty"` // offset of the next page, when there is one
+}
+
+// handleSearchAPI searches the message history
+// (GET /api/search?q=...&chat_jid=...&sender=...&media_type=image&after=2024-01-01&before=2024-02-01&limit=20&offset=0).
+// q may be left out when media_type is given. Tokens limited to some chats only search those chats.
+func handleSearchAPI(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := r.URL.Query()
+		query := SearchQuery{
+			Query:     params.Get("q"),
+			ChatJID:   params.Get("chat_jid"),
+			Sender:    params.Get("sender"),
+			MediaType: params.Get("media_type"),
+			Limit:     searchDefaultLimit,
+		}
+		if query.MediaType != "" && !slices.Contains(searchMediaTypes, query.MediaType) {
+			http.Error(w, "media_type must be one of "+strings.Join(searchMediaTypes, ", "), http.StatusBadRequest)
+			return
+		}
+		if len(searchTerms(query.Query)) == 0 && query.MediaType == "" {
+			http.Error(w, "q or media_type is required", http.StatusBadRequest)
+			return
+		}
+		if query.ChatJID != "" && !apiRequestAllowsChat(r, query.ChatJID) {
+			rejectChat(w, r, query.ChatJID)
+			return
+		}
+		if token := apiTokenFromRequest(r); token != nil && !slices.Contains(token.Chats, "*") {
+			query.Chats = token.Chats
+		}
+		// Senders are stored by number, without the server
+		query.Sender = strings.SplitN(query.Sender, "@", 2)[0]
+
+		for name, target := range map[string]*time.Time{"after": &query.After, "before": &query.Before} {
+			if value := params.Get(name); value != "" {
+				t, err := parseSearchTime(value)
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Invalid %s: use YYYY-MM-DD or an ISO 8601 time", name), http.StatusBadRequest)
+					return
+				}
+				*target = t
+			}
+		}
+		if value := params.Get("limit"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > searchMaxLimit {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", searchMaxLimit), http.StatusBadRequest)
+				return
+			}
+			query.Limit = limit
+		}
+		if value := params.Get("offset"); value != "" {
+			offset, err := strconv.Atoi(value)
+			if err != nil || offset < 0 {
+				http.Error(w, "Invalid offset", http.StatusBadRequest)
+				return
+			}
+			query.Offset = offset
+		}
+
+		results, more, err := messageStore.SearchMessages(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response := SearchResponse{Results: results}
+		if more {
+			response.NextOffset = query.Offset + len(results)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		printSegmentsUsage()
+		os.Exit(1)
+	}
+
+	// Open SQLite database for messages
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open message database: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	if err := ensureBroadcastTables(db); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create segment tables: %v\n", err)
+		os.Exit(1)
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+	switch command {
+	case "list":
+		err = runSegmentsList(db)
+	case "create":
+		err = runSegmentsCreate(db, args)
+	case "delete":
+		err = runSegmentsDelete(db, args)
+	case "members":
+		err = runSegmentsMembers(db, args)
+	case "tag":
+		err = runSegmentsTag(db, args, true)
+	case "untag":
+		err = runSegmentsTag(db, args, false)
+	case "broadcasts":
+		err = runBroadcastsList(db)
+	case "broadcast-members":
+		err = runBroadcastMembers(db, args)
+	case "help", "--help", "-h":
+		printSegmentsUsage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
+		printSegmentsUsage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printSegmentsUsage() {
+	fmt.Println(`Contact segments
+
+USAGE:
+    segments list
+    segments create --name NAME [--tag TAG] [--member-of CHAT_JID] [--active-days N] [--inactive-days N] [--description TEXT]
+    segments delete --name NAME
+    segments members --name NAME
+    segments tag --jid JID_OR_PHONE --tag TAG
+    segments untag --jid JID_OR_PHONE --tag TAG
+    segments broadcasts
+    segments broadcast-members --list LIST [--name NAME] [--set JIDS | --add JIDS | --remove JIDS]
+
+Segments can be used as recipients with "segment:NAME", e.g. DAILY_SUMMARY_SEND_TO=segment:partners
+or campaign --segment partners.
+
+Broadcast lists are picked up when you send through them; WhatsApp doesn't share their members with
+linked devices, so set them with broadcast-members (comma-separated JIDs or phone numbers). LIST is the
+list JID, name or tag. Members are tagged "broadcast:<name>", and lists can be used as recipients with
+"broadcast:NAME" or campaign --broadcast NAME.`)
+}
+
+func runSegmentsList(db *sql.DB) error {
+	segments, err := listSegments(db)
+	if err != nil {
+		return err
+	}
+
+	if len(segments) == 0 {
+		fmt.Println("No segments defined")
+		return nil
+	}
+
+	for _, segment := range segments {
+		members, err := resolveSegment(db, segment.Name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-20s %4d members  tag=%q member_of=%q active_days=%d inactive_days=%d  %s\n",
+			segment.Name, len(members), segment.Tag, segment.MemberOf, segment.ActiveWithinDays, segment.InactiveForDays, segment.Description)
+	}
+	return nil
+}
+
+func runSegmentsCreate(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	name := fs.String("name", "", "Segment name (required)")
+	description := fs.String("description", "", "Free-form description")
+	tag := fs.String("tag", "", "Only contacts with this tag")
+	memberOf := fs.String("member-of", "", "Only contacts who wrote in this chat JID")
+	activeDays := fs.Int("active-days", 0, "Only contacts with an interaction in the last N days")
+	inactiveDays := fs.Int("inactive-days", 0, "Only contacts without an interaction in the last N days")
+	fs.Parse(args)
+
+	err := saveSegment(db, ContactSegment{
+		Name:             *name,
+		Description:      *description,
+		Tag:              *tag,
+		MemberOf:         *memberOf,
+		ActiveWithinDays: *activeDays,
+		InactiveForDays:  *inactiveDays,
+	})
+	if err != nil {
+		return err
+	}
+
+	members, err := resolveSegment(db, *name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Segment %s saved (%d members)\n", *name, len(members))
+	return nil
+}
+
+func runSegmentsDelete(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	name := fs.String("name", "", "Segment name (required)")
+	fs.Parse(args)
+
+	if *name == "" {
+		return fmt.Errorf("--name is required")
+	}
+	if err := deleteSegment(db, *name); err != nil {
+		return err
+	}
+	fmt.Printf("Segment %s deleted\n", *name)
+	return nil
+}
+
+func runSegmentsMembers(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("members", flag.ExitOnError)
+	name := fs.String("name", "", "Segment name (required)")
+	fs.Parse(args)
+
+	members, err := resolveSegment(db, *name)
+	if err != nil {
+		return err
+	}
+	for _, jid := range members {
+		fmt.Println(jid)
+	}
+	return nil
+}
+
+func runSegmentsTag(db *sql.DB, args []string, add bool) error {
+	fs := flag.NewFlagSet("tag", flag.ExitOnError)
+	jid := fs.String("jid", "", "Contact JID or phone number (required)")
+	tag := fs.String("tag", "", "Tag (required)")
+	fs.Parse(args)
+
+	if *jid == "" || *tag == "" {
+		return fmt.Errorf("--jid and --tag are required")
+	}
+
+	if add {
+		if err := tagContact(db, *jid, *tag); err != nil {
+			return err
+		}
+		fmt.Printf("Tagged %s with %s\n", normalizeContactJID(*jid), *tag)
+		return nil
+	}
+
+	if err := untagContact(db, *jid, *tag); err != nil {
+		return err
+	}
+	fmt.Printf("Removed tag %s from %s\n", *tag, normalizeContactJID(*jid))
+	return nil
+}
+
+func runBroadcastsList(db *sql.DB) error {
+	lists, err := listBroadcastLists(db)
+	if err != nil {
+		return err
+	}
+
+	if len(lists) == 0 {
+		fmt.Println("No broadcast lists seen yet")
+		return nil
+	}
+
+	for _, list := range lists {
+		name := list.Name
+		if name == "" {
+			name = "(unnamed)"
+		}
+		fmt.Printf("%-30s %-20s %4d members  tag=%q\n", list.JID, name, len(list.Members), list.Tag)
+	}
+	return nil
+}
+
+func runBroadcastMembers(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("broadcast-members", flag.ExitOnError)
+	listFlag := fs.String("list", "", "Broadcast list JID, name or tag (required)")
+	name := fs.String("name", "", "Name the list (also renames its contact tag)")
+	set := fs.String("set", "", "Replace the members with these comma-separated JIDs or phone numbers")
+	add := fs.String("add", "", "Add these comma-separated JIDs or phone numbers")
+	remove := fs.String("remove", "", "Remove these comma-separated JIDs or phone numbers")
+	fs.Parse(args)
+
+	if *listFlag == "" {
+		return fmt.Errorf("--list is required")
+	}
+
+	list, err := findBroadcastList(db, *listFlag)
+	if err != nil {
+		// Lists I haven't sent through yet can be added by JID
+		if !strings.HasSuffix(*listFlag, "@broadcast") {
+			return err
+		}
+		list = &BroadcastList{JID: *listFlag}
+	}
+
+	if *name != "" || list.Name == "" {
+		if err := saveBroadcastList(db, list.JID, *name); err != nil {
+			return err
+		}
+	}
+	if *set != "" {
+		if err := setBroadcastListMembers(db, list.JID, strings.Split(*set, ",")); err != nil {
+			return err
+		}
+	}
+	if *add != "" {
+		if err := updateBroadcastListMembers(db, list.JID, strings.Split(*add, ","), true); err != nil {
+			return err
+		}
+	}
+	if *remove != "" {
+		if err := updateBroadcastListMembers(db, list.JID, strings.Split(*remove, ","), false); err != nil {
+			return err
+		}
+	}
+
+	list, err = findBroadcastList(db, list.JID)
+	if err != nil {
+		return err
+	}
+	for _, member := range list.Members {
+		fmt.Println(member)
+	}
+	fmt.Printf("Broadcast list %s (%s): %d members, tag %s\n", list.JID, list.Name, len(list.Members), list.Tag)
+	return nil
+}
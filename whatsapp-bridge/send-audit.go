@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+// Every message sent on my behalf gets a row in send_audit: sends through /api/send and the MCP tools, the
+// summaries of the scheduled tools, self-chat replies, polls, reactions and stickers. `audit --sends` lists them.
+
+// Results of an audited send
+const (
+	sendAuditQueued    = "queued" // waiting in the outbox
+	sendAuditSent      = "sent"
+	sendAuditFailed    = "failed"
+	sendAuditCancelled = "cancelled" // a scheduled message that was cancelled
+	sendAuditRejected  = "rejected"  // refused by the send policy, never sent
+)
+
+// Kinds of audited sends
+const (
+	sendKindMessage  = "message"
+	sendKindPoll     = "poll"
+	sendKindReaction = "reaction"
+	sendKindSticker  = "sticker"
+)
+
+// SendAuditRecord is a row of send_audit
+type SendAuditRecord struct {
+	ID          int64      `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	TriggeredBy string     `json:"triggered_by"`
+	Origin      string     `json:"origin,omitempty"`
+	Kind        string     `json:"kind"`
+	Recipient   string     `json:"recipient"`
+	OutboxID    int64      `json:"outbox_id,omitempty"`
+	MessageID   string     `json:"message_id,omitempty"`
+	PromptHash  string     `json:"prompt_hash,omitempty"`
+	ContentHash string     `json:"content_hash,omitempty"`
+	Result      string     `json:"result"`
+	Error       string     `json:"error,omitempty"`
+	SentAt      *time.Time `json:"sent_at,omitempty"`
+}
+
+// hashSendText returns the hex SHA-256 of a prompt or message, or "" for an empty one
+func hashSendText(text string) string {
+	if text == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// lastPromptHash returns the hash of this process's most recent LLM prompt, which wrote the summary it sends
+func lastPromptHash() string {
+	return hashSendText(lastLLMPrompt)
+}
+
+// recordSendAudit adds a send to the audit log
+func recordSendAudit(db *sql.DB, record SendAuditRecord) error {
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	if record.Kind == "" {
+		record.Kind = sendKindMessage
+	}
+	var outboxID interface{}
+	if record.OutboxID != 0 {
+		outboxID = record.OutboxID
+	}
+	_, err := db.Exec(`
+		INSERT INTO send_audit (created_at, triggered_by, origin, kind, recipient, outbox_id, message_id, prompt_hash, content_hash, result, error, sent_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, record.CreatedAt, record.TriggeredBy, record.Origin, record.Kind, record.Recipient, outboxID, record.MessageID,
+		record.PromptHash, record.ContentHash, record.Result, record.Error, record.SentAt)
+	return err
+}
+
+// recordSendResult records how an immediate send ended: sent with its message ID, or failed with sendErr
+func recordSendResult(db *sql.DB, record SendAuditRecord, messageID string, sendErr error) error {
+	if sendErr != nil {
+		record.Result, record.Error = sendAuditFailed, sendErr.Error()
+	} else {
+		now := time.Now()
+		record.Result, record.MessageID, record.SentAt = sendAuditSent, messageID, &now
+	}
+	return recordSendAudit(db, record)
+}
+
+// updateOutboxSendAudit records the outcome of a message queued in the outbox
+func updateOutboxSendAudit(db *sql.DB, outboxID int64, result, messageID, errorText string) error {
+	var sentAt interface{}
+	if result == sendAuditSent {
+		sentAt = time.Now()
+	}
+	_, err := db.Exec("UPDATE send_audit SET result = ?, message_id = ?, error = ?, sent_at = ? WHERE outbox_id = ?",
+		result, messageID, errorText, sentAt, outboxID)
+	return err
+}
+
+// readSendAudit returns the sends audited between two times, oldest first, optionally only those to a recipient
+func readSendAudit(db *sql.DB, start, end time.Time, recipient string) ([]SendAuditRecord, error) {
+	rows, err := db.Query(`
+		SELECT id, created_at, triggered_by, origin, kind, recipient, COALESCE(outbox_id, 0), message_id, prompt_hash,
+			content_hash, result, error, sent_at
+		FROM send_audit
+		WHERE created_at >= ? AND created_at < ? AND (? = '' OR recipient = ?)
+		ORDER BY id
+	`, start, end, recipient, recipient)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []SendAuditRecord
+	for rows.Next() {
+		var record SendAuditRecord
+		var sentAt sql.NullTime
+		if err := rows.Scan(&record.ID, &record.CreatedAt, &record.TriggeredBy, &record.Origin, &record.Kind, &record.Recipient,
+			&record.OutboxID, &record.MessageID, &record.PromptHash, &record.ContentHash, &record.Result, &record.Error, &sentAt); err != nil {
+			return nil, err
+		}
+		if sentAt.Valid {
+			record.SentAt = &sentAt.Time
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Every message the bridge sends is paced, so summaries, reminders and replies stacking up don't look like spam
+// to WhatsApp: at most SEND_RATE_PER_MINUTE messages a minute with a random pause of up to SEND_JITTER_SECONDS
+// between them, and at most SEND_DAILY_CAP_PER_CHAT automated messages a day to each chat.
+const (
+	defaultSendRatePerMinute   = 20
+	defaultSendJitterSeconds   = 3
+	defaultSendDailyCapPerChat = 200
+)
+
+// sendPacer spaces out the bridge's sends; senders queue on its mutex
+type sendPacer struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+var outgoingPacer = &sendPacer{}
+
+// getSendEnvInt reads a non-negative number from the environment, or returns fallback
+func getSendEnvInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+// wait blocks until the next message may go out: a minute divided by the rate after the previous one, plus jitter.
+// A rate of 0 turns pacing off.
+func (pacer *sendPacer) wait() {
+	pacer.mu.Lock()
+	defer pacer.mu.Unlock()
+	rate := getSendEnvInt("SEND_RATE_PER_MINUTE", defaultSendRatePerMinute)
+	if rate == 0 {
+		return
+	}
+	interval := time.Minute / time.Duration(rate)
+	if jitter := getSendEnvInt("SEND_JITTER_SECONDS", defaultSendJitterSeconds); jitter > 0 {
+		interval += time.Duration(rand.Int63n(int64(jitter) * int64(time.Second)))
+	}
+	if wait := time.Until(pacer.last.Add(interval)); wait > 0 {
+		time.Sleep(wait)
+	}
+	pacer.last = time.Now()
+}
+
+// checkDailySendCap fails when the bridge already sent a chat its daily cap of automated messages, counted since
+// midnight from the messages recorded in db, the database holding the chat. My own chat has no cap.
+func checkDailySendCap(client *whatsmeow.Client, db *sql.DB, chat types.JID) error {
+	limit := getSendEnvInt("SEND_DAILY_CAP_PER_CHAT", defaultSendDailyCapPerChat)
+	if limit == 0 || (client.Store.ID != nil && chat.User == client.Store.ID.User) {
+		return nil
+	}
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var sent int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM messages
+		WHERE chat_jid = ? AND is_from_me AND COALESCE(origin, '') != '' AND timestamp >= ?
+	`, chat.String(), midnight).Scan(&sent)
+	if err != nil {
+		return fmt.Errorf("failed to count today's messages to %s: %v", chat, err)
+	}
+	if sent >= limit {
+		return fmt.Errorf("daily cap of %d automated messages to %s reached (SEND_DAILY_CAP_PER_CHAT)", limit, chat)
+	}
+	return nil
+}
+
+// sendPaced sends a message once the chat is under its daily cap and the pacing allows it
+func sendPaced(client *whatsmeow.Client, db *sql.DB, chat types.JID, msg *waProto.Message) (whatsmeow.SendResponse, error) {
+	if err := checkDailySendCap(client, db, chat); err != nil {
+		return whatsmeow.SendResponse{}, err
+	}
+	outgoingPacer.wait()
+	return client.SendMessage(context.Background(), chat, msg)
+}
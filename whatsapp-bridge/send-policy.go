@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// The send policy (send_policy in config.json) limits the chats that sends decided by a model may reach: those of
+// the MCP tools and the summaries of the scheduled tools. A hallucinated phone number is rejected instead of
+// messaged. Sends I make myself through the API, and the bridge's alerts to the admin chat, aren't restricted.
+
+// ownChatJID returns the JID of this account's own chat, which "self" stands for, or "" before login
+func ownChatJID(client *whatsmeow.Client) string {
+	if client == nil || client.Store.ID == nil {
+		return ""
+	}
+	return types.NewJID(client.Store.ID.User, types.DefaultUserServer).String()
+}
+
+// sendPolicyKeys resolves the chats of a policy list to JIDs; "self" is kept as is, and as ownJID when known
+func sendPolicyKeys(chats []string, ownJID string) map[string]bool {
+	keys := make(map[string]bool)
+	for _, chat := range chats {
+		chat = strings.TrimSpace(chat)
+		switch {
+		case chat == "":
+		case chat == "self":
+			keys["self"] = true
+			if ownJID != "" {
+				keys[ownJID] = true
+			}
+		default:
+			jid, err := resolveChatArgument(chat)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: chat %q in send_policy: %v\n", chat, err)
+				continue
+			}
+			keys[jid] = true
+		}
+	}
+	return keys
+}
+
+// sendPolicyRecipient reduces a recipient to the form of the policy keys
+func sendPolicyRecipient(recipient, ownJID string) string {
+	recipient = strings.TrimSpace(recipient)
+	switch {
+	case recipient == "self" && ownJID != "":
+		return ownJID
+	case recipient == "self" || strings.Contains(recipient, "@"):
+		return recipient
+	default:
+		return recipientConfigKey(recipient) + "@s.whatsapp.net"
+	}
+}
+
+// checkSendPolicy returns an error when the send policy keeps the assistant from sending to a recipient (a JID,
+// phone number or "self"); ownJID is this account's chat JID, or "" when unknown. The config is parsed once (see
+// loadPolicyConfig); if it couldn't be read at first, every send is rejected.
+func checkSendPolicy(recipient, ownJID string) error {
+	config, err := loadPolicyConfig()
+	if err != nil {
+		return fmt.Errorf("send policy unavailable: %v", err)
+	}
+	policy := config.SendPolicy
+	if len(policy.Allow) == 0 && len(policy.Deny) == 0 {
+		return nil
+	}
+
+	target := sendPolicyRecipient(recipient, ownJID)
+	if sendPolicyKeys(policy.Deny, ownJID)[target] {
+		return fmt.Errorf("%s is denied by the send policy", recipient)
+	}
+	if len(policy.Allow) > 0 && !sendPolicyKeys(policy.Allow, ownJID)[target] {
+		return fmt.Errorf("%s is not allowed by the send policy", recipient)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// To expose the API beyond localhost, the bridge serves it over HTTPS as well, on API_TLS_PORT: with the
+// certificate in API_TLS_CERT and API_TLS_KEY, or one obtained from Let's Encrypt for API_TLS_AUTOCERT_DOMAINS.
+// Plain HTTP stays on 8080 for the tools and the healthcheck inside the container. Behind a reverse proxy,
+// TRUSTED_PROXIES lists the proxies whose X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host are believed.
+
+const (
+	defaultTLSPort = 8443
+	autocertDir    = "store/autocert"
+)
+
+// tlsServer is the HTTPS server of the REST API, if any, shut down with the bridge
+var tlsServer *http.Server
+
+type forwardedHTTPSKey struct{}
+
+// getTLSPort returns the port the API is served on over HTTPS (API_TLS_PORT, default 8443)
+func getTLSPort() int {
+	port, err := strconv.Atoi(os.Getenv("API_TLS_PORT"))
+	if err != nil || port < 1 || port > 65535 {
+		return defaultTLSPort
+	}
+	return port
+}
+
+// splitEnvList returns the non-empty entries of a comma-separated environment variable
+func splitEnvList(name string) []string {
+	var entries []string
+	for _, entry := range strings.Split(os.Getenv(name), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// apiTLSConfig returns the TLS configuration of the API from the environment, or nil when HTTPS isn't set up
+func apiTLSConfig() (*tls.Config, error) {
+	certFile, keyFile := os.Getenv("API_TLS_CERT"), os.Getenv("API_TLS_KEY")
+	domains := splitEnvList("API_TLS_AUTOCERT_DOMAINS")
+	switch {
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("API_TLS_CERT and API_TLS_KEY must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the TLS certificate: %v", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+	case len(domains) > 0:
+		// Certificates are obtained with the TLS-ALPN-01 challenge, so port 443 must reach API_TLS_PORT
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(autocertDir),
+			Email:      os.Getenv("API_TLS_AUTOCERT_EMAIL"),
+		}
+		config := manager.TLSConfig()
+		config.MinVersion = tls.VersionTLS12
+		return config, nil
+	default:
+		return nil, nil
+	}
+}
+
+// startTLSServer serves the API over HTTPS on API_TLS_PORT, when a certificate or autocert domains are configured
+func startTLSServer(handler http.Handler) error {
+	config, err := apiTLSConfig()
+	if err != nil || config == nil {
+		return err
+	}
+	tlsServer = &http.Server{Addr: fmt.Sprintf(":%d", getTLSPort()), Handler: handler, TLSConfig: config}
+	fmt.Printf("Starting REST API server over HTTPS on %s...\n", tlsServer.Addr)
+	go func() {
+		if err := tlsServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("REST API HTTPS server error: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// parseTrustedProxies reads TRUSTED_PROXIES, a comma-separated list of IP addresses and CIDR ranges
+func parseTrustedProxies() ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range splitEnvList("TRUSTED_PROXIES") {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// isTrustedProxy reports whether an address is one of the trusted proxies
+func isTrustedProxy(proxies []*net.IPNet, address string) bool {
+	ip := net.ParseIP(strings.TrimSpace(address))
+	if ip == nil {
+		return false
+	}
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// withTrustedProxyHeaders believes the forwarding headers of requests coming from a trusted proxy: the client
+// address becomes the request's RemoteAddr and the forwarded host its Host. Other requests lose these headers,
+// so nothing further down can be fooled by them.
+func withTrustedProxyHeaders(proxies []*net.IPNet, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil || !isTrustedProxy(proxies, peer) {
+			r.Header.Del("X-Forwarded-For")
+			r.Header.Del("X-Forwarded-Proto")
+			r.Header.Del("X-Forwarded-Host")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The client is the rightmost address not added by a trusted proxy
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			client := strings.TrimSpace(hops[0])
+			for i := len(hops) - 1; i >= 0; i-- {
+				if hop := strings.TrimSpace(hops[i]); !isTrustedProxy(proxies, hop) {
+					client = hop
+					break
+				}
+			}
+			if net.ParseIP(client) != nil {
+				r.RemoteAddr = net.JoinHostPort(client, "0")
+			}
+		}
+		if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+			r.Host = host
+		}
+		if strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+			r = r.WithContext(context.WithValue(r.Context(), forwardedHTTPSKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestIsHTTPS reports whether the client reached the API over HTTPS, directly or through a trusted proxy
+func requestIsHTTPS(r *http.Request) bool {
+	forwarded, _ := r.Context().Value(forwardedHTTPSKey{}).(bool)
+	return r.TLS != nil || forwarded
+}
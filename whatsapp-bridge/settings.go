@@ -0,0 +1,245 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		printSettingsUsage()
+		os.Exit(1)
+	}
+
+	// Open SQLite database for messages
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open message database: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	if err := migrateSchema(db); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to migrate message database: %v\n", err)
+		os.Exit(1)
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+	switch command {
+	case "list":
+		err = runSettingsList(db)
+	case "show":
+		err = runSettingsShow(db, args)
+	case "set":
+		err = runSettingsSet(db, args)
+	case "clear":
+		err = runSettingsClear(db, args)
+	case "help", "--help", "-h":
+		printSettingsUsage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
+		printSettingsUsage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printSettingsUsage() {
+	fmt.Println(`Per-chat settings
+
+USAGE:
+    settings list
+    settings show --chat CHAT
+    settings set --chat CHAT [--summary on|off|default] [--prompt-file FILE] [--language LANGUAGE]
+                 [--retention-days N] [--webhooks URLS] [--mute | --mute=false]
+    settings clear --chat CHAT
+
+CHAT is a chat JID, phone number or group name. Only the settings given to "set" change; an empty
+value (or "default" for --summary, 0 for --retention-days) resets a setting to config.json and the
+environment. "clear" resets them all.
+
+    --summary         on includes the chat in the daily summary, off leaves it out
+    --prompt-file     daily summary prompt template for the chat, replacing prompts/daily-summary.md
+    --language        language the chat's summaries are written in
+    --retention-days  the bridge deletes the chat's messages older than N days
+    --webhooks        comma-separated URLs that also receive the chat's messages
+    --mute            no summaries, digests, webhook deliveries or recaps for the chat`)
+}
+
+func runSettingsList(db *sql.DB) error {
+	list, err := listChatSettings(db)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		fmt.Println("No chat settings")
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "CHAT\tSUMMARY\tPROMPT\tLANGUAGE\tRETENTION\tWEBHOOKS\tMUTED")
+	for _, settings := range list {
+		summary := "default"
+		if settings.SummaryEnabled != nil {
+			summary = map[bool]string{true: "on", false: "off"}[*settings.SummaryEnabled]
+		}
+		prompt := "-"
+		if settings.PromptOverride != "" {
+			prompt = "custom"
+		}
+		retention := "-"
+		if settings.RetentionDays > 0 {
+			retention = fmt.Sprintf("%d days", settings.RetentionDays)
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%d\t%v\n", settings.ChatJID, summary, prompt,
+			orDash(settings.Language), retention, len(settings.WebhookTargets), settings.Muted)
+	}
+	return writer.Flush()
+}
+
+// orDash returns "-" for an empty value
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func runSettingsShow(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	chat := fs.String("chat", "", "Chat JID, phone number or group name (required)")
+	fs.Parse(args)
+
+	chatJID, err := resolveChatArgument(*chat)
+	if err != nil {
+		return err
+	}
+	settings, err := getChatSettings(db, chatJID)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func runSettingsSet(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("set", flag.ExitOnError)
+	chat := fs.String("chat", "", "Chat JID, phone number or group name (required)")
+	summary := fs.String("summary", "", "on, off or default")
+	promptFile := fs.String("prompt-file", "", "Daily summary prompt template file; empty resets it")
+	language := fs.String("language", "", "Language of the chat's summaries; empty resets it")
+	retentionDays := fs.Int("retention-days", 0, "Delete the chat's messages older than N days; 0 keeps them")
+	webhooks := fs.String("webhooks", "", "Comma-separated webhook URLs; empty resets them")
+	mute := fs.Bool("mute", false, "Mute the chat")
+	fs.Parse(args)
+
+	chatJID, err := resolveChatArgument(*chat)
+	if err != nil {
+		return err
+	}
+
+	// Only the flags given change, as in a POST to /api/chat-settings
+	patch := make(map[string]json.RawMessage)
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		var value interface{}
+		switch f.Name {
+		case "summary":
+			switch strings.ToLower(*summary) {
+			case "on", "true":
+				value = true
+			case "off", "false":
+				value = false
+			case "default", "":
+			default:
+				flagErr = fmt.Errorf("--summary must be on, off or default")
+			}
+			patch["summary_enabled"], _ = json.Marshal(value)
+		case "prompt-file":
+			if *promptFile != "" {
+				data, err := os.ReadFile(*promptFile)
+				if err != nil {
+					flagErr = fmt.Errorf("failed to read prompt file: %v", err)
+				}
+				value = string(data)
+			}
+			patch["prompt_override"], _ = json.Marshal(value)
+		case "language":
+			if *language != "" {
+				value = *language
+			}
+			patch["language"], _ = json.Marshal(value)
+		case "retention-days":
+			if *retentionDays != 0 {
+				value = *retentionDays
+			}
+			patch["retention_days"], _ = json.Marshal(value)
+		case "webhooks":
+			var targets []string
+			for _, target := range strings.Split(*webhooks, ",") {
+				if target = strings.TrimSpace(target); target != "" {
+					targets = append(targets, target)
+				}
+			}
+			if len(targets) > 0 {
+				value = targets
+			}
+			patch["webhook_targets"], _ = json.Marshal(value)
+		case "mute":
+			patch["muted"], _ = json.Marshal(*mute)
+		}
+	})
+	if flagErr != nil {
+		return flagErr
+	}
+	if len(patch) == 0 {
+		return fmt.Errorf("nothing to set; see settings help")
+	}
+
+	settings, err := getChatSettings(db, chatJID)
+	if err != nil {
+		return err
+	}
+	if err := applyChatSettingsPatch(&settings, patch); err != nil {
+		return err
+	}
+	if err := validateChatSettings(settings); err != nil {
+		return err
+	}
+	if err := saveChatSettings(db, settings); err != nil {
+		return fmt.Errorf("failed to save chat settings: %v", err)
+	}
+	fmt.Printf("Updated the settings of %s\n", chatJID)
+	return nil
+}
+
+func runSettingsClear(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("clear", flag.ExitOnError)
+	chat := fs.String("chat", "", "Chat JID, phone number or group name (required)")
+	fs.Parse(args)
+
+	chatJID, err := resolveChatArgument(*chat)
+	if err != nil {
+		return err
+	}
+	if err := deleteChatSettings(db, chatJID); err != nil {
+		return err
+	}
+	fmt.Printf("Cleared the settings of %s\n", chatJID)
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// On SIGTERM the bridge stops taking API requests and WhatsApp events, lets the events being handled finish,
+// sends what is due in the outbox, checkpoints the databases and disconnects, all within SHUTDOWN_TIMEOUT_SECONDS.
+// Messages turned away are not acknowledged (the client acks only after the handler succeeds, see SynchronousAck),
+// so WhatsApp delivers them again on the next connection.
+const (
+	defaultShutdownTimeout = 8 * time.Second // docker stop kills after 10 seconds by default
+	shutdownPollInterval   = 250 * time.Millisecond
+)
+
+// eventIntake tracks the WhatsApp events being handled, and turns new ones away once it is closed
+type eventIntake struct {
+	mu       sync.Mutex
+	closed   bool
+	inFlight sync.WaitGroup
+}
+
+// bridgeEvents is the intake of the bridge's event handler
+var bridgeEvents = &eventIntake{}
+
+// restServer is the REST API server, shut down with the bridge
+var restServer *http.Server
+
+// enter reports whether an event may be handled; each accepted event must be followed by leave
+func (intake *eventIntake) enter() bool {
+	intake.mu.Lock()
+	defer intake.mu.Unlock()
+	if intake.closed {
+		return false
+	}
+	intake.inFlight.Add(1)
+	return true
+}
+
+// leave marks an accepted event as handled
+func (intake *eventIntake) leave() {
+	intake.inFlight.Done()
+}
+
+// close turns new events away and waits for those being handled, until ctx is done
+func (intake *eventIntake) close(ctx context.Context) error {
+	intake.mu.Lock()
+	intake.closed = true
+	intake.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		intake.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// getShutdownTimeout returns how long a graceful shutdown may take (SHUTDOWN_TIMEOUT_SECONDS, default 8)
+func getShutdownTimeout() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT_SECONDS"))
+	if err != nil || seconds < 1 {
+		return defaultShutdownTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// Flush sends the pending messages whose time has come, until none is left or ctx is done. Messages waiting for
+// a retry later stay queued for the next start.
+func (o *Outbox) Flush(ctx context.Context) error {
+	for {
+		var due int
+		err := o.store.db.QueryRow("SELECT COUNT(*) FROM outbox WHERE status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)",
+			outboxPending, time.Now()).Scan(&due)
+		if err != nil {
+			return err
+		}
+		if due == 0 || isSafeModeActive() || readOnlyModeEnabled() || !o.client.IsConnected() {
+			return nil
+		}
+		done := make(chan struct{})
+		go func() {
+			o.deliverDue()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case <-time.After(shutdownPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// checkpoint writes the WAL of each database back into it, so nothing is left only in the -wal files
+func (store *MessageStore) checkpoint() error {
+	if _, err := store.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return err
+	}
+	if store.direct != nil {
+		if _, err := store.direct.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shutdownBridge stops the bridge in order within the shutdown timeout; the steps left when it runs out are
+// skipped, except disconnecting and closing the databases
+func shutdownBridge(client *whatsmeow.Client, messageStore *MessageStore, logger waLog.Logger) {
+	timeout := getShutdownTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	started := time.Now()
+
+	for _, server := range []*http.Server{restServer, tlsServer} {
+		if server == nil {
+			continue
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Warnf("REST API server didn't stop cleanly: %v", err)
+		}
+	}
+	if err := bridgeEvents.close(ctx); err != nil {
+		logger.Warnf("Stopped waiting for the events being handled: %v", err)
+	}
+	if messageOutbox != nil {
+		if err := messageOutbox.Flush(ctx); err != nil {
+			logger.Warnf("Stopped sending the outbox, the rest is sent after the restart: %v", err)
+		}
+	}
+
+	client.Disconnect()
+	if err := messageStore.checkpoint(); err != nil {
+		logger.Warnf("Failed to checkpoint the databases: %v", err)
+	}
+	if err := messageStore.Close(); err != nil {
+		logger.Warnf("Failed to close the databases: %v", err)
+	}
+	logger.Infof("Shut down in %v", time.Since(started).Round(time.Millisecond))
+}
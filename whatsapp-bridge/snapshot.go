@@ -0,0 +1,351 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// snapshotFormat identifies the snapshot file layout
+const snapshotFormat = "whatsapp-chat-snapshot/v1"
+
+// ChatSnapshot is a hash-chained export of a chat's messages for a date range
+type ChatSnapshot struct {
+	Format    string             `json:"format"`
+	ChatJID   string             `json:"chat_jid"`
+	ChatName  string             `json:"chat_name"`
+	Start     string             `json:"start"`
+	End       string             `json:"end"`
+	CreatedAt time.Time          `json:"created_at"`
+	Entries   []SnapshotEntry    `json:"entries"`
+	FinalHash string             `json:"final_hash"`
+	Signature *SnapshotSignature `json:"signature,omitempty"`
+}
+
+// SnapshotEntry is one message; Hash covers the entry fields and the previous entry's hash
+type SnapshotEntry struct {
+	Index      int       `json:"index"`
+	ID         string    `json:"id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name"`
+	IsFromMe   bool      `json:"is_from_me"`
+	Content    string    `json:"content"`
+	MediaType  string    `json:"media_type,omitempty"`
+	Filename   string    `json:"filename,omitempty"`
+	FileSHA256 string    `json:"file_sha256,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// SnapshotSignature is an Ed25519 signature over the final hash
+type SnapshotSignature struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+	Value     string `json:"value"`
+}
+
+var (
+	snapshotChat    = flag.String("chat", "", "Chat JID to export (required unless --verify or --gen-key)")
+	snapshotStart   = flag.String("start-date", "", "First day in YYYY-MM-DD format (required)")
+	snapshotEnd     = flag.String("end-date", "", "Last day in YYYY-MM-DD format (defaults to start date)")
+	snapshotTZ      = flag.String("timezone", "America/Sao_Paulo", "Timezone for the date range")
+	snapshotOut     = flag.String("out", "", "Output file (defaults to store/snapshots/<chat>-<start>-<end>.json)")
+	snapshotKey     = flag.String("sign-key", "", "Ed25519 private key (PEM) to sign the snapshot with")
+	snapshotGenKey  = flag.String("gen-key", "", "Generate an Ed25519 key pair at this path (and path.pub) and exit")
+	snapshotVerify  = flag.String("verify", "", "Verify the hash chain and signature of a snapshot file and exit")
+	snapshotPubKey  = flag.String("public-key", "", "Expected signer public key (base64) when verifying")
+	snapshotVerbose = flag.Bool("verbose", false, "Print every entry when verifying")
+)
+
+func main() {
+	flag.Parse()
+
+	var err error
+	switch {
+	case *snapshotGenKey != "":
+		err = generateSnapshotKey(*snapshotGenKey)
+	case *snapshotVerify != "":
+		err = verifySnapshotFile(*snapshotVerify, *snapshotPubKey)
+	default:
+		err = createSnapshot()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// createSnapshot exports the chat's messages for the date range as a hash-chained (and optionally signed) JSON file
+func createSnapshot() error {
+	if *snapshotChat == "" || *snapshotStart == "" {
+		flag.Usage()
+		return fmt.Errorf("--chat and --start-date are required")
+	}
+	if *snapshotEnd == "" {
+		*snapshotEnd = *snapshotStart
+	}
+
+	loc, err := time.LoadLocation(*snapshotTZ)
+	if err != nil {
+		return fmt.Errorf("failed to load timezone %s: %v", *snapshotTZ, err)
+	}
+	start, err := time.ParseInLocation("2006-01-02", *snapshotStart, loc)
+	if err != nil {
+		return fmt.Errorf("invalid start date: %v", err)
+	}
+	end, err := time.ParseInLocation("2006-01-02", *snapshotEnd, loc)
+	if err != nil {
+		return fmt.Errorf("invalid end date: %v", err)
+	}
+	end = end.AddDate(0, 0, 1).Add(-time.Nanosecond)
+
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		return fmt.Errorf("failed to open message database: %v", err)
+	}
+	defer db.Close()
+
+	snapshot := &ChatSnapshot{
+		Format:    snapshotFormat,
+		ChatJID:   *snapshotChat,
+		Start:     *snapshotStart,
+		End:       *snapshotEnd,
+		CreatedAt: time.Now().UTC(),
+	}
+	db.QueryRow("SELECT COALESCE(name, '') FROM chats WHERE jid = ?", *snapshotChat).Scan(&snapshot.ChatName)
+
+	rows, err := db.Query(`
+		SELECT id, sender, COALESCE(content, ''), timestamp, is_from_me, COALESCE(media_type, ''), COALESCE(filename, ''), file_sha256
+		FROM messages
+		WHERE chat_jid = ? AND timestamp >= ? AND timestamp <= ?
+		ORDER BY timestamp ASC, id ASC
+	`, *snapshotChat, start, end)
+	if err != nil {
+		return fmt.Errorf("failed to query messages: %v", err)
+	}
+	defer rows.Close()
+
+	senderNames := make(map[string]string)
+	prevHash := ""
+	for rows.Next() {
+		var entry SnapshotEntry
+		var fileSHA256 []byte
+		if err := rows.Scan(&entry.ID, &entry.Sender, &entry.Content, &entry.Timestamp, &entry.IsFromMe, &entry.MediaType, &entry.Filename, &fileSHA256); err != nil {
+			return fmt.Errorf("failed to scan message: %v", err)
+		}
+
+		if _, ok := senderNames[entry.Sender]; !ok {
+			var name string
+			db.QueryRow("SELECT COALESCE(name, '') FROM chats WHERE jid = ?", entry.Sender+"@s.whatsapp.net").Scan(&name)
+			senderNames[entry.Sender] = name
+		}
+
+		entry.Index = len(snapshot.Entries)
+		entry.Timestamp = entry.Timestamp.UTC()
+		entry.SenderName = senderNames[entry.Sender]
+		if len(fileSHA256) > 0 {
+			entry.FileSHA256 = hex.EncodeToString(fileSHA256)
+		}
+		entry.PrevHash = prevHash
+		entry.Hash, err = hashSnapshotEntry(entry)
+		if err != nil {
+			return err
+		}
+		prevHash = entry.Hash
+
+		snapshot.Entries = append(snapshot.Entries, entry)
+	}
+
+	snapshot.FinalHash, err = hashSnapshotHeader(snapshot, prevHash)
+	if err != nil {
+		return err
+	}
+
+	if *snapshotKey != "" {
+		privateKey, err := loadSnapshotPrivateKey(*snapshotKey)
+		if err != nil {
+			return err
+		}
+		snapshot.Signature = &SnapshotSignature{
+			Algorithm: "ed25519",
+			PublicKey: base64.StdEncoding.EncodeToString(privateKey.Public().(ed25519.PublicKey)),
+			Value:     base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte(snapshot.FinalHash))),
+		}
+	}
+
+	outPath := *snapshotOut
+	if outPath == "" {
+		safeChat := strings.NewReplacer("@", "_", ".", "_").Replace(*snapshotChat)
+		outPath = filepath.Join("store", "snapshots", fmt.Sprintf("%s-%s-%s.json", safeChat, *snapshotStart, *snapshotEnd))
+	}
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %v", err)
+	}
+	if err := os.WriteFile(outPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+
+	fmt.Printf("Snapshot of %s (%s) written to %s\n", snapshot.ChatName, snapshot.ChatJID, outPath)
+	fmt.Printf("  Messages:   %d\n", len(snapshot.Entries))
+	fmt.Printf("  Final hash: %s\n", snapshot.FinalHash)
+	if snapshot.Signature != nil {
+		fmt.Printf("  Signed by:  %s\n", snapshot.Signature.PublicKey)
+	}
+	return nil
+}
+
+// hashSnapshotEntry hashes an entry's canonical JSON (without its own hash), which includes the previous hash
+func hashSnapshotEntry(entry SnapshotEntry) (string, error) {
+	entry.Hash = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal entry: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// hashSnapshotHeader binds the chat, range and creation time to the last entry hash
+func hashSnapshotHeader(snapshot *ChatSnapshot, lastHash string) (string, error) {
+	header := struct {
+		Format    string    `json:"format"`
+		ChatJID   string    `json:"chat_jid"`
+		ChatName  string    `json:"chat_name"`
+		Start     string    `json:"start"`
+		End       string    `json:"end"`
+		CreatedAt time.Time `json:"created_at"`
+		Count     int       `json:"count"`
+		LastHash  string    `json:"last_hash"`
+	}{snapshot.Format, snapshot.ChatJID, snapshot.ChatName, snapshot.Start, snapshot.End, snapshot.CreatedAt, len(snapshot.Entries), lastHash}
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal header: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// verifySnapshotFile recomputes the hash chain and checks the signature of a snapshot
+func verifySnapshotFile(path, expectedPublicKey string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %v", err)
+	}
+
+	var snapshot ChatSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse snapshot: %v", err)
+	}
+	if snapshot.Format != snapshotFormat {
+		return fmt.Errorf("unsupported snapshot format %q", snapshot.Format)
+	}
+
+	prevHash := ""
+	for i, entry := range snapshot.Entries {
+		if entry.Index != i || entry.PrevHash != prevHash {
+			return fmt.Errorf("chain broken at entry %d (message %s): entries were removed or reordered", i, entry.ID)
+		}
+		hash, err := hashSnapshotEntry(entry)
+		if err != nil {
+			return err
+		}
+		if hash != entry.Hash {
+			return fmt.Errorf("entry %d (message %s) was modified: hash mismatch", i, entry.ID)
+		}
+		if *snapshotVerbose {
+			fmt.Printf("  ok %4d %s %s\n", i, entry.Timestamp.Format(time.RFC3339), entry.Hash)
+		}
+		prevHash = hash
+	}
+
+	finalHash, err := hashSnapshotHeader(&snapshot, prevHash)
+	if err != nil {
+		return err
+	}
+	if finalHash != snapshot.FinalHash {
+		return fmt.Errorf("final hash mismatch: header fields or trailing entries were modified")
+	}
+
+	fmt.Printf("Hash chain valid: %d messages, final hash %s\n", len(snapshot.Entries), finalHash)
+
+	if snapshot.Signature == nil {
+		if expectedPublicKey != "" {
+			return fmt.Errorf("snapshot is not signed")
+		}
+		fmt.Println("Snapshot is not signed")
+		return nil
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(snapshot.Signature.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key in signature")
+	}
+	signature, err := base64.StdEncoding.DecodeString(snapshot.Signature.Value)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding")
+	}
+	if !ed25519.Verify(publicKey, []byte(snapshot.FinalHash), signature) {
+		return fmt.Errorf("signature is invalid")
+	}
+	if expectedPublicKey != "" && expectedPublicKey != snapshot.Signature.PublicKey {
+		return fmt.Errorf("signature is valid but was made with a different key (%s)", snapshot.Signature.PublicKey)
+	}
+
+	fmt.Printf("Signature valid (ed25519, public key %s)\n", snapshot.Signature.PublicKey)
+	return nil
+}
+
+// generateSnapshotKey writes a new Ed25519 private key (PEM) to path and its base64 public key to path.pub
+func generateSnapshotKey(path string) error {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	block := &pem.Block{Type: "ED25519 PRIVATE KEY", Bytes: privateKey.Seed()}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return fmt.Errorf("failed to write private key: %v", err)
+	}
+
+	encodedPublicKey := base64.StdEncoding.EncodeToString(publicKey)
+	if err := os.WriteFile(path+".pub", []byte(encodedPublicKey+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write public key: %v", err)
+	}
+
+	fmt.Printf("Private key written to %s\nPublic key (%s.pub): %s\n", path, path, encodedPublicKey)
+	return nil
+}
+
+// loadSnapshotPrivateKey reads a private key written by generateSnapshotKey
+func loadSnapshotPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || len(block.Bytes) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key file %s", path)
+	}
+	return ed25519.NewKeyFromSeed(block.Bytes), nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	summariesDir = "store/summaries"
+
+	// How far back to look for the previous state of play, so a quiet week doesn't reset it
+	stateOfPlayLookbackDays = 14
+)
+
+// StateOfPlay is the compact, machine-maintained state of a group's ongoing threads, carried from one daily summary to the next
+type StateOfPlay struct {
+	Threads        []StateOfPlayThread `json:"threads"`
+	PendingActions []StateOfPlayAction `json:"pending_actions"`
+	Decisions      []string            `json:"decisions,omitempty"`
+}
+
+// StateOfPlayThread is an ongoing discussion that spans days
+type StateOfPlayThread struct {
+	Topic  string   `json:"topic"`
+	Status string   `json:"status"`
+	People []string `json:"people,omitempty"`
+	Since  string   `json:"since,omitempty"` // YYYY-MM-DD the thread started
+}
+
+// StateOfPlayAction is an open task with its owner
+type StateOfPlayAction struct {
+	Action string `json:"action"`
+	Owner  string `json:"owner,omitempty"`
+	Due    string `json:"due,omitempty"`
+}
+
+// SummarySidecar is stored next to each daily summary
+type SummarySidecar struct {
+	GroupJID    string      `json:"group_jid"`
+	Date        string      `json:"date"`
+	GeneratedAt time.Time   `json:"generated_at"`
+	StateOfPlay StateOfPlay `json:"state_of_play"`
+}
+
+var stateOfPlayPattern = regexp.MustCompile(`(?s)<state_of_play>\s*(?:` + "```" + `(?:json)?\s*)?(.*?)\s*(?:` + "```" + `\s*)?</state_of_play>`)
+
+// stateOfPlayEnabled reports whether a group keeps a state of play between summaries (state_of_play in config.json)
+func stateOfPlayEnabled(groupJID string) bool {
+	config, err := loadBridgeConfig()
+	if err != nil {
+		return false
+	}
+	return boolSetting(config.getGroupConfig(groupJID).StateOfPlay, false)
+}
+
+// summaryPath returns the path of a group's summary file for a date, with the given extension
+func summaryPath(groupJID, date, ext string) string {
+	dir := strings.NewReplacer("@", "_", ":", "_", "/", "_").Replace(groupJID)
+	return filepath.Join(summariesDir, dir, date+ext)
+}
+
+// loadPreviousStateOfPlay returns the most recent sidecar before a date, or nil when there is none
+func loadPreviousStateOfPlay(groupJID, date string) (*SummarySidecar, error) {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 1; i <= stateOfPlayLookbackDays; i++ {
+		data, err := os.ReadFile(summaryPath(groupJID, day.AddDate(0, 0, -i).Format("2006-01-02"), ".json"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var sidecar SummarySidecar
+		if err := json.Unmarshal(data, &sidecar); err != nil {
+			return nil, fmt.Errorf("failed to parse summary sidecar: %v", err)
+		}
+		return &sidecar, nil
+	}
+	return nil, nil
+}
+
+// applyStateOfPlayToPrompt gives the model the previous state of play (instead of re-describing ongoing threads)
+// and asks it to return the updated state after the summary. Fills {{STATE_OF_PLAY}} or appends the section.
+func applyStateOfPlayToPrompt(prompt string, previous *SummarySidecar) string {
+	var section strings.Builder
+	if previous != nil {
+		stateJSON, _ := json.Marshal(previous.StateOfPlay)
+		section.WriteString(fmt.Sprintf("**State of play as of %s** (machine-generated; ongoing threads, open actions and decisions):\n", previous.Date))
+		section.WriteString(string(stateJSON))
+		section.WriteString("\n\nAssume the reader already knows this context: only mention earlier threads when something changed today.\n")
+	}
+	section.WriteString("After the summary, output the updated state of play as compact JSON between <state_of_play> and </state_of_play> tags, ")
+	section.WriteString(`with the shape {"threads":[{"topic":"","status":"","people":[],"since":"YYYY-MM-DD"}],"pending_actions":[{"action":"","owner":"","due":""}],"decisions":[]}. `)
+	section.WriteString("Carry over threads and actions that are still open, update the ones that moved today and drop the ones that were resolved.")
+
+	if strings.Contains(prompt, "{{STATE_OF_PLAY}}") {
+		return strings.ReplaceAll(prompt, "{{STATE_OF_PLAY}}", section.String())
+	}
+	return prompt + "\n\n" + section.String()
+}
+
+// extractStateOfPlay splits the model's answer into the summary to send and the updated state of play
+func extractStateOfPlay(ctx context.Context, response string) (string, *StateOfPlay, error) {
+	match := stateOfPlayPattern.FindStringSubmatchIndex(response)
+	if match == nil {
+		return response, nil, fmt.Errorf("no <state_of_play> block in the response")
+	}
+
+	summary := strings.TrimSpace(response[:match[0]] + response[match[1]:])
+	var state StateOfPlay
+	if err := decodeLLMJSON(ctx, response[match[2]:match[3]], &state); err != nil {
+		return summary, nil, fmt.Errorf("invalid state of play JSON: %v", err)
+	}
+	return summary, &state, nil
+}
+
+// saveSummary writes the summary text and, when available, its state of play sidecar
+func saveSummary(groupJID, date, summary string, state *StateOfPlay) error {
+	path := summaryPath(groupJID, date, ".md")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create summaries directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(summary+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write summary: %v", err)
+	}
+	if state == nil {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(SummarySidecar{
+		GroupJID:    groupJID,
+		Date:        date,
+		GeneratedAt: time.Now(),
+		StateOfPlay: *state,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode summary sidecar: %v", err)
+	}
+	if err := os.WriteFile(summaryPath(groupJID, date, ".json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to write summary sidecar: %v", err)
+	}
+	return nil
+}
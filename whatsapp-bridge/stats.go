@@ -0,0 +1,254 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How statistics can be grouped
+const (
+	statsBySender = "sender"
+	statsByChat   = "chat"
+	statsByDay    = "day"
+)
+
+// Statistics rows returned, by default and at most
+const (
+	statsDefaultLimit = 20
+	statsMaxLimit     = 1000
+)
+
+// StatsQuery selects and groups the daily message rollups (see migrations/0004_message_stats.sql)
+type StatsQuery struct {
+	By      string
+	ChatJID string
+	Chats   []string // only these chats, for tokens limited to some chats; nil counts every chat
+	Sender  string
+	After   string // first day, YYYY-MM-DD
+	Before  string // day after the last, YYYY-MM-DD
+	Limit   int
+}
+
+// StatsRow is the activity of one sender, chat or day
+type StatsRow struct {
+	Key                string  `json:"key"` // sender phone number, chat JID or day
+	Name               string  `json:"name,omitempty"`
+	Messages           int     `json:"messages"`
+	Media              int     `json:"media"`
+	Responses          int     `json:"responses"`
+	AvgResponseSeconds float64 `json:"avg_response_seconds,omitempty"` // how long replies took on average
+	responseSeconds    int
+}
+
+// add counts another row with the same key in
+func (row *StatsRow) add(other StatsRow) {
+	row.Messages += other.Messages
+	row.Media += other.Media
+	row.Responses += other.Responses
+	row.responseSeconds += other.responseSeconds
+	if row.Name == "" {
+		row.Name = other.Name
+	}
+}
+
+// setAverage sets the average reply time from the counted responses
+func (row *StatsRow) setAverage() {
+	if row.Responses > 0 {
+		row.AvgResponseSeconds = float64(row.responseSeconds) / float64(row.Responses)
+	}
+}
+
+// StatsResponse is the /api/stats response
+type StatsResponse struct {
+	By     string     `json:"by"`
+	Rows   []StatsRow `json:"rows"`
+	Totals StatsRow   `json:"totals"`
+}
+
+// queryStatsIn reads one database's rollups grouped by the query's key
+func queryStatsIn(db *sql.DB, query StatsQuery) ([]StatsRow, error) {
+	key, name := "s.sender", "''"
+	switch query.By {
+	case statsByChat:
+		key, name = "s.chat_jid", "COALESCE(MAX(c.name), '')"
+	case statsByDay:
+		key = "s.day"
+	}
+
+	conditions := []string{"1 = 1"}
+	var args []interface{}
+	if query.ChatJID != "" {
+		conditions = append(conditions, "s.chat_jid = ?")
+		args = append(args, query.ChatJID)
+	}
+	if query.Chats != nil {
+		if len(query.Chats) == 0 {
+			return nil, nil
+		}
+		conditions = append(conditions, "s.chat_jid IN (?"+strings.Repeat(", ?", len(query.Chats)-1)+")")
+		for _, chat := range query.Chats {
+			args = append(args, chat)
+		}
+	}
+	if query.Sender != "" {
+		conditions = append(conditions, "s.sender = ?")
+		args = append(args, query.Sender)
+	}
+	if query.After != "" {
+		conditions = append(conditions, "s.day >= ?")
+		args = append(args, query.After)
+	}
+	if query.Before != "" {
+		conditions = append(conditions, "s.day < ?")
+		args = append(args, query.Before)
+	}
+
+	rows, err := db.Query(`
+		SELECT `+key+`, `+name+`, SUM(s.messages), SUM(s.media), SUM(s.responses), SUM(s.response_seconds)
+		FROM message_stats_daily s
+		LEFT JOIN chats c ON c.jid = s.chat_jid
+		WHERE `+strings.Join(conditions, " AND ")+`
+		GROUP BY `+key, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []StatsRow
+	for rows.Next() {
+		var row StatsRow
+		if err := rows.Scan(&row.Key, &row.Name, &row.Messages, &row.Media, &row.Responses, &row.responseSeconds); err != nil {
+			return nil, err
+		}
+		stats = append(stats, row)
+	}
+	return stats, rows.Err()
+}
+
+// senderNames looks the senders' names up in the contacts the bridge keeps
+func (store *MessageStore) senderNames(rows []StatsRow) {
+	for i := range rows {
+		var name string
+		err := store.db.QueryRow("SELECT name FROM contacts WHERE jid IN (?, ?) AND COALESCE(name, '') != ''",
+			rows[i].Key+"@s.whatsapp.net", rows[i].Key+"@lid").Scan(&name)
+		if err == nil {
+			rows[i].Name = name
+		}
+	}
+}
+
+// MessageStats adds up the daily rollups of both stores: the most active senders or chats first, or days in
+// order. Totals cover every row, also those beyond the limit.
+func (store *MessageStore) MessageStats(query StatsQuery) (StatsResponse, error) {
+	merged := make(map[string]*StatsRow)
+	for _, db := range []*sql.DB{store.db, store.direct} {
+		if db == nil || (query.ChatJID != "" && db != store.dbFor(query.ChatJID)) {
+			continue
+		}
+		rows, err := queryStatsIn(db, query)
+		if err != nil {
+			return StatsResponse{}, fmt.Errorf("failed to read message statistics: %v", err)
+		}
+		for _, row := range rows {
+			if existing, ok := merged[row.Key]; ok {
+				existing.add(row)
+			} else {
+				row := row
+				merged[row.Key] = &row
+			}
+		}
+	}
+
+	response := StatsResponse{By: query.By, Rows: []StatsRow{}, Totals: StatsRow{Key: "total"}}
+	for _, row := range merged {
+		response.Totals.add(StatsRow{Messages: row.Messages, Media: row.Media, Responses: row.Responses, responseSeconds: row.responseSeconds})
+		response.Rows = append(response.Rows, *row)
+	}
+	if query.By == statsByDay {
+		sort.Slice(response.Rows, func(i, j int) bool { return response.Rows[i].Key < response.Rows[j].Key })
+	} else {
+		sort.Slice(response.Rows, func(i, j int) bool {
+			if response.Rows[i].Messages != response.Rows[j].Messages {
+				return response.Rows[i].Messages > response.Rows[j].Messages
+			}
+			return response.Rows[i].Key < response.Rows[j].Key
+		})
+	}
+	if len(response.Rows) > query.Limit {
+		response.Rows = response.Rows[:query.Limit]
+	}
+	if query.By == statsBySender {
+		store.senderNames(response.Rows)
+	}
+
+	response.Totals.setAverage()
+	for i := range response.Rows {
+		response.Rows[i].setAverage()
+	}
+	return response, nil
+}
+
+// handleStatsAPI reports message activity from the daily rollups
+// (GET /api/stats?by=sender|chat|day&chat_jid=...&sender=...&after=2024-01-01&before=2024-02-01&limit=20).
+// Tokens limited to some chats only count those chats.
+func handleStatsAPI(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		params := r.URL.Query()
+		query := StatsQuery{
+			By:      params.Get("by"),
+			ChatJID: params.Get("chat_jid"),
+			Sender:  strings.SplitN(params.Get("sender"), "@", 2)[0],
+			After:   params.Get("after"),
+			Before:  params.Get("before"),
+			Limit:   statsDefaultLimit,
+		}
+		if query.By == "" {
+			query.By = statsBySender
+		}
+		if query.By != statsBySender && query.By != statsByChat && query.By != statsByDay {
+			http.Error(w, "by must be sender, chat or day", http.StatusBadRequest)
+			return
+		}
+		for name, value := range map[string]string{"after": query.After, "before": query.Before} {
+			if _, err := time.Parse("2006-01-02", value); value != "" && err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s: use YYYY-MM-DD", name), http.StatusBadRequest)
+				return
+			}
+		}
+		if value := params.Get("limit"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > statsMaxLimit {
+				http.Error(w, fmt.Sprintf("limit must be between 1 and %d", statsMaxLimit), http.StatusBadRequest)
+				return
+			}
+			query.Limit = limit
+		}
+		if query.ChatJID != "" && !apiRequestAllowsChat(r, query.ChatJID) {
+			rejectChat(w, r, query.ChatJID)
+			return
+		}
+		if token := apiTokenFromRequest(r); token != nil && !slices.Contains(token.Chats, "*") {
+			query.Chats = token.Chats
+		}
+
+		response, err := messageStore.MessageStats(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
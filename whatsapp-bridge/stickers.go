@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Stickers are 512x512 WebP images
+const stickerSize = 512
+
+// Animated stickers are cut to this many seconds
+const stickerMaxSeconds = 6
+
+// How long ffmpeg may take to convert an image into a sticker
+const stickerConvertTimeout = 60 * time.Second
+
+// webpInfo returns the size of a WebP image and whether it is animated
+func webpInfo(data []byte) (width, height uint32, animated bool, err error) {
+	if len(data) < 30 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, false, fmt.Errorf("not a WebP image")
+	}
+	chunk := data[20:]
+	switch string(data[12:16]) {
+	case "VP8X":
+		// Flags, 3 reserved bytes, then the canvas size minus one in 24 bits each
+		animated = chunk[0]&0x02 != 0
+		width = uint32(chunk[4]) | uint32(chunk[5])<<8 | uint32(chunk[6])<<16 + 1
+		height = uint32(chunk[7]) | uint32(chunk[8])<<8 | uint32(chunk[9])<<16 + 1
+	case "VP8 ":
+		// Frame tag and start code, then the size in 14 bits each
+		width = uint32(binary.LittleEndian.Uint16(chunk[6:8]) & 0x3fff)
+		height = uint32(binary.LittleEndian.Uint16(chunk[8:10]) & 0x3fff)
+	case "VP8L":
+		// Signature byte, then the size minus one in 14 bits each
+		bits := binary.LittleEndian.Uint32(chunk[1:5])
+		width = bits&0x3fff + 1
+		height = (bits>>14)&0x3fff + 1
+	default:
+		return 0, 0, false, fmt.Errorf("unknown WebP format %q", data[12:16])
+	}
+	return width, height, animated, nil
+}
+
+// convertToSticker turns an image (PNG, JPEG, static WebP) into a static sticker, or a GIF or short video into an
+// animated one, with ffmpeg: scaled to fit 512x512 and padded with transparency
+func convertToSticker(path string) ([]byte, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, fmt.Errorf("converting %s into a sticker needs ffmpeg; send a 512x512 WebP instead", filepath.Ext(path))
+	}
+	output, err := os.CreateTemp("", "sticker-*.webp")
+	if err != nil {
+		return nil, err
+	}
+	output.Close()
+	defer os.Remove(output.Name())
+
+	filter := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,format=rgba,pad=%d:%d:(ow-iw)/2:(oh-ih)/2:color=0x00000000",
+		stickerSize, stickerSize, stickerSize, stickerSize)
+	args := []string{"-v", "error", "-y", "-i", path, "-vf", filter, "-an", "-vcodec", "libwebp", "-quality", "75"}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gif", ".mp4", ".webm", ".mov":
+		args = append(args, "-loop", "0", "-t", fmt.Sprint(stickerMaxSeconds), "-fps_mode", "vfr")
+	default:
+		args = append(args, "-frames:v", "1")
+	}
+	args = append(args, output.Name())
+
+	ctx, cancel := context.WithTimeout(context.Background(), stickerConvertTimeout)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return os.ReadFile(output.Name())
+}
+
+// loadSticker returns the sticker for a file: 512x512 WebP files are used as they are, anything else is converted
+func loadSticker(path string) (data []byte, width, height uint32, animated bool, err error) {
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, 0, 0, false, fmt.Errorf("failed to read sticker file: %v", err)
+	}
+	width, height, animated, err = webpInfo(data)
+	if err != nil || width != stickerSize || height != stickerSize {
+		if data, err = convertToSticker(path); err != nil {
+			return nil, 0, 0, false, err
+		}
+		if width, height, animated, err = webpInfo(data); err != nil {
+			return nil, 0, 0, false, fmt.Errorf("converted sticker is invalid: %v", err)
+		}
+	}
+	return data, width, height, animated, nil
+}
+
+// sendWhatsAppSticker sends an image, GIF or WebP as a sticker and returns the message ID
+func sendWhatsAppSticker(client *whatsmeow.Client, messageStore *MessageStore, recipient, path string) (string, error) {
+	if readOnlyModeEnabled() {
+		return "", errReadOnly
+	}
+	if !client.IsConnected() {
+		return "", fmt.Errorf("not connected to WhatsApp")
+	}
+	recipientJID, err := parseRecipientJID(client, recipient)
+	if err != nil {
+		return "", fmt.Errorf("invalid recipient %s: %v", recipient, err)
+	}
+
+	data, width, height, animated, err := loadSticker(path)
+	if err != nil {
+		return "", err
+	}
+	upload, err := client.Upload(context.Background(), data, whatsmeow.MediaImage)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload sticker: %v", err)
+	}
+
+	msg := &waProto.Message{
+		StickerMessage: &waProto.StickerMessage{
+			Mimetype:      proto.String("image/webp"),
+			URL:           &upload.URL,
+			DirectPath:    &upload.DirectPath,
+			MediaKey:      upload.MediaKey,
+			FileEncSHA256: upload.FileEncSHA256,
+			FileSHA256:    upload.FileSHA256,
+			FileLength:    &upload.FileLength,
+			Width:         proto.Uint32(width),
+			Height:        proto.Uint32(height),
+			IsAnimated:    proto.Bool(animated),
+		},
+	}
+	sendResp, err := sendPaced(client, messageStore.dbFor(recipientJID.String()), recipientJID, msg)
+	if err != nil {
+		return "", fmt.Errorf("error sending sticker: %v", err)
+	}
+	if err := messageStore.StoreSentMessage(client, sendResp.ID, recipientJID, msg, messageOriginBridgeAPI); err != nil {
+		fmt.Printf("Failed to store sent sticker: %v\n", err)
+	}
+	return sendResp.ID, nil
+}
+
+// SendStickerRequest is the request body for the send sticker API
+type SendStickerRequest struct {
+	Recipient string `json:"recipient"`
+	MediaPath string `json:"media_path"` // WebP, PNG, JPEG or GIF; anything but a 512x512 WebP needs ffmpeg
+}
+
+// handleSendStickerAPI sends a sticker (POST /api/stickers/send)
+func handleSendStickerAPI(client *whatsmeow.Client, messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req SendStickerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if req.Recipient == "" || req.MediaPath == "" {
+			http.Error(w, "recipient and media_path are required", http.StatusBadRequest)
+			return
+		}
+		if !strings.Contains(req.Recipient, "@") && req.Recipient != "self" {
+			req.Recipient = normalizePhoneRecipient(req.Recipient)
+		}
+		if !apiRequestAllowsChat(r, req.Recipient) {
+			rejectChat(w, r, req.Recipient)
+			return
+		}
+		// Scoped tokens can't send local files
+		if token := apiTokenFromRequest(r); token != nil && !token.HasCapability(apiCapabilityAdmin) {
+			http.Error(w, "Sending stickers requires the admin capability", http.StatusForbidden)
+			return
+		}
+		if rejectedBySendPolicy(w, r, client, messageStore, sendKindSticker, "", req.Recipient, "") {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if isSafeModeActive() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(SendMessageResponse{
+				Success: false,
+				Message: "Safe mode is on, outbound messages are disabled",
+			})
+			return
+		}
+
+		messageID, err := sendWhatsAppSticker(client, messageStore, req.Recipient, req.MediaPath)
+		auditAPISend(messageStore, r, sendKindSticker, req.Recipient, "", messageID, err)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(SendMessageResponse{Success: false, Message: err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(SendMessageResponse{
+			Success:   true,
+			Message:   fmt.Sprintf("Sticker sent to %s", req.Recipient),
+			MessageID: messageID,
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// JSONSchema is the subset of JSON Schema the bridge uses to describe and validate structured LLM output
+type JSONSchema struct {
+	Type                 string                 `json:"type"`
+	Description          string                 `json:"description,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	MinProperties        int                    `json:"minProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	MinItems             int                    `json:"minItems,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinLength            int                    `json:"minLength,omitempty"`
+}
+
+// structuredOutputEnabled reports whether JSON answers are requested with an explicit schema,
+// validated and retried once on failure (LLM_STRUCTURED_OUTPUT, default true)
+func structuredOutputEnabled() bool {
+	return os.Getenv("LLM_STRUCTURED_OUTPUT") != "false"
+}
+
+// topicSegmentationSchema describes the topic -> {messages, summary} map, with indices into the day's messages
+func topicSegmentationSchema(messageCount int) *JSONSchema {
+	minIndex, maxIndex := 0.0, float64(messageCount-1)
+	return &JSONSchema{
+		Type:          "object",
+		Description:   "Topics of the conversation, keyed by a short snake_case topic name",
+		MinProperties: 1,
+		AdditionalProperties: &JSONSchema{
+			Type:     "object",
+			Required: []string{"messages", "summary"},
+			Properties: map[string]*JSONSchema{
+				"messages": {
+					Type:        "array",
+					Description: "Indices of the topic's messages in the input array",
+					MinItems:    1,
+					Items:       &JSONSchema{Type: "integer", Minimum: &minIndex, Maximum: &maxIndex},
+				},
+				"summary": {Type: "string", Description: "Brief topic description", MinLength: 1},
+			},
+		},
+	}
+}
+
+// validateJSONSchema checks a decoded JSON value (decoded with UseNumber) against a schema and returns every violation
+func validateJSONSchema(value interface{}, schema *JSONSchema, path string) []string {
+	if schema == nil {
+		return nil
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an object", path)}
+		}
+		var problems []string
+		if len(object) < schema.MinProperties {
+			problems = append(problems, fmt.Sprintf("%s: expected at least %d entries", path, schema.MinProperties))
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing required field %q", path, name))
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := schema.Properties[name]
+			if !ok {
+				property = schema.AdditionalProperties
+			}
+			problems = append(problems, validateJSONSchema(object[name], property, path+"."+name)...)
+		}
+		return problems
+
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected an array", path)}
+		}
+		var problems []string
+		if len(array) < schema.MinItems {
+			problems = append(problems, fmt.Sprintf("%s: expected at least %d items", path, schema.MinItems))
+		}
+		for i, item := range array {
+			problems = append(problems, validateJSONSchema(item, schema.Items, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return problems
+
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected a string", path)}
+		}
+		if len(strings.TrimSpace(text)) < schema.MinLength {
+			return []string{fmt.Sprintf("%s: expected at least %d characters", path, schema.MinLength)}
+		}
+		return nil
+
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected a number", path)}
+		}
+		if schema.Type == "integer" {
+			if _, err := number.Int64(); err != nil {
+				return []string{fmt.Sprintf("%s: expected an integer, got %s", path, number)}
+			}
+		}
+		n, _ := number.Float64()
+		if schema.Minimum != nil && n < *schema.Minimum {
+			return []string{fmt.Sprintf("%s: %s is below the minimum %v", path, number, *schema.Minimum)}
+		}
+		if schema.Maximum != nil && n > *schema.Maximum {
+			return []string{fmt.Sprintf("%s: %s is above the maximum %v", path, number, *schema.Maximum)}
+		}
+		return nil
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s: expected a boolean", path)}
+		}
+		return nil
+	}
+	return nil
+}
+
+// extractStructuredJSON returns the JSON value in a response: from a markdown code block if there is one,
+// otherwise from the first opening brace or bracket to the last closing one
+func extractStructuredJSON(response string) string {
+	content := strings.TrimSpace(extractJSONFromMarkdown(response))
+	start := strings.IndexAny(content, "{[")
+	end := strings.LastIndexAny(content, "}]")
+	if start == -1 || end < start {
+		return content
+	}
+	return content[start : end+1]
+}
+
+// parseStructuredResponse extracts, repairs if needed, decodes and validates a response, returning what is wrong with it.
+// The parse outcome is recorded to monitor how reliably the model returns JSON.
+func parseStructuredResponse(ctx context.Context, response string, schema *JSONSchema) (string, error) {
+	jsonContent, fixes, err := repairLLMJSON(extractStructuredJSON(response))
+	if err != nil {
+		// A truncated answer has no closing brace, so retry from the opening one to the end
+		jsonContent, fixes, err = repairLLMJSON(extractTruncatedJSON(response))
+	}
+	if err != nil {
+		recordLLMJSONParse(ctx, jsonParseFailed, nil)
+		return "", fmt.Errorf("response is not valid JSON: %v", err)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(jsonContent))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		recordLLMJSONParse(ctx, jsonParseFailed, fixes)
+		return "", fmt.Errorf("response is not valid JSON: %v", err)
+	}
+
+	if problems := validateJSONSchema(value, schema, "$"); len(problems) > 0 {
+		recordLLMJSONParse(ctx, jsonParseSchemaMismatch, fixes)
+		if len(problems) > 10 {
+			problems = append(problems[:10], fmt.Sprintf("and %d more problems", len(problems)-10))
+		}
+		return "", fmt.Errorf("response does not match the schema: %s", strings.Join(problems, "; "))
+	}
+	recordLLMJSONParse(ctx, jsonParseOutcome(fixes), fixes)
+	return jsonContent, nil
+}
+
+// extractTruncatedJSON returns a response from its first opening brace or bracket to the end
+func extractTruncatedJSON(response string) string {
+	content := strings.TrimSpace(extractJSONFromMarkdown(response))
+	if start := strings.IndexAny(content, "{["); start != -1 {
+		return content[start:]
+	}
+	return content
+}
+
+// decodeLLMJSON decodes JSON written by the LLM into out, repairing it first when needed,
+// and records the parse outcome
+func decodeLLMJSON(ctx context.Context, content string, out interface{}) error {
+	jsonContent, fixes, err := repairLLMJSON(strings.TrimSpace(content))
+	if err == nil {
+		err = json.Unmarshal([]byte(jsonContent), out)
+	}
+	if err != nil {
+		recordLLMJSONParse(ctx, jsonParseFailed, fixes)
+		return err
+	}
+	recordLLMJSONParse(ctx, jsonParseOutcome(fixes), fixes)
+	if len(fixes) > 0 {
+		fmt.Fprintf(os.Stderr, "Repaired LLM JSON (%s)\n", strings.Join(fixes, ", "))
+	}
+	return nil
+}
+
+// structuredOutputInstructions tells the model the exact shape of the answer
+func structuredOutputInstructions(schema *JSONSchema) (string, error) {
+	var schemaJSON bytes.Buffer
+	encoder := json.NewEncoder(&schemaJSON)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(schema); err != nil {
+		return "", fmt.Errorf("failed to encode JSON schema: %v", err)
+	}
+	return "\n\n**Output format:** respond with a single JSON value that validates against this JSON Schema, " +
+		"and nothing else (no explanations):\n```json\n" + strings.TrimSpace(schemaJSON.String()) + "\n```\n", nil
+}
+
+// callLLMStructured asks the LLM for JSON matching schema and decodes it into out.
+// An invalid answer is retried once with the validation errors, so the model can correct itself.
+func callLLMStructured(ctx context.Context, prompt string, schema *JSONSchema, out interface{}, logger waLog.Logger) error {
+	instructions, err := structuredOutputInstructions(schema)
+	if err != nil {
+		return err
+	}
+	prompt += instructions
+
+	response, err := callLLM(ctx, prompt)
+	if err != nil {
+		return err
+	}
+
+	jsonContent, validationErr := parseStructuredResponse(ctx, response, schema)
+	if validationErr != nil {
+		logger.Warnf("Invalid structured response, retrying once: %v", validationErr)
+
+		correction := fmt.Sprintf("Your previous response was rejected: %v\n\nPrevious response:\n%s\n\n"+
+			"Respond again with only the corrected JSON, matching the schema exactly.", validationErr, response)
+		// A session already has the prompt and the rejected answer
+		if llmSessionFromContext(ctx) == nil {
+			correction = prompt + "\n\n---\n\n" + correction
+		}
+
+		response, err = callLLM(ctx, correction)
+		if err != nil {
+			return err
+		}
+		if jsonContent, validationErr = parseStructuredResponse(ctx, response, schema); validationErr != nil {
+			logger.Warnf("Response content: %s", response)
+			return fmt.Errorf("invalid structured response after retry: %v", validationErr)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(jsonContent), out); err != nil {
+		return fmt.Errorf("failed to decode structured response: %v", err)
+	}
+	return nil
+}
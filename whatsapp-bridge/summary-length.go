@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Tightening passes tried before an over-long summary is sent as it is
+const summaryTightenAttempts = 2
+
+const summaryTightenPrompt = `The WhatsApp summary below is %d characters long; rewrite it in at most %d characters.
+
+Keep every action item with its owner and deadline, every decision and every number, amount and date. Cut repetition, background and lower-priority detail first, and merge related points. Keep the language, the structure and the WhatsApp formatting (*bold*, _italic_, lists).
+
+Return only the rewritten summary.
+
+<summary>
+%s
+</summary>`
+
+// tightenSummary shortens a summary longer than maxChars with LLM passes that keep the action items.
+// When no pass gets under the limit the shortest version is returned, since cutting the text could drop action items.
+func tightenSummary(ctx context.Context, summary string, maxChars int, logger waLog.Logger) string {
+	if maxChars <= 0 || utf8.RuneCountInString(summary) <= maxChars {
+		return summary
+	}
+
+	shortest := summary
+	for attempt := 1; attempt <= summaryTightenAttempts; attempt++ {
+		length := utf8.RuneCountInString(shortest)
+		response, err := callLLM(withLLMPurpose(ctx, "summary_tighten"), fmt.Sprintf(summaryTightenPrompt, length, maxChars, shortest))
+		if err != nil {
+			logger.Warnf("Failed to tighten summary, sending it at %d characters: %v", length, err)
+			return shortest
+		}
+		response = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(response), "<summary>"), "</summary>"))
+		if response == "" {
+			continue
+		}
+
+		tightened := utf8.RuneCountInString(response)
+		logger.Infof("Tightened summary from %d to %d characters (limit %d)", length, tightened, maxChars)
+		if tightened < length {
+			shortest = response
+		}
+		if tightened <= maxChars {
+			return shortest
+		}
+	}
+
+	logger.Warnf("Summary still has %d characters after tightening (limit %d), sending the shortest version", utf8.RuneCountInString(shortest), maxChars)
+	return shortest
+}
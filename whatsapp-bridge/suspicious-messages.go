@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// The weekly digest lists the messages the bridge's scam filter flagged during the week (see scam-filter.go); the
+// daily summary already left the scams out (see getQuarantinedMessages).
+
+// Senders listed in the weekly digest's suspicious messages section
+const maxSuspiciousSenders = 10
+
+// SuspiciousSender sums up the flagged messages of a sender in a chat
+type SuspiciousSender struct {
+	Sender     string
+	ChatJID    string
+	Scams      int
+	Suspicious int
+	Reasons    []string
+}
+
+// getSuspiciousSenders returns the senders of the messages flagged in a period and not released, the most
+// flagged first
+func getSuspiciousSenders(db *sql.DB, after, before time.Time) ([]SuspiciousSender, error) {
+	rows, err := db.Query(`
+		SELECT sender, chat_jid, verdict, reasons, flagged_at FROM message_flags
+		WHERE flagged_at >= ? AND flagged_at < ? AND released_at IS NULL
+		ORDER BY flagged_at
+	`, after, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bySender := make(map[string]*SuspiciousSender)
+	var senders []*SuspiciousSender
+	for rows.Next() {
+		var sender, chatJID, verdict, reasons string
+		var flaggedAt time.Time
+		if err := rows.Scan(&sender, &chatJID, &verdict, &reasons, &flaggedAt); err != nil {
+			return nil, err
+		}
+		key := sender + " " + chatJID
+		summary, ok := bySender[key]
+		if !ok {
+			summary = &SuspiciousSender{Sender: sender, ChatJID: chatJID}
+			bySender[key] = summary
+			senders = append(senders, summary)
+		}
+		if verdict == scamVerdictScam {
+			summary.Scams++
+		} else {
+			summary.Suspicious++
+		}
+		for _, reason := range strings.Split(reasons, ",") {
+			if reason = strings.TrimSpace(reason); reason != "" && !slices.Contains(summary.Reasons, reason) {
+				summary.Reasons = append(summary.Reasons, reason)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(senders, func(i, j int) bool {
+		return senders[i].Scams+senders[i].Suspicious > senders[j].Scams+senders[j].Suspicious
+	})
+	result := make([]SuspiciousSender, len(senders))
+	for i, sender := range senders {
+		result[i] = *sender
+	}
+	return result, nil
+}
+
+// buildSuspiciousSection returns the weekly digest's section on the messages flagged in a period, or "" when there
+// were none
+func buildSuspiciousSection(db *sql.DB, after, before time.Time, logger waLog.Logger) string {
+	senders, err := getSuspiciousSenders(db, after, before)
+	if err != nil {
+		// The table is created by the bridge
+		logger.Debugf("Flagged messages not available: %v", err)
+		return ""
+	}
+	if len(senders) == 0 {
+		return ""
+	}
+
+	scams, suspicious := 0, 0
+	for _, sender := range senders {
+		scams += sender.Scams
+		suspicious += sender.Suspicious
+	}
+	lines := []string{fmt.Sprintf("*Suspicious messages* (🚫 %d quarantined from summaries, ⚠️ %d suspicious)", scams, suspicious)}
+	for i, sender := range senders {
+		if i == maxSuspiciousSenders {
+			lines = append(lines, fmt.Sprintf("_…and %d more senders_", len(senders)-i))
+			break
+		}
+		where := "in a direct message"
+		if strings.HasSuffix(sender.ChatJID, "@g.us") {
+			where = "in " + getGroupName(sender.ChatJID, logger)
+		}
+		count := sender.Scams + sender.Suspicious
+		icon := "⚠️"
+		if sender.Scams > 0 {
+			icon = "🚫"
+		}
+		line := fmt.Sprintf("%s %s, %s: %d message(s)", icon, getSenderName(sender.Sender, false, logger), where, count)
+		if len(sender.Reasons) > 0 {
+			line += " — " + strings.Join(sender.Reasons, ", ")
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
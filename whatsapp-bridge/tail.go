@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	tailURL        = flag.String("url", "", "Event stream URL (defaults to BRIDGE_API_URL with ws:// and /events)")
+	tailChat       = flag.String("chat", "", "Only show messages from chats whose JID or name contains this text")
+	tailSender     = flag.String("sender", "", "Only show messages from senders whose phone or name contains this text")
+	tailContains   = flag.String("contains", "", "Only show messages whose content contains this text")
+	tailMedia      = flag.String("media", "", "Only show media messages of this type (image, video, audio, document), or 'any'")
+	tailGroupsOnly = flag.Bool("groups", false, "Only show group messages")
+	tailDirectOnly = flag.Bool("direct", false, "Only show direct messages")
+	tailJSON       = flag.Bool("json", false, "Print raw JSON events instead of formatted lines")
+)
+
+func main() {
+	flag.Parse()
+
+	streamURL := *tailURL
+	if streamURL == "" {
+		streamURL = getEventStreamURL()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	fmt.Fprintf(os.Stderr, "Tailing %s (Ctrl+C to stop)\n", streamURL)
+
+	// Keep reconnecting so a bridge restart doesn't end the tail
+	backoff := time.Second
+	for {
+		err := tailEventStream(streamURL, signals)
+		if err == nil {
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "Stream disconnected: %v (reconnecting in %v)\n", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-signals:
+			return
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// getEventStreamURL derives the WebSocket URL of the bridge event stream from the REST API URL
+func getEventStreamURL() string {
+	apiURL := getBridgeAPIURL()
+	apiURL = strings.Replace(apiURL, "https://", "wss://", 1)
+	apiURL = strings.Replace(apiURL, "http://", "ws://", 1)
+	return apiURL + "/events"
+}
+
+// tailEventStream prints matching events until the connection drops (error) or a signal is received (nil)
+func tailEventStream(streamURL string, signals chan os.Signal) error {
+	header := http.Header{}
+	setBridgeAPIAuth(header)
+	conn, _, err := websocket.DefaultDialer.Dial(streamURL, header)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	events := make(chan StreamEvent)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			var event StreamEvent
+			if err := conn.ReadJSON(&event); err != nil {
+				errs <- err
+				return
+			}
+			events <- event
+		}
+	}()
+
+	for {
+		select {
+		case event := <-events:
+			if tailEventMatches(event) {
+				printTailEvent(event)
+			}
+		case err := <-errs:
+			return err
+		case <-signals:
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return nil
+		}
+	}
+}
+
+// tailEventMatches applies the filter flags to an event
+func tailEventMatches(event StreamEvent) bool {
+	if *tailGroupsOnly && !event.IsGroup {
+		return false
+	}
+	if *tailDirectOnly && event.IsGroup {
+		return false
+	}
+	if *tailChat != "" && !containsFold(event.ChatJID, *tailChat) && !containsFold(event.ChatName, *tailChat) {
+		return false
+	}
+	if *tailSender != "" && !containsFold(event.Sender, *tailSender) && !containsFold(event.SenderName, *tailSender) {
+		return false
+	}
+	if *tailContains != "" && !containsFold(event.Content, *tailContains) {
+		return false
+	}
+	if *tailMedia == "any" && event.MediaType == "" {
+		return false
+	}
+	if *tailMedia != "" && *tailMedia != "any" && event.MediaType != *tailMedia {
+		return false
+	}
+	return true
+}
+
+// containsFold reports whether substr is within s, ignoring case
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// printTailEvent prints an event as a single line (or JSON with --json)
+func printTailEvent(event StreamEvent) {
+	if *tailJSON {
+		data, _ := json.Marshal(event)
+		fmt.Println(string(data))
+		return
+	}
+
+	direction := "←"
+	if event.IsFromMe {
+		direction = "→"
+	}
+
+	content := event.Content
+	if event.MediaType != "" {
+		content = strings.TrimSpace(fmt.Sprintf("[%s: %s] %s", event.MediaType, event.Filename, content))
+	}
+
+	fmt.Printf("[%s] %s %s | %s (%s): %s\n",
+		event.Timestamp.Local().Format("2006-01-02 15:04:05"), direction, event.ChatName, event.SenderName, event.Sender, content)
+}
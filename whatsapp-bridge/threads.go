@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// How many replies up or down a thread is followed, so a quoting loop can't run forever
+const maxThreadDepth = 100
+
+// quotedMessage returns the ID and sender of the message a message replies to; "" when it isn't a reply
+func quotedMessage(msg *waProto.Message) (id, sender string) {
+	for _, contextInfo := range messageContextInfos(msg) {
+		if stanzaID := contextInfo.GetStanzaID(); stanzaID != "" {
+			return stanzaID, contextInfo.GetParticipant()
+		}
+	}
+	return "", ""
+}
+
+// StoreMessageThread records which message a stored message replies to, if any
+func (store *MessageStore) StoreMessageThread(messageID, chatJID string, msg *waProto.Message) error {
+	replyTo, replyToSender := quotedMessage(msg)
+	if replyTo == "" || messageID == "" {
+		return nil
+	}
+	_, err := store.dbFor(chatJID).Exec(`
+		INSERT INTO message_threads (message_id, chat_jid, reply_to_id, reply_to_sender) VALUES (?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid) DO UPDATE SET reply_to_id = excluded.reply_to_id, reply_to_sender = excluded.reply_to_sender
+	`, messageID, chatJID, replyTo, replyToSender)
+	return err
+}
+
+// recordMessageThread records which message a received message replies to, logging failures
+func recordMessageThread(messageStore *MessageStore, messageID, chatJID string, msg *waProto.Message, logger waLog.Logger) {
+	if err := messageStore.StoreMessageThread(messageID, chatJID, msg); err != nil {
+		logger.Warnf("Failed to record which message %s replies to: %v", messageID, err)
+	}
+}
+
+// ThreadMessage is a message of a reply thread
+type ThreadMessage struct {
+	ID        string    `json:"id"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+	IsFromMe  bool      `json:"is_from_me"`
+	MediaType string    `json:"media_type,omitempty"`
+	ReplyTo   string    `json:"reply_to,omitempty"` // ID of the message this one quotes
+
+	SenderName string     `json:"sender_name,omitempty"`
+	Reactions  []Reaction `json:"reactions,omitempty"`
+}
+
+// GetThread reconstructs the reply thread a message belongs to: the messages it replies to up to the first, and
+// every reply to any of them and to those replies, in time order, with their reactions. Quoted messages that were
+// never stored are left out.
+func (store *MessageStore) GetThread(chatJID, messageID string) ([]ThreadMessage, error) {
+	rows, err := store.dbFor(chatJID).Query(`
+		WITH RECURSIVE
+			up (id, depth) AS (
+				SELECT ?, 0
+				UNION
+				SELECT t.reply_to_id, up.depth + 1 FROM message_threads t JOIN up ON t.message_id = up.id
+				WHERE t.chat_jid = ? AND up.depth < ?
+			),
+			down (id, depth) AS (
+				SELECT id, 0 FROM up
+				UNION
+				SELECT t.message_id, down.depth + 1 FROM message_threads t JOIN down ON t.reply_to_id = down.id
+				WHERE t.chat_jid = ? AND down.depth < ?
+			)
+		SELECT m.id, m.sender, m.content, m.timestamp, m.is_from_me, COALESCE(m.media_type, ''), COALESCE(t.reply_to_id, '')
+		FROM messages m
+		LEFT JOIN message_threads t ON t.message_id = m.id AND t.chat_jid = m.chat_jid
+		WHERE m.chat_jid = ? AND m.id IN (SELECT id FROM up UNION SELECT id FROM down)
+		ORDER BY m.timestamp
+	`, messageID, chatJID, maxThreadDepth, chatJID, maxThreadDepth, chatJID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var thread []ThreadMessage
+	for rows.Next() {
+		var message ThreadMessage
+		if err := rows.Scan(&message.ID, &message.Sender, &message.Content, &message.Timestamp, &message.IsFromMe, &message.MediaType, &message.ReplyTo); err != nil {
+			return nil, err
+		}
+		message.Content = store.open(message.Content)
+		thread = append(thread, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range thread {
+		reactions, err := store.GetReactions(thread[i].ID, chatJID)
+		if err != nil {
+			return nil, err
+		}
+		if len(reactions) > 0 {
+			thread[i].Reactions = reactions
+		}
+	}
+	return thread, nil
+}
+
+// threadRoot returns the ID of the first stored message of a thread, the one whose quoted message, if any, isn't in it
+func threadRoot(thread []ThreadMessage) string {
+	ids := make(map[string]bool)
+	for _, message := range thread {
+		ids[message.ID] = true
+	}
+	for _, message := range thread {
+		if !ids[message.ReplyTo] {
+			return message.ID
+		}
+	}
+	return ""
+}
+
+// handleThreadAPI returns the reply thread a message belongs to (GET /api/thread?chat_jid=...&message_id=...)
+func handleThreadAPI(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		chatJID, messageID := r.URL.Query().Get("chat_jid"), r.URL.Query().Get("message_id")
+		if chatJID == "" || messageID == "" {
+			http.Error(w, "chat_jid and message_id are required", http.StatusBadRequest)
+			return
+		}
+		if !apiRequestAllowsChat(r, chatJID) {
+			rejectChat(w, r, chatJID)
+			return
+		}
+
+		thread, err := messageStore.GetThread(chatJID, messageID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(thread) == 0 {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		names := make(map[string]string)
+		for i, message := range thread {
+			if message.IsFromMe {
+				thread[i].SenderName = "Me"
+				continue
+			}
+			if _, ok := names[message.Sender]; !ok {
+				names[message.Sender] = lookupSenderName(messageStore.db, message.Sender)
+			}
+			thread[i].SenderName = names[message.Sender]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"chat_jid": chatJID, "root": threadRoot(thread), "messages": thread})
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimelineRange = 24 * time.Hour
+	maxTimelineBuckets   = 24 * 62
+)
+
+// TimelineBucket is the activity of a chat during one time bucket
+type TimelineBucket struct {
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	MessageCount int       `json:"message_count"`
+	SenderCount  int       `json:"sender_count"`
+	Topics       []string  `json:"topics"`
+}
+
+// TimelineTopic is a segmented topic with the time span of its messages, for "jump to when topic X started"
+type TimelineTopic struct {
+	Topic          string    `json:"topic"`
+	EpisodeUUID    string    `json:"episode_uuid"`
+	Date           string    `json:"date"`
+	MessageCount   int       `json:"message_count"`
+	FirstMessageID string    `json:"first_message_id"`
+	FirstMessageAt time.Time `json:"first_message_at"`
+	LastMessageAt  time.Time `json:"last_message_at"`
+}
+
+// TimelineResponse is returned by /api/timeline
+type TimelineResponse struct {
+	ChatJID  string           `json:"chat_jid"`
+	Timezone string           `json:"timezone"`
+	Bucket   string           `json:"bucket"`
+	Start    time.Time        `json:"start"`
+	End      time.Time        `json:"end"`
+	Buckets  []TimelineBucket `json:"buckets"`
+	Topics   []TimelineTopic  `json:"topics"`
+}
+
+// parseTimelineTime accepts RFC 3339 timestamps or YYYY-MM-DD dates in the given location
+func parseTimelineTime(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, loc)
+}
+
+// handleTimelineAPI serves GET /api/timeline?chat_jid=...&start=...&end=...&bucket=hour|day&timezone=...&topic=...
+func handleTimelineAPI(messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		chatJID := query.Get("chat_jid")
+		if chatJID == "" {
+			http.Error(w, "chat_jid is required", http.StatusBadRequest)
+			return
+		}
+		if !apiRequestAllowsChat(r, chatJID) {
+			rejectChat(w, r, chatJID)
+			return
+		}
+
+		timezone := query.Get("timezone")
+		if timezone == "" {
+			timezone = os.Getenv("DAILY_SUMMARY_TIMEZONE")
+		}
+		if timezone == "" {
+			timezone = "UTC"
+		}
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid timezone: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		bucketSize := time.Hour
+		bucket := query.Get("bucket")
+		switch bucket {
+		case "", "hour":
+			bucket = "hour"
+		case "day":
+			bucketSize = 24 * time.Hour
+		default:
+			http.Error(w, "bucket must be hour or day", http.StatusBadRequest)
+			return
+		}
+
+		end := time.Now().In(loc)
+		if value := query.Get("end"); value != "" {
+			if end, err = parseTimelineTime(value, loc); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid end: %v", err), http.StatusBadRequest)
+				return
+			}
+			// A bare end date includes the whole day
+			if len(value) == len("2006-01-02") {
+				end = end.AddDate(0, 0, 1)
+			}
+		}
+		start := end.Add(-defaultTimelineRange)
+		if value := query.Get("start"); value != "" {
+			if start, err = parseTimelineTime(value, loc); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid start: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if !start.Before(end) {
+			http.Error(w, "start must be before end", http.StatusBadRequest)
+			return
+		}
+		if end.Sub(start)/bucketSize > maxTimelineBuckets {
+			http.Error(w, fmt.Sprintf("Range too large: at most %d buckets", maxTimelineBuckets), http.StatusBadRequest)
+			return
+		}
+
+		timeline, err := buildTimeline(messageStore.db, chatJID, start.In(loc), end.In(loc), bucketSize, query.Get("topic"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to build timeline: %v", err), http.StatusInternalServerError)
+			return
+		}
+		timeline.Timezone = timezone
+		timeline.Bucket = bucket
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(timeline)
+	}
+}
+
+// buildTimeline counts a chat's messages per bucket and labels each bucket with the topics whose messages overlap it.
+// Topics come from the recorded episodes; a topic filter keeps only matching topics (case-insensitive substring).
+func buildTimeline(db *sql.DB, chatJID string, start, end time.Time, bucketSize time.Duration, topicFilter string) (*TimelineResponse, error) {
+	loc := start.Location()
+
+	// Buckets aligned to local hours or midnights
+	alignedStart := time.Date(start.Year(), start.Month(), start.Day(), start.Hour(), 0, 0, 0, loc)
+	if bucketSize >= 24*time.Hour {
+		alignedStart = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+	}
+
+	var buckets []TimelineBucket
+	for bucketStart := alignedStart; bucketStart.Before(end); {
+		bucketEnd := bucketStart.Add(bucketSize)
+		if bucketSize >= 24*time.Hour {
+			bucketEnd = bucketStart.AddDate(0, 0, 1) // DST-safe
+		}
+		buckets = append(buckets, TimelineBucket{Start: bucketStart, End: bucketEnd, Topics: []string{}})
+		bucketStart = bucketEnd
+	}
+
+	bucketIndex := func(t time.Time) int {
+		i := sort.Search(len(buckets), func(i int) bool { return buckets[i].End.After(t) })
+		if i < len(buckets) && !t.Before(buckets[i].Start) {
+			return i
+		}
+		return -1
+	}
+
+	// Message activity
+	rows, err := db.Query(`
+		SELECT timestamp, sender FROM messages
+		WHERE chat_jid = ? AND timestamp >= ? AND timestamp < ? AND COALESCE(message_type, '') = ''
+	`, chatJID, alignedStart, end)
+	if err != nil {
+		return nil, err
+	}
+	senders := make([]map[string]bool, len(buckets))
+	for rows.Next() {
+		var timestamp time.Time
+		var sender string
+		if err := rows.Scan(&timestamp, &sender); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if i := bucketIndex(timestamp); i >= 0 {
+			buckets[i].MessageCount++
+			if senders[i] == nil {
+				senders[i] = make(map[string]bool)
+			}
+			senders[i][sender] = true
+		}
+	}
+	rows.Close()
+	for i := range buckets {
+		buckets[i].SenderCount = len(senders[i])
+	}
+
+	// Topic labels from the segmentations recorded with each episode
+	if err := ensureEpisodeTables(db); err != nil {
+		return nil, err
+	}
+	rows, err = db.Query(`
+		SELECT uuid, COALESCE(topic, ''), COALESCE(date, ''), COALESCE(message_count, 0), COALESCE(first_message_id, ''), first_message_at, last_message_at
+		FROM graphiti_episodes
+		WHERE group_jid = ? AND first_message_at IS NOT NULL AND first_message_at < ? AND last_message_at >= ?
+		ORDER BY first_message_at
+	`, chatJID, end, alignedStart)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	topics := []TimelineTopic{}
+	for rows.Next() {
+		var topic TimelineTopic
+		if err := rows.Scan(&topic.EpisodeUUID, &topic.Topic, &topic.Date, &topic.MessageCount, &topic.FirstMessageID, &topic.FirstMessageAt, &topic.LastMessageAt); err != nil {
+			return nil, err
+		}
+		if topicFilter != "" && !strings.Contains(strings.ToLower(topic.Topic), strings.ToLower(topicFilter)) {
+			continue
+		}
+		topics = append(topics, topic)
+
+		for i := range buckets {
+			if topic.FirstMessageAt.Before(buckets[i].End) && !topic.LastMessageAt.Before(buckets[i].Start) {
+				buckets[i].Topics = append(buckets[i].Topics, topic.Topic)
+			}
+		}
+	}
+
+	return &TimelineResponse{
+		ChatJID: chatJID,
+		Start:   alignedStart,
+		End:     end,
+		Buckets: buckets,
+		Topics:  topics,
+	}, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		printTokensUsage()
+		os.Exit(1)
+	}
+
+	// Open SQLite database for messages
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open message database: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	if err := ensureAPITokensTable(db); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create API tokens table: %v\n", err)
+		os.Exit(1)
+	}
+
+	command, args := os.Args[1], os.Args[2:]
+	switch command {
+	case "list":
+		err = runTokensList(db)
+	case "create":
+		err = runTokensCreate(db, args)
+	case "revoke":
+		err = runTokensRevoke(db, args)
+	case "help", "--help", "-h":
+		printTokensUsage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
+		printTokensUsage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printTokensUsage() {
+	fmt.Println(`Bridge API tokens
+
+USAGE:
+    tokens list
+    tokens create --name NAME --chats CHATS --capabilities CAPABILITIES [--expires-days N]
+    tokens revoke --name NAME
+
+CHATS is a comma-separated list of chat JIDs, phone numbers or group names, or "*" for every chat.
+CAPABILITIES is a comma-separated list of:
+    read      messages, timeline, pulse and live events of the token's chats
+    send      text messages to the token's chats
+    download  media of the token's chats
+    admin     everything, including import control, safe mode and sending media files
+
+Clients send the token as "Authorization: Bearer <token>". By default (BRIDGE_API_AUTH=local) only
+clients on the bridge's machine, or in its container, may go without one. Set BRIDGE_API_AUTH=required to
+reject every request without a token; the bridge's own tools then use BRIDGE_API_TOKEN.`)
+}
+
+func runTokensList(db *sql.DB) error {
+	tokens, err := listAPITokens(db)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		fmt.Println("No API tokens")
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tSTATUS\tCAPABILITIES\tCHATS\tLAST USED")
+	for _, token := range tokens {
+		status := "active"
+		if !token.RevokedAt.IsZero() {
+			status = "revoked"
+		} else if !token.ExpiresAt.IsZero() && time.Now().After(token.ExpiresAt) {
+			status = "expired"
+		} else if !token.ExpiresAt.IsZero() {
+			status = "until " + token.ExpiresAt.Format("2006-01-02")
+		}
+		lastUsed := "never"
+		if !token.LastUsedAt.IsZero() {
+			lastUsed = token.LastUsedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", token.Name, status,
+			strings.Join(token.Capabilities, ","), strings.Join(token.Chats, ","), lastUsed)
+	}
+	return writer.Flush()
+}
+
+func runTokensCreate(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	name := fs.String("name", "", "Token name, e.g. who or what uses it (required)")
+	chats := fs.String("chats", "", "Comma-separated chat JIDs, phone numbers or group names, or * (required)")
+	capabilities := fs.String("capabilities", "", "Comma-separated capabilities: read, send, download, admin (required)")
+	expiresDays := fs.Int("expires-days", 0, "Expire the token after N days (default never)")
+	fs.Parse(args)
+
+	if *name == "" || *chats == "" || *capabilities == "" {
+		return fmt.Errorf("--name, --chats and --capabilities are required")
+	}
+
+	var chatJIDs []string
+	for _, chat := range strings.Split(*chats, ",") {
+		chat = strings.TrimSpace(chat)
+		switch {
+		case chat == "":
+			continue
+		case chat == "*" || strings.Contains(chat, "@"):
+		case strings.Trim(chat, "+0123456789") == "":
+			chat = strings.TrimPrefix(chat, "+") + "@s.whatsapp.net"
+		default:
+			// A group name, resolved once so renaming the group doesn't widen or break the token
+			jid, err := resolveGroupReference(chat)
+			if err != nil {
+				return err
+			}
+			chat = jid
+		}
+		chatJIDs = append(chatJIDs, chat)
+	}
+
+	var grants []string
+	for _, capability := range strings.Split(*capabilities, ",") {
+		if capability = strings.TrimSpace(capability); capability != "" {
+			grants = append(grants, capability)
+		}
+	}
+
+	var expiresAt time.Time
+	if *expiresDays > 0 {
+		expiresAt = time.Now().AddDate(0, 0, *expiresDays)
+	}
+
+	token, err := createAPIToken(db, *name, chatJIDs, grants, expiresAt)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created token %q for %s (%s)\n", *name, strings.Join(chatJIDs, ", "), strings.Join(grants, ", "))
+	fmt.Printf("\n    %s\n\nIt won't be shown again.\n", token)
+	return nil
+}
+
+func runTokensRevoke(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	name := fs.String("name", "", "Token name (required)")
+	fs.Parse(args)
+
+	if *name == "" {
+		return fmt.Errorf("--name is required")
+	}
+	if err := revokeAPIToken(db, *name); err != nil {
+		return err
+	}
+	fmt.Printf("Revoked token %q\n", *name)
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Transcriber turns a downloaded voice note into text
+type Transcriber interface {
+	Name() string
+	Transcribe(audioPath string) (string, error)
+}
+
+const (
+	transcriptionBackendNone       = "none"
+	transcriptionBackendWhisperCpp = "whisper-cpp"
+)
+
+// getTranscriptionBackend returns the configured backend (TRANSCRIPTION_BACKEND, default none)
+func getTranscriptionBackend() string {
+	backend := os.Getenv("TRANSCRIPTION_BACKEND")
+	if backend == "" {
+		backend = transcriptionBackendNone
+	}
+	return backend
+}
+
+// newTranscriber creates the configured transcriber, or nil when transcription is disabled
+func newTranscriber() (Transcriber, error) {
+	switch backend := getTranscriptionBackend(); backend {
+	case transcriptionBackendNone:
+		return nil, nil
+	case transcriptionBackendWhisperCpp:
+		return NewWhisperCppTranscriber()
+	default:
+		return nil, fmt.Errorf("unknown transcription backend %q (expected %s or %s)", backend, transcriptionBackendNone, transcriptionBackendWhisperCpp)
+	}
+}
+
+// WhisperCppTranscriber runs whisper.cpp on the local machine, so audio never leaves it
+type WhisperCppTranscriber struct {
+	binary    string
+	modelPath string
+	language  string
+	threads   string
+	ffmpeg    string
+}
+
+// NewWhisperCppTranscriber configures whisper.cpp from WHISPER_CPP_BINARY, WHISPER_MODEL_PATH, WHISPER_LANGUAGE and WHISPER_THREADS
+func NewWhisperCppTranscriber() (*WhisperCppTranscriber, error) {
+	transcriber := &WhisperCppTranscriber{
+		binary:    os.Getenv("WHISPER_CPP_BINARY"),
+		modelPath: os.Getenv("WHISPER_MODEL_PATH"),
+		language:  os.Getenv("WHISPER_LANGUAGE"),
+		threads:   os.Getenv("WHISPER_THREADS"),
+		ffmpeg:    os.Getenv("FFMPEG_BINARY"),
+	}
+	if transcriber.binary == "" {
+		transcriber.binary = "whisper-cli"
+	}
+	if transcriber.language == "" {
+		transcriber.language = "auto"
+	}
+	if transcriber.threads == "" {
+		transcriber.threads = "4"
+	}
+	if transcriber.ffmpeg == "" {
+		transcriber.ffmpeg = "ffmpeg"
+	}
+
+	if transcriber.modelPath == "" {
+		return nil, fmt.Errorf("WHISPER_MODEL_PATH is required for the whisper-cpp transcription backend")
+	}
+	if _, err := os.Stat(transcriber.modelPath); err != nil {
+		return nil, fmt.Errorf("whisper model not found: %v", err)
+	}
+	if _, err := exec.LookPath(transcriber.binary); err != nil {
+		return nil, fmt.Errorf("whisper.cpp binary %s not found: %v", transcriber.binary, err)
+	}
+	if _, err := exec.LookPath(transcriber.ffmpeg); err != nil {
+		return nil, fmt.Errorf("ffmpeg binary %s not found: %v", transcriber.ffmpeg, err)
+	}
+
+	return transcriber, nil
+}
+
+// Name returns the backend name
+func (transcriber *WhisperCppTranscriber) Name() string {
+	return transcriptionBackendWhisperCpp
+}
+
+// Transcribe converts the voice note to the 16 kHz mono WAV whisper.cpp expects and returns the recognized text
+func (transcriber *WhisperCppTranscriber) Transcribe(audioPath string) (string, error) {
+	wavFile, err := os.CreateTemp("", "voice-*.wav")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	wavPath := wavFile.Name()
+	wavFile.Close()
+	defer os.Remove(wavPath)
+
+	convert := exec.Command(transcriber.ffmpeg, "-y", "-loglevel", "error", "-i", audioPath, "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", wavPath)
+	if output, err := convert.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	var stdout, stderr bytes.Buffer
+	whisper := exec.Command(transcriber.binary,
+		"-m", transcriber.modelPath,
+		"-f", wavPath,
+		"-l", transcriber.language,
+		"-t", transcriber.threads,
+		"-nt", // no timestamps
+		"-np", // no progress or system info
+	)
+	whisper.Stdout = &stdout
+	whisper.Stderr = &stderr
+	if err := whisper.Run(); err != nil {
+		return "", fmt.Errorf("whisper.cpp failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	// whisper.cpp prints one line per segment
+	var lines []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, " "), nil
+}
+
+// StoreTranscript saves the transcript of a voice note
+func (store *MessageStore) StoreTranscript(id, chatJID, transcript string) error {
+	transcript, err := store.seal(chatJID, transcript)
+	if err != nil {
+		return err
+	}
+	_, err = store.dbFor(chatJID).Exec("UPDATE messages SET transcript = ? WHERE id = ? AND chat_jid = ?", transcript, id, chatJID)
+	return err
+}
+
+// transcribeVoiceNote downloads a voice note and stores its transcript; meant to run in its own goroutine
+func transcribeVoiceNote(client *whatsmeow.Client, messageStore *MessageStore, transcriber Transcriber, messageID, chatJID string, logger waLog.Logger) {
+	success, _, _, path, err := downloadMedia(client, messageStore, messageID, chatJID)
+	if !success || err != nil {
+		logger.Warnf("Failed to download voice note %s for transcription: %v", messageID, err)
+		return
+	}
+
+	transcript, err := transcriber.Transcribe(path)
+	if err != nil {
+		logger.Warnf("Failed to transcribe voice note %s: %v", messageID, err)
+		return
+	}
+	if transcript == "" {
+		return
+	}
+
+	if err := messageStore.StoreTranscript(messageID, chatJID, transcript); err != nil {
+		logger.Warnf("Failed to store transcript of %s: %v", messageID, err)
+		return
+	}
+	fmt.Printf("Transcribed voice note %s (%s): %s\n", messageID, filepath.Base(path), transcript)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// The translations entry of config.json lists chats, e.g. international deal groups, whose incoming messages are
+// translated into my language as they arrive (see live-translation.go): each translation is forwarded to my
+// self-chat, listed under the chat's daily summary, or both. Translations come from the LLM, or from DeepL with
+// TRANSLATION_PROVIDER=deepl; messages already in my language are left alone.
+
+const (
+	translationModeForward = "forward"
+	translationModeSummary = "summary"
+	translationModeBoth    = "both"
+
+	translationProviderLLM   = "llm"
+	translationProviderDeepL = "deepl"
+
+	defaultTranslationLanguage = "English"
+
+	// Translations listed under a daily summary; the later ones are counted instead
+	maxSummaryTranslations = 30
+)
+
+const messageTranslationPrompt = `Translate this WhatsApp message into %s. Keep its tone, emoji, names, numbers and WhatsApp formatting (*bold*, _italic_).
+
+If the message is already in %s, or has nothing to translate, leave translation empty.
+
+<message>
+%s
+</message>`
+
+// translationSchema describes the LLM's translation of a message
+var translationSchema = &JSONSchema{
+	Type: "object",
+	Properties: map[string]*JSONSchema{
+		"source_language": {Type: "string", Description: `the language the message is written in, in English, e.g. "Portuguese"`},
+		"translation":     {Type: "string", Description: "the translated message, or \"\" when it is already in the target language"},
+	},
+	Required: []string{"source_language", "translation"},
+}
+
+// deeplLanguageCodes are the DeepL target codes of the languages most likely to be configured by name
+var deeplLanguageCodes = map[string]string{
+	"english":    "EN-US",
+	"portuguese": "PT-BR",
+	"spanish":    "ES",
+	"french":     "FR",
+	"german":     "DE",
+	"italian":    "IT",
+	"dutch":      "NL",
+	"polish":     "PL",
+	"russian":    "RU",
+	"ukrainian":  "UK",
+	"turkish":    "TR",
+	"japanese":   "JA",
+	"korean":     "KO",
+	"chinese":    "ZH",
+	"arabic":     "AR",
+	"indonesian": "ID",
+}
+
+// translationRule is a translation rule with its chats resolved
+type translationRule struct {
+	chats    map[string]bool
+	language string
+	forward  bool
+	summary  bool
+}
+
+// StoredTranslation is a message translation, with the message's sender and time
+type StoredTranslation struct {
+	MessageID      string
+	Sender         string
+	Timestamp      time.Time
+	SourceLanguage string
+	Translation    string
+}
+
+// getTranslationProvider returns what translates messages (TRANSLATION_PROVIDER: llm, the default, or deepl)
+func getTranslationProvider() string {
+	if strings.EqualFold(os.Getenv("TRANSLATION_PROVIDER"), translationProviderDeepL) {
+		return translationProviderDeepL
+	}
+	return translationProviderLLM
+}
+
+// newTranslationRule resolves the chats of a translation rule; myLanguage is used when the rule sets none
+func newTranslationRule(config TranslationConfig, myLanguage string) (translationRule, error) {
+	rule := translationRule{chats: make(map[string]bool), language: strings.TrimSpace(config.Language)}
+	if rule.language == "" {
+		rule.language = myLanguage
+	}
+	switch strings.ToLower(strings.TrimSpace(config.Mode)) {
+	case "", translationModeForward:
+		rule.forward = true
+	case translationModeSummary:
+		rule.summary = true
+	case translationModeBoth:
+		rule.forward, rule.summary = true, true
+	default:
+		return rule, fmt.Errorf("mode must be %s, %s or %s, not %q", translationModeForward, translationModeSummary, translationModeBoth, config.Mode)
+	}
+	if len(config.Chats) == 0 {
+		return rule, fmt.Errorf("no chats")
+	}
+	for _, chat := range config.Chats {
+		jid, err := resolveChatArgument(chat)
+		if err != nil {
+			return rule, fmt.Errorf("chat %q: %v", chat, err)
+		}
+		rule.chats[jid] = true
+	}
+	return rule, nil
+}
+
+// loadTranslationRules returns the translation rules of the config, leaving out the invalid ones
+func loadTranslationRules(config *BridgeConfig, logger waLog.Logger) []translationRule {
+	myLanguage := strings.TrimSpace(config.getRecipientConfig("self").Language)
+	if myLanguage == "" {
+		myLanguage = defaultTranslationLanguage
+	}
+	var rules []translationRule
+	for i, ruleConfig := range config.Translations {
+		rule, err := newTranslationRule(ruleConfig, myLanguage)
+		if err != nil {
+			logger.Warnf("Translation rule %d disabled: %v", i+1, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// translationRuleFor returns the first rule covering a chat, or nil
+func translationRuleFor(rules []translationRule, chatJID string) *translationRule {
+	for i := range rules {
+		if rules[i].chats[chatJID] {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// translateMessage translates an incoming message into a language with the configured provider. The translation
+// is "" when the message is already in that language.
+func translateMessage(ctx context.Context, text, language string, logger waLog.Logger) (translation, sourceLanguage string, err error) {
+	if getTranslationProvider() == translationProviderDeepL {
+		return translateWithDeepL(ctx, text, language)
+	}
+	var answer struct {
+		SourceLanguage string `json:"source_language"`
+		Translation    string `json:"translation"`
+	}
+	prompt := fmt.Sprintf(messageTranslationPrompt, language, language, text)
+	if err := callLLMStructured(withLLMPurpose(ctx, "translation"), prompt, translationSchema, &answer, logger); err != nil {
+		return "", "", err
+	}
+	translation = strings.TrimSpace(answer.Translation)
+	if strings.EqualFold(strings.TrimSpace(answer.SourceLanguage), language) || translation == strings.TrimSpace(text) {
+		translation = ""
+	}
+	return translation, strings.TrimSpace(answer.SourceLanguage), nil
+}
+
+// deeplTargetCode returns the DeepL code of a language given by name or code, e.g. "PT-BR" for Portuguese
+func deeplTargetCode(language string) string {
+	if code, ok := deeplLanguageCodes[strings.ToLower(strings.TrimSpace(language))]; ok {
+		return code
+	}
+	return strings.ToUpper(strings.TrimSpace(language))
+}
+
+// translateWithDeepL translates a message with the DeepL API (DEEPL_API_KEY; free keys use the free endpoint)
+func translateWithDeepL(ctx context.Context, text, language string) (translation, sourceLanguage string, err error) {
+	apiKey := os.Getenv("DEEPL_API_KEY")
+	if apiKey == "" {
+		return "", "", fmt.Errorf("DEEPL_API_KEY is not set")
+	}
+	apiURL := os.Getenv("DEEPL_API_URL")
+	if apiURL == "" {
+		apiURL = "https://api.deepl.com/v2"
+		if strings.HasSuffix(apiKey, ":fx") {
+			apiURL = "https://api-free.deepl.com/v2"
+		}
+	}
+	target := deeplTargetCode(language)
+
+	jsonData, err := json.Marshal(map[string]interface{}{"text": []string{text}, "target_lang": target})
+	if err != nil {
+		return "", "", fmt.Errorf("error marshaling request: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(apiURL, "/")+"/translate", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", "", fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+apiKey)
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("error reading response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("DeepL returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var deeplResp struct {
+		Translations []struct {
+			DetectedSourceLanguage string `json:"detected_source_language"`
+			Text                   string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := json.Unmarshal(body, &deeplResp); err != nil {
+		return "", "", fmt.Errorf("error parsing response: %v", err)
+	}
+	if len(deeplResp.Translations) == 0 {
+		return "", "", fmt.Errorf("DeepL returned no translation")
+	}
+	result := deeplResp.Translations[0]
+	// "EN" is detected for an "EN-US" target
+	if strings.EqualFold(result.DetectedSourceLanguage, strings.Split(target, "-")[0]) {
+		return "", result.DetectedSourceLanguage, nil
+	}
+	return strings.TrimSpace(result.Text), result.DetectedSourceLanguage, nil
+}
+
+// storeTranslation saves the translation of a message
+func storeTranslation(db *sql.DB, messageID, chatJID, language, sourceLanguage, translation, provider string) error {
+	_, err := db.Exec(`
+		INSERT INTO message_translations (message_id, chat_jid, language, source_language, translation, provider, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, chat_jid, language) DO UPDATE SET
+			source_language = excluded.source_language, translation = excluded.translation, provider = excluded.provider,
+			created_at = excluded.created_at
+	`, messageID, chatJID, language, sourceLanguage, translation, provider, time.Now())
+	return err
+}
+
+// loadTranslations returns the translations into a language of a chat's messages sent in a period, oldest first
+func loadTranslations(db *sql.DB, chatJID, language string, after, before time.Time) ([]StoredTranslation, error) {
+	rows, err := db.Query(`
+		SELECT t.message_id, m.sender, m.timestamp, t.source_language, t.translation
+		FROM message_translations t
+		JOIN messages m ON m.id = t.message_id AND m.chat_jid = t.chat_jid
+		WHERE t.chat_jid = ? AND t.language = ? AND m.timestamp >= ? AND m.timestamp < ? AND m.deleted_at IS NULL
+		ORDER BY m.timestamp
+	`, chatJID, language, after, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var translations []StoredTranslation
+	for rows.Next() {
+		var translation StoredTranslation
+		if err := rows.Scan(&translation.MessageID, &translation.Sender, &translation.Timestamp, &translation.SourceLanguage, &translation.Translation); err != nil {
+			return nil, err
+		}
+		translations = append(translations, translation)
+	}
+	return translations, rows.Err()
+}
+
+// formatTranslationsSection renders the translations listed under a daily summary
+func formatTranslationsSection(translations []StoredTranslation, language string, loc *time.Location, senderName func(string) string) string {
+	lines := []string{fmt.Sprintf("🌐 *Translated messages* (into %s)", language)}
+	for i, translation := range translations {
+		if i == maxSummaryTranslations {
+			lines = append(lines, fmt.Sprintf("_…and %d more_", len(translations)-i))
+			break
+		}
+		text := strings.Join(strings.Fields(translation.Translation), " ")
+		lines = append(lines, fmt.Sprintf("[%s] %s: %s", translation.Timestamp.In(loc).Format("15:04"), senderName(translation.Sender), text))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// appendTranslations lists the day's translations of a chat with a summary translation rule under its summary;
+// it is left as is otherwise
+func appendTranslations(summary string, config *BridgeConfig, chatJID string, startOfDay, endOfDay time.Time, logger waLog.Logger) string {
+	rule := translationRuleFor(loadTranslationRules(config, logger), chatJID)
+	if rule == nil || !rule.summary {
+		return summary
+	}
+	db, err := openSharedMessagesDB()
+	if err != nil {
+		logger.Warnf("Translations not available: %v", err)
+		return summary
+	}
+
+	translations, err := loadTranslations(db, chatJID, rule.language, startOfDay, endOfDay)
+	if err != nil {
+		logger.Warnf("Failed to load translations: %v", err)
+		return summary
+	}
+	if len(translations) == 0 {
+		return summary
+	}
+	logger.Infof("Listing %d translated message(s) under the summary", len(translations))
+	section := formatTranslationsSection(translations, rule.language, startOfDay.Location(), func(sender string) string {
+		return getSenderName(sender, false, logger)
+	})
+	return summary + "\n\n" + section
+}
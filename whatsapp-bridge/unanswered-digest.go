@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The unanswered digest reminds me of who I owe a reply to: the direct chats and group mentions the bridge
+// reports as waiting for me (/api/unanswered), sent to my self-chat.
+
+var (
+	unansweredHours  = flag.Int("hours", envInt("UNANSWERED_DIGEST_HOURS", 24), "Report messages waiting for a reply for more than this many hours (defaults to UNANSWERED_DIGEST_HOURS, then 24)")
+	unansweredDays   = flag.Int("days", envInt("UNANSWERED_DIGEST_DAYS", 7), "Look at the messages of this many days (defaults to UNANSWERED_DIGEST_DAYS, then 7)")
+	unansweredSendTo = flag.String("send-to", os.Getenv("UNANSWERED_DIGEST_SEND_TO"), "Recipient JID or \"self\" (defaults to UNANSWERED_DIGEST_SEND_TO, then self)")
+	unansweredDryRun = flag.Bool("dry-run", false, "Print the digest instead of sending it")
+)
+
+// Longest preview of the last message of each chat
+const unansweredPreviewLength = 80
+
+// unansweredDigestChat is an entry of the bridge's /api/unanswered response
+type unansweredDigestChat struct {
+	Kind       string    `json:"kind"`
+	ChatJID    string    `json:"chat_jid"`
+	ChatName   string    `json:"chat_name"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name"`
+	Count      int       `json:"count"`
+	Since      time.Time `json:"since"`
+	Content    string    `json:"content"`
+}
+
+// envInt returns an integer environment variable, or fallback when it is unset or invalid
+func envInt(name string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+func main() {
+	flag.Parse()
+
+	logger := newLogger("UnansweredDigest", "INFO")
+	logger.Infof("Starting unanswered digest...")
+
+	if exitIfSafeMode(logger) {
+		return
+	}
+
+	var response struct {
+		Chats []unansweredDigestChat `json:"chats"`
+	}
+	query := url.Values{"hours": {strconv.Itoa(*unansweredHours)}, "days": {strconv.Itoa(*unansweredDays)}}
+	if err := callBridgeAPI("GET", "/unanswered?"+query.Encode(), nil, &response); err != nil {
+		logger.Errorf("Failed to list unanswered messages: %v", err)
+		os.Exit(1)
+	}
+	if len(response.Chats) == 0 {
+		logger.Infof("Nothing waiting for a reply, nothing to send")
+		return
+	}
+
+	digest := formatUnansweredDigest(response.Chats, time.Now())
+	if *unansweredDryRun {
+		fmt.Println(digest)
+		return
+	}
+
+	sendTo := *unansweredSendTo
+	if sendTo == "" {
+		sendTo = "self"
+	}
+	if err := sendToRecipient(digest, sendTo, logger); err != nil {
+		logger.Errorf("Failed to send unanswered digest: %v", err)
+		os.Exit(1)
+	}
+	logger.Infof("Sent unanswered digest with %d chat(s) to %s", len(response.Chats), sendTo)
+}
+
+// formatUnansweredDigest renders the digest, direct chats first, longest waiting first within each part
+func formatUnansweredDigest(chats []unansweredDigestChat, now time.Time) string {
+	people := make(map[string]bool)
+	var direct, mentions []string
+	for _, chat := range chats {
+		people[strings.Split(chat.Sender, "@")[0]] = true
+		name := chat.SenderName
+		if name == "" {
+			name = strings.Split(chat.Sender, "@")[0]
+		}
+		preview := []rune(strings.Join(strings.Fields(chat.Content), " "))
+		if len(preview) > unansweredPreviewLength {
+			preview = append(preview[:unansweredPreviewLength-1], '…')
+		}
+		waiting := formatWaitingTime(now.Sub(chat.Since))
+		if chat.Kind == "mention" {
+			group := chat.ChatName
+			if group == "" {
+				group = chat.ChatJID
+			}
+			mentions = append(mentions, fmt.Sprintf("• *%s* in %s, %s: _%s_", name, group, waiting, string(preview)))
+			continue
+		}
+		count := ""
+		if chat.Count > 1 {
+			count = fmt.Sprintf(" (%d messages)", chat.Count)
+		}
+		direct = append(direct, fmt.Sprintf("• *%s*%s, %s: _%s_", name, count, waiting, string(preview)))
+	}
+
+	noun := "people"
+	if len(people) == 1 {
+		noun = "person"
+	}
+	sections := []string{fmt.Sprintf("📬 *You owe replies to %d %s*", len(people), noun)}
+	if len(direct) > 0 {
+		sections = append(sections, "*Direct messages*\n"+strings.Join(direct, "\n"))
+	}
+	if len(mentions) > 0 {
+		sections = append(sections, "*Mentions in groups*\n"+strings.Join(mentions, "\n"))
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+// formatWaitingTime renders how long a message has waited, e.g. "waiting 3 days"
+func formatWaitingTime(waited time.Duration) string {
+	switch hours := int(waited.Hours()); {
+	case hours < 1:
+		return "waiting less than an hour"
+	case hours == 1:
+		return "waiting 1 hour"
+	case hours < 48:
+		return fmt.Sprintf("waiting %d hours", hours)
+	default:
+		return fmt.Sprintf("waiting %d days", hours/24)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// The messages I owe a reply to: direct messages that came after my last reply in their chat, and messages in
+// groups that @mention me or quote one of my messages, with nothing from me in the group since. Only replies I
+// wrote myself, or sent through the API, count; the bridge's summaries, alerts and command answers don't.
+
+const (
+	// Defaults of /api/unanswered: messages waiting for more than a day, from the last week
+	defaultUnansweredHours = 24
+	defaultUnansweredDays  = 7
+
+	maxUnansweredDays = 90
+)
+
+// unansweredReplyCondition matches my own messages that count as a reply
+const unansweredReplyCondition = `mine.is_from_me = 1 AND COALESCE(mine.origin, '') IN ('', '` + messageOriginBridgeAPI + `')`
+
+// UnansweredChat is a direct chat, or a group member mentioning me, waiting for my reply
+type UnansweredChat struct {
+	Kind       string    `json:"kind"` // "direct" or "mention"
+	ChatJID    string    `json:"chat_jid"`
+	ChatName   string    `json:"chat_name,omitempty"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name,omitempty"`
+	Count      int       `json:"count"` // messages waiting
+	Since      time.Time `json:"since"` // when the oldest of them arrived
+	MessageID  string    `json:"message_id"`
+	Content    string    `json:"content"` // of the latest of them
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// unansweredMessage is a message found by the unanswered queries
+type unansweredMessage struct {
+	id, chatJID, sender, content, mediaType, quotedSender string
+	timestamp                                             time.Time
+}
+
+// ownUsers returns the user parts of this account's phone number JID and LID, or nil before login
+func ownUsers(client *whatsmeow.Client) []string {
+	if client == nil || client.Store.ID == nil {
+		return nil
+	}
+	users := []string{client.Store.ID.User}
+	if !client.Store.LID.IsEmpty() {
+		users = append(users, client.Store.LID.User)
+	}
+	return users
+}
+
+// ownMentionPattern matches an @mention of one of my identifiers in message text
+func ownMentionPattern(users []string) *regexp.Regexp {
+	quoted := make([]string, len(users))
+	for i, user := range users {
+		quoted[i] = regexp.QuoteMeta(user)
+	}
+	return regexp.MustCompile(`@(?:` + strings.Join(quoted, "|") + `)(?:$|\D)`)
+}
+
+// queryUnanswered runs one of the unanswered queries
+func (store *MessageStore) queryUnanswered(db *sql.DB, query string, args ...interface{}) ([]unansweredMessage, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %v", err)
+	}
+	defer rows.Close()
+
+	var messages []unansweredMessage
+	for rows.Next() {
+		var msg unansweredMessage
+		if err := rows.Scan(&msg.id, &msg.chatJID, &msg.sender, &msg.content, &msg.mediaType, &msg.quotedSender, &msg.timestamp); err != nil {
+			return nil, err
+		}
+		msg.content = store.open(msg.content)
+		if msg.content == "" && msg.mediaType != "" {
+			msg.content = "[" + msg.mediaType + "]"
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// UnansweredChats returns who waits for my reply since before the cutoff, looking at the messages since after,
+// longest waiting first. users are this account's identifiers (see ownUsers).
+func (store *MessageStore) UnansweredChats(users []string, after, cutoff time.Time) ([]UnansweredChat, error) {
+	if len(users) == 0 {
+		return nil, fmt.Errorf("not logged in")
+	}
+	muted := make(map[string]bool)
+	rows, err := store.db.Query("SELECT chat_jid FROM chat_settings WHERE muted")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat settings: %v", err)
+	}
+	for rows.Next() {
+		var chatJID string
+		if err := rows.Scan(&chatJID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		muted[chatJID] = true
+	}
+	rows.Close()
+
+	// Direct messages after my last reply in their chat
+	direct, err := store.queryUnanswered(store.dbFor(users[0]+"@"+types.DefaultUserServer), `
+		SELECT m.id, m.chat_jid, m.sender, COALESCE(m.content, ''), COALESCE(m.media_type, ''), '', m.timestamp
+		FROM messages m
+		WHERE m.chat_jid NOT LIKE '%@g.us' AND m.chat_jid NOT LIKE '%@broadcast' AND m.chat_jid NOT LIKE '%@newsletter'
+			AND NOT COALESCE(m.is_from_me, 0) AND COALESCE(m.message_type, '') = '' AND m.deleted_at IS NULL AND m.timestamp >= ?
+			AND NOT EXISTS (SELECT 1 FROM messages mine WHERE mine.chat_jid = m.chat_jid AND mine.timestamp >= m.timestamp AND `+unansweredReplyCondition+`)
+		ORDER BY m.timestamp
+	`, after)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group messages that may mention or quote me, with nothing from me in the group since
+	conditions := make([]string, 0, 2*len(users))
+	args := []interface{}{after}
+	for _, user := range users {
+		conditions = append(conditions, "m.content LIKE ?", "t.reply_to_sender LIKE ?")
+		args = append(args, "%@"+user+"%", user+"@%")
+	}
+	mentions, err := store.queryUnanswered(store.db, `
+		SELECT m.id, m.chat_jid, m.sender, COALESCE(m.content, ''), COALESCE(m.media_type, ''), COALESCE(t.reply_to_sender, ''), m.timestamp
+		FROM messages m
+		LEFT JOIN message_threads t ON t.message_id = m.id AND t.chat_jid = m.chat_jid
+		WHERE m.chat_jid LIKE '%@g.us' AND NOT COALESCE(m.is_from_me, 0) AND COALESCE(m.message_type, '') = ''
+			AND m.deleted_at IS NULL AND m.timestamp >= ? AND (`+strings.Join(conditions, " OR ")+`)
+			AND NOT EXISTS (SELECT 1 FROM messages mine WHERE mine.chat_jid = m.chat_jid AND mine.timestamp >= m.timestamp AND `+unansweredReplyCondition+`)
+		ORDER BY m.timestamp
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	own := make(map[string]bool)
+	for _, user := range users {
+		own[user] = true
+	}
+	pattern := ownMentionPattern(users)
+	byKey := make(map[string]*UnansweredChat)
+	var chats []*UnansweredChat
+	add := func(kind string, msg unansweredMessage) {
+		key := msg.chatJID
+		if kind == "mention" {
+			// Per member in groups, as each of them waits for me
+			key += " " + msg.sender
+		}
+		chat, ok := byKey[key]
+		if !ok {
+			chat = &UnansweredChat{Kind: kind, ChatJID: msg.chatJID, Sender: msg.sender, Since: msg.timestamp}
+			byKey[key] = chat
+			chats = append(chats, chat)
+		}
+		chat.Count++
+		chat.MessageID, chat.Content, chat.Timestamp = msg.id, msg.content, msg.timestamp
+	}
+	for _, msg := range direct {
+		if !muted[msg.chatJID] && !own[strings.Split(msg.chatJID, "@")[0]] {
+			add("direct", msg)
+		}
+	}
+	for _, msg := range mentions {
+		quoted := strings.Split(msg.quotedSender, "@")[0]
+		if !muted[msg.chatJID] && (own[quoted] || pattern.MatchString(msg.content)) {
+			add("mention", msg)
+		}
+	}
+
+	names := make(map[string]string)
+	unanswered := []UnansweredChat{}
+	for _, chat := range chats {
+		if chat.Since.After(cutoff) {
+			continue
+		}
+		store.db.QueryRow("SELECT COALESCE(name, '') FROM chats WHERE jid = ?", chat.ChatJID).Scan(&chat.ChatName)
+		name, ok := names[chat.Sender]
+		if !ok {
+			name = lookupSenderName(store.db, chat.Sender)
+			names[chat.Sender] = name
+		}
+		chat.SenderName = name
+		unanswered = append(unanswered, *chat)
+	}
+	sort.SliceStable(unanswered, func(i, j int) bool { return unanswered[i].Since.Before(unanswered[j].Since) })
+	return unanswered, nil
+}
+
+// handleUnansweredAPI lists the direct messages and mentions waiting for my reply for more than hours (default
+// 24), from the last days (default 7)
+func handleUnansweredAPI(client *whatsmeow.Client, messageStore *MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		hours, days := defaultUnansweredHours, defaultUnansweredDays
+		if value := r.URL.Query().Get("hours"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 || parsed > 24*maxUnansweredDays {
+				http.Error(w, fmt.Sprintf("hours must be between 0 and %d", 24*maxUnansweredDays), http.StatusBadRequest)
+				return
+			}
+			hours = parsed
+		}
+		if value := r.URL.Query().Get("days"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > maxUnansweredDays {
+				http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxUnansweredDays), http.StatusBadRequest)
+				return
+			}
+			days = parsed
+		}
+		if hours >= 24*days {
+			http.Error(w, "hours must be less than the days covered", http.StatusBadRequest)
+			return
+		}
+
+		users := ownUsers(client)
+		if len(users) == 0 {
+			http.Error(w, "Not logged in", http.StatusServiceUnavailable)
+			return
+		}
+		now := time.Now()
+		chats, err := messageStore.UnansweredChats(users, now.AddDate(0, 0, -days), now.Add(-time.Duration(hours)*time.Hour))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		allowed := chats[:0]
+		for _, chat := range chats {
+			if apiRequestAllowsChat(r, chat.ChatJID) {
+				allowed = append(allowed, chat)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"hours": hours, "days": days, "chats": allowed})
+	}
+}
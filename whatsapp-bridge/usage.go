@@ -0,0 +1,207 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsageUsage()
+		os.Exit(1)
+	}
+
+	// Open SQLite database for messages
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open message database: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	command, args := os.Args[1], os.Args[2:]
+	switch command {
+	case "report":
+		err = runUsageReport(db, args)
+	case "queue":
+		err = runUsageQueue(db)
+	case "json":
+		err = runUsageJSON(db, args)
+	case "help", "--help", "-h":
+		printUsageUsage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
+		printUsageUsage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printUsageUsage() {
+	fmt.Println(`LLM usage
+
+USAGE:
+    usage report [--period daily|monthly] [--days N] [--by task|group|provider]
+    usage queue
+    usage json [--days N]
+
+Every LLM call's tokens, duration, cost, task type and group are recorded in the llm_usage table.
+Costs come from Claude Code, or are estimated from list prices for the Anthropic API (local models cost 0).
+The queue command shows the calls running and waiting in the queue shared by every process
+(limits: LLM_MAX_IN_FLIGHT, LLM_MAX_REQUESTS_PER_MINUTE).
+The json command shows how often JSON answers (topic segmentation, state of play) were valid,
+needed repair, or failed, per task type.`)
+}
+
+func runUsageQueue(db *sql.DB) error {
+	tickets, err := listLLMQueue(db)
+	if err != nil {
+		return err
+	}
+	maxInFlight, perMinute := getLLMQueueLimits()
+	fmt.Printf("Limits: %s in flight, %s per minute\n", formatLLMQueueLimit(maxInFlight), formatLLMQueueLimit(perMinute))
+	if len(tickets) == 0 {
+		fmt.Println("No LLM calls running or waiting")
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "STATE\tSINCE\tPID\tTASK\tGROUP")
+	for _, ticket := range tickets {
+		state, since := "waiting", ticket.EnqueuedAt
+		if ticket.Running {
+			state, since = "running", ticket.StartedAt
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%s\t%s\n", state, time.Since(since).Round(time.Second), ticket.PID, ticket.Task, ticket.GroupJID)
+	}
+	return writer.Flush()
+}
+
+// formatLLMQueueLimit renders a queue limit, where 0 means unlimited
+func formatLLMQueueLimit(limit int) string {
+	if limit == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(limit)
+}
+
+func runUsageJSON(db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("json", flag.ExitOnError)
+	days := flags.Int("days", 30, "How many days back to report")
+	flags.Parse(args)
+
+	aggregates, err := aggregateLLMJSONParses(db, time.Now().AddDate(0, 0, -*days))
+	if err != nil {
+		return err
+	}
+	if len(aggregates) == 0 {
+		fmt.Printf("No JSON answers parsed in the last %d days\n", *days)
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "TASK\tPARSED\tVALID\tREPAIRED\tFAILED\tSCHEMA MISMATCH\tREPAIR RATE\tFIXES")
+	for _, aggregate := range aggregates {
+		task := aggregate.Task
+		if task == "" {
+			task = "-"
+		}
+
+		fixNames := make([]string, 0, len(aggregate.Fixes))
+		for fix := range aggregate.Fixes {
+			fixNames = append(fixNames, fix)
+		}
+		sort.Slice(fixNames, func(i, j int) bool {
+			return aggregate.Fixes[fixNames[i]] > aggregate.Fixes[fixNames[j]] ||
+				(aggregate.Fixes[fixNames[i]] == aggregate.Fixes[fixNames[j]] && fixNames[i] < fixNames[j])
+		})
+		var fixes []string
+		for _, fix := range fixNames {
+			fixes = append(fixes, fmt.Sprintf("%s %d", fix, aggregate.Fixes[fix]))
+		}
+
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%d\t%d\t%d\t%.1f%%\t%s\n", task, aggregate.Parses, aggregate.Valid,
+			aggregate.Repaired, aggregate.Failed, aggregate.SchemaMismatch,
+			100*float64(aggregate.Repaired)/float64(aggregate.Parses), strings.Join(fixes, ", "))
+	}
+	return writer.Flush()
+}
+
+func runUsageReport(db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	period := flags.String("period", "daily", "Aggregate per day (daily) or per month (monthly)")
+	days := flags.Int("days", 0, "How many days back to report (default 30 for daily, 365 for monthly)")
+	by := flags.String("by", "", "Also break down by task, group or provider")
+	flags.Parse(args)
+
+	if *period != "daily" && *period != "monthly" {
+		return fmt.Errorf("--period must be daily or monthly")
+	}
+	if *days <= 0 {
+		*days = 30
+		if *period == "monthly" {
+			*days = 365
+		}
+	}
+
+	aggregates, err := aggregateLLMUsage(db, *period, *by, time.Now().AddDate(0, 0, -*days))
+	if err != nil {
+		return err
+	}
+	if len(aggregates) == 0 {
+		fmt.Printf("No LLM calls recorded in the last %d days\n", *days)
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := "PERIOD\t"
+	if *by != "" {
+		header += "KEY\t"
+	}
+	fmt.Fprintln(writer, header+"CALLS\tFAILED\tINPUT\tOUTPUT\tCACHE WRITE\tCACHE READ\tAVG TIME\tCOST (USD)\t")
+
+	var total LLMUsageAggregate
+	for _, aggregate := range aggregates {
+		row := aggregate.Period + "\t"
+		if *by != "" {
+			key := aggregate.Key
+			if key == "" {
+				key = "-"
+			}
+			row += key + "\t"
+		}
+		fmt.Fprintf(writer, "%s%d\t%d\t%d\t%d\t%d\t%d\t%.1fs\t%.4f\t\n", row,
+			aggregate.Calls, aggregate.Failures, aggregate.InputTokens, aggregate.OutputTokens,
+			aggregate.CacheCreationTokens, aggregate.CacheReadTokens, aggregate.AvgDurationMs/1000, aggregate.CostUSD)
+
+		total.AvgDurationMs += aggregate.AvgDurationMs * float64(aggregate.Calls)
+		total.Calls += aggregate.Calls
+		total.Failures += aggregate.Failures
+		total.InputTokens += aggregate.InputTokens
+		total.OutputTokens += aggregate.OutputTokens
+		total.CacheCreationTokens += aggregate.CacheCreationTokens
+		total.CacheReadTokens += aggregate.CacheReadTokens
+		total.CostUSD += aggregate.CostUSD
+	}
+
+	row := "TOTAL\t"
+	if *by != "" {
+		row += "\t"
+	}
+	fmt.Fprintf(writer, "%s%d\t%d\t%d\t%d\t%d\t%d\t%.1fs\t%.4f\t\n", row,
+		total.Calls, total.Failures, total.InputTokens, total.OutputTokens,
+		total.CacheCreationTokens, total.CacheReadTokens, total.AvgDurationMs/float64(total.Calls)/1000, total.CostUSD)
+	return writer.Flush()
+}
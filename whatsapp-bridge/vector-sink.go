@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteVectorSink stores episodes with their embeddings in a local SQLite database,
+// for users who don't run Graphiti
+type SQLiteVectorSink struct {
+	db *sql.DB
+}
+
+// NewSQLiteVectorSink opens (and creates if needed) the vector store database
+func NewSQLiteVectorSink() (*SQLiteVectorSink, error) {
+	db, err := sql.Open("sqlite3", "file:"+getVectorDBPath()+"?_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vector database: %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS knowledge_vectors (
+			uuid TEXT PRIMARY KEY,
+			namespace TEXT,
+			group_jid TEXT,
+			date TEXT,
+			topic TEXT,
+			name TEXT,
+			body TEXT,
+			embedding BLOB,
+			model TEXT,
+			created_at TIMESTAMP
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_knowledge_vectors_namespace ON knowledge_vectors (namespace);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create vector table: %v", err)
+	}
+
+	return &SQLiteVectorSink{db: db}, nil
+}
+
+// Name returns the sink name
+func (sink *SQLiteVectorSink) Name() string {
+	return knowledgeSinkSQLiteVector
+}
+
+// AddEpisode embeds the episode text and stores it
+func (sink *SQLiteVectorSink) AddEpisode(ctx context.Context, episode KnowledgeEpisode) error {
+	// Embed the topic together with the conversation so short episodes still carry their subject
+	text := fmt.Sprintf("%s (%s, %s)\n%s", episode.Topic, episode.GroupName, episode.Date, episode.Body)
+	embedding, err := createEmbedding(ctx, text)
+	if err != nil {
+		return fmt.Errorf("failed to create embedding: %v", err)
+	}
+
+	_, err = sink.db.Exec(
+		`INSERT OR REPLACE INTO knowledge_vectors (uuid, namespace, group_jid, date, topic, name, body, embedding, model, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		episode.UUID, episode.Namespace, episode.GroupJID, episode.Date, episode.Topic, episode.Name, episode.Body,
+		encodeEmbedding(embedding), getEmbeddingModel(), time.Now(),
+	)
+	return err
+}
+
+// DeleteEpisode removes a stored episode
+func (sink *SQLiteVectorSink) DeleteEpisode(uuid string) error {
+	_, err := sink.db.Exec("DELETE FROM knowledge_vectors WHERE uuid = ?", uuid)
+	return err
+}
+
+// Search returns the episodes most similar to a query, optionally restricted to one namespace
+func (sink *SQLiteVectorSink) Search(ctx context.Context, query, namespace string, limit int) ([]VectorSearchResult, error) {
+	condition, args := "1", []interface{}{}
+	if namespace != "" {
+		condition, args = "namespace = ?", []interface{}{namespace}
+	}
+	return searchVectorIndex(ctx, sink.db, query, condition, args, limit)
+}
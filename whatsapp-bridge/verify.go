@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// GraphitiEpisode is an episode as returned by the Graphiti /episodes/{group_id} endpoint
+type GraphitiEpisode struct {
+	UUID    string `json:"uuid"`
+	Name    string `json:"name"`
+	GroupID string `json:"group_id"`
+}
+
+// verifyGap is a date/group with episodes recorded locally that are missing from Graphiti
+type verifyGap struct {
+	GroupJID string
+	Date     string
+	Recorded int
+	Missing  []EpisodeRecord
+}
+
+var (
+	verifyGroupJID  = flag.String("group-jid", "", "Only verify this WhatsApp group JID (defaults to every group with recorded episodes)")
+	verifyStartDate = flag.String("start-date", "", "Start date in YYYY-MM-DD format (defaults to the first recorded episode)")
+	verifyEndDate   = flag.String("end-date", "", "End date in YYYY-MM-DD format (defaults to today)")
+	verifyLastN     = flag.Int("last-n", 10000, "Maximum number of episodes to fetch from Graphiti per namespace")
+)
+
+func main() {
+	flag.Parse()
+
+	logger := newLogger("Verify", "INFO")
+
+	startDate := *verifyStartDate
+	if startDate == "" {
+		startDate = "0000-00-00"
+	}
+	endDate := *verifyEndDate
+	if endDate == "" {
+		endDate = time.Now().Format("2006-01-02")
+	}
+
+	// Open SQLite database for messages
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		logger.Errorf("Failed to open message database: %v", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	if err := ensureEpisodeTables(db); err != nil {
+		logger.Errorf("Failed to create episode table: %v", err)
+		os.Exit(1)
+	}
+
+	groupJIDs := []string{*verifyGroupJID}
+	if *verifyGroupJID == "" {
+		groupJIDs, err = listEpisodeGroups(db)
+		if err != nil {
+			logger.Errorf("Failed to list groups: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	// Graphiti episodes are fetched once per namespace
+	graphitiEpisodes := make(map[string]map[string]bool)
+
+	var gaps []verifyGap
+	totalRecorded, totalMissing := 0, 0
+	for _, groupJID := range groupJIDs {
+		episodes, err := listEpisodes(db, groupJID, startDate, endDate)
+		if err != nil {
+			logger.Errorf("Failed to list episodes for %s: %v", groupJID, err)
+			os.Exit(1)
+		}
+
+		byDate := make(map[string]*verifyGap)
+		for _, episode := range episodes {
+			// Only Graphiti episodes can be verified against Graphiti
+			if episode.Sink != knowledgeSinkGraphiti {
+				continue
+			}
+
+			known, ok := graphitiEpisodes[episode.GraphitiGroupID]
+			if !ok {
+				known, err = fetchGraphitiEpisodeKeys(episode.GraphitiGroupID, *verifyLastN)
+				if err != nil {
+					logger.Errorf("Failed to fetch Graphiti episodes for %s: %v", episode.GraphitiGroupID, err)
+					os.Exit(1)
+				}
+				graphitiEpisodes[episode.GraphitiGroupID] = known
+			}
+
+			gap, ok := byDate[episode.Date]
+			if !ok {
+				gap = &verifyGap{GroupJID: groupJID, Date: episode.Date}
+				byDate[episode.Date] = gap
+			}
+			gap.Recorded++
+			totalRecorded++
+
+			// Episodes are matched on UUID, falling back to the name in case Graphiti assigned its own UUID
+			if !known[episode.UUID] && !known[episode.Name] {
+				gap.Missing = append(gap.Missing, episode)
+				totalMissing++
+			}
+		}
+
+		for _, gap := range byDate {
+			if len(gap.Missing) > 0 {
+				gaps = append(gaps, *gap)
+			}
+		}
+	}
+
+	sort.Slice(gaps, func(i, j int) bool {
+		if gaps[i].GroupJID != gaps[j].GroupJID {
+			return gaps[i].GroupJID < gaps[j].GroupJID
+		}
+		return gaps[i].Date < gaps[j].Date
+	})
+
+	for _, gap := range gaps {
+		fmt.Printf("%s  %s  %d/%d episodes missing from Graphiti\n", gap.Date, gap.GroupJID, len(gap.Missing), gap.Recorded)
+		for _, episode := range gap.Missing {
+			fmt.Printf("    %s  %s\n", episode.UUID, episode.Name)
+		}
+	}
+
+	logger.Infof("Verified %d episodes in %d groups: %d missing on %d dates", totalRecorded, len(groupJIDs), totalMissing, len(gaps))
+
+	if len(gaps) > 0 {
+		logger.Warnf("Re-ingest the affected dates with: ./reingest --group-jid <jid> --start-date <date>")
+		os.Exit(1)
+	}
+}
+
+// listEpisodeGroups returns every group JID with recorded episodes
+func listEpisodeGroups(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT DISTINCT group_jid FROM graphiti_episodes WHERE group_jid IS NOT NULL AND group_jid != '' ORDER BY group_jid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groupJIDs []string
+	for rows.Next() {
+		var groupJID string
+		if err := rows.Scan(&groupJID); err != nil {
+			return nil, err
+		}
+		groupJIDs = append(groupJIDs, groupJID)
+	}
+	return groupJIDs, nil
+}
+
+// fetchGraphitiEpisodeKeys returns the UUIDs and names of the most recent episodes in a Graphiti namespace
+func fetchGraphitiEpisodeKeys(groupID string, lastN int) (map[string]bool, error) {
+	var episodes []GraphitiEpisode
+	path := fmt.Sprintf("/episodes/%s?last_n=%d", url.PathEscape(groupID), lastN)
+	if err := callGraphitiAPI(http.MethodGet, path, nil, &episodes); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool)
+	for _, episode := range episodes {
+		keys[episode.UUID] = true
+		if name := strings.TrimSpace(episode.Name); name != "" {
+			keys[name] = true
+		}
+	}
+	return keys, nil
+}
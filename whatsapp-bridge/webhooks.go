@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const (
+	webhookPollInterval = 2 * time.Second
+	webhookTimeout      = 10 * time.Second
+	webhookBatchSize    = 50
+
+	// Delivered and abandoned deliveries are kept this long for inspection
+	webhookRetention = 7 * 24 * time.Hour
+)
+
+// webhookRetryDelays are the waits before each retry of a failed delivery; after the last one it is abandoned
+var webhookRetryDelays = []time.Duration{
+	5 * time.Second, 30 * time.Second, 2 * time.Minute, 10 * time.Minute, 30 * time.Minute, 2 * time.Hour,
+}
+
+// WebhookDispatcher queues message events for every matching webhook in messages.db and delivers them,
+// so deliveries that fail or are interrupted by a restart are retried. Besides the config.json webhooks, a chat's
+// messages go to the webhook_targets of its chat settings.
+type WebhookDispatcher struct {
+	db       *sql.DB
+	webhooks map[string]*WebhookConfig // by URL
+	chats    map[string]map[string]bool
+	logger   waLog.Logger
+}
+
+// ensureWebhookTable creates the webhook delivery queue if it doesn't exist
+func ensureWebhookTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			message_id TEXT DEFAULT '',
+			chat_jid TEXT DEFAULT '',
+			payload TEXT NOT NULL,
+			attempts INTEGER DEFAULT 0,
+			next_attempt_at TIMESTAMP,
+			last_error TEXT DEFAULT '',
+			created_at TIMESTAMP,
+			delivered_at TIMESTAMP,
+			abandoned_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_pending ON webhook_deliveries(delivered_at, abandoned_at, next_attempt_at);
+	`)
+	return err
+}
+
+// startWebhooks starts delivering stored messages to the configured webhooks. It runs without any too, since
+// chats can be given webhook targets while the bridge is up.
+func startWebhooks(db *sql.DB, logger waLog.Logger) error {
+	config, err := loadBridgeConfig()
+	if err != nil {
+		return err
+	}
+	if err := ensureWebhookTable(db); err != nil {
+		return fmt.Errorf("failed to create webhook table: %v", err)
+	}
+
+	dispatcher := &WebhookDispatcher{
+		db:       db,
+		webhooks: make(map[string]*WebhookConfig),
+		chats:    make(map[string]map[string]bool),
+		logger:   logger,
+	}
+	for i := range config.Webhooks {
+		webhook := &config.Webhooks[i]
+		if webhook.URL == "" {
+			continue
+		}
+		if webhook.Secret == "" {
+			webhook.Secret = os.Getenv("WEBHOOK_SECRET")
+		}
+		if len(webhook.Chats) > 0 {
+			chats := make(map[string]bool)
+			for _, chat := range webhook.Chats {
+				jid, err := resolveChatReference(chat)
+				if err != nil {
+					logger.Warnf("Webhook %s: %v", webhook.URL, err)
+					continue
+				}
+				chats[jid] = true
+			}
+			dispatcher.chats[webhook.URL] = chats
+		}
+		dispatcher.webhooks[webhook.URL] = webhook
+		logger.Infof("Delivering messages to webhook %s", webhook.URL)
+	}
+
+	events := eventHub.Subscribe()
+	go func() {
+		for event := range events {
+			dispatcher.enqueue(event)
+		}
+	}()
+	go dispatcher.run()
+	return nil
+}
+
+// resolveChatReference returns the JID of a chat given as JID, phone number or group name
+func resolveChatReference(chat string) (string, error) {
+	chat = strings.TrimSpace(chat)
+	if strings.Trim(chat, "+0123456789 -()") == "" {
+		return normalizePhoneRecipient(chat) + "@s.whatsapp.net", nil
+	}
+	return resolveGroupReference(chat)
+}
+
+// matches reports whether a webhook wants an event
+func (dispatcher *WebhookDispatcher) matches(webhook *WebhookConfig, event StreamEvent) bool {
+	if event.IsFromMe && !webhook.IncludeFromMe {
+		return false
+	}
+	chats, filtered := dispatcher.chats[webhook.URL]
+	return !filtered || chats[event.ChatJID]
+}
+
+// chatWebhook returns the webhook of a chat settings target: the configured one with that URL, if any, or
+// one signed with WEBHOOK_SECRET that leaves out my own messages
+func (dispatcher *WebhookDispatcher) chatWebhook(url string) *WebhookConfig {
+	if webhook, ok := dispatcher.webhooks[url]; ok {
+		return webhook
+	}
+	return &WebhookConfig{URL: url, Secret: os.Getenv("WEBHOOK_SECRET")}
+}
+
+// enqueue queues an event for every webhook it matches, and for its chat's webhook targets; a muted chat's
+// events aren't delivered anywhere
+func (dispatcher *WebhookDispatcher) enqueue(event StreamEvent) {
+	settings, err := getChatSettings(dispatcher.db, event.ChatJID)
+	if err != nil {
+		dispatcher.logger.Warnf("Failed to read the chat settings of %s: %v", event.ChatJID, err)
+	}
+	if settings.Muted {
+		return
+	}
+	targets := make(map[string]*WebhookConfig)
+	for url, webhook := range dispatcher.webhooks {
+		if dispatcher.matches(webhook, event) {
+			targets[url] = webhook
+		}
+	}
+	for _, url := range settings.WebhookTargets {
+		if webhook := dispatcher.chatWebhook(url); !event.IsFromMe || webhook.IncludeFromMe {
+			targets[url] = webhook
+		}
+	}
+
+	var payload []byte
+	for url := range targets {
+		if payload == nil {
+			if payload, err = json.Marshal(event); err != nil {
+				dispatcher.logger.Warnf("Failed to encode webhook payload: %v", err)
+				return
+			}
+		}
+		now := time.Now()
+		_, err = dispatcher.db.Exec(`
+			INSERT INTO webhook_deliveries (url, message_id, chat_jid, payload, next_attempt_at, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, url, event.ID, event.ChatJID, string(payload), now, now)
+		if err != nil {
+			dispatcher.logger.Warnf("Failed to queue webhook delivery to %s: %v", url, err)
+		}
+	}
+}
+
+// run delivers due deliveries until the bridge exits
+func (dispatcher *WebhookDispatcher) run() {
+	lastPrune := time.Time{}
+	for {
+		if err := dispatcher.deliverDue(); err != nil {
+			dispatcher.logger.Warnf("Webhook delivery failed: %v", err)
+		}
+		if time.Since(lastPrune) > time.Hour {
+			cutoff := time.Now().Add(-webhookRetention)
+			dispatcher.db.Exec("DELETE FROM webhook_deliveries WHERE delivered_at < ? OR abandoned_at < ?", cutoff, cutoff)
+			lastPrune = time.Now()
+		}
+		time.Sleep(webhookPollInterval)
+	}
+}
+
+// webhookDelivery is a queued delivery
+type webhookDelivery struct {
+	ID       int64
+	URL      string
+	Payload  string
+	Attempts int
+}
+
+// deliverDue attempts every delivery whose next attempt is due, oldest first
+func (dispatcher *WebhookDispatcher) deliverDue() error {
+	rows, err := dispatcher.db.Query(`
+		SELECT id, url, payload, attempts FROM webhook_deliveries
+		WHERE delivered_at IS NULL AND abandoned_at IS NULL AND next_attempt_at <= ?
+		ORDER BY id
+		LIMIT ?
+	`, time.Now(), webhookBatchSize)
+	if err != nil {
+		return err
+	}
+	var deliveries []webhookDelivery
+	for rows.Next() {
+		var delivery webhookDelivery
+		if err := rows.Scan(&delivery.ID, &delivery.URL, &delivery.Payload, &delivery.Attempts); err != nil {
+			rows.Close()
+			return err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	rows.Close()
+
+	var chatTargets map[string]bool
+	for _, delivery := range deliveries {
+		webhook, ok := dispatcher.webhooks[delivery.URL]
+		if !ok {
+			if chatTargets == nil {
+				if chatTargets, err = dispatcher.chatWebhookTargets(); err != nil {
+					return err
+				}
+			}
+			if chatTargets[delivery.URL] {
+				webhook, ok = dispatcher.chatWebhook(delivery.URL), true
+			}
+		}
+		if !ok {
+			// Removed from the config and the chat settings since it was queued
+			dispatcher.db.Exec("UPDATE webhook_deliveries SET abandoned_at = ?, last_error = ? WHERE id = ?",
+				time.Now(), "webhook no longer configured", delivery.ID)
+			continue
+		}
+
+		err := postWebhook(webhook, delivery.ID, []byte(delivery.Payload))
+		attempts := delivery.Attempts + 1
+		switch {
+		case err == nil:
+			_, err = dispatcher.db.Exec("UPDATE webhook_deliveries SET delivered_at = ?, attempts = ? WHERE id = ?", time.Now(), attempts, delivery.ID)
+		case attempts > len(webhookRetryDelays):
+			dispatcher.logger.Warnf("Giving up on webhook delivery %d to %s after %d attempts: %v", delivery.ID, delivery.URL, attempts, err)
+			_, err = dispatcher.db.Exec("UPDATE webhook_deliveries SET abandoned_at = ?, attempts = ?, last_error = ? WHERE id = ?",
+				time.Now(), attempts, err.Error(), delivery.ID)
+		default:
+			delay := webhookRetryDelays[attempts-1]
+			dispatcher.logger.Warnf("Webhook delivery %d to %s failed, retrying in %s: %v", delivery.ID, delivery.URL, delay, err)
+			_, err = dispatcher.db.Exec("UPDATE webhook_deliveries SET next_attempt_at = ?, attempts = ?, last_error = ? WHERE id = ?",
+				time.Now().Add(delay), attempts, err.Error(), delivery.ID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chatWebhookTargets returns the webhook targets of every chat's settings
+func (dispatcher *WebhookDispatcher) chatWebhookTargets() (map[string]bool, error) {
+	list, err := listChatSettings(dispatcher.db)
+	if err != nil {
+		return nil, err
+	}
+	targets := make(map[string]bool)
+	for _, settings := range list {
+		for _, url := range settings.WebhookTargets {
+			targets[url] = true
+		}
+	}
+	return targets, nil
+}
+
+// signWebhookPayload returns the hex HMAC-SHA256 of "<timestamp>.<body>"
+func signWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook POSTs a payload to a webhook; any 2xx response counts as delivered
+func postWebhook(webhook *WebhookConfig, deliveryID int64, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "whatsapp-bridge-webhooks")
+	req.Header.Set("X-Webhook-Id", strconv.FormatInt(deliveryID, 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if webhook.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+signWebhookPayload(webhook.Secret, timestamp, body))
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	digestSendTo = flag.String("send-to", os.Getenv("WEEKLY_DIGEST_SEND_TO"), "Recipient JID or \"self\" (defaults to WEEKLY_DIGEST_SEND_TO, then self)")
+	digestDryRun = flag.Bool("dry-run", false, "Print the digest instead of sending it")
+)
+
+func main() {
+	flag.Parse()
+
+	logger := newLogger("WeeklyDigest", "INFO")
+	logger.Infof("Starting weekly digest...")
+
+	if exitIfSafeMode(logger) {
+		return
+	}
+
+	timezone := os.Getenv("DAILY_SUMMARY_TIMEZONE")
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		logger.Errorf("Failed to load timezone %s: %v", timezone, err)
+		loc = time.UTC
+	}
+
+	maxGroups := 20
+	if value, err := strconv.Atoi(os.Getenv("WEEKLY_DIGEST_MAX_GROUPS")); err == nil && value > 0 {
+		maxGroups = value
+	}
+
+	db, err := sql.Open(messagesDBDriver(), messagesDBDSN)
+	if err != nil {
+		logger.Errorf("Failed to open message database: %v", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	weekEnd := getPulseWeekEnd(loc)
+	groups, err := listActiveGroups(db, weekEnd.AddDate(0, 0, -7))
+	if err != nil {
+		logger.Errorf("Failed to list active groups: %v", err)
+		os.Exit(1)
+	}
+	if len(groups) > maxGroups {
+		logger.Infof("Limiting the digest to the %d most active of %d groups", maxGroups, len(groups))
+		groups = groups[:maxGroups]
+	}
+
+	var lines []string
+	for _, groupJID := range groups {
+		if !includeGroupInDigest(groupJID) {
+			continue
+		}
+		pulse, err := computeGroupPulse(db, groupJID, weekEnd)
+		if err != nil {
+			logger.Warnf("Failed to compute pulse of %s: %v", groupJID, err)
+			continue
+		}
+		lines = append(lines, "• "+formatPulseLine(pulse))
+	}
+
+	var sections []string
+	if len(lines) > 0 {
+		sections = append(sections, "*Group pulse* (score 0-100, vs previous week)\n"+strings.Join(lines, "\n"))
+	}
+	if reconnectEnabled() {
+		if section := buildReconnectSection(context.Background(), db, time.Now(), logger); section != "" {
+			sections = append(sections, section)
+		}
+	}
+	if section := buildSuspiciousSection(db, weekEnd.AddDate(0, 0, -7), weekEnd, logger); section != "" {
+		sections = append(sections, section)
+	}
+	if len(sections) == 0 {
+		logger.Infof("No group activity this week, nothing to send")
+		return
+	}
+
+	digest := fmt.Sprintf("📊 *Weekly digest* (%s to %s)\n\n%s",
+		weekEnd.AddDate(0, 0, -7).Format("2006-01-02"), weekEnd.AddDate(0, 0, -1).Format("2006-01-02"), strings.Join(sections, "\n\n"))
+
+	if *digestDryRun {
+		fmt.Println(digest)
+		return
+	}
+
+	sendTo := *digestSendTo
+	if sendTo == "" {
+		sendTo = "self"
+	}
+	if err := sendToRecipient(digest, sendTo, logger); err != nil {
+		logger.Errorf("Failed to send weekly digest: %v", err)
+		os.Exit(1)
+	}
+	logger.Infof("Weekly digest sent with %d groups", len(lines))
+}
+
+// includeGroupInDigest leaves out groups configured with importance 0 and muted groups
+func includeGroupInDigest(groupJID string) bool {
+	if settings, err := loadChatSettings(groupJID); err == nil && settings.Muted {
+		return false
+	}
+	config, err := loadBridgeConfig()
+	if err != nil {
+		return true
+	}
+	importance := config.getGroupConfig(groupJID).Importance
+	return importance == nil || *importance > 0
+}
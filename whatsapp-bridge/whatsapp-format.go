@@ -0,0 +1,280 @@
+package main
+
+import (
+	"html"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Output formats for WhatsApp-formatted text (*bold*, _italic_, ~strike~, ```monospace```, `code`, lists and quotes)
+const (
+	whatsAppFormatMarkdown = "markdown" // Markdown, e.g. for the LLM transcript and Markdown exports
+	whatsAppFormatHTML     = "html"     // escaped HTML for HTML exports
+	whatsAppFormatPlain    = "plain"    // markers removed
+	whatsAppFormatRaw      = "raw"      // WhatsApp's own markers, untouched
+)
+
+var (
+	whatsAppBulletPattern   = regexp.MustCompile(`^\s*[*\-•]\s+(.*)$`)
+	whatsAppNumberedPattern = regexp.MustCompile(`^\s*(\d+)[.)]\s+(.*)$`)
+	whatsAppQuotePattern    = regexp.MustCompile(`^>\s?(.*)$`)
+	whatsAppLinkPattern     = regexp.MustCompile(`^https?://\S+`)
+	markdownEscaper         = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "#", `\#`, "[", `\[`, "]", `\]`)
+)
+
+// whatsAppRenderer converts WhatsApp formatting into one output format
+type whatsAppRenderer struct {
+	format string
+	// escape protects literal Markdown characters in exports; the LLM transcript is left readable instead
+	escape bool
+}
+
+// getTranscriptFormat returns how message formatting is rendered in the transcript given to the LLM
+// (TRANSCRIPT_FORMAT: markdown (default), plain or raw)
+func getTranscriptFormat() string {
+	switch format := os.Getenv("TRANSCRIPT_FORMAT"); format {
+	case whatsAppFormatPlain, whatsAppFormatRaw:
+		return format
+	default:
+		return whatsAppFormatMarkdown
+	}
+}
+
+// renderTranscriptContent renders a message's WhatsApp formatting for the LLM transcript
+func renderTranscriptContent(text string) string {
+	return renderWhatsAppText(text, getTranscriptFormat(), false)
+}
+
+// formatTranscriptMessage prefixes a message and indents its continuation lines,
+// so multi-line messages (e.g. lists) stay attached to their sender in the transcript
+func formatTranscriptMessage(prefix, content string) string {
+	return prefix + strings.ReplaceAll(content, "\n", "\n    ")
+}
+
+// renderWhatsAppText converts WhatsApp-formatted text into the given format
+func renderWhatsAppText(text, format string, escape bool) string {
+	if format == whatsAppFormatRaw || text == "" {
+		return text
+	}
+	renderer := whatsAppRenderer{format: format, escape: escape}
+
+	// ```monospace``` can span lines, so it is split out before the line-level parsing
+	segments := strings.Split(text, "```")
+	if len(segments)%2 == 0 {
+		// Unmatched opening marker: keep it as text
+		segments[len(segments)-2] += "```" + segments[len(segments)-1]
+		segments = segments[:len(segments)-1]
+	}
+
+	var out strings.Builder
+	for i, segment := range segments {
+		if i%2 == 1 {
+			out.WriteString(renderer.monospace(segment))
+			continue
+		}
+		out.WriteString(renderer.lines(segment, i == 0))
+	}
+	return out.String()
+}
+
+// lines renders text line by line, grouping list items and quotes; the first line only starts a
+// block (list item, quote) when it starts the message
+func (renderer whatsAppRenderer) lines(text string, startsLine bool) string {
+	lines := strings.Split(text, "\n")
+	var out strings.Builder
+	openList := ""     // "ul" or "ol" while an HTML list is open
+	afterText := false // a plain HTML line needs a <br> before the next one
+
+	closeList := func() {
+		if openList != "" {
+			out.WriteString("</" + openList + ">")
+			openList = ""
+		}
+	}
+
+	for i, line := range lines {
+		atLineStart := i > 0 || startsLine
+		kind, number, content := "", "", line
+		if atLineStart {
+			if match := whatsAppBulletPattern.FindStringSubmatch(line); match != nil {
+				kind, content = "ul", match[1]
+			} else if match := whatsAppNumberedPattern.FindStringSubmatch(line); match != nil {
+				kind, number, content = "ol", match[1], match[2]
+			} else if match := whatsAppQuotePattern.FindStringSubmatch(line); match != nil {
+				kind, content = "quote", match[1]
+			}
+		}
+		rendered := renderer.inline(content)
+
+		if renderer.format != whatsAppFormatHTML {
+			if i > 0 {
+				out.WriteString("\n")
+			}
+			switch kind {
+			case "ul":
+				out.WriteString("- " + rendered)
+			case "ol":
+				out.WriteString(number + ". " + rendered)
+			case "quote":
+				out.WriteString("> " + rendered)
+			default:
+				out.WriteString(rendered)
+			}
+			continue
+		}
+
+		switch kind {
+		case "ul", "ol":
+			if openList != kind {
+				closeList()
+				out.WriteString("<" + kind + ">")
+				openList = kind
+			}
+			out.WriteString("<li>" + rendered + "</li>")
+			afterText = false
+		case "quote":
+			closeList()
+			out.WriteString("<blockquote>" + rendered + "</blockquote>")
+			afterText = false
+		default:
+			closeList()
+			if afterText {
+				out.WriteString("<br>")
+			}
+			out.WriteString(rendered)
+			afterText = true
+		}
+	}
+	closeList()
+	return out.String()
+}
+
+// inline renders *bold*, _italic_, ~strike~ and `code` within a line
+func (renderer whatsAppRenderer) inline(text string) string {
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		// Links are copied as they are, so underscores and tildes in them aren't read as formatting
+		if link := whatsAppLinkPattern.FindString(text[i:]); link != "" {
+			if renderer.format == whatsAppFormatHTML {
+				link = html.EscapeString(link)
+			}
+			out.WriteString(link)
+			i += len(link)
+			continue
+		}
+
+		marker := text[i]
+		if strings.IndexByte("*_~`", marker) >= 0 && isWhatsAppOpening(text, i) {
+			if end := findWhatsAppClosing(text, i); end > 0 {
+				inner := text[i+1 : end]
+				if marker == '`' {
+					out.WriteString(renderer.code(inner))
+				} else {
+					out.WriteString(renderer.style(marker, renderer.inline(inner)))
+				}
+				i = end + 1
+				continue
+			}
+		}
+		r, size := utf8.DecodeRuneInString(text[i:])
+		out.WriteString(renderer.text(string(r)))
+		i += size
+	}
+	return out.String()
+}
+
+// isWhatsAppOpening reports whether the marker at i can open a span: at the start or after a space or
+// punctuation, and followed by a non-space
+func isWhatsAppOpening(text string, i int) bool {
+	if i+1 >= len(text) {
+		return false
+	}
+	if next, _ := utf8.DecodeRuneInString(text[i+1:]); unicode.IsSpace(next) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	previous, _ := utf8.DecodeLastRuneInString(text[:i])
+	return unicode.IsSpace(previous) || unicode.IsPunct(previous)
+}
+
+// findWhatsAppClosing returns the index of the marker closing the span opened at i, or -1
+func findWhatsAppClosing(text string, i int) int {
+	marker := text[i]
+	for j := i + 2; j < len(text); j++ {
+		if text[j] != marker {
+			continue
+		}
+		if previous, _ := utf8.DecodeLastRuneInString(text[:j]); unicode.IsSpace(previous) {
+			continue
+		}
+		if j+1 < len(text) {
+			if next, _ := utf8.DecodeRuneInString(text[j+1:]); !unicode.IsSpace(next) && !unicode.IsPunct(next) {
+				continue
+			}
+		}
+		return j
+	}
+	return -1
+}
+
+// text renders literal text
+func (renderer whatsAppRenderer) text(text string) string {
+	switch {
+	case renderer.format == whatsAppFormatHTML:
+		return html.EscapeString(text)
+	case renderer.format == whatsAppFormatMarkdown && renderer.escape:
+		return markdownEscaper.Replace(text)
+	default:
+		return text
+	}
+}
+
+// style renders a bold, italic or strikethrough span
+func (renderer whatsAppRenderer) style(marker byte, inner string) string {
+	switch renderer.format {
+	case whatsAppFormatHTML:
+		tag := map[byte]string{'*': "strong", '_': "em", '~': "del"}[marker]
+		return "<" + tag + ">" + inner + "</" + tag + ">"
+	case whatsAppFormatMarkdown:
+		markdown := map[byte]string{'*': "**", '_': "_", '~': "~~"}[marker]
+		return markdown + inner + markdown
+	default:
+		return inner
+	}
+}
+
+// code renders `inline code`
+func (renderer whatsAppRenderer) code(inner string) string {
+	switch renderer.format {
+	case whatsAppFormatHTML:
+		return "<code>" + html.EscapeString(inner) + "</code>"
+	case whatsAppFormatMarkdown:
+		return "`" + inner + "`"
+	default:
+		return inner
+	}
+}
+
+// monospace renders a ```monospace``` span, as a block when it spans lines
+func (renderer whatsAppRenderer) monospace(inner string) string {
+	multiline := strings.Contains(inner, "\n")
+	switch renderer.format {
+	case whatsAppFormatHTML:
+		if multiline {
+			return "<pre>" + html.EscapeString(strings.Trim(inner, "\n")) + "</pre>"
+		}
+		return "<code>" + html.EscapeString(inner) + "</code>"
+	case whatsAppFormatMarkdown:
+		if multiline {
+			return "\n```\n" + strings.Trim(inner, "\n") + "\n```\n"
+		}
+		return "`" + inner + "`"
+	default:
+		return inner
+	}
+}